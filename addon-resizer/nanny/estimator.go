@@ -19,6 +19,8 @@ package nanny
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
 
 	"k8s.io/kubernetes/pkg/api/resource"
 	api "k8s.io/kubernetes/pkg/api/v1"
@@ -26,10 +28,11 @@ import (
 	log "github.com/golang/glog"
 )
 
-// Resource defines the name of a resource, the quantity, and the marginal value.
+// Resource defines the name of a resource, the quantity, and the marginal values per node and
+// per pod.
 type Resource struct {
-	Base, ExtraPerNode resource.Quantity
-	Name               api.ResourceName
+	Base, ExtraPerNode, ExtraPerPod resource.Quantity
+	Name                            api.ResourceName
 }
 
 // ResourceListPair is a pair of ResourceLists, denoting a range.
@@ -52,46 +55,67 @@ type EstimatorResult struct {
 type Estimator struct {
 	// Specification of monitored resources.
 	Resources []Resource
-	// Percentage offset defining acceptable resource range.
+	// Percentage offset defining acceptable resource range. It's applied to both the number of
+	// nodes and the number of pods, so their small fluctuations don't cause resizing.
 	AcceptanceOffset int64
 	// Percentage offset defining recommended resource range.
 	RecommendationOffset int64
 }
 
+// ScalesWithPods checks if any of the resources depends on the number of pods.
+func (e Estimator) ScalesWithPods() bool {
+	for _, r := range e.Resources {
+		if !r.ExtraPerPod.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
 func decWithPercentageOffset(value uint64, offset int64, rounder func(float64) float64) uint64 {
 	return uint64(int64(value) + int64(rounder(float64(offset)*float64(value)/100)))
 }
 
-func nodesAndOffsetToRange(numNodes uint64, offset int64, res []Resource) ResourceListPair {
+func countsAndOffsetToRange(numNodes, numPods uint64, offset int64, res []Resource) ResourceListPair {
 	numNodesMin := decWithPercentageOffset(numNodes, -offset, math.Floor)
 	numNodesMax := decWithPercentageOffset(numNodes, offset, math.Ceil)
+	numPodsMin := decWithPercentageOffset(numPods, -offset, math.Floor)
+	numPodsMax := decWithPercentageOffset(numPods, offset, math.Ceil)
 	return ResourceListPair{
-		lower: calculateResources(numNodesMin, res),
-		upper: calculateResources(numNodesMax, res),
+		lower: calculateResources(numNodesMin, numPodsMin, res),
+		upper: calculateResources(numNodesMax, numPodsMax, res),
 	}
 }
 
-func (e Estimator) scaleWithNodes(numNodes uint64) *EstimatorResult {
+func (e Estimator) scale(numNodes, numPods uint64) *EstimatorResult {
 	return &EstimatorResult{
-		RecommendedRange: nodesAndOffsetToRange(numNodes, e.RecommendationOffset, e.Resources),
-		AcceptableRange:  nodesAndOffsetToRange(numNodes, e.AcceptanceOffset, e.Resources),
+		RecommendedRange: countsAndOffsetToRange(numNodes, numPods, e.RecommendationOffset, e.Resources),
+		AcceptableRange:  countsAndOffsetToRange(numNodes, numPods, e.AcceptanceOffset, e.Resources),
 	}
 }
 
-func calculateResources(numNodes uint64, resources []Resource) api.ResourceList {
+// multiplyQuantity returns the quantity multiplied by count. Since we want to enable passing
+// values smaller than e.g. 1 millicore per node, we need to have some more hacky solution here
+// than operating on MilliValues.
+func multiplyQuantity(quantity resource.Quantity, count uint64) resource.Quantity {
+	quantityString := quantity.String()
+	suffix := strings.TrimLeft(quantityString, "0123456789.")
+	value, _ := strconv.ParseFloat(quantityString[:len(quantityString)-len(suffix)], 64)
+	// Trailing zeros are trimmed, as long fractions with binary suffixes aren't parsed precisely.
+	product := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%f", value*float64(count)), "0"), ".")
+	return resource.MustParse(product + suffix)
+}
+
+func calculateResources(numNodes, numPods uint64, resources []Resource) api.ResourceList {
 	resourceList := make(api.ResourceList)
 	for _, r := range resources {
-		// Since we want to enable passing values smaller than e.g. 1 millicore per node,
-		// we need to have some more hacky solution here than operating on MilliValues.
-		perNodeString := r.ExtraPerNode.String()
-		var perNode float64
-		read, _ := fmt.Sscanf(perNodeString, "%f", &perNode)
-		overhead := resource.MustParse(fmt.Sprintf("%f%s", perNode*float64(numNodes), perNodeString[read:]))
-
 		newRes := r.Base
-		newRes.Add(overhead)
+		newRes.Add(multiplyQuantity(r.ExtraPerNode, numNodes))
+		if !r.ExtraPerPod.IsZero() {
+			newRes.Add(multiplyQuantity(r.ExtraPerPod, numPods))
+		}
 
-		log.V(4).Infof("New requirement for resource %s with %d nodes is %s", r.Name, numNodes, newRes.String())
+		log.V(4).Infof("New requirement for resource %s with %d nodes and %d pods is %s", r.Name, numNodes, numPods, newRes.String())
 
 		resourceList[r.Name] = newRes
 	}
@@ -362,9 +362,90 @@ func TestEstimateResources(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		got := tc.e.scaleWithNodes(tc.numNodes)
+		got := tc.e.scale(tc.numNodes, 0)
 		want := &tc.estimatorResult
 		verifyRange(t, tc.lineNum, "AcceptableRange", got.AcceptableRange, want.AcceptableRange)
 		verifyRange(t, tc.lineNum, "RecommendedRange", got.RecommendedRange, want.RecommendedRange)
 	}
 }
+
+var podsEstimator = Estimator{
+	Resources: []Resource{
+		{
+			Base:         resource.MustParse("0.3"),
+			ExtraPerNode: resource.MustParse("1"),
+			ExtraPerPod:  resource.MustParse("100m"),
+			Name:         "cpu",
+		},
+		{
+			Base:         resource.MustParse("30Mi"),
+			ExtraPerNode: resource.MustParse("1Mi"),
+			ExtraPerPod:  resource.MustParse("512Ki"),
+			Name:         "memory",
+		},
+	},
+	AcceptanceOffset:     20,
+	RecommendationOffset: 10,
+}
+
+func TestEstimateResourcesWithPods(t *testing.T) {
+	if !podsEstimator.ScalesWithPods() || fullEstimator.ScalesWithPods() {
+		t.Errorf("ScalesWithPods should only be true for estimators with resources per pod")
+	}
+
+	got := podsEstimator.scale(10, 100)
+	verifyRange(t, num(), "RecommendedRange", got.RecommendedRange, ResourceListPair{
+		// 9 nodes and 90 pods.
+		lower: api.ResourceList{"cpu": resource.MustParse("18.3"), "memory": resource.MustParse("84Mi")},
+		// 11 nodes and 110 pods.
+		upper: api.ResourceList{"cpu": resource.MustParse("22.3"), "memory": resource.MustParse("96Mi")},
+	})
+	verifyRange(t, num(), "AcceptableRange", got.AcceptableRange, ResourceListPair{
+		// 8 nodes and 80 pods.
+		lower: api.ResourceList{"cpu": resource.MustParse("16.3"), "memory": resource.MustParse("78Mi")},
+		// 12 nodes and 120 pods.
+		upper: api.ResourceList{"cpu": resource.MustParse("24.3"), "memory": resource.MustParse("102Mi")},
+	})
+
+	// Many small nodes and few huge nodes running the same pods.
+	got = podsEstimator.scale(0, 100)
+	verifyResources(t, num(), "RecommendedRange (lower bound)", got.RecommendedRange.lower,
+		api.ResourceList{"cpu": resource.MustParse("9.3"), "memory": resource.MustParse("75Mi")})
+	got = podsEstimator.scale(50, 0)
+	verifyResources(t, num(), "RecommendedRange (lower bound)", got.RecommendedRange.lower,
+		api.ResourceList{"cpu": resource.MustParse("45.3"), "memory": resource.MustParse("75Mi")})
+}
+
+func TestPodCountHysteresis(t *testing.T) {
+	actual := calculateResources(10, 100, podsEstimator.Resources)
+	testCases := []struct {
+		lineNum  int
+		numNodes uint64
+		numPods  uint64
+		want     api.ResourceList
+	}{
+		{num(), 10, 100, nil},
+		{num(), 10, 115, nil},
+		{num(), 10, 85, nil},
+		{num(), 11, 90, nil},
+		// 9 nodes and 180 pods.
+		{num(), 10, 200, api.ResourceList{"cpu": resource.MustParse("27.3"), "memory": resource.MustParse("129Mi")}},
+		// 11 nodes and 22 pods.
+		{num(), 10, 20, api.ResourceList{"cpu": resource.MustParse("13.5"), "memory": resource.MustParse("52Mi")}},
+	}
+	for _, tc := range testCases {
+		got := shouldOverwriteResources(podsEstimator.scale(tc.numNodes, tc.numPods), actual, actual)
+		if tc.want == nil {
+			if got != nil {
+				t.Errorf("[test@line %d] unexpected overwrite %+v", tc.lineNum, *got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("[test@line %d] expected overwrite", tc.lineNum)
+			continue
+		}
+		verifyResources(t, tc.lineNum, "Limits", got.Limits, tc.want)
+		verifyResources(t, tc.lineNum, "Requests", got.Requests, tc.want)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	apiv1 "k8s.io/kubernetes/pkg/api/v1"
 	cache "k8s.io/kubernetes/pkg/client/cache"
 	client "k8s.io/kubernetes/pkg/client/clientset_generated/release_1_3"
+	fields "k8s.io/kubernetes/pkg/fields"
 	runtime "k8s.io/kubernetes/pkg/runtime"
 	wait "k8s.io/kubernetes/pkg/util/wait"
 	watch "k8s.io/kubernetes/pkg/watch"
@@ -37,11 +38,26 @@ type kubernetesClient struct {
 	clientset  *client.Clientset
 	nodeStore  cache.Store
 	reflector  *cache.Reflector
+	// podStore and podReflector are only set when pods are counted.
+	podStore     cache.Store
+	podReflector *cache.Reflector
 }
 
 func (k *kubernetesClient) CountNodes() (uint64, error) {
+	return countObjects(k.reflector, k.nodeStore)
+}
+
+// CountPods returns the number of pods that are not terminated, or 0 if pods are not counted.
+func (k *kubernetesClient) CountPods() (uint64, error) {
+	if k.podReflector == nil {
+		return 0, nil
+	}
+	return countObjects(k.podReflector, k.podStore)
+}
+
+func countObjects(reflector *cache.Reflector, store cache.Store) (uint64, error) {
 	err := wait.PollImmediate(time.Second, time.Minute, func() (bool, error) {
-		if k.reflector.LastSyncResourceVersion() == "" {
+		if reflector.LastSyncResourceVersion() == "" {
 			return false, nil
 		}
 		return true, nil
@@ -49,7 +65,7 @@ func (k *kubernetesClient) CountNodes() (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return uint64(len(k.nodeStore.List())), nil
+	return uint64(len(store.ListKeys())), nil
 }
 
 func (k *kubernetesClient) ContainerResources() (*apiv1.ResourceRequirements, error) {
@@ -86,8 +102,9 @@ func (k *kubernetesClient) UpdateDeployment(resources *apiv1.ResourceRequirement
 	return fmt.Errorf("Container %s was not found in the deployment %s in namespace %s.", k.container, k.deployment, k.namespace)
 }
 
-// NewKubernetesClient gives a KubernetesClient with the given dependencies.
-func NewKubernetesClient(namespace, deployment, pod, container string, clientset *client.Clientset) KubernetesClient {
+// NewKubernetesClient gives a KubernetesClient with the given dependencies. Pods in the cluster
+// are only watched if countPods is set.
+func NewKubernetesClient(namespace, deployment, pod, container string, clientset *client.Clientset, countPods bool) KubernetesClient {
 	result := &kubernetesClient{
 		namespace:  namespace,
 		deployment: deployment,
@@ -107,5 +124,24 @@ func NewKubernetesClient(namespace, deployment, pod, container string, clientset
 	}
 	result.reflector = cache.NewReflector(nodeListWatch, &apiv1.Node{}, result.nodeStore, 0)
 	result.reflector.Run()
+
+	if countPods {
+		// Only the number of pods is used, but this client can't list object metadata alone.
+		podSelector := fields.ParseSelectorOrDie("status.phase!=" + string(apiv1.PodSucceeded) +
+			",status.phase!=" + string(apiv1.PodFailed))
+		podListWatch := &cache.ListWatch{
+			ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = podSelector
+				return clientset.Core().Pods(api.NamespaceAll).List(options)
+			},
+			WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = podSelector
+				return clientset.Core().Pods(api.NamespaceAll).Watch(options)
+			},
+		}
+		result.podStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+		result.podReflector = cache.NewReflector(podListWatch, &apiv1.Pod{}, result.podStore, 0)
+		result.podReflector.Run()
+	}
 	return result
 }
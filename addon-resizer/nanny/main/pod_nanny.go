@@ -37,10 +37,13 @@ var (
 	// Flags to define the resource requirements.
 	baseCPU              = flag.String("cpu", noValue, "The base CPU resource requirement.")
 	cpuPerNode           = flag.String("extra-cpu", "0", "The amount of CPU to add per node.")
+	cpuPerPod            = flag.String("extra-cpu-per-pod", "0", "The amount of CPU to add per pod in the cluster.")
 	baseMemory           = flag.String("memory", noValue, "The base memory resource requirement.")
 	memoryPerNode        = flag.String("extra-memory", "0Mi", "The amount of memory to add per node.")
+	memoryPerPod         = flag.String("extra-memory-per-pod", "0Mi", "The amount of memory to add per pod in the cluster.")
 	baseStorage          = flag.String("storage", noValue, "The base storage resource requirement.")
 	storagePerNode       = flag.String("extra-storage", "0Gi", "The amount of storage to add per node.")
+	storagePerPod        = flag.String("extra-storage-per-pod", "0Gi", "The amount of storage to add per pod in the cluster.")
 	recommendationOffset = flag.Int("recommendation-offset", 10, "A number from range 0-100. When the dependent's resources are rewritten, they are set to the closer end of the range defined by this percentage threshold.")
 	acceptanceOffset     = flag.Int("acceptance-offset", 20, "A number from range 0-100. The dependent's resources are rewritten when they deviate from expected by a percentage that is higher than this threshold. Can't be lower than recommendation-offset.")
 	// Flags to identify the container to nanny.
@@ -77,6 +80,7 @@ func main() {
 	log.Infof("Poll period: %+v", pollPeriod)
 	log.Infof("Watching namespace: %s, pod: %s, container: %s.", *podNamespace, *podName, *containerName)
 	log.Infof("cpu: %s, extra_cpu: %s, memory: %s, extra_memory: %s, storage: %s, extra_storage: %s", *baseCPU, *cpuPerNode, *baseMemory, *memoryPerNode, *baseStorage, *storagePerNode)
+	log.Infof("extra_cpu_per_pod: %s, extra_memory_per_pod: %s, extra_storage_per_pod: %s", *cpuPerPod, *memoryPerPod, *storagePerPod)
 	log.Infof("Accepted range +/-%d%%", *acceptanceOffset)
 	log.Infof("Recommended range +/-%d%%", *recommendationOffset)
 
@@ -90,8 +94,6 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	k8s := nanny.NewKubernetesClient(*podNamespace, *deployment, *podName, *containerName, clientset)
-
 	var resources []nanny.Resource
 
 	// Monitor only the resources specified.
@@ -99,6 +101,7 @@ func main() {
 		resources = append(resources, nanny.Resource{
 			Base:         resource.MustParse(*baseCPU),
 			ExtraPerNode: resource.MustParse(*cpuPerNode),
+			ExtraPerPod:  resource.MustParse(*cpuPerPod),
 			Name:         "cpu",
 		})
 	}
@@ -107,6 +110,7 @@ func main() {
 		resources = append(resources, nanny.Resource{
 			Base:         resource.MustParse(*baseMemory),
 			ExtraPerNode: resource.MustParse(*memoryPerNode),
+			ExtraPerPod:  resource.MustParse(*memoryPerPod),
 			Name:         "memory",
 		})
 	}
@@ -115,20 +119,24 @@ func main() {
 		resources = append(resources, nanny.Resource{
 			Base:         resource.MustParse(*baseStorage),
 			ExtraPerNode: resource.MustParse(*memoryPerNode),
+			ExtraPerPod:  resource.MustParse(*storagePerPod),
 			Name:         "storage",
 		})
 	}
 
 	log.Infof("Resources: %+v", resources)
 
+	estimator := nanny.Estimator{
+		AcceptanceOffset:     int64(*acceptanceOffset),
+		RecommendationOffset: int64(*recommendationOffset),
+		Resources:            resources,
+	}
+	k8s := nanny.NewKubernetesClient(*podNamespace, *deployment, *podName, *containerName, clientset, estimator.ScalesWithPods())
+
 	// Begin nannying.
 	nanny.PollAPIServer(
 		k8s,
-		nanny.Estimator{
-			AcceptanceOffset:     int64(*acceptanceOffset),
-			RecommendationOffset: int64(*recommendationOffset),
-			Resources:            resources,
-		},
+		estimator,
 		*containerName,
 		pollPeriod)
 }
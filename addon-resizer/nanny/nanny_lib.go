@@ -70,6 +70,7 @@ func shouldOverwriteResources(estimatorResult *EstimatorResult, limits, reqs api
 // KubernetesClient is an object that performs the nanny's requisite interactions with Kubernetes.
 type KubernetesClient interface {
 	CountNodes() (uint64, error)
+	CountPods() (uint64, error)
 	ContainerResources() (*api.ResourceRequirements, error)
 	UpdateDeployment(resources *api.ResourceRequirements) error
 }
@@ -77,10 +78,10 @@ type KubernetesClient interface {
 // ResourceEstimator estimates ResourceRequirements for a given criteria. Returned value is a list
 // with acceptable values. First element on that list is the recommended one.
 type ResourceEstimator interface {
-	scaleWithNodes(numNodes uint64) *EstimatorResult
+	scale(numNodes, numPods uint64) *EstimatorResult
 }
 
-// PollAPIServer periodically counts the number of nodes, estimates the expected
+// PollAPIServer periodically counts the number of nodes and pods, estimates the expected
 // ResourceRequirements, compares them to the actual ResourceRequirements, and
 // updates the deployment with the expected ResourceRequirements if necessary.
 func PollAPIServer(k8s KubernetesClient, est ResourceEstimator, contName string, pollPeriod time.Duration) {
@@ -98,6 +99,14 @@ func PollAPIServer(k8s KubernetesClient, est ResourceEstimator, contName string,
 		}
 		log.V(4).Infof("The number of nodes is %d", num)
 
+		// Query the apiserver for the number of pods.
+		numPods, err := k8s.CountPods()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		log.V(4).Infof("The number of pods is %d", numPods)
+
 		// Query the apiserver for this pod's information.
 		resources, err := k8s.ContainerResources()
 		if err != nil {
@@ -106,7 +115,7 @@ func PollAPIServer(k8s KubernetesClient, est ResourceEstimator, contName string,
 		}
 
 		// Get the expected resource limits.
-		estimation := est.scaleWithNodes(num)
+		estimation := est.scale(num, numPods)
 
 		// If there's a difference, go ahead and set the new values.
 		overwrite := shouldOverwriteResources(estimation, resources.Limits, resources.Requests)
@@ -19,15 +19,30 @@ package aws
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/golang/glog"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
 )
 
+const (
+	// maxConcurrentDescribeRequests is the maximum number of batches of ASGs described at the same time.
+	maxConcurrentDescribeRequests = 4
+	// maxThrottlingRetries is the number of times a throttled request is retried before giving up.
+	maxThrottlingRetries = 4
+)
+
+// throttlingBackoff is the wait before the first retry of a throttled request. It doubles with every retry.
+var throttlingBackoff = 500 * time.Millisecond
+
 // autoScaling is the interface represents a specific aspect of the auto-scaling service provided by AWS SDK for use in CA
 type autoScaling interface {
 	DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+	DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error)
 	DescribeLaunchConfigurations(*autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error)
 	DescribeTags(input *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error)
 	SetDesiredCapacity(input *autoscaling.SetDesiredCapacityInput) (*autoscaling.SetDesiredCapacityOutput, error)
@@ -80,39 +95,100 @@ func (m *autoScalingWrapper) getAutoscalingGroupsByNames(names []string) ([]*aut
 		return nil, fmt.Errorf("List of ASG names was empty. Must specify at least one ASG name")
 	}
 
-	nameRefs := []*string{}
-	for _, n := range names {
-		nameRefs = append(nameRefs, aws.String(n))
-	}
-	params := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: nameRefs,
-		MaxRecords:            aws.Int64(maxRecordsReturnedByAPI),
-	}
-	description, err := m.DescribeAutoScalingGroups(params)
+	asgs, err := m.describeAutoscalingGroups(names)
 	if err != nil {
-		glog.V(4).Infof("Failed to describe ASGs : %v", err)
 		return nil, err
 	}
-	if len(description.AutoScalingGroups) < 1 {
+	if len(asgs) < 1 {
 		return nil, errors.New("No ASGs found")
 	}
 
-	asgs := description.AutoScalingGroups
-	for description.NextToken != nil {
-		description, err = m.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
-			NextToken:  description.NextToken,
-			MaxRecords: aws.Int64(maxRecordsReturnedByAPI),
+	glog.V(6).Infof("Finishing getAutoscalingGroupsByNames asgs=%v", asgs)
+
+	return asgs, nil
+}
+
+// describeAutoscalingGroups describes the given ASGs in batches of maxRecordsReturnedByAPI names, at most
+// maxConcurrentDescribeRequests batches at a time. ASGs that don't exist are not returned.
+func (m *autoScalingWrapper) describeAutoscalingGroups(names []string) ([]*autoscaling.Group, error) {
+	batches := [][]string{}
+	for len(names) > 0 {
+		size := maxRecordsReturnedByAPI
+		if len(names) < size {
+			size = len(names)
+		}
+		batches = append(batches, names[:size])
+		names = names[size:]
+	}
+
+	results := make([][]*autoscaling.Group, len(batches))
+	errs := make([]error, len(batches))
+	semaphore := make(chan struct{}, maxConcurrentDescribeRequests)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func(i int, batch []string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i], errs[i] = m.describeAutoscalingGroupsBatch(batch)
+		}(i, batch)
+	}
+	wg.Wait()
+
+	asgs := []*autoscaling.Group{}
+	for i := range batches {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		asgs = append(asgs, results[i]...)
+	}
+	return asgs, nil
+}
+
+func (m *autoScalingWrapper) describeAutoscalingGroupsBatch(names []string) ([]*autoscaling.Group, error) {
+	asgs := []*autoscaling.Group{}
+	var nextToken *string
+	for {
+		var description *autoscaling.DescribeAutoScalingGroupsOutput
+		err := retryOnThrottling("DescribeAutoScalingGroups", func() (err error) {
+			description, err = m.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+				AutoScalingGroupNames: aws.StringSlice(names),
+				MaxRecords:            aws.Int64(maxRecordsReturnedByAPI),
+				NextToken:             nextToken,
+			})
+			return err
 		})
 		if err != nil {
 			glog.V(4).Infof("Failed to describe ASGs : %v", err)
 			return nil, err
 		}
 		asgs = append(asgs, description.AutoScalingGroups...)
+		if description.NextToken == nil {
+			return asgs, nil
+		}
+		nextToken = description.NextToken
 	}
+}
 
-	glog.V(6).Infof("Finishing getAutoscalingGroupsByNames asgs=%v", asgs)
-
-	return asgs, nil
+// getAutoscalingGroupNameForInstance returns the name of the ASG the instance belongs to, or an empty
+// string if it doesn't belong to any ASG.
+func (m *autoScalingWrapper) getAutoscalingGroupNameForInstance(instanceId string) (string, error) {
+	var description *autoscaling.DescribeAutoScalingInstancesOutput
+	err := retryOnThrottling("DescribeAutoScalingInstances", func() (err error) {
+		description, err = m.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+			InstanceIds: aws.StringSlice([]string{instanceId}),
+		})
+		return err
+	})
+	if err != nil {
+		glog.V(4).Infof("Failed to describe instance %s: %v", instanceId, err)
+		return "", err
+	}
+	if len(description.AutoScalingInstances) < 1 {
+		return "", nil
+	}
+	return aws.StringValue(description.AutoScalingInstances[0].AutoScalingGroupName), nil
 }
 
 func (m *autoScalingWrapper) getAutoscalingGroupsByTags(keys []string) ([]*autoscaling.Group, error) {
@@ -130,9 +206,13 @@ func (m *autoScalingWrapper) getAutoscalingGroupsByTags(keys []string) ([]*autos
 		}
 		filters = append(filters, filter)
 	}
-	description, err := m.DescribeTags(&autoscaling.DescribeTagsInput{
-		Filters:    filters,
-		MaxRecords: aws.Int64(maxRecordsReturnedByAPI),
+	var description *autoscaling.DescribeTagsOutput
+	err := retryOnThrottling("DescribeTags", func() (err error) {
+		description, err = m.DescribeTags(&autoscaling.DescribeTagsInput{
+			Filters:    filters,
+			MaxRecords: aws.Int64(maxRecordsReturnedByAPI),
+		})
+		return err
 	})
 	if err != nil {
 		glog.V(4).Infof("Failed to describe ASG tags for keys %v : %v", keys, err)
@@ -145,9 +225,13 @@ func (m *autoScalingWrapper) getAutoscalingGroupsByTags(keys []string) ([]*autos
 	tags = append(tags, description.Tags...)
 
 	for description.NextToken != nil {
-		description, err = m.DescribeTags(&autoscaling.DescribeTagsInput{
-			NextToken:  description.NextToken,
-			MaxRecords: aws.Int64(maxRecordsReturnedByAPI),
+		nextToken := description.NextToken
+		err = retryOnThrottling("DescribeTags", func() (err error) {
+			description, err = m.DescribeTags(&autoscaling.DescribeTagsInput{
+				NextToken:  nextToken,
+				MaxRecords: aws.Int64(maxRecordsReturnedByAPI),
+			})
+			return err
 		})
 		if err != nil {
 			glog.V(4).Infof("Failed to describe ASG tags for key %v: %v", keys, err)
@@ -185,3 +269,33 @@ func (m *autoScalingWrapper) getAutoscalingGroupsByTags(keys []string) ([]*autos
 
 	return asgs, nil
 }
+
+// retryOnThrottling calls request until it succeeds, fails with an error other than throttling or
+// maxThrottlingRetries retries are used up, waiting exponentially longer between the retries.
+func retryOnThrottling(api string, request func() error) error {
+	backoff := throttlingBackoff
+	for retry := 0; ; retry++ {
+		err := request()
+		if err == nil || !isThrottlingError(err) {
+			return err
+		}
+		metrics.RegisterCloudProviderThrottledRequest("aws", api)
+		if retry == maxThrottlingRetries {
+			return err
+		}
+		glog.V(4).Infof("%s request throttled, retrying in %v", api, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isThrottlingError returns true if AWS rejected the request because of the request rate.
+func isThrottlingError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
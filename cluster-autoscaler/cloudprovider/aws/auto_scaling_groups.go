@@ -19,16 +19,34 @@ package aws
 import (
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/golang/glog"
 )
 
+const (
+	// minThrottledRefreshInterval is the time ASGs aren't described again after AWS throttled the requests.
+	// It doubles while the requests are throttled, up to maxThrottledRefreshInterval.
+	minThrottledRefreshInterval = 30 * time.Second
+	maxThrottledRefreshInterval = 10 * time.Minute
+)
+
 type autoScalingGroups struct {
 	registeredAsgs           []*asgInformation
 	instanceToAsg            map[AwsRef]*Asg
 	cacheMutex               sync.Mutex
 	instancesNotInManagedAsg map[AwsRef]struct{}
 	service                  autoScalingWrapper
+
+	// groups contains the last description of each registered ASG, by ASG name.
+	groups map[string]*autoscaling.Group
+	// lastRefresh is the time the registered ASGs were last described.
+	lastRefresh time.Time
+	// refreshInterval is the time until the registered ASGs are described again. It is 0
+	// unless AWS throttles the requests.
+	refreshInterval time.Duration
 }
 
 func newAutoScalingGroups(service autoScalingWrapper) *autoScalingGroups {
@@ -37,6 +55,7 @@ func newAutoScalingGroups(service autoScalingWrapper) *autoScalingGroups {
 		service:                  service,
 		instanceToAsg:            make(map[AwsRef]*Asg),
 		instancesNotInManagedAsg: make(map[AwsRef]struct{}),
+		groups:                   make(map[string]*autoscaling.Group),
 	}
 	return registry
 }
@@ -54,21 +73,33 @@ func (m *autoScalingGroups) Register(asg *Asg) {
 // FindForInstance returns AsgConfig of the given Instance
 func (m *autoScalingGroups) FindForInstance(instance *AwsRef) (*Asg, error) {
 	m.cacheMutex.Lock()
-	defer m.cacheMutex.Unlock()
 	if config, found := m.instanceToAsg[*instance]; found {
+		m.cacheMutex.Unlock()
 		return config, nil
 	}
 	if _, found := m.instancesNotInManagedAsg[*instance]; found {
+		m.cacheMutex.Unlock()
 		// The instance is already known to not belong to any configured ASG
-		// Skip regenerateCache so that we won't unnecessarily call DescribeAutoScalingGroups
+		// Skip looking it up so that we won't unnecessarily call AWS API
 		// See https://github.com/kubernetes/contrib/issues/2541
 		return nil, nil
 	}
-	if err := m.regenerateCache(); err != nil {
+	m.cacheMutex.Unlock()
+
+	// Look up only the new instance instead of describing all ASGs again. The lookup may wait for
+	// throttled requests to be retried, so the cache isn't locked meanwhile.
+	name, err := m.service.getAutoscalingGroupNameForInstance(instance.Name)
+	if err != nil {
 		return nil, fmt.Errorf("Error while looking for ASG for instance %+v, error: %v", *instance, err)
 	}
-	if config, found := m.instanceToAsg[*instance]; found {
-		return config, nil
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	for _, asg := range m.registeredAsgs {
+		if asg.config.Name == name {
+			m.instanceToAsg[*instance] = asg.config
+			return asg.config, nil
+		}
 	}
 	// instance does not belong to any configured ASG
 	glog.V(6).Infof("Instance %+v is not in any ASG managed by CA. CA is now memorizing the fact not to unnecessarily call AWS API afterwards trying to find the unexistent managed ASG for the instance", *instance)
@@ -76,22 +107,153 @@ func (m *autoScalingGroups) FindForInstance(instance *AwsRef) (*Asg, error) {
 	return nil, nil
 }
 
+// Refresh describes all registered ASGs, unless AWS recently throttled the requests. In that case
+// the previous descriptions are used until the refresh interval, growing with every throttled
+// refresh, passes. If the ASGs can't be described for another reason, the previous descriptions
+// are used until the next refresh. Errors are logged rather than returned, so that a failed
+// refresh doesn't stop the main loop.
+func (m *autoScalingGroups) Refresh() error {
+	m.cacheMutex.Lock()
+	now := time.Now()
+	if now.Before(m.lastRefresh.Add(m.refreshInterval)) {
+		m.cacheMutex.Unlock()
+		return nil
+	}
+	names := m.registeredNames()
+	m.cacheMutex.Unlock()
+
+	descriptions, err := m.describe(names)
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	if err == nil {
+		m.updateGroups(descriptions)
+		m.refreshInterval = 0
+		return nil
+	}
+	if len(m.groups) == 0 {
+		glog.Errorf("Failed to describe ASGs, no ASG descriptions are available yet: %v", err)
+		return nil
+	}
+	if !isThrottlingError(err) {
+		glog.Errorf("Failed to describe ASGs, using ASG descriptions from before: %v", err)
+		return nil
+	}
+	m.lastRefresh = now
+	m.refreshInterval *= 2
+	if m.refreshInterval < minThrottledRefreshInterval {
+		m.refreshInterval = minThrottledRefreshInterval
+	}
+	if m.refreshInterval > maxThrottledRefreshInterval {
+		m.refreshInterval = maxThrottledRefreshInterval
+	}
+	glog.Warningf("Describing ASGs was throttled, using ASG descriptions from before, next refresh in %v: %v", m.refreshInterval, err)
+	return nil
+}
+
+// Invalidate makes the next Refresh describe the ASGs, unless AWS throttles the requests.
+func (m *autoScalingGroups) Invalidate() {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	if m.refreshInterval == 0 {
+		m.lastRefresh = time.Time{}
+	}
+}
+
+// Get returns the last description of the ASG or nil if the ASG wasn't described yet.
+func (m *autoScalingGroups) Get(name string) *autoscaling.Group {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	return m.groups[name]
+}
+
+// Set replaces the description of the ASG, e.g. after it was described outside of Refresh.
+func (m *autoScalingGroups) Set(group *autoscaling.Group) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	if m.groups != nil {
+		m.groups[aws.StringValue(group.AutoScalingGroupName)] = group
+	}
+}
+
+// SetDesiredCapacity updates the desired capacity in the last description of the ASG after CA changed it.
+func (m *autoScalingGroups) SetDesiredCapacity(name string, size int64) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	if group, found := m.groups[name]; found {
+		updated := *group
+		updated.DesiredCapacity = aws.Int64(size)
+		m.groups[name] = &updated
+	}
+}
+
+// regenerateCache describes all registered ASGs and rebuilds the instance to ASG mapping from scratch,
+// forgetting the instances known not to belong to any of them. The mapping is kept if the ASGs can't
+// be described.
 func (m *autoScalingGroups) regenerateCache() error {
-	newCache := make(map[AwsRef]*Asg)
+	m.cacheMutex.Lock()
+	names := m.registeredNames()
+	m.cacheMutex.Unlock()
+
+	descriptions, err := m.describe(names)
+	if err != nil {
+		return err
+	}
 
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	m.instanceToAsg = make(map[AwsRef]*Asg)
+	m.instancesNotInManagedAsg = make(map[AwsRef]struct{})
+	m.updateGroups(descriptions)
+	return nil
+}
+
+// registeredNames returns the names of the registered ASGs. The cache must be locked.
+func (m *autoScalingGroups) registeredNames() []string {
+	names := make([]string, 0, len(m.registeredAsgs))
 	for _, asg := range m.registeredAsgs {
-		glog.V(4).Infof("Regenerating ASG information for %s", asg.config.Name)
+		names = append(names, asg.config.Name)
+	}
+	return names
+}
+
+// describe describes the ASGs with the given names. Throttled requests are retried with backoff, so
+// the cache must not be locked.
+func (m *autoScalingGroups) describe(names []string) ([]*autoscaling.Group, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	glog.V(4).Infof("Describing %d ASGs", len(names))
+	return m.service.describeAutoscalingGroups(names)
+}
 
-		group, err := m.service.getAutoscalingGroupByName(asg.config.Name)
-		if err != nil {
-			return err
+// updateGroups replaces the ASG descriptions and updates the instance to ASG mapping with the instances
+// that were added to or removed from the ASGs since they were last described. The cache must be locked.
+func (m *autoScalingGroups) updateGroups(descriptions []*autoscaling.Group) {
+	groups := make(map[string]*autoscaling.Group)
+	for _, group := range descriptions {
+		groups[aws.StringValue(group.AutoScalingGroupName)] = group
+	}
+	for _, asg := range m.registeredAsgs {
+		if previous, found := m.groups[asg.config.Name]; found {
+			for _, instance := range previous.Instances {
+				ref := AwsRef{Name: aws.StringValue(instance.InstanceId)}
+				if m.instanceToAsg[ref] == asg.config {
+					delete(m.instanceToAsg, ref)
+				}
+			}
+		}
+		group, found := groups[asg.config.Name]
+		if !found {
+			glog.Warningf("ASG %s was not found", asg.config.Name)
+			continue
 		}
 		for _, instance := range group.Instances {
-			ref := AwsRef{Name: *instance.InstanceId}
-			newCache[ref] = asg.config
+			ref := AwsRef{Name: aws.StringValue(instance.InstanceId)}
+			m.instanceToAsg[ref] = asg.config
+			delete(m.instancesNotInManagedAsg, ref)
 		}
 	}
-
-	m.instanceToAsg = newCache
-	return nil
+	m.groups = groups
+	m.lastRefresh = time.Now()
 }
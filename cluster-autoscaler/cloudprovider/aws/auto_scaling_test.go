@@ -0,0 +1,259 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/stretchr/testify/assert"
+)
+
+const throttlingTestPageSize = 40
+
+// throttlingAutoScaling describes ASGs in pages of throttlingTestPageSize groups, throttling the
+// first throttles requests, or all of them if throttles is negative. If err is set, all requests fail
+// with it.
+type throttlingAutoScaling struct {
+	autoScaling
+
+	mutex      sync.Mutex
+	groups     map[string]*autoscaling.Group
+	throttles  int
+	err        error
+	calls      int
+	running    int
+	maxRunning int
+	maxNames   int
+}
+
+func (a *throttlingAutoScaling) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	a.mutex.Lock()
+	a.calls++
+	a.running++
+	if a.running > a.maxRunning {
+		a.maxRunning = a.running
+	}
+	if len(input.AutoScalingGroupNames) > a.maxNames {
+		a.maxNames = len(input.AutoScalingGroupNames)
+	}
+	throttled := a.throttles != 0
+	if a.throttles > 0 {
+		a.throttles--
+	}
+	err := a.err
+	a.mutex.Unlock()
+
+	time.Sleep(time.Millisecond)
+	defer func() {
+		a.mutex.Lock()
+		a.running--
+		a.mutex.Unlock()
+	}()
+	if throttled {
+		return nil, awserr.New("Throttling", "Rate exceeded", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if input.NextToken != nil {
+		start, _ = strconv.Atoi(*input.NextToken)
+	}
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	names := aws.StringValueSlice(input.AutoScalingGroupNames)
+	for i := start; i < len(names); i++ {
+		if i == start+throttlingTestPageSize {
+			output.NextToken = aws.String(strconv.Itoa(i))
+			break
+		}
+		if group, found := a.groups[names[i]]; found {
+			output.AutoScalingGroups = append(output.AutoScalingGroups, group)
+		}
+	}
+	return output, nil
+}
+
+func (a *throttlingAutoScaling) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	return &autoscaling.DescribeAutoScalingInstancesOutput{}, nil
+}
+
+func (a *throttlingAutoScaling) setGroup(name string, instanceIds ...string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	group := testDescribeAutoScalingGroupsOutput(int64(len(instanceIds)), instanceIds...).AutoScalingGroups[0]
+	group.AutoScalingGroupName = aws.String(name)
+	a.groups[name] = group
+}
+
+func (a *throttlingAutoScaling) setError(err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.err = err
+}
+
+func (a *throttlingAutoScaling) setThrottles(throttles int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.throttles = throttles
+}
+
+func withFastThrottlingBackoff() func() {
+	previous := throttlingBackoff
+	throttlingBackoff = time.Millisecond
+	return func() { throttlingBackoff = previous }
+}
+
+func TestDescribeAutoscalingGroups(t *testing.T) {
+	defer withFastThrottlingBackoff()()
+
+	service := &throttlingAutoScaling{groups: make(map[string]*autoscaling.Group), throttles: 3}
+	names := []string{}
+	for i := 0; i < 250; i++ {
+		name := fmt.Sprintf("asg-%d", i)
+		service.setGroup(name, fmt.Sprintf("i-%d", i))
+		names = append(names, name)
+	}
+	names = append(names, "missing-asg")
+	wrapper := &autoScalingWrapper{service}
+
+	asgs, err := wrapper.describeAutoscalingGroups(names)
+	assert.NoError(t, err)
+	assert.Equal(t, 250, len(asgs))
+	assert.Equal(t, maxRecordsReturnedByAPI, service.maxNames)
+	assert.True(t, service.maxRunning <= maxConcurrentDescribeRequests)
+	// Batches of 100, 100 and 51 names take 3, 3 and 2 pages, plus 3 throttled requests.
+	assert.Equal(t, 11, service.calls)
+}
+
+func TestDescribeAutoscalingGroupsThrottled(t *testing.T) {
+	defer withFastThrottlingBackoff()()
+
+	service := &throttlingAutoScaling{groups: make(map[string]*autoscaling.Group), throttles: -1}
+	service.setGroup("asg")
+	wrapper := &autoScalingWrapper{service}
+
+	_, err := wrapper.describeAutoscalingGroups([]string{"asg"})
+	assert.Error(t, err)
+	assert.True(t, isThrottlingError(err))
+	assert.Equal(t, maxThrottlingRetries+1, service.calls)
+}
+
+func TestAutoScalingGroupsRefresh(t *testing.T) {
+	defer withFastThrottlingBackoff()()
+
+	service := &throttlingAutoScaling{groups: make(map[string]*autoscaling.Group)}
+	service.setGroup("asg-1", "i-1", "i-2")
+	service.setGroup("asg-2", "i-3")
+	asgs := newAutoScalingGroups(autoScalingWrapper{service})
+	asg1 := &Asg{Name: "asg-1"}
+	asg2 := &Asg{Name: "asg-2"}
+	asgs.Register(asg1)
+	asgs.Register(asg2)
+
+	// Nothing was described yet, failed refreshes don't stop the main loop.
+	service.setThrottles(-1)
+	assert.NoError(t, asgs.Refresh())
+	assert.Nil(t, asgs.Get("asg-1"))
+
+	service.setThrottles(0)
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, int64(2), *asgs.Get("asg-1").DesiredCapacity)
+	found, err := asgs.FindForInstance(&AwsRef{Name: "i-3"})
+	assert.NoError(t, err)
+	assert.Equal(t, asg2, found)
+
+	// Membership changes are picked up by the next refresh.
+	service.setGroup("asg-1", "i-2", "i-4")
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, asg1, asgs.instanceToAsg[AwsRef{Name: "i-4"}])
+	_, found1 := asgs.instanceToAsg[AwsRef{Name: "i-1"}]
+	assert.False(t, found1)
+
+	// Refreshes failing for other reasons use the previous descriptions and are retried in the next loop.
+	service.setError(fmt.Errorf("connection reset"))
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, int64(2), *asgs.Get("asg-1").DesiredCapacity)
+	assert.Equal(t, asg1, asgs.instanceToAsg[AwsRef{Name: "i-4"}])
+	assert.Equal(t, time.Duration(0), asgs.refreshInterval)
+	assert.Error(t, asgs.regenerateCache())
+	assert.Equal(t, asg1, asgs.instanceToAsg[AwsRef{Name: "i-4"}])
+	service.setError(nil)
+
+	// Throttled refreshes use the previous descriptions and are postponed.
+	service.setThrottles(-1)
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, minThrottledRefreshInterval, asgs.refreshInterval)
+	assert.Equal(t, int64(2), *asgs.Get("asg-1").DesiredCapacity)
+	calls := service.calls
+	asgs.Invalidate()
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, calls, service.calls)
+
+	asgs.lastRefresh = asgs.lastRefresh.Add(-minThrottledRefreshInterval)
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, 2*minThrottledRefreshInterval, asgs.refreshInterval)
+
+	// Successful refresh resets the interval.
+	service.setThrottles(0)
+	service.setGroup("asg-2", "i-3", "i-5")
+	asgs.lastRefresh = asgs.lastRefresh.Add(-2 * minThrottledRefreshInterval)
+	assert.NoError(t, asgs.Refresh())
+	assert.Equal(t, time.Duration(0), asgs.refreshInterval)
+	assert.Equal(t, int64(2), *asgs.Get("asg-2").DesiredCapacity)
+}
+
+func TestAutoScalingGroupsFindForInstanceDuringThrottledRefresh(t *testing.T) {
+	previous := throttlingBackoff
+	throttlingBackoff = 100 * time.Millisecond
+	defer func() { throttlingBackoff = previous }()
+
+	service := &throttlingAutoScaling{groups: make(map[string]*autoscaling.Group)}
+	service.setGroup("asg-1", "i-1")
+	asgs := newAutoScalingGroups(autoScalingWrapper{service})
+	asg1 := &Asg{Name: "asg-1"}
+	asgs.Register(asg1)
+	assert.NoError(t, asgs.Refresh())
+
+	// Retries of the throttled requests don't block lookups of known instances.
+	service.setThrottles(-1)
+	asgs.Invalidate()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		asgs.Refresh()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	found, err := asgs.FindForInstance(&AwsRef{Name: "i-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, asg1, found)
+	select {
+	case <-done:
+		t.Error("Refresh finished before the lookup")
+	default:
+	}
+	<-done
+}
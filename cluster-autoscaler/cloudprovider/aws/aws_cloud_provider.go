@@ -19,6 +19,7 @@ package aws
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -174,7 +175,56 @@ func (aws *awsCloudProvider) GetResourceLimiter() (*cloudprovider.ResourceLimite
 // Refresh is called before every main loop and can be used to dynamically update cloud provider state.
 // In particular the list of node groups returned by NodeGroups can change as a result of CloudProvider.Refresh().
 func (aws *awsCloudProvider) Refresh() error {
-	return nil
+	return aws.awsManager.Refresh()
+}
+
+// DeleteInstance deletes the instance with the given provider id. Instances that belong to
+// an ASG are not deleted.
+func (aws *awsCloudProvider) DeleteInstance(providerID string) error {
+	ref, err := AwsRefFromProviderId(providerID)
+	if err != nil {
+		return err
+	}
+	asg, err := aws.awsManager.GetAsgForInstance(ref)
+	if err != nil {
+		return err
+	}
+	if asg != nil {
+		return fmt.Errorf("Instance %s belongs to ASG %s, refusing to delete it directly", providerID, asg.Id())
+	}
+	return aws.awsManager.TerminateInstance(ref)
+}
+
+// NodeGroupNetworkCapacity returns how many IP addresses are left in the subnets of the ASG and how
+// many of them each node takes, assuming the default configuration of the AWS VPC CNI plugin. ASGs
+// outside of a VPC and instance types with unknown network interface limits are not limited.
+func (aws *awsCloudProvider) NodeGroupNetworkCapacity(nodeGroup cloudprovider.NodeGroup, nodes []*apiv1.Node) (*cloudprovider.NetworkCapacity, error) {
+	asg, ok := nodeGroup.(*Asg)
+	if !ok {
+		return nil, fmt.Errorf("node group %s is not an ASG", nodeGroup.Id())
+	}
+	instanceType, availableIps, err := aws.awsManager.GetAsgNetwork(asg)
+	if err != nil {
+		return nil, err
+	}
+	ipsPerNode := vpcCniIpsPerNode(instanceType)
+	if len(availableIps) == 0 || ipsPerNode == 0 {
+		return nil, nil
+	}
+	subnetIds := make([]string, 0, len(availableIps))
+	addresses := 0
+	for subnetId, available := range availableIps {
+		subnetIds = append(subnetIds, subnetId)
+		// A node takes all its addresses from a single subnet, so whatever is left in a subnet
+		// after the last whole node is of no use.
+		addresses += int(available / ipsPerNode * ipsPerNode)
+	}
+	sort.Strings(subnetIds)
+	return &cloudprovider.NetworkCapacity{
+		Network:          strings.Join(subnetIds, ","),
+		Addresses:        addresses,
+		AddressesPerNode: int(ipsPerNode),
+	}, nil
 }
 
 // AwsRef contains a reference to some entity in AWS/GKE world.
@@ -219,7 +269,7 @@ func (asg *Asg) MinSize() int {
 // TargetSize returns the current TARGET size of the node group. It is possible that the
 // number is different from the number of nodes registered in Kubernetes.
 func (asg *Asg) TargetSize() (int, error) {
-	size, err := asg.awsManager.GetAsgSize(asg)
+	size, err := asg.awsManager.GetAsgTargetSize(asg)
 	return int(size), err
 }
 
@@ -247,6 +297,15 @@ func (asg *Asg) Delete() error {
 
 // IncreaseSize increases Asg size
 func (asg *Asg) IncreaseSize(delta int) error {
+	return asg.IncreaseSizeFrom(anyTargetSize, delta)
+}
+
+// anyTargetSize passed as the expected size to IncreaseSizeFrom skips the desired capacity check.
+const anyTargetSize = -1
+
+// IncreaseSizeFrom increases Asg size if its desired capacity is still expectedSize. The desired
+// capacity is read right before setting it, as SetDesiredCapacity doesn't support preconditions.
+func (asg *Asg) IncreaseSizeFrom(expectedSize int, delta int) error {
 	if delta <= 0 {
 		return fmt.Errorf("size increase must be positive")
 	}
@@ -254,6 +313,9 @@ func (asg *Asg) IncreaseSize(delta int) error {
 	if err != nil {
 		return err
 	}
+	if expectedSize != anyTargetSize && int(size) != expectedSize {
+		return &cloudprovider.TargetSizeChangedError{NodeGroup: asg.Id(), Expected: expectedSize, Actual: int(size)}
+	}
 	if int(size)+delta > asg.MaxSize() {
 		return fmt.Errorf("size increase too large - desired:%d max:%d", int(size)+delta, asg.MaxSize())
 	}
@@ -362,6 +424,12 @@ func (asg *Asg) TemplateNodeInfo() (*schedulercache.NodeInfo, error) {
 	return nodeInfo, nil
 }
 
+// TemplateFingerprint returns the name of the launch configuration used by the ASG. It is
+// updated whenever the ASG size is fetched or the target size is read.
+func (asg *Asg) TemplateFingerprint() (string, error) {
+	return asg.awsManager.GetAsgLaunchConfigurationName(asg)
+}
+
 func buildAsgFromSpec(value string, awsManager *AwsManager) (*Asg, error) {
 	spec, err := dynamic.SpecFromString(value, true)
 
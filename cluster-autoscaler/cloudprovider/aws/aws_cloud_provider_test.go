@@ -21,6 +21,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	apiv1 "k8s.io/api/core/v1"
@@ -36,6 +37,11 @@ func (a *AutoScalingMock) DescribeAutoScalingGroups(i *autoscaling.DescribeAutoS
 	return args.Get(0).(*autoscaling.DescribeAutoScalingGroupsOutput), nil
 }
 
+func (a *AutoScalingMock) DescribeAutoScalingInstances(i *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	args := a.Called(i)
+	return args.Get(0).(*autoscaling.DescribeAutoScalingInstancesOutput), nil
+}
+
 func (a *AutoScalingMock) DescribeLaunchConfigurations(i *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
 	args := a.Called(i)
 	return args.Get(0).(*autoscaling.DescribeLaunchConfigurationsOutput), nil
@@ -65,6 +71,20 @@ func (a *AutoScalingMock) TerminateInstanceInAutoScalingGroup(input *autoscaling
 	return args.Get(0).(*autoscaling.TerminateInstanceInAutoScalingGroupOutput), nil
 }
 
+type EC2Mock struct {
+	mock.Mock
+}
+
+func (e *EC2Mock) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	args := e.Called(input)
+	return &ec2.TerminateInstancesOutput{}, args.Error(0)
+}
+
+func (e *EC2Mock) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	args := e.Called(input)
+	return args.Get(0).(*ec2.DescribeSubnetsOutput), args.Error(1)
+}
+
 var testService = autoScalingWrapper{&AutoScalingMock{}}
 
 var testAwsManager = &AwsManager{
@@ -108,6 +128,23 @@ func testDescribeAutoScalingGroupsOutput(desiredCap int64, instanceIds ...string
 	}
 }
 
+func testDescribeAutoScalingInstancesOutput(asgName string) *autoscaling.DescribeAutoScalingInstancesOutput {
+	if asgName == "" {
+		return &autoscaling.DescribeAutoScalingInstancesOutput{}
+	}
+	return &autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{
+			{AutoScalingGroupName: aws.String(asgName)},
+		},
+	}
+}
+
+func describeInstanceInput(instanceId string) *autoscaling.DescribeAutoScalingInstancesInput {
+	return &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: aws.StringSlice([]string{instanceId}),
+	}
+}
+
 func testProvider(t *testing.T, m *AwsManager) *awsCloudProvider {
 	resourceLimiter := cloudprovider.NewResourceLimiter(
 		map[string]int64{cloudprovider.ResourceNameCores: 1, cloudprovider.ResourceNameMemory: 10000000},
@@ -167,10 +204,10 @@ func TestNodeGroupForNode(t *testing.T) {
 	err := provider.addNodeGroup("1:5:test-asg")
 	assert.NoError(t, err)
 
-	service.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: aws.StringSlice([]string{provider.asgs[0].Name}),
-		MaxRecords:            aws.Int64(1),
-	}).Return(testDescribeAutoScalingGroupsOutput(1, "test-instance-id"))
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id")).
+		Return(testDescribeAutoScalingInstancesOutput("test-asg"))
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id-not-in-group")).
+		Return(testDescribeAutoScalingInstancesOutput(""))
 
 	group, err := provider.NodeGroupForNode(node)
 
@@ -178,7 +215,7 @@ func TestNodeGroupForNode(t *testing.T) {
 	assert.Equal(t, group.Id(), "test-asg")
 	assert.Equal(t, group.MinSize(), 1)
 	assert.Equal(t, group.MaxSize(), 5)
-	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 1)
 
 	// test node in cluster that is not in a group managed by cluster autoscaler
 	nodeNotInGroup := &apiv1.Node{
@@ -191,7 +228,104 @@ func TestNodeGroupForNode(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Nil(t, group)
-	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 2)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 2)
+
+	// Both instances are remembered.
+	_, err = provider.NodeGroupForNode(node)
+	assert.NoError(t, err)
+	_, err = provider.NodeGroupForNode(nodeNotInGroup)
+	assert.NoError(t, err)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 2)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 0)
+}
+
+func TestDeleteInstance(t *testing.T) {
+	service := &AutoScalingMock{}
+	ec2Service := &EC2Mock{}
+	m := newTestAwsManagerWithService(service)
+	m.ec2Service = ec2Service
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id")).
+		Return(testDescribeAutoScalingInstancesOutput("test-asg"))
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id-not-in-group")).
+		Return(testDescribeAutoScalingInstancesOutput(""))
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id-other-asg")).
+		Return(testDescribeAutoScalingInstancesOutput("other-asg"))
+	ec2Service.On("TerminateInstances", &ec2.TerminateInstancesInput{
+		InstanceIds: aws.StringSlice([]string{"test-instance-id-not-in-group"}),
+	}).Return(nil)
+
+	// Instances in a managed ASG are never terminated directly.
+	err = provider.DeleteInstance("aws:///us-east-1a/test-instance-id")
+	assert.Error(t, err)
+	ec2Service.AssertNumberOfCalls(t, "TerminateInstances", 0)
+
+	// Neither are instances of ASGs CA doesn't manage.
+	err = provider.DeleteInstance("aws:///us-east-1a/test-instance-id-other-asg")
+	assert.Error(t, err)
+	ec2Service.AssertNumberOfCalls(t, "TerminateInstances", 0)
+
+	err = provider.DeleteInstance("aws:///us-east-1a/test-instance-id-not-in-group")
+	assert.NoError(t, err)
+	ec2Service.AssertNumberOfCalls(t, "TerminateInstances", 1)
+
+	err = provider.DeleteInstance("gce://project1/us-central1-b/n1")
+	assert.Error(t, err)
+	ec2Service.AssertNumberOfCalls(t, "TerminateInstances", 1)
+}
+
+func TestNodeGroupNetworkCapacity(t *testing.T) {
+	service := &AutoScalingMock{}
+	ec2Service := &EC2Mock{}
+	m := newTestAwsManagerWithService(service)
+	m.ec2Service = ec2Service
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+
+	group := testDescribeAutoScalingGroupsOutput(1, "test-instance-id")
+	group.AutoScalingGroups[0].LaunchConfigurationName = aws.String("test-lc")
+	group.AutoScalingGroups[0].VPCZoneIdentifier = aws.String("subnet-1,subnet-2")
+	service.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice([]string{"test-asg"}),
+		MaxRecords:            aws.Int64(1),
+	}).Return(group)
+	service.On("DescribeLaunchConfigurations", &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: aws.StringSlice([]string{"test-lc"}),
+		MaxRecords:               aws.Int64(1),
+	}).Return(&autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{{InstanceType: aws.String("m4.large")}},
+	})
+	// m4.large nodes take 2 ENIs with 10 addresses each.
+	ec2Service.On("DescribeSubnets", &ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice([]string{"subnet-1", "subnet-2"}),
+	}).Return(&ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-1"), AvailableIpAddressCount: aws.Int64(45)},
+			{SubnetId: aws.String("subnet-2"), AvailableIpAddressCount: aws.Int64(19)},
+		},
+	}, nil).Once()
+
+	expected := &cloudprovider.NetworkCapacity{Network: "subnet-1,subnet-2", Addresses: 40, AddressesPerNode: 20}
+	capacity, err := provider.NodeGroupNetworkCapacity(provider.asgs[0], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, capacity)
+	assert.Equal(t, 2, capacity.MaxNodes(capacity.Addresses))
+
+	// Subnets are cached.
+	capacity, err = provider.NodeGroupNetworkCapacity(provider.asgs[0], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, capacity)
+	ec2Service.AssertNumberOfCalls(t, "DescribeSubnets", 1)
+}
+
+func TestVpcCniIpsPerNode(t *testing.T) {
+	assert.Equal(t, int64(20), vpcCniIpsPerNode("m4.large"))
+	assert.Equal(t, int64(4), vpcCniIpsPerNode("t2.nano"))
+	assert.Equal(t, int64(0), vpcCniIpsPerNode("unknown.type"))
 }
 
 func TestAwsRefFromProviderId(t *testing.T) {
@@ -240,6 +374,44 @@ func TestTargetSize(t *testing.T) {
 	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
 }
 
+func TestRefresh(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := newTestAwsManagerWithService(service)
+	m.asgs.groups = make(map[string]*autoscaling.Group)
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+
+	description := testDescribeAutoScalingGroupsOutput(2, "test-instance-id", "second-test-instance-id")
+	description.AutoScalingGroups[0].AutoScalingGroupName = aws.String("test-asg")
+	description.AutoScalingGroups[0].Instances[0].AvailabilityZone = aws.String("us-east-1a")
+	description.AutoScalingGroups[0].Instances[1].AvailabilityZone = aws.String("us-east-1a")
+	service.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice([]string{"test-asg"}),
+		MaxRecords:            aws.Int64(maxRecordsReturnedByAPI),
+	}).Return(description)
+
+	assert.NoError(t, provider.Refresh())
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
+
+	// Sizes, nodes and instances are served from the refreshed descriptions.
+	targetSize, err := provider.asgs[0].TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, targetSize)
+	nodes, err := provider.asgs[0].Nodes()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aws:///us-east-1a/test-instance-id", "aws:///us-east-1a/second-test-instance-id"}, nodes)
+	group, err := provider.NodeGroupForNode(&apiv1.Node{
+		Spec: apiv1.NodeSpec{
+			ProviderID: "aws:///us-east-1a/second-test-instance-id",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-asg", group.Id())
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 0)
+}
+
 func TestIncreaseSize(t *testing.T) {
 	service := &AutoScalingMock{}
 	m := newTestAwsManagerWithService(service)
@@ -265,17 +437,45 @@ func TestIncreaseSize(t *testing.T) {
 	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
 }
 
-func TestBelongs(t *testing.T) {
+func TestIncreaseSizeFrom(t *testing.T) {
 	service := &AutoScalingMock{}
 	m := newTestAwsManagerWithService(service)
 	provider := testProvider(t, m)
 	err := provider.addNodeGroup("1:5:test-asg")
 	assert.NoError(t, err)
 
+	// Desired capacity was increased concurrently from 2 to 3.
 	service.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: aws.StringSlice([]string{provider.asgs[0].Name}),
 		MaxRecords:            aws.Int64(1),
-	}).Return(testDescribeAutoScalingGroupsOutput(1, "test-instance-id"))
+	}).Return(testDescribeAutoScalingGroupsOutput(3, "test-instance-id", "second-test-instance-id"))
+	service.On("SetDesiredCapacity", &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String(provider.asgs[0].Name),
+		DesiredCapacity:      aws.Int64(4),
+		HonorCooldown:        aws.Bool(false),
+	}).Return(&autoscaling.SetDesiredCapacityOutput{})
+
+	err = provider.asgs[0].IncreaseSizeFrom(2, 1)
+	assert.Equal(t, &cloudprovider.TargetSizeChangedError{NodeGroup: provider.asgs[0].Id(), Expected: 2, Actual: 3}, err)
+	service.AssertNumberOfCalls(t, "SetDesiredCapacity", 0)
+
+	err = provider.asgs[0].IncreaseSizeFrom(3, 1)
+	assert.NoError(t, err)
+	service.AssertNumberOfCalls(t, "SetDesiredCapacity", 1)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 2)
+}
+
+func TestBelongs(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := newTestAwsManagerWithService(service)
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("invalid-instance-id")).
+		Return(testDescribeAutoScalingInstancesOutput(""))
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id")).
+		Return(testDescribeAutoScalingInstancesOutput("test-asg"))
 
 	invalidNode := &apiv1.Node{
 		Spec: apiv1.NodeSpec{
@@ -284,7 +484,7 @@ func TestBelongs(t *testing.T) {
 	}
 	_, err = provider.asgs[0].Belongs(invalidNode)
 	assert.Error(t, err)
-	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 1)
 
 	validNode := &apiv1.Node{
 		Spec: apiv1.NodeSpec{
@@ -294,9 +494,14 @@ func TestBelongs(t *testing.T) {
 	belongs, err := provider.asgs[0].Belongs(validNode)
 	assert.Equal(t, belongs, true)
 	assert.NoError(t, err)
-	// As "test-instance-id" is already known to be managed by test-asg since the first `Belongs` call,
-	// No additional DescribAutoScalingGroup call is made
-	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 2)
+
+	// As "test-instance-id" is already known to be managed by test-asg since the second `Belongs` call,
+	// No additional DescribeAutoScalingInstances call is made
+	belongs, err = provider.asgs[0].Belongs(validNode)
+	assert.Equal(t, belongs, true)
+	assert.NoError(t, err)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 2)
 }
 
 func TestDeleteNodes(t *testing.T) {
@@ -318,6 +523,8 @@ func TestDeleteNodes(t *testing.T) {
 		AutoScalingGroupNames: aws.StringSlice([]string{provider.asgs[0].Name}),
 		MaxRecords:            aws.Int64(1),
 	}).Return(testDescribeAutoScalingGroupsOutput(2, "test-instance-id", "second-test-instance-id"))
+	service.On("DescribeAutoScalingInstances", describeInstanceInput("test-instance-id")).
+		Return(testDescribeAutoScalingInstancesOutput("test-asg"))
 
 	node := &apiv1.Node{
 		Spec: apiv1.NodeSpec{
@@ -327,7 +534,8 @@ func TestDeleteNodes(t *testing.T) {
 	err = provider.asgs[0].DeleteNodes([]*apiv1.Node{node})
 	assert.NoError(t, err)
 	service.AssertNumberOfCalls(t, "TerminateInstanceInAutoScalingGroup", 1)
-	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 2)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingGroups", 1)
+	service.AssertNumberOfCalls(t, "DescribeAutoScalingInstances", 1)
 }
 
 func TestGetResourceLimiter(t *testing.T) {
@@ -22,12 +22,15 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
 	"gopkg.in/gcfg.v1"
 	apiv1 "k8s.io/api/core/v1"
@@ -43,6 +46,11 @@ const (
 	operationWaitTimeout    = 5 * time.Second
 	operationPollInterval   = 100 * time.Millisecond
 	maxRecordsReturnedByAPI = 100
+	// asgFullResyncInterval is how often the instance to ASG mapping is rebuilt from scratch. In between
+	// it is updated from the ASGs described by Refresh and from lookups of new instances.
+	asgFullResyncInterval = time.Hour
+	// subnetCacheTTL is how long the number of IP addresses available in a subnet is cached.
+	subnetCacheTTL = time.Minute
 )
 
 type asgInformation struct {
@@ -50,11 +58,38 @@ type asgInformation struct {
 	basename string
 }
 
+// ec2Instances is the interface represents the part of the EC2 service provided by AWS SDK
+// used by CA to manage instances outside of auto-scaling groups and to check subnet capacity.
+type ec2Instances interface {
+	TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error)
+	DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+}
+
 // AwsManager is handles aws communication and data caching.
 type AwsManager struct {
-	service   autoScalingWrapper
-	asgs      *autoScalingGroups
-	interrupt chan struct{}
+	service    autoScalingWrapper
+	ec2Service ec2Instances
+	asgs       *autoScalingGroups
+	interrupt  chan struct{}
+
+	// launchConfigurations contains launch configuration names returned by the last GetAsgSize or
+	// GetAsgTargetSize call for each ASG.
+	launchConfigurations      map[string]string
+	launchConfigurationsMutex sync.Mutex
+
+	// instanceTypes contains instance types of launch configurations, by launch configuration name.
+	// Launch configurations can't be modified, so they are never described again.
+	instanceTypes      map[string]string
+	instanceTypesMutex sync.Mutex
+
+	// subnets contains subnets described by GetAsgNetwork, by subnet id.
+	subnets      map[string]cachedSubnet
+	subnetsMutex sync.Mutex
+}
+
+type cachedSubnet struct {
+	availableIps int64
+	fetched      time.Time
 }
 
 type asgTemplate struct {
@@ -81,18 +116,17 @@ func createAWSManagerInternal(configReader io.Reader, service *autoScalingWrappe
 	}
 
 	manager := &AwsManager{
-		asgs:      newAutoScalingGroups(*service),
-		service:   *service,
-		interrupt: make(chan struct{}),
+		asgs:       newAutoScalingGroups(*service),
+		service:    *service,
+		ec2Service: ec2.New(session.New()),
+		interrupt:  make(chan struct{}),
 	}
 
 	go wait.Until(func() {
-		manager.asgs.cacheMutex.Lock()
-		defer manager.asgs.cacheMutex.Unlock()
 		if err := manager.asgs.regenerateCache(); err != nil {
 			glog.Errorf("Error while regenerating Asg cache: %v", err)
 		}
-	}, time.Hour, manager.interrupt)
+	}, asgFullResyncInterval, manager.interrupt)
 
 	return manager, nil
 }
@@ -102,6 +136,85 @@ func CreateAwsManager(configReader io.Reader) (*AwsManager, error) {
 	return createAWSManagerInternal(configReader, nil)
 }
 
+// TerminateInstance terminates the given instance directly, bypassing ASGs. Instances of any ASG,
+// including the ones not managed by CA, are not terminated, as the ASG would replace them.
+func (m *AwsManager) TerminateInstance(instance *AwsRef) error {
+	asgName, err := m.service.getAutoscalingGroupNameForInstance(instance.Name)
+	if err != nil {
+		return err
+	}
+	if asgName != "" {
+		return fmt.Errorf("Instance %s belongs to ASG %s, refusing to terminate it directly", instance.Name, asgName)
+	}
+	params := &ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String(instance.Name)},
+	}
+	_, err = m.ec2Service.TerminateInstances(params)
+	return err
+}
+
+// GetAsgNetwork returns the instance type launched by the ASG and the number of IP addresses
+// available in each of its VPC subnets, by subnet id. No subnets are returned for ASGs outside of
+// a VPC. Subnets are described at most once every subnetCacheTTL.
+func (m *AwsManager) GetAsgNetwork(asg *Asg) (string, map[string]int64, error) {
+	group, err := m.getAsg(asg.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	if group.LaunchConfigurationName == nil {
+		return "", nil, fmt.Errorf("ASG %s has no launch configuration", asg.Name)
+	}
+	instanceType, err := m.getInstanceTypeByLCName(*group.LaunchConfigurationName)
+	if err != nil {
+		return "", nil, err
+	}
+	if group.VPCZoneIdentifier == nil || *group.VPCZoneIdentifier == "" {
+		return instanceType, nil, nil
+	}
+	subnetIds := make([]string, 0)
+	for _, subnetId := range strings.Split(*group.VPCZoneIdentifier, ",") {
+		subnetIds = append(subnetIds, strings.TrimSpace(subnetId))
+	}
+	availableIps, err := m.getSubnetsAvailableIps(subnetIds)
+	if err != nil {
+		return "", nil, err
+	}
+	return instanceType, availableIps, nil
+}
+
+func (m *AwsManager) getSubnetsAvailableIps(subnetIds []string) (map[string]int64, error) {
+	m.subnetsMutex.Lock()
+	defer m.subnetsMutex.Unlock()
+	availableIps := make(map[string]int64)
+	toDescribe := make([]*string, 0)
+	for _, subnetId := range subnetIds {
+		if cached, found := m.subnets[subnetId]; found && time.Since(cached.fetched) < subnetCacheTTL {
+			availableIps[subnetId] = cached.availableIps
+		} else {
+			toDescribe = append(toDescribe, aws.String(subnetId))
+		}
+	}
+	if len(toDescribe) == 0 {
+		return availableIps, nil
+	}
+	subnets, err := m.ec2Service.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: toDescribe})
+	if err != nil {
+		return nil, err
+	}
+	if m.subnets == nil {
+		m.subnets = make(map[string]cachedSubnet)
+	}
+	now := time.Now()
+	for _, subnet := range subnets.Subnets {
+		if subnet.SubnetId == nil || subnet.AvailableIpAddressCount == nil {
+			continue
+		}
+		availableIps[*subnet.SubnetId] = *subnet.AvailableIpAddressCount
+		m.subnets[*subnet.SubnetId] = cachedSubnet{availableIps: *subnet.AvailableIpAddressCount, fetched: now}
+	}
+	return availableIps, nil
+}
+
 // RegisterAsg registers asg in Aws Manager.
 func (m *AwsManager) RegisterAsg(asg *Asg) {
 	m.asgs.Register(asg)
@@ -112,6 +225,12 @@ func (m *AwsManager) GetAsgForInstance(instance *AwsRef) (*Asg, error) {
 	return m.asgs.FindForInstance(instance)
 }
 
+// Refresh describes the registered ASGs, so that their sizes, instances and templates don't have to be
+// described one by one.
+func (m *AwsManager) Refresh() error {
+	return m.asgs.Refresh()
+}
+
 // Cleanup closes the channel to signal the go routine to stop that is handling the cache
 func (m *AwsManager) Cleanup() {
 	close(m.interrupt)
@@ -121,7 +240,7 @@ func (m *AwsManager) getAutoscalingGroupsByTags(keys []string) ([]*autoscaling.G
 	return m.service.getAutoscalingGroupsByTags(keys)
 }
 
-// GetAsgSize gets ASG size.
+// GetAsgSize gets ASG size. Unlike GetAsgTargetSize, it always describes the ASG.
 func (m *AwsManager) GetAsgSize(asgConfig *Asg) (int64, error) {
 	params := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{aws.String(asgConfig.Name)},
@@ -136,10 +255,76 @@ func (m *AwsManager) GetAsgSize(asgConfig *Asg) (int64, error) {
 	if len(groups.AutoScalingGroups) < 1 {
 		return -1, fmt.Errorf("Unable to get first autoscaling.Group for %s", asgConfig.Name)
 	}
+	m.asgs.Set(groups.AutoScalingGroups[0])
 	asg := *groups.AutoScalingGroups[0]
+	m.recordLaunchConfiguration(asgConfig, &asg)
 	return *asg.DesiredCapacity, nil
 }
 
+// GetAsgTargetSize gets ASG size as of the last Refresh, or describes the ASG if it wasn't described yet.
+func (m *AwsManager) GetAsgTargetSize(asgConfig *Asg) (int64, error) {
+	group := m.asgs.Get(asgConfig.Name)
+	if group == nil {
+		return m.GetAsgSize(asgConfig)
+	}
+	m.recordLaunchConfiguration(asgConfig, group)
+	return *group.DesiredCapacity, nil
+}
+
+func (m *AwsManager) recordLaunchConfiguration(asgConfig *Asg, group *autoscaling.Group) {
+	if group.LaunchConfigurationName == nil {
+		return
+	}
+	m.launchConfigurationsMutex.Lock()
+	defer m.launchConfigurationsMutex.Unlock()
+	if m.launchConfigurations == nil {
+		m.launchConfigurations = make(map[string]string)
+	}
+	m.launchConfigurations[asgConfig.Name] = *group.LaunchConfigurationName
+}
+
+// getAsg returns the ASG as of the last Refresh, or describes it if it wasn't described yet.
+func (m *AwsManager) getAsg(name string) (*autoscaling.Group, error) {
+	if group := m.asgs.Get(name); group != nil {
+		return group, nil
+	}
+	group, err := m.service.getAutoscalingGroupByName(name)
+	if err != nil {
+		return nil, err
+	}
+	m.asgs.Set(group)
+	return group, nil
+}
+
+func (m *AwsManager) getInstanceTypeByLCName(name string) (string, error) {
+	m.instanceTypesMutex.Lock()
+	defer m.instanceTypesMutex.Unlock()
+	if instanceType, found := m.instanceTypes[name]; found {
+		return instanceType, nil
+	}
+	instanceType, err := m.service.getInstanceTypeByLCName(name)
+	if err != nil {
+		return "", err
+	}
+	if m.instanceTypes == nil {
+		m.instanceTypes = make(map[string]string)
+	}
+	m.instanceTypes[name] = instanceType
+	return instanceType, nil
+}
+
+// GetAsgLaunchConfigurationName returns the name of the launch configuration used by the ASG,
+// as seen by the last GetAsgSize call.
+func (m *AwsManager) GetAsgLaunchConfigurationName(asg *Asg) (string, error) {
+	m.launchConfigurationsMutex.Lock()
+	defer m.launchConfigurationsMutex.Unlock()
+	name, found := m.launchConfigurations[asg.Name]
+	if !found {
+		return "", fmt.Errorf("launch configuration of %s is not known yet", asg.Name)
+	}
+	return name, nil
+}
+
 // SetAsgSize sets ASG size.
 func (m *AwsManager) SetAsgSize(asg *Asg, size int64) error {
 	params := &autoscaling.SetDesiredCapacityInput{
@@ -152,6 +337,8 @@ func (m *AwsManager) SetAsgSize(asg *Asg, size int64) error {
 	if err != nil {
 		return err
 	}
+	m.asgs.SetDesiredCapacity(asg.Name, size)
+	m.asgs.Invalidate()
 	return nil
 }
 
@@ -185,14 +372,15 @@ func (m *AwsManager) DeleteInstances(instances []*AwsRef) error {
 		}
 		glog.V(4).Infof(*resp.Activity.Description)
 	}
+	m.asgs.Invalidate()
 
 	return nil
 }
 
-// GetAsgNodes returns Asg nodes.
+// GetAsgNodes returns Asg nodes as of the last Refresh.
 func (m *AwsManager) GetAsgNodes(asg *Asg) ([]string, error) {
 	result := make([]string, 0)
-	group, err := m.service.getAutoscalingGroupByName(asg.Name)
+	group, err := m.getAsg(asg.Name)
 	if err != nil {
 		return []string{}, err
 	}
@@ -204,12 +392,12 @@ func (m *AwsManager) GetAsgNodes(asg *Asg) ([]string, error) {
 }
 
 func (m *AwsManager) getAsgTemplate(name string) (*asgTemplate, error) {
-	asg, err := m.service.getAutoscalingGroupByName(name)
+	asg, err := m.getAsg(name)
 	if err != nil {
 		return nil, err
 	}
 
-	instanceTypeName, err := m.service.getInstanceTypeByLCName(*asg.LaunchConfigurationName)
+	instanceTypeName, err := m.getInstanceTypeByLCName(*asg.LaunchConfigurationName)
 	if err != nil {
 		return nil, err
 	}
@@ -269,8 +457,7 @@ func (m *AwsManager) buildNodeFromTemplate(asg *Asg, template *asgTemplate) (*ap
 
 func buildGenericLabels(template *asgTemplate, nodeName string) map[string]string {
 	result := make(map[string]string)
-	// TODO: extract it somehow
-	result[kubeletapis.LabelArch] = cloudprovider.DefaultArch
+	result[kubeletapis.LabelArch] = getArchForInstanceType(template.InstanceType.InstanceType)
 	result[kubeletapis.LabelOS] = cloudprovider.DefaultOS
 
 	result[kubeletapis.LabelInstanceType] = template.InstanceType.InstanceType
@@ -317,3 +504,17 @@ func extractTaintsFromAsg(tags []*autoscaling.TagDescription) []apiv1.Taint {
 	}
 	return taints
 }
+
+// arm64InstanceFamily matches instance families with Graviton processors, like m6g, c7gn or
+// im4gn. The first generation of Graviton instances is a1.
+var arm64InstanceFamily = regexp.MustCompile(`^(a1|[a-z]+[0-9]+g[a-z]*)$`)
+
+// getArchForInstanceType returns the arch of nodes of the instance type. It's needed for templates
+// of ASGs without nodes to be matched against pods requiring an arch.
+func getArchForInstanceType(instanceType string) string {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	if arm64InstanceFamily.MatchString(family) {
+		return cloudprovider.Arm64Arch
+	}
+	return cloudprovider.DefaultArch
+}
@@ -44,6 +44,25 @@ func TestBuildGenericLabels(t *testing.T) {
 	assert.Equal(t, cloudprovider.DefaultOS, labels[kubeletapis.LabelOS])
 }
 
+func TestGetArchForInstanceType(t *testing.T) {
+	for _, instanceType := range []string{"c4.large", "m5.xlarge", "g4dn.xlarge", "m6i.large", "m6a.large", "p3.2xlarge"} {
+		assert.Equal(t, cloudprovider.DefaultArch, getArchForInstanceType(instanceType), instanceType)
+	}
+	for _, instanceType := range []string{"a1.medium", "m6g.large", "m6gd.xlarge", "c6gn.large", "c7g.2xlarge", "r7g.medium", "t4g.nano", "im4gn.large", "x2gd.xlarge"} {
+		assert.Equal(t, cloudprovider.Arm64Arch, getArchForInstanceType(instanceType), instanceType)
+	}
+
+	labels := buildGenericLabels(&asgTemplate{
+		InstanceType: &instanceType{
+			InstanceType: "m6g.large",
+			VCPU:         2,
+			MemoryMb:     8192,
+		},
+		Region: "us-east-1",
+	}, "sillyname")
+	assert.Equal(t, cloudprovider.Arm64Arch, labels[kubeletapis.LabelArch])
+}
+
 func TestExtractLabelsFromAsg(t *testing.T) {
 	tags := []*autoscaling.TagDescription{
 		{
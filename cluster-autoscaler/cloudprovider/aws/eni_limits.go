@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+// eniLimit is the number of network interfaces that can be attached to an instance type and
+// the number of IPv4 addresses each of them can have.
+type eniLimit struct {
+	ENIs       int64
+	IPv4PerENI int64
+}
+
+// vpcCniENIsPerNode is the number of network interfaces the AWS VPC CNI plugin attaches to a new
+// node with the default WARM_ENI_TARGET: the primary one and a spare one for new pods. The plugin
+// takes all addresses of an interface from the subnet when it is attached.
+const vpcCniENIsPerNode = 2
+
+// eniLimits contains limits of common instance types, see
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/using-eni.html#AvailableIpPerENI
+var eniLimits = map[string]eniLimit{
+	"c4.large":    {ENIs: 3, IPv4PerENI: 10},
+	"c4.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"c4.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"c4.4xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"c4.8xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"c5.large":    {ENIs: 3, IPv4PerENI: 10},
+	"c5.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"c5.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"c5.4xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"c5.9xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"c5.18xlarge": {ENIs: 15, IPv4PerENI: 50},
+	"i3.large":    {ENIs: 3, IPv4PerENI: 10},
+	"i3.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"i3.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"i3.4xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"i3.8xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"i3.16xlarge": {ENIs: 15, IPv4PerENI: 50},
+	"m4.large":    {ENIs: 2, IPv4PerENI: 10},
+	"m4.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"m4.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"m4.4xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"m4.10xlarge": {ENIs: 8, IPv4PerENI: 30},
+	"m4.16xlarge": {ENIs: 8, IPv4PerENI: 30},
+	"m5.large":    {ENIs: 3, IPv4PerENI: 10},
+	"m5.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"m5.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"m5.4xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"m5.12xlarge": {ENIs: 8, IPv4PerENI: 30},
+	"m5.24xlarge": {ENIs: 15, IPv4PerENI: 50},
+	"p2.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"p2.8xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"p2.16xlarge": {ENIs: 8, IPv4PerENI: 30},
+	"p3.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"p3.8xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"p3.16xlarge": {ENIs: 8, IPv4PerENI: 30},
+	"r4.large":    {ENIs: 3, IPv4PerENI: 10},
+	"r4.xlarge":   {ENIs: 4, IPv4PerENI: 15},
+	"r4.2xlarge":  {ENIs: 4, IPv4PerENI: 15},
+	"r4.4xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"r4.8xlarge":  {ENIs: 8, IPv4PerENI: 30},
+	"r4.16xlarge": {ENIs: 15, IPv4PerENI: 50},
+	"t2.nano":     {ENIs: 2, IPv4PerENI: 2},
+	"t2.micro":    {ENIs: 2, IPv4PerENI: 2},
+	"t2.small":    {ENIs: 3, IPv4PerENI: 4},
+	"t2.medium":   {ENIs: 3, IPv4PerENI: 6},
+	"t2.large":    {ENIs: 3, IPv4PerENI: 12},
+	"t2.xlarge":   {ENIs: 3, IPv4PerENI: 15},
+	"t2.2xlarge":  {ENIs: 3, IPv4PerENI: 15},
+}
+
+// vpcCniIpsPerNode returns the number of subnet addresses the AWS VPC CNI plugin takes for a new
+// node of the given instance type, or 0 if the limits of the instance type are not known.
+func vpcCniIpsPerNode(instanceType string) int64 {
+	limit, found := eniLimits[instanceType]
+	if !found {
+		return 0
+	}
+	enis := limit.ENIs
+	if enis > vpcCniENIsPerNode {
+		enis = vpcCniENIsPerNode
+	}
+	return enis * limit.IPv4PerENI
+}
@@ -63,6 +63,9 @@ func (b CloudProviderBuilder) Build(discoveryOpts cloudprovider.NodeGroupDiscove
 		var gceManager gce.GceManager
 		var gceError error
 		mode := gce.ModeGCE
+		if b.autoprovisioningEnabled {
+			mode = gce.ModeGCENAP
+		}
 		if b.cloudProviderFlag == "gke" {
 			if b.autoprovisioningEnabled {
 				mode = gce.ModeGKENAP
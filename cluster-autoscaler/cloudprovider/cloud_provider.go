@@ -138,6 +138,202 @@ type NodeGroup interface {
 	Autoprovisioned() bool
 }
 
+// AsyncNodeGroup is an optional extension of NodeGroup implemented by node groups
+// whose resize is a long running operation on the cloud provider side. It allows
+// CA to start the resize without blocking and attribute its failure to the scale-up
+// that requested it.
+type AsyncNodeGroup interface {
+	NodeGroup
+
+	// IncreaseSizeAsync starts increasing the size of the node group and returns
+	// without waiting for the resize to complete. The returned string identifies
+	// the resize operation and can be passed to GetOperationStatus.
+	IncreaseSizeAsync(delta int) (string, error)
+
+	// GetOperationStatus returns the status of an operation started by IncreaseSizeAsync.
+	GetOperationStatus(operationId string) (OperationStatus, error)
+}
+
+// ConditionalNodeGroup is an optional extension of NodeGroup implemented by node groups that
+// can check their target size right before a resize. It prevents CA from repeating a resize
+// done concurrently, e.g. by another CA replica during a botched leader election.
+type ConditionalNodeGroup interface {
+	NodeGroup
+
+	// IncreaseSizeFrom increases the size of the node group by delta if its current target
+	// size is expectedSize. Otherwise it returns TargetSizeChangedError without resizing.
+	IncreaseSizeFrom(expectedSize int, delta int) error
+}
+
+// ConditionalAsyncNodeGroup is ConditionalNodeGroup for node groups resized asynchronously.
+type ConditionalAsyncNodeGroup interface {
+	AsyncNodeGroup
+
+	// IncreaseSizeAsyncFrom starts increasing the size of the node group by delta if its
+	// current target size is expectedSize. Otherwise it returns TargetSizeChangedError.
+	IncreaseSizeAsyncFrom(expectedSize int, delta int) (string, error)
+}
+
+// TargetSizeChangedError is returned by conditional resizes when the target size of the node
+// group is different than expected.
+type TargetSizeChangedError struct {
+	// NodeGroup is the id of the node group.
+	NodeGroup string
+	// Expected is the target size expected by the caller.
+	Expected int
+	// Actual is the current target size.
+	Actual int
+}
+
+// Error implements error.
+func (e *TargetSizeChangedError) Error() string {
+	return fmt.Sprintf("target size of %s changed concurrently: expected %d, found %d", e.NodeGroup, e.Expected, e.Actual)
+}
+
+// OutOfResourcesError is returned by resizes, or set as OperationStatus.Error, when the cloud provider
+// doesn't have capacity for the machines of the node group, e.g. in a zonal stockout.
+type OutOfResourcesError struct {
+	// Message describes the failure.
+	Message string
+}
+
+// Error implements error.
+func (e *OutOfResourcesError) Error() string {
+	return fmt.Sprintf("out of resources: %s", e.Message)
+}
+
+// OperationStatus describes the state of a cloud provider operation.
+type OperationStatus struct {
+	// Done is true if the operation has finished, either successfully or not.
+	Done bool
+	// Error is the reason the operation failed. Only set if Done is true.
+	Error error
+}
+
+// TemplateFingerprintNodeGroup is an optional extension of NodeGroup implemented by node groups
+// that can tell cheaply whether the result of TemplateNodeInfo has changed. It allows CA to reuse
+// template node infos instead of rebuilding them from cloud provider APIs in every loop.
+type TemplateFingerprintNodeGroup interface {
+	NodeGroup
+
+	// TemplateFingerprint returns a value that changes whenever the template the node group
+	// uses for new nodes changes. It should not require additional cloud provider API calls.
+	TemplateFingerprint() (string, error)
+}
+
+// InstanceState is the state of an instance of a node group on the cloud provider side.
+type InstanceState int
+
+const (
+	// InstanceRunning means the instance exists and counts into the size of its node group, including
+	// instances restarted or recreated by the cloud provider.
+	InstanceRunning InstanceState = iota + 1
+	// InstanceCreating means the instance is being created.
+	InstanceCreating
+	// InstanceDeleting means the instance is being deleted.
+	InstanceDeleting
+	// InstanceAbandoning means the instance is being removed from its node group without being deleted.
+	// The target size of the node group is decreased by the cloud provider.
+	InstanceAbandoning
+)
+
+// String implements fmt.Stringer.
+func (s InstanceState) String() string {
+	switch s {
+	case InstanceRunning:
+		return "running"
+	case InstanceCreating:
+		return "creating"
+	case InstanceDeleting:
+		return "deleting"
+	case InstanceAbandoning:
+		return "abandoning"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// Leaving returns true if the instance is leaving its node group and no longer counts into its size.
+func (s InstanceState) Leaving() bool {
+	return s == InstanceDeleting || s == InstanceAbandoning
+}
+
+// Instance is an instance of a node group on the cloud provider side.
+type Instance struct {
+	// Id is the provider id of the instance, as returned by Nodes.
+	Id string
+	// State is the state of the instance.
+	State InstanceState
+}
+
+// InstanceStateNodeGroup is an optional extension of NodeGroup implemented by node groups that know
+// the state of their instances. It keeps CA from mistaking instances that are being deleted or removed
+// from the node group for missing nodes. Instances of other node groups are assumed to be running.
+type InstanceStateNodeGroup interface {
+	NodeGroup
+
+	// Instances returns all instances of the node group with their states.
+	Instances() ([]Instance, error)
+}
+
+// NodeGroupInstances returns instances of the node group, with their states if the node group
+// implements InstanceStateNodeGroup.
+func NodeGroupInstances(nodeGroup NodeGroup) ([]Instance, error) {
+	if withStates, ok := nodeGroup.(InstanceStateNodeGroup); ok {
+		return withStates.Instances()
+	}
+	nodes, err := nodeGroup.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]Instance, 0, len(nodes))
+	for _, node := range nodes {
+		instances = append(instances, Instance{Id: node, State: InstanceRunning})
+	}
+	return instances, nil
+}
+
+// InstanceDeletingCloudProvider is an optional extension of CloudProvider implemented by cloud
+// providers that can delete instances which don't belong to any node group. It is used to remove
+// orphan nodes, e.g. ones added manually or left behind by a deleted node group.
+type InstanceDeletingCloudProvider interface {
+	CloudProvider
+
+	// DeleteInstance deletes the instance with the given provider id. It must refuse to delete
+	// instances that belong to a node group, those should be removed with DeleteNodes instead.
+	DeleteInstance(providerID string) error
+}
+
+// NetworkCapacityCloudProvider is an optional extension of CloudProvider implemented by cloud
+// providers that know how many addresses are left for new nodes and their pods. Without it
+// scale-up keeps adding nodes after the subnet is exhausted and the new nodes never become ready.
+type NetworkCapacityCloudProvider interface {
+	CloudProvider
+
+	// NodeGroupNetworkCapacity returns the addresses left for new nodes of the node group, or nil
+	// if it is not known. nodes are all nodes registered in the cluster.
+	NodeGroupNetworkCapacity(nodeGroup NodeGroup, nodes []*apiv1.Node) (*NetworkCapacity, error)
+}
+
+// NetworkCapacity describes the addresses left in the network new nodes of a node group take
+// their addresses from.
+type NetworkCapacity struct {
+	// Network identifies the network, e.g. a subnet or a secondary range. Node groups in the same
+	// network share its addresses.
+	Network string
+	// Addresses is the number of addresses left in the network.
+	Addresses int
+	// AddressesPerNode is the number of addresses each new node of the node group takes.
+	AddressesPerNode int
+}
+
+// MaxNodes returns how many nodes of the node group fit in the given number of addresses.
+func (c *NetworkCapacity) MaxNodes(addresses int) int {
+	if addresses <= 0 || c.AddressesPerNode <= 0 {
+		return 0
+	}
+	return addresses / c.AddressesPerNode
+}
+
 // PricingModel contains information about the node price and how it changes in time.
 type PricingModel interface {
 	// NodePrice returns a price of running the given node for a given period of time.
@@ -149,6 +345,39 @@ type PricingModel interface {
 	PodPrice(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (float64, error)
 }
 
+// PriceBreakdownPricingModel is an optional extension of PricingModel implemented by pricing models
+// that can split the pod price into per-resource components, e.g. for chargeback.
+type PriceBreakdownPricingModel interface {
+	PricingModel
+
+	// PodPriceBreakdown returns the components of the price returned by PodPrice for the same
+	// pod and period of time. The components sum up to that price.
+	PodPriceBreakdown(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (*PodPriceBreakdown, error)
+}
+
+// PodPriceBreakdown is the price of running a pod split into per-resource components, together
+// with the assumptions used to compute them. All prices are in the currency of the pricing model.
+type PodPriceBreakdown struct {
+	// CPU, Memory and GPU are the prices of the resources requested by the pod.
+	CPU    float64
+	Memory float64
+	GPU    float64
+
+	// CPUPricePerHour is the assumed price of one CPU for an hour.
+	CPUPricePerHour float64
+	// MemoryPricePerGbHour is the assumed price of one gigabyte of memory for an hour.
+	MemoryPricePerGbHour float64
+	// GPUPricePerHour is the assumed price of one GPU for an hour.
+	GPUPricePerHour float64
+	// GPUModel is the GPU model the pod requests, empty if unknown or if the pod requests no GPUs.
+	GPUModel string
+}
+
+// Total returns the sum of the price components.
+func (b *PodPriceBreakdown) Total() float64 {
+	return b.CPU + b.Memory + b.GPU
+}
+
 const (
 	// ResourceNameCores is string name for cores. It's used by ResourceLimiter.
 	ResourceNameCores = "cpu"
@@ -0,0 +1,384 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	gce "google.golang.org/api/compute/v1"
+
+	"github.com/golang/glog"
+)
+
+// Node autoprovisioning outside of GKE. Node groups are zonal MIGs created by the autoscaler
+// from a copy of the instance template of one of the MIGs given with --nodes.
+
+var (
+	gceAutoprovisioningMachineFamilies = flag.String("gce-autoprovisioning-machine-families", "n1-standard,n1-highcpu,n1-highmem",
+		"Comma-separated list of machine families (e.g. n1-standard) node autoprovisioning can create node groups with.")
+)
+
+const (
+	// napInstanceTemplateLabel is the GCE label put on instance templates created by the autoscaler.
+	// Its value is the cluster name.
+	napInstanceTemplateLabel = "cluster-autoscaler-autoprovisioned"
+	// kubeEnvMetadataKey is the key of the kube-env item in instance template metadata.
+	kubeEnvMetadataKey = "kube-env"
+	// globalOperationWaitTimeout is the timeout of instance template operations.
+	globalOperationWaitTimeout = 30 * time.Second
+)
+
+// machineFamily returns the family of the machine type, i.e. the machine type without the
+// number of cpus, e.g. n1-standard for n1-standard-4.
+func machineFamily(machineType string) string {
+	ix := strings.LastIndex(machineType, "-")
+	if ix == -1 {
+		return machineType
+	}
+	return machineType[:ix]
+}
+
+// filterMachineTypesByFamily returns machine types belonging to one of the comma-separated families.
+func filterMachineTypesByFamily(machineTypes []string, families string) []string {
+	allowed := make(map[string]bool)
+	for _, family := range strings.Split(families, ",") {
+		if family = strings.TrimSpace(family); family != "" {
+			allowed[family] = true
+		}
+	}
+	result := make([]string, 0, len(machineTypes))
+	for _, machineType := range machineTypes {
+		if allowed[machineFamily(machineType)] {
+			result = append(result, machineType)
+		}
+	}
+	return result
+}
+
+// napMigPrefix returns the name prefix of MIGs created by the autoscaler in the cluster.
+func napMigPrefix(clusterName string) string {
+	return fmt.Sprintf("%s-%s-", nodeAutoprovisioningPrefix, clusterName)
+}
+
+// napMigName returns the name of the MIG created for the autoprovisioning spec. The name is
+// the same for equal specs, so an existing MIG is reused instead of creating another one.
+func napMigName(clusterName string, spec *autoprovisioningSpec) string {
+	hash := fnv.New32a()
+	keys := make([]string, 0, len(spec.labels))
+	for key := range spec.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "label:%s=%s;", key, spec.labels[key])
+	}
+	keys = keys[:0]
+	for key := range spec.extraResources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		quantity := spec.extraResources[key]
+		fmt.Fprintf(hash, "resource:%s=%s;", key, quantity.String())
+	}
+	return fmt.Sprintf("%s%s-%08x", napMigPrefix(clusterName), spec.machineType, hash.Sum32())
+}
+
+// buildNapKubeEnv returns kube-env of the base template with NODE_LABELS set to the labels of
+// the autoprovisioning spec. Labels and taints of the base node group are dropped, as the
+// template node of the spec has neither.
+func buildNapKubeEnv(kubeEnv string, labels map[string]string) string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimRight(kubeEnv, "\n"), "\n") {
+		switch key := strings.TrimSpace(strings.SplitN(strings.TrimSpace(line), ":", 2)[0]); key {
+		case "NODE_LABELS", "NODE_TAINTS":
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels))
+		for key, value := range labels {
+			pairs = append(pairs, key+"="+value)
+		}
+		sort.Strings(pairs)
+		lines = append(lines, fmt.Sprintf("NODE_LABELS: %q", strings.Join(pairs, ",")))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// napAccelerators returns the accelerators of instances of the autoprovisioning spec. GPUs are
+// requested with nvidia.com/gpu in extra resources and their type with the accelerator label.
+func napAccelerators(spec *autoprovisioningSpec) ([]*gce.AcceleratorConfig, error) {
+	gpus, found := spec.extraResources[resourceNvidiaGPU]
+	if !found || gpus.IsZero() {
+		return nil, nil
+	}
+	acceleratorType := spec.labels[gpuLabel]
+	if acceleratorType == "" {
+		return nil, fmt.Errorf("no %s label with the type of %s requested GPUs", gpuLabel, gpus.String())
+	}
+	return []*gce.AcceleratorConfig{{AcceleratorType: acceleratorType, AcceleratorCount: gpus.Value()}}, nil
+}
+
+// buildNapInstanceTemplate builds the instance template of an autoprovisioned MIG from the template
+// of an existing MIG. Only the network, disks, service accounts and metadata other than node
+// labels and taints are copied, the rest follows the autoprovisioning spec.
+func buildNapInstanceTemplate(name, clusterName string, base *gce.InstanceTemplate, spec *autoprovisioningSpec) (*gce.InstanceTemplate, error) {
+	if base.Properties == nil || base.Properties.Metadata == nil {
+		return nil, fmt.Errorf("instance template %s has no metadata", base.Name)
+	}
+	accelerators, err := napAccelerators(spec)
+	if err != nil {
+		return nil, err
+	}
+	properties := *base.Properties
+	properties.MachineType = spec.machineType
+	properties.GuestAccelerators = accelerators
+	// Instances with GPUs can't be live migrated.
+	onHostMaintenance := "MIGRATE"
+	if len(accelerators) > 0 {
+		onHostMaintenance = "TERMINATE"
+	}
+	automaticRestart := true
+	properties.Scheduling = &gce.Scheduling{
+		AutomaticRestart:  &automaticRestart,
+		OnHostMaintenance: onHostMaintenance,
+		Preemptible:       false,
+	}
+	properties.Labels = make(map[string]string)
+	for key, value := range base.Properties.Labels {
+		properties.Labels[key] = value
+	}
+	properties.Labels[napInstanceTemplateLabel] = clusterName
+
+	metadata := *base.Properties.Metadata
+	metadata.Items = make([]*gce.MetadataItems, 0, len(base.Properties.Metadata.Items))
+	foundKubeEnv := false
+	for _, item := range base.Properties.Metadata.Items {
+		if item.Key == kubeEnvMetadataKey && item.Value != nil {
+			kubeEnv := buildNapKubeEnv(*item.Value, spec.labels)
+			item = &gce.MetadataItems{Key: item.Key, Value: &kubeEnv}
+			foundKubeEnv = true
+		}
+		metadata.Items = append(metadata.Items, item)
+	}
+	if !foundKubeEnv {
+		return nil, fmt.Errorf("no kube-env in metadata of instance template %s", base.Name)
+	}
+	properties.Metadata = &metadata
+
+	return &gce.InstanceTemplate{
+		Name:        name,
+		Description: fmt.Sprintf("Created by cluster autoscaler for cluster %s", clusterName),
+		Properties:  &properties,
+	}, nil
+}
+
+// fetchAutoprovisionedMigsGceImpl registers MIGs created by the autoscaler and unregisters
+// autoprovisioned MIGs that are gone. MIGs given with --nodes are left intact.
+func (m *gceManagerImpl) fetchAutoprovisionedMigsGceImpl() error {
+	m.assertGCENAP()
+
+	prefix := napMigPrefix(m.clusterName)
+	existingMigs := map[GceRef]struct{}{}
+	changed := false
+
+	igms, err := m.gceService.InstanceGroupManagers.List(m.projectId, m.location).Filter(fmt.Sprintf("name eq %s.*", prefix)).Do()
+	if err != nil {
+		return err
+	}
+	for _, igm := range igms.Items {
+		if !strings.HasPrefix(igm.Name, prefix) {
+			continue
+		}
+		mig := &Mig{
+			GceRef: GceRef{
+				Name:    igm.Name,
+				Zone:    m.location,
+				Project: m.projectId,
+			},
+			gceManager:      m,
+			exist:           true,
+			autoprovisioned: true,
+			nodePoolName:    igm.Name,
+			minSize:         napMinNodes,
+			maxSize:         napMaxNodes,
+		}
+		existingMigs[mig.GceRef] = struct{}{}
+		if m.RegisterMig(mig) {
+			changed = true
+		}
+	}
+	for _, mig := range m.getMigs() {
+		if _, found := existingMigs[mig.config.GceRef]; !found && mig.config.Autoprovisioned() {
+			m.UnregisterMig(mig.config)
+			changed = true
+		}
+	}
+	if changed {
+		m.cacheMutex.Lock()
+		defer m.cacheMutex.Unlock()
+
+		if err := m.regenerateCache(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createNodePoolGceImpl creates a zonal MIG for the autoprovisioned mig, or reuses the MIG created
+// for an equal spec before.
+func (m *gceManagerImpl) createNodePoolGceImpl(mig *Mig) error {
+	m.assertGCENAP()
+	if m.isRegional {
+		return fmt.Errorf("node autoprovisioning is not supported in regional clusters")
+	}
+	if mig.spec == nil {
+		return fmt.Errorf("no spec in mig %s", mig.Name)
+	}
+	name := napMigName(m.clusterName, mig.spec)
+
+	if err := m.fetchAutoprovisionedMigsGceImpl(); err != nil {
+		return err
+	}
+	if existing := m.findMigByNodePoolName(name); existing != nil {
+		glog.V(1).Infof("Reusing autoprovisioned MIG %s", existing.Id())
+		*mig = *existing
+		return nil
+	}
+
+	var base *Mig
+	for _, existing := range m.getMigs() {
+		if !existing.config.Autoprovisioned() {
+			base = existing.config
+			break
+		}
+	}
+	if base == nil {
+		return fmt.Errorf("no node group to copy the instance template of %s from", name)
+	}
+	baseTemplate, err := m.templates.getMigTemplate(base)
+	if err != nil {
+		return err
+	}
+	template, err := buildNapInstanceTemplate(name, m.clusterName, baseTemplate, mig.spec)
+	if err != nil {
+		return err
+	}
+
+	glog.V(1).Infof("Creating instance template %s based on %s", name, baseTemplate.Name)
+	templateOp, err := m.gceService.InstanceTemplates.Insert(m.projectId, template).Do()
+	if err != nil {
+		return err
+	}
+	if err := m.waitForGlobalOp(templateOp, m.projectId); err != nil {
+		return err
+	}
+
+	glog.V(1).Infof("Creating MIG %s in %s", name, m.location)
+	igm := &gce.InstanceGroupManager{
+		Name:             name,
+		BaseInstanceName: name,
+		InstanceTemplate: fmt.Sprintf(instanceTemplateUrlTemplate, m.projectId, name),
+		TargetSize:       0,
+		ForceSendFields:  []string{"TargetSize"},
+	}
+	migOp, err := m.gceService.InstanceGroupManagers.Insert(m.projectId, m.location, igm).Do()
+	if err != nil {
+		return err
+	}
+	if err := m.waitForOp(migOp, m.projectId, m.location); err != nil {
+		return err
+	}
+
+	if err := m.fetchAutoprovisionedMigsGceImpl(); err != nil {
+		return err
+	}
+	if existing := m.findMigByNodePoolName(name); existing != nil {
+		*mig = *existing
+		return nil
+	}
+	return fmt.Errorf("mig %s not found", name)
+}
+
+// deleteNodePoolGceImpl deletes the autoprovisioned MIG and the instance template created for it.
+func (m *gceManagerImpl) deleteNodePoolGceImpl(toBeRemoved *Mig) error {
+	m.assertGCENAP()
+	if !toBeRemoved.Autoprovisioned() {
+		return fmt.Errorf("only autoprovisioned node pools can be deleted")
+	}
+	igm, err := m.gceService.InstanceGroupManagers.Get(toBeRemoved.Project, toBeRemoved.Zone, toBeRemoved.Name).Do()
+	if err != nil {
+		return err
+	}
+
+	glog.V(1).Infof("Deleting MIG %s", toBeRemoved.Id())
+	migOp, err := m.gceService.InstanceGroupManagers.Delete(toBeRemoved.Project, toBeRemoved.Zone, toBeRemoved.Name).Do()
+	if err != nil {
+		return err
+	}
+	if err := m.waitForOp(migOp, toBeRemoved.Project, toBeRemoved.Zone); err != nil {
+		return err
+	}
+
+	// Templates of other MIGs are never deleted.
+	if templateName := path.Base(igm.InstanceTemplate); strings.HasPrefix(templateName, napMigPrefix(m.clusterName)) {
+		glog.V(1).Infof("Deleting instance template %s", templateName)
+		templateOp, err := m.gceService.InstanceTemplates.Delete(toBeRemoved.Project, templateName).Do()
+		if err != nil {
+			return err
+		}
+		if err := m.waitForGlobalOp(templateOp, toBeRemoved.Project); err != nil {
+			return err
+		}
+	}
+	return m.fetchAutoprovisionedMigsGceImpl()
+}
+
+func (m *gceManagerImpl) findMigByNodePoolName(nodePoolName string) *Mig {
+	for _, existing := range m.getMigs() {
+		if existing.config.nodePoolName == nodePoolName {
+			return existing.config
+		}
+	}
+	return nil
+}
+
+func (m *gceManagerImpl) waitForGlobalOp(operation *gce.Operation, project string) error {
+	for start := time.Now(); time.Since(start) < globalOperationWaitTimeout; time.Sleep(operationPollInterval) {
+		glog.V(4).Infof("Waiting for operation %s %s", project, operation.Name)
+		if op, err := m.gceService.GlobalOperations.Get(project, operation.Name).Do(); err == nil {
+			glog.V(4).Infof("Operation %s %s status: %s", project, operation.Name, op.Status)
+			if op.Status == "DONE" {
+				if op.Error != nil && len(op.Error.Errors) > 0 {
+					return fmt.Errorf("operation %s failed: %s: %s", operation.Name, op.Error.Errors[0].Code, op.Error.Errors[0].Message)
+				}
+				return nil
+			}
+		} else {
+			glog.Warningf("Error while getting operation %s on %s: %v", operation.Name, operation.TargetLink, err)
+		}
+	}
+	return fmt.Errorf("Timeout while waiting for operation %s on %s to complete.", operation.Name, operation.TargetLink)
+}
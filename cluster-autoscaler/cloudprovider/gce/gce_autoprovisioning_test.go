@@ -0,0 +1,303 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	gce "google.golang.org/api/compute/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const napInstanceGroupManager = `{
+  "kind": "compute#instanceGroupManager",
+  "name": "%s",
+  "zone": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b",
+  "instanceTemplate": "https://www.googleapis.com/compute/v1/projects/project1/global/instanceTemplates/%s",
+  "baseInstanceName": "%s",
+  "targetSize": 0
+}`
+
+const napInstanceGroupManagerList = `{
+  "kind": "compute#instanceGroupManagerList",
+  "items": [%s]
+}`
+
+const napEmptyManagedInstancesResponse = `{}`
+
+const napZoneOperationResponse = `{
+  "kind": "compute#operation",
+  "name": "operation-nap-mig",
+  "status": "DONE"
+}`
+
+const napGlobalOperationResponse = `{
+  "kind": "compute#operation",
+  "name": "operation-nap-template",
+  "status": "DONE"
+}`
+
+func napTestSpec() *autoprovisioningSpec {
+	return &autoprovisioningSpec{
+		machineType: "n1-standard-1",
+		labels:      map[string]string{"dedicated": "ml"},
+	}
+}
+
+func getNapInstanceGroupManager(name string) string {
+	return fmt.Sprintf(napInstanceGroupManager, name, name, name)
+}
+
+func getNapInstanceGroupManagerList(names ...string) string {
+	items := make([]string, 0, len(names))
+	for _, name := range names {
+		items = append(items, getNapInstanceGroupManager(name))
+	}
+	return fmt.Sprintf(napInstanceGroupManagerList, strings.Join(items, ","))
+}
+
+func TestFilterMachineTypesByFamily(t *testing.T) {
+	machineTypes := []string{"n1-standard-1", "n1-standard-2", "n1-highcpu-2", "n1-highmem-2"}
+	assert.Equal(t, []string{"n1-standard-1", "n1-standard-2", "n1-highmem-2"},
+		filterMachineTypesByFamily(machineTypes, "n1-standard, n1-highmem"))
+	assert.Equal(t, []string{}, filterMachineTypesByFamily(machineTypes, ""))
+	assert.Equal(t, autoprovisionedMachineTypes,
+		filterMachineTypesByFamily(autoprovisionedMachineTypes, *gceAutoprovisioningMachineFamilies))
+}
+
+func TestNapMigName(t *testing.T) {
+	spec := napTestSpec()
+	name := napMigName(clusterName, spec)
+	assert.True(t, strings.HasPrefix(name, "nap-cluster1-n1-standard-1-"), name)
+	assert.Equal(t, name, napMigName(clusterName, napTestSpec()))
+
+	otherLabels := napTestSpec()
+	otherLabels.labels["dedicated"] = "web"
+	assert.NotEqual(t, name, napMigName(clusterName, otherLabels))
+
+	otherResources := napTestSpec()
+	otherResources.extraResources = map[string]resource.Quantity{"nvidia.com/gpu": resource.MustParse("1")}
+	assert.NotEqual(t, name, napMigName(clusterName, otherResources))
+}
+
+func TestBuildNapKubeEnv(t *testing.T) {
+	kubeEnv := "ALLOCATE_NODE_CIDRS: \"true\"\nNODE_LABELS: \"a=b,c=d\"\nNODE_TAINTS: \"e=f:NoSchedule\"\n"
+	result := buildNapKubeEnv(kubeEnv, map[string]string{"c": "x", "dedicated": "ml"})
+	assert.Equal(t, "ALLOCATE_NODE_CIDRS: \"true\"\nNODE_LABELS: \"c=x,dedicated=ml\"\n", result)
+
+	labels, err := extractLabelsFromKubeEnv(result)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"c": "x", "dedicated": "ml"}, labels)
+	taints, err := extractTaintsFromKubeEnv(result)
+	assert.NoError(t, err)
+	assert.Empty(t, taints)
+
+	assert.Equal(t, "ALLOCATE_NODE_CIDRS: \"true\"\n", buildNapKubeEnv(kubeEnv, nil))
+}
+
+func TestNapAccelerators(t *testing.T) {
+	accelerators, err := napAccelerators(napTestSpec())
+	assert.NoError(t, err)
+	assert.Nil(t, accelerators)
+
+	spec := napTestSpec()
+	spec.extraResources = map[string]resource.Quantity{resourceNvidiaGPU: resource.MustParse("2")}
+	_, err = napAccelerators(spec)
+	assert.Error(t, err)
+
+	spec.labels[gpuLabel] = "nvidia-tesla-k80"
+	accelerators, err = napAccelerators(spec)
+	assert.NoError(t, err)
+	assert.Equal(t, []*gce.AcceleratorConfig{{AcceleratorType: "nvidia-tesla-k80", AcceleratorCount: 2}}, accelerators)
+}
+
+func TestBuildNapInstanceTemplate(t *testing.T) {
+	kubeEnv := "NODE_LABELS: \"a=b\"\nNODE_TAINTS: \"e=f:NoSchedule\"\n"
+	base := &gce.InstanceTemplate{
+		Name: "base",
+		Properties: &gce.InstanceProperties{
+			MachineType:       "n1-standard-2",
+			Labels:            map[string]string{"team": "infra"},
+			GuestAccelerators: []*gce.AcceleratorConfig{{AcceleratorType: "nvidia-tesla-p100", AcceleratorCount: 4}},
+			Scheduling:        &gce.Scheduling{OnHostMaintenance: "TERMINATE", Preemptible: true},
+			Metadata: &gce.Metadata{
+				Items: []*gce.MetadataItems{{Key: "kube-env", Value: &kubeEnv}},
+			},
+		},
+	}
+	template, err := buildNapInstanceTemplate("nap-cluster1-n1-standard-1-abc", clusterName, base, napTestSpec())
+	assert.NoError(t, err)
+	assert.Equal(t, "nap-cluster1-n1-standard-1-abc", template.Name)
+	assert.Equal(t, "n1-standard-1", template.Properties.MachineType)
+	assert.Equal(t, map[string]string{"team": "infra", napInstanceTemplateLabel: clusterName}, template.Properties.Labels)
+	// Labels, taints, accelerators and scheduling of the base template are not copied.
+	assert.Equal(t, "NODE_LABELS: \"dedicated=ml\"\n", *template.Properties.Metadata.Items[0].Value)
+	assert.Nil(t, template.Properties.GuestAccelerators)
+	assert.False(t, template.Properties.Scheduling.Preemptible)
+	assert.Equal(t, "MIGRATE", template.Properties.Scheduling.OnHostMaintenance)
+
+	// The base template is not modified.
+	assert.Equal(t, "n1-standard-2", base.Properties.MachineType)
+	assert.Equal(t, map[string]string{"team": "infra"}, base.Properties.Labels)
+	assert.Equal(t, "NODE_LABELS: \"a=b\"\nNODE_TAINTS: \"e=f:NoSchedule\"\n", kubeEnv)
+	assert.True(t, base.Properties.Scheduling.Preemptible)
+
+	gpuSpec := napTestSpec()
+	gpuSpec.labels[gpuLabel] = "nvidia-tesla-k80"
+	gpuSpec.extraResources = map[string]resource.Quantity{resourceNvidiaGPU: resource.MustParse("1")}
+	template, err = buildNapInstanceTemplate("nap-cluster1-n1-standard-1-def", clusterName, base, gpuSpec)
+	assert.NoError(t, err)
+	assert.Equal(t, []*gce.AcceleratorConfig{{AcceleratorType: "nvidia-tesla-k80", AcceleratorCount: 1}}, template.Properties.GuestAccelerators)
+	assert.Equal(t, "TERMINATE", template.Properties.Scheduling.OnHostMaintenance)
+
+	base.Properties.Metadata.Items = nil
+	_, err = buildNapInstanceTemplate("nap-cluster1-n1-standard-1-abc", clusterName, base, napTestSpec())
+	assert.Error(t, err)
+}
+
+func TestCreateNodePoolGce(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGCENAP, false)
+	setupTestNodePool(g)
+	name := napMigName(clusterName, napTestSpec())
+
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers").Return(getNapInstanceGroupManagerList()).Once()
+	// Instance template is copied from the default pool.
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool").Return(getInstanceGroupManager(zoneB)).Once()
+	server.On("handle", "/project1/global/instanceTemplates/gke-cluster-1-default-pool").Return(instanceTemplate).Once()
+	server.On("handle", "/project1/global/instanceTemplates").Return(napGlobalOperationResponse).Once()
+	server.On("handle", "/project1/global/operations/operation-nap-template").Return(napGlobalOperationResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers").Return(napZoneOperationResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/operations/operation-nap-mig").Return(napZoneOperationResponse).Once()
+	// The new MIG is registered.
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers").Return(getNapInstanceGroupManagerList(name)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/"+name).Return(getNapInstanceGroupManager(name)).Twice()
+	server.On("handle", "/project1/global/instanceTemplates/"+name).Return(instanceTemplate).Once()
+	server.On("handle", "/project1/zones/us-central1-b/machineTypes/n1-standard-1").Return(getMachineType(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool").Return(getInstanceGroupManager(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool/listManagedInstances").Return(getManagedInstancesResponse1(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/"+name+"/listManagedInstances").Return(napEmptyManagedInstancesResponse).Once()
+
+	mig := &Mig{
+		GceRef: GceRef{
+			Project: projectId,
+			Zone:    zoneB,
+			Name:    "nap-n1-standard-1-1505728466-temporary-mig",
+		},
+		gceManager:      g,
+		minSize:         0,
+		maxSize:         1000,
+		autoprovisioned: true,
+		exist:           false,
+		nodePoolName:    "nap-n1-standard-1-1505728466",
+		spec:            napTestSpec(),
+	}
+
+	err := g.createNodePool(mig)
+	assert.NoError(t, err)
+	assert.Equal(t, name, mig.Name)
+	assert.True(t, mig.Exist())
+	assert.True(t, mig.Autoprovisioned())
+	assert.Equal(t, 2, len(g.getMigs()))
+	mock.AssertExpectationsForObjects(t, server)
+}
+
+func TestCreateNodePoolGceReusesExistingMig(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGCENAP, false)
+	setupTestNodePool(g)
+	name := napMigName(clusterName, napTestSpec())
+
+	// The MIG was created before, e.g. by a previous instance of the autoscaler.
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers").Return(getNapInstanceGroupManagerList(name)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/"+name).Return(getNapInstanceGroupManager(name)).Twice()
+	server.On("handle", "/project1/global/instanceTemplates/"+name).Return(instanceTemplate).Once()
+	server.On("handle", "/project1/zones/us-central1-b/machineTypes/n1-standard-1").Return(getMachineType(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool").Return(getInstanceGroupManager(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool/listManagedInstances").Return(getManagedInstancesResponse1(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/"+name+"/listManagedInstances").Return(napEmptyManagedInstancesResponse).Once()
+
+	mig := &Mig{
+		GceRef: GceRef{
+			Project: projectId,
+			Zone:    zoneB,
+			Name:    "nap-n1-standard-1-1505728466-temporary-mig",
+		},
+		gceManager:      g,
+		autoprovisioned: true,
+		exist:           false,
+		nodePoolName:    "nap-n1-standard-1-1505728466",
+		spec:            napTestSpec(),
+	}
+
+	err := g.createNodePool(mig)
+	assert.NoError(t, err)
+	assert.Equal(t, name, mig.Name)
+	assert.True(t, mig.Exist())
+	assert.Equal(t, napMaxNodes, mig.MaxSize())
+	assert.Equal(t, 2, len(g.getMigs()))
+	mock.AssertExpectationsForObjects(t, server)
+}
+
+func TestDeleteNodePoolGce(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGCENAP, false)
+	setupTestNodePool(g)
+	name := napMigName(clusterName, napTestSpec())
+	mig := &Mig{
+		GceRef: GceRef{
+			Project: projectId,
+			Zone:    zoneB,
+			Name:    name,
+		},
+		gceManager:      g,
+		minSize:         napMinNodes,
+		maxSize:         napMaxNodes,
+		autoprovisioned: true,
+		exist:           true,
+		nodePoolName:    name,
+	}
+	g.migs = append(g.migs, &migInformation{config: mig})
+
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/"+name).Return(getNapInstanceGroupManager(name)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/"+name).Return(napZoneOperationResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/operations/operation-nap-mig").Return(napZoneOperationResponse).Once()
+	server.On("handle", "/project1/global/instanceTemplates/"+name).Return(napGlobalOperationResponse).Once()
+	server.On("handle", "/project1/global/operations/operation-nap-template").Return(napGlobalOperationResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers").Return(getNapInstanceGroupManagerList()).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool").Return(getInstanceGroupManager(zoneB)).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool/listManagedInstances").Return(getManagedInstancesResponse1(zoneB)).Once()
+
+	err := g.deleteNodePool(mig)
+	assert.NoError(t, err)
+	migs := g.getMigs()
+	assert.Equal(t, 1, len(migs))
+	assert.Equal(t, defaultPoolMig, migs[0].config.Name)
+	mock.AssertExpectationsForObjects(t, server)
+
+	err = g.deleteNodePool(migs[0].config)
+	assert.Error(t, err)
+}
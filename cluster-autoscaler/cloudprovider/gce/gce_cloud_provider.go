@@ -18,6 +18,7 @@ package gce
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -127,12 +128,42 @@ func (gce *GceCloudProvider) NodeGroupForNode(node *apiv1.Node) (cloudprovider.N
 
 // Pricing returns pricing model for this cloud provider or error if not available.
 func (gce *GceCloudProvider) Pricing() (cloudprovider.PricingModel, errors.AutoscalerError) {
-	return &GcePriceModel{}, nil
+	return NewGcePriceModel(gce.acceleratorCount, DefaultBillingPeriods), nil
+}
+
+// acceleratorCount returns the number of GPUs attached to instances of the node's MIG,
+// based on its instance template. Template nodes carry the count in an annotation, as they
+// don't have instances to look the MIG up by.
+func (gce *GceCloudProvider) acceleratorCount(node *apiv1.Node) (int64, error) {
+	if count, found := node.Annotations[acceleratorCountAnnotation]; found {
+		return strconv.ParseInt(count, 10, 64)
+	}
+	ref, err := GceRefFromProviderId(node.Spec.ProviderID)
+	if err != nil {
+		return 0, err
+	}
+	mig, err := gce.gceManager.GetMigForInstance(ref)
+	if err != nil {
+		return 0, err
+	}
+	if mig == nil {
+		return 0, fmt.Errorf("node %s doesn't belong to any MIG", node.Name)
+	}
+	templates := gce.gceManager.getTemplates()
+	template, err := templates.getMigTemplate(mig)
+	if err != nil {
+		return 0, err
+	}
+	if template.Properties == nil {
+		return 0, fmt.Errorf("instance template %s has no properties", template.Name)
+	}
+	return templates.getAcceleratorCount(template.Properties.GuestAccelerators), nil
 }
 
 // GetAvailableMachineTypes get all machine types that can be requested from the cloud provider.
+// Only machine types of the families given with --gce-autoprovisioning-machine-families are returned.
 func (gce *GceCloudProvider) GetAvailableMachineTypes() ([]string, error) {
-	return autoprovisionedMachineTypes, nil
+	return filterMachineTypesByFamily(autoprovisionedMachineTypes, *gceAutoprovisioningMachineFamilies), nil
 }
 
 // NewNodeGroup builds a theoretical node group based on the node definition provided. The node group is not automatically
@@ -182,6 +213,51 @@ func (gce *GceCloudProvider) Refresh() error {
 	return gce.gceManager.Refresh()
 }
 
+// DeleteInstance deletes the instance with the given provider id. Instances that belong to
+// a MIG are not deleted.
+func (gce *GceCloudProvider) DeleteInstance(providerID string) error {
+	if !strings.HasPrefix(providerID, "gce://") {
+		return fmt.Errorf("Wrong id: expected format gce://<project-id>/<zone>/<name>, got %v", providerID)
+	}
+	ref, err := GceRefFromProviderId(providerID)
+	if err != nil {
+		return err
+	}
+	mig, err := gce.gceManager.GetMigForInstance(ref)
+	if err != nil {
+		return err
+	}
+	if mig != nil {
+		return fmt.Errorf("Instance %s belongs to MIG %s, refusing to delete it directly", providerID, mig.Id())
+	}
+	return gce.gceManager.DeleteInstance(ref)
+}
+
+// NodeGroupNetworkCapacity returns how many pod CIDRs are left in the secondary range the MIG nodes
+// take pod CIDRs from. MIGs using routes instead of alias IP ranges are not limited.
+func (gce *GceCloudProvider) NodeGroupNetworkCapacity(nodeGroup cloudprovider.NodeGroup, nodes []*apiv1.Node) (*cloudprovider.NetworkCapacity, error) {
+	mig, ok := nodeGroup.(*Mig)
+	if !ok {
+		return nil, fmt.Errorf("node group %s is not a MIG", nodeGroup.Id())
+	}
+	podRange, err := gce.gceManager.GetMigPodRange(mig)
+	if err != nil {
+		return nil, err
+	}
+	if podRange == "" {
+		return nil, nil
+	}
+	capacity, err := podRangeCapacity(podRange, nodes)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudprovider.NetworkCapacity{
+		Network:          podRange,
+		Addresses:        capacity,
+		AddressesPerNode: 1,
+	}, nil
+}
+
 // GceRef contains s reference to some entity in GCE/GKE world.
 type GceRef struct {
 	Project string
@@ -247,17 +323,62 @@ func (mig *Mig) TargetSize() (int, error) {
 
 // IncreaseSize increases Mig size
 func (mig *Mig) IncreaseSize(delta int) error {
+	newSize, err := mig.sizeAfterIncrease(anyTargetSize, delta)
+	if err != nil {
+		return err
+	}
+	return mig.gceManager.SetMigSize(mig, newSize)
+}
+
+// IncreaseSizeFrom increases Mig size if its target size is still expectedSize.
+func (mig *Mig) IncreaseSizeFrom(expectedSize int, delta int) error {
+	newSize, err := mig.sizeAfterIncrease(expectedSize, delta)
+	if err != nil {
+		return err
+	}
+	return mig.gceManager.SetMigSize(mig, newSize)
+}
+
+// IncreaseSizeAsync starts Mig resize and returns the name of the resize operation.
+func (mig *Mig) IncreaseSizeAsync(delta int) (string, error) {
+	return mig.IncreaseSizeAsyncFrom(anyTargetSize, delta)
+}
+
+// IncreaseSizeAsyncFrom starts Mig resize if its target size is still expectedSize and returns
+// the name of the resize operation.
+func (mig *Mig) IncreaseSizeAsyncFrom(expectedSize int, delta int) (string, error) {
+	newSize, err := mig.sizeAfterIncrease(expectedSize, delta)
+	if err != nil {
+		return "", err
+	}
+	return mig.gceManager.SetMigSizeAsync(mig, newSize)
+}
+
+// GetOperationStatus returns the status of a resize operation started by IncreaseSizeAsync.
+func (mig *Mig) GetOperationStatus(operationId string) (cloudprovider.OperationStatus, error) {
+	return mig.gceManager.GetMigOperationStatus(mig, operationId)
+}
+
+// anyTargetSize passed as the expected size to sizeAfterIncrease skips the target size check.
+const anyTargetSize = -1
+
+// sizeAfterIncrease reads the current target size, so that it is as fresh as possible when the
+// resize is requested. Instance group manager resizes don't support preconditions.
+func (mig *Mig) sizeAfterIncrease(expectedSize int, delta int) (int64, error) {
 	if delta <= 0 {
-		return fmt.Errorf("size increase must be positive")
+		return 0, fmt.Errorf("size increase must be positive")
 	}
 	size, err := mig.gceManager.GetMigSize(mig)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if expectedSize != anyTargetSize && int(size) != expectedSize {
+		return 0, &cloudprovider.TargetSizeChangedError{NodeGroup: mig.Id(), Expected: expectedSize, Actual: int(size)}
 	}
 	if int(size)+delta > mig.MaxSize() {
-		return fmt.Errorf("size increase too large - desired:%d max:%d", int(size)+delta, mig.MaxSize())
+		return 0, fmt.Errorf("size increase too large - desired:%d max:%d", int(size)+delta, mig.MaxSize())
 	}
-	return mig.gceManager.SetMigSize(mig, size+int64(delta))
+	return size + int64(delta), nil
 }
 
 // DecreaseTargetSize decreases the target size of the node group. This function
@@ -271,13 +392,20 @@ func (mig *Mig) DecreaseTargetSize(delta int) error {
 	if err != nil {
 		return err
 	}
-	nodes, err := mig.gceManager.GetMigNodes(mig)
+	instances, err := mig.gceManager.GetMigInstances(mig)
 	if err != nil {
 		return err
 	}
-	if int(size)+delta < len(nodes) {
+	// Instances being deleted or abandoned are already excluded from the target size.
+	existing := 0
+	for _, instance := range instances {
+		if !instance.State.Leaving() {
+			existing++
+		}
+	}
+	if int(size)+delta < existing {
 		return fmt.Errorf("attempt to delete existing nodes targetSize:%d delta:%d existingNodes: %d",
-			size, delta, len(nodes))
+			size, delta, existing)
 	}
 	return mig.gceManager.SetMigSize(mig, size+int64(delta))
 }
@@ -344,6 +472,11 @@ func (mig *Mig) Nodes() ([]string, error) {
 	return mig.gceManager.GetMigNodes(mig)
 }
 
+// Instances returns all instances of the mig with their states.
+func (mig *Mig) Instances() ([]cloudprovider.Instance, error) {
+	return mig.gceManager.GetMigInstances(mig)
+}
+
 // Exist checks if the node group really exists on the cloud provider side. Allows to tell the
 // theoretical node group from the real one.
 func (mig *Mig) Exist() bool {
@@ -398,6 +531,15 @@ func (mig *Mig) TemplateNodeInfo() (*schedulercache.NodeInfo, error) {
 	return nodeInfo, nil
 }
 
+// TemplateFingerprint returns the URL of the instance template used by the MIG. It is
+// updated whenever the MIG size is fetched.
+func (mig *Mig) TemplateFingerprint() (string, error) {
+	if !mig.Exist() {
+		return "", fmt.Errorf("mig %s doesn't exist", mig.Id())
+	}
+	return mig.gceManager.GetMigTemplateUrl(mig)
+}
+
 func buildMig(value string, gceManager GceManager) (*Mig, error) {
 	spec, err := dynamic.SpecFromString(value, true)
 
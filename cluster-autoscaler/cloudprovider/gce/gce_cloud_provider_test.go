@@ -57,11 +57,36 @@ func (m *gceManagerMock) SetMigSize(mig *Mig, size int64) error {
 	return args.Error(0)
 }
 
+func (m *gceManagerMock) SetMigSizeAsync(mig *Mig, size int64) (string, error) {
+	args := m.Called(mig, size)
+	return args.String(0), args.Error(1)
+}
+
+func (m *gceManagerMock) GetMigOperationStatus(mig *Mig, operationName string) (cloudprovider.OperationStatus, error) {
+	args := m.Called(mig, operationName)
+	return args.Get(0).(cloudprovider.OperationStatus), args.Error(1)
+}
+
+func (m *gceManagerMock) GetMigTemplateUrl(mig *Mig) (string, error) {
+	args := m.Called(mig)
+	return args.String(0), args.Error(1)
+}
+
 func (m *gceManagerMock) DeleteInstances(instances []*GceRef) error {
 	args := m.Called(instances)
 	return args.Error(0)
 }
 
+func (m *gceManagerMock) DeleteInstance(instance *GceRef) error {
+	args := m.Called(instance)
+	return args.Error(0)
+}
+
+func (m *gceManagerMock) GetMigPodRange(mig *Mig) (string, error) {
+	args := m.Called(mig)
+	return args.String(0), args.Error(1)
+}
+
 func (m *gceManagerMock) GetMigForInstance(instance *GceRef) (*Mig, error) {
 	args := m.Called(instance)
 	return args.Get(0).(*Mig), args.Error(1)
@@ -72,6 +97,11 @@ func (m *gceManagerMock) GetMigNodes(mig *Mig) ([]string, error) {
 	return args.Get(0).([]string), args.Error(1)
 }
 
+func (m *gceManagerMock) GetMigInstances(mig *Mig) ([]cloudprovider.Instance, error) {
+	args := m.Called(mig)
+	return args.Get(0).([]cloudprovider.Instance), args.Error(1)
+}
+
 func (m *gceManagerMock) Refresh() error {
 	args := m.Called()
 	return args.Error(0)
@@ -193,6 +223,68 @@ func TestNodeGroupForNode(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, gceManagerMock)
 }
 
+func TestAcceleratorCountOfTemplateNode(t *testing.T) {
+	gceManagerMock := &gceManagerMock{}
+	gce := &GceCloudProvider{
+		gceManager: gceManagerMock,
+	}
+	// Template nodes have no instance, the count from the instance template is used without
+	// looking up the MIG.
+	n := BuildTestNode("ng1-template", 1000, 1000)
+	n.Annotations = map[string]string{acceleratorCountAnnotation: "2"}
+
+	count, err := gce.acceleratorCount(n)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+}
+
+func TestDeleteInstance(t *testing.T) {
+	gceManagerMock := &gceManagerMock{}
+	gce := &GceCloudProvider{
+		gceManager: gceManagerMock,
+	}
+	ref := &GceRef{Project: "project1", Zone: "us-central1-b", Name: "n1"}
+
+	// Instance outside of MIGs.
+	gceManagerMock.On("GetMigForInstance", ref).Return((*Mig)(nil), nil).Once()
+	gceManagerMock.On("DeleteInstance", ref).Return(nil).Once()
+	assert.NoError(t, gce.DeleteInstance("gce://project1/us-central1-b/n1"))
+
+	// Instance in a MIG.
+	mig := &Mig{GceRef: GceRef{Project: "project1", Zone: "us-central1-b", Name: "ng1"}}
+	gceManagerMock.On("GetMigForInstance", ref).Return(mig, nil).Once()
+	assert.Error(t, gce.DeleteInstance("gce://project1/us-central1-b/n1"))
+
+	// Not a GCE instance.
+	assert.Error(t, gce.DeleteInstance("aws:///us-east-1a/n1"))
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+	gceManagerMock.AssertNumberOfCalls(t, "DeleteInstance", 1)
+}
+
+func TestNodeGroupNetworkCapacity(t *testing.T) {
+	gceManagerMock := &gceManagerMock{}
+	gce := &GceCloudProvider{
+		gceManager: gceManagerMock,
+	}
+	mig := &Mig{GceRef: GceRef{Project: "project1", Zone: "us-central1-b", Name: "ng1"}}
+	nodes := []*apiv1.Node{{Spec: apiv1.NodeSpec{PodCIDR: "10.4.0.0/24"}}}
+
+	// Routes based cluster.
+	gceManagerMock.On("GetMigPodRange", mig).Return("", nil).Once()
+	capacity, err := gce.NodeGroupNetworkCapacity(mig, nodes)
+	assert.NoError(t, err)
+	assert.Nil(t, capacity)
+
+	// Alias IP ranges.
+	gceManagerMock.On("GetMigPodRange", mig).Return("10.4.0.0/23", nil).Once()
+	capacity, err = gce.NodeGroupNetworkCapacity(mig, nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, &cloudprovider.NetworkCapacity{Network: "10.4.0.0/23", Addresses: 1, AddressesPerNode: 1}, capacity)
+
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+}
+
 func TestGetResourceLimiter(t *testing.T) {
 	gceManagerMock := &gceManagerMock{}
 	resourceLimiter := cloudprovider.NewResourceLimiter(
@@ -413,16 +505,67 @@ func TestMig(t *testing.T) {
 	assert.Equal(t, "size increase too large - desired:1002 max:1000", err.Error())
 	mock.AssertExpectationsForObjects(t, gceManagerMock)
 
+	// Test IncreaseSizeAsync.
+	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(2), nil).Once()
+	gceManagerMock.On("SetMigSizeAsync", mock.AnythingOfType("*gce.Mig"), int64(3)).Return("operation-1", nil).Once()
+	operationId, err := mig1.IncreaseSizeAsync(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "operation-1", operationId)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+
+	// Test IncreaseSizeFrom - fail when the size was increased concurrently.
+	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(3), nil).Once()
+	err = mig1.IncreaseSizeFrom(2, 1)
+	assert.Equal(t, &cloudprovider.TargetSizeChangedError{NodeGroup: mig1.Id(), Expected: 2, Actual: 3}, err)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+
+	// Test IncreaseSizeFrom.
+	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(3), nil).Once()
+	gceManagerMock.On("SetMigSize", mock.AnythingOfType("*gce.Mig"), int64(4)).Return(nil).Once()
+	err = mig1.IncreaseSizeFrom(3, 1)
+	assert.NoError(t, err)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+
+	// Test IncreaseSizeAsyncFrom - fail when the size was increased concurrently.
+	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(4), nil).Once()
+	_, err = mig1.IncreaseSizeAsyncFrom(3, 1)
+	assert.Equal(t, &cloudprovider.TargetSizeChangedError{NodeGroup: mig1.Id(), Expected: 3, Actual: 4}, err)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+
+	// Test GetOperationStatus.
+	gceManagerMock.On("GetMigOperationStatus", mock.AnythingOfType("*gce.Mig"), "operation-1").Return(
+		cloudprovider.OperationStatus{Done: true}, nil).Once()
+	status, err := mig1.GetOperationStatus("operation-1")
+	assert.NoError(t, err)
+	assert.True(t, status.Done)
+	assert.NoError(t, status.Error)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+
 	// Test DecreaseTargetSize.
 	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(3), nil).Once()
-	gceManagerMock.On("GetMigNodes", mock.AnythingOfType("*gce.Mig")).Return(
-		[]string{"gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-9j4g",
-			"gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-dck1"}, nil).Once()
+	gceManagerMock.On("GetMigInstances", mock.AnythingOfType("*gce.Mig")).Return(
+		[]cloudprovider.Instance{
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-9j4g", State: cloudprovider.InstanceRunning},
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-dck1", State: cloudprovider.InstanceRunning},
+		}, nil).Once()
 	gceManagerMock.On("SetMigSize", mock.AnythingOfType("*gce.Mig"), int64(2)).Return(nil).Once()
 	err = mig1.DecreaseTargetSize(-1)
 	assert.NoError(t, err)
 	mock.AssertExpectationsForObjects(t, gceManagerMock)
 
+	// Test DecreaseTargetSize - instances being deleted or abandoned are not existing nodes.
+	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(2), nil).Once()
+	gceManagerMock.On("GetMigInstances", mock.AnythingOfType("*gce.Mig")).Return(
+		[]cloudprovider.Instance{
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-9j4g", State: cloudprovider.InstanceRunning},
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-dck1", State: cloudprovider.InstanceDeleting},
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-f1hm", State: cloudprovider.InstanceAbandoning},
+		}, nil).Once()
+	gceManagerMock.On("SetMigSize", mock.AnythingOfType("*gce.Mig"), int64(1)).Return(nil).Once()
+	err = mig1.DecreaseTargetSize(-1)
+	assert.NoError(t, err)
+	mock.AssertExpectationsForObjects(t, gceManagerMock)
+
 	// Test DecreaseTargetSize - fail on positive delta.
 	err = mig1.DecreaseTargetSize(1)
 	assert.Error(t, err)
@@ -430,9 +573,11 @@ func TestMig(t *testing.T) {
 
 	// Test DecreaseTargetSize - fail on deleting existing nodes.
 	gceManagerMock.On("GetMigSize", mock.AnythingOfType("*gce.Mig")).Return(int64(3), nil).Once()
-	gceManagerMock.On("GetMigNodes", mock.AnythingOfType("*gce.Mig")).Return(
-		[]string{"gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-9j4g",
-			"gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-dck1"}, nil).Once()
+	gceManagerMock.On("GetMigInstances", mock.AnythingOfType("*gce.Mig")).Return(
+		[]cloudprovider.Instance{
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-9j4g", State: cloudprovider.InstanceRunning},
+			{Id: "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-dck1", State: cloudprovider.InstanceCreating},
+		}, nil).Once()
 
 	err = mig1.DecreaseTargetSize(-2)
 	assert.Error(t, err)
@@ -59,6 +59,10 @@ const (
 	// ModeGKENAP means that the cluster is running on GKE with autoprovisioning enabled.
 	// TODO(maciekpytel): remove this when NAP API is available in normal client
 	ModeGKENAP GcpCloudProviderMode = "gke_nap"
+
+	// ModeGCENAP means that the cluster is running on gce with autoprovisioning enabled. Node
+	// groups are created by the autoscaler as zonal MIGs.
+	ModeGCENAP GcpCloudProviderMode = "gce_nap"
 )
 
 const (
@@ -69,6 +73,12 @@ const (
 	nodeAutoprovisioningPrefix = "nap"
 	napMaxNodes                = 1000
 	napMinNodes                = 0
+	// createdByMetadataKey is the instance metadata key GCE sets to the URL of the MIG that
+	// created the instance.
+	createdByMetadataKey = "created-by"
+	// podRangeCacheTTL is how long the secondary range of a MIG is cached. Ranges change only when
+	// the instance template of the MIG is replaced.
+	podRangeCacheTTL = 10 * time.Minute
 )
 
 var (
@@ -95,12 +105,26 @@ type GceManager interface {
 	GetMigSize(mig *Mig) (int64, error)
 	// SetMigSize sets MIG size.
 	SetMigSize(mig *Mig, size int64) error
+	// SetMigSizeAsync starts MIG resize and returns the name of the resize operation without waiting for it.
+	SetMigSizeAsync(mig *Mig, size int64) (string, error)
+	// GetMigOperationStatus returns the status of the given operation in the zone of the MIG.
+	GetMigOperationStatus(mig *Mig, operationName string) (cloudprovider.OperationStatus, error)
+	// GetMigTemplateUrl returns the URL of the instance template used by the MIG, as seen by
+	// the last GetMigSize call.
+	GetMigTemplateUrl(mig *Mig) (string, error)
 	// DeleteInstances deletes the given instances. All instances must be controlled by the same MIG.
 	DeleteInstances(instances []*GceRef) error
+	// DeleteInstance deletes the given instance directly, bypassing MIGs.
+	DeleteInstance(instance *GceRef) error
 	// GetMigForInstance returns MigConfig of the given Instance
 	GetMigForInstance(instance *GceRef) (*Mig, error)
+	// GetMigPodRange returns the CIDR of the secondary range pod CIDRs of the MIG nodes are taken
+	// from, or an empty string if the instances of the MIG don't use alias IP ranges.
+	GetMigPodRange(mig *Mig) (string, error)
 	// GetMigNodes returns mig nodes.
 	GetMigNodes(mig *Mig) ([]string, error)
+	// GetMigInstances returns instances of the mig with states derived from their current actions.
+	GetMigInstances(mig *Mig) ([]cloudprovider.Instance, error)
 	// Refresh updates config by calling GKE API (in GKE mode only).
 	Refresh() error
 	// GetResourceLimiter returns resource limiter.
@@ -120,14 +144,20 @@ type GceManager interface {
 type gceManagerImpl struct {
 	migs     []*migInformation
 	migCache map[GceRef]*Mig
+	// migTemplateUrls contains instance template URLs returned by the last GetMigSize call for each MIG.
+	migTemplateUrls map[GceRef]string
+	// podRanges contains secondary ranges returned by GetMigPodRange for each MIG.
+	podRanges map[GceRef]cachedPodRange
 
 	gceService      *gce.Service
 	gkeService      *gke.Service
 	gkeAlphaService *gke_alpha.Service
 	gkeBetaService  *gke_beta.Service
 
-	cacheMutex sync.Mutex
-	migsMutex  sync.Mutex
+	cacheMutex        sync.Mutex
+	migsMutex         sync.Mutex
+	templateUrlsMutex sync.Mutex
+	podRangesMutex    sync.Mutex
 
 	location        string
 	projectId       string
@@ -260,6 +290,15 @@ func CreateGceManager(configReader io.Reader, mode GcpCloudProviderMode, cluster
 		glog.V(1).Info("Using GKE-NAP mode")
 	}
 
+	if mode == ModeGCENAP {
+		err = manager.fetchAllNodePools()
+		if err != nil {
+			glog.Errorf("Failed to fetch autoprovisioned MIGs: %v", err)
+			return nil, err
+		}
+		glog.V(1).Info("Using GCE-NAP mode")
+	}
+
 	manager.lastRefresh = time.Now()
 
 	go wait.Until(func() {
@@ -295,10 +334,19 @@ func (m *gceManagerImpl) assertGKENAP() {
 	}
 }
 
+func (m *gceManagerImpl) assertGCENAP() {
+	if m.mode != ModeGCENAP {
+		glog.Fatalf("This should run only in GCE mode with autoprovisioning enabled")
+	}
+}
+
 func (m *gceManagerImpl) fetchAllNodePools() error {
 	if m.mode == ModeGKENAP {
 		return m.fetchAllNodePoolsGkeNapImpl()
 	}
+	if m.mode == ModeGCENAP {
+		return m.fetchAutoprovisionedMigsGceImpl()
+	}
 	if m.isRegional {
 		return m.fetchAllNodePoolsGkeRegionalImpl()
 	}
@@ -546,6 +594,9 @@ func (m *gceManagerImpl) UnregisterMig(toBeRemoved *Mig) bool {
 }
 
 func (m *gceManagerImpl) deleteNodePool(toBeRemoved *Mig) error {
+	if m.mode == ModeGCENAP {
+		return m.deleteNodePoolGceImpl(toBeRemoved)
+	}
 	m.assertGKENAP()
 	if !toBeRemoved.Autoprovisioned() {
 		return fmt.Errorf("only autoprovisioned node pools can be deleted")
@@ -564,6 +615,9 @@ func (m *gceManagerImpl) deleteNodePool(toBeRemoved *Mig) error {
 }
 
 func (m *gceManagerImpl) createNodePool(mig *Mig) error {
+	if m.mode == ModeGCENAP {
+		return m.createNodePoolGceImpl(mig)
+	}
 	m.assertGKENAP()
 
 	// TODO: handle preemptable
@@ -622,9 +676,26 @@ func (m *gceManagerImpl) GetMigSize(mig *Mig) (int64, error) {
 	if err != nil {
 		return -1, err
 	}
+	m.templateUrlsMutex.Lock()
+	defer m.templateUrlsMutex.Unlock()
+	if m.migTemplateUrls == nil {
+		m.migTemplateUrls = make(map[GceRef]string)
+	}
+	m.migTemplateUrls[mig.GceRef] = igm.InstanceTemplate
 	return igm.TargetSize, nil
 }
 
+// GetMigTemplateUrl returns the URL of the instance template used by the MIG.
+func (m *gceManagerImpl) GetMigTemplateUrl(mig *Mig) (string, error) {
+	m.templateUrlsMutex.Lock()
+	defer m.templateUrlsMutex.Unlock()
+	templateUrl, found := m.migTemplateUrls[mig.GceRef]
+	if !found {
+		return "", fmt.Errorf("instance template of %s is not known yet", mig.Id())
+	}
+	return templateUrl, nil
+}
+
 // SetMigSize sets MIG size.
 func (m *gceManagerImpl) SetMigSize(mig *Mig, size int64) error {
 	glog.V(0).Infof("Setting mig size %s to %d", mig.Id(), size)
@@ -635,6 +706,48 @@ func (m *gceManagerImpl) SetMigSize(mig *Mig, size int64) error {
 	return m.waitForOp(op, mig.Project, mig.Zone)
 }
 
+// SetMigSizeAsync starts MIG resize and returns the name of the resize operation without waiting for it.
+func (m *gceManagerImpl) SetMigSizeAsync(mig *Mig, size int64) (string, error) {
+	glog.V(0).Infof("Setting mig size %s to %d", mig.Id(), size)
+	op, err := m.gceService.InstanceGroupManagers.Resize(mig.Project, mig.Zone, mig.Name, size).Do()
+	if err != nil {
+		return "", err
+	}
+	return op.Name, nil
+}
+
+// isResourcePoolExhausted returns true if the error code of an operation means that the zone
+// doesn't have capacity for the requested machines.
+func isResourcePoolExhausted(code string) bool {
+	return code == "ZONE_RESOURCE_POOL_EXHAUSTED" || code == "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS"
+}
+
+// GetMigOperationStatus returns the status of the given operation in the zone of the MIG.
+func (m *gceManagerImpl) GetMigOperationStatus(mig *Mig, operationName string) (cloudprovider.OperationStatus, error) {
+	op, err := m.gceService.ZoneOperations.Get(mig.Project, mig.Zone, operationName).Do()
+	if err != nil {
+		return cloudprovider.OperationStatus{}, err
+	}
+	glog.V(4).Infof("Operation %s %s %s status: %s", mig.Project, mig.Zone, operationName, op.Status)
+	if op.Status != "DONE" {
+		return cloudprovider.OperationStatus{Done: false}, nil
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		opErr := op.Error.Errors[0]
+		if isResourcePoolExhausted(opErr.Code) {
+			return cloudprovider.OperationStatus{
+				Done:  true,
+				Error: &cloudprovider.OutOfResourcesError{Message: fmt.Sprintf("operation %s failed: %s: %s", operationName, opErr.Code, opErr.Message)},
+			}, nil
+		}
+		return cloudprovider.OperationStatus{
+			Done:  true,
+			Error: fmt.Errorf("operation %s failed: %s: %s", operationName, opErr.Code, opErr.Message),
+		}, nil
+	}
+	return cloudprovider.OperationStatus{Done: true}, nil
+}
+
 // GCE
 func (m *gceManagerImpl) waitForOp(operation *gce.Operation, project string, zone string) error {
 	for start := time.Now(); time.Since(start) < operationWaitTimeout; time.Sleep(operationPollInterval) {
@@ -700,6 +813,84 @@ func (m *gceManagerImpl) DeleteInstances(instances []*GceRef) error {
 	return m.waitForOp(op, commonMig.Project, commonMig.Zone)
 }
 
+// DeleteInstance deletes the given instance directly, bypassing MIGs.
+func (m *gceManagerImpl) DeleteInstance(instance *GceRef) error {
+	// Instances of MIGs not known to CA have to be left alone too, as the MIG would recreate them.
+	gceInstance, err := m.gceService.Instances.Get(instance.Project, instance.Zone, instance.Name).Do()
+	if err != nil {
+		return err
+	}
+	if gceInstance.Metadata != nil {
+		for _, item := range gceInstance.Metadata.Items {
+			if item.Key == createdByMetadataKey && item.Value != nil && strings.Contains(*item.Value, "/instanceGroupManagers/") {
+				return fmt.Errorf("Instance %s was created by %s, refusing to delete it directly", instance.Name, *item.Value)
+			}
+		}
+	}
+	op, err := m.gceService.Instances.Delete(instance.Project, instance.Zone, instance.Name).Do()
+	if err != nil {
+		return err
+	}
+	return m.waitForOp(op, instance.Project, instance.Zone)
+}
+
+type cachedPodRange struct {
+	podRange string
+	fetched  time.Time
+}
+
+// GetMigPodRange returns the CIDR of the secondary range pod CIDRs of the MIG nodes are taken
+// from, or an empty string if the instances of the MIG don't use alias IP ranges. Ranges are
+// cached for podRangeCacheTTL.
+func (m *gceManagerImpl) GetMigPodRange(mig *Mig) (string, error) {
+	m.podRangesMutex.Lock()
+	defer m.podRangesMutex.Unlock()
+	if cached, found := m.podRanges[mig.GceRef]; found && time.Since(cached.fetched) < podRangeCacheTTL {
+		return cached.podRange, nil
+	}
+	podRange, err := m.fetchMigPodRange(mig)
+	if err != nil {
+		return "", err
+	}
+	if m.podRanges == nil {
+		m.podRanges = make(map[GceRef]cachedPodRange)
+	}
+	m.podRanges[mig.GceRef] = cachedPodRange{podRange: podRange, fetched: time.Now()}
+	return podRange, nil
+}
+
+func (m *gceManagerImpl) fetchMigPodRange(mig *Mig) (string, error) {
+	template, err := m.templates.getMigTemplate(mig)
+	if err != nil {
+		return "", err
+	}
+	if template.Properties == nil {
+		return "", nil
+	}
+	for _, networkInterface := range template.Properties.NetworkInterfaces {
+		for _, aliasRange := range networkInterface.AliasIpRanges {
+			if aliasRange.SubnetworkRangeName == "" || networkInterface.Subnetwork == "" {
+				continue
+			}
+			project, region, name, err := ParseSubnetworkUrl(networkInterface.Subnetwork)
+			if err != nil {
+				return "", err
+			}
+			subnetwork, err := m.gceService.Subnetworks.Get(project, region, name).Do()
+			if err != nil {
+				return "", err
+			}
+			for _, secondaryRange := range subnetwork.SecondaryIpRanges {
+				if secondaryRange.RangeName == aliasRange.SubnetworkRangeName {
+					return secondaryRange.IpCidrRange, nil
+				}
+			}
+			return "", fmt.Errorf("secondary range %s not found in subnetwork %s", aliasRange.SubnetworkRangeName, name)
+		}
+	}
+	return "", nil
+}
+
 func (m *gceManagerImpl) getMigs() []*migInformation {
 	m.migsMutex.Lock()
 	defer m.migsMutex.Unlock()
@@ -780,21 +971,55 @@ func (m *gceManagerImpl) regenerateCache() error {
 
 // GetMigNodes returns mig nodes.
 func (m *gceManagerImpl) GetMigNodes(mig *Mig) ([]string, error) {
-	instances, err := m.gceService.InstanceGroupManagers.ListManagedInstances(mig.Project, mig.Zone, mig.Name).Do()
+	instances, err := m.GetMigInstances(mig)
 	if err != nil {
 		return []string{}, err
 	}
-	result := make([]string, 0)
+	result := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		result = append(result, instance.Id)
+	}
+	return result, nil
+}
+
+// GetMigInstances returns instances of the mig with states derived from their current actions.
+func (m *gceManagerImpl) GetMigInstances(mig *Mig) ([]cloudprovider.Instance, error) {
+	instances, err := m.gceService.InstanceGroupManagers.ListManagedInstances(mig.Project, mig.Zone, mig.Name).Do()
+	if err != nil {
+		return []cloudprovider.Instance{}, err
+	}
+	result := make([]cloudprovider.Instance, 0)
 	for _, instance := range instances.ManagedInstances {
 		project, zone, name, err := ParseInstanceUrl(instance.Instance)
 		if err != nil {
-			return []string{}, err
+			return []cloudprovider.Instance{}, err
 		}
-		result = append(result, fmt.Sprintf("gce://%s/%s/%s", project, zone, name))
+		result = append(result, cloudprovider.Instance{
+			Id:    fmt.Sprintf("gce://%s/%s/%s", project, zone, name),
+			State: instanceStateFromAction(instance.CurrentAction),
+		})
 	}
 	return result, nil
 }
 
+// instanceStateFromAction maps the current action of a managed instance to the state of the instance.
+// Instances recreated by autohealing or refreshed keep their place in the MIG, so they are running.
+// Abandoning removes the instance from the MIG and decreases its target size, like deleting does.
+func instanceStateFromAction(action string) cloudprovider.InstanceState {
+	switch action {
+	case "CREATING", "CREATING_WITHOUT_RETRIES", "VERIFYING":
+		return cloudprovider.InstanceCreating
+	case "DELETING":
+		return cloudprovider.InstanceDeleting
+	case "ABANDONING":
+		return cloudprovider.InstanceAbandoning
+	case "NONE", "RECREATING", "REFRESHING", "RESTARTING":
+		return cloudprovider.InstanceRunning
+	}
+	glog.Warningf("Unknown managed instance action %q, assuming the instance is running", action)
+	return cloudprovider.InstanceRunning
+}
+
 func (m *gceManagerImpl) getLocation() string {
 	return m.location
 }
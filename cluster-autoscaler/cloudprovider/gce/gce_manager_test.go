@@ -19,8 +19,10 @@ package gce
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 
 	"github.com/stretchr/testify/assert"
@@ -940,6 +942,44 @@ func TestDeleteInstances(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, server)
 }
 
+const instanceCreatedByMig = `{
+  "kind": "compute#instance",
+  "name": "%s",
+  "metadata": {
+    "items": [
+      {
+        "key": "created-by",
+        "value": "projects/123456789/zones/us-central1-b/instanceGroupManagers/other-pool"
+      }
+    ]
+  }
+}`
+
+const standaloneInstance = `{
+  "kind": "compute#instance",
+  "name": "%s",
+  "metadata": {}
+}`
+
+func TestDeleteStandaloneInstance(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGCE, false)
+
+	// Instances of MIGs not known to CA are not deleted.
+	server.On("handle", "/project1/zones/us-central1-b/instances/in-mig").Return(fmt.Sprintf(instanceCreatedByMig, "in-mig")).Once()
+	err := g.DeleteInstance(&GceRef{Project: projectId, Zone: zoneB, Name: "in-mig"})
+	assert.Error(t, err)
+	mock.AssertExpectationsForObjects(t, server)
+
+	server.On("handle", "/project1/zones/us-central1-b/instances/standalone").Return(fmt.Sprintf(standaloneInstance, "standalone")).Once()
+	server.On("handle", "/project1/zones/us-central1-b/instances/standalone").Return(deleteInstancesResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/operations/operation-1505802641136-55984ff86d980-a99e8c2b-0c8aaaaa").Return(deleteInstancesOperationResponse).Once()
+	err = g.DeleteInstance(&GceRef{Project: projectId, Zone: zoneB, Name: "standalone"})
+	assert.NoError(t, err)
+	mock.AssertExpectationsForObjects(t, server)
+}
+
 func TestGetMigSize(t *testing.T) {
 	server := NewHttpServerMock()
 	defer server.Close()
@@ -1028,6 +1068,104 @@ func TestSetMigSize(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, server)
 }
 
+const setMigSizeOperationFailedResponse = `{
+  "kind": "compute#operation",
+  "id": "7558996788000226430",
+  "name": "operation-1505739408819-5597646964339-eb839c88-28805931",
+  "zone": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-a",
+  "operationType": "compute.instanceGroupManagers.resize",
+  "targetLink": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-a/instanceGroupManagers/gke-cluster-1-default-pool-f7607aac-grp",
+  "targetId": "5382990249302819619",
+  "status": "DONE",
+  "error": {
+    "errors": [
+      {
+        "code": "QUOTA_EXCEEDED",
+        "message": "Quota 'CPUS' exceeded. Limit: 24.0 in region us-central1."
+      }
+    ]
+  },
+  "user": "user@example.com",
+  "progress": 100,
+  "insertTime": "2017-09-18T05:56:49.227-07:00",
+  "startTime": "2017-09-18T05:56:49.230-07:00",
+  "endTime": "2017-09-18T05:56:49.230-07:00",
+  "selfLink": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-a/operations/operation-1505739408819-5597646964339-eb839c88-28805931"
+}`
+
+func TestSetMigSizeAsync(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGKE, false)
+
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/nodeautoprovisioning-323233232/resize").Return(setMigSizeResponse).Once()
+
+	mig := &Mig{
+		GceRef: GceRef{
+			Project: projectId,
+			Zone:    zoneB,
+			Name:    "nodeautoprovisioning-323233232",
+		},
+		gceManager:      g,
+		minSize:         0,
+		maxSize:         1000,
+		autoprovisioned: true,
+		exist:           true,
+		nodePoolName:    "nodeautoprovisioning-323233232",
+		spec:            nil}
+
+	operationName, err := g.SetMigSizeAsync(mig, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "operation-1505739408819-5597646964339-eb839c88-28805931", operationName)
+	mock.AssertExpectationsForObjects(t, server)
+}
+
+func TestGetMigOperationStatus(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGKE, false)
+
+	operationName := "operation-1505739408819-5597646964339-eb839c88-28805931"
+	server.On("handle", "/project1/zones/us-central1-b/operations/"+operationName).Return(setMigSizeOperationResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/operations/"+operationName).Return(setMigSizeOperationFailedResponse).Once()
+	server.On("handle", "/project1/zones/us-central1-b/operations/"+operationName).Return(
+		strings.Replace(setMigSizeOperationFailedResponse, "QUOTA_EXCEEDED", "ZONE_RESOURCE_POOL_EXHAUSTED", 1)).Once()
+
+	mig := &Mig{
+		GceRef: GceRef{
+			Project: projectId,
+			Zone:    zoneB,
+			Name:    "nodeautoprovisioning-323233232",
+		},
+		gceManager:      g,
+		minSize:         0,
+		maxSize:         1000,
+		autoprovisioned: true,
+		exist:           true,
+		nodePoolName:    "nodeautoprovisioning-323233232",
+		spec:            nil}
+
+	status, err := g.GetMigOperationStatus(mig, operationName)
+	assert.NoError(t, err)
+	assert.True(t, status.Done)
+	assert.NoError(t, status.Error)
+
+	status, err = g.GetMigOperationStatus(mig, operationName)
+	assert.NoError(t, err)
+	assert.True(t, status.Done)
+	assert.Error(t, status.Error)
+	assert.Contains(t, status.Error.Error(), "QUOTA_EXCEEDED")
+	_, outOfResources := status.Error.(*cloudprovider.OutOfResourcesError)
+	assert.False(t, outOfResources)
+
+	status, err = g.GetMigOperationStatus(mig, operationName)
+	assert.NoError(t, err)
+	assert.True(t, status.Done)
+	assert.IsType(t, &cloudprovider.OutOfResourcesError{}, status.Error)
+	assert.Contains(t, status.Error.Error(), "ZONE_RESOURCE_POOL_EXHAUSTED")
+	mock.AssertExpectationsForObjects(t, server)
+}
+
 func TestGetMigForInstance(t *testing.T) {
 	server := NewHttpServerMock()
 	defer server.Close()
@@ -1082,6 +1220,90 @@ func TestGetMigNodes(t *testing.T) {
 	mock.AssertExpectationsForObjects(t, server)
 }
 
+const managedInstancesResponseWithActions = `{
+  "managedInstances": [
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-none",
+      "instanceStatus": "RUNNING",
+      "currentAction": "NONE"
+    },
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-crea",
+      "currentAction": "CREATING"
+    },
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-recr",
+      "instanceStatus": "STOPPING",
+      "currentAction": "RECREATING"
+    },
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-dele",
+      "instanceStatus": "STOPPING",
+      "currentAction": "DELETING"
+    },
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-aban",
+      "instanceStatus": "RUNNING",
+      "currentAction": "ABANDONING"
+    },
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-veri",
+      "instanceStatus": "RUNNING",
+      "currentAction": "VERIFYING"
+    },
+    {
+      "instance": "https://www.googleapis.com/compute/v1/projects/project1/zones/us-central1-b/instances/gke-cluster-1-default-pool-f7607aac-refr",
+      "instanceStatus": "RUNNING",
+      "currentAction": "REFRESHING"
+    }
+  ]
+}`
+
+func TestGetMigInstances(t *testing.T) {
+	server := NewHttpServerMock()
+	defer server.Close()
+	g := newTestGceManager(t, server.URL, ModeGKE, false)
+
+	server.On("handle", "/project1/zones/us-central1-b/instanceGroupManagers/gke-cluster-1-default-pool/listManagedInstances").Return(managedInstancesResponseWithActions).Once()
+
+	mig := &Mig{
+		GceRef: GceRef{
+			Project: projectId,
+			Zone:    zoneB,
+			Name:    "gke-cluster-1-default-pool",
+		},
+		gceManager: g,
+		exist:      true,
+	}
+
+	instances, err := g.GetMigInstances(mig)
+	assert.NoError(t, err)
+	prefix := "gce://project1/us-central1-b/gke-cluster-1-default-pool-f7607aac-"
+	assert.Equal(t, []cloudprovider.Instance{
+		{Id: prefix + "none", State: cloudprovider.InstanceRunning},
+		{Id: prefix + "crea", State: cloudprovider.InstanceCreating},
+		{Id: prefix + "recr", State: cloudprovider.InstanceRunning},
+		{Id: prefix + "dele", State: cloudprovider.InstanceDeleting},
+		{Id: prefix + "aban", State: cloudprovider.InstanceAbandoning},
+		{Id: prefix + "veri", State: cloudprovider.InstanceCreating},
+		{Id: prefix + "refr", State: cloudprovider.InstanceRunning},
+	}, instances)
+	mock.AssertExpectationsForObjects(t, server)
+}
+
+func TestInstanceStateFromAction(t *testing.T) {
+	assert.Equal(t, cloudprovider.InstanceRunning, instanceStateFromAction("NONE"))
+	assert.Equal(t, cloudprovider.InstanceCreating, instanceStateFromAction("CREATING"))
+	assert.Equal(t, cloudprovider.InstanceCreating, instanceStateFromAction("CREATING_WITHOUT_RETRIES"))
+	assert.Equal(t, cloudprovider.InstanceCreating, instanceStateFromAction("VERIFYING"))
+	assert.Equal(t, cloudprovider.InstanceRunning, instanceStateFromAction("RECREATING"))
+	assert.Equal(t, cloudprovider.InstanceRunning, instanceStateFromAction("REFRESHING"))
+	assert.Equal(t, cloudprovider.InstanceRunning, instanceStateFromAction("RESTARTING"))
+	assert.Equal(t, cloudprovider.InstanceDeleting, instanceStateFromAction("DELETING"))
+	assert.Equal(t, cloudprovider.InstanceAbandoning, instanceStateFromAction("ABANDONING"))
+	assert.Equal(t, cloudprovider.InstanceRunning, instanceStateFromAction("SOMETHING_NEW"))
+}
+
 func TestFetchResourceLimiter(t *testing.T) {
 	server := NewHttpServerMock()
 	defer server.Close()
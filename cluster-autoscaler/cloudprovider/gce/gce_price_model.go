@@ -21,11 +21,82 @@ import (
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+
+	"github.com/golang/glog"
 )
 
 // GcePriceModel implements PriceModel interface for GCE.
 type GcePriceModel struct {
+	// acceleratorCount returns the number of GPUs attached to instances the node was created from.
+	// GPUs are attached separately from the machine type and may not be in node capacity yet,
+	// e.g. until the device plugin registers them.
+	acceleratorCount func(node *apiv1.Node) (int64, error)
+	// billingPeriods are the billing periods of the price components. Nil means DefaultBillingPeriods.
+	billingPeriods *BillingPeriods
+}
+
+// NewGcePriceModel builds a GcePriceModel charging the price components by the given billing periods.
+// acceleratorCount may be nil, in which case GPUs not in node capacity are not priced.
+func NewGcePriceModel(acceleratorCount func(node *apiv1.Node) (int64, error), billingPeriods BillingPeriods) *GcePriceModel {
+	return &GcePriceModel{
+		acceleratorCount: acceleratorCount,
+		billingPeriods:   &billingPeriods,
+	}
+}
+
+// BillingPeriod describes how usage of a resource is charged over time: usage shorter than
+// MinimumCharge is charged as MinimumCharge, and longer usage is rounded up to a multiple of
+// Granularity. Zero Granularity means usage is charged exactly.
+type BillingPeriod struct {
+	MinimumCharge time.Duration
+	Granularity   time.Duration
+}
+
+// DefaultBillingPeriod is the per-minute billing with a 1 minute minimum of GCE.
+var DefaultBillingPeriod = BillingPeriod{MinimumCharge: time.Minute, Granularity: time.Minute}
+
+// BillingPeriods are the billing periods of the components of a node price.
+type BillingPeriods struct {
+	// Base is the billing period of predefined machine types.
+	Base BillingPeriod
+	// CPU and Memory are the billing periods of custom machine types and of pod requests.
+	CPU    BillingPeriod
+	Memory BillingPeriod
+	// GPU is the billing period of attached GPUs.
+	GPU BillingPeriod
+}
+
+// DefaultBillingPeriods charge every price component with DefaultBillingPeriod.
+var DefaultBillingPeriods = BillingPeriods{
+	Base:   DefaultBillingPeriod,
+	CPU:    DefaultBillingPeriod,
+	Memory: DefaultBillingPeriod,
+	GPU:    DefaultBillingPeriod,
+}
+
+// Hours returns the number of hours charged for using the resource from startTime to endTime.
+func (p BillingPeriod) Hours(startTime time.Time, endTime time.Time) float64 {
+	duration := endTime.Sub(startTime)
+	if duration <= 0 {
+		return 0
+	}
+	if duration < p.MinimumCharge {
+		duration = p.MinimumCharge
+	}
+	if p.Granularity > 0 {
+		duration = time.Duration(math.Ceil(float64(duration)/float64(p.Granularity))) * p.Granularity
+	}
+	return duration.Hours()
+}
+
+func (model *GcePriceModel) periods() BillingPeriods {
+	if model.billingPeriods == nil {
+		return DefaultBillingPeriods
+	}
+	return *model.billingPeriods
 }
 
 const (
@@ -37,6 +108,8 @@ const (
 
 	gigabyte         = 1024.0 * 1024.0 * 1024.0
 	preemptibleLabel = "cloud.google.com/gke-preemptible"
+	spotLabel        = "cloud.google.com/gke-spot"
+	gpuLabel         = "cloud.google.com/gke-accelerator"
 )
 
 var (
@@ -97,66 +170,104 @@ func (model *GcePriceModel) NodePrice(node *apiv1.Node, startTime time.Time, end
 	if node.Labels != nil {
 		if machineType, found := node.Labels[kubeletapis.LabelInstanceType]; found {
 			var priceMapToUse map[string]float64
-			if node.Labels[preemptibleLabel] == "true" {
+			if isPreemptible(node) {
 				priceMapToUse = preemptiblePrices
 			} else {
 				priceMapToUse = instancePrices
 			}
 			if basePricePerHour, found := priceMapToUse[machineType]; found {
-				price = basePricePerHour * getHours(startTime, endTime)
+				price = basePricePerHour * model.periods().Base.Hours(startTime, endTime)
 				basePriceFound = true
 			}
 		}
 	}
 	if !basePriceFound {
-		price = getBasePrice(node.Status.Capacity, startTime, endTime)
-		if node.Labels != nil && node.Labels[preemptibleLabel] == "true" {
+		cpuPrice, memoryPrice := model.getBasePrice(node.Status.Capacity, startTime, endTime)
+		price = cpuPrice + memoryPrice
+		if isPreemptible(node) {
 			price = price * preemptibleDiscount
 		}
 	}
 	// TODO: handle ssd.
 
-	price += getAdditionalPrice(node.Status.Capacity, startTime, endTime)
+	// GPUs shared by containers or partitioned with MIG are exposed as multiple GPU resources, but
+	// only physical GPUs are paid for.
+	price += model.getAdditionalPrice(node.Status.Capacity, startTime, endTime) / float64(gpu.SharingFactor(node.Labels))
+	if node.Labels[gpuLabel] != "" && getGpuCount(node.Status.Capacity) == 0 && model.acceleratorCount != nil {
+		gpuCount, err := model.acceleratorCount(node)
+		if err != nil {
+			glog.Warningf("Failed to get GPU count for node %s: %v", node.Name, err)
+		} else {
+			price += float64(gpuCount) * gpuPricePerHour * model.periods().GPU.Hours(startTime, endTime)
+		}
+	}
 	return price, nil
 }
 
-func getHours(startTime time.Time, endTime time.Time) float64 {
-	minutes := math.Ceil(float64(endTime.Sub(startTime)) / float64(time.Minute))
-	hours := minutes / 60.0
-	return hours
+// isPreemptible returns true if the node is a preemptible or spot VM. Spot VMs are priced like
+// preemptible ones.
+func isPreemptible(node *apiv1.Node) bool {
+	return node.Labels[preemptibleLabel] == "true" || node.Labels[spotLabel] == "true"
 }
 
 // PodPrice returns a theoretical minimum priece of running a pod for a given
 // period of time on a perfectly matching machine.
 func (model *GcePriceModel) PodPrice(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (float64, error) {
-	price := 0.0
+	breakdown, err := model.PodPriceBreakdown(pod, startTime, endTime)
+	if err != nil {
+		return 0, err
+	}
+	return breakdown.Total(), nil
+}
+
+// PodPriceBreakdown returns the price returned by PodPrice split into CPU, memory and GPU components.
+func (model *GcePriceModel) PodPriceBreakdown(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (*cloudprovider.PodPriceBreakdown, error) {
+	breakdown := &cloudprovider.PodPriceBreakdown{
+		CPUPricePerHour:      cpuPricePerHour,
+		MemoryPricePerGbHour: memoryPricePerHourPerGb,
+		GPUPricePerHour:      gpuPricePerHour,
+	}
 	for _, container := range pod.Spec.Containers {
-		price += getBasePrice(container.Resources.Requests, startTime, endTime)
-		price += getAdditionalPrice(container.Resources.Requests, startTime, endTime)
+		cpuPrice, memoryPrice := model.getBasePrice(container.Resources.Requests, startTime, endTime)
+		breakdown.CPU += cpuPrice
+		breakdown.Memory += memoryPrice
+		breakdown.GPU += model.getAdditionalPrice(container.Resources.Requests, startTime, endTime)
 	}
-	return price, nil
+	if breakdown.GPU > 0 {
+		breakdown.GPUModel = pod.Spec.NodeSelector[gpuLabel]
+	}
+	return breakdown, nil
 }
 
-func getBasePrice(resources apiv1.ResourceList, startTime time.Time, endTime time.Time) float64 {
+// getBasePrice returns the prices of CPU and memory in the resource list.
+func (model *GcePriceModel) getBasePrice(resources apiv1.ResourceList, startTime time.Time, endTime time.Time) (float64, float64) {
 	if len(resources) == 0 {
-		return 0
+		return 0, 0
 	}
-	hours := getHours(startTime, endTime)
-	price := 0.0
 	cpu := resources[apiv1.ResourceCPU]
 	mem := resources[apiv1.ResourceMemory]
-	price += float64(cpu.MilliValue()) / 1000.0 * cpuPricePerHour * hours
-	price += float64(mem.Value()) / gigabyte * memoryPricePerHourPerGb * hours
-	return price
+	cpuPrice := float64(cpu.MilliValue()) / 1000.0 * cpuPricePerHour * model.periods().CPU.Hours(startTime, endTime)
+	memoryPrice := float64(mem.Value()) / gigabyte * memoryPricePerHourPerGb * model.periods().Memory.Hours(startTime, endTime)
+	return cpuPrice, memoryPrice
 }
 
-func getAdditionalPrice(resources apiv1.ResourceList, startTime time.Time, endTime time.Time) float64 {
+// getAdditionalPrice returns the price of GPUs in the resource list.
+func (model *GcePriceModel) getAdditionalPrice(resources apiv1.ResourceList, startTime time.Time, endTime time.Time) float64 {
 	if len(resources) == 0 {
 		return 0
 	}
-	hours := getHours(startTime, endTime)
+	hours := model.periods().GPU.Hours(startTime, endTime)
 	price := 0.0
-	gpu := resources[apiv1.ResourceNvidiaGPU]
-	price += float64(gpu.MilliValue()) / 1000.0 * gpuPricePerHour * hours
+	price += getGpuCount(resources) * gpuPricePerHour * hours
 	return price
 }
+
+// getGpuCount returns the number of GPUs in the resource list, under either of GPU resource names.
+func getGpuCount(resources apiv1.ResourceList) float64 {
+	count := 0.0
+	for _, resourceName := range []apiv1.ResourceName{apiv1.ResourceNvidiaGPU, resourceNvidiaGPU} {
+		gpu := resources[resourceName]
+		count += float64(gpu.MilliValue()) / 1000.0
+	}
+	return count
+}
@@ -17,13 +17,16 @@ limitations under the License.
 package gce
 
 import (
+	"fmt"
 	"math"
 	"testing"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -93,6 +96,88 @@ func TestGetNodePrice(t *testing.T) {
 	assert.True(t, math.Abs(price3-8*price6) < 0.1)
 }
 
+func TestGetNodePriceGpuFromTemplate(t *testing.T) {
+	labels, _ := buildGenericLabels(GceRef{
+		Name:    "kubernetes-minion-group",
+		Project: "mwielgus-proj",
+		Zone:    "us-central1-b"},
+		"n1-standard-8", "sillyname")
+	labels[gpuLabel] = "nvidia-tesla-k80"
+
+	lookups := 0
+	model := &GcePriceModel{
+		acceleratorCount: func(node *apiv1.Node) (int64, error) {
+			lookups++
+			return 2, nil
+		},
+	}
+	now := time.Now()
+
+	// GPUs in capacity
+	node1 := BuildTestNode("sillyname1", 8000, 30*1024*1024*1024)
+	node1.Labels = labels
+	node1.Status.Capacity[resourceNvidiaGPU] = *resource.NewQuantity(2, resource.DecimalSI)
+	price1, err := model.NodePrice(node1, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, lookups)
+
+	// GPU label, but no GPUs in capacity
+	node2 := BuildTestNode("sillyname2", 8000, 30*1024*1024*1024)
+	node2.Labels = labels
+	price2, err := model.NodePrice(node2, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, lookups)
+	assert.True(t, math.Abs(price1-price2) < 0.001)
+
+	// no GPUs at all
+	delete(labels, gpuLabel)
+	node3 := BuildTestNode("sillyname3", 8000, 30*1024*1024*1024)
+	node3.Labels = labels
+	price3, err := model.NodePrice(node3, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, lookups)
+	assert.True(t, math.Abs(price1-price3-2*gpuPricePerHour) < 0.001)
+
+	// failed lookup
+	model.acceleratorCount = func(node *apiv1.Node) (int64, error) {
+		return 0, fmt.Errorf("no mig")
+	}
+	labels[gpuLabel] = "nvidia-tesla-k80"
+	node4 := BuildTestNode("sillyname4", 8000, 30*1024*1024*1024)
+	node4.Labels = labels
+	price4, err := model.NodePrice(node4, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, math.Abs(price3-price4) < 0.001)
+}
+
+func TestGetNodePriceSharedGpu(t *testing.T) {
+	model := &GcePriceModel{}
+	now := time.Now()
+
+	// 2 GPUs time-shared by 8 containers each
+	node1 := BuildTestNode("sillyname1", 8000, 30*1024*1024*1024)
+	node1.Labels[gpu.GPUSharingStrategyLabel] = "time-sharing"
+	node1.Labels[gpu.GPUMaxSharedClientsLabel] = "8"
+	node1.Status.Capacity[resourceNvidiaGPU] = *resource.NewQuantity(16, resource.DecimalSI)
+	price1, err := model.NodePrice(node1, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	// 2 GPUs partitioned into 7 instances each
+	node2 := BuildTestNode("sillyname2", 8000, 30*1024*1024*1024)
+	node2.Labels[gpu.GPUPartitionSizeLabel] = "1g.5gb"
+	node2.Status.Capacity[resourceNvidiaGPU] = *resource.NewQuantity(14, resource.DecimalSI)
+	price2, err := model.NodePrice(node2, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	// no GPUs
+	node3 := BuildTestNode("sillyname3", 8000, 30*1024*1024*1024)
+	price3, err := model.NodePrice(node3, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	assert.True(t, math.Abs(price1-price3-2*gpuPricePerHour) < 0.001)
+	assert.True(t, math.Abs(price2-price3-2*gpuPricePerHour) < 0.001)
+}
+
 func TestGetPodPrice(t *testing.T) {
 	pod1 := BuildTestPod("a1", 100, 500*1024*1024)
 	pod2 := BuildTestPod("a2", 2*100, 2*500*1024*1024)
@@ -107,3 +192,171 @@ func TestGetPodPrice(t *testing.T) {
 	// 2 times bigger pod should cost twice as much.
 	assert.True(t, math.Abs(price1*2-price2) < 0.001)
 }
+
+func TestGetPodPriceBreakdown(t *testing.T) {
+	pod := BuildTestPod("a1", 2000, 4*1024*1024*1024)
+	pod.Spec.Containers[0].Resources.Requests[resourceNvidiaGPU] = *resource.NewQuantity(1, resource.DecimalSI)
+	pod.Spec.NodeSelector = map[string]string{gpuLabel: "nvidia-tesla-k80"}
+
+	model := &GcePriceModel{}
+	now := time.Now()
+
+	breakdown, err := model.PodPriceBreakdown(pod, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, math.Abs(breakdown.CPU-2*cpuPricePerHour) < 0.001)
+	assert.True(t, math.Abs(breakdown.Memory-4*memoryPricePerHourPerGb) < 0.001)
+	assert.True(t, math.Abs(breakdown.GPU-gpuPricePerHour) < 0.001)
+	assert.Equal(t, "nvidia-tesla-k80", breakdown.GPUModel)
+	assert.Equal(t, cpuPricePerHour, breakdown.CPUPricePerHour)
+
+	// Components sum up to the price of the pod.
+	price, err := model.PodPrice(pod, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, price, breakdown.Total())
+	legacyPrice := 2*cpuPricePerHour + 4*memoryPricePerHourPerGb + gpuPricePerHour
+	assert.True(t, math.Abs(price-legacyPrice) < 0.001)
+
+	// GPU model is only reported for pods requesting GPUs.
+	pod = BuildTestPod("a2", 100, 500*1024*1024)
+	pod.Spec.NodeSelector = map[string]string{gpuLabel: "nvidia-tesla-k80"}
+	breakdown, err = model.PodPriceBreakdown(pod, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, breakdown.GPU)
+	assert.Equal(t, "", breakdown.GPUModel)
+}
+
+func TestBillingPeriodHours(t *testing.T) {
+	now := time.Now()
+	exact := BillingPeriod{}
+	hourly := BillingPeriod{MinimumCharge: 10 * time.Minute, Granularity: time.Hour}
+
+	for _, tc := range []struct {
+		duration time.Duration
+		period   BillingPeriod
+		expected float64
+	}{
+		{0, DefaultBillingPeriod, 0},
+		{-time.Minute, DefaultBillingPeriod, 0},
+		{time.Second, DefaultBillingPeriod, 1.0 / 60},
+		{59 * time.Second, DefaultBillingPeriod, 1.0 / 60},
+		{61 * time.Second, DefaultBillingPeriod, 2.0 / 60},
+		{time.Hour, DefaultBillingPeriod, 1},
+		{3*24*time.Hour + time.Second, DefaultBillingPeriod, 72 + 1.0/60},
+		{30 * time.Second, exact, 30.0 / 3600},
+		{time.Minute, hourly, 1},
+		{3*24*time.Hour + time.Minute, hourly, 73},
+	} {
+		assert.InDelta(t, tc.expected, tc.period.Hours(now, now.Add(tc.duration)), 1e-9, "%v with %+v", tc.duration, tc.period)
+	}
+}
+
+func TestGetNodePriceBillingPeriods(t *testing.T) {
+	labels, _ := buildGenericLabels(GceRef{
+		Name:    "kubernetes-minion-group",
+		Project: "mwielgus-proj",
+		Zone:    "us-central1-b"},
+		"n1-standard-8", "sillyname")
+	node := BuildTestNode("sillyname", 8000, 30*1024*1024*1024)
+	node.Labels = labels
+	node.Status.Capacity[resourceNvidiaGPU] = *resource.NewQuantity(1, resource.DecimalSI)
+
+	model := &GcePriceModel{}
+	gpuPeriods := DefaultBillingPeriods
+	gpuPeriods.GPU = BillingPeriod{MinimumCharge: 10 * time.Minute, Granularity: time.Minute}
+	gpuMinimumModel := NewGcePriceModel(nil, gpuPeriods)
+	now := time.Now()
+
+	// Prices don't decrease with longer windows, from sub-minute to multi-day ones.
+	previous, previousWithMinimum := 0.0, 0.0
+	for _, duration := range []time.Duration{time.Second, 30 * time.Second, time.Minute, 5 * time.Minute,
+		10 * time.Minute, time.Hour, 24 * time.Hour, 7 * 24 * time.Hour} {
+		price, err := model.NodePrice(node, now, now.Add(duration))
+		assert.NoError(t, err)
+		assert.True(t, price >= previous, "price for %v", duration)
+		priceWithMinimum, err := gpuMinimumModel.NodePrice(node, now, now.Add(duration))
+		assert.NoError(t, err)
+		assert.True(t, priceWithMinimum >= previousWithMinimum, "price with GPU minimum for %v", duration)
+		assert.True(t, priceWithMinimum >= price, "price with GPU minimum for %v", duration)
+		previous, previousWithMinimum = price, priceWithMinimum
+	}
+
+	// Sub-minute usage is charged for the 1 minute minimum, only the GPU is charged for its own minimum.
+	price, err := model.NodePrice(node, now, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.InDelta(t, (instancePrices["n1-standard-8"]+gpuPricePerHour)/60, price, 1e-9)
+	price, err = gpuMinimumModel.NodePrice(node, now, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.InDelta(t, instancePrices["n1-standard-8"]/60+gpuPricePerHour/6, price, 1e-9)
+
+	// Multi-day usage is charged by the minute, past the minimum both models charge the same.
+	price, err = model.NodePrice(node, now, now.Add(3*24*time.Hour+time.Second))
+	assert.NoError(t, err)
+	assert.InDelta(t, (instancePrices["n1-standard-8"]+gpuPricePerHour)*(72+1.0/60), price, 1e-9)
+	priceWithMinimum, err := gpuMinimumModel.NodePrice(node, now, now.Add(3*24*time.Hour+time.Second))
+	assert.NoError(t, err)
+	assert.InDelta(t, price, priceWithMinimum, 1e-9)
+}
+
+func TestGetNodePriceCustomMachineBillingPeriods(t *testing.T) {
+	// Nodes of unknown machine types are priced by their CPU and memory.
+	node := BuildTestNode("custom", 2000, 4*1024*1024*1024)
+	periods := DefaultBillingPeriods
+	periods.CPU = BillingPeriod{MinimumCharge: time.Hour, Granularity: time.Hour}
+	hourlyCpuModel := NewGcePriceModel(nil, periods)
+	now := time.Now()
+
+	price, err := (&GcePriceModel{}).NodePrice(node, now, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.InDelta(t, (2*cpuPricePerHour+4*memoryPricePerHourPerGb)/60, price, 1e-9)
+	price, err = hourlyCpuModel.NodePrice(node, now, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.InDelta(t, 2*cpuPricePerHour+4*memoryPricePerHourPerGb/60, price, 1e-9)
+	price, err = hourlyCpuModel.NodePrice(node, now, now.Add(2*24*time.Hour+time.Minute))
+	assert.NoError(t, err)
+	assert.InDelta(t, 2*cpuPricePerHour*49+4*memoryPricePerHourPerGb*(48+1.0/60), price, 1e-9)
+}
+
+func TestGetNodePriceSpot(t *testing.T) {
+	model := &GcePriceModel{}
+	now := time.Now()
+
+	labels, _ := buildGenericLabels(GceRef{
+		Name:    "kubernetes-minion-group",
+		Project: "mwielgus-proj",
+		Zone:    "us-central1-b"},
+		"n1-standard-8", "sillyname")
+	preemptible := BuildTestNode("preemptible", 8000, 30*1024*1024*1024)
+	preemptible.Labels = copyLabels(labels)
+	preemptible.Labels[preemptibleLabel] = "true"
+	spot := BuildTestNode("spot", 8000, 30*1024*1024*1024)
+	spot.Labels = copyLabels(labels)
+	spot.Labels[spotLabel] = "true"
+
+	preemptiblePrice, err := model.NodePrice(preemptible, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	spotPrice, err := model.NodePrice(spot, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, preemptiblePrice, spotPrice)
+
+	// Machine types without a predefined price are priced by cpu and memory.
+	labels[kubeletapis.LabelInstanceType] = "n2-standard-4"
+	regular := BuildTestNode("regular", 4000, 16*1024*1024*1024)
+	regular.Labels = copyLabels(labels)
+	spot = BuildTestNode("spot", 4000, 16*1024*1024*1024)
+	spot.Labels = copyLabels(labels)
+	spot.Labels[spotLabel] = "true"
+
+	regularPrice, err := model.NodePrice(regular, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	spotPrice, err = model.NodePrice(spot, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, regularPrice*preemptibleDiscount, spotPrice, 1e-9)
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+	return result
+}
@@ -27,6 +27,8 @@ const (
 	gcePrefix           = gceUrlSchema + "://content." + gceDomainSufix
 	instanceUrlTemplate = gcePrefix + "%s/zones/%s/instances/%s"
 	migUrlTemplate      = gcePrefix + "%s/zones/%s/instanceGroups/%s"
+
+	instanceTemplateUrlTemplate = gcePrefix + "%s/global/instanceTemplates/%s"
 )
 
 // ParseMigUrl expects url in format:
@@ -41,6 +43,12 @@ func ParseInstanceUrl(url string) (project string, zone string, name string, err
 	return parseGceUrl(url, "instances")
 }
 
+// ParseSubnetworkUrl expects url in format:
+// https://www.googleapis.com/compute/v1/projects/<project-id>/regions/<region>/subnetworks/<name>
+func ParseSubnetworkUrl(url string) (project string, region string, name string, err error) {
+	return parseGceScopedUrl(url, "regions", "subnetworks")
+}
+
 // GenerateInstanceUrl generates url for instance.
 func GenerateInstanceUrl(project, zone, name string) string {
 	return fmt.Sprintf(instanceUrlTemplate, project, zone, name)
@@ -52,7 +60,11 @@ func GenerateMigUrl(project, zone, name string) string {
 }
 
 func parseGceUrl(url, expectedResource string) (project string, zone string, name string, err error) {
-	errMsg := fmt.Errorf("Wrong url: expected format https://content.googleapis.com/compute/v1/projects/<project-id>/zones/<zone>/%s/<name>, got %s", expectedResource, url)
+	return parseGceScopedUrl(url, "zones", expectedResource)
+}
+
+func parseGceScopedUrl(url, scope, expectedResource string) (project string, location string, name string, err error) {
+	errMsg := fmt.Errorf("Wrong url: expected format https://content.googleapis.com/compute/v1/projects/<project-id>/%s/<location>/%s/<name>, got %s", scope, expectedResource, url)
 	if !strings.Contains(url, gceDomainSufix) {
 		return "", "", "", errMsg
 	}
@@ -60,14 +72,14 @@ func parseGceUrl(url, expectedResource string) (project string, zone string, nam
 		return "", "", "", errMsg
 	}
 	splitted := strings.Split(strings.Split(url, gceDomainSufix)[1], "/")
-	if len(splitted) != 5 || splitted[1] != "zones" {
+	if len(splitted) != 5 || splitted[1] != scope {
 		return "", "", "", errMsg
 	}
 	if splitted[3] != expectedResource {
 		return "", "", "", fmt.Errorf("Wrong resource in url: expected %s, got %s", expectedResource, splitted[3])
 	}
 	project = splitted[0]
-	zone = splitted[2]
+	location = splitted[2]
 	name = splitted[4]
-	return project, zone, name, nil
+	return project, location, name, nil
 }
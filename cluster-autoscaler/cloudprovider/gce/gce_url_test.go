@@ -41,3 +41,14 @@ func TestParseUrl(t *testing.T) {
 	proj, zone, name, err = parseGceUrl("https://content.googleapis.com/compute/vabc/projects/mwielgus-proj/zones/us-central1-b/instanceGroups/kubernetes-minion-group", "instanceGroups")
 	assert.NotNil(t, err)
 }
+
+func TestParseSubnetworkUrl(t *testing.T) {
+	proj, region, name, err := ParseSubnetworkUrl("https://www.googleapis.com/compute/v1/projects/mwielgus-proj/regions/us-central1/subnetworks/default")
+	assert.Nil(t, err)
+	assert.Equal(t, "mwielgus-proj", proj)
+	assert.Equal(t, "us-central1", region)
+	assert.Equal(t, "default", name)
+
+	_, _, _, err = ParseSubnetworkUrl("https://www.googleapis.com/compute/v1/projects/mwielgus-proj/zones/us-central1-b/subnetworks/default")
+	assert.NotNil(t, err)
+}
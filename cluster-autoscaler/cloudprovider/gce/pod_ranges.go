@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"fmt"
+	"net"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// defaultNodePodCIDRMaskSize is the size of pod CIDRs assigned to nodes, used when no node has a
+// pod CIDR from the range yet.
+const defaultNodePodCIDRMaskSize = 24
+
+// podRangeCapacity returns how many more nodes can get a pod CIDR from the given range, based on
+// the pod CIDRs already assigned to nodes. Nodes with pod CIDRs outside of the range, e.g. from
+// another node pool's range, are ignored.
+func podRangeCapacity(podRange string, nodes []*apiv1.Node) (int, error) {
+	_, rangeNet, err := net.ParseCIDR(podRange)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse pod range %s: %v", podRange, err)
+	}
+	rangeMaskSize, _ := rangeNet.Mask.Size()
+
+	nodeMaskSize := defaultNodePodCIDRMaskSize
+	allocated := 0
+	for _, node := range nodes {
+		if node.Spec.PodCIDR == "" {
+			continue
+		}
+		ip, nodeNet, err := net.ParseCIDR(node.Spec.PodCIDR)
+		if err != nil || !rangeNet.Contains(ip) {
+			continue
+		}
+		nodeMaskSize, _ = nodeNet.Mask.Size()
+		allocated++
+	}
+	if nodeMaskSize < rangeMaskSize {
+		return 0, nil
+	}
+	capacity := (1 << uint(nodeMaskSize-rangeMaskSize)) - allocated
+	if capacity < 0 {
+		return 0, nil
+	}
+	return capacity, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildNodeWithPodCIDR(podCIDR string) *apiv1.Node {
+	return &apiv1.Node{Spec: apiv1.NodeSpec{PodCIDR: podCIDR}}
+}
+
+func TestPodRangeCapacity(t *testing.T) {
+	// A /22 range holds 4 /24 node pod CIDRs.
+	capacity, err := podRangeCapacity("10.4.0.0/22", []*apiv1.Node{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, capacity)
+
+	nodes := []*apiv1.Node{
+		buildNodeWithPodCIDR("10.4.0.0/24"),
+		buildNodeWithPodCIDR("10.4.1.0/24"),
+		buildNodeWithPodCIDR("10.8.0.0/24"),
+		buildNodeWithPodCIDR(""),
+	}
+	capacity, err = podRangeCapacity("10.4.0.0/22", nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, capacity)
+
+	// Node pod CIDR size is taken from existing nodes.
+	nodes = []*apiv1.Node{buildNodeWithPodCIDR("10.4.0.0/25")}
+	capacity, err = podRangeCapacity("10.4.0.0/22", nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, capacity)
+
+	// Exhausted range.
+	nodes = []*apiv1.Node{buildNodeWithPodCIDR("10.4.0.0/24")}
+	capacity, err = podRangeCapacity("10.4.0.0/24", nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, capacity)
+
+	_, err = podRangeCapacity("not-a-cidr", nodes)
+	assert.Error(t, err)
+}
@@ -22,9 +22,11 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 
 	gce "google.golang.org/api/compute/v1"
 	apiv1 "k8s.io/api/core/v1"
@@ -39,6 +41,9 @@ const (
 	mbPerGB           = 1000
 	millicoresPerCore = 1000
 	resourceNvidiaGPU = "nvidia.com/gpu"
+	// acceleratorCountAnnotation holds the number of GPUs the instance template of the MIG attaches
+	// to instances. It's set on template nodes, so that the price model doesn't look up their MIG.
+	acceleratorCountAnnotation = "cluster-autoscaler.kubernetes.io/gce-accelerator-count"
 )
 
 // builds templates for gce cloud provider
@@ -85,6 +90,16 @@ func (t *templateBuilder) getAcceleratorCount(accelerators []*gce.AcceleratorCon
 	return count
 }
 
+// getAcceleratorType returns the type of the first GPU among accelerators, empty if there's none.
+func (t *templateBuilder) getAcceleratorType(accelerators []*gce.AcceleratorConfig) string {
+	for _, accelerator := range accelerators {
+		if strings.HasPrefix(accelerator.AcceleratorType, "nvidia-") && accelerator.AcceleratorCount > 0 {
+			return accelerator.AcceleratorType
+		}
+	}
+	return ""
+}
+
 func (t *templateBuilder) buildCapacity(machineType string, accelerators []*gce.AcceleratorConfig, zone string) (apiv1.ResourceList, error) {
 	capacity := apiv1.ResourceList{}
 	// TODO: get a real value.
@@ -160,6 +175,9 @@ func (t *templateBuilder) buildNodeFromTemplate(mig *Mig, template *gce.Instance
 		Name:     nodeName,
 		SelfLink: fmt.Sprintf("/api/v1/nodes/%s", nodeName),
 		Labels:   map[string]string{},
+		Annotations: map[string]string{
+			acceleratorCountAnnotation: strconv.FormatInt(t.getAcceleratorCount(template.Properties.GuestAccelerators), 10),
+		},
 	}
 
 	capacity, err := t.buildCapacity(template.Properties.MachineType, template.Properties.GuestAccelerators, mig.GceRef.Zone)
@@ -186,6 +204,10 @@ func (t *templateBuilder) buildNodeFromTemplate(mig *Mig, template *gce.Instance
 				return nil, err
 			}
 			node.Labels = cloudprovider.JoinStringMaps(node.Labels, kubeEnvLabels)
+			// Time-shared and MIG-partitioned GPUs are exposed as multiple GPU resources each.
+			if gpus, found := node.Status.Capacity[resourceNvidiaGPU]; found {
+				node.Status.Capacity[resourceNvidiaGPU] = *resource.NewQuantity(gpus.Value()*gpu.SharingFactor(node.Labels), resource.DecimalSI)
+			}
 			// Extract taints
 			kubeEnvTaints, err := extractTaintsFromKubeEnv(*item.Value)
 			if err != nil {
@@ -210,6 +232,12 @@ func (t *templateBuilder) buildNodeFromTemplate(mig *Mig, template *gce.Instance
 		return nil, err
 	}
 	node.Labels = cloudprovider.JoinStringMaps(node.Labels, labels)
+	// Price model and node selectors rely on the accelerator label of GPU nodes.
+	if _, found := node.Labels[gpuLabel]; !found {
+		if acceleratorType := t.getAcceleratorType(template.Properties.GuestAccelerators); acceleratorType != "" {
+			node.Labels[gpuLabel] = acceleratorType
+		}
+	}
 
 	// Ready status
 	node.Status.Conditions = cloudprovider.BuildReadyConditions()
@@ -230,8 +258,14 @@ func (t *templateBuilder) buildNodeFromAutoprovisioningSpec(mig *Mig) (*apiv1.No
 		SelfLink: fmt.Sprintf("/api/v1/nodes/%s", nodeName),
 		Labels:   map[string]string{},
 	}
-	// TODO: Handle GPU
-	capacity, err := t.buildCapacity(mig.spec.machineType, nil, mig.GceRef.Zone)
+	accelerators, err := napAccelerators(mig.spec)
+	if err != nil {
+		return nil, err
+	}
+	node.Annotations = map[string]string{
+		acceleratorCountAnnotation: strconv.FormatInt(t.getAcceleratorCount(accelerators), 10),
+	}
+	capacity, err := t.buildCapacity(mig.spec.machineType, accelerators, mig.GceRef.Zone)
 	if err != nil {
 		return nil, err
 	}
@@ -274,8 +308,7 @@ func buildLablesForAutoprovisionedMig(mig *Mig, nodeName string) (map[string]str
 func buildGenericLabels(ref GceRef, machineType string, nodeName string) (map[string]string, error) {
 	result := make(map[string]string)
 
-	// TODO: extract it somehow
-	result[kubeletapis.LabelArch] = cloudprovider.DefaultArch
+	result[kubeletapis.LabelArch] = getArchForMachineType(machineType)
 	result[kubeletapis.LabelOS] = cloudprovider.DefaultOS
 
 	result[kubeletapis.LabelInstanceType] = machineType
@@ -289,6 +322,20 @@ func buildGenericLabels(ref GceRef, machineType string, nodeName string) (map[st
 	return result, nil
 }
 
+// arm64MachineFamilies are the prefixes of machine types with Ampere or Axion ARM processors.
+var arm64MachineFamilies = []string{"t2a-", "c4a-"}
+
+// getArchForMachineType returns the arch of nodes of the machine type. It's needed for templates
+// of node groups without nodes to be matched against pods requiring an arch.
+func getArchForMachineType(machineType string) string {
+	for _, family := range arm64MachineFamilies {
+		if strings.HasPrefix(path.Base(machineType), family) {
+			return cloudprovider.Arm64Arch
+		}
+	}
+	return cloudprovider.DefaultArch
+}
+
 func parseCustomMachineType(machineType string) (cpu, mem int64, err error) {
 	// example custom-2-2816
 	var count int
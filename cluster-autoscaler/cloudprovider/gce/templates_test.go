@@ -81,6 +81,41 @@ func TestBuildNodeFromTemplateSetsResources(t *testing.T) {
 		allocatableCpu:    "8000m",
 		allocatableMemory: fmt.Sprintf("%v", 2*1024*1024),
 		expectedErr:       false,
+	}, {
+		kubeEnv: "NODE_LABELS: cloud.google.com/gke-gpu-sharing-strategy=time-sharing,cloud.google.com/gke-max-shared-clients-per-gpu=4\n" +
+			fmt.Sprintf("KUBELET_TEST_ARGS: --experimental-allocatable-ignore-eviction --kube-reserved=cpu=1000m,memory=%v\n", 1024*1024),
+		name:        "nodeName",
+		machineType: "custom-8-2",
+		accelerators: []*gce.AcceleratorConfig{
+			{AcceleratorType: "nvidia-tesla-t4", AcceleratorCount: 2},
+		},
+		mig: &Mig{GceRef: GceRef{
+			Name:    "some-name",
+			Project: "some-proj",
+			Zone:    "us-central1-b"}},
+		capacityCpu:       "8000m",
+		capacityMemory:    fmt.Sprintf("%v", 2*1024*1024),
+		allocatableCpu:    "7000m",
+		allocatableMemory: fmt.Sprintf("%v", 1024*1024),
+		gpuCount:          8,
+		expectedErr:       false,
+	}, {
+		kubeEnv:     "NODE_LABELS: cloud.google.com/gke-gpu-partition-size=1g.5gb\n",
+		name:        "nodeName",
+		machineType: "custom-8-2",
+		accelerators: []*gce.AcceleratorConfig{
+			{AcceleratorType: "nvidia-tesla-a100", AcceleratorCount: 2},
+		},
+		mig: &Mig{GceRef: GceRef{
+			Name:    "some-name",
+			Project: "some-proj",
+			Zone:    "us-central1-b"}},
+		capacityCpu:       "8000m",
+		capacityMemory:    fmt.Sprintf("%v", 2*1024*1024),
+		allocatableCpu:    "8000m",
+		allocatableMemory: fmt.Sprintf("%v", 2*1024*1024),
+		gpuCount:          14,
+		expectedErr:       false,
 	}, {
 		kubeEnv:     "This kube-env is totally messed up",
 		name:        "nodeName",
@@ -118,6 +153,12 @@ func TestBuildNodeFromTemplateSetsResources(t *testing.T) {
 			assert.NoError(t, err)
 			assertEqualResourceLists(t, "Capacity", capacity, node.Status.Capacity)
 			assertEqualResourceLists(t, "Allocatable", allocatable, node.Status.Allocatable)
+			if tc.gpuCount > 0 {
+				assert.Equal(t, tc.accelerators[0].AcceleratorType, node.Labels[gpuLabel])
+			} else {
+				assert.NotContains(t, node.Labels, gpuLabel)
+			}
+			assert.Equal(t, fmt.Sprint(tb.getAcceleratorCount(tc.accelerators)), node.Annotations[acceleratorCountAnnotation])
 		}
 	}
 }
@@ -137,6 +178,22 @@ func TestBuildGenericLabels(t *testing.T) {
 	assert.Equal(t, cloudprovider.DefaultOS, labels[kubeletapis.LabelOS])
 }
 
+func TestGetArchForMachineType(t *testing.T) {
+	assert.Equal(t, cloudprovider.DefaultArch, getArchForMachineType("n1-standard-8"))
+	assert.Equal(t, cloudprovider.DefaultArch, getArchForMachineType("custom-2-2816"))
+	assert.Equal(t, cloudprovider.Arm64Arch, getArchForMachineType("t2a-standard-4"))
+	assert.Equal(t, cloudprovider.Arm64Arch, getArchForMachineType("c4a-highmem-8"))
+	assert.Equal(t, cloudprovider.Arm64Arch, getArchForMachineType("zones/us-central1-a/machineTypes/t2a-standard-1"))
+
+	labels, err := buildGenericLabels(GceRef{
+		Name:    "kubernetes-minion-group",
+		Project: "mwielgus-proj",
+		Zone:    "us-central1-b"},
+		"t2a-standard-4", "sillyname")
+	assert.Nil(t, err)
+	assert.Equal(t, cloudprovider.Arm64Arch, labels[kubeletapis.LabelArch])
+}
+
 func TestBuildLabelsForAutoscaledMigOK(t *testing.T) {
 	labels, err := buildLablesForAutoprovisionedMig(
 		&Mig{
@@ -262,6 +319,18 @@ func TestGetAcceleratorCount(t *testing.T) {
 	}
 }
 
+func TestGetAcceleratorType(t *testing.T) {
+	tb := templateBuilder{}
+	assert.Equal(t, "", tb.getAcceleratorType(nil))
+	assert.Equal(t, "", tb.getAcceleratorType([]*gce.AcceleratorConfig{
+		{AcceleratorType: "other-type", AcceleratorCount: 3},
+	}))
+	assert.Equal(t, "nvidia-tesla-p100", tb.getAcceleratorType([]*gce.AcceleratorConfig{
+		{AcceleratorType: "other-type", AcceleratorCount: 3},
+		{AcceleratorType: "nvidia-tesla-p100", AcceleratorCount: 8},
+	}))
+}
+
 func TestBuildAllocatableFromCapacity(t *testing.T) {
 	type testCase struct {
 		capacityCpu       string
@@ -40,15 +40,22 @@ type OnNodeGroupCreateFunc func(string) error
 // OnNodeGroupDeleteFunc is a function called when a node group is deleted.
 type OnNodeGroupDeleteFunc func(string) error
 
+// OnDeleteInstanceFunc is a function called when an instance outside of node groups is deleted.
+type OnDeleteInstanceFunc func(string) error
+
 // TestCloudProvider is a dummy cloud provider to be used in tests.
 type TestCloudProvider struct {
 	sync.Mutex
 	nodes             map[string]string
+	deletedInstances  map[string]bool
+	instanceStates    map[string]cloudprovider.InstanceState
 	groups            map[string]cloudprovider.NodeGroup
 	onScaleUp         func(string, int) error
 	onScaleDown       func(string, string) error
 	onNodeGroupCreate func(string) error
 	onNodeGroupDelete func(string) error
+	onDeleteInstance  func(string) error
+	networkCapacity   map[string]*cloudprovider.NetworkCapacity
 	machineTypes      []string
 	machineTemplates  map[string]*schedulercache.NodeInfo
 	resourceLimiter   *cloudprovider.ResourceLimiter
@@ -158,6 +165,14 @@ func (tcp *TestCloudProvider) AddNodeGroup(id string, min int, max int, size int
 	}
 }
 
+// RemoveNodeGroup removes node group from test cloud provider, like a node group deleted outside
+// of CA. Nodes of the group are not removed.
+func (tcp *TestCloudProvider) RemoveNodeGroup(id string) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	delete(tcp.groups, id)
+}
+
 // AddAutoprovisionedNodeGroup adds node group to test cloud provider.
 func (tcp *TestCloudProvider) AddAutoprovisionedNodeGroup(id string, min int, max int, size int, machineType string) {
 	tcp.Lock()
@@ -182,6 +197,68 @@ func (tcp *TestCloudProvider) AddNode(nodeGroupId string, node *apiv1.Node) {
 	tcp.nodes[node.Name] = nodeGroupId
 }
 
+// MarkInstanceDeleted removes the instance of the node from its node group, like a spot instance
+// reclaimed by the cloud provider. The node group of the node can still be found.
+func (tcp *TestCloudProvider) MarkInstanceDeleted(nodeName string) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	if tcp.deletedInstances == nil {
+		tcp.deletedInstances = make(map[string]bool)
+	}
+	tcp.deletedInstances[nodeName] = true
+}
+
+// SetInstanceState sets the state of the instance of the node returned by Instances. Instances are
+// running by default.
+func (tcp *TestCloudProvider) SetInstanceState(nodeName string, state cloudprovider.InstanceState) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	if tcp.instanceStates == nil {
+		tcp.instanceStates = make(map[string]cloudprovider.InstanceState)
+	}
+	tcp.instanceStates[nodeName] = state
+}
+
+// SetOnDeleteInstance sets the function called when an instance outside of node groups is deleted.
+func (tcp *TestCloudProvider) SetOnDeleteInstance(onDeleteInstance OnDeleteInstanceFunc) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	tcp.onDeleteInstance = onDeleteInstance
+}
+
+// DeleteInstance deletes the instance with the given provider id. Test nodes use their names
+// as provider ids. Instances of nodes added to a node group are not deleted.
+func (tcp *TestCloudProvider) DeleteInstance(providerID string) error {
+	tcp.Lock()
+	defer tcp.Unlock()
+	if groupName, found := tcp.nodes[providerID]; found {
+		return fmt.Errorf("instance %s belongs to node group %s", providerID, groupName)
+	}
+	if tcp.onDeleteInstance == nil {
+		return nil
+	}
+	return tcp.onDeleteInstance(providerID)
+}
+
+// SetNetworkCapacity sets the network capacity of the given node group. Node groups given the same
+// network share its addresses.
+func (tcp *TestCloudProvider) SetNetworkCapacity(nodeGroupId string, capacity *cloudprovider.NetworkCapacity) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	if tcp.networkCapacity == nil {
+		tcp.networkCapacity = make(map[string]*cloudprovider.NetworkCapacity)
+	}
+	tcp.networkCapacity[nodeGroupId] = capacity
+}
+
+// NodeGroupNetworkCapacity returns the capacity set with SetNetworkCapacity, or nil if none was set
+// for the node group.
+func (tcp *TestCloudProvider) NodeGroupNetworkCapacity(nodeGroup cloudprovider.NodeGroup, nodes []*apiv1.Node) (*cloudprovider.NetworkCapacity, error) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	return tcp.networkCapacity[nodeGroup.Id()], nil
+}
+
 // GetResourceLimiter returns struct containing limits (max, min) for resources (cores, memory etc.).
 func (tcp *TestCloudProvider) GetResourceLimiter() (*cloudprovider.ResourceLimiter, error) {
 	return tcp.resourceLimiter, nil
@@ -335,13 +412,32 @@ func (tng *TestNodeGroup) Nodes() ([]string, error) {
 
 	result := make([]string, 0)
 	for node, nodegroup := range tng.cloudProvider.nodes {
-		if nodegroup == tng.id {
+		if nodegroup == tng.id && !tng.cloudProvider.deletedInstances[node] {
 			result = append(result, node)
 		}
 	}
 	return result, nil
 }
 
+// Instances returns all instances of the node group with states set with SetInstanceState.
+func (tng *TestNodeGroup) Instances() ([]cloudprovider.Instance, error) {
+	nodes, err := tng.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	tng.cloudProvider.Lock()
+	defer tng.cloudProvider.Unlock()
+	result := make([]cloudprovider.Instance, 0, len(nodes))
+	for _, node := range nodes {
+		state, found := tng.cloudProvider.instanceStates[node]
+		if !found {
+			state = cloudprovider.InstanceRunning
+		}
+		result = append(result, cloudprovider.Instance{Id: node, State: state})
+	}
+	return result, nil
+}
+
 // Autoprovisioned returns true if the node group is autoprovisioned.
 func (tng *TestNodeGroup) Autoprovisioned() bool {
 	return tng.autoprovisioned
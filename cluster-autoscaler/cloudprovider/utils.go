@@ -30,6 +30,8 @@ import (
 const (
 	// DefaultArch is the default Arch for GenericLabels
 	DefaultArch = "amd64"
+	// Arm64Arch is the Arch of machines with 64-bit ARM processors
+	Arm64Arch = "arm64"
 	// DefaultOS is the default OS for GenericLabels
 	DefaultOS = "linux"
 	// KubeProxyCpuRequestMillis is the amount of cpu requested by Kubeproxy
@@ -35,6 +35,18 @@ const (
 	// ClusterAutoscalerScaleUp is a condition that explains what is the current status
 	// of a node group with regard to scale down activities.
 	ClusterAutoscalerScaleUp ClusterAutoscalerConditionType = "ScaleUp"
+	// ClusterAutoscalerHeadroom is a condition that explains whether the spare capacity
+	// requested with headroom specs is available.
+	ClusterAutoscalerHeadroom ClusterAutoscalerConditionType = "Headroom"
+	// ClusterAutoscalerCapacityReservations is a condition that explains whether the capacity
+	// reserved for namespaces is available.
+	ClusterAutoscalerCapacityReservations ClusterAutoscalerConditionType = "CapacityReservations"
+	// ClusterAutoscalerScaleUpLimits is a condition that explains which cluster-wide limits keep
+	// pending pods from triggering a scale-up. It's only reported while some pods are blocked.
+	ClusterAutoscalerScaleUpLimits ClusterAutoscalerConditionType = "ScaleUpLimits"
+	// ClusterAutoscalerDrift is a condition that explains how the cloud provider view of a node
+	// group differs from the cluster. It's only reported for node groups that drifted.
+	ClusterAutoscalerDrift ClusterAutoscalerConditionType = "Drift"
 )
 
 // ClusterAutoscalerConditionStatus is a status of ClusterAutoscalerCondition.
@@ -67,6 +79,31 @@ const (
 	ClusterAutoscalerNoActivity ClusterAutoscalerConditionStatus = "NoActivity"
 	// ClusterAutoscalerBackoff status means that due to a recently failed scale-up no further scale-ups attempts will be made for some time.
 	ClusterAutoscalerBackoff ClusterAutoscalerConditionStatus = "Backoff"
+
+	// Statuses for Headroom condition type.
+
+	// ClusterAutoscalerHeadroomAvailable status means that all requested headroom is available.
+	ClusterAutoscalerHeadroomAvailable ClusterAutoscalerConditionStatus = "Available"
+	// ClusterAutoscalerHeadroomMissing status means that some requested headroom is not available.
+	ClusterAutoscalerHeadroomMissing ClusterAutoscalerConditionStatus = "Missing"
+
+	// Statuses for CapacityReservations condition type.
+
+	// ClusterAutoscalerCapacityReservationsSatisfied status means that all reserved capacity is available.
+	ClusterAutoscalerCapacityReservationsSatisfied ClusterAutoscalerConditionStatus = "Satisfied"
+	// ClusterAutoscalerCapacityReservationsUnsatisfied status means that some reserved capacity is not available.
+	ClusterAutoscalerCapacityReservationsUnsatisfied ClusterAutoscalerConditionStatus = "Unsatisfied"
+
+	// Statuses for ScaleUpLimits condition type.
+
+	// ClusterAutoscalerLimitReached status means that pending pods need nodes above a cluster-wide limit.
+	ClusterAutoscalerLimitReached ClusterAutoscalerConditionStatus = "LimitReached"
+
+	// Statuses for Drift condition type.
+
+	// ClusterAutoscalerDrifted status means that the node group has missing nodes, extra instances
+	// or a target size not matching its instances.
+	ClusterAutoscalerDrifted ClusterAutoscalerConditionStatus = "Drifted"
 )
 
 // ClusterAutoscalerCondition describes some aspect of ClusterAutoscaler work.
@@ -88,10 +125,42 @@ type ClusterAutoscalerCondition struct {
 
 // ClusterAutoscalerStatus contains ClusterAutoscaler status.
 type ClusterAutoscalerStatus struct {
+	// BuildInfo identifies the CA build and configuration producing the status.
+	BuildInfo *BuildInfo `json:"buildInfo,omitempty"`
+	// ActiveTimeProfile is the time profile of the configuration file in effect, if any.
+	ActiveTimeProfile string `json:"activeTimeProfile,omitempty"`
 	// NodeGroupStatuses contains status information of individual node groups on which CA works.
 	NodeGroupStatuses []NodeGroupStatus `json:"nodeGroupStatuses,omitempty"`
 	// ClusterwideConditions contains conditions that apply to the whole autoscaler.
 	ClusterwideConditions []ClusterAutoscalerCondition `json:"clusterwideConditions,omitempty"`
+	// PlannedScaleUps contains scale-ups skipped in the last loop because CA runs in dry-run mode.
+	PlannedScaleUps []PlannedScaleUp `json:"plannedScaleUps,omitempty"`
+}
+
+// PlannedScaleUp describes a node group size increase CA would make if it wasn't running in dry-run mode.
+type PlannedScaleUp struct {
+	// NodeGroup is the id of the node group that would be scaled up.
+	NodeGroup string `json:"nodeGroup"`
+	// CurrentSize is the target size of the node group before the scale-up.
+	CurrentSize int `json:"currentSize"`
+	// NewSize is the target size of the node group after the scale-up.
+	NewSize int `json:"newSize"`
+	// Delta is the number of nodes that would be added.
+	Delta int `json:"delta"`
+	// Pods are namespace/name of pending pods that triggered the scale-up.
+	Pods []string `json:"pods,omitempty"`
+}
+
+// BuildInfo identifies the CA build and configuration in use.
+type BuildInfo struct {
+	// Version is the CA version.
+	Version string `json:"version,omitempty"`
+	// GitCommit is the commit CA was built from.
+	GitCommit string `json:"gitCommit,omitempty"`
+	// CloudProvider is the name of the cloud provider in use.
+	CloudProvider string `json:"cloudProvider,omitempty"`
+	// OptionsHash identifies the effective autoscaling options, including options reloaded at runtime.
+	OptionsHash string `json:"optionsHash,omitempty"`
 }
 
 // NodeGroupStatus contains status of a group of nodes controlled by ClusterAutoscaler.
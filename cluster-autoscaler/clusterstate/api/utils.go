@@ -19,6 +19,7 @@ package api
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
 // GetConditionByType gets condition by type.
@@ -77,8 +78,22 @@ func getConditionsString(autoscalerConditions []ClusterAutoscalerCondition, pref
 // GetReadableString produces human-redable description of status.
 func (status ClusterAutoscalerStatus) GetReadableString() string {
 	var buffer bytes.Buffer
+	if status.BuildInfo != nil {
+		buffer.WriteString(fmt.Sprintf("Build: version=%s gitCommit=%s cloudProvider=%s optionsHash=%s\n\n",
+			status.BuildInfo.Version, status.BuildInfo.GitCommit, status.BuildInfo.CloudProvider, status.BuildInfo.OptionsHash))
+	}
+	if status.ActiveTimeProfile != "" {
+		buffer.WriteString(fmt.Sprintf("Active time profile: %s\n\n", status.ActiveTimeProfile))
+	}
 	buffer.WriteString("Cluster-wide:\n")
 	buffer.WriteString(getConditionsString(status.ClusterwideConditions, "  "))
+	if len(status.PlannedScaleUps) > 0 {
+		buffer.WriteString("\nPlanned scale-ups (dry-run):\n")
+		for _, planned := range status.PlannedScaleUps {
+			buffer.WriteString(fmt.Sprintf("  NodeGroup:   %s currentSize=%d newSize=%d delta=%d pods=%s\n",
+				planned.NodeGroup, planned.CurrentSize, planned.NewSize, planned.Delta, strings.Join(planned.Pods, ",")))
+		}
+	}
 	if len(status.NodeGroupStatuses) == 0 {
 		return buffer.String()
 	}
@@ -89,3 +89,22 @@ func TestGetStringNodeGroups(t *testing.T) {
 	assert.Regexp(t, regexp.MustCompile("(?ms)NodeGroups:.*Name:\\s*ng1"), result)
 	assert.Regexp(t, regexp.MustCompile("(?ms)NodeGroups:.*Name:\\s*ng2"), result)
 }
+
+func TestGetStringBuildInfo(t *testing.T) {
+	status := ClusterAutoscalerStatus{
+		BuildInfo: &BuildInfo{Version: "1.0.0", GitCommit: "abc123", CloudProvider: "gce", OptionsHash: "0011"},
+	}
+	result := status.GetReadableString()
+	assert.Regexp(t, regexp.MustCompile("^Build: version=1.0.0 gitCommit=abc123 cloudProvider=gce optionsHash=0011\n\nCluster-wide:"), result)
+}
+
+func TestGetStringPlannedScaleUps(t *testing.T) {
+	status := ClusterAutoscalerStatus{
+		PlannedScaleUps: []PlannedScaleUp{
+			{NodeGroup: "ng1", CurrentSize: 1, NewSize: 3, Delta: 2, Pods: []string{"default/p1", "default/p2"}},
+		},
+	}
+	result := status.GetReadableString()
+	assert.Regexp(t, regexp.MustCompile("(?ms)Planned scale-ups \\(dry-run\\):\n  NodeGroup:\\s*ng1 currentSize=1 newSize=3 delta=2 pods=default/p1,default/p2\n"), result)
+	assert.NotRegexp(t, regexp.MustCompile("Planned scale-ups"), ClusterAutoscalerStatus{}.GetReadableString())
+}
@@ -18,7 +18,10 @@ package clusterstate
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +35,7 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 
 	"github.com/golang/glog"
 )
@@ -52,6 +56,22 @@ const (
 
 	// NodeGroupBackoffResetTimeout is the time after last failed scale-up when the backoff duration is reset.
 	NodeGroupBackoffResetTimeout = 3 * time.Hour
+
+	// deletedInstanceMinNodeAge is how old a node has to be before a missing instance is treated as
+	// deleted. Instance lists of some cloud providers lag behind newly created instances.
+	deletedInstanceMinNodeAge = 2 * time.Minute
+
+	// provisionTimeSamples is the number of recent successful scale-ups kept per node group to
+	// compute the typical provision time.
+	provisionTimeSamples = 10
+
+	// adaptiveProvisionTimeoutMinSamples is the number of successful scale-ups of a node group
+	// needed before its provision timeout is derived from their durations.
+	adaptiveProvisionTimeoutMinSamples = 3
+
+	// failedScaleUpRetention is how long nodes added by a failed scale-up are reported as wasted
+	// while its pods are still pending.
+	failedScaleUpRetention = time.Hour
 )
 
 // ScaleUpRequest contains information about the requested node group scale up.
@@ -64,6 +84,30 @@ type ScaleUpRequest struct {
 	ExpectedAddTime time.Time
 	// How much the node group is increased.
 	Increase int
+	// OperationId identifies the cloud provider operation performing the resize. It is only
+	// set for node groups implementing cloudprovider.AsyncNodeGroup.
+	OperationId string
+	// operationDone is set once the resize operation is known to have finished.
+	operationDone bool
+	// Pods are the namespace/name keys of the pods the scale-up was requested for.
+	Pods []string
+}
+
+// FailedScaleUp is a scale-up request that failed, timed out or was only partially fulfilled.
+type FailedScaleUp struct {
+	// Request is the failed scale-up request.
+	Request *ScaleUpRequest
+	// FailureTime is the time when the failure was found.
+	FailureTime time.Time
+}
+
+// WastedNode is a node added by a failed scale-up while the pods the scale-up was requested for
+// are still pending. The pods needed more nodes than they got, so the node is likely to stay idle.
+type WastedNode struct {
+	// Node is the wasted node.
+	Node *apiv1.Node
+	// ScaleUp is the failed scale-up that added the node.
+	ScaleUp FailedScaleUp
 }
 
 // ScaleDownRequest contains information about the requested node deletion.
@@ -76,6 +120,8 @@ type ScaleDownRequest struct {
 	Time time.Time
 	// ExpectedDeleteTime is the time when the node is excpected to be deleted.
 	ExpectedDeleteTime time.Time
+	// EstimatedSavingsPerHour is the hourly price of the deleted node in USD, 0 if it's unknown.
+	EstimatedSavingsPerHour float64
 }
 
 // ClusterStateRegistryConfig contains configuration information for ClusterStateRegistry.
@@ -86,6 +132,46 @@ type ClusterStateRegistryConfig struct {
 	OkTotalUnreadyCount int
 	//  Maximum time CA waits for node to be provisioned
 	MaxNodeProvisionTime time.Duration
+	// AdaptiveProvisionTimeout makes the provision timeout of node groups with enough finished
+	// scale-ups ProvisionTimeoutFactor times their p95 provision time, instead of MaxNodeProvisionTime.
+	AdaptiveProvisionTimeout bool
+	// ProvisionTimeoutFactor multiplies the p95 provision time of a node group to get its timeout.
+	ProvisionTimeoutFactor float64
+	// MinProvisionTimeout is the lower bound of adaptive provision timeouts.
+	MinProvisionTimeout time.Duration
+	// MaxProvisionTimeout is the upper bound of adaptive provision timeouts.
+	MaxProvisionTimeout time.Duration
+	// StockoutMemoryTTL is how long an instance type and zone are reported as stocked out after
+	// a scale-up of a node group with such machines ran out of resources. 0 disables this.
+	StockoutMemoryTTL time.Duration
+}
+
+// InstanceTypeZone identifies machines of the same type in the same zone. A scale-up that runs out
+// of resources is likely to fail in every node group creating such machines.
+type InstanceTypeZone struct {
+	InstanceType string
+	Zone         string
+}
+
+// InstanceTypeZoneOfNode returns the instance type and zone of the node from its labels. False
+// if the node has no instance type label.
+func InstanceTypeZoneOfNode(node *apiv1.Node) (InstanceTypeZone, bool) {
+	instanceType := node.Labels[kubeletapis.LabelInstanceType]
+	if instanceType == "" {
+		return InstanceTypeZone{}, false
+	}
+	return InstanceTypeZone{InstanceType: instanceType, Zone: node.Labels[kubeletapis.LabelZoneFailureDomain]}, true
+}
+
+// ProvisionTimeStats describes how long recent successful scale-ups of a node group took from the
+// request until the new nodes were ready.
+type ProvisionTimeStats struct {
+	// P50 is the median provision time.
+	P50 time.Duration
+	// P95 is the 95th percentile of provision times.
+	P95 time.Duration
+	// Samples is the number of scale-ups the percentiles are computed from.
+	Samples int
 }
 
 // IncorrectNodeGroupSize contains information about how much the current size of the node group
@@ -100,6 +186,61 @@ type IncorrectNodeGroupSize struct {
 	FirstObserved time.Time
 }
 
+// PartialScaleUp contains information about a node group whose target size was increased but the
+// cloud provider stopped adding instances before reaching it. Some providers (e.g. spot pools or
+// capacity-constrained regions) fulfill only a part of a resize and silently keep the target.
+type PartialScaleUp struct {
+	// TargetSize is the target size of the node group on the cloud provider side.
+	TargetSize int
+	// FulfilledSize is the number of instances the cloud provider actually created.
+	FulfilledSize int
+	// FirstObserved is the time when the shortfall was detected.
+	FirstObserved time.Time
+}
+
+// HeadroomStatus describes how much of the spare capacity requested with a headroom spec is available.
+type HeadroomStatus struct {
+	// Spec is the headroom spec.
+	Spec string
+	// Current is the number of headroom units that fit on existing nodes.
+	Current int
+	// Desired is the requested number of headroom units.
+	Desired int
+}
+
+// CapacityReservationStatus describes how much of the capacity reserved for a namespace is missing.
+type CapacityReservationStatus struct {
+	// Reservation is the capacity reservation.
+	Reservation string
+	// MissingMilliCPU is the reserved CPU neither used by the namespace nor free, in millicores.
+	MissingMilliCPU int64
+	// MissingMemory is the reserved memory neither used by the namespace nor free, in bytes.
+	MissingMemory int64
+}
+
+// ScaleUpLimitStatus describes a cluster-wide limit that kept pending pods from triggering a scale-up.
+type ScaleUpLimitStatus struct {
+	// Limit is the name of the limit: nodes, cores or memory.
+	Limit string
+	// Current is the current cluster size in units of the limit.
+	Current int64
+	// Max is the maximum cluster size in units of the limit.
+	Max int64
+	// BlockedPods is the number of pending pods blocked by the limit.
+	BlockedPods int
+}
+
+// Satisfied returns true if no reserved capacity is missing.
+func (s CapacityReservationStatus) Satisfied() bool {
+	return s.MissingMilliCPU <= 0 && s.MissingMemory <= 0
+}
+
+// instanceCount tracks how many instances a node group has on the cloud provider side.
+type instanceCount struct {
+	count        int
+	lastIncrease time.Time
+}
+
 // UnregisteredNode contains information about nodes that are present on the cluster provider side
 // but failed to register in Kubernetes.
 type UnregisteredNode struct {
@@ -113,6 +254,25 @@ type scaleUpBackoff struct {
 	duration          time.Duration
 	backoffUntil      time.Time
 	lastFailedScaleUp time.Time
+	// partialScaleUp is set if the backoff was caused by a partially fulfilled scale-up.
+	partialScaleUp *PartialScaleUp
+}
+
+// nodeGroupLimits are the size limits of a node group.
+type nodeGroupLimits struct {
+	minSize int
+	maxSize int
+}
+
+// DeletedInstanceNode contains information about a node registered in Kubernetes whose instance no
+// longer exists on the cloud provider side, for example after spot or preemptible instance reclamation.
+type DeletedInstanceNode struct {
+	// Node is the node object left behind by the instance.
+	Node *apiv1.Node
+	// NodeGroupId is the node group the instance belonged to.
+	NodeGroupId string
+	// DeletedSince is the time when the instance was first found missing.
+	DeletedSince time.Time
 }
 
 // ClusterStateRegistry is a structure to keep track the current state of the cluster.
@@ -128,8 +288,31 @@ type ClusterStateRegistry struct {
 	acceptableRanges        map[string]AcceptableRange
 	incorrectNodeGroupSizes map[string]IncorrectNodeGroupSize
 	unregisteredNodes       map[string]UnregisteredNode
+	deletedInstanceNodes    map[string]DeletedInstanceNode
+	nodeGroupsOfNodes       map[string]string
 	candidatesForScaleDown  map[string][]string
+	// estimatedSavings holds, per node group, the sum of hourly prices of all nodes
+	// removed by scale-down since the registry was created.
+	estimatedSavings        map[string]float64
 	nodeGroupBackoffInfo    map[string]scaleUpBackoff
+	instanceCounts          map[string]instanceCount
+	partialScaleUps         map[string]PartialScaleUp
+	drift                   map[string]NodeGroupDrift
+	headroomStatuses        []HeadroomStatus
+	lastHeadroomUpdateTime  time.Time
+	reservationStatuses     []CapacityReservationStatus
+	lastReservationUpdate   time.Time
+	scaleUpLimitStatuses    []ScaleUpLimitStatus
+	lastScaleUpLimitUpdate  time.Time
+	plannedScaleUps         []api.PlannedScaleUp
+	provisionTimes          map[string][]time.Duration
+	knownNodeGroups         map[string]nodeGroupLimits
+	stockouts               map[InstanceTypeZone]time.Time
+	lastScaleUpTimes        map[string]time.Time
+	failedScaleUps          []FailedScaleUp
+	failedScaleUpNodes      map[string]int
+	buildInfo               *api.BuildInfo
+	activeTimeProfile       string
 	lastStatus              *api.ClusterAutoscalerStatus
 	lastScaleDownUpdateTime time.Time
 	logRecorder             *utils.LogEventRecorder
@@ -151,8 +334,20 @@ func NewClusterStateRegistry(cloudProvider cloudprovider.CloudProvider, config C
 		acceptableRanges:        make(map[string]AcceptableRange),
 		incorrectNodeGroupSizes: make(map[string]IncorrectNodeGroupSize),
 		unregisteredNodes:       make(map[string]UnregisteredNode),
+		deletedInstanceNodes:    make(map[string]DeletedInstanceNode),
+		nodeGroupsOfNodes:       make(map[string]string),
 		candidatesForScaleDown:  make(map[string][]string),
+		estimatedSavings:        make(map[string]float64),
 		nodeGroupBackoffInfo:    make(map[string]scaleUpBackoff),
+		instanceCounts:          make(map[string]instanceCount),
+		partialScaleUps:         make(map[string]PartialScaleUp),
+		drift:                   make(map[string]NodeGroupDrift),
+		provisionTimes:          make(map[string][]time.Duration),
+		knownNodeGroups:         make(map[string]nodeGroupLimits),
+		stockouts:               make(map[InstanceTypeZone]time.Time),
+		lastScaleUpTimes:        make(map[string]time.Time),
+		failedScaleUps:          make([]FailedScaleUp, 0),
+		failedScaleUpNodes:      make(map[string]int),
 		lastStatus:              emptyStatus,
 		logRecorder:             logRecorder,
 	}
@@ -163,6 +358,93 @@ func (csr *ClusterStateRegistry) RegisterScaleUp(request *ScaleUpRequest) {
 	csr.Lock()
 	defer csr.Unlock()
 	csr.scaleUpRequests = append(csr.scaleUpRequests, request)
+	if request.Time.After(csr.lastScaleUpTimes[request.NodeGroupName]) {
+		csr.lastScaleUpTimes[request.NodeGroupName] = request.Time
+	}
+}
+
+// GetLastScaleUpTimes returns the time of the last scale up of each node group scaled up since CA started.
+func (csr *ClusterStateRegistry) GetLastScaleUpTimes() map[string]time.Time {
+	csr.Lock()
+	defer csr.Unlock()
+	result := make(map[string]time.Time, len(csr.lastScaleUpTimes))
+	for id, scaleUpTime := range csr.lastScaleUpTimes {
+		result[id] = scaleUpTime
+	}
+	return result
+}
+
+// registerFailedScaleUpRequest remembers a failed scale-up request, so that nodes it added can be
+// found wasted. Requests without pods are not remembered.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) registerFailedScaleUpRequest(request *ScaleUpRequest, currentTime time.Time) {
+	if len(request.Pods) == 0 {
+		return
+	}
+	csr.failedScaleUps = append(csr.failedScaleUps, FailedScaleUp{Request: request, FailureTime: currentTime})
+}
+
+// TakeFailedScaleUpNodes returns the number of nodes that scale-ups failed to add, by node group,
+// found since the previous call.
+func (csr *ClusterStateRegistry) TakeFailedScaleUpNodes() map[string]int {
+	csr.Lock()
+	defer csr.Unlock()
+	result := csr.failedScaleUpNodes
+	csr.failedScaleUpNodes = make(map[string]int)
+	return result
+}
+
+// GetWastedNodes returns nodes added by failed scale-ups whose pods are still pending, by node name.
+// A node was added by a scale-up if it is in its node group and was created between the request and
+// the failure. Failed scale-ups are forgotten once none of their pods is pending, or after
+// failedScaleUpRetention.
+func (csr *ClusterStateRegistry) GetWastedNodes(pendingPods []*apiv1.Pod, currentTime time.Time) map[string]WastedNode {
+	csr.Lock()
+	defer csr.Unlock()
+	pending := make(map[string]bool, len(pendingPods))
+	for _, pod := range pendingPods {
+		pending[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)] = true
+	}
+	csr.expireFailedScaleUps(currentTime)
+	result := make(map[string]WastedNode)
+	failedScaleUps := make([]FailedScaleUp, 0, len(csr.failedScaleUps))
+	for _, failed := range csr.failedScaleUps {
+		podsPending := false
+		for _, pod := range failed.Request.Pods {
+			if pending[pod] {
+				podsPending = true
+				break
+			}
+		}
+		if !podsPending {
+			glog.V(4).Infof("Pods of the failed scale-up of %s requested at %v are no longer pending",
+				failed.Request.NodeGroupName, failed.Request.Time)
+			continue
+		}
+		failedScaleUps = append(failedScaleUps, failed)
+		for _, node := range csr.nodes {
+			created := node.CreationTimestamp.Time
+			if csr.nodeGroupsOfNodes[node.Name] != failed.Request.NodeGroupName ||
+				created.Before(failed.Request.Time) || created.After(failed.FailureTime) {
+				continue
+			}
+			result[node.Name] = WastedNode{Node: node, ScaleUp: failed}
+		}
+	}
+	csr.failedScaleUps = failedScaleUps
+	return result
+}
+
+// expireFailedScaleUps forgets failed scale-ups older than failedScaleUpRetention.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) expireFailedScaleUps(currentTime time.Time) {
+	failedScaleUps := make([]FailedScaleUp, 0, len(csr.failedScaleUps))
+	for _, failed := range csr.failedScaleUps {
+		if !failed.FailureTime.Add(failedScaleUpRetention).Before(currentTime) {
+			failedScaleUps = append(failedScaleUps, failed)
+		}
+	}
+	csr.failedScaleUps = failedScaleUps
 }
 
 // RegisterScaleDown registers node scale down.
@@ -170,6 +452,9 @@ func (csr *ClusterStateRegistry) RegisterScaleDown(request *ScaleDownRequest) {
 	csr.Lock()
 	defer csr.Unlock()
 	csr.scaleDownRequests = append(csr.scaleDownRequests, request)
+	if request.EstimatedSavingsPerHour > 0 {
+		csr.estimatedSavings[request.NodeGroupName] += request.EstimatedSavingsPerHour
+	}
 }
 
 // To be executed under a lock.
@@ -180,6 +465,13 @@ func (csr *ClusterStateRegistry) updateScaleRequests(currentTime time.Time) {
 			delete(csr.nodeGroupBackoffInfo, ngId)
 		}
 	}
+	for key, stockoutTime := range csr.stockouts {
+		if !stockoutTime.Add(csr.config.StockoutMemoryTTL).After(currentTime) {
+			delete(csr.stockouts, key)
+		}
+	}
+	// Failed scale-ups are also forgotten when nobody asks for wasted nodes.
+	csr.expireFailedScaleUps(currentTime)
 
 	timedOutSur := make([]*ScaleUpRequest, 0)
 	newSur := make([]*ScaleUpRequest, 0)
@@ -190,6 +482,7 @@ func (csr *ClusterStateRegistry) updateScaleRequests(currentTime time.Time) {
 			delete(csr.nodeGroupBackoffInfo, sur.NodeGroupName)
 			glog.V(4).Infof("Scale up in group %v finished successfully in %v",
 				sur.NodeGroupName, currentTime.Sub(sur.Time))
+			csr.recordProvisionTime(sur.NodeGroupName, currentTime.Sub(sur.Time))
 			continue
 		}
 		if sur.ExpectedAddTime.After(currentTime) {
@@ -213,6 +506,8 @@ func (csr *ClusterStateRegistry) updateScaleRequests(currentTime time.Time) {
 				sur.NodeGroupName, currentTime.Sub(sur.Time))
 			metrics.RegisterFailedScaleUp(metrics.Timeout)
 			csr.backoffNodeGroup(sur.NodeGroupName, currentTime)
+			csr.registerFailedScaleUpRequest(sur, currentTime)
+			csr.failedScaleUpNodes[sur.NodeGroupName] += minInt(sur.Increase, csr.upcomingNodesInNodeGroup(sur.NodeGroupName))
 		}
 	}
 
@@ -225,6 +520,84 @@ func (csr *ClusterStateRegistry) updateScaleRequests(currentTime time.Time) {
 	csr.scaleDownRequests = newSdr
 }
 
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) recordProvisionTime(nodeGroupName string, duration time.Duration) {
+	samples := append(csr.provisionTimes[nodeGroupName], duration)
+	if len(samples) > provisionTimeSamples {
+		samples = samples[len(samples)-provisionTimeSamples:]
+	}
+	csr.provisionTimes[nodeGroupName] = samples
+	stats, _ := csr.provisionTimeStats(nodeGroupName)
+	metrics.UpdateNodeGroupProvisionTime(nodeGroupName, stats.P50, stats.P95)
+}
+
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) provisionTimeStats(nodeGroupName string) (ProvisionTimeStats, bool) {
+	samples := csr.provisionTimes[nodeGroupName]
+	if len(samples) == 0 {
+		return ProvisionTimeStats{}, false
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return ProvisionTimeStats{
+		P50:     percentile(sorted, 0.5),
+		P95:     percentile(sorted, 0.95),
+		Samples: len(sorted),
+	}, true
+}
+
+// percentile returns the nearest-rank percentile of sorted, non-empty durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// GetProvisionTimeStats returns statistics of recent successful scale-ups of the node group.
+// Returns false if no scale-up of the node group finished yet.
+func (csr *ClusterStateRegistry) GetProvisionTimeStats(nodeGroupName string) (ProvisionTimeStats, bool) {
+	csr.Lock()
+	defer csr.Unlock()
+	return csr.provisionTimeStats(nodeGroupName)
+}
+
+// GetTypicalProvisionTime returns the median time recent successful scale-ups of the node group took
+// from the request until the new nodes started. Returns false if no scale-up of the node group
+// finished yet.
+func (csr *ClusterStateRegistry) GetTypicalProvisionTime(nodeGroupName string) (time.Duration, bool) {
+	stats, found := csr.GetProvisionTimeStats(nodeGroupName)
+	return stats.P50, found
+}
+
+// GetProvisionTimeout returns how long CA waits for nodes of the node group to be provisioned.
+func (csr *ClusterStateRegistry) GetProvisionTimeout(nodeGroupName string) time.Duration {
+	csr.Lock()
+	defer csr.Unlock()
+	return csr.provisionTimeout(nodeGroupName)
+}
+
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) provisionTimeout(nodeGroupName string) time.Duration {
+	if !csr.config.AdaptiveProvisionTimeout {
+		return csr.config.MaxNodeProvisionTime
+	}
+	stats, found := csr.provisionTimeStats(nodeGroupName)
+	if !found || stats.Samples < adaptiveProvisionTimeoutMinSamples {
+		return csr.config.MaxNodeProvisionTime
+	}
+	timeout := time.Duration(float64(stats.P95) * csr.config.ProvisionTimeoutFactor)
+	if timeout < csr.config.MinProvisionTimeout {
+		return csr.config.MinProvisionTimeout
+	}
+	if timeout > csr.config.MaxProvisionTimeout {
+		return csr.config.MaxProvisionTimeout
+	}
+	return timeout
+}
+
 // To be executed under a lock.
 func (csr *ClusterStateRegistry) backoffNodeGroup(nodeGroupName string, currentTime time.Time) {
 	duration := InitialNodeGroupBackoffDuration
@@ -257,6 +630,136 @@ func (csr *ClusterStateRegistry) RegisterFailedScaleUp(nodeGroupName string, rea
 
 	metrics.RegisterFailedScaleUp(reason)
 	csr.backoffNodeGroup(nodeGroupName, time.Now())
+	if reason == metrics.OutOfResources {
+		csr.registerStockout(nodeGroupName, time.Now())
+	}
+}
+
+// IsStockedOut returns true if a scale-up of machines of the instance type and zone ran out of
+// resources within StockoutMemoryTTL before now.
+func (csr *ClusterStateRegistry) IsStockedOut(key InstanceTypeZone, now time.Time) bool {
+	csr.Lock()
+	defer csr.Unlock()
+	stockoutTime, found := csr.stockouts[key]
+	return found && stockoutTime.Add(csr.config.StockoutMemoryTTL).After(now)
+}
+
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) registerStockout(nodeGroupName string, currentTime time.Time) {
+	key, found := csr.instanceTypeZoneOfNodeGroup(nodeGroupName)
+	if !found {
+		glog.V(4).Infof("Unknown instance type of node group %s, stockout not recorded", nodeGroupName)
+		return
+	}
+	metrics.RegisterStockout(key.InstanceType, key.Zone)
+	if csr.config.StockoutMemoryTTL <= 0 {
+		return
+	}
+	glog.Warningf("No capacity for %s in zone %q, node groups with such machines are avoided in scale-up until %v",
+		key.InstanceType, key.Zone, currentTime.Add(csr.config.StockoutMemoryTTL))
+	csr.stockouts[key] = currentTime
+}
+
+// instanceTypeZoneOfNodeGroup returns the instance type and zone of the machines created by the
+// node group, from its template or, if the template is not available, from its registered nodes.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) instanceTypeZoneOfNodeGroup(nodeGroupName string) (InstanceTypeZone, bool) {
+	for _, nodeGroup := range csr.cloudProvider.NodeGroups() {
+		if nodeGroup.Id() != nodeGroupName {
+			continue
+		}
+		if nodeInfo, err := nodeGroup.TemplateNodeInfo(); err == nil {
+			if key, found := InstanceTypeZoneOfNode(nodeInfo.Node()); found {
+				return key, true
+			}
+		}
+		break
+	}
+	for _, node := range csr.nodes {
+		if csr.nodeGroupsOfNodes[node.Name] != nodeGroupName {
+			continue
+		}
+		if key, found := InstanceTypeZoneOfNode(node); found {
+			return key, true
+		}
+	}
+	return InstanceTypeZone{}, false
+}
+
+// getScaleUpOperationStatuses polls cloud provider for the status of resize operations
+// of scale-up requests that haven't finished yet.
+func (csr *ClusterStateRegistry) getScaleUpOperationStatuses() map[*ScaleUpRequest]cloudprovider.OperationStatus {
+	csr.Lock()
+	pending := make([]*ScaleUpRequest, 0)
+	for _, sur := range csr.scaleUpRequests {
+		if sur.OperationId != "" && !sur.operationDone {
+			pending = append(pending, sur)
+		}
+	}
+	csr.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	asyncNodeGroups := make(map[string]cloudprovider.AsyncNodeGroup)
+	for _, nodeGroup := range csr.cloudProvider.NodeGroups() {
+		if asyncNodeGroup, ok := nodeGroup.(cloudprovider.AsyncNodeGroup); ok {
+			asyncNodeGroups[nodeGroup.Id()] = asyncNodeGroup
+		}
+	}
+	result := make(map[*ScaleUpRequest]cloudprovider.OperationStatus)
+	for _, sur := range pending {
+		nodeGroup, found := asyncNodeGroups[sur.NodeGroupName]
+		if !found {
+			continue
+		}
+		status, err := nodeGroup.GetOperationStatus(sur.OperationId)
+		if err != nil {
+			glog.Warningf("Failed to get status of operation %s for node group %s: %v", sur.OperationId, sur.NodeGroupName, err)
+			continue
+		}
+		result[sur] = status
+	}
+	return result
+}
+
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) handleScaleUpOperationStatuses(statuses map[*ScaleUpRequest]cloudprovider.OperationStatus, currentTime time.Time) {
+	failed := make(map[*ScaleUpRequest]bool)
+	for sur, status := range statuses {
+		if !status.Done {
+			continue
+		}
+		sur.operationDone = true
+		if status.Error == nil {
+			continue
+		}
+		failed[sur] = true
+		glog.Warningf("Scale-up of node group %v by %d requested at %v failed: %v",
+			sur.NodeGroupName, sur.Increase, sur.Time, status.Error)
+		csr.logRecorder.Eventf(apiv1.EventTypeWarning, "ScaleUpOperationFailed",
+			"Scale-up of group %s by %d requested at %v failed: %v",
+			sur.NodeGroupName, sur.Increase, sur.Time, status.Error)
+		if _, ok := status.Error.(*cloudprovider.OutOfResourcesError); ok {
+			metrics.RegisterFailedScaleUp(metrics.OutOfResources)
+			csr.registerStockout(sur.NodeGroupName, currentTime)
+		} else {
+			metrics.RegisterFailedScaleUp(metrics.APIError)
+		}
+		csr.backoffNodeGroup(sur.NodeGroupName, currentTime)
+		csr.registerFailedScaleUpRequest(sur, currentTime)
+		csr.failedScaleUpNodes[sur.NodeGroupName] += sur.Increase
+	}
+	if len(failed) == 0 {
+		return
+	}
+	newSur := make([]*ScaleUpRequest, 0, len(csr.scaleUpRequests))
+	for _, sur := range csr.scaleUpRequests {
+		if !failed[sur] {
+			newSur = append(newSur, sur)
+		}
+	}
+	csr.scaleUpRequests = newSur
 }
 
 // UpdateNodes updates the state of the nodes in the ClusterStateRegistry and recalculates the statss
@@ -266,29 +769,127 @@ func (csr *ClusterStateRegistry) UpdateNodes(nodes []*apiv1.Node, currentTime ti
 	if err != nil {
 		return err
 	}
-	notRegistered, err := getNotRegisteredNodes(nodes, csr.cloudProvider, currentTime)
+	notRegistered, instanceCounts, cloudInstances, err := getNotRegisteredNodes(nodes, csr.cloudProvider, currentTime)
 	if err != nil {
 		return err
 	}
+	operationStatuses := csr.getScaleUpOperationStatuses()
+	nodeGroups := csr.cloudProvider.NodeGroups()
 
 	csr.Lock()
 	defer csr.Unlock()
 
 	csr.nodes = nodes
+	csr.updateKnownNodeGroups(nodeGroups)
+
+	csr.handleScaleUpOperationStatuses(operationStatuses, currentTime)
 
 	csr.updateUnregisteredNodes(notRegistered)
+	csr.updateDeletedInstanceNodes(cloudInstances, currentTime)
+	csr.updateInstanceCounts(instanceCounts, currentTime)
 	csr.updateReadinessStats(currentTime)
 
 	// update acceptable ranges based on requests from last loop and targetSizes
 	// updateScaleRequests relies on acceptableRanges being up to date
 	csr.updateAcceptableRanges(targetSizes)
+	csr.updatePartialScaleUps(targetSizes, currentTime)
 	csr.updateScaleRequests(currentTime)
 	//  recalculate acceptable ranges after removing timed out requests
 	csr.updateAcceptableRanges(targetSizes)
 	csr.updateIncorrectNodeGroupSizes(currentTime)
+	csr.updateDrift(targetSizes, currentTime)
 	return nil
 }
 
+// updateKnownNodeGroups drops the state of node groups that no longer exist in the cloud provider,
+// for example auto-discovered groups that were deleted. A node group whose size limits changed may
+// have been deleted and created again with the same name, so its backoff is reset.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) updateKnownNodeGroups(nodeGroups []cloudprovider.NodeGroup) {
+	result := make(map[string]nodeGroupLimits)
+	for _, nodeGroup := range nodeGroups {
+		limits := nodeGroupLimits{minSize: nodeGroup.MinSize(), maxSize: nodeGroup.MaxSize()}
+		result[nodeGroup.Id()] = limits
+		if known, found := csr.knownNodeGroups[nodeGroup.Id()]; found && known != limits {
+			glog.V(1).Infof("Size limits of node group %s changed from %d-%d to %d-%d, resetting its backoff",
+				nodeGroup.Id(), known.minSize, known.maxSize, limits.minSize, limits.maxSize)
+			delete(csr.nodeGroupBackoffInfo, nodeGroup.Id())
+		}
+	}
+
+	removed := make(map[string]bool)
+	for id := range csr.knownNodeGroups {
+		removed[id] = true
+	}
+	// Backoffs and requests may also refer to node groups removed before they were first seen here.
+	for id := range csr.nodeGroupBackoffInfo {
+		removed[id] = true
+	}
+	for id := range csr.provisionTimes {
+		removed[id] = true
+	}
+	for _, sur := range csr.scaleUpRequests {
+		removed[sur.NodeGroupName] = true
+	}
+	for id := range removed {
+		if _, found := result[id]; !found {
+			csr.purgeNodeGroup(id)
+		}
+	}
+	csr.knownNodeGroups = result
+}
+
+// purgeNodeGroup drops all state and metrics of a node group that no longer exists.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) purgeNodeGroup(id string) {
+	glog.V(1).Infof("Node group %s no longer exists, dropping its state", id)
+	csr.logRecorder.Eventf(apiv1.EventTypeNormal, "NodeGroupRemoved", "Node group %s was removed from the cloud provider", id)
+
+	delete(csr.perNodeGroupReadiness, id)
+	delete(csr.acceptableRanges, id)
+	delete(csr.incorrectNodeGroupSizes, id)
+	delete(csr.candidatesForScaleDown, id)
+	delete(csr.estimatedSavings, id)
+	delete(csr.nodeGroupBackoffInfo, id)
+	delete(csr.instanceCounts, id)
+	delete(csr.partialScaleUps, id)
+	delete(csr.drift, id)
+	delete(csr.provisionTimes, id)
+	delete(csr.lastScaleUpTimes, id)
+	failedScaleUps := make([]FailedScaleUp, 0, len(csr.failedScaleUps))
+	for _, failed := range csr.failedScaleUps {
+		if failed.Request.NodeGroupName != id {
+			failedScaleUps = append(failedScaleUps, failed)
+		}
+	}
+	csr.failedScaleUps = failedScaleUps
+	scaleUpRequests := make([]*ScaleUpRequest, 0, len(csr.scaleUpRequests))
+	for _, sur := range csr.scaleUpRequests {
+		if sur.NodeGroupName != id {
+			scaleUpRequests = append(scaleUpRequests, sur)
+		}
+	}
+	csr.scaleUpRequests = scaleUpRequests
+	scaleDownRequests := make([]*ScaleDownRequest, 0, len(csr.scaleDownRequests))
+	for _, sdr := range csr.scaleDownRequests {
+		if sdr.NodeGroupName != id {
+			scaleDownRequests = append(scaleDownRequests, sdr)
+		}
+	}
+	csr.scaleDownRequests = scaleDownRequests
+	for name, deleted := range csr.deletedInstanceNodes {
+		if deleted.NodeGroupId == id {
+			delete(csr.deletedInstanceNodes, name)
+		}
+	}
+	for name, nodeGroupId := range csr.nodeGroupsOfNodes {
+		if nodeGroupId == id {
+			delete(csr.nodeGroupsOfNodes, name)
+		}
+	}
+	metrics.UnregisterNodeGroup(id)
+}
+
 // Recalculate cluster state after scale-ups or scale-downs were registered.
 func (csr *ClusterStateRegistry) Recalculate() {
 	targetSizes, err := getTargetSizes(csr.cloudProvider)
@@ -390,10 +991,17 @@ func (csr *ClusterStateRegistry) IsNodeGroupSafeToScaleUp(nodeGroupName string,
 }
 
 func (csr *ClusterStateRegistry) areThereUpcomingNodesInNodeGroup(nodeGroupName string) bool {
+	return csr.upcomingNodesInNodeGroup(nodeGroupName) > 0
+}
+
+// upcomingNodesInNodeGroup returns the number of nodes of the target size of the node group that
+// are not provisioned yet.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) upcomingNodesInNodeGroup(nodeGroupName string) int {
 	acceptable, found := csr.acceptableRanges[nodeGroupName]
 	if !found {
 		glog.Warningf("Failed to find acceptable ranges for %v", nodeGroupName)
-		return false
+		return 0
 	}
 
 	readiness, found := csr.perNodeGroupReadiness[nodeGroupName]
@@ -402,11 +1010,11 @@ func (csr *ClusterStateRegistry) areThereUpcomingNodesInNodeGroup(nodeGroupName
 		if acceptable.MinNodes != 0 {
 			glog.Warningf("Failed to find readiness information for %v", nodeGroupName)
 		}
-		return acceptable.CurrentTarget > 0
+		return maxInt(acceptable.CurrentTarget, 0)
 	}
 
-	provisioned := readiness.Registered - readiness.NotStarted - readiness.LongNotStarted
-	return acceptable.CurrentTarget > provisioned
+	provisioned := readiness.Registered - readiness.NotStarted - readiness.LongNotStarted - readiness.InstanceDeleted
+	return maxInt(acceptable.CurrentTarget-provisioned, 0)
 }
 
 // IsNodeGroupScalingUp returns true if the node group is currently scaling up.
@@ -471,6 +1079,12 @@ type Readiness struct {
 	// Number of nodes that are being currently deleted. They exist in K8S but
 	// are not included in NodeGroup.TargetSize().
 	Deleted int
+	// Number of nodes that are going through graceful shutdown. Pods on them are being
+	// terminated and they are expected to go away soon.
+	ShuttingDown int
+	// Number of nodes whose instances no longer exist on the cloud provider side. They provide
+	// no capacity and are not included in NodeGroup.TargetSize().
+	InstanceDeleted int
 	// Number of nodes that failed to start within a reasonable limit.
 	LongNotStarted int
 	// Number of nodes that are not yet fully started.
@@ -492,6 +1106,10 @@ func (csr *ClusterStateRegistry) updateReadinessStats(currentTime time.Time) {
 		current.Registered++
 		if deletetaint.HasToBeDeletedTaint(node) {
 			current.Deleted++
+		} else if _, found := csr.deletedInstanceNodes[node.Name]; found {
+			current.InstanceDeleted++
+		} else if kube_util.IsNodeShuttingDown(node) {
+			current.ShuttingDown++
 		} else if isNodeNotStarted(node) && node.CreationTimestamp.Time.Add(MaxNodeStartupTime).Before(currentTime) {
 			current.LongNotStarted++
 		} else if isNodeNotStarted(node) {
@@ -510,7 +1128,10 @@ func (csr *ClusterStateRegistry) updateReadinessStats(currentTime time.Time) {
 
 		// Node is most likely not autoscaled, however check the errors.
 		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
-			if errNg != nil {
+			// Cloud providers may not find the node group of a node whose instance is gone.
+			if deleted, found := csr.deletedInstanceNodes[node.Name]; found {
+				perNodeGroup[deleted.NodeGroupId] = update(perNodeGroup[deleted.NodeGroupId], node, ready)
+			} else if errNg != nil {
 				glog.Warningf("Failed to get nodegroup for %s: %v", node.Name, errNg)
 			}
 			if errReady != nil {
@@ -523,12 +1144,12 @@ func (csr *ClusterStateRegistry) updateReadinessStats(currentTime time.Time) {
 	}
 
 	for _, unregistered := range csr.unregisteredNodes {
-		if unregistered.UnregisteredSince.Add(csr.config.MaxNodeProvisionTime).Before(currentTime) {
-			nodeGroup, errNg := csr.cloudProvider.NodeGroupForNode(unregistered.Node)
-			if errNg != nil {
-				glog.Warningf("Failed to get nodegroup for %s: %v", unregistered.Node.Name, errNg)
-				continue
-			}
+		nodeGroup, errNg := csr.cloudProvider.NodeGroupForNode(unregistered.Node)
+		if errNg != nil {
+			glog.Warningf("Failed to get nodegroup for %s: %v", unregistered.Node.Name, errNg)
+			continue
+		}
+		if unregistered.UnregisteredSince.Add(csr.provisionTimeout(nodeGroup.Id())).Before(currentTime) {
 			perNgCopy := perNodeGroup[nodeGroup.Id()]
 			perNgCopy.LongUnregistered += 1
 			perNodeGroup[nodeGroup.Id()] = perNgCopy
@@ -561,10 +1182,12 @@ func (csr *ClusterStateRegistry) updateIncorrectNodeGroupSizes(currentTime time.
 			}
 			continue
 		}
-		if readiness.Registered > acceptableRange.MaxNodes ||
-			readiness.Registered < acceptableRange.MinNodes {
+		// Nodes whose instances are gone are not part of the node group anymore.
+		registered := readiness.Registered - readiness.InstanceDeleted
+		if registered > acceptableRange.MaxNodes ||
+			registered < acceptableRange.MinNodes {
 			incorrect := IncorrectNodeGroupSize{
-				CurrentSize:   readiness.Registered,
+				CurrentSize:   registered,
 				ExpectedSize:  acceptableRange.CurrentTarget,
 				FirstObserved: currentTime,
 			}
@@ -581,6 +1204,127 @@ func (csr *ClusterStateRegistry) updateIncorrectNodeGroupSizes(currentTime time.
 	csr.incorrectNodeGroupSizes = result
 }
 
+func (csr *ClusterStateRegistry) updateInstanceCounts(counts map[string]int, currentTime time.Time) {
+	result := make(map[string]instanceCount)
+	for id, count := range counts {
+		current := instanceCount{count: count, lastIncrease: currentTime}
+		if prev, found := csr.instanceCounts[id]; found && count <= prev.count {
+			current.lastIncrease = prev.lastIncrease
+		}
+		result[id] = current
+	}
+	csr.instanceCounts = result
+}
+
+// Detects scale-ups that the cloud provider stopped fulfilling: the target size is above the number
+// of instances, all scale-up requests of the node group timed out and no new instance arrived for
+// MaxNodeProvisionTime. Scale-up requests of such node groups are dropped, so that their missing
+// nodes are no longer treated as upcoming and pods waiting for them can be helped by other node groups.
+func (csr *ClusterStateRegistry) updatePartialScaleUps(targetSizes map[string]int, currentTime time.Time) {
+	expectedAddTimes := make(map[string]time.Time)
+	for _, sur := range csr.scaleUpRequests {
+		if sur.ExpectedAddTime.After(expectedAddTimes[sur.NodeGroupName]) {
+			expectedAddTimes[sur.NodeGroupName] = sur.ExpectedAddTime
+		}
+	}
+
+	result := make(map[string]PartialScaleUp)
+	for id, target := range targetSizes {
+		instances, found := csr.instanceCounts[id]
+		if !found || instances.count >= target {
+			continue
+		}
+		if existing, found := csr.partialScaleUps[id]; found {
+			existing.TargetSize = target
+			existing.FulfilledSize = instances.count
+			result[id] = existing
+			continue
+		}
+		expectedAddTime, found := expectedAddTimes[id]
+		if !found || !expectedAddTime.Before(currentTime) {
+			continue
+		}
+		if !instances.lastIncrease.Add(csr.provisionTimeout(id)).Before(currentTime) {
+			continue
+		}
+
+		partial := PartialScaleUp{
+			TargetSize:    target,
+			FulfilledSize: instances.count,
+			FirstObserved: currentTime,
+		}
+		result[id] = partial
+		glog.Warningf("Scale-up of node group %v partially fulfilled: %d of %d nodes created, none added since %v",
+			id, instances.count, target, instances.lastIncrease)
+		csr.logRecorder.Eventf(apiv1.EventTypeWarning, "ScaleUpPartiallyFulfilled",
+			"Cloud provider created only %d of %d nodes in group %s", instances.count, target, id)
+		metrics.RegisterFailedScaleUp(metrics.PartialFulfillment)
+		csr.registerStockout(id, currentTime)
+		csr.backoffNodeGroup(id, currentTime)
+		backoffInfo := csr.nodeGroupBackoffInfo[id]
+		backoffInfo.partialScaleUp = &partial
+		csr.nodeGroupBackoffInfo[id] = backoffInfo
+		csr.failedScaleUpNodes[id] += target - instances.count
+
+		newSur := make([]*ScaleUpRequest, 0, len(csr.scaleUpRequests))
+		for _, sur := range csr.scaleUpRequests {
+			if sur.NodeGroupName != id {
+				newSur = append(newSur, sur)
+			} else {
+				csr.registerFailedScaleUpRequest(sur, currentTime)
+			}
+		}
+		csr.scaleUpRequests = newSur
+	}
+	csr.partialScaleUps = result
+}
+
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) updateDeletedInstanceNodes(cloudInstances sets.String, currentTime time.Time) {
+	result := make(map[string]DeletedInstanceNode)
+	nodeGroupsOfNodes := make(map[string]string)
+	for _, node := range csr.nodes {
+		// The node group is remembered from previous loops, in case the cloud provider can't map
+		// the node to it after the instance is deleted.
+		nodeGroupId := csr.nodeGroupsOfNodes[node.Name]
+		nodeGroup, err := csr.cloudProvider.NodeGroupForNode(node)
+		if err == nil && nodeGroup != nil && !reflect.ValueOf(nodeGroup).IsNil() {
+			nodeGroupId = nodeGroup.Id()
+		}
+		if nodeGroupId == "" {
+			continue
+		}
+		nodeGroupsOfNodes[node.Name] = nodeGroupId
+		if cloudInstances.Has(node.Spec.ProviderID) || node.CreationTimestamp.Add(deletedInstanceMinNodeAge).After(currentTime) {
+			continue
+		}
+		if prev, found := csr.deletedInstanceNodes[node.Name]; found {
+			result[node.Name] = prev
+			continue
+		}
+		glog.V(1).Infof("Instance of node %s no longer exists in node group %s", node.Name, nodeGroupId)
+		result[node.Name] = DeletedInstanceNode{
+			Node:         node,
+			NodeGroupId:  nodeGroupId,
+			DeletedSince: currentTime,
+		}
+	}
+	csr.nodeGroupsOfNodes = nodeGroupsOfNodes
+	csr.deletedInstanceNodes = result
+}
+
+// GetDeletedInstanceNodes returns nodes whose instances no longer exist on the cloud provider side.
+func (csr *ClusterStateRegistry) GetDeletedInstanceNodes() []DeletedInstanceNode {
+	csr.Lock()
+	defer csr.Unlock()
+
+	result := make([]DeletedInstanceNode, 0, len(csr.deletedInstanceNodes))
+	for _, deleted := range csr.deletedInstanceNodes {
+		result = append(result, deleted)
+	}
+	return result
+}
+
 func (csr *ClusterStateRegistry) updateUnregisteredNodes(unregisteredNodes []UnregisteredNode) {
 	result := make(map[string]UnregisteredNode)
 	for _, unregistered := range unregisteredNodes {
@@ -623,11 +1367,67 @@ func (csr *ClusterStateRegistry) UpdateScaleDownCandidates(nodes []*apiv1.Node,
 	csr.lastScaleDownUpdateTime = now
 }
 
+// UpdateHeadroom updates information about spare capacity requested with headroom specs.
+func (csr *ClusterStateRegistry) UpdateHeadroom(statuses []HeadroomStatus, now time.Time) {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.headroomStatuses = statuses
+	csr.lastHeadroomUpdateTime = now
+}
+
+// UpdateCapacityReservations updates information about capacity reserved for namespaces.
+func (csr *ClusterStateRegistry) UpdateCapacityReservations(statuses []CapacityReservationStatus, now time.Time) {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.reservationStatuses = statuses
+	csr.lastReservationUpdate = now
+}
+
+// UpdateScaleUpLimits updates information about cluster-wide limits blocking pending pods.
+func (csr *ClusterStateRegistry) UpdateScaleUpLimits(statuses []ScaleUpLimitStatus, now time.Time) {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.scaleUpLimitStatuses = statuses
+	csr.lastScaleUpLimitUpdate = now
+}
+
+// RegisterPlannedScaleUp registers a scale-up skipped because CA runs in dry-run mode. Planned scale-ups
+// are included in the status until ClearPlannedScaleUps is called.
+func (csr *ClusterStateRegistry) RegisterPlannedScaleUp(planned api.PlannedScaleUp) {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.plannedScaleUps = append(csr.plannedScaleUps, planned)
+}
+
+// ClearPlannedScaleUps drops the planned scale-ups of the previous loop.
+func (csr *ClusterStateRegistry) ClearPlannedScaleUps() {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.plannedScaleUps = nil
+}
+
+// SetBuildInfo sets the build and configuration information included in the status.
+func (csr *ClusterStateRegistry) SetBuildInfo(info api.BuildInfo) {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.buildInfo = &info
+}
+
+// SetActiveTimeProfile sets the time profile in effect included in the status.
+func (csr *ClusterStateRegistry) SetActiveTimeProfile(profile string) {
+	csr.Lock()
+	defer csr.Unlock()
+	csr.activeTimeProfile = profile
+}
+
 // GetStatus returns ClusterAutoscalerStatus with the current cluster autoscaler status.
 func (csr *ClusterStateRegistry) GetStatus(now time.Time) *api.ClusterAutoscalerStatus {
 	result := &api.ClusterAutoscalerStatus{
+		BuildInfo:             csr.buildInfo,
+		ActiveTimeProfile:     csr.activeTimeProfile,
 		ClusterwideConditions: make([]api.ClusterAutoscalerCondition, 0),
 		NodeGroupStatuses:     make([]api.NodeGroupStatus, 0),
+		PlannedScaleUps:       csr.plannedScaleUps,
 	}
 	for _, nodeGroup := range csr.cloudProvider.NodeGroups() {
 		nodeGroupStatus := api.NodeGroupStatus{
@@ -642,15 +1442,26 @@ func (csr *ClusterStateRegistry) GetStatus(now time.Time) *api.ClusterAutoscaler
 			csr.IsNodeGroupHealthy(nodeGroup.Id()), readiness, acceptable, nodeGroup.MinSize(), nodeGroup.MaxSize()))
 
 		// Scale up.
+		var provisionTimeStats *ProvisionTimeStats
+		if stats, found := csr.provisionTimeStats(nodeGroup.Id()); found {
+			provisionTimeStats = &stats
+		}
 		nodeGroupStatus.Conditions = append(nodeGroupStatus.Conditions, buildScaleUpStatusNodeGroup(
 			csr.IsNodeGroupScalingUp(nodeGroup.Id()),
 			csr.IsNodeGroupSafeToScaleUp(nodeGroup.Id(), now),
 			readiness,
-			acceptable))
+			acceptable,
+			csr.nodeGroupBackoffInfo[nodeGroup.Id()].partialScaleUp,
+			provisionTimeStats))
 
 		// Scale down.
 		nodeGroupStatus.Conditions = append(nodeGroupStatus.Conditions, buildScaleDownStatusNodeGroup(
-			csr.candidatesForScaleDown[nodeGroup.Id()], csr.lastScaleDownUpdateTime))
+			csr.candidatesForScaleDown[nodeGroup.Id()], csr.estimatedSavings[nodeGroup.Id()], csr.lastScaleDownUpdateTime))
+
+		// Drift.
+		if drift, found := csr.drift[nodeGroup.Id()]; found {
+			nodeGroupStatus.Conditions = append(nodeGroupStatus.Conditions, buildDriftStatusNodeGroup(drift, readiness.Time))
+		}
 
 		result.NodeGroupStatuses = append(result.NodeGroupStatuses, nodeGroupStatus)
 	}
@@ -659,7 +1470,19 @@ func (csr *ClusterStateRegistry) GetStatus(now time.Time) *api.ClusterAutoscaler
 	result.ClusterwideConditions = append(result.ClusterwideConditions,
 		buildScaleUpStatusClusterwide(result.NodeGroupStatuses, csr.totalReadiness))
 	result.ClusterwideConditions = append(result.ClusterwideConditions,
-		buildScaleDownStatusClusterwide(csr.candidatesForScaleDown, csr.lastScaleDownUpdateTime))
+		buildScaleDownStatusClusterwide(csr.candidatesForScaleDown, csr.estimatedSavings, csr.lastScaleDownUpdateTime))
+	if len(csr.headroomStatuses) > 0 {
+		result.ClusterwideConditions = append(result.ClusterwideConditions,
+			buildHeadroomStatusClusterwide(csr.headroomStatuses, csr.lastHeadroomUpdateTime))
+	}
+	if len(csr.reservationStatuses) > 0 {
+		result.ClusterwideConditions = append(result.ClusterwideConditions,
+			buildCapacityReservationsStatusClusterwide(csr.reservationStatuses, csr.lastReservationUpdate))
+	}
+	if len(csr.scaleUpLimitStatuses) > 0 {
+		result.ClusterwideConditions = append(result.ClusterwideConditions,
+			buildScaleUpLimitsStatusClusterwide(csr.scaleUpLimitStatuses, csr.lastScaleUpLimitUpdate))
+	}
 
 	updateLastTransition(csr.lastStatus, result)
 	csr.lastStatus = result
@@ -674,9 +1497,11 @@ func (csr *ClusterStateRegistry) GetClusterReadiness() Readiness {
 func buildHealthStatusNodeGroup(isReady bool, readiness Readiness, acceptable AcceptableRange, minSize, maxSize int) api.ClusterAutoscalerCondition {
 	condition := api.ClusterAutoscalerCondition{
 		Type: api.ClusterAutoscalerHealth,
-		Message: fmt.Sprintf("ready=%d unready=%d notStarted=%d longNotStarted=%d registered=%d longUnregistered=%d cloudProviderTarget=%d (minSize=%d, maxSize=%d)",
+		Message: fmt.Sprintf("ready=%d unready=%d shuttingDown=%d instanceDeleted=%d notStarted=%d longNotStarted=%d registered=%d longUnregistered=%d cloudProviderTarget=%d (minSize=%d, maxSize=%d)",
 			readiness.Ready,
 			readiness.Unready,
+			readiness.ShuttingDown,
+			readiness.InstanceDeleted,
 			readiness.NotStarted,
 			readiness.LongNotStarted,
 			readiness.Registered,
@@ -694,7 +1519,8 @@ func buildHealthStatusNodeGroup(isReady bool, readiness Readiness, acceptable Ac
 	return condition
 }
 
-func buildScaleUpStatusNodeGroup(isScaleUpInProgress bool, isSafeToScaleUp bool, readiness Readiness, acceptable AcceptableRange) api.ClusterAutoscalerCondition {
+func buildScaleUpStatusNodeGroup(isScaleUpInProgress bool, isSafeToScaleUp bool, readiness Readiness, acceptable AcceptableRange,
+	partialScaleUp *PartialScaleUp, provisionTimeStats *ProvisionTimeStats) api.ClusterAutoscalerCondition {
 	condition := api.ClusterAutoscalerCondition{
 		Type: api.ClusterAutoscalerScaleUp,
 		Message: fmt.Sprintf("ready=%d cloudProviderTarget=%d",
@@ -702,22 +1528,32 @@ func buildScaleUpStatusNodeGroup(isScaleUpInProgress bool, isSafeToScaleUp bool,
 			acceptable.CurrentTarget),
 		LastProbeTime: metav1.Time{Time: readiness.Time},
 	}
+	if provisionTimeStats != nil {
+		condition.Message += fmt.Sprintf(" provisionTimeP50=%v provisionTimeP95=%v",
+			provisionTimeStats.P50, provisionTimeStats.P95)
+	}
 	if isScaleUpInProgress {
 		condition.Status = api.ClusterAutoscalerInProgress
 	} else if !isSafeToScaleUp {
 		condition.Status = api.ClusterAutoscalerBackoff
+		if partialScaleUp != nil {
+			condition.Message += fmt.Sprintf(" partiallyFulfilled=%d/%d", partialScaleUp.FulfilledSize, partialScaleUp.TargetSize)
+		}
 	} else {
 		condition.Status = api.ClusterAutoscalerNoActivity
 	}
 	return condition
 }
 
-func buildScaleDownStatusNodeGroup(candidates []string, lastProbed time.Time) api.ClusterAutoscalerCondition {
+func buildScaleDownStatusNodeGroup(candidates []string, estimatedSavings float64, lastProbed time.Time) api.ClusterAutoscalerCondition {
 	condition := api.ClusterAutoscalerCondition{
 		Type:          api.ClusterAutoscalerScaleDown,
 		Message:       fmt.Sprintf("candidates=%d", len(candidates)),
 		LastProbeTime: metav1.Time{Time: lastProbed},
 	}
+	if estimatedSavings > 0 {
+		condition.Message += fmt.Sprintf(" estimatedSavingsUsdPerHourTotal=%.4f", estimatedSavings)
+	}
 	if len(candidates) > 0 {
 		condition.Status = api.ClusterAutoscalerCandidatesPresent
 	} else {
@@ -729,9 +1565,11 @@ func buildScaleDownStatusNodeGroup(candidates []string, lastProbed time.Time) ap
 func buildHealthStatusClusterwide(isReady bool, readiness Readiness) api.ClusterAutoscalerCondition {
 	condition := api.ClusterAutoscalerCondition{
 		Type: api.ClusterAutoscalerHealth,
-		Message: fmt.Sprintf("ready=%d unready=%d notStarted=%d longNotStarted=%d registered=%d longUnregistered=%d",
+		Message: fmt.Sprintf("ready=%d unready=%d shuttingDown=%d instanceDeleted=%d notStarted=%d longNotStarted=%d registered=%d longUnregistered=%d",
 			readiness.Ready,
 			readiness.Unready,
+			readiness.ShuttingDown,
+			readiness.InstanceDeleted,
 			readiness.NotStarted,
 			readiness.LongNotStarted,
 			readiness.Registered,
@@ -773,16 +1611,73 @@ func buildScaleUpStatusClusterwide(nodeGroupStatuses []api.NodeGroupStatus, read
 	return condition
 }
 
-func buildScaleDownStatusClusterwide(candidates map[string][]string, lastProbed time.Time) api.ClusterAutoscalerCondition {
+func buildHeadroomStatusClusterwide(statuses []HeadroomStatus, lastProbed time.Time) api.ClusterAutoscalerCondition {
+	condition := api.ClusterAutoscalerCondition{
+		Type:          api.ClusterAutoscalerHeadroom,
+		Status:        api.ClusterAutoscalerHeadroomAvailable,
+		LastProbeTime: metav1.Time{Time: lastProbed},
+	}
+	messages := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		if status.Current < status.Desired {
+			condition.Status = api.ClusterAutoscalerHeadroomMissing
+		}
+		messages = append(messages, fmt.Sprintf("%s current=%d desired=%d", status.Spec, status.Current, status.Desired))
+	}
+	condition.Message = strings.Join(messages, "; ")
+	return condition
+}
+
+func buildCapacityReservationsStatusClusterwide(statuses []CapacityReservationStatus, lastProbed time.Time) api.ClusterAutoscalerCondition {
+	condition := api.ClusterAutoscalerCondition{
+		Type:          api.ClusterAutoscalerCapacityReservations,
+		Status:        api.ClusterAutoscalerCapacityReservationsSatisfied,
+		LastProbeTime: metav1.Time{Time: lastProbed},
+	}
+	messages := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		if status.Satisfied() {
+			messages = append(messages, fmt.Sprintf("%s satisfied", status.Reservation))
+			continue
+		}
+		condition.Status = api.ClusterAutoscalerCapacityReservationsUnsatisfied
+		messages = append(messages, fmt.Sprintf("%s missing cpu=%dm memory=%d", status.Reservation, status.MissingMilliCPU, status.MissingMemory))
+	}
+	condition.Message = strings.Join(messages, "; ")
+	return condition
+}
+
+func buildScaleUpLimitsStatusClusterwide(statuses []ScaleUpLimitStatus, lastProbed time.Time) api.ClusterAutoscalerCondition {
+	condition := api.ClusterAutoscalerCondition{
+		Type:          api.ClusterAutoscalerScaleUpLimits,
+		Status:        api.ClusterAutoscalerLimitReached,
+		LastProbeTime: metav1.Time{Time: lastProbed},
+	}
+	messages := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		messages = append(messages, fmt.Sprintf("%s current=%d max=%d blockedPods=%d", status.Limit, status.Current, status.Max, status.BlockedPods))
+	}
+	condition.Message = strings.Join(messages, "; ")
+	return condition
+}
+
+func buildScaleDownStatusClusterwide(candidates map[string][]string, estimatedSavings map[string]float64, lastProbed time.Time) api.ClusterAutoscalerCondition {
 	totalCandidates := 0
 	for _, val := range candidates {
 		totalCandidates += len(val)
 	}
+	totalSavings := 0.0
+	for _, val := range estimatedSavings {
+		totalSavings += val
+	}
 	condition := api.ClusterAutoscalerCondition{
 		Type:          api.ClusterAutoscalerScaleDown,
 		Message:       fmt.Sprintf("candidates=%d", totalCandidates),
 		LastProbeTime: metav1.Time{Time: lastProbed},
 	}
+	if totalSavings > 0 {
+		condition.Message += fmt.Sprintf(" estimatedSavingsUsdPerHourTotal=%.4f", totalSavings)
+	}
 	if totalCandidates > 0 {
 		condition.Status = api.ClusterAutoscalerCandidatesPresent
 	} else {
@@ -862,6 +1757,31 @@ func (csr *ClusterStateRegistry) GetIncorrectNodeGroupSize(nodeGroupName string)
 	return &result
 }
 
+// GetPartialScaleUp returns information about a partially fulfilled scale-up of the given node group
+// or nil if the node group reached its target size.
+func (csr *ClusterStateRegistry) GetPartialScaleUp(nodeGroupName string) *PartialScaleUp {
+	result, found := csr.partialScaleUps[nodeGroupName]
+	if !found {
+		return nil
+	}
+	return &result
+}
+
+// GetBackoffs returns the time until which node groups are backed off from scaling up, keyed by node group id.
+// Backoffs that already ended are omitted.
+func (csr *ClusterStateRegistry) GetBackoffs(now time.Time) map[string]time.Time {
+	csr.Lock()
+	defer csr.Unlock()
+
+	result := make(map[string]time.Time)
+	for id, backoffInfo := range csr.nodeGroupBackoffInfo {
+		if backoffInfo.backoffUntil.After(now) {
+			result[id] = backoffInfo.backoffUntil
+		}
+	}
+	return result
+}
+
 // GetUpcomingNodes returns how many new nodes will be added shortly to the node groups or should become ready soon.
 // The functiom may overestimate the number of nodes.
 func (csr *ClusterStateRegistry) GetUpcomingNodes() map[string]int {
@@ -873,8 +1793,15 @@ func (csr *ClusterStateRegistry) GetUpcomingNodes() map[string]int {
 		id := nodeGroup.Id()
 		readiness := csr.perNodeGroupReadiness[id]
 		ar := csr.acceptableRanges[id]
+		// Nodes that are shutting down are still included in the target size but they are not going
+		// to provide any capacity, so they shouldn't be treated as upcoming.
 		// newNodes is the number of nodes that
-		newNodes := ar.CurrentTarget - (readiness.Ready + readiness.Unready + readiness.LongNotStarted + readiness.LongUnregistered)
+		newNodes := ar.CurrentTarget - (readiness.Ready + readiness.Unready + readiness.ShuttingDown + readiness.LongNotStarted + readiness.LongUnregistered)
+		// Nodes the cloud provider stopped creating are not coming anymore, even before the target
+		// size is decreased.
+		if partial, found := csr.partialScaleUps[id]; found {
+			newNodes -= partial.TargetSize - partial.FulfilledSize
+		}
 		if newNodes <= 0 {
 			// Negative value is unlikely but theoretically possible.
 			continue
@@ -885,18 +1812,31 @@ func (csr *ClusterStateRegistry) GetUpcomingNodes() map[string]int {
 }
 
 // Calculates which of the existing cloud provider nodes are not registered in Kubernetes.
-func getNotRegisteredNodes(allNodes []*apiv1.Node, cloudProvider cloudprovider.CloudProvider, time time.Time) ([]UnregisteredNode, error) {
+// Also returns the number of cloud provider nodes in each node group and the set of all of them.
+// Instances that are being deleted or removed from their node group don't count into its size and
+// are not expected to register, but their nodes still have instances.
+func getNotRegisteredNodes(allNodes []*apiv1.Node, cloudProvider cloudprovider.CloudProvider, time time.Time) ([]UnregisteredNode, map[string]int, sets.String, error) {
 	registered := sets.NewString()
 	for _, node := range allNodes {
 		registered.Insert(node.Spec.ProviderID)
 	}
 	notRegistered := make([]UnregisteredNode, 0)
+	instanceCounts := make(map[string]int)
+	cloudInstances := sets.NewString()
 	for _, nodeGroup := range cloudProvider.NodeGroups() {
-		nodes, err := nodeGroup.Nodes()
+		instances, err := cloudprovider.NodeGroupInstances(nodeGroup)
 		if err != nil {
-			return []UnregisteredNode{}, err
+			return []UnregisteredNode{}, nil, nil, err
 		}
-		for _, node := range nodes {
+		count := 0
+		for _, instance := range instances {
+			cloudInstances.Insert(instance.Id)
+			if instance.State.Leaving() {
+				glog.V(4).Infof("Instance %s of node group %s is %v", instance.Id, nodeGroup.Id(), instance.State)
+				continue
+			}
+			count++
+			node := instance.Id
 			if !registered.Has(node) {
 				notRegistered = append(notRegistered, UnregisteredNode{
 					Node: &apiv1.Node{
@@ -911,6 +1851,21 @@ func getNotRegisteredNodes(allNodes []*apiv1.Node, cloudProvider cloudprovider.C
 				})
 			}
 		}
+		instanceCounts[nodeGroup.Id()] = count
+	}
+	return notRegistered, instanceCounts, cloudInstances, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
 	}
-	return notRegistered, nil
+	return b
 }
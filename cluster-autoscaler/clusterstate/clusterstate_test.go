@@ -17,17 +17,22 @@ limitations under the License.
 package clusterstate
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 	"k8s.io/client-go/kubernetes/fake"
 	kube_record "k8s.io/client-go/tools/record"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -86,6 +91,130 @@ func TestOKWithScaleUp(t *testing.T) {
 	assert.True(t, ng2Checked)
 }
 
+func TestTypicalProvisionTime(t *testing.T) {
+	now := time.Now()
+
+	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(ng1_1, true, now.Add(-time.Minute))
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", ng1_1)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+	}, fakeLogRecorder)
+	_, found := clusterstate.GetTypicalProvisionTime("ng1")
+	assert.False(t, found)
+
+	for _, duration := range []time.Duration{3 * time.Minute, time.Minute, 2 * time.Minute} {
+		clusterstate.RegisterScaleUp(&ScaleUpRequest{
+			NodeGroupName:   "ng1",
+			Increase:        1,
+			Time:            now.Add(-duration),
+			ExpectedAddTime: now.Add(10 * time.Minute),
+		})
+		assert.NoError(t, clusterstate.UpdateNodes([]*apiv1.Node{ng1_1}, now))
+	}
+	provisionTime, found := clusterstate.GetTypicalProvisionTime("ng1")
+	assert.True(t, found)
+	assert.Equal(t, 2*time.Minute, provisionTime)
+	_, found = clusterstate.GetTypicalProvisionTime("ng2")
+	assert.False(t, found)
+}
+
+func TestProvisionTimeStats(t *testing.T) {
+	sorted := []time.Duration{}
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Minute)
+	}
+	assert.Equal(t, 5*time.Minute, percentile(sorted, 0.5))
+	assert.Equal(t, 10*time.Minute, percentile(sorted, 0.95))
+	assert.Equal(t, 9*time.Minute, percentile(sorted, 0.9))
+	assert.Equal(t, time.Minute, percentile(sorted[:1], 0.95))
+	assert.Equal(t, time.Minute, percentile(sorted, 0))
+
+	clusterstate := NewClusterStateRegistry(testprovider.NewTestCloudProvider(nil, nil), ClusterStateRegistryConfig{}, nil)
+	// Only the most recent samples are kept.
+	for i := 0; i < provisionTimeSamples; i++ {
+		clusterstate.recordProvisionTime("ng1", time.Hour)
+	}
+	for _, duration := range []time.Duration{8 * time.Minute, 90 * time.Second, 2 * time.Minute} {
+		clusterstate.recordProvisionTime("ng1", duration)
+	}
+	stats, found := clusterstate.GetProvisionTimeStats("ng1")
+	assert.True(t, found)
+	assert.Equal(t, ProvisionTimeStats{P50: time.Hour, P95: time.Hour, Samples: provisionTimeSamples}, stats)
+	for i := 0; i < provisionTimeSamples; i++ {
+		clusterstate.recordProvisionTime("ng1", 90*time.Second)
+	}
+	stats, _ = clusterstate.GetProvisionTimeStats("ng1")
+	assert.Equal(t, ProvisionTimeStats{P50: 90 * time.Second, P95: 90 * time.Second, Samples: provisionTimeSamples}, stats)
+}
+
+func TestAdaptiveProvisionTimeout(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("gpu", 0, 10, 0)
+	provider.AddNodeGroup("e2", 0, 10, 0)
+	provider.AddNodeGroup("fast", 0, 10, 0)
+	provider.AddNodeGroup("new", 0, 10, 0)
+	config := ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		MaxNodeProvisionTime:      15 * time.Minute,
+		AdaptiveProvisionTimeout:  true,
+		ProvisionTimeoutFactor:    1.5,
+		MinProvisionTimeout:       2 * time.Minute,
+		MaxProvisionTimeout:       12 * time.Minute,
+	}
+
+	// Scale-ups finish when UpdateNodes no longer sees upcoming nodes, the durations are measured
+	// against the time passed to it.
+	recordScaleUps := func(clusterstate *ClusterStateRegistry, nodeGroup string, durations ...time.Duration) {
+		for _, duration := range durations {
+			clusterstate.RegisterScaleUp(&ScaleUpRequest{
+				NodeGroupName:   nodeGroup,
+				Increase:        1,
+				Time:            now.Add(-duration),
+				ExpectedAddTime: now.Add(time.Hour),
+			})
+			assert.NoError(t, clusterstate.UpdateNodes([]*apiv1.Node{}, now))
+		}
+	}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(&fake.Clientset{}, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, config, fakeLogRecorder)
+	recordScaleUps(clusterstate, "gpu", 7*time.Minute, 8*time.Minute, 10*time.Minute)
+	recordScaleUps(clusterstate, "e2", 80*time.Second, 90*time.Second, 100*time.Second)
+	recordScaleUps(clusterstate, "fast", 10*time.Second, 20*time.Second, 30*time.Second)
+	recordScaleUps(clusterstate, "new", time.Minute, time.Minute)
+
+	// Above the upper bound.
+	assert.Equal(t, 12*time.Minute, clusterstate.GetProvisionTimeout("gpu"))
+	assert.Equal(t, 150*time.Second, clusterstate.GetProvisionTimeout("e2"))
+	// Below the lower bound.
+	assert.Equal(t, 2*time.Minute, clusterstate.GetProvisionTimeout("fast"))
+	// Not enough history.
+	assert.Equal(t, 15*time.Minute, clusterstate.GetProvisionTimeout("new"))
+
+	// Percentiles are reported in the status.
+	status := clusterstate.GetStatus(now)
+	for _, nodeGroupStatus := range status.NodeGroupStatuses {
+		if nodeGroupStatus.ProviderID == "e2" {
+			assert.Contains(t, api.GetConditionByType(api.ClusterAutoscalerScaleUp, nodeGroupStatus.Conditions).Message,
+				"provisionTimeP50=1m30s provisionTimeP95=1m40s")
+		}
+	}
+
+	config.AdaptiveProvisionTimeout = false
+	clusterstate = NewClusterStateRegistry(provider, config, fakeLogRecorder)
+	recordScaleUps(clusterstate, "e2", 80*time.Second, 90*time.Second, 100*time.Second)
+	assert.Equal(t, 15*time.Minute, clusterstate.GetProvisionTimeout("e2"))
+}
+
 func TestEmptyOK(t *testing.T) {
 	now := time.Now()
 
@@ -342,6 +471,91 @@ func TestExpiredScaleUp(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, clusterstate.IsClusterHealthy())
 	assert.False(t, clusterstate.IsNodeGroupHealthy("ng1"))
+	assert.Equal(t, map[string]int{"ng1": 4}, clusterstate.TakeFailedScaleUpNodes())
+	assert.Empty(t, clusterstate.TakeFailedScaleUpNodes())
+}
+
+func TestRemovedNodeGroups(t *testing.T) {
+	now := time.Now()
+	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(ng1_1, true, now.Add(-time.Minute))
+	ng2_1 := BuildTestNode("ng2-1", 1000, 1000)
+	SetNodeReadyState(ng2_1, true, now.Add(-time.Minute))
+	nodes := []*apiv1.Node{ng1_1, ng2_1}
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", ng1_1)
+	provider.AddNodeGroup("ng2", 1, 10, 3)
+	provider.AddNode("ng2", ng2_1)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+	}, fakeLogRecorder)
+	clusterstate.RegisterScaleUp(&ScaleUpRequest{
+		NodeGroupName:   "ng2",
+		Increase:        2,
+		Time:            now.Add(-time.Minute),
+		ExpectedAddTime: now.Add(10 * time.Minute),
+	})
+	clusterstate.RegisterScaleDown(&ScaleDownRequest{
+		NodeGroupName:      "ng2",
+		NodeName:           "ng2-1",
+		Time:               now,
+		ExpectedDeleteTime: now.Add(time.Minute),
+	})
+	clusterstate.recordProvisionTime("ng2", time.Minute)
+	clusterstate.RegisterFailedScaleUp("ng2", metrics.Timeout)
+	clusterstate.RegisterFailedScaleUp("ng3", metrics.Timeout)
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+	clusterstate.UpdateScaleDownCandidates([]*apiv1.Node{ng1_1, ng2_1}, now)
+	assert.Contains(t, clusterstate.acceptableRanges, "ng2")
+	assert.Contains(t, clusterstate.perNodeGroupReadiness, "ng2")
+	assert.Contains(t, clusterstate.candidatesForScaleDown, "ng2")
+	// ng3 doesn't exist, its backoff is dropped right away.
+	assert.NotContains(t, clusterstate.nodeGroupBackoffInfo, "ng3")
+	assert.Contains(t, clusterstate.nodeGroupBackoffInfo, "ng2")
+
+	provider.RemoveNodeGroup("ng2")
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+	for _, state := range []interface{}{
+		clusterstate.acceptableRanges,
+		clusterstate.perNodeGroupReadiness,
+		clusterstate.incorrectNodeGroupSizes,
+		clusterstate.candidatesForScaleDown,
+		clusterstate.nodeGroupBackoffInfo,
+		clusterstate.instanceCounts,
+		clusterstate.partialScaleUps,
+		clusterstate.provisionTimes,
+		clusterstate.knownNodeGroups,
+	} {
+		assert.NotContains(t, state, "ng2")
+	}
+	assert.Empty(t, clusterstate.scaleUpRequests)
+	assert.Empty(t, clusterstate.scaleDownRequests)
+	assert.Equal(t, 0, clusterstate.GetUpcomingNodes()["ng2"])
+	assert.True(t, clusterstate.IsNodeGroupSafeToScaleUp("ng1", now))
+	for _, status := range clusterstate.GetStatus(now).NodeGroupStatuses {
+		assert.NotEqual(t, "ng2", status.ProviderID)
+	}
+
+	// Re-added with different limits, the node group starts without backoff.
+	provider.AddNodeGroup("ng2", 2, 5, 1)
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+	assert.Contains(t, clusterstate.acceptableRanges, "ng2")
+	assert.True(t, clusterstate.IsNodeGroupSafeToScaleUp("ng2", now))
+	assert.Equal(t, nodeGroupLimits{minSize: 2, maxSize: 5}, clusterstate.knownNodeGroups["ng2"])
+
+	// Limits changed without the node group missing in between.
+	clusterstate.RegisterFailedScaleUp("ng2", metrics.Timeout)
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+	assert.False(t, clusterstate.IsNodeGroupSafeToScaleUp("ng2", now))
+	provider.AddNodeGroup("ng2", 1, 20, 1)
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+	assert.True(t, clusterstate.IsNodeGroupSafeToScaleUp("ng2", now))
 }
 
 func TestRegisterScaleDown(t *testing.T) {
@@ -402,6 +616,13 @@ func TestUpcomingNodes(t *testing.T) {
 	provider.AddNodeGroup("ng4", 1, 10, 1)
 	provider.AddNode("ng4", ng4_1)
 
+	// Node that is shutting down is not an upcoming node.
+	ng5_1 := BuildTestNode("ng5-1", 1000, 1000)
+	SetNodeReadyState(ng5_1, true, now.Add(-time.Minute))
+	ng5_1.Spec.Taints = []apiv1.Taint{{Key: kube_util.OutOfServiceTaint, Effect: apiv1.TaintEffectNoExecute}}
+	provider.AddNodeGroup("ng5", 1, 10, 1)
+	provider.AddNode("ng5", ng5_1)
+
 	assert.NotNil(t, provider)
 	fakeClient := &fake.Clientset{}
 	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
@@ -409,14 +630,60 @@ func TestUpcomingNodes(t *testing.T) {
 		MaxTotalUnreadyPercentage: 10,
 		OkTotalUnreadyCount:       1,
 	}, fakeLogRecorder)
-	err := clusterstate.UpdateNodes([]*apiv1.Node{ng1_1, ng2_1, ng3_1, ng4_1}, now)
+	err := clusterstate.UpdateNodes([]*apiv1.Node{ng1_1, ng2_1, ng3_1, ng4_1, ng5_1}, now)
 	assert.NoError(t, err)
+	assert.Equal(t, 1, clusterstate.GetClusterReadiness().ShuttingDown)
 
 	upcomingNodes := clusterstate.GetUpcomingNodes()
 	assert.Equal(t, 6, upcomingNodes["ng1"])
 	assert.Equal(t, 1, upcomingNodes["ng2"])
 	assert.Equal(t, 2, upcomingNodes["ng3"])
 	assert.NotContains(t, upcomingNodes, "ng4")
+	assert.NotContains(t, upcomingNodes, "ng5")
+}
+
+func TestDeletedInstanceNodes(t *testing.T) {
+	now := time.Now()
+	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(ng1_1, true, now.Add(-time.Minute))
+	ng1_2 := BuildTestNode("ng1-2", 1000, 1000)
+	SetNodeReadyState(ng1_2, true, now.Add(-time.Minute))
+	// A node created a moment ago may not be listed by the cloud provider yet.
+	ng1_3 := BuildTestNode("ng1-3", 1000, 1000)
+	SetNodeReadyState(ng1_3, true, now.Add(-time.Minute))
+	ng1_3.CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", ng1_1)
+	provider.AddNode("ng1", ng1_2)
+	provider.AddNode("ng1", ng1_3)
+	provider.MarkInstanceDeleted("ng1-2")
+	provider.MarkInstanceDeleted("ng1-3")
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+	}, fakeLogRecorder)
+	err := clusterstate.UpdateNodes([]*apiv1.Node{ng1_1, ng1_2, ng1_3}, now)
+	assert.NoError(t, err)
+
+	deleted := clusterstate.GetDeletedInstanceNodes()
+	assert.Equal(t, 1, len(deleted))
+	assert.Equal(t, "ng1-2", deleted[0].Node.Name)
+	assert.Equal(t, "ng1", deleted[0].NodeGroupId)
+	assert.Equal(t, now, deleted[0].DeletedSince)
+	assert.Equal(t, 1, clusterstate.GetClusterReadiness().InstanceDeleted)
+	assert.Equal(t, 1, clusterstate.perNodeGroupReadiness["ng1"].InstanceDeleted)
+
+	// The node is still without an instance, the time it was first noticed is kept.
+	err = clusterstate.UpdateNodes([]*apiv1.Node{ng1_1, ng1_2, ng1_3}, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	deleted = clusterstate.GetDeletedInstanceNodes()
+	assert.Equal(t, 1, len(deleted))
+	assert.Equal(t, now, deleted[0].DeletedSince)
 }
 
 func TestIncorrectSize(t *testing.T) {
@@ -490,6 +757,47 @@ func TestUnregisteredNodes(t *testing.T) {
 	assert.Equal(t, 0, len(clusterstate.GetUnregisteredNodes()))
 }
 
+func TestLeavingInstances(t *testing.T) {
+	now := time.Now()
+	running := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(running, true, now.Add(-time.Hour))
+	recreating := BuildTestNode("ng1-2", 1000, 1000)
+	SetNodeReadyState(recreating, true, now.Add(-time.Hour))
+	abandoning := BuildTestNode("ng1-3", 1000, 1000)
+	SetNodeReadyState(abandoning, true, now.Add(-time.Hour))
+	// The node of the deleted instance is already gone.
+	deleting := BuildTestNode("ng1-4", 1000, 1000)
+	creating := BuildTestNode("ng1-5", 1000, 1000)
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	for _, node := range []*apiv1.Node{running, recreating, abandoning, deleting, creating} {
+		provider.AddNode("ng1", node)
+	}
+	provider.SetInstanceState("ng1-3", cloudprovider.InstanceAbandoning)
+	provider.SetInstanceState("ng1-4", cloudprovider.InstanceDeleting)
+	provider.SetInstanceState("ng1-5", cloudprovider.InstanceCreating)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+	}, fakeLogRecorder)
+	err := clusterstate.UpdateNodes([]*apiv1.Node{running, recreating, abandoning}, now)
+	assert.NoError(t, err)
+
+	// Only the instance being created is expected to register.
+	unregistered := clusterstate.GetUnregisteredNodes()
+	assert.Equal(t, 1, len(unregistered))
+	assert.Equal(t, "ng1-5", unregistered[0].Node.Name)
+	// Leaving instances don't count into the size of the node group.
+	assert.Equal(t, 3, clusterstate.instanceCounts["ng1"].count)
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("ng1"))
+	// The node of the abandoned instance still has its instance.
+	assert.Empty(t, clusterstate.GetDeletedInstanceNodes())
+}
+
 func TestUpdateLastTransitionTimes(t *testing.T) {
 	now := metav1.Time{Time: time.Now()}
 	later := metav1.Time{Time: now.Time.Add(10 * time.Second)}
@@ -631,12 +939,14 @@ func TestScaleUpBackoff(t *testing.T) {
 	assert.True(t, clusterstate.IsClusterHealthy())
 	assert.True(t, clusterstate.IsNodeGroupHealthy("ng1"))
 	assert.False(t, clusterstate.IsNodeGroupSafeToScaleUp("ng1", now))
+	assert.Equal(t, map[string]time.Time{"ng1": now.Add(InitialNodeGroupBackoffDuration)}, clusterstate.GetBackoffs(now))
 
 	// Backoff should expire after timeout
 	now = now.Add(InitialNodeGroupBackoffDuration).Add(time.Second)
 	assert.True(t, clusterstate.IsClusterHealthy())
 	assert.True(t, clusterstate.IsNodeGroupHealthy("ng1"))
 	assert.True(t, clusterstate.IsNodeGroupSafeToScaleUp("ng1", now))
+	assert.Empty(t, clusterstate.GetBackoffs(now))
 
 	// Another failed scale up should cause longer backoff
 	clusterstate.RegisterScaleUp(&ScaleUpRequest{
@@ -672,3 +982,382 @@ func TestScaleUpBackoff(t *testing.T) {
 	_, found := clusterstate.nodeGroupBackoffInfo["ng1"]
 	assert.False(t, found)
 }
+
+type asyncTestNodeGroup struct {
+	*testprovider.TestNodeGroup
+	operations map[string]cloudprovider.OperationStatus
+}
+
+func (ng *asyncTestNodeGroup) IncreaseSizeAsync(delta int) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func (ng *asyncTestNodeGroup) GetOperationStatus(operationId string) (cloudprovider.OperationStatus, error) {
+	status, found := ng.operations[operationId]
+	if !found {
+		return cloudprovider.OperationStatus{}, fmt.Errorf("unknown operation %s", operationId)
+	}
+	return status, nil
+}
+
+type asyncTestCloudProvider struct {
+	*testprovider.TestCloudProvider
+	operations map[string]cloudprovider.OperationStatus
+}
+
+func (p *asyncTestCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0)
+	for _, nodeGroup := range p.TestCloudProvider.NodeGroups() {
+		result = append(result, &asyncTestNodeGroup{
+			TestNodeGroup: nodeGroup.(*testprovider.TestNodeGroup),
+			operations:    p.operations,
+		})
+	}
+	return result
+}
+
+func TestFailedScaleUpOperation(t *testing.T) {
+	now := time.Now()
+
+	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(ng1_1, true, now.Add(-time.Minute))
+	ng2_1 := BuildTestNode("ng2-1", 1000, 1000)
+	SetNodeReadyState(ng2_1, true, now.Add(-time.Minute))
+
+	provider := &asyncTestCloudProvider{
+		TestCloudProvider: testprovider.NewTestCloudProvider(nil, nil),
+		operations: map[string]cloudprovider.OperationStatus{
+			"op-1": {Done: true, Error: fmt.Errorf("quota exceeded")},
+			"op-2": {Done: false},
+		},
+	}
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNodeGroup("ng2", 1, 10, 3)
+	provider.AddNode("ng1", ng1_1)
+	provider.AddNode("ng2", ng2_1)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+	}, fakeLogRecorder)
+	failedRequest := &ScaleUpRequest{
+		NodeGroupName:   "ng1",
+		Increase:        2,
+		Time:            now,
+		ExpectedAddTime: now.Add(time.Minute),
+		OperationId:     "op-1",
+	}
+	runningRequest := &ScaleUpRequest{
+		NodeGroupName:   "ng2",
+		Increase:        2,
+		Time:            now,
+		ExpectedAddTime: now.Add(time.Minute),
+		OperationId:     "op-2",
+	}
+	clusterstate.RegisterScaleUp(failedRequest)
+	clusterstate.RegisterScaleUp(runningRequest)
+
+	err := clusterstate.UpdateNodes([]*apiv1.Node{ng1_1, ng2_1}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ScaleUpRequest{runningRequest}, clusterstate.scaleUpRequests)
+	assert.True(t, failedRequest.operationDone)
+	assert.False(t, runningRequest.operationDone)
+	assert.False(t, clusterstate.IsNodeGroupSafeToScaleUp("ng1", now))
+	assert.True(t, clusterstate.IsNodeGroupSafeToScaleUp("ng2", now))
+	assert.True(t, clusterstate.IsNodeGroupScalingUp("ng2"))
+	assert.Equal(t, map[string]int{"ng1": 2}, clusterstate.TakeFailedScaleUpNodes())
+}
+
+func TestPartialScaleUp(t *testing.T) {
+	now := time.Now()
+
+	// The cloud provider fulfills only 6 of 10 requested nodes.
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 20, 10)
+	nodes := make([]*apiv1.Node, 0)
+	for i := 0; i < 6; i++ {
+		node := BuildTestNode(fmt.Sprintf("ng1-%d", i), 1000, 1000)
+		SetNodeReadyState(node, true, now.Add(-time.Minute))
+		provider.AddNode("ng1", node)
+		nodes = append(nodes, node)
+	}
+	provider.AddNodeGroup("ng2", 0, 20, 1)
+	ng2_1 := BuildTestNode("ng2-1", 1000, 1000)
+	SetNodeReadyState(ng2_1, true, now.Add(-time.Minute))
+	provider.AddNode("ng2", ng2_1)
+	nodes = append(nodes, ng2_1)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		MaxNodeProvisionTime:      15 * time.Minute,
+	}, fakeLogRecorder)
+	clusterstate.RegisterScaleUp(&ScaleUpRequest{
+		NodeGroupName:   "ng1",
+		Increase:        10,
+		Time:            now,
+		ExpectedAddTime: now.Add(15 * time.Minute),
+	})
+
+	// Instances stop arriving, but provision timeout hasn't passed yet.
+	err := clusterstate.UpdateNodes(nodes, now.Add(5*time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, clusterstate.GetPartialScaleUp("ng1"))
+	assert.True(t, clusterstate.IsNodeGroupScalingUp("ng1"))
+	assert.Equal(t, 4, clusterstate.GetUpcomingNodes()["ng1"])
+	assert.Empty(t, clusterstate.TakeFailedScaleUpNodes())
+
+	// No new instances for longer than provision timeout.
+	now = now.Add(21 * time.Minute)
+	err = clusterstate.UpdateNodes(nodes, now)
+	assert.NoError(t, err)
+	partial := clusterstate.GetPartialScaleUp("ng1")
+	assert.NotNil(t, partial)
+	assert.Equal(t, 10, partial.TargetSize)
+	assert.Equal(t, 6, partial.FulfilledSize)
+	assert.Equal(t, map[string]int{"ng1": 4}, clusterstate.TakeFailedScaleUpNodes())
+	assert.Empty(t, clusterstate.scaleUpRequests)
+	assert.False(t, clusterstate.IsNodeGroupScalingUp("ng1"))
+	assert.False(t, clusterstate.IsNodeGroupSafeToScaleUp("ng1", now))
+	assert.True(t, clusterstate.IsNodeGroupSafeToScaleUp("ng2", now))
+	assert.Equal(t, 0, clusterstate.GetUpcomingNodes()["ng1"])
+	assert.Nil(t, clusterstate.GetPartialScaleUp("ng2"))
+
+	status := clusterstate.GetStatus(now)
+	for _, nodeGroupStatus := range status.NodeGroupStatuses {
+		if nodeGroupStatus.ProviderID != "ng1" {
+			continue
+		}
+		condition := nodeGroupStatus.Conditions[1]
+		assert.Equal(t, api.ClusterAutoscalerBackoff, condition.Status)
+		assert.Contains(t, condition.Message, "partiallyFulfilled=6/10")
+	}
+
+	// Target size lowered to the achieved size.
+	for _, nodeGroup := range provider.NodeGroups() {
+		if nodeGroup.Id() == "ng1" {
+			nodeGroup.(*testprovider.TestNodeGroup).SetTargetSize(6)
+		}
+	}
+	now = now.Add(time.Minute)
+	err = clusterstate.UpdateNodes(nodes, now)
+	assert.NoError(t, err)
+	assert.Nil(t, clusterstate.GetPartialScaleUp("ng1"))
+	assert.True(t, clusterstate.IsNodeGroupHealthy("ng1"))
+	assert.False(t, clusterstate.IsNodeGroupSafeToScaleUp("ng1", now))
+}
+
+func TestWastedNodes(t *testing.T) {
+	now := time.Now()
+
+	// The cloud provider fulfills only 6 of 9 requested nodes.
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 20, 10)
+	oldNode := BuildTestNode("ng1-old", 1000, 1000)
+	oldNode.CreationTimestamp = metav1.NewTime(now.Add(-time.Hour))
+	SetNodeReadyState(oldNode, true, now.Add(-time.Hour))
+	provider.AddNode("ng1", oldNode)
+	nodes := []*apiv1.Node{oldNode}
+	for i := 0; i < 6; i++ {
+		node := BuildTestNode(fmt.Sprintf("ng1-%d", i), 1000, 1000)
+		node.CreationTimestamp = metav1.NewTime(now.Add(time.Minute))
+		SetNodeReadyState(node, true, now.Add(2*time.Minute))
+		provider.AddNode("ng1", node)
+		nodes = append(nodes, node)
+	}
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		MaxNodeProvisionTime:      15 * time.Minute,
+	}, fakeLogRecorder)
+	clusterstate.RegisterScaleUp(&ScaleUpRequest{
+		NodeGroupName:   "ng1",
+		Increase:        9,
+		Time:            now,
+		ExpectedAddTime: now.Add(15 * time.Minute),
+		Pods:            []string{"default/p1", "default/p2"},
+	})
+	p2 := BuildTestPod("p2", 1000, 0)
+
+	err := clusterstate.UpdateNodes(nodes, now.Add(5*time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, clusterstate.GetWastedNodes([]*apiv1.Pod{p2}, now.Add(5*time.Minute)))
+
+	// The scale-up is found partially fulfilled while its pods are still pending.
+	now = now.Add(21 * time.Minute)
+	err = clusterstate.UpdateNodes(nodes, now)
+	assert.NoError(t, err)
+	assert.NotNil(t, clusterstate.GetPartialScaleUp("ng1"))
+	wasted := clusterstate.GetWastedNodes([]*apiv1.Pod{p2}, now)
+	assert.Equal(t, 6, len(wasted))
+	assert.NotContains(t, wasted, "ng1-old")
+	assert.Equal(t, "ng1", wasted["ng1-0"].ScaleUp.Request.NodeGroupName)
+	assert.Equal(t, now, wasted["ng1-0"].ScaleUp.FailureTime)
+
+	// The pods got scheduled.
+	assert.Empty(t, clusterstate.GetWastedNodes([]*apiv1.Pod{}, now.Add(time.Minute)))
+	assert.Empty(t, clusterstate.GetWastedNodes([]*apiv1.Pod{p2}, now.Add(time.Minute)))
+}
+
+func TestFailedScaleUpsExpire(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(ng1_1, true, now.Add(-time.Minute))
+	provider.AddNode("ng1", ng1_1)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterstate.registerFailedScaleUpRequest(&ScaleUpRequest{
+		NodeGroupName: "ng1",
+		Increase:      1,
+		Time:          now,
+		Pods:          []string{"default/p1"},
+	}, now)
+
+	// Failed scale-ups are forgotten by the loop even if wasted nodes are never looked up.
+	assert.NoError(t, clusterstate.UpdateNodes([]*apiv1.Node{ng1_1}, now.Add(time.Minute)))
+	assert.Len(t, clusterstate.failedScaleUps, 1)
+	assert.NoError(t, clusterstate.UpdateNodes([]*apiv1.Node{ng1_1}, now.Add(failedScaleUpRetention+time.Minute)))
+	assert.Empty(t, clusterstate.failedScaleUps)
+}
+
+func TestHeadroomStatus(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
+	SetNodeReadyState(ng1_1, true, now.Add(-time.Minute))
+	provider.AddNode("ng1", ng1_1)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{}, fakeLogRecorder)
+	err := clusterstate.UpdateNodes([]*apiv1.Node{ng1_1}, now)
+	assert.NoError(t, err)
+
+	// No headroom configured.
+	for _, condition := range clusterstate.GetStatus(now).ClusterwideConditions {
+		assert.NotEqual(t, api.ClusterAutoscalerHeadroom, condition.Type)
+	}
+
+	clusterstate.UpdateHeadroom([]HeadroomStatus{
+		{Spec: "nodes=2:nodeGroup=ng1", Current: 1, Desired: 2},
+		{Spec: "cpu=1,memory=1Gi:labels=a=b", Current: 1, Desired: 1},
+	}, now)
+	conditions := clusterstate.GetStatus(now).ClusterwideConditions
+	condition := conditions[len(conditions)-1]
+	assert.Equal(t, api.ClusterAutoscalerHeadroom, condition.Type)
+	assert.Equal(t, api.ClusterAutoscalerHeadroomMissing, condition.Status)
+	assert.Equal(t, "nodes=2:nodeGroup=ng1 current=1 desired=2; cpu=1,memory=1Gi:labels=a=b current=1 desired=1", condition.Message)
+
+	clusterstate.UpdateHeadroom([]HeadroomStatus{{Spec: "nodes=2:nodeGroup=ng1", Current: 2, Desired: 2}}, now)
+	conditions = clusterstate.GetStatus(now).ClusterwideConditions
+	assert.Equal(t, api.ClusterAutoscalerHeadroomAvailable, conditions[len(conditions)-1].Status)
+}
+
+func TestCapacityReservationsStatus(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{}, fakeLogRecorder)
+	assert.NoError(t, clusterstate.UpdateNodes([]*apiv1.Node{}, now))
+
+	for _, condition := range clusterstate.GetStatus(now).ClusterwideConditions {
+		assert.NotEqual(t, api.ClusterAutoscalerCapacityReservations, condition.Type)
+	}
+
+	clusterstate.UpdateCapacityReservations([]CapacityReservationStatus{
+		{Reservation: "team-a/cpu=4:nodeGroups=ng1", MissingMilliCPU: 1500},
+		{Reservation: "team-b/memory=1Gi:nodeGroups=ng1"},
+	}, now)
+	conditions := clusterstate.GetStatus(now).ClusterwideConditions
+	condition := conditions[len(conditions)-1]
+	assert.Equal(t, api.ClusterAutoscalerCapacityReservations, condition.Type)
+	assert.Equal(t, api.ClusterAutoscalerCapacityReservationsUnsatisfied, condition.Status)
+	assert.Equal(t, "team-a/cpu=4:nodeGroups=ng1 missing cpu=1500m memory=0; team-b/memory=1Gi:nodeGroups=ng1 satisfied", condition.Message)
+
+	clusterstate.UpdateCapacityReservations([]CapacityReservationStatus{{Reservation: "team-b/memory=1Gi:nodeGroups=ng1"}}, now)
+	conditions = clusterstate.GetStatus(now).ClusterwideConditions
+	assert.Equal(t, api.ClusterAutoscalerCapacityReservationsSatisfied, conditions[len(conditions)-1].Status)
+}
+
+func TestScaleUpLimitsStatus(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{}, fakeLogRecorder)
+	assert.NoError(t, clusterstate.UpdateNodes([]*apiv1.Node{}, now))
+
+	for _, condition := range clusterstate.GetStatus(now).ClusterwideConditions {
+		assert.NotEqual(t, api.ClusterAutoscalerScaleUpLimits, condition.Type)
+	}
+
+	clusterstate.UpdateScaleUpLimits([]ScaleUpLimitStatus{
+		{Limit: "nodes", Current: 10, Max: 10, BlockedPods: 3},
+		{Limit: "cores", Current: 38, Max: 40, BlockedPods: 1},
+	}, now)
+	conditions := clusterstate.GetStatus(now).ClusterwideConditions
+	condition := conditions[len(conditions)-1]
+	assert.Equal(t, api.ClusterAutoscalerScaleUpLimits, condition.Type)
+	assert.Equal(t, api.ClusterAutoscalerLimitReached, condition.Status)
+	assert.Equal(t, "nodes current=10 max=10 blockedPods=3; cores current=38 max=40 blockedPods=1", condition.Message)
+
+	clusterstate.UpdateScaleUpLimits(nil, now)
+	for _, condition := range clusterstate.GetStatus(now).ClusterwideConditions {
+		assert.NotEqual(t, api.ClusterAutoscalerScaleUpLimits, condition.Type)
+	}
+}
+
+func TestStockouts(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	nodes := make([]*apiv1.Node, 0)
+	for _, nodeGroup := range []string{"ng1", "ng2"} {
+		node := BuildTestNode(nodeGroup+"-1", 1000, 1000)
+		node.Labels = map[string]string{
+			kubeletapis.LabelInstanceType:      "g5.xlarge",
+			kubeletapis.LabelZoneFailureDomain: "zone-" + nodeGroup,
+		}
+		SetNodeReadyState(node, true, now.Add(-time.Minute))
+		provider.AddNodeGroup(nodeGroup, 1, 10, 1)
+		provider.AddNode(nodeGroup, node)
+		nodes = append(nodes, node)
+	}
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		StockoutMemoryTTL:         10 * time.Minute,
+	}, fakeLogRecorder)
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+
+	zone1 := InstanceTypeZone{InstanceType: "g5.xlarge", Zone: "zone-ng1"}
+	zone2 := InstanceTypeZone{InstanceType: "g5.xlarge", Zone: "zone-ng2"}
+	clusterstate.RegisterFailedScaleUp("ng1", metrics.APIError)
+	assert.False(t, clusterstate.IsStockedOut(zone1, now))
+
+	// Group templates are not available, the instance type and zone are read from the nodes.
+	clusterstate.RegisterFailedScaleUp("ng1", metrics.OutOfResources)
+	assert.True(t, clusterstate.IsStockedOut(zone1, now))
+	assert.False(t, clusterstate.IsStockedOut(zone2, now))
+	assert.False(t, clusterstate.IsStockedOut(zone1, now.Add(11*time.Minute)))
+
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now.Add(11*time.Minute)))
+	assert.Empty(t, clusterstate.stockouts)
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterstate
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/golang/glog"
+)
+
+// NodeGroupDrift describes how the cloud provider view of a node group differs from the nodes
+// registered in Kubernetes and from the changes made by CA, for example after manual resizes in
+// the cloud console or resizes by other controllers.
+type NodeGroupDrift struct {
+	// MissingNodes is the number of instances that didn't register as nodes within the provision timeout.
+	MissingNodes int
+	// ExtraInstances is the number of instances above the target size while no node of the group
+	// is being deleted.
+	ExtraInstances int
+	// TargetMismatch is the number of instances missing to the target size while no scale-up of
+	// the group is in progress.
+	TargetMismatch int
+	// FirstObserved is the time when the target size mismatch was first seen.
+	FirstObserved time.Time
+}
+
+// Drifted returns true if any kind of drift was found.
+func (d NodeGroupDrift) Drifted() bool {
+	return d.MissingNodes > 0 || d.ExtraInstances > 0 || d.TargetMismatch > 0
+}
+
+// updateDrift compares target sizes and instances of node groups with registered nodes and
+// pending scale requests.
+// To be executed under a lock.
+func (csr *ClusterStateRegistry) updateDrift(targetSizes map[string]int, currentTime time.Time) {
+	scaleUps := make(map[string]bool)
+	for _, sur := range csr.scaleUpRequests {
+		scaleUps[sur.NodeGroupName] = true
+	}
+	scaleDowns := make(map[string]bool)
+	for _, sdr := range csr.scaleDownRequests {
+		scaleDowns[sdr.NodeGroupName] = true
+	}
+
+	result := make(map[string]NodeGroupDrift)
+	for id, target := range targetSizes {
+		instances, found := csr.instanceCounts[id]
+		if !found {
+			continue
+		}
+		drift := NodeGroupDrift{
+			MissingNodes: csr.perNodeGroupReadiness[id].LongUnregistered,
+		}
+		if instances.count > target && !scaleDowns[id] {
+			drift.ExtraInstances = instances.count - target
+		}
+		if instances.count < target && !scaleUps[id] {
+			drift.TargetMismatch = target - instances.count
+			drift.FirstObserved = currentTime
+			if existing, found := csr.drift[id]; found && existing.TargetMismatch > 0 {
+				drift.FirstObserved = existing.FirstObserved
+			}
+		}
+		if drift.Drifted() {
+			if _, found := csr.drift[id]; !found {
+				glog.Warningf("Node group %s drifted from the cluster: missingNodes=%d extraInstances=%d targetMismatch=%d",
+					id, drift.MissingNodes, drift.ExtraInstances, drift.TargetMismatch)
+			}
+			result[id] = drift
+		}
+		metrics.UpdateNodeGroupDrift(id, drift.MissingNodes, drift.ExtraInstances, drift.TargetMismatch)
+	}
+	csr.drift = result
+}
+
+// GetNodeGroupDrift returns the drift of the node group, or nil if it has none.
+func (csr *ClusterStateRegistry) GetNodeGroupDrift(nodeGroupName string) *NodeGroupDrift {
+	csr.Lock()
+	defer csr.Unlock()
+
+	if drift, found := csr.drift[nodeGroupName]; found {
+		return &drift
+	}
+	return nil
+}
+
+func buildDriftStatusNodeGroup(drift NodeGroupDrift, lastProbed time.Time) api.ClusterAutoscalerCondition {
+	return api.ClusterAutoscalerCondition{
+		Type:   api.ClusterAutoscalerDrift,
+		Status: api.ClusterAutoscalerDrifted,
+		Message: fmt.Sprintf("missingNodes=%d extraInstances=%d targetMismatch=%d",
+			drift.MissingNodes, drift.ExtraInstances, drift.TargetMismatch),
+		LastProbeTime: metav1.Time{Time: lastProbed},
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clusterstate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	"k8s.io/client-go/kubernetes/fake"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeGroupDrift(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	nodes := make([]*apiv1.Node, 0)
+	addNodes := func(nodeGroup string, count int, registered bool) {
+		for i := 0; i < count; i++ {
+			node := BuildTestNode(fmt.Sprintf("%s-%d-%v", nodeGroup, i, registered), 1000, 1000)
+			SetNodeReadyState(node, true, now.Add(-time.Hour))
+			provider.AddNode(nodeGroup, node)
+			if registered {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	// One instance above the target size.
+	provider.AddNodeGroup("extra", 0, 10, 2)
+	addNodes("extra", 3, true)
+	// One instance missing to the target size.
+	provider.AddNodeGroup("mismatch", 0, 10, 3)
+	addNodes("mismatch", 2, true)
+	// One instance without a node.
+	provider.AddNodeGroup("missing", 0, 10, 2)
+	addNodes("missing", 1, true)
+	addNodes("missing", 1, false)
+	// Instances are being added or removed by CA.
+	provider.AddNodeGroup("scaling-up", 0, 10, 3)
+	addNodes("scaling-up", 2, true)
+	provider.AddNodeGroup("scaling-down", 0, 10, 1)
+	addNodes("scaling-down", 2, true)
+	provider.AddNodeGroup("ok", 0, 10, 2)
+	addNodes("ok", 2, true)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterstate := NewClusterStateRegistry(provider, ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		MaxNodeProvisionTime:      15 * time.Minute,
+	}, fakeLogRecorder)
+	clusterstate.RegisterScaleUp(&ScaleUpRequest{
+		NodeGroupName:   "scaling-up",
+		Increase:        1,
+		Time:            now,
+		ExpectedAddTime: now.Add(time.Hour),
+	})
+	clusterstate.RegisterScaleDown(&ScaleDownRequest{
+		NodeGroupName:      "scaling-down",
+		NodeName:           "scaling-down-0-true",
+		Time:               now,
+		ExpectedDeleteTime: now.Add(time.Hour),
+	})
+
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, now))
+	assert.Equal(t, &NodeGroupDrift{ExtraInstances: 1}, clusterstate.GetNodeGroupDrift("extra"))
+	assert.Equal(t, &NodeGroupDrift{TargetMismatch: 1, FirstObserved: now}, clusterstate.GetNodeGroupDrift("mismatch"))
+	// The instance may still register.
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("missing"))
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("scaling-up"))
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("scaling-down"))
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("ok"))
+
+	later := now.Add(20 * time.Minute)
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, later))
+	assert.Equal(t, &NodeGroupDrift{MissingNodes: 1}, clusterstate.GetNodeGroupDrift("missing"))
+	// The mismatch is first observed when it started.
+	assert.Equal(t, &NodeGroupDrift{TargetMismatch: 1, FirstObserved: now}, clusterstate.GetNodeGroupDrift("mismatch"))
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("ok"))
+
+	status := clusterstate.GetStatus(later)
+	for _, nodeGroupStatus := range status.NodeGroupStatuses {
+		condition := api.GetConditionByType(api.ClusterAutoscalerDrift, nodeGroupStatus.Conditions)
+		switch nodeGroupStatus.ProviderID {
+		case "extra", "mismatch", "missing":
+			if assert.NotNil(t, condition, nodeGroupStatus.ProviderID) {
+				assert.Equal(t, api.ClusterAutoscalerDrifted, condition.Status)
+			}
+		default:
+			assert.Nil(t, condition, nodeGroupStatus.ProviderID)
+		}
+		if nodeGroupStatus.ProviderID == "missing" {
+			assert.Equal(t, "missingNodes=1 extraInstances=0 targetMismatch=0", condition.Message)
+		}
+	}
+
+	// The target size was fixed.
+	for _, nodeGroup := range provider.NodeGroups() {
+		if nodeGroup.Id() == "mismatch" {
+			nodeGroup.(*testprovider.TestNodeGroup).SetTargetSize(2)
+		}
+	}
+	assert.NoError(t, clusterstate.UpdateNodes(nodes, later.Add(time.Minute)))
+	assert.Nil(t, clusterstate.GetNodeGroupDrift("mismatch"))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// CapacityMargin is capacity subtracted from the allocatable of template nodes when estimating how
+// many nodes pods need, to account for real nodes having slightly less room than their template.
+type CapacityMargin struct {
+	// Fraction is the part of allocatable cpu and memory subtracted, from 0 to 1.
+	Fraction float64
+	// CPU is the cpu subtracted on top of Fraction.
+	CPU resource.Quantity
+	// Memory is the memory subtracted on top of Fraction.
+	Memory resource.Quantity
+}
+
+// CapacityMarginFromString parses a margin either in the form of `<percent>%` or
+// `cpu=<quantity>,memory=<quantity>`, with any of the resources omitted.
+func CapacityMarginFromString(value string) (*CapacityMargin, error) {
+	margin := &CapacityMargin{}
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse capacity margin percentage: %v", err)
+		}
+		margin.Fraction = percent / 100
+	} else {
+		for _, field := range strings.Split(value, ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("wrong capacity margin: %s, expected <percent>%% or cpu=<quantity>,memory=<quantity>", value)
+			}
+			var err error
+			switch kv[0] {
+			case "cpu":
+				margin.CPU, err = resource.ParseQuantity(kv[1])
+			case "memory":
+				margin.Memory, err = resource.ParseQuantity(kv[1])
+			default:
+				return nil, fmt.Errorf("unknown capacity margin resource: %s", kv[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse capacity margin %s: %v", kv[0], err)
+			}
+		}
+	}
+	if err := margin.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid capacity margin: %v", err)
+	}
+	return margin, nil
+}
+
+// Validate produces an error if there's an invalid field in the margin.
+func (m *CapacityMargin) Validate() error {
+	if m.Fraction < 0 || m.Fraction >= 1 {
+		return fmt.Errorf("percentage must be at least 0 and less than 100")
+	}
+	if m.CPU.Sign() < 0 || m.Memory.Sign() < 0 {
+		return fmt.Errorf("margin must not be negative")
+	}
+	return nil
+}
+
+// Apply returns cpu in millicores and memory in bytes left of the given allocatable after the margin.
+func (m *CapacityMargin) Apply(milliCPU, memory int64) (int64, int64) {
+	milliCPU -= int64(float64(milliCPU)*m.Fraction) + m.CPU.MilliValue()
+	memory -= int64(float64(memory)*m.Fraction) + m.Memory.Value()
+	if milliCPU < 0 {
+		milliCPU = 0
+	}
+	if memory < 0 {
+		memory = 0
+	}
+	return milliCPU, memory
+}
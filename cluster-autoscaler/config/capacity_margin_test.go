@@ -0,0 +1,59 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapacityMarginFromString(t *testing.T) {
+	margin, err := CapacityMarginFromString("2%")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.02, margin.Fraction)
+	milliCPU, memory := margin.Apply(4000, 1000000)
+	assert.Equal(t, int64(3920), milliCPU)
+	assert.Equal(t, int64(980000), memory)
+
+	margin, err = CapacityMarginFromString("cpu=100m,memory=1Mi")
+	assert.NoError(t, err)
+	milliCPU, memory = margin.Apply(4000, 2*1024*1024)
+	assert.Equal(t, int64(3900), milliCPU)
+	assert.Equal(t, int64(1024*1024), memory)
+	milliCPU, memory = margin.Apply(50, 1024)
+	assert.Equal(t, int64(0), milliCPU)
+	assert.Equal(t, int64(0), memory)
+
+	margin, err = CapacityMarginFromString("memory=256Mi")
+	assert.NoError(t, err)
+	assert.True(t, margin.CPU.IsZero())
+
+	for _, value := range []string{
+		"2",
+		"x%",
+		"-1%",
+		"100%",
+		"cpu=-100m",
+		"gpu=1",
+		"cpu=x",
+		"cpu=100m,",
+	} {
+		_, err = CapacityMarginFromString(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// CapacityReservation describes capacity guaranteed to the pods of a namespace in some node groups.
+// Requests of the namespace pods running in the node groups count towards the reservation, the rest
+// of it has to be kept free.
+type CapacityReservation struct {
+	// Namespace is the namespace the capacity is reserved for.
+	Namespace string
+	// NodeGroups are the ids of node groups in which the capacity is reserved. The first one is
+	// expanded when the reservation isn't satisfied.
+	NodeGroups []string
+	// CPU is the reserved CPU.
+	CPU resource.Quantity
+	// Memory is the reserved memory.
+	Memory resource.Quantity
+
+	value string
+}
+
+// String returns the reservation in the format it was parsed from, prefixed with the namespace.
+func (r *CapacityReservation) String() string {
+	return fmt.Sprintf("%s/%s", r.Namespace, r.value)
+}
+
+// CapacityReservationFromString parses a reservation for the namespace in the form of
+// `cpu=<quantity>,memory=<quantity>:nodeGroups=<id>[,<id>]`.
+func CapacityReservationFromString(namespace, value string) (*CapacityReservation, error) {
+	tokens := strings.SplitN(value, ":", 2)
+	if len(tokens) != 2 {
+		return nil, fmt.Errorf("wrong capacity reservation: %s, expected <amount>:nodeGroups=<ids>", value)
+	}
+	reservation := &CapacityReservation{Namespace: namespace, value: value}
+
+	for _, field := range strings.Split(tokens[0], ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("wrong capacity reservation amount: %s", field)
+		}
+		var err error
+		switch kv[0] {
+		case "cpu":
+			reservation.CPU, err = resource.ParseQuantity(kv[1])
+		case "memory":
+			reservation.Memory, err = resource.ParseQuantity(kv[1])
+		default:
+			return nil, fmt.Errorf("unknown capacity reservation amount: %s", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse capacity reservation %s: %v", kv[0], err)
+		}
+	}
+
+	if !strings.HasPrefix(tokens[1], "nodeGroups=") {
+		return nil, fmt.Errorf("wrong capacity reservation target: %s, expected nodeGroups=<ids>", tokens[1])
+	}
+	for _, id := range strings.Split(strings.TrimPrefix(tokens[1], "nodeGroups="), ",") {
+		if id != "" {
+			reservation.NodeGroups = append(reservation.NodeGroups, id)
+		}
+	}
+
+	if err := reservation.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid capacity reservation: %v", err)
+	}
+	return reservation, nil
+}
+
+// Validate produces an error if there's an invalid field in the reservation.
+func (r *CapacityReservation) Validate() error {
+	if r.Namespace == "" {
+		return fmt.Errorf("namespace must be set")
+	}
+	if len(r.NodeGroups) == 0 {
+		return fmt.Errorf("node groups must be set")
+	}
+	if r.CPU.Sign() < 0 || r.Memory.Sign() < 0 {
+		return fmt.Errorf("reservation must not be negative")
+	}
+	if r.CPU.IsZero() && r.Memory.IsZero() {
+		return fmt.Errorf("cpu or memory must be set")
+	}
+	return nil
+}
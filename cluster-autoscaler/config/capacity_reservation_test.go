@@ -0,0 +1,52 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapacityReservationFromString(t *testing.T) {
+	reservation, err := CapacityReservationFromString("team-a", "cpu=4,memory=8Gi:nodeGroups=ng1,ng2")
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", reservation.Namespace)
+	assert.Equal(t, []string{"ng1", "ng2"}, reservation.NodeGroups)
+	assert.Equal(t, int64(4000), reservation.CPU.MilliValue())
+	assert.Equal(t, int64(8*1024*1024*1024), reservation.Memory.Value())
+	assert.Equal(t, "team-a/cpu=4,memory=8Gi:nodeGroups=ng1,ng2", reservation.String())
+
+	reservation, err = CapacityReservationFromString("team-b", "memory=1Gi:nodeGroups=ng1")
+	assert.NoError(t, err)
+	assert.True(t, reservation.CPU.IsZero())
+
+	for _, value := range []string{
+		"cpu=4",
+		"cpu=4:nodeGroup=ng1",
+		"cpu=4:nodeGroups=",
+		"cpu=x:nodeGroups=ng1",
+		"gpus=1:nodeGroups=ng1",
+		"cpu=0:nodeGroups=ng1",
+		"cpu=-1:nodeGroups=ng1",
+	} {
+		_, err = CapacityReservationFromString("team-a", value)
+		assert.Error(t, err, value)
+	}
+	_, err = CapacityReservationFromString("", "cpu=4:nodeGroups=ng1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReloadableOptions are names of flags whose values can be changed at runtime by editing the configuration file.
+var ReloadableOptions = []string{
+	"scale-down-utilization-threshold",
+	"scale-down-unneeded-time",
+	"scale-down-unready-time",
+	"scale-down-delay-after-add",
+	"scale-down-delay-after-delete",
+	"scale-down-delay-after-failure",
+}
+
+// FileConfig is the content of the configuration file passed with --config.
+type FileConfig struct {
+	// Options contain values of command line flags, keyed by flag name. Flags accepting multiple
+	// values, like --nodes, take a list.
+	Options map[string]json.RawMessage `json:"options,omitempty"`
+	// NodeGroupDefaults are options of all node groups, overriding the global ones.
+	NodeGroupDefaults NodeGroupConfig `json:"nodeGroupDefaults"`
+	// NodeGroups are options of node groups matching the name or the regex. Later entries
+	// take precedence over earlier ones.
+	NodeGroups []NodeGroupConfig `json:"nodeGroups,omitempty"`
+	// FailoverChains are ordered lists of node groups. Scale-up uses the first group of a chain
+	// that can help pending pods, and scale-down prefers removing nodes from later groups.
+	FailoverChains []FailoverChainConfig `json:"failoverChains,omitempty"`
+	// TimeProfiles override reloadable options during recurring time windows. The first profile
+	// whose window contains the current time is used.
+	TimeProfiles []TimeProfileConfig `json:"timeProfiles,omitempty"`
+
+	hash string
+}
+
+// FailoverChainConfig declares node groups that can replace each other, in the order of preference,
+// for example a spot node group followed by an on-demand one.
+type FailoverChainConfig struct {
+	// Name identifies the chain in logs.
+	Name string `json:"name"`
+	// NodeGroups are ids of node groups in the chain, the preferred one first.
+	NodeGroups []string `json:"nodeGroups"`
+}
+
+// NodeGroupConfig contains autoscaling options set in the configuration file for node groups.
+// Options that are not set are inherited.
+type NodeGroupConfig struct {
+	// Name is the id of the node group the options apply to.
+	Name string `json:"name,omitempty"`
+	// NameRegex is a regular expression matching ids of node groups the options apply to.
+	NameRegex string `json:"nameRegex,omitempty"`
+
+	ScaleDownUtilizationThreshold *float64         `json:"scaleDownUtilizationThreshold,omitempty"`
+	ScaleDownUnneededTime         *metav1.Duration `json:"scaleDownUnneededTime,omitempty"`
+	ScaleDownUnreadyTime          *metav1.Duration `json:"scaleDownUnreadyTime,omitempty"`
+	MaxGracefulTerminationSec     *int             `json:"maxGracefulTerminationSec,omitempty"`
+	MaxDrainParallelism           *int             `json:"maxDrainParallelism,omitempty"`
+	MaxNodesPerMinute             *int             `json:"maxNodesPerMinute,omitempty"`
+	SplitWeight                   *int             `json:"splitWeight,omitempty"`
+
+	nameRegex *regexp.Regexp
+}
+
+// NodeGroupAutoscalingOptions are autoscaling options in effect for a node group.
+type NodeGroupAutoscalingOptions struct {
+	// ScaleDownUtilizationThreshold sets threshold for nodes to be considered for scale down.
+	ScaleDownUtilizationThreshold float64
+	// ScaleDownUnneededTime sets the duration a node has to be unneeded before it's removed.
+	ScaleDownUnneededTime time.Duration
+	// ScaleDownUnreadyTime sets the duration an unready node has to be unneeded before it's removed.
+	ScaleDownUnreadyTime time.Duration
+	// MaxGracefulTerminationSec is the maximum number of seconds scale down waits for each pod of
+	// a node of the group to terminate.
+	MaxGracefulTerminationSec int
+	// MaxDrainParallelism is the maximum number of nodes of the group that can be removed at the
+	// same time. 0 means no limit.
+	MaxDrainParallelism int
+	// MaxNodesPerMinute is the maximum number of nodes that can be added to the group per minute.
+	// 0 means no limit.
+	MaxNodesPerMinute int
+	// SplitWeight is the weight of the group when new nodes are split between similar node groups
+	// with the priority splitting strategy.
+	SplitWeight int
+}
+
+// DefaultSplitWeight is the weight of node groups without splitWeight set in the configuration file.
+const DefaultSplitWeight = 1
+
+// LoadFileConfig reads and validates the configuration file.
+func LoadFileConfig(path string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %s: %v", path, err)
+	}
+	return ParseFileConfig(data)
+}
+
+// ParseFileConfig parses and validates configuration in YAML or JSON format.
+func ParseFileConfig(data []byte) (*FileConfig, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %v", err)
+	}
+	config := &FileConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	config.hash = hex.EncodeToString(sum[:8])
+	return config, nil
+}
+
+// Hash identifies the content of the configuration file.
+func (c *FileConfig) Hash() string {
+	return c.hash
+}
+
+func (c *FileConfig) validate() error {
+	for _, name := range c.optionNames() {
+		if name == "config" {
+			return fmt.Errorf("options.config: the configuration file can't be set in itself")
+		}
+		if _, err := c.OptionValues(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range ReloadableOptions {
+		if err := validateReloadableOption(c.Options, "options", name); err != nil {
+			return err
+		}
+	}
+	if c.NodeGroupDefaults.Name != "" || c.NodeGroupDefaults.NameRegex != "" {
+		return fmt.Errorf("nodeGroupDefaults: name and nameRegex are not allowed")
+	}
+	if err := c.NodeGroupDefaults.validate("nodeGroupDefaults"); err != nil {
+		return err
+	}
+	for i := range c.NodeGroups {
+		path := fmt.Sprintf("nodeGroups[%d]", i)
+		nodeGroup := &c.NodeGroups[i]
+		if (nodeGroup.Name == "") == (nodeGroup.NameRegex == "") {
+			return fmt.Errorf("%s: exactly one of name and nameRegex must be set", path)
+		}
+		if nodeGroup.NameRegex != "" {
+			regex, err := regexp.Compile(nodeGroup.NameRegex)
+			if err != nil {
+				return fmt.Errorf("%s.nameRegex: %v", path, err)
+			}
+			nodeGroup.nameRegex = regex
+		}
+		if err := nodeGroup.validate(path); err != nil {
+			return err
+		}
+	}
+	chainsOfNodeGroups := make(map[string]string)
+	for i, chain := range c.FailoverChains {
+		path := fmt.Sprintf("failoverChains[%d]", i)
+		if chain.Name == "" {
+			return fmt.Errorf("%s.name: must be set", path)
+		}
+		if len(chain.NodeGroups) < 2 {
+			return fmt.Errorf("%s.nodeGroups: at least 2 node groups are required, got %d", path, len(chain.NodeGroups))
+		}
+		for _, nodeGroupId := range chain.NodeGroups {
+			if other, found := chainsOfNodeGroups[nodeGroupId]; found {
+				return fmt.Errorf("%s.nodeGroups: node group %s is already in failover chain %s", path, nodeGroupId, other)
+			}
+			chainsOfNodeGroups[nodeGroupId] = chain.Name
+		}
+	}
+	profileNames := make(map[string]bool)
+	for i := range c.TimeProfiles {
+		path := fmt.Sprintf("timeProfiles[%d]", i)
+		profile := &c.TimeProfiles[i]
+		if profileNames[profile.Name] {
+			return fmt.Errorf("%s.name: duplicate profile %s", path, profile.Name)
+		}
+		profileNames[profile.Name] = true
+		if err := profile.validate(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateReloadableOption(options map[string]json.RawMessage, path string, name string) error {
+	values, err := optionValues(options, path, name)
+	if err != nil || len(values) == 0 {
+		return err
+	}
+	if len(values) > 1 {
+		return fmt.Errorf("%s.%s: expected a single value", path, name)
+	}
+	if name == "scale-down-utilization-threshold" {
+		threshold, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", path, name, err)
+		}
+		return validateThreshold(path+"."+name, threshold)
+	}
+	duration, err := time.ParseDuration(values[0])
+	if err != nil {
+		return fmt.Errorf("%s.%s: %v", path, name, err)
+	}
+	return validateDuration(path+"."+name, duration)
+}
+
+func (c *NodeGroupConfig) validate(path string) error {
+	if c.ScaleDownUtilizationThreshold != nil {
+		if err := validateThreshold(path+".scaleDownUtilizationThreshold", *c.ScaleDownUtilizationThreshold); err != nil {
+			return err
+		}
+	}
+	if c.ScaleDownUnneededTime != nil {
+		if err := validateDuration(path+".scaleDownUnneededTime", c.ScaleDownUnneededTime.Duration); err != nil {
+			return err
+		}
+	}
+	if c.ScaleDownUnreadyTime != nil {
+		if err := validateDuration(path+".scaleDownUnreadyTime", c.ScaleDownUnreadyTime.Duration); err != nil {
+			return err
+		}
+	}
+	if c.MaxGracefulTerminationSec != nil && *c.MaxGracefulTerminationSec < 0 {
+		return fmt.Errorf("%s.maxGracefulTerminationSec: must not be negative, got %d", path, *c.MaxGracefulTerminationSec)
+	}
+	if c.MaxDrainParallelism != nil && *c.MaxDrainParallelism < 0 {
+		return fmt.Errorf("%s.maxDrainParallelism: must not be negative, got %d", path, *c.MaxDrainParallelism)
+	}
+	if c.MaxNodesPerMinute != nil && *c.MaxNodesPerMinute < 0 {
+		return fmt.Errorf("%s.maxNodesPerMinute: must not be negative, got %d", path, *c.MaxNodesPerMinute)
+	}
+	if c.SplitWeight != nil && *c.SplitWeight < 0 {
+		return fmt.Errorf("%s.splitWeight: must not be negative, got %d", path, *c.SplitWeight)
+	}
+	return nil
+}
+
+func validateThreshold(path string, threshold float64) error {
+	if threshold < 0 || threshold > 1 {
+		return fmt.Errorf("%s: must be between 0 and 1, got %v", path, threshold)
+	}
+	return nil
+}
+
+func validateDuration(path string, duration time.Duration) error {
+	if duration < 0 {
+		return fmt.Errorf("%s: must not be negative, got %v", path, duration)
+	}
+	return nil
+}
+
+func (c *FileConfig) optionNames() []string {
+	names := make([]string, 0, len(c.Options))
+	for name := range c.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OptionValues returns values of the option in the format accepted by the flag. Returns an empty
+// list if the option is not set.
+func (c *FileConfig) OptionValues(name string) ([]string, error) {
+	return optionValues(c.Options, "options", name)
+}
+
+func optionValues(options map[string]json.RawMessage, path string, name string) ([]string, error) {
+	raw, found := options[name]
+	if !found {
+		return []string{}, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		value, err := rawToString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", path, name, err)
+		}
+		return []string{value}, nil
+	}
+	values := make([]string, 0, len(list))
+	for i, item := range list {
+		value, err := rawToString(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s[%d]: %v", path, name, i, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// rawToString converts a JSON scalar to the string it would be written as on the command line.
+func rawToString(raw json.RawMessage) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", err
+	}
+	switch value.(type) {
+	case string:
+		return value.(string), nil
+	case float64, bool:
+		return string(bytes.TrimSpace(raw)), nil
+	default:
+		return "", fmt.Errorf("expected a string, a number or a boolean, got %s", string(raw))
+	}
+}
+
+// ApplyToFlags sets flags to values from the configuration file. Flags set on the command line
+// keep their values.
+func (c *FileConfig) ApplyToFlags(flags *pflag.FlagSet) error {
+	for _, name := range c.optionNames() {
+		flag := flags.Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("options.%s: unknown flag", name)
+		}
+		if flag.Changed {
+			glog.V(1).Infof("Flag --%s set on the command line, ignoring its value from the configuration file", name)
+			continue
+		}
+		values, err := c.OptionValues(name)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("options.%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NodeGroupOptions returns options in effect for the node group. Node group defaults are applied on top
+// of the global options, followed by matching node group entries.
+func (c *FileConfig) NodeGroupOptions(nodeGroupId string, global NodeGroupAutoscalingOptions) NodeGroupAutoscalingOptions {
+	if c == nil {
+		return global
+	}
+	result := global
+	c.NodeGroupDefaults.applyTo(&result)
+	for _, nodeGroup := range c.NodeGroups {
+		if nodeGroup.matches(nodeGroupId) {
+			nodeGroup.applyTo(&result)
+		}
+	}
+	return result
+}
+
+// FailoverChain returns the failover chain the node group belongs to.
+func (c *FileConfig) FailoverChain(nodeGroupId string) (FailoverChainConfig, bool) {
+	if c == nil {
+		return FailoverChainConfig{}, false
+	}
+	for _, chain := range c.FailoverChains {
+		for _, id := range chain.NodeGroups {
+			if id == nodeGroupId {
+				return chain, true
+			}
+		}
+	}
+	return FailoverChainConfig{}, false
+}
+
+func (c *NodeGroupConfig) matches(nodeGroupId string) bool {
+	if c.nameRegex != nil {
+		return c.nameRegex.MatchString(nodeGroupId)
+	}
+	return c.Name == nodeGroupId
+}
+
+func (c *NodeGroupConfig) applyTo(options *NodeGroupAutoscalingOptions) {
+	if c.ScaleDownUtilizationThreshold != nil {
+		options.ScaleDownUtilizationThreshold = *c.ScaleDownUtilizationThreshold
+	}
+	if c.ScaleDownUnneededTime != nil {
+		options.ScaleDownUnneededTime = c.ScaleDownUnneededTime.Duration
+	}
+	if c.ScaleDownUnreadyTime != nil {
+		options.ScaleDownUnreadyTime = c.ScaleDownUnreadyTime.Duration
+	}
+	if c.MaxGracefulTerminationSec != nil {
+		options.MaxGracefulTerminationSec = *c.MaxGracefulTerminationSec
+	}
+	if c.MaxDrainParallelism != nil {
+		options.MaxDrainParallelism = *c.MaxDrainParallelism
+	}
+	if c.MaxNodesPerMinute != nil {
+		options.MaxNodesPerMinute = *c.MaxNodesPerMinute
+	}
+	if c.SplitWeight != nil {
+		options.SplitWeight = *c.SplitWeight
+	}
+}
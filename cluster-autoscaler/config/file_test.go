@@ -0,0 +1,203 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+const testFileConfig = `
+options:
+  scale-down-utilization-threshold: 0.4
+  scale-down-unneeded-time: 5m
+  max-nodes-total: 100
+  scale-down-enabled: false
+  nodes:
+  - 1:10:ng1
+  - 1:20:ng2
+nodeGroupDefaults:
+  scaleDownUnneededTime: 20m
+nodeGroups:
+- nameRegex: ^gpu-
+  scaleDownUtilizationThreshold: 0.8
+- name: gpu-special
+  scaleDownUnneededTime: 1h
+  maxGracefulTerminationSec: 3600
+  maxDrainParallelism: 2
+  maxNodesPerMinute: 5
+  splitWeight: 3
+failoverChains:
+- name: workers
+  nodeGroups: [spot-ng, ondemand-ng]
+`
+
+func TestParseFileConfig(t *testing.T) {
+	config, err := ParseFileConfig([]byte(testFileConfig))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, config.Hash())
+
+	values, err := config.OptionValues("scale-down-utilization-threshold")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0.4"}, values)
+	values, err = config.OptionValues("scale-down-enabled")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"false"}, values)
+	values, err = config.OptionValues("nodes")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1:10:ng1", "1:20:ng2"}, values)
+	values, err = config.OptionValues("expander")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, values)
+
+	chain, found := config.FailoverChain("ondemand-ng")
+	assert.True(t, found)
+	assert.Equal(t, FailoverChainConfig{Name: "workers", NodeGroups: []string{"spot-ng", "ondemand-ng"}}, chain)
+	_, found = config.FailoverChain("ng1")
+	assert.False(t, found)
+
+	other, err := ParseFileConfig([]byte(testFileConfig + "\n# comment\n"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, config.Hash(), other.Hash())
+}
+
+func TestParseFileConfigErrors(t *testing.T) {
+	for value, expected := range map[string]string{
+		"options: [": "failed to parse configuration",
+		"unknown: 1": "unknown field",
+		"options:\n  scale-down-utilization-threshold: 1.5":                   "options.scale-down-utilization-threshold: must be between 0 and 1",
+		"options:\n  scale-down-unneeded-time: soon":                          "options.scale-down-unneeded-time:",
+		"options:\n  nodes:\n  - 1:10:ng1\n  - {a: b}":                        "options.nodes[1]: expected a string",
+		"options:\n  config: other.yaml":                                      "options.config:",
+		"nodeGroupDefaults:\n  name: ng1":                                     "nodeGroupDefaults: name and nameRegex are not allowed",
+		"nodeGroupDefaults:\n  scaleDownUnreadyTime: -1m":                     "nodeGroupDefaults.scaleDownUnreadyTime: must not be negative",
+		"nodeGroups:\n- scaleDownUtilizationThreshold: 0.5":                   "nodeGroups[0]: exactly one of name and nameRegex must be set",
+		"nodeGroups:\n- name: ng1\n- nameRegex: '['":                          "nodeGroups[1].nameRegex:",
+		"nodeGroups:\n- name: ng1\n  nameRegex: ng":                           "nodeGroups[0]: exactly one of name and nameRegex must be set",
+		"nodeGroups:\n- name: ng1\n  scaleDownUtilizationThreshold: -0.1":     "nodeGroups[0].scaleDownUtilizationThreshold: must be between 0 and 1",
+		"nodeGroups:\n- name: ng1\n- name: ng2\n  scaleDownUnneededTime: xyz": "failed to parse configuration",
+		"nodeGroups:\n- name: ng1\n  maxGracefulTerminationSec: -1":           "nodeGroups[0].maxGracefulTerminationSec: must not be negative",
+		"nodeGroups:\n- name: ng1\n  maxDrainParallelism: -1":                 "nodeGroups[0].maxDrainParallelism: must not be negative",
+		"nodeGroups:\n- name: ng1\n  maxNodesPerMinute: -1":                   "nodeGroups[0].maxNodesPerMinute: must not be negative",
+		"nodeGroups:\n- name: ng1\n  splitWeight: -1":                         "nodeGroups[0].splitWeight: must not be negative",
+		"failoverChains:\n- nodeGroups: [ng1, ng2]":                           "failoverChains[0].name: must be set",
+		"failoverChains:\n- name: c1\n  nodeGroups: [ng1]":                    "failoverChains[0].nodeGroups: at least 2 node groups are required",
+	} {
+		_, err := ParseFileConfig([]byte(value))
+		if assert.Error(t, err, value) {
+			assert.Contains(t, err.Error(), expected, value)
+		}
+	}
+
+	_, err := ParseFileConfig([]byte(`
+failoverChains:
+- name: c1
+  nodeGroups: [ng1, ng2]
+- name: c2
+  nodeGroups: [ng3, ng1]
+`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failoverChains[1].nodeGroups: node group ng1 is already in failover chain c1")
+	}
+}
+
+func TestApplyToFlags(t *testing.T) {
+	goFlags := flag.NewFlagSet("test", flag.ContinueOnError)
+	threshold := goFlags.Float64("scale-down-utilization-threshold", 0.5, "")
+	unneededTime := goFlags.Duration("scale-down-unneeded-time", 10*time.Minute, "")
+	maxNodesTotal := goFlags.Int("max-nodes-total", 0, "")
+	goFlags.Int("max-empty-bulk-delete", 10, "")
+	scaleDownEnabled := goFlags.Bool("scale-down-enabled", true, "")
+	nodes := &testMultiStringFlag{}
+	goFlags.Var(nodes, "nodes", "")
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.AddGoFlagSet(goFlags)
+
+	// Set on the command line.
+	assert.NoError(t, flags.Parse([]string{"--scale-down-unneeded-time=2m"}))
+
+	config, err := ParseFileConfig([]byte(testFileConfig))
+	assert.NoError(t, err)
+	assert.NoError(t, config.ApplyToFlags(flags))
+	assert.Equal(t, 0.4, *threshold)
+	assert.Equal(t, 2*time.Minute, *unneededTime)
+	assert.Equal(t, 100, *maxNodesTotal)
+	assert.False(t, *scaleDownEnabled)
+	assert.Equal(t, testMultiStringFlag{"1:10:ng1", "1:20:ng2"}, *nodes)
+
+	config, err = ParseFileConfig([]byte("options:\n  no-such-flag: 1"))
+	assert.NoError(t, err)
+	err = config.ApplyToFlags(flags)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "options.no-such-flag: unknown flag")
+	}
+
+	config, err = ParseFileConfig([]byte("options:\n  max-empty-bulk-delete: many"))
+	assert.NoError(t, err)
+	err = config.ApplyToFlags(flags)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "options.max-empty-bulk-delete:")
+	}
+}
+
+func TestNodeGroupOptions(t *testing.T) {
+	config, err := ParseFileConfig([]byte(testFileConfig))
+	assert.NoError(t, err)
+	global := NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.5,
+		ScaleDownUnneededTime:         10 * time.Minute,
+		ScaleDownUnreadyTime:          20 * time.Minute,
+	}
+
+	assert.Equal(t, NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.5,
+		ScaleDownUnneededTime:         20 * time.Minute,
+		ScaleDownUnreadyTime:          20 * time.Minute,
+	}, config.NodeGroupOptions("ng1", global))
+	assert.Equal(t, NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.8,
+		ScaleDownUnneededTime:         20 * time.Minute,
+		ScaleDownUnreadyTime:          20 * time.Minute,
+	}, config.NodeGroupOptions("gpu-pool", global))
+	assert.Equal(t, NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.8,
+		ScaleDownUnneededTime:         time.Hour,
+		ScaleDownUnreadyTime:          20 * time.Minute,
+		MaxGracefulTerminationSec:     3600,
+		MaxDrainParallelism:           2,
+		MaxNodesPerMinute:             5,
+		SplitWeight:                   3,
+	}, config.NodeGroupOptions("gpu-special", global))
+
+	var noConfig *FileConfig
+	assert.Equal(t, global, noConfig.NodeGroupOptions("ng1", global))
+}
+
+type testMultiStringFlag []string
+
+func (f *testMultiStringFlag) String() string {
+	return ""
+}
+
+func (f *testMultiStringFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// HeadroomSpec describes spare capacity that should be kept in the cluster on top of what
+// the pods need. Headroom is either a number of empty nodes of a node group or a number of
+// replicas of a unit of resources, placed on nodes of a node group or on nodes with given labels.
+type HeadroomSpec struct {
+	// NodeGroup is the id of the node group in which headroom is kept.
+	NodeGroup string
+	// Labels select nodes on which headroom is kept, if NodeGroup is not set.
+	Labels map[string]string
+	// Nodes is the number of empty nodes to keep. Only allowed with NodeGroup.
+	Nodes int
+	// CPU is the CPU in a single unit of headroom.
+	CPU resource.Quantity
+	// Memory is the memory in a single unit of headroom.
+	Memory resource.Quantity
+	// Replicas is the number of headroom units.
+	Replicas int
+
+	value string
+}
+
+// String returns the spec in the format it was parsed from.
+func (s *HeadroomSpec) String() string {
+	return s.value
+}
+
+// HeadroomSpecFromString parses a headroom spec in the form of `<amount>:<target>`, where amount is either
+// `nodes=<count>` or `cpu=<quantity>,memory=<quantity>[,replicas=<count>]` and target is either
+// `nodeGroup=<id>` or `labels=<key>=<value>[,<key>=<value>]`.
+func HeadroomSpecFromString(value string) (*HeadroomSpec, error) {
+	tokens := strings.SplitN(value, ":", 2)
+	if len(tokens) != 2 {
+		return nil, fmt.Errorf("wrong headroom configuration: %s, expected <amount>:<target>", value)
+	}
+	spec := &HeadroomSpec{value: value, Replicas: 1}
+
+	for _, field := range strings.Split(tokens[0], ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("wrong headroom amount: %s", field)
+		}
+		var err error
+		switch kv[0] {
+		case "nodes":
+			spec.Nodes, err = strconv.Atoi(kv[1])
+		case "replicas":
+			spec.Replicas, err = strconv.Atoi(kv[1])
+		case "cpu":
+			spec.CPU, err = resource.ParseQuantity(kv[1])
+		case "memory":
+			spec.Memory, err = resource.ParseQuantity(kv[1])
+		default:
+			return nil, fmt.Errorf("unknown headroom amount: %s", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse headroom %s: %v", kv[0], err)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(tokens[1], "nodeGroup="):
+		spec.NodeGroup = strings.TrimPrefix(tokens[1], "nodeGroup=")
+	case strings.HasPrefix(tokens[1], "labels="):
+		spec.Labels = make(map[string]string)
+		for _, label := range strings.Split(strings.TrimPrefix(tokens[1], "labels="), ",") {
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("wrong headroom label: %s", label)
+			}
+			spec.Labels[kv[0]] = kv[1]
+		}
+	default:
+		return nil, fmt.Errorf("wrong headroom target: %s, expected nodeGroup=<id> or labels=<labels>", tokens[1])
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid headroom spec: %v", err)
+	}
+	return spec, nil
+}
+
+// Validate produces an error if there's an invalid field in the headroom spec.
+func (s *HeadroomSpec) Validate() error {
+	if s.NodeGroup == "" && len(s.Labels) == 0 {
+		return fmt.Errorf("node group or labels must be set")
+	}
+	hasResources := !s.CPU.IsZero() || !s.Memory.IsZero()
+	if s.Nodes < 0 || s.Replicas < 0 || s.CPU.Sign() < 0 || s.Memory.Sign() < 0 {
+		return fmt.Errorf("headroom must not be negative")
+	}
+	if s.Nodes > 0 && hasResources {
+		return fmt.Errorf("headroom must be set either in nodes or in resources")
+	}
+	if s.Nodes == 0 && !hasResources {
+		return fmt.Errorf("headroom must be set in nodes or in resources")
+	}
+	if s.Nodes > 0 && s.NodeGroup == "" {
+		return fmt.Errorf("headroom in nodes requires a node group")
+	}
+	return nil
+}
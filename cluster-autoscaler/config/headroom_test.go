@@ -0,0 +1,57 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeadroomSpecFromString(t *testing.T) {
+	spec, err := HeadroomSpecFromString("nodes=2:nodeGroup=https://www.googleapis.com/compute/v1/projects/p/zones/z/instanceGroups/ig")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://www.googleapis.com/compute/v1/projects/p/zones/z/instanceGroups/ig", spec.NodeGroup)
+	assert.Equal(t, 2, spec.Nodes)
+
+	spec, err = HeadroomSpecFromString("cpu=500m,memory=1Gi,replicas=4:labels=pool=spot,zone=a")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"pool": "spot", "zone": "a"}, spec.Labels)
+	assert.Equal(t, int64(500), spec.CPU.MilliValue())
+	assert.Equal(t, int64(1024*1024*1024), spec.Memory.Value())
+	assert.Equal(t, 4, spec.Replicas)
+	assert.Equal(t, "cpu=500m,memory=1Gi,replicas=4:labels=pool=spot,zone=a", spec.String())
+
+	spec, err = HeadroomSpecFromString("cpu=1:nodeGroup=ng1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, spec.Replicas)
+
+	for _, value := range []string{
+		"nodes=2",
+		"nodes=2:labels=pool=spot",
+		"nodes=2,cpu=1:nodeGroup=ng1",
+		"replicas=2:nodeGroup=ng1",
+		"nodes=x:nodeGroup=ng1",
+		"gpus=1:nodeGroup=ng1",
+		"cpu=1:pool=spot",
+		"cpu=1:labels=pool",
+		"nodes=-1:nodeGroup=ng1",
+	} {
+		_, err = HeadroomSpecFromString(value)
+		assert.Error(t, err, value)
+	}
+}
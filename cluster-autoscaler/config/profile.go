@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// BalancedProfile keeps the usual defaults of CA.
+	BalancedProfile = "balanced"
+	// CostOptimizedProfile removes underutilized nodes more eagerly.
+	CostOptimizedProfile = "cost-optimized"
+	// LatencyOptimizedProfile reacts to pending pods faster and keeps spare nodes longer.
+	LatencyOptimizedProfile = "latency-optimized"
+)
+
+// Profiles map names of profiles to values of flags they set.
+var Profiles = map[string]map[string]string{
+	BalancedProfile: {
+		"scan-interval":                         "10s",
+		"expander":                              "random",
+		"scale-down-utilization-threshold":      "0.5",
+		"scale-down-unneeded-time":              "10m",
+		"scale-down-delay-after-add":            "10m",
+		"scale-down-delay-after-delete":         "10s",
+		"max-empty-bulk-delete":                 "10",
+		"scale-down-non-empty-candidates-count": "30",
+		"estimator":                             "binpacking",
+		"estimator-capacity-margin":             "",
+		"max-nodes-per-minute":                  "0",
+	},
+	CostOptimizedProfile: {
+		"scan-interval":                         "10s",
+		"expander":                              "least-waste",
+		"scale-down-utilization-threshold":      "0.7",
+		"scale-down-unneeded-time":              "5m",
+		"scale-down-delay-after-add":            "5m",
+		"scale-down-delay-after-delete":         "10s",
+		"max-empty-bulk-delete":                 "20",
+		"scale-down-non-empty-candidates-count": "50",
+		"estimator":                             "binpacking",
+		"estimator-capacity-margin":             "",
+		"max-nodes-per-minute":                  "20",
+	},
+	LatencyOptimizedProfile: {
+		"scan-interval":                         "5s",
+		"expander":                              "most-pods",
+		"scale-down-utilization-threshold":      "0.4",
+		"scale-down-unneeded-time":              "20m",
+		"scale-down-delay-after-add":            "20m",
+		"scale-down-delay-after-delete":         "5s",
+		"max-empty-bulk-delete":                 "5",
+		"scale-down-non-empty-candidates-count": "30",
+		"estimator":                             "binpacking",
+		"estimator-capacity-margin":             "5%",
+		"max-nodes-per-minute":                  "0",
+	},
+}
+
+// OptionSource is the layer of configuration setting the value of a flag.
+type OptionSource string
+
+const (
+	// DefaultSource means the flag has its default value.
+	DefaultSource OptionSource = "default"
+	// ProfileSource means the value comes from the profile.
+	ProfileSource OptionSource = "profile"
+	// FileSource means the value comes from the configuration file.
+	FileSource OptionSource = "config-file"
+	// CommandLineSource means the flag is set on the command line.
+	CommandLineSource OptionSource = "command-line"
+)
+
+// OptionProvenance records the layer which set each flag. Flags not in the map have default values.
+type OptionProvenance map[string]OptionSource
+
+// ResolvedOption is the value of a flag in effect.
+type ResolvedOption struct {
+	Name   string       `json:"name"`
+	Value  string       `json:"value"`
+	Source OptionSource `json:"source"`
+}
+
+// RecordChanged marks flags set since the previous call as coming from the source.
+func (p OptionProvenance) RecordChanged(flags *pflag.FlagSet, source OptionSource) {
+	flags.Visit(func(flag *pflag.Flag) {
+		if _, found := p[flag.Name]; !found {
+			p[flag.Name] = source
+		}
+	})
+}
+
+// ApplyProfile sets flags that are not set yet to values from the profile and records them in provenance.
+func ApplyProfile(name string, flags *pflag.FlagSet, provenance OptionProvenance) error {
+	profile, found := Profiles[name]
+	if !found {
+		return fmt.Errorf("unknown profile %s, expected one of: %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	names := make([]string, 0, len(profile))
+	for flagName := range profile {
+		names = append(names, flagName)
+	}
+	sort.Strings(names)
+	for _, flagName := range names {
+		flag := flags.Lookup(flagName)
+		if flag == nil {
+			return fmt.Errorf("profile %s: unknown flag %s", name, flagName)
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := flags.Set(flagName, profile[flagName]); err != nil {
+			return fmt.Errorf("profile %s: %s: %v", name, flagName, err)
+		}
+		provenance[flagName] = ProfileSource
+	}
+	return nil
+}
+
+// ProfileNames returns sorted names of available profiles.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns values of all flags in effect, sorted by name, with their sources.
+func (p OptionProvenance) Resolve(flags *pflag.FlagSet) []ResolvedOption {
+	result := make([]ResolvedOption, 0)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		source, found := p[flag.Name]
+		if !found {
+			source = DefaultSource
+		}
+		result = append(result, ResolvedOption{Name: flag.Name, Value: flag.Value.String(), Source: source})
+	})
+	return result
+}
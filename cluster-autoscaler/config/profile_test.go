@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProfileFlags struct {
+	flags                   *pflag.FlagSet
+	scanInterval            *time.Duration
+	expander                *string
+	threshold               *float64
+	unneededTime            *time.Duration
+	delayAfterAdd           *time.Duration
+	delayAfterDelete        *time.Duration
+	maxEmptyBulkDelete      *int
+	nonEmptyCandidatesCount *int
+	estimator               *string
+	capacityMargin          *string
+	maxNodesPerMinute       *int
+}
+
+func newTestProfileFlags() *testProfileFlags {
+	goFlags := flag.NewFlagSet("test", flag.ContinueOnError)
+	result := &testProfileFlags{
+		scanInterval:            goFlags.Duration("scan-interval", 10*time.Second, ""),
+		expander:                goFlags.String("expander", "random", ""),
+		threshold:               goFlags.Float64("scale-down-utilization-threshold", 0.5, ""),
+		unneededTime:            goFlags.Duration("scale-down-unneeded-time", 10*time.Minute, ""),
+		delayAfterAdd:           goFlags.Duration("scale-down-delay-after-add", 10*time.Minute, ""),
+		delayAfterDelete:        goFlags.Duration("scale-down-delay-after-delete", 10*time.Second, ""),
+		maxEmptyBulkDelete:      goFlags.Int("max-empty-bulk-delete", 10, ""),
+		nonEmptyCandidatesCount: goFlags.Int("scale-down-non-empty-candidates-count", 30, ""),
+		estimator:               goFlags.String("estimator", "binpacking", ""),
+		capacityMargin:          goFlags.String("estimator-capacity-margin", "", ""),
+		maxNodesPerMinute:       goFlags.Int("max-nodes-per-minute", 0, ""),
+	}
+	goFlags.Int("max-nodes-total", 0, "")
+	result.flags = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	result.flags.AddGoFlagSet(goFlags)
+	return result
+}
+
+func TestApplyProfile(t *testing.T) {
+	type expectedValues struct {
+		scanInterval     time.Duration
+		expander         string
+		threshold        float64
+		unneededTime     time.Duration
+		delayAfterAdd    time.Duration
+		delayAfterDelete time.Duration
+		maxEmptyBulk     int
+		nonEmptyCount    int
+		estimator        string
+		capacityMargin   string
+		maxNodesPerMin   int
+	}
+	for profile, expected := range map[string]expectedValues{
+		BalancedProfile:         {10 * time.Second, "random", 0.5, 10 * time.Minute, 10 * time.Minute, 10 * time.Second, 10, 30, "binpacking", "", 0},
+		CostOptimizedProfile:    {10 * time.Second, "least-waste", 0.7, 5 * time.Minute, 5 * time.Minute, 10 * time.Second, 20, 50, "binpacking", "", 20},
+		LatencyOptimizedProfile: {5 * time.Second, "most-pods", 0.4, 20 * time.Minute, 20 * time.Minute, 5 * time.Second, 5, 30, "binpacking", "5%", 0},
+	} {
+		f := newTestProfileFlags()
+		assert.NoError(t, f.flags.Parse([]string{}))
+		provenance := make(OptionProvenance)
+		assert.NoError(t, ApplyProfile(profile, f.flags, provenance), profile)
+		assert.Equal(t, expected, expectedValues{*f.scanInterval, *f.expander, *f.threshold, *f.unneededTime,
+			*f.delayAfterAdd, *f.delayAfterDelete, *f.maxEmptyBulkDelete, *f.nonEmptyCandidatesCount,
+			*f.estimator, *f.capacityMargin, *f.maxNodesPerMinute}, profile)
+		assert.Equal(t, len(Profiles[profile]), len(provenance), profile)
+	}
+}
+
+func TestApplyProfilePrecedence(t *testing.T) {
+	f := newTestProfileFlags()
+	assert.NoError(t, f.flags.Parse([]string{"--scale-down-unneeded-time=2m"}))
+	provenance := make(OptionProvenance)
+	provenance.RecordChanged(f.flags, CommandLineSource)
+
+	config, err := ParseFileConfig([]byte("options:\n  expander: price\n  max-nodes-total: 100\n  scale-down-unneeded-time: 1h"))
+	assert.NoError(t, err)
+	assert.NoError(t, config.ApplyToFlags(f.flags))
+	provenance.RecordChanged(f.flags, FileSource)
+
+	assert.NoError(t, ApplyProfile(CostOptimizedProfile, f.flags, provenance))
+	assert.Equal(t, 2*time.Minute, *f.unneededTime)
+	assert.Equal(t, "price", *f.expander)
+	assert.Equal(t, 0.7, *f.threshold)
+
+	resolved := make(map[string]ResolvedOption)
+	for _, option := range provenance.Resolve(f.flags) {
+		resolved[option.Name] = option
+	}
+	assert.Equal(t, ResolvedOption{Name: "scale-down-unneeded-time", Value: "2m0s", Source: CommandLineSource}, resolved["scale-down-unneeded-time"])
+	assert.Equal(t, ResolvedOption{Name: "expander", Value: "price", Source: FileSource}, resolved["expander"])
+	assert.Equal(t, ResolvedOption{Name: "max-nodes-total", Value: "100", Source: FileSource}, resolved["max-nodes-total"])
+	assert.Equal(t, ResolvedOption{Name: "scale-down-utilization-threshold", Value: "0.7", Source: ProfileSource}, resolved["scale-down-utilization-threshold"])
+	assert.Equal(t, ResolvedOption{Name: "max-nodes-per-minute", Value: "20", Source: ProfileSource}, resolved["max-nodes-per-minute"])
+	assert.Equal(t, ResolvedOption{Name: "estimator", Value: "binpacking", Source: ProfileSource}, resolved["estimator"])
+	assert.Len(t, resolved, 12)
+}
+
+func TestApplyProfileErrors(t *testing.T) {
+	f := newTestProfileFlags()
+	err := ApplyProfile("fastest", f.flags, make(OptionProvenance))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "expected one of: balanced, cost-optimized, latency-optimized")
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Duration("scan-interval", 10*time.Second, "")
+	err = ApplyProfile(BalancedProfile, flags, make(OptionProvenance))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown flag")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MaxTimeProfileDuration is the longest allowed window of a time profile.
+const MaxTimeProfileDuration = 7 * 24 * time.Hour
+
+// TimeProfileConfig overrides options during recurring time windows, for example to remove
+// underutilized nodes more eagerly at night. Only ReloadableOptions can be overridden, as other
+// options are used to build components at startup.
+type TimeProfileConfig struct {
+	// Name identifies the profile in logs, metrics and status.
+	Name string `json:"name"`
+	// Schedule is a cron expression (minute, hour, day of month, month and day of week) of
+	// times the window starts at.
+	Schedule string `json:"schedule"`
+	// Duration is how long the window lasts after each start.
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the name of the time zone the schedule is evaluated in. UTC if empty.
+	TimeZone string `json:"timeZone,omitempty"`
+	// Options contain values of reloadable flags in effect during the window, keyed by flag name.
+	Options map[string]json.RawMessage `json:"options"`
+
+	schedule *cronSchedule
+	location *time.Location
+}
+
+func (p *TimeProfileConfig) validate(path string) error {
+	if p.Name == "" {
+		return fmt.Errorf("%s.name: must be set", path)
+	}
+	schedule, err := parseCronSchedule(p.Schedule)
+	if err != nil {
+		return fmt.Errorf("%s.schedule: %v", path, err)
+	}
+	p.schedule = schedule
+	if p.Duration.Duration <= 0 || p.Duration.Duration > MaxTimeProfileDuration {
+		return fmt.Errorf("%s.duration: must be positive and at most %v, got %v", path, MaxTimeProfileDuration, p.Duration.Duration)
+	}
+	p.location, err = time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return fmt.Errorf("%s.timeZone: %v", path, err)
+	}
+	reloadable := make(map[string]bool)
+	for _, name := range ReloadableOptions {
+		reloadable[name] = true
+	}
+	names := make([]string, 0, len(p.Options))
+	for name := range p.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !reloadable[name] {
+			return fmt.Errorf("%s.options.%s: can't be changed at runtime, expected one of: %s", path, name, strings.Join(ReloadableOptions, ", "))
+		}
+		if err := validateReloadableOption(p.Options, path+".options", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OptionValues returns values of the option set by the profile. Returns an empty list if the
+// option is not set.
+func (p *TimeProfileConfig) OptionValues(name string) ([]string, error) {
+	return optionValues(p.Options, "options", name)
+}
+
+// Active checks if the given time is in one of the windows of the profile.
+func (p *TimeProfileConfig) Active(now time.Time) bool {
+	// Windows start on full minutes, so it's enough to check every minute the window
+	// containing now could have started at.
+	for start := now.Truncate(time.Minute); now.Sub(start) < p.Duration.Duration; start = start.Add(-time.Minute) {
+		if p.schedule.matches(start.In(p.location)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveTimeProfile returns the first time profile whose window contains the given time, or nil
+// if there's none.
+func (c *FileConfig) ActiveTimeProfile(now time.Time) *TimeProfileConfig {
+	if c == nil {
+		return nil
+	}
+	for i := range c.TimeProfiles {
+		if c.TimeProfiles[i].Active(now) {
+			return &c.TimeProfiles[i]
+		}
+	}
+	return nil
+}
+
+// cronSchedule is a parsed cron expression. Each field is a bitmask of matching values.
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// Like in cron, if both day fields are restricted, a day matching either of them matches.
+	dayOfMonthStar bool
+	dayOfWeekStar  bool
+}
+
+// parseCronSchedule parses a standard 5-field cron expression. Fields accept `*`, values, ranges
+// (`1-5`), steps (`*/15`, `0-30/10`) and comma-separated lists of them. Day of week is 0-7, with
+// both 0 and 7 meaning Sunday.
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q, got %d", expression, len(fields))
+	}
+	var err error
+	schedule := &cronSchedule{
+		dayOfMonthStar: fields[2] == "*",
+		dayOfWeekStar:  fields[4] == "*",
+	}
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var result uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		first, last := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			}
+			if first < min || last > max || first > last {
+				return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+			}
+		}
+		for value := first; value <= last; value += step {
+			result |= 1 << uint(value)
+		}
+	}
+	return result, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.dayOfMonthStar || s.dayOfWeekStar {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTimeProfiles = `
+timeProfiles:
+- name: night
+  schedule: "0 22 * * *"
+  duration: 8h
+  options:
+    scale-down-utilization-threshold: 0.7
+    scale-down-unneeded-time: 2m
+- name: weekend
+  schedule: "0 0 * * 6"
+  duration: 48h
+  options:
+    scale-down-utilization-threshold: 0.6
+`
+
+func TestActiveTimeProfile(t *testing.T) {
+	config, err := ParseFileConfig([]byte(testTimeProfiles))
+	assert.NoError(t, err)
+
+	// 2018-01-03 is a Wednesday.
+	for now, expected := range map[string]string{
+		"2018-01-03T12:00:00Z": "",
+		"2018-01-03T21:59:59Z": "",
+		"2018-01-03T22:00:00Z": "night",
+		"2018-01-04T05:59:59Z": "night",
+		"2018-01-04T06:00:00Z": "",
+		"2018-01-06T12:00:00Z": "weekend",
+		"2018-01-06T23:00:00Z": "night",
+		"2018-01-07T23:59:00Z": "night",
+		"2018-01-08T12:00:00Z": "",
+	} {
+		currentTime, err := time.Parse(time.RFC3339, now)
+		assert.NoError(t, err)
+		profile := config.ActiveTimeProfile(currentTime)
+		name := ""
+		if profile != nil {
+			name = profile.Name
+		}
+		assert.Equal(t, expected, name, "at %s", now)
+	}
+
+	var noConfig *FileConfig
+	assert.Nil(t, noConfig.ActiveTimeProfile(time.Now()))
+
+	profile := config.ActiveTimeProfile(time.Date(2018, 1, 3, 23, 0, 0, 0, time.UTC))
+	values, err := profile.OptionValues("scale-down-unneeded-time")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2m"}, values)
+	values, err = profile.OptionValues("scale-down-delay-after-add")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, values)
+}
+
+func TestParseCronSchedule(t *testing.T) {
+	schedule, err := parseCronSchedule("*/15 9-17 * * 1-5")
+	assert.NoError(t, err)
+	assert.True(t, schedule.matches(time.Date(2018, 1, 3, 9, 45, 0, 0, time.UTC)))
+	assert.False(t, schedule.matches(time.Date(2018, 1, 3, 9, 46, 0, 0, time.UTC)))
+	assert.False(t, schedule.matches(time.Date(2018, 1, 3, 18, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.matches(time.Date(2018, 1, 6, 9, 45, 0, 0, time.UTC)))
+
+	// Both 0 and 7 mean Sunday.
+	schedule, err = parseCronSchedule("0 0 * * 7")
+	assert.NoError(t, err)
+	assert.True(t, schedule.matches(time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC)))
+
+	// Restricted day of month and day of week match either of them.
+	schedule, err = parseCronSchedule("0 0 1,15 * 1")
+	assert.NoError(t, err)
+	assert.True(t, schedule.matches(time.Date(2018, 1, 15, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, schedule.matches(time.Date(2018, 1, 8, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, schedule.matches(time.Date(2018, 1, 9, 0, 0, 0, 0, time.UTC)))
+
+	for _, expression := range []string{"", "0 0 * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := parseCronSchedule(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestTimeProfileErrors(t *testing.T) {
+	for value, expected := range map[string]string{
+		"timeProfiles:\n- schedule: '0 22 * * *'\n  duration: 1h":                                                                 "timeProfiles[0].name: must be set",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * *'\n  duration: 1h":                                                        "timeProfiles[0].schedule",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'":                                                                      "timeProfiles[0].duration",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'\n  duration: 200h":                                                    "timeProfiles[0].duration",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'\n  duration: 1h\n  timeZone: No/Such":                                 "timeProfiles[0].timeZone",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'\n  duration: 1h\n- name: a\n  schedule: '0 6 * * *'\n  duration: 1h":  "timeProfiles[1].name: duplicate",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'\n  duration: 1h\n  options:\n    max-nodes-total: 10":                 "timeProfiles[0].options.max-nodes-total: can't be changed at runtime",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'\n  duration: 1h\n  options:\n    scale-down-utilization-threshold: 2": "timeProfiles[0].options.scale-down-utilization-threshold",
+		"timeProfiles:\n- name: a\n  schedule: '0 22 * * *'\n  duration: 1h\n  options:\n    scale-down-unneeded-time: soon":      "timeProfiles[0].options.scale-down-unneeded-time",
+	} {
+		_, err := ParseFileConfig([]byte(value))
+		if assert.Error(t, err, value) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+}
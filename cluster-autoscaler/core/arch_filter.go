@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+
+	apiv1 "k8s.io/api/core/v1"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// archLabels are the node labels holding the arch, the beta one first.
+var archLabels = []string{kubeletapis.LabelArch, "kubernetes.io/arch"}
+
+// dropArchConflictingOptions drops expansion options of node groups whose template node has an arch
+// none of the pods of the option can run on. Predicates should already keep such pods away, so this
+// is a sanity check against templates with a wrong arch label.
+func dropArchConflictingOptions(options []expander.Option, nodeInfos map[string]*schedulercache.NodeInfo) []expander.Option {
+	result := make([]expander.Option, 0, len(options))
+	for _, option := range options {
+		nodeInfo, found := nodeInfos[option.NodeGroup.Id()]
+		if !found || nodeInfo.Node() == nil {
+			result = append(result, option)
+			continue
+		}
+		arch := getNodeArch(nodeInfo.Node())
+		if arch == "" || anyPodRunsOnArch(option.Pods, arch) {
+			result = append(result, option)
+			continue
+		}
+		glog.V(2).Infof("Skipping node group %s - none of the pods runs on %s nodes", option.NodeGroup.Id(), arch)
+	}
+	return result
+}
+
+func getNodeArch(node *apiv1.Node) string {
+	for _, label := range archLabels {
+		if arch, found := node.Labels[label]; found {
+			return arch
+		}
+	}
+	return ""
+}
+
+func anyPodRunsOnArch(pods []*apiv1.Pod, arch string) bool {
+	for _, pod := range pods {
+		archs, required := getRequiredArchs(pod)
+		if !required || archs[arch] {
+			return true
+		}
+	}
+	return false
+}
+
+// getRequiredArchs returns the archs allowed by the node selector and the required node affinity of
+// the pod. The second value is false if the pod doesn't require any arch.
+func getRequiredArchs(pod *apiv1.Pod) (map[string]bool, bool) {
+	for _, label := range archLabels {
+		if arch, found := pod.Spec.NodeSelector[label]; found {
+			return map[string]bool{arch: true}, true
+		}
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil, false
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return nil, false
+	}
+	// Terms are ORed, so the pod requires an arch only if each of the terms does.
+	archs := make(map[string]bool)
+	for _, term := range terms {
+		termRequiresArch := false
+		for _, requirement := range term.MatchExpressions {
+			if requirement.Operator != apiv1.NodeSelectorOpIn || !isArchLabel(requirement.Key) {
+				continue
+			}
+			termRequiresArch = true
+			for _, value := range requirement.Values {
+				archs[value] = true
+			}
+		}
+		if !termRequiresArch {
+			return nil, false
+		}
+	}
+	return archs, true
+}
+
+func isArchLabel(key string) bool {
+	for _, label := range archLabels {
+		if key == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildArchAffinityPod(name string, archs ...string) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 0)
+	pod.Spec.Affinity = &apiv1.Affinity{
+		NodeAffinity: &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{{
+					MatchExpressions: []apiv1.NodeSelectorRequirement{{
+						Key:      kubeletapis.LabelArch,
+						Operator: apiv1.NodeSelectorOpIn,
+						Values:   archs,
+					}},
+				}},
+			},
+		},
+	}
+	return pod
+}
+
+func TestDropArchConflictingOptions(t *testing.T) {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	nodeInfos := make(map[string]*schedulercache.NodeInfo)
+	for nodeGroup, arch := range map[string]string{"amd": "amd64", "arm": "arm64", "unknown": ""} {
+		provider.AddNodeGroup(nodeGroup, 0, 10, 0)
+		node := BuildTestNode(nodeGroup+"-template", 1000, 1000)
+		if arch != "" {
+			node.Labels[kubeletapis.LabelArch] = arch
+		}
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(node)
+		nodeInfos[nodeGroup] = nodeInfo
+	}
+
+	anyArch := BuildTestPod("any-arch", 100, 0)
+	armSelector := BuildTestPod("arm-selector", 100, 0)
+	armSelector.Spec.NodeSelector = map[string]string{"kubernetes.io/arch": "arm64"}
+	armAffinity := buildArchAffinityPod("arm-affinity", "arm64")
+	multiArch := buildArchAffinityPod("multi-arch", "amd64", "arm64")
+
+	option := func(nodeGroup string, pods ...*apiv1.Pod) expander.Option {
+		return expander.Option{NodeGroup: getTestNodeGroup(provider, nodeGroup), Pods: pods}
+	}
+	amdAny, armAny, unknownArm := option("amd", anyArch), option("arm", anyArch), option("unknown", armSelector)
+	amdArm, armArm := option("amd", armSelector, armAffinity), option("arm", armSelector, armAffinity)
+	amdMixed, amdMulti := option("amd", armAffinity, anyArch), option("amd", multiArch)
+
+	assert.Equal(t, []expander.Option{amdAny, armAny, unknownArm}, dropArchConflictingOptions([]expander.Option{amdAny, armAny, unknownArm}, nodeInfos))
+	assert.Equal(t, []expander.Option{armArm}, dropArchConflictingOptions([]expander.Option{amdArm, armArm}, nodeInfos))
+	assert.Equal(t, []expander.Option{amdMixed, amdMulti}, dropArchConflictingOptions([]expander.Option{amdMixed, amdMulti}, nodeInfos))
+}
+
+func TestScaleUpArch(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	nodes := make([]*apiv1.Node, 0)
+	for nodeGroup, arch := range map[string]string{"amd": "amd64", "arm": "arm64"} {
+		node := BuildTestNode(nodeGroup+"-1", 1000, 1000)
+		node.Labels[kubeletapis.LabelArch] = arch
+		SetNodeReadyState(node, true, time.Now())
+		provider.AddNodeGroup(nodeGroup, 1, 10, 1)
+		provider.AddNode(nodeGroup, node)
+		nodes = append(nodes, node)
+	}
+
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             kube_record.NewFakeRecorder(10),
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	scaledUp, typedErr := ScaleUp(context, []*apiv1.Pod{buildArchAffinityPod("p-new", "arm64")}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, typedErr)
+	assert.True(t, scaledUp)
+	assert.Equal(t, "arm-1", getStringFromChan(sizeChanges))
+}
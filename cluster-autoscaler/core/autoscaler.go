@@ -46,6 +46,9 @@ type Autoscaler interface {
 	CloudProvider() cloudprovider.CloudProvider
 	// ExitCleanUp is a clean-up performed just before process termination.
 	ExitCleanUp()
+	// LastLoopActive checks if the last invocation of RunOnce had pending pods to help, or
+	// scale-up or scale-down in progress.
+	LastLoopActive() bool
 }
 
 // NewAutoscaler creates an autoscaler of an appropriate type according to the parameters
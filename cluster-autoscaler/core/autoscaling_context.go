@@ -17,17 +17,21 @@ limitations under the License.
 package core
 
 import (
+	"os"
 	"time"
 
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider/builder"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
 	"k8s.io/autoscaler/cluster-autoscaler/expander/factory"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
 	kube_client "k8s.io/client-go/kubernetes"
 	kube_record "k8s.io/client-go/tools/record"
 )
@@ -51,20 +55,78 @@ type AutoscalingContext struct {
 	ExpanderStrategy expander.Strategy
 	// LogRecorder can be used to collect log messages to expose via Events on some central object.
 	LogRecorder *utils.LogEventRecorder
+	// ScaleUpRateLimiter limits how fast nodes are added to the cluster.
+	ScaleUpRateLimiter *ScaleUpRateLimiter
+	// ScaleUpLimitEventLimiter limits events about pods blocked by cluster-wide limits.
+	ScaleUpLimitEventLimiter *ScaleUpLimitEventLimiter
+	// TemplateNodeInfoCache caches template node infos built by the cloud provider.
+	TemplateNodeInfoCache *TemplateNodeInfoCache
+	// NodeAllocatableTracker finds nodes whose allocatable changed.
+	NodeAllocatableTracker *NodeAllocatableTracker
+	// DecisionRecorder stores expander decisions for offline replay. Nil if recording is disabled.
+	DecisionRecorder debug.DecisionRecorder
+	// HeadroomSpecs are parsed from Headroom option.
+	HeadroomSpecs []*config.HeadroomSpec
+	// CapacityReservationSource provides capacity reserved for namespaces. Nil if reservations are disabled.
+	CapacityReservationSource CapacityReservationSource
+	// NodeGroupConfigProcessor provides options of individual node groups and reloads the configuration file.
+	NodeGroupConfigProcessor *NodeGroupConfigProcessor
+	// PodScaleUpDelayFilter holds back pending pods too new to trigger a scale-up.
+	PodScaleUpDelayFilter *PodScaleUpDelayFilter
+	// UnschedulablePodLimiter caps the number of unschedulable pods considered in a loop. Nil if there's no limit.
+	UnschedulablePodLimiter *UnschedulablePodLimiter
+	// PodEquivalenceCache keeps equivalence hashes of pending pods between loops.
+	PodEquivalenceCache *PodEquivalenceCache
+	// OversizedPodsCache remembers pending pods that don't fit any node group. Nil if disabled.
+	OversizedPodsCache *OversizedPodsCache
+	// NamespaceScaleUpOptOutFilter removes pending pods of namespaces opted out of scale-up. Nil if disabled.
+	NamespaceScaleUpOptOutFilter *NamespaceScaleUpOptOutFilter
+	// EstimatorCapacityMargin is subtracted from allocatable of template nodes in scale-up estimation. Nil if there's no margin.
+	EstimatorCapacityMargin *config.CapacityMargin
+	// EstimateShortfallTracker finds scale-ups that added fewer nodes than needed.
+	EstimateShortfallTracker *EstimateShortfallTracker
+	// DryRunReport collects the actions of a dry-run loop run with RunOnceWithDryRunReport. Nil otherwise.
+	DryRunReport *DryRunReport
+	// Self identifies the pod and the node of CA. Empty if CA runs outside of the cluster.
+	Self SelfIdentity
+	// SplittingStrategy splits new nodes between similar node groups. Nil means an even split.
+	SplittingStrategy nodegroupset.SplittingStrategy
 }
 
 // AutoscalingOptions contain various options to customize how autoscaling works
 type AutoscalingOptions struct {
 	// MaxEmptyBulkDelete is a number of empty nodes that can be removed at the same time.
 	MaxEmptyBulkDelete int
+	// MaxDrainParallelismPerZone is the maximum number of nodes in a zone that can be removed at
+	// the same time. 0 means no limit.
+	MaxDrainParallelismPerZone int
+	// MaxDrainParallelismPerNodeGroup is the maximum number of nodes of a node group that can be
+	// removed at the same time, unless overridden for the node group. 0 means no limit.
+	MaxDrainParallelismPerNodeGroup int
 	// ScaleDownUtilizationThreshold sets threshold for nodes to be considered for scale down.
 	// Well-utilized nodes are not touched.
 	ScaleDownUtilizationThreshold float64
+	// ScaleDownGpuUtilization makes CA measure utilization of GPU nodes by their GPUs only.
+	ScaleDownGpuUtilization bool
 	// ScaleDownUnneededTime sets the duration CA expects a node to be unneeded/eligible for removal
 	// before scaling down the node.
 	ScaleDownUnneededTime time.Duration
 	// ScaleDownUnreadyTime represents how long an unready node should be unneeded before it is eligible for scale down
 	ScaleDownUnreadyTime time.Duration
+	// EnforceNodeGroupMaxSize makes CA remove nodes from node groups above their max size without
+	// waiting for the nodes to be unneeded.
+	EnforceNodeGroupMaxSize bool
+	// ScaleDownOrphanNodes makes CA remove empty nodes that don't belong to any node group and match
+	// OrphanNodesSelector, once they have been empty for ScaleDownUnneededTime.
+	ScaleDownOrphanNodes bool
+	// OrphanNodesSelector is the label selector of orphan nodes CA may remove.
+	OrphanNodesSelector string
+	// CompactionEvictionsPerHour is the maximum number of pods CA evicts per hour from underutilized
+	// nodes that can't be removed, to make other nodes removable. 0 disables compaction.
+	CompactionEvictionsPerHour int
+	// CompactionWorkloadCooldown is how long pods of a workload evicted by compaction are not evicted
+	// by compaction again.
+	CompactionWorkloadCooldown time.Duration
 	// MaxNodesTotal sets the maximum number of nodes in the whole cluster
 	MaxNodesTotal int
 	// MaxCoresTotal sets the maximum number of cores in the whole cluster
@@ -79,15 +141,51 @@ type AutoscalingOptions struct {
 	NodeGroupAutoDiscovery string
 	// UnregisteredNodeRemovalTime represents how long CA waits before removing nodes that are not registered in Kubernetes")
 	UnregisteredNodeRemovalTime time.Duration
+	// ReconcileTargetSize decreases target sizes of node groups that stay above their numbers of
+	// instances with no scale-up in progress for longer than the provision timeout.
+	ReconcileTargetSize bool
+	// DeletedInstanceNodeRemovalTime is how long CA waits before removing nodes whose instances no
+	// longer exist on the cloud provider side. 0 disables the removal.
+	DeletedInstanceNodeRemovalTime time.Duration
+	// ToBeDeletedTaintTTL is the age after which ToBeDeleted taints are removed from nodes when no
+	// scale-down is in progress. 0 disables the removal.
+	ToBeDeletedTaintTTL time.Duration
 	// EstimatorName is the estimator used to estimate the number of needed nodes in scale up.
 	EstimatorName string
+	// ScoringStrategy decides which of the nodes a pod fits on is chosen in scale-up estimation
+	// and drain simulation. It should match the resource priority of the scheduler.
+	ScoringStrategy simulator.ScoringStrategy
 	// ExpanderName sets the type of node group expander to be used in scale up
 	ExpanderName string
-	// MaxGracefulTerminationSec is maximum number of seconds scale down waits for pods to terminate before
-	// removing the node from cloud provider.
+	// ExpanderPriceStabilityMargin is the relative price advantage an option needs for the price expander
+	// to stop choosing the node group it chose before for the same pods. 0 disables this.
+	ExpanderPriceStabilityMargin float64
+	// ExpanderPriceStabilityDuration is how long the price expander remembers its choice for the same pods.
+	ExpanderPriceStabilityDuration time.Duration
+	// ExpanderPriceMinFitEfficiency is the lowest fraction of cpu or memory of the added nodes that pods
+	// must request for the price expander to consider an option. 0 disables filtering.
+	ExpanderPriceMinFitEfficiency float64
+	// MaxGracefulTerminationSec is maximum number of seconds scale down waits for each pod to terminate before
+	// removing the node from cloud provider. Pods can override it with PodDrainTimeoutAnnotationKey.
 	MaxGracefulTerminationSec int
+	// MaxNodeDrainTime is maximum time scale down waits for all pods on a node to terminate, 0 means no limit.
+	MaxNodeDrainTime time.Duration
+	// EvictionDeleteFallback means pods annotated with cluster-autoscaler.kubernetes.io/allow-force-delete=true
+	// are deleted when an admission webhook denies their eviction, unless a PodDisruptionBudget covers them.
+	EvictionDeleteFallback bool
 	//  Maximum time CA waits for node to be provisioned
 	MaxNodeProvisionTime time.Duration
+	// AdaptiveProvisionTimeout makes CA wait for nodes of a node group ProvisionTimeoutFactor times
+	// the p95 of its recent provision times, instead of MaxNodeProvisionTime.
+	AdaptiveProvisionTimeout bool
+	// ProvisionTimeoutFactor multiplies the p95 provision time of a node group to get its timeout.
+	ProvisionTimeoutFactor float64
+	// MinProvisionTimeout and MaxProvisionTimeout bound adaptive provision timeouts.
+	MinProvisionTimeout time.Duration
+	MaxProvisionTimeout time.Duration
+	// StockoutMemoryTTL is how long scale-up avoids node groups with the instance type and zone of
+	// a node group whose scale-up ran out of resources. 0 disables this.
+	StockoutMemoryTTL time.Duration
 	// MaxTotalUnreadyPercentage is the maximum percentage of unready nodes after which CA halts operations
 	MaxTotalUnreadyPercentage float64
 	// OkTotalUnreadyCount is the number of allowed unready nodes, irrespective of max-total-unready-percentage
@@ -106,6 +204,10 @@ type AutoscalingOptions struct {
 	ScaleDownDelayAfterDelete time.Duration
 	// ScaleDownDelayAfterFailure sets the duration before the next scale down attempt if scale down results in an error
 	ScaleDownDelayAfterFailure time.Duration
+	// ScaleDownDelayPerNodeGroup limits ScaleDownDelayAfterAdd to the node groups that were scaled up
+	ScaleDownDelayPerNodeGroup bool
+	// BypassScaleDownDelayForEmpty allows removal of empty nodes during ScaleDownDelayAfterAdd and ScaleDownDelayAfterFailure
+	BypassScaleDownDelayForEmpty bool
 	// ScaleDownNonEmptyCandidatesCount is the maximum number of non empty nodes
 	// considered at once as candidates for scale down.
 	ScaleDownNonEmptyCandidatesCount int
@@ -119,10 +221,17 @@ type AutoscalingOptions struct {
 	// The formula to calculate additional candidates number is following:
 	// max(#nodes * ScaleDownCandidatesPoolRatio, ScaleDownCandidatesPoolMinCount)
 	ScaleDownCandidatesPoolMinCount int
+	// ScaleDownCandidatePriorityWeight multiplies the highest priority of pods on a node into its
+	// priority score. Among unneeded nodes with equal utilization, the ones with lower scores are
+	// removed first. 0 disables the scores.
+	ScaleDownCandidatePriorityWeight float64
 	// WriteStatusConfigMap tells if the status information should be written to a ConfigMap
 	WriteStatusConfigMap bool
 	// BalanceSimilarNodeGroups enables logic that identifies node groups with similar machines and tries to balance node count between them.
 	BalanceSimilarNodeGroups bool
+	// NodeGroupSplitStrategy is the name of the strategy splitting new nodes between similar node groups.
+	// Empty means an even split.
+	NodeGroupSplitStrategy string
 	// ConfigNamespace is the namespace cluster-autoscaler is running in and all related configmaps live in
 	ConfigNamespace string
 	// ClusterName if available
@@ -131,9 +240,90 @@ type AutoscalingOptions struct {
 	NodeAutoprovisioningEnabled bool
 	// MaxAutoprovisionedNodeGroupCount is the maximum number of autoprovisioned groups in the cluster.
 	MaxAutoprovisionedNodeGroupCount int
+	// EmptyAutoprovisionedGroupTTL is how long an autoprovisioned node group has to be empty before
+	// it is deleted. 0 means it is deleted as soon as it's empty.
+	EmptyAutoprovisionedGroupTTL time.Duration
 	// Pods with priority below cutoff are expendable. They can be killed without any consideration during scale down and they don't cause scale up.
 	// Pods with null priority (PodPriority disabled) are non expendable.
 	ExpendablePodsPriorityCutoff int
+	// ScaleDownSimulationSliceSize is the maximum number of non-empty unneeded nodes for which scale-down
+	// is simulated in a single loop. Other nodes keep their state from previous loops. 0 means no limit.
+	ScaleDownSimulationSliceSize int
+	// MaxNodesPerMinute is the maximum number of nodes that can be added to the whole cluster per minute.
+	// 0 means no limit.
+	MaxNodesPerMinute int
+	// MaxNodesPerMinutePerNodeGroup is the maximum number of nodes that can be added to a single
+	// node group per minute, unless overridden for the node group. 0 means no limit.
+	MaxNodesPerMinutePerNodeGroup int
+	// ScaleDownSimulateUpcomingNodes makes scale-down simulation take nodes from scale-ups in progress into
+	// account. Nodes whose pods fit only on such nodes are not removed until the new nodes register.
+	ScaleDownSimulateUpcomingNodes bool
+	// Headroom contains specs of spare capacity kept in the cluster on top of what pods need,
+	// in a format accepted by config.HeadroomSpecFromString.
+	Headroom []string
+	// EstimatorCapacityMargin is subtracted from allocatable of template nodes when estimating the number
+	// of nodes needed in scale-up, in a format accepted by config.CapacityMarginFromString. Empty for no margin.
+	EstimatorCapacityMargin string
+	// CapacityReservationsEnabled makes CA keep capacity reserved for namespaces in the
+	// CapacityReservationsConfigMapName ConfigMap.
+	CapacityReservationsEnabled bool
+	// ConsiderPreemptionInScaleUp makes scale-up ignore pending pods that can be scheduled by preempting
+	// lower priority pods, and help the pods they would preempt instead.
+	ConsiderPreemptionInScaleUp bool
+	// ScaleUpPrefilterEnabled makes scale-up rule out node groups with PredicateChecker.CheckStaticPredicates
+	// before running all predicates for a pod.
+	ScaleUpPrefilterEnabled bool
+	// ForceNodeGroupAnnotationEnabled allows pending pods to request expansion of a specific node group
+	// with ForceNodeGroupAnnotationKey annotation, bypassing the expander and node group backoff.
+	ForceNodeGroupAnnotationEnabled bool
+	// TemplateNodeInfoCacheTTL is the maximum time a template node info built by the cloud provider is reused
+	// for node groups that report template changes. 0 disables caching.
+	TemplateNodeInfoCacheTTL time.Duration
+	// IgnoredTaints are keys of taints removed from template nodes, in addition to transient taints.
+	IgnoredTaints []string
+	// RecordDecisionsDir is a directory where scale-up decisions are written for offline replay.
+	// Empty means decisions are not recorded.
+	RecordDecisionsDir string
+	// RecordPackingTrace adds pod to simulated node assignments made by the binpacking estimator
+	// to recorded scale-up decisions.
+	RecordPackingTrace bool
+	// DryRun makes CA run the whole loop without changing the cluster. Actions that would be taken are
+	// only reported via events, metrics and status.
+	DryRun bool
+	// ConfigFile is the path to the configuration file with options and node group overrides. Empty string
+	// for no configuration file.
+	ConfigFile string
+	// CommandLineFlags are names of flags set on the command line. They take precedence over the
+	// configuration file, also when it's reloaded.
+	CommandLineFlags map[string]bool
+	// NewPodScaleUpDelay is the minimum age of a pending pod before it can trigger a scale-up.
+	NewPodScaleUpDelay time.Duration
+	// NewPodScaleUpDelayPerNamespace overrides NewPodScaleUpDelay for namespaces, in <namespace>=<duration> format.
+	NewPodScaleUpDelayPerNamespace []string
+	// AdaptivePodScaleUpDelay is added to the scale-up delay of pods of controllers whose previous pods
+	// terminated within FastPodFailureThreshold of starting. 0 disables the adaptive delay.
+	AdaptivePodScaleUpDelay time.Duration
+	// MaxUnschedulablePodsConsidered is the maximum number of unschedulable pods considered in a loop,
+	// the rest is deferred to next loops. 0 means no limit.
+	MaxUnschedulablePodsConsidered int
+	// OversizedPodsRecheckInterval is how often pending pods requesting more than the template node of
+	// any node group has are checked again. 0 disables caching them.
+	OversizedPodsRecheckInterval time.Duration
+	// ScaleUpOptOutNamespaceSelector is a label selector of namespaces whose pending pods don't trigger
+	// scale-up. Empty string disables the opt-out.
+	ScaleUpOptOutNamespaceSelector string
+	// FastPodFailureThreshold is the time after starting within which a terminated pod counts as a fast failure.
+	FastPodFailureThreshold time.Duration
+	// Version is the version of the CA binary, reported in build info.
+	Version string
+	// GitCommit is the commit the CA binary was built from, reported in build info.
+	GitCommit string
+	// CrashReporter keeps the latest state of the main loop to dump it if CA crashes. It outlives
+	// autoscaler rebuilds, so it's passed with options. Nil if crash dumps are disabled.
+	CrashReporter *debug.CrashReporter
+	// CapacityForecaster answers forecast requests based on the state seen by the main loop. It outlives
+	// autoscaler rebuilds like CrashReporter. Nil if the forecast endpoint is disabled.
+	CapacityForecaster *CapacityForecaster
 }
 
 // NewAutoscalingContext returns an autoscaling context from all the necessary parameters passed via arguments
@@ -149,7 +339,8 @@ func NewAutoscalingContext(options AutoscalingOptions, predicateChecker *simulat
 			map[string]int64{cloudprovider.ResourceNameCores: int64(options.MinCoresTotal), cloudprovider.ResourceNameMemory: options.MinMemoryTotal},
 			map[string]int64{cloudprovider.ResourceNameCores: options.MaxCoresTotal, cloudprovider.ResourceNameMemory: options.MaxMemoryTotal}))
 	expanderStrategy, err := factory.ExpanderStrategyFromString(options.ExpanderName,
-		cloudProvider, listerRegistry.AllNodeLister())
+		cloudProvider, listerRegistry.AllNodeLister(), options.ExpanderPriceStabilityMargin, options.ExpanderPriceStabilityDuration,
+		options.ExpanderPriceMinFitEfficiency)
 	if err != nil {
 		return nil, err
 	}
@@ -158,18 +349,95 @@ func NewAutoscalingContext(options AutoscalingOptions, predicateChecker *simulat
 		MaxTotalUnreadyPercentage: options.MaxTotalUnreadyPercentage,
 		OkTotalUnreadyCount:       options.OkTotalUnreadyCount,
 		MaxNodeProvisionTime:      options.MaxNodeProvisionTime,
+		AdaptiveProvisionTimeout:  options.AdaptiveProvisionTimeout,
+		ProvisionTimeoutFactor:    options.ProvisionTimeoutFactor,
+		MinProvisionTimeout:       options.MinProvisionTimeout,
+		MaxProvisionTimeout:       options.MaxProvisionTimeout,
+		StockoutMemoryTTL:         options.StockoutMemoryTTL,
 	}
 	clusterStateRegistry := clusterstate.NewClusterStateRegistry(cloudProvider, clusterStateConfig, logEventRecorder)
 
+	headroomSpecs := make([]*config.HeadroomSpec, 0, len(options.Headroom))
+	for _, value := range options.Headroom {
+		spec, specErr := config.HeadroomSpecFromString(value)
+		if specErr != nil {
+			return nil, errors.ToAutoscalerError(errors.InternalError, specErr)
+		}
+		headroomSpecs = append(headroomSpecs, spec)
+	}
+
+	var capacityMargin *config.CapacityMargin
+	if options.EstimatorCapacityMargin != "" {
+		var marginErr error
+		capacityMargin, marginErr = config.CapacityMarginFromString(options.EstimatorCapacityMargin)
+		if marginErr != nil {
+			return nil, errors.ToAutoscalerError(errors.InternalError, marginErr)
+		}
+	}
+
+	var decisionRecorder debug.DecisionRecorder
+	if options.RecordDecisionsDir != "" {
+		var recorderErr error
+		decisionRecorder, recorderErr = debug.NewDirectoryDecisionRecorder(options.RecordDecisionsDir)
+		if recorderErr != nil {
+			return nil, errors.ToAutoscalerError(errors.InternalError, recorderErr)
+		}
+	}
+
+	nodeGroupConfigProcessor, configErr := NewNodeGroupConfigProcessor(options.ConfigFile, options.CommandLineFlags)
+	if configErr != nil {
+		return nil, errors.ToAutoscalerError(errors.InternalError, configErr)
+	}
+
+	podScaleUpDelayFilter, delayErr := NewPodScaleUpDelayFilter(options)
+	if delayErr != nil {
+		return nil, errors.ToAutoscalerError(errors.InternalError, delayErr)
+	}
+
+	namespaceScaleUpOptOutFilter, optOutErr := NewNamespaceScaleUpOptOutFilter(options, kubeClient, kubeEventRecorder)
+	if optOutErr != nil {
+		return nil, errors.ToAutoscalerError(errors.InternalError, optOutErr)
+	}
+
+	var capacityReservationSource CapacityReservationSource
+	if options.CapacityReservationsEnabled {
+		capacityReservationSource = NewConfigMapCapacityReservationSource(kubeClient, options.ConfigNamespace)
+	}
+
 	autoscalingContext := AutoscalingContext{
-		AutoscalingOptions:   options,
-		CloudProvider:        cloudProvider,
-		ClusterStateRegistry: clusterStateRegistry,
-		ClientSet:            kubeClient,
-		Recorder:             kubeEventRecorder,
-		PredicateChecker:     predicateChecker,
-		ExpanderStrategy:     expanderStrategy,
-		LogRecorder:          logEventRecorder,
+		AutoscalingOptions:           options,
+		CloudProvider:                cloudProvider,
+		ClusterStateRegistry:         clusterStateRegistry,
+		ClientSet:                    kubeClient,
+		Recorder:                     kubeEventRecorder,
+		PredicateChecker:             predicateChecker,
+		ExpanderStrategy:             expanderStrategy,
+		LogRecorder:                  logEventRecorder,
+		ScaleUpRateLimiter:           NewScaleUpRateLimiter(options.MaxNodesPerMinute),
+		ScaleUpLimitEventLimiter:     NewScaleUpLimitEventLimiter(),
+		TemplateNodeInfoCache:        NewTemplateNodeInfoCache(options.TemplateNodeInfoCacheTTL),
+		NodeAllocatableTracker:       NewNodeAllocatableTracker(),
+		DecisionRecorder:             decisionRecorder,
+		HeadroomSpecs:                headroomSpecs,
+		CapacityReservationSource:    capacityReservationSource,
+		EstimatorCapacityMargin:      capacityMargin,
+		EstimateShortfallTracker:     NewEstimateShortfallTracker(),
+		NodeGroupConfigProcessor:     nodeGroupConfigProcessor,
+		PodScaleUpDelayFilter:        podScaleUpDelayFilter,
+		NamespaceScaleUpOptOutFilter: namespaceScaleUpOptOutFilter,
+		UnschedulablePodLimiter:      NewUnschedulablePodLimiter(options.MaxUnschedulablePodsConsidered),
+		PodEquivalenceCache:          NewPodEquivalenceCache(),
+		OversizedPodsCache:           NewOversizedPodsCache(options.OversizedPodsRecheckInterval),
+		Self:                         GetSelfIdentity(kubeClient, os.Getenv, options.ConfigNamespace),
+	}
+	if options.NodeGroupSplitStrategy != "" {
+		splittingStrategy, splitErr := nodegroupset.NewSplittingStrategy(options.NodeGroupSplitStrategy, func(nodeGroup cloudprovider.NodeGroup) int {
+			return autoscalingContext.NodeGroupConfigProcessor.GetOptions(&autoscalingContext, nodeGroup).SplitWeight
+		})
+		if splitErr != nil {
+			return nil, errors.ToAutoscalerError(errors.InternalError, splitErr)
+		}
+		autoscalingContext.SplittingStrategy = splittingStrategy
 	}
 
 	return &autoscalingContext, nil
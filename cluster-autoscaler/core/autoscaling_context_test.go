@@ -22,7 +22,9 @@ import (
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
 
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/kubernetes/fake"
@@ -36,11 +38,12 @@ func TestNewAutoscalingContext(t *testing.T) {
 
 	autoscalingContext, err := NewAutoscalingContext(
 		AutoscalingOptions{
-			ExpanderName:   expander.RandomExpanderName,
-			MaxCoresTotal:  10,
-			MinCoresTotal:  1,
-			MaxMemoryTotal: 10000000000,
-			MinMemoryTotal: 1000000000,
+			ExpanderName:           expander.RandomExpanderName,
+			NodeGroupSplitStrategy: nodegroupset.EvenSplittingStrategyName,
+			MaxCoresTotal:          10,
+			MinCoresTotal:          1,
+			MaxMemoryTotal:         10000000000,
+			MinMemoryTotal:         1000000000,
 		},
 		simulator.NewTestPredicateChecker(),
 		fakeClient, fakeRecorder,
@@ -48,3 +51,21 @@ func TestNewAutoscalingContext(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, autoscalingContext)
 }
+
+func TestNewAutoscalingContextSplittingStrategy(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	newContext := func(strategy string) (*AutoscalingContext, errors.AutoscalerError) {
+		return NewAutoscalingContext(
+			AutoscalingOptions{ExpanderName: expander.RandomExpanderName, NodeGroupSplitStrategy: strategy},
+			simulator.NewTestPredicateChecker(), fakeClient, kube_record.NewFakeRecorder(5),
+			fakeLogRecorder, kube_util.NewListerRegistry(nil, nil, nil, nil, nil, nil))
+	}
+
+	autoscalingContext, err := newContext(nodegroupset.PrioritySplittingStrategyName)
+	assert.NoError(t, err)
+	assert.IsType(t, nodegroupset.WeightedSplittingStrategy{}, autoscalingContext.SplittingStrategy)
+
+	_, err = newContext("cheapest")
+	assert.Error(t, err)
+}
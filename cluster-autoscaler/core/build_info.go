@@ -0,0 +1,66 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	"github.com/golang/glog"
+)
+
+// AutoscalingOptionsHash returns a stable hash of the options. Build information and components
+// passed with the options, like the crash reporter, don't affect it.
+func AutoscalingOptionsHash(options AutoscalingOptions) string {
+	options.Version = ""
+	options.GitCommit = ""
+	options.CrashReporter = nil
+	options.CapacityForecaster = nil
+	// Maps are encoded with sorted keys, so the encoding only depends on option values.
+	data, err := json.Marshal(options)
+	if err != nil {
+		glog.Errorf("Failed to encode autoscaling options: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// updateBuildInfo reports the build and the hash of the options in use in the build info metric
+// and status. The hash is recomputed in every loop, as options can be reloaded at runtime.
+func (a *StaticAutoscaler) updateBuildInfo() {
+	hash := AutoscalingOptionsHash(a.AutoscalingOptions)
+	if hash == a.optionsHash {
+		return
+	}
+	if a.optionsHash != "" {
+		glog.V(1).Infof("Autoscaling options changed, hash %s", hash)
+	}
+	a.optionsHash = hash
+	info := api.BuildInfo{
+		Version:       a.Version,
+		GitCommit:     a.GitCommit,
+		CloudProvider: a.AutoscalingContext.CloudProvider.Name(),
+		OptionsHash:   hash,
+	}
+	metrics.UpdateBuildInfo(info.Version, info.GitCommit, info.CloudProvider, info.OptionsHash)
+	a.ClusterStateRegistry.SetBuildInfo(info)
+}
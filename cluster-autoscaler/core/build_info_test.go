@@ -0,0 +1,91 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoscalingOptionsHash(t *testing.T) {
+	options := AutoscalingOptions{
+		ScaleDownUnneededTime: 10 * time.Minute,
+		NodeGroups:            []string{"1:10:ng1"},
+		CommandLineFlags:      map[string]bool{"a": true, "b": true, "c": true},
+	}
+	hash := AutoscalingOptionsHash(options)
+	assert.NotEmpty(t, hash)
+
+	same := options
+	same.CommandLineFlags = map[string]bool{"c": true, "a": true, "b": true}
+	same.Version = "1.0.1"
+	same.GitCommit = "abc123"
+	same.CrashReporter = &debug.CrashReporter{}
+	assert.Equal(t, hash, AutoscalingOptionsHash(same))
+
+	changed := options
+	changed.ScaleDownUnneededTime = 5 * time.Minute
+	assert.NotEqual(t, hash, AutoscalingOptionsHash(changed))
+
+	changed = options
+	changed.NodeGroups = []string{"1:11:ng1"}
+	assert.NotEqual(t, hash, AutoscalingOptionsHash(changed))
+}
+
+func TestUpdateBuildInfoOnReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-info")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("options:\n  scale-down-unneeded-time: 10m\n"), 0644))
+
+	processor, err := NewNodeGroupConfigProcessor(path, nil)
+	assert.NoError(t, err)
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	autoscaler := &StaticAutoscaler{
+		AutoscalingContext: &AutoscalingContext{
+			AutoscalingOptions:       AutoscalingOptions{Version: "1.0.0", GitCommit: "abc123"},
+			CloudProvider:            provider,
+			ClusterStateRegistry:     clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, nil),
+			NodeGroupConfigProcessor: processor,
+		},
+	}
+
+	reload := func() string {
+		autoscaler.NodeGroupConfigProcessor.Reload(&autoscaler.AutoscalingOptions, time.Now())
+		autoscaler.updateBuildInfo()
+		info := autoscaler.ClusterStateRegistry.GetStatus(time.Now()).BuildInfo
+		assert.Equal(t, "1.0.0", info.Version)
+		assert.Equal(t, "abc123", info.GitCommit)
+		assert.Equal(t, "TestCloudProvider", info.CloudProvider)
+		return info.OptionsHash
+	}
+	hash := reload()
+	assert.Equal(t, hash, reload())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("options:\n  scale-down-unneeded-time: 5m\n"), 0644))
+	assert.NotEqual(t, hash, reload())
+}
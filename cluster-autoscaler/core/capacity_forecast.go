@@ -0,0 +1,304 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// maxForecastPods is the maximum number of pods in a single forecast request.
+	maxForecastPods = 1000
+	// maxForecastRequestBytes is the maximum size of a forecast request body.
+	maxForecastRequestBytes = 4 << 20
+)
+
+// Reasons why a node group can't provision nodes for all pods of a forecast request.
+const (
+	// ForecastNoNodeTemplate means there is no template node to simulate new nodes of the node group.
+	ForecastNoNodeTemplate = "NoNodeTemplate"
+	// ForecastNotSafeToScaleUp means the node group is backed off or unhealthy.
+	ForecastNotSafeToScaleUp = "NotSafeToScaleUp"
+	// ForecastPodsDontFit means some of the pods don't fit on a new node of the node group.
+	ForecastPodsDontFit = "PodsDontFit"
+	// ForecastMaxSizeReached means the node group can't grow by the estimated number of nodes.
+	ForecastMaxSizeReached = "MaxSizeReached"
+	// ForecastNotEvaluated means the forecast ran out of time before getting to the node group.
+	ForecastNotEvaluated = "NotEvaluated"
+)
+
+// CapacityForecastRequest is the body of a forecast request.
+type CapacityForecastRequest struct {
+	// Pods are the pods that would be submitted. Only the namespace, labels and spec are used.
+	Pods []apiv1.Pod `json:"pods"`
+}
+
+// NodeGroupForecast describes whether a node group could provision nodes for the pods.
+type NodeGroupForecast struct {
+	NodeGroup string `json:"nodeGroup"`
+	// Feasible is set if the node group alone can provision nodes for all pods not fitting on existing nodes.
+	Feasible bool   `json:"feasible"`
+	Reason   string `json:"reason,omitempty"`
+	// FittingPods is the number of pods that fit on a new node of the node group.
+	FittingPods int `json:"fittingPods"`
+	// EstimatedNodes is the number of new nodes needed for the fitting pods.
+	EstimatedNodes int `json:"estimatedNodes"`
+	// MaxNewNodes is the number of nodes the node group can grow by.
+	MaxNewNodes int `json:"maxNewNodes"`
+	// TypicalProvisionTimeSeconds is the median duration of recent scale-ups of the node group,
+	// omitted if there were none.
+	TypicalProvisionTimeSeconds float64 `json:"typicalProvisionTimeSeconds,omitempty"`
+}
+
+// CapacityForecast is the response to a forecast request.
+type CapacityForecast struct {
+	// SnapshotTime is the time of the main loop iteration the forecast is based on.
+	SnapshotTime time.Time `json:"snapshotTime"`
+	// SchedulableOnExistingNodes is the number of pods that fit on existing nodes.
+	SchedulableOnExistingNodes int                 `json:"schedulableOnExistingNodes"`
+	NodeGroups                 []NodeGroupForecast `json:"nodeGroups"`
+	// Complete is false if some node groups were not evaluated within the forecast timeout.
+	Complete bool `json:"complete"`
+}
+
+// forecastNodeGroup is the state of a node group captured by the main loop.
+type forecastNodeGroup struct {
+	id              string
+	nodeInfo        *schedulercache.NodeInfo
+	maxNewNodes     int
+	safeToScaleUp   bool
+	provisionTime   time.Duration
+	provisionTimeOk bool
+}
+
+// forecastSnapshot is the state of the cluster captured by the main loop. Forecasts only read it.
+type forecastSnapshot struct {
+	time                         time.Time
+	nodes                        []*apiv1.Node
+	scheduledPods                []*apiv1.Pod
+	podsWaitingForPreemption     []*apiv1.Pod
+	nodeGroups                   []forecastNodeGroup
+	upcomingNodes                []*schedulercache.NodeInfo
+	predicateChecker             *simulator.PredicateChecker
+	estimatorName                string
+	expendablePodsPriorityCutoff int
+}
+
+// CapacityForecaster answers whether the autoscaler could provision nodes for a set of pods, based on
+// the state of the cluster seen by the last main loop iteration. Forecasts run in the HTTP server
+// goroutine and never modify the cluster state. Only one forecast runs at a time and each is bounded
+// by a timeout, so the endpoint can't starve the main loop.
+type CapacityForecaster struct {
+	timeout  time.Duration
+	busy     chan struct{}
+	mutex    sync.Mutex
+	snapshot *forecastSnapshot
+}
+
+// NewCapacityForecaster builds a CapacityForecaster spending at most timeout on a single forecast.
+func NewCapacityForecaster(timeout time.Duration) *CapacityForecaster {
+	return &CapacityForecaster{
+		timeout: timeout,
+		busy:    make(chan struct{}, 1),
+	}
+}
+
+// UpdateSnapshot captures the state of the cluster for subsequent forecasts.
+func (f *CapacityForecaster) UpdateSnapshot(context *AutoscalingContext, nodes []*apiv1.Node, scheduledPods []*apiv1.Pod,
+	podsWaitingForPreemption []*apiv1.Pod, daemonsets []*extensionsv1.DaemonSet, now time.Time) error {
+	nodeInfos, err := GetNodeInfosForGroups(nodes, context.CloudProvider, context.ClientSet, daemonsets,
+		context.TemplateNodeInfoCache, context.NodeAllocatableTracker, context.IgnoredTaints)
+	if err != nil {
+		return err
+	}
+	nodeGroups := make([]forecastNodeGroup, 0)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		targetSize, err := nodeGroup.TargetSize()
+		if err != nil {
+			return fmt.Errorf("failed to get size of node group %s: %v", nodeGroup.Id(), err)
+		}
+		group := forecastNodeGroup{
+			id:            nodeGroup.Id(),
+			nodeInfo:      nodeInfos[nodeGroup.Id()],
+			maxNewNodes:   nodeGroup.MaxSize() - targetSize,
+			safeToScaleUp: context.ClusterStateRegistry.IsNodeGroupSafeToScaleUp(nodeGroup.Id(), now),
+		}
+		group.provisionTime, group.provisionTimeOk = context.ClusterStateRegistry.GetTypicalProvisionTime(nodeGroup.Id())
+		nodeGroups = append(nodeGroups, group)
+	}
+	upcomingNodes := make([]*schedulercache.NodeInfo, 0)
+	for nodeGroup, numberOfNodes := range context.ClusterStateRegistry.GetUpcomingNodes() {
+		nodeTemplate, found := nodeInfos[nodeGroup]
+		if !found {
+			continue
+		}
+		for i := 0; i < numberOfNodes; i++ {
+			upcomingNodes = append(upcomingNodes, nodeTemplate)
+		}
+	}
+	// The main loop reconfigures the predicate checker in every iteration, forecasts use a copy.
+	predicateChecker := *context.PredicateChecker
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.snapshot = &forecastSnapshot{
+		time:                         now,
+		nodes:                        nodes,
+		scheduledPods:                scheduledPods,
+		podsWaitingForPreemption:     podsWaitingForPreemption,
+		nodeGroups:                   nodeGroups,
+		upcomingNodes:                upcomingNodes,
+		predicateChecker:             &predicateChecker,
+		estimatorName:                context.EstimatorName,
+		expendablePodsPriorityCutoff: context.ExpendablePodsPriorityCutoff,
+	}
+	return nil
+}
+
+// Forecast checks which node groups could provision nodes for the pods. Returns nil if there is no
+// snapshot of the cluster yet.
+func (f *CapacityForecaster) Forecast(pods []*apiv1.Pod) *CapacityForecast {
+	f.mutex.Lock()
+	snapshot := f.snapshot
+	f.mutex.Unlock()
+	if snapshot == nil {
+		return nil
+	}
+	deadline := time.Now().Add(f.timeout)
+
+	pendingPods := FilterOutSchedulable(pods, snapshot.nodes, snapshot.scheduledPods, snapshot.podsWaitingForPreemption,
+		snapshot.predicateChecker, snapshot.expendablePodsPriorityCutoff)
+	result := &CapacityForecast{
+		SnapshotTime:               snapshot.time,
+		SchedulableOnExistingNodes: len(pods) - len(pendingPods),
+		NodeGroups:                 make([]NodeGroupForecast, 0, len(snapshot.nodeGroups)),
+		Complete:                   true,
+	}
+	// The estimator only needs these from the context.
+	estimationContext := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{EstimatorName: snapshot.estimatorName},
+		PredicateChecker:   snapshot.predicateChecker,
+	}
+	zoneAntiAffinityGroups := estimator.FindZoneAntiAffinityGroups(pendingPods)
+
+	for _, nodeGroup := range snapshot.nodeGroups {
+		forecast := NodeGroupForecast{
+			NodeGroup:   nodeGroup.id,
+			MaxNewNodes: nodeGroup.maxNewNodes,
+		}
+		if nodeGroup.provisionTimeOk {
+			forecast.TypicalProvisionTimeSeconds = nodeGroup.provisionTime.Seconds()
+		}
+		if time.Now().After(deadline) {
+			forecast.Reason = ForecastNotEvaluated
+			result.Complete = false
+			result.NodeGroups = append(result.NodeGroups, forecast)
+			continue
+		}
+		if nodeGroup.nodeInfo == nil {
+			forecast.Reason = ForecastNoNodeTemplate
+			result.NodeGroups = append(result.NodeGroups, forecast)
+			continue
+		}
+
+		fittingPods := make([]*apiv1.Pod, 0)
+		for _, pod := range pendingPods {
+			if err := snapshot.predicateChecker.CheckPredicates(pod, nil, nodeGroup.nodeInfo, simulator.ReturnSimpleError); err == nil {
+				fittingPods = append(fittingPods, pod)
+			}
+		}
+		forecast.FittingPods = len(fittingPods)
+		fittingPods, _ = estimator.LimitZoneAntiAffinePods(fittingPods, zoneAntiAffinityGroups)
+		if len(fittingPods) > 0 {
+			forecast.EstimatedNodes, _, _ = estimateNodeCount(estimationContext, fittingPods, nodeGroup.nodeInfo, snapshot.upcomingNodes)
+		}
+
+		switch {
+		case !nodeGroup.safeToScaleUp:
+			forecast.Reason = ForecastNotSafeToScaleUp
+		case forecast.FittingPods < len(pendingPods):
+			forecast.Reason = ForecastPodsDontFit
+		case forecast.EstimatedNodes > forecast.MaxNewNodes:
+			forecast.Reason = ForecastMaxSizeReached
+		default:
+			forecast.Feasible = true
+		}
+		result.NodeGroups = append(result.NodeGroups, forecast)
+	}
+	return result
+}
+
+// ServeHTTP implements http.Handler interface to answer forecast requests POSTed as CapacityForecastRequest.
+func (f *CapacityForecaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case f.busy <- struct{}{}:
+		defer func() { <-f.busy }()
+	default:
+		http.Error(w, "another forecast is in progress", http.StatusTooManyRequests)
+		return
+	}
+
+	var request CapacityForecastRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxForecastRequestBytes)).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(request.Pods) == 0 || len(request.Pods) > maxForecastPods {
+		http.Error(w, fmt.Sprintf("expected between 1 and %d pods, got %d", maxForecastPods, len(request.Pods)), http.StatusBadRequest)
+		return
+	}
+	pods := make([]*apiv1.Pod, 0, len(request.Pods))
+	for i := range request.Pods {
+		pod := &request.Pods[i]
+		if pod.Namespace == "" {
+			pod.Namespace = apiv1.NamespaceDefault
+		}
+		if pod.Name == "" {
+			pod.Name = fmt.Sprintf("forecast-%d", i)
+		}
+		pod.UID = types.UID(fmt.Sprintf("forecast-%d", i))
+		pods = append(pods, pod)
+	}
+
+	forecast := f.Forecast(pods)
+	if forecast == nil {
+		http.Error(w, "cluster state is not known yet", http.StatusServiceUnavailable)
+		return
+	}
+	glog.V(4).Infof("Capacity forecast for %d pods, %d fit on existing nodes", len(pods), forecast.SchedulableOnExistingNodes)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(forecast); err != nil {
+		glog.Errorf("Failed to write capacity forecast: %v", err)
+	}
+}
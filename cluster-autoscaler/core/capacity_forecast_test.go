@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildForecastTemplate(name string, cpu int64) *schedulercache.NodeInfo {
+	node := BuildTestNode(name, cpu, 1000000)
+	SetNodeReadyState(node, true, time.Time{})
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	return nodeInfo
+}
+
+func buildForecastSnapshot() *forecastSnapshot {
+	n1 := BuildTestNode("n1", 1000, 1000000)
+	SetNodeReadyState(n1, true, time.Time{})
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Spec.NodeName = "n1"
+
+	return &forecastSnapshot{
+		time:          time.Now(),
+		nodes:         []*apiv1.Node{n1},
+		scheduledPods: []*apiv1.Pod{p1},
+		nodeGroups: []forecastNodeGroup{
+			{id: "ng1", nodeInfo: buildForecastTemplate("ng1-template", 2000), maxNewNodes: 5, safeToScaleUp: true,
+				provisionTime: 3 * time.Minute, provisionTimeOk: true},
+			{id: "ng2", nodeInfo: buildForecastTemplate("ng2-template", 500), maxNewNodes: 5, safeToScaleUp: true},
+			{id: "ng3", maxNewNodes: 5, safeToScaleUp: true},
+			{id: "ng4", nodeInfo: buildForecastTemplate("ng4-template", 2000), maxNewNodes: 1, safeToScaleUp: true},
+			{id: "ng5", nodeInfo: buildForecastTemplate("ng5-template", 2000), maxNewNodes: 5, safeToScaleUp: false},
+		},
+		predicateChecker: simulator.NewTestPredicateChecker(),
+		estimatorName:    estimator.BinpackingEstimatorName,
+	}
+}
+
+func postForecast(forecaster *CapacityForecaster, pods []*apiv1.Pod) *httptest.ResponseRecorder {
+	request := CapacityForecastRequest{}
+	for _, pod := range pods {
+		request.Pods = append(request.Pods, *pod)
+	}
+	body, _ := json.Marshal(request)
+	recorder := httptest.NewRecorder()
+	forecaster.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/simulate", bytes.NewReader(body)))
+	return recorder
+}
+
+func TestCapacityForecast(t *testing.T) {
+	forecaster := NewCapacityForecaster(time.Minute)
+	forecaster.snapshot = buildForecastSnapshot()
+
+	recorder := postForecast(forecaster, []*apiv1.Pod{
+		BuildTestPod("small", 100, 0),
+		BuildTestPod("big1", 1500, 0),
+		BuildTestPod("big2", 1500, 0),
+	})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var forecast CapacityForecast
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &forecast))
+
+	assert.True(t, forecast.Complete)
+	assert.Equal(t, 1, forecast.SchedulableOnExistingNodes)
+	assert.Equal(t, []NodeGroupForecast{
+		{NodeGroup: "ng1", Feasible: true, FittingPods: 2, EstimatedNodes: 2, MaxNewNodes: 5, TypicalProvisionTimeSeconds: 180},
+		{NodeGroup: "ng2", Reason: ForecastPodsDontFit, MaxNewNodes: 5},
+		{NodeGroup: "ng3", Reason: ForecastNoNodeTemplate, MaxNewNodes: 5},
+		{NodeGroup: "ng4", Reason: ForecastMaxSizeReached, FittingPods: 2, EstimatedNodes: 2, MaxNewNodes: 1},
+		{NodeGroup: "ng5", Reason: ForecastNotSafeToScaleUp, FittingPods: 2, EstimatedNodes: 2, MaxNewNodes: 5},
+	}, forecast.NodeGroups)
+}
+
+func TestCapacityForecastTimeout(t *testing.T) {
+	forecaster := NewCapacityForecaster(-time.Second)
+	forecaster.snapshot = buildForecastSnapshot()
+
+	recorder := postForecast(forecaster, []*apiv1.Pod{BuildTestPod("big1", 1500, 0)})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var forecast CapacityForecast
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &forecast))
+	assert.False(t, forecast.Complete)
+	for _, nodeGroup := range forecast.NodeGroups {
+		assert.Equal(t, ForecastNotEvaluated, nodeGroup.Reason)
+		assert.False(t, nodeGroup.Feasible)
+	}
+}
+
+func TestCapacityForecastErrors(t *testing.T) {
+	forecaster := NewCapacityForecaster(time.Minute)
+	pods := []*apiv1.Pod{BuildTestPod("p", 100, 0)}
+
+	assert.Equal(t, http.StatusServiceUnavailable, postForecast(forecaster, pods).Code)
+	forecaster.snapshot = buildForecastSnapshot()
+	assert.Equal(t, http.StatusOK, postForecast(forecaster, pods).Code)
+	assert.Equal(t, http.StatusBadRequest, postForecast(forecaster, []*apiv1.Pod{}).Code)
+
+	recorder := httptest.NewRecorder()
+	forecaster.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/simulate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	forecaster.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/simulate", bytes.NewReader([]byte("{"))))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	forecaster.busy <- struct{}{}
+	assert.Equal(t, http.StatusTooManyRequests, postForecast(forecaster, pods).Code)
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+
+	apiv1 "k8s.io/api/core/v1"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// CapacityReservationsConfigMapName is the name of the ConfigMap holding capacity reserved for
+// namespaces. Each key is a namespace and each value a reservation in the format accepted by
+// config.CapacityReservationFromString.
+const CapacityReservationsConfigMapName = "cluster-autoscaler-capacity-reservations"
+
+// CapacityReservationSource provides capacity reserved for namespaces. It can be implemented to keep
+// reservations outside of CA, for example in a custom resource.
+type CapacityReservationSource interface {
+	// CapacityReservations returns the current reservations.
+	CapacityReservations() ([]*config.CapacityReservation, error)
+}
+
+// ConfigMapCapacityReservationSource reads reservations from CapacityReservationsConfigMapName.
+type ConfigMapCapacityReservationSource struct {
+	client    kube_client.Interface
+	namespace string
+}
+
+// NewConfigMapCapacityReservationSource builds a source reading the ConfigMap in the namespace.
+func NewConfigMapCapacityReservationSource(client kube_client.Interface, namespace string) *ConfigMapCapacityReservationSource {
+	return &ConfigMapCapacityReservationSource{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// CapacityReservations returns the reservations from the ConfigMap, ordered by namespace. There are
+// none if the ConfigMap doesn't exist. Invalid reservations are skipped.
+func (s *ConfigMapCapacityReservationSource) CapacityReservations() ([]*config.CapacityReservation, error) {
+	configMap, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(CapacityReservationsConfigMapName, metav1.GetOptions{})
+	if kube_errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make([]string, 0, len(configMap.Data))
+	for namespace := range configMap.Data {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	result := make([]*config.CapacityReservation, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		reservation, err := config.CapacityReservationFromString(namespace, configMap.Data[namespace])
+		if err != nil {
+			glog.Warningf("Ignoring capacity reservation of %s: %v", namespace, err)
+			continue
+		}
+		result = append(result, reservation)
+	}
+	return result, nil
+}
+
+// capacityReservations is the state of capacity reserved for namespaces. Free capacity kept for a
+// reservation is represented by synthetic pods placed on existing nodes, like headroom, and the
+// capacity missing on existing nodes by pending synthetic pods.
+type capacityReservations struct {
+	// pending are synthetic pods for the missing capacity, which needs a scale-up.
+	pending []*apiv1.Pod
+	// placed are synthetic pods taking the free capacity kept for reservations on existing nodes.
+	placed []*apiv1.Pod
+	// reservedNodes are names of nodes holding reserved capacity. They are not considered for scale-down.
+	reservedNodes map[string]bool
+	statuses      []clusterstate.CapacityReservationStatus
+}
+
+// computeCapacityReservations keeps free capacity for the reservations on nodes of their node groups,
+// which already run the given scheduled pods. Requests of pods of the reserving namespace count
+// towards the reservation. Nodes are taken in name order, and a node's free capacity kept for one
+// reservation isn't available to the next ones. Capacity still missing is requested from nodes
+// built from the template of the first node group of the reservation.
+func computeCapacityReservations(reservations []*config.CapacityReservation, nodes []*apiv1.Node, scheduledPods []*apiv1.Pod,
+	nodeInfos map[string]*schedulercache.NodeInfo, cloudProvider cloudprovider.CloudProvider) *capacityReservations {
+
+	result := &capacityReservations{
+		pending:       make([]*apiv1.Pod, 0),
+		placed:        make([]*apiv1.Pod, 0),
+		reservedNodes: make(map[string]bool),
+	}
+	sortedNodes := make([]*apiv1.Node, len(nodes))
+	copy(sortedNodes, nodes)
+	sort.Slice(sortedNodes, func(i, j int) bool { return sortedNodes[i].Name < sortedNodes[j].Name })
+	podsOnNodes := make(map[string][]*apiv1.Pod)
+	for _, pod := range scheduledPods {
+		podsOnNodes[pod.Spec.NodeName] = append(podsOnNodes[pod.Spec.NodeName], pod)
+	}
+	nodeGroupOfNode := make(map[string]string)
+	nodeNameToNodeInfo := make(map[string]*schedulercache.NodeInfo)
+	for _, node := range sortedNodes {
+		nodeGroup, err := cloudProvider.NodeGroupForNode(node)
+		if err != nil || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		nodeGroupOfNode[node.Name] = nodeGroup.Id()
+		nodeInfo := schedulercache.NewNodeInfo(podsOnNodes[node.Name]...)
+		nodeInfo.SetNode(node)
+		nodeNameToNodeInfo[node.Name] = nodeInfo
+	}
+
+	for _, reservation := range reservations {
+		inReservation := make(map[string]bool)
+		for _, id := range reservation.NodeGroups {
+			inReservation[id] = true
+		}
+
+		// Requests of the namespace pods running in the node groups use up the reservation.
+		namespacePods := make([]*apiv1.Pod, 0)
+		for _, node := range sortedNodes {
+			if !inReservation[nodeGroupOfNode[node.Name]] {
+				continue
+			}
+			for _, pod := range podsOnNodes[node.Name] {
+				if pod.Namespace == reservation.Namespace {
+					namespacePods = append(namespacePods, pod)
+				}
+			}
+		}
+		used := schedulercache.NewNodeInfo(namespacePods...).RequestedResource()
+		missingCPU := reservation.CPU.MilliValue() - used.MilliCPU
+		missingMemory := reservation.Memory.Value() - used.Memory
+
+		for _, node := range sortedNodes {
+			if missingCPU <= 0 && missingMemory <= 0 {
+				break
+			}
+			if !inReservation[nodeGroupOfNode[node.Name]] {
+				continue
+			}
+			nodeInfo := nodeNameToNodeInfo[node.Name]
+			allocatable := nodeInfo.AllocatableResource()
+			requested := nodeInfo.RequestedResource()
+			cpu := minInt64(missingCPU, allocatable.MilliCPU-requested.MilliCPU)
+			memory := minInt64(missingMemory, allocatable.Memory-requested.Memory)
+			if cpu <= 0 && memory <= 0 {
+				continue
+			}
+			cpu, memory = maxInt64(cpu, 0), maxInt64(memory, 0)
+			pod := buildSyntheticPod(fmt.Sprintf("capacity-reservation-%s-%s", reservation.Namespace, node.Name), nil,
+				*resource.NewMilliQuantity(cpu, resource.DecimalSI), *resource.NewQuantity(memory, resource.DecimalSI))
+			pod.Spec.NodeName = node.Name
+			nodeInfo.AddPod(pod)
+			result.placed = append(result.placed, pod)
+			result.reservedNodes[node.Name] = true
+			missingCPU -= cpu
+			missingMemory -= memory
+		}
+
+		status := clusterstate.CapacityReservationStatus{
+			Reservation:     reservation.String(),
+			MissingMilliCPU: maxInt64(missingCPU, 0),
+			MissingMemory:   maxInt64(missingMemory, 0),
+		}
+		result.statuses = append(result.statuses, status)
+		if status.Satisfied() {
+			continue
+		}
+		glog.V(1).Infof("Capacity reservation %s: missing cpu=%dm memory=%d", reservation, status.MissingMilliCPU, status.MissingMemory)
+		pending, err := buildCapacityReservationPods(reservation, status, nodeInfos)
+		if err != nil {
+			glog.Warningf("Failed to request capacity for reservation %s: %v", reservation, err)
+			continue
+		}
+		result.pending = append(result.pending, pending...)
+	}
+	return result
+}
+
+// buildCapacityReservationPods builds pending synthetic pods for the capacity missing for the
+// reservation, each fitting on an empty node of the first node group of the reservation.
+func buildCapacityReservationPods(reservation *config.CapacityReservation, status clusterstate.CapacityReservationStatus,
+	nodeInfos map[string]*schedulercache.NodeInfo) ([]*apiv1.Pod, error) {
+
+	nodeInfo, found := nodeInfos[reservation.NodeGroups[0]]
+	if !found || nodeInfo.Node() == nil {
+		return nil, fmt.Errorf("no node info for node group %s", reservation.NodeGroups[0])
+	}
+	allocatable := nodeInfo.AllocatableResource()
+	requested := nodeInfo.RequestedResource()
+	freeCPU := allocatable.MilliCPU - requested.MilliCPU
+	freeMemory := allocatable.Memory - requested.Memory
+	if (status.MissingMilliCPU > 0 && freeCPU <= 0) || (status.MissingMemory > 0 && freeMemory <= 0) {
+		return nil, fmt.Errorf("nodes of node group %s have no free capacity", reservation.NodeGroups[0])
+	}
+
+	selector := templateNodeSelector(nodeInfo.Node())
+	pods := make([]*apiv1.Pod, 0)
+	missingCPU, missingMemory := status.MissingMilliCPU, status.MissingMemory
+	for i := 0; missingCPU > 0 || missingMemory > 0; i++ {
+		cpu := maxInt64(minInt64(missingCPU, freeCPU), 0)
+		memory := maxInt64(minInt64(missingMemory, freeMemory), 0)
+		pods = append(pods, buildSyntheticPod(fmt.Sprintf("capacity-reservation-%s-%d", reservation.Namespace, i), selector,
+			*resource.NewMilliQuantity(cpu, resource.DecimalSI), *resource.NewQuantity(memory, resource.DecimalSI)))
+		missingCPU -= cpu
+		missingMemory -= memory
+	}
+	return pods, nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runCapacityReservationTest computes reservations in a cluster with node group ng1 made of nodes n1,
+// running a 600m pod of the podNamespace, and n2, which is empty. Node n3 of ng2, with different
+// labels, is empty as well. Pending reservation pods are then passed to scale-up. Returns the computed
+// reservations and node group size changes.
+func runCapacityReservationTest(t *testing.T, podNamespace string, reservationValue string) (*capacityReservations, []string) {
+	fakeClient := &fake.Clientset{}
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Labels["pool"] = "a"
+	SetNodeReadyState(n1, true, time.Now())
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Labels["pool"] = "a"
+	SetNodeReadyState(n2, true, time.Now())
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n3.Labels["pool"] = "b"
+	SetNodeReadyState(n3, true, time.Now())
+	p1 := BuildTestPod("p1", 600, 0)
+	p1.Namespace = podNamespace
+	p1.Spec.NodeName = "n1"
+	nodes := []*apiv1.Node{n1, n2, n3}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng2", n3)
+
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             kube_record.NewFakeRecorder(10),
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	reservation, err := config.CapacityReservationFromString("team-a", reservationValue)
+	assert.NoError(t, err)
+	nodeInfos, err := GetNodeInfosForGroups(nodes, provider, fakeClient, []*extensionsv1.DaemonSet{},
+		nil, nil, nil)
+	assert.NoError(t, err)
+	result := computeCapacityReservations([]*config.CapacityReservation{reservation}, nodes, []*apiv1.Pod{p1}, nodeInfos, provider)
+
+	changes := make([]string, 0)
+	if len(result.pending) > 0 {
+		_, err := ScaleUp(context, result.pending, nodes, []*extensionsv1.DaemonSet{}, nil)
+		assert.NoError(t, err)
+	}
+	for len(sizeChanges) > 0 {
+		changes = append(changes, <-sizeChanges)
+	}
+	return result, changes
+}
+
+func TestCapacityReservationBlocksScaleDown(t *testing.T) {
+	result, changes := runCapacityReservationTest(t, "default", "cpu=1:nodeGroups=ng1")
+	// 400m free on n1 and 600m on n2 are kept, so the empty n2 can't be removed. n3 isn't in ng1.
+	assert.Equal(t, 2, len(result.placed))
+	assert.Equal(t, int64(400), result.placed[0].Spec.Containers[0].Resources.Requests.Cpu().MilliValue())
+	assert.Equal(t, "n1", result.placed[0].Spec.NodeName)
+	assert.Equal(t, int64(600), result.placed[1].Spec.Containers[0].Resources.Requests.Cpu().MilliValue())
+	assert.Equal(t, "n2", result.placed[1].Spec.NodeName)
+	assert.Equal(t, map[string]bool{"n1": true, "n2": true}, result.reservedNodes)
+	assert.Equal(t, 0, len(result.pending))
+	assert.Equal(t, []clusterstate.CapacityReservationStatus{{Reservation: "team-a/cpu=1:nodeGroups=ng1"}}, result.statuses)
+	assert.Equal(t, []string{}, changes)
+
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	assert.Equal(t, []*apiv1.Node{n3}, filterOutReservedNodes([]*apiv1.Node{n2, n3}, result.reservedNodes))
+}
+
+func TestCapacityReservationUsedByNamespace(t *testing.T) {
+	result, changes := runCapacityReservationTest(t, "team-a", "cpu=1:nodeGroups=ng1")
+	// The 600m pod of team-a uses the reservation, the rest fits next to it on n1.
+	assert.Equal(t, 1, len(result.placed))
+	assert.Equal(t, map[string]bool{"n1": true}, result.reservedNodes)
+	assert.True(t, result.statuses[0].Satisfied())
+	assert.Equal(t, []string{}, changes)
+}
+
+func TestCapacityReservationTopUp(t *testing.T) {
+	result, changes := runCapacityReservationTest(t, "default", "cpu=2500m:nodeGroups=ng1,ng2")
+	// 400m on n1, 1000m on n2 and 1000m on n3 are free, 100m is missing.
+	assert.Equal(t, map[string]bool{"n1": true, "n2": true, "n3": true}, result.reservedNodes)
+	assert.Equal(t, []clusterstate.CapacityReservationStatus{
+		{Reservation: "team-a/cpu=2500m:nodeGroups=ng1,ng2", MissingMilliCPU: 100},
+	}, result.statuses)
+	assert.Equal(t, 1, len(result.pending))
+	assert.Equal(t, []string{"ng1-1"}, changes)
+
+	result, changes = runCapacityReservationTest(t, "default", "cpu=3500m:nodeGroups=ng1")
+	// 1400m is free in ng1, 2100m is requested from three new nodes of ng1.
+	assert.Equal(t, int64(2100), result.statuses[0].MissingMilliCPU)
+	assert.Equal(t, 3, len(result.pending))
+	assert.Equal(t, []string{"ng1-3"}, changes)
+}
+
+func TestConfigMapCapacityReservationSource(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	source := NewConfigMapCapacityReservationSource(fakeClient, "kube-system")
+	reservations, err := source.CapacityReservations()
+	assert.NoError(t, err)
+	assert.Empty(t, reservations)
+
+	_, err = fakeClient.CoreV1().ConfigMaps("kube-system").Create(&apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: CapacityReservationsConfigMapName},
+		Data: map[string]string{
+			"team-b": "memory=1Gi:nodeGroups=ng2",
+			"team-a": "cpu=4:nodeGroups=ng1",
+			"team-c": "gpus=1:nodeGroups=ng1",
+		},
+	})
+	assert.NoError(t, err)
+	reservations, err = source.CapacityReservations()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(reservations))
+	assert.Equal(t, "team-a/cpu=4:nodeGroups=ng1", reservations[0].String())
+	assert.Equal(t, "team-b/memory=1Gi:nodeGroups=ng2", reservations[1].String())
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
+
+	apiv1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/golang/glog"
+)
+
+// compactionState is what ScaleDown remembers between loops to compact nodes.
+type compactionState struct {
+	// candidatesSince are underutilized nodes that can't be removed because of some of their pods,
+	// with the time they were first seen so.
+	candidatesSince map[string]time.Time
+	// evictions are the times of evictions done by compaction in the last hour.
+	evictions []time.Time
+	// evictedWorkloads are the workloads of pods evicted by compaction, with the time of the last
+	// eviction. They are not evicted again for CompactionWorkloadCooldown.
+	evictedWorkloads map[string]time.Time
+	// tainted are nodes tainted with ToBeDeleted while pods are evicted from them, so that the pods
+	// don't come back, with the keys of the evicted pods. The taint is removed once these pods are
+	// gone.
+	tainted map[string][]string
+}
+
+func newCompactionState() *compactionState {
+	return &compactionState{
+		candidatesSince:  make(map[string]time.Time),
+		evictions:        make([]time.Time, 0),
+		evictedWorkloads: make(map[string]time.Time),
+		tainted:          make(map[string][]string),
+	}
+}
+
+// UpdateCompactionCandidates updates the list of nodes from which TryToCompact may evict pods:
+// nodes below the utilization threshold that were found unremovable because of some of their pods.
+func (sd *ScaleDown) UpdateCompactionCandidates(nodes []*apiv1.Node, pods []*apiv1.Pod, timestamp time.Time) {
+	sd.untaintCompactedNodes(nodes, pods)
+	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(pods, nodes)
+	result := make(map[string]time.Time)
+	for _, node := range nodes {
+		reason, found := sd.unremovableReasons[node.Name]
+		if !found {
+			continue
+		}
+		blocked := simulator.UnremovableNode{Node: node, Reason: reason}
+		if !blocked.BlockedByPod() || hasNoScaleDownAnnotation(node) || deletetaint.HasToBeDeletedTaint(node) {
+			continue
+		}
+		nodeInfo, found := nodeNameToNodeInfo[node.Name]
+		if !found {
+			continue
+		}
+		utilization, err := sd.calculateUtilization(node, nodeInfo)
+		if err != nil {
+			glog.Warningf("Failed to calculate utilization for %s: %v", node.Name, err)
+			continue
+		}
+		threshold := sd.context.ScaleDownUtilizationThreshold
+		if nodeGroup, err := sd.context.CloudProvider.NodeGroupForNode(node); err == nil {
+			threshold = sd.context.NodeGroupConfigProcessor.GetOptions(sd.context, nodeGroup).ScaleDownUtilizationThreshold
+		}
+		if utilization >= threshold {
+			continue
+		}
+		if since, found := sd.compaction.candidatesSince[node.Name]; found {
+			result[node.Name] = since
+		} else {
+			result[node.Name] = timestamp
+		}
+	}
+	sd.compaction.candidatesSince = result
+}
+
+// TryToCompact evicts the movable pods of one node that has been a compaction candidate for
+// ScaleDownUnneededTime, if the simulation shows that afterwards another node, now unremovable
+// because its pods don't fit elsewhere, can be removed. The node itself is not removed. At most
+// CompactionEvictionsPerHour pods are evicted per hour, and pods of workloads evicted in the last
+// CompactionWorkloadCooldown are left alone, so the same pods aren't moved back and forth. Nodes
+// whose pods would exceed their daily restart budgets aren't compacted either. The node CA runs on
+// is compacted last, without evicting the pod of CA.
+// Returns true if pods were evicted.
+func (sd *ScaleDown) TryToCompact(allNodes []*apiv1.Node, pods []*apiv1.Pod, pdbs []*policyv1.PodDisruptionBudget,
+	timestamp time.Time) (bool, errors.AutoscalerError) {
+	budget := sd.compactionBudget(timestamp)
+	if budget <= 0 {
+		glog.V(4).Infof("Compaction: eviction budget exhausted")
+		return false, nil
+	}
+
+	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(pods, allNodes)
+	sources := make([]*apiv1.Node, 0)
+	targets := make([]*apiv1.Node, 0)
+	for _, node := range allNodes {
+		if since, found := sd.compaction.candidatesSince[node.Name]; found && !since.Add(sd.context.ScaleDownUnneededTime).After(timestamp) {
+			sources = append(sources, node)
+		} else if sd.unremovableReasons[node.Name] == simulator.NoPlaceToMovePods && sd.canShrinkNodeGroupOf(node) {
+			targets = append(targets, node)
+		}
+	}
+	if len(sources) == 0 || len(targets) == 0 {
+		return false, nil
+	}
+	// The longest underutilized nodes are compacted first.
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sd.compaction.candidatesSince[sources[i].Name].Before(sd.compaction.candidatesSince[sources[j].Name])
+	})
+	sources = orderSelfNodeLast(sources, sd.context.Self)
+
+	for _, source := range sources {
+		nodeInfo, found := nodeNameToNodeInfo[source.Name]
+		if !found {
+			continue
+		}
+		movablePods := simulator.GetMovablePods(nodeInfo, pdbs)
+		if sd.context.Self.isSelfNode(source) {
+			// The pod of CA stays on its node, which is compacted only once no other node is being deleted.
+			if beingDeleted := otherNodesBeingDeleted(allNodes, sd.context.Self); len(beingDeleted) > 0 {
+				glog.V(4).Infof("Compaction: skipping %s cluster autoscaler runs on until other nodes are deleted: %v", source.Name, beingDeleted)
+				continue
+			}
+			movablePods = withoutSelfPod(movablePods, sd.context.Self)
+		}
+		if len(movablePods) == 0 || len(movablePods) > budget {
+			continue
+		}
+		if workload, cooling := sd.coolingWorkload(movablePods, timestamp); cooling {
+			glog.V(4).Infof("Compaction: skipping %s, pods of %s were recently evicted", source.Name, workload)
+			continue
+		}
+		if exceeding := sd.restartBudget.exceedingPods(movablePods, timestamp); len(exceeding) > 0 {
+			glog.V(4).Infof("Compaction: skipping %s, daily restart budget of %s exhausted", source.Name, workloadKey(exceeding[0]))
+			continue
+		}
+		for _, target := range targets {
+			if simulator.CompactionEnablesRemoval(source, movablePods, target, allNodes, pods, sd.context.PredicateChecker,
+				pdbs, sd.context.ScoringStrategy, timestamp) {
+				return true, sd.compactNode(source, target, movablePods, timestamp)
+			}
+		}
+	}
+	return false, nil
+}
+
+// compactionBudget returns the number of pods compaction may still evict in the current hour.
+func (sd *ScaleDown) compactionBudget(timestamp time.Time) int {
+	recent := make([]time.Time, 0, len(sd.compaction.evictions))
+	for _, eviction := range sd.compaction.evictions {
+		if eviction.Add(time.Hour).After(timestamp) {
+			recent = append(recent, eviction)
+		}
+	}
+	sd.compaction.evictions = recent
+	return sd.context.CompactionEvictionsPerHour - len(recent)
+}
+
+// coolingWorkload returns a workload of one of the pods that compaction evicted less than
+// CompactionWorkloadCooldown ago.
+func (sd *ScaleDown) coolingWorkload(pods []*apiv1.Pod, timestamp time.Time) (string, bool) {
+	for workload, evicted := range sd.compaction.evictedWorkloads {
+		if !evicted.Add(sd.context.CompactionWorkloadCooldown).After(timestamp) {
+			delete(sd.compaction.evictedWorkloads, workload)
+		}
+	}
+	for _, pod := range pods {
+		workload := workloadKey(pod)
+		if _, found := sd.compaction.evictedWorkloads[workload]; found {
+			return workload, true
+		}
+	}
+	return "", false
+}
+
+// canShrinkNodeGroupOf returns true if the node group of the node is above its min size, so removing
+// the node is possible at all.
+func (sd *ScaleDown) canShrinkNodeGroupOf(node *apiv1.Node) bool {
+	nodeGroup, err := sd.context.CloudProvider.NodeGroupForNode(node)
+	if err != nil || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+		return false
+	}
+	size, err := nodeGroup.TargetSize()
+	return err == nil && size > nodeGroup.MinSize()
+}
+
+func (sd *ScaleDown) compactNode(source, target *apiv1.Node, pods []*apiv1.Pod, timestamp time.Time) errors.AutoscalerError {
+	if sd.context.DryRun {
+		sd.recordCompaction(pods, timestamp)
+		recordDryRunAction(sd.context, metrics.DryRunCompaction, nodeGroupIdForNode(sd.context.CloudProvider, source),
+			"would evict %d pods from %s to make %s removable", len(pods), source.Name, target.Name)
+		return nil
+	}
+
+	// The evicted pods must be rescheduled elsewhere, not back on the node they're evicted from.
+	if err := deletetaint.MarkToBeDeleted(source, sd.context.ClientSet); err != nil {
+		sd.context.Recorder.Eventf(source, apiv1.EventTypeWarning, "CompactionFailed", "failed to mark the node as toBeDeleted/unschedulable: %v", err)
+		return errors.ToAutoscalerError(errors.ApiCallError, err)
+	}
+	sd.recordCompaction(pods, timestamp)
+	glog.V(0).Infof("Compaction: evicting %d pods from %s to make %s removable", len(pods), source.Name, target.Name)
+	sd.context.LogRecorder.Eventf(apiv1.EventTypeNormal, "Compaction",
+		"Compaction: evicting pods %s from %s to make %s removable", podNames(pods), source.Name, target.Name)
+	sd.context.Recorder.Eventf(source, apiv1.EventTypeNormal, "Compaction", "evicting %d pods to make %s removable", len(pods), target.Name)
+	metrics.RegisterCompaction()
+	sd.restartBudget.recordEvictions(pods, timestamp)
+	maxGracefulTerminationSec, _ := nodeDrainLimits(sd.context, source)
+	for _, pod := range pods {
+		maxTermination := podGracePeriodSec(pod, maxGracefulTerminationSec)
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+			},
+			DeleteOptions: &metav1.DeleteOptions{
+				GracePeriodSeconds: &maxTermination,
+			},
+		}
+		// Pods protected by a PDB that was just exhausted are not retried, the rest of the node
+		// is compacted in a later loop.
+		if err := sd.context.ClientSet.CoreV1().Pods(pod.Namespace).Evict(eviction); err != nil {
+			sd.context.Recorder.Eventf(pod, apiv1.EventTypeWarning, "CompactionFailed", "failed to evict pod for compaction: %v", err)
+			sd.untaintCompactedNode(source)
+			return errors.NewAutoscalerError(errors.ApiCallError, "failed to evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		sd.context.Recorder.Eventf(pod, apiv1.EventTypeNormal, "Compaction", "evicting pod to make node %s removable", target.Name)
+		metrics.RegisterEvictions(1)
+	}
+	sd.compaction.tainted[source.Name] = podKeys(pods)
+	return nil
+}
+
+// recordCompaction counts the evictions of the pods into the compaction budget and cooldowns.
+func (sd *ScaleDown) recordCompaction(pods []*apiv1.Pod, timestamp time.Time) {
+	for _, pod := range pods {
+		sd.compaction.evictions = append(sd.compaction.evictions, timestamp)
+		sd.compaction.evictedWorkloads[workloadKey(pod)] = timestamp
+	}
+}
+
+// untaintCompactedNodes removes the ToBeDeleted taint from compacted nodes none of the evicted pods
+// are left on. Nodes that are gone are forgotten.
+func (sd *ScaleDown) untaintCompactedNodes(nodes []*apiv1.Node, pods []*apiv1.Pod) {
+	if len(sd.compaction.tainted) == 0 {
+		return
+	}
+	remaining := make(map[string]bool, len(pods))
+	for _, key := range podKeys(pods) {
+		remaining[key] = true
+	}
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.Name] = true
+		evicted, found := sd.compaction.tainted[node.Name]
+		if !found {
+			continue
+		}
+		gone := true
+		for _, key := range evicted {
+			if remaining[key] {
+				gone = false
+				break
+			}
+		}
+		if gone {
+			sd.untaintCompactedNode(node)
+		}
+	}
+	for name := range sd.compaction.tainted {
+		if !present[name] {
+			delete(sd.compaction.tainted, name)
+		}
+	}
+}
+
+func (sd *ScaleDown) untaintCompactedNode(node *apiv1.Node) {
+	delete(sd.compaction.tainted, node.Name)
+	if _, err := deletetaint.CleanToBeDeleted(node, sd.context.ClientSet); err != nil {
+		sd.context.Recorder.Eventf(node, apiv1.EventTypeWarning, "ClusterAutoscalerCleanup",
+			"failed to clean toBeDeletedTaint after compaction: %v", err)
+	}
+}
+
+// workloadKey identifies the controller of the pod, or the pod itself if it has none.
+func workloadKey(pod *apiv1.Pod) string {
+	if controllerRef := drain.ControllerRef(pod); controllerRef != nil {
+		return fmt.Sprintf("%s/%s/%s", pod.Namespace, controllerRef.Kind, controllerRef.Name)
+	}
+	return fmt.Sprintf("%s/Pod/%s", pod.Namespace, pod.Name)
+}
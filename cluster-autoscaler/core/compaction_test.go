@@ -0,0 +1,279 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildCompactionTest builds three nodes of ng1. n1 is underutilized, kept by a naked pod and has a
+// movable pod. n2 has a single pod of targetPodCpu that doesn't fit anywhere else. n3 is half full
+// with a naked pod, so it's kept by its pod too, but has nothing to move. The movable pod of n1 fits
+// on n3, and the pod of n2 fits on n1 once the movable pod is gone, if it's not bigger than 900.
+// Evicted pods are sent to the returned channel. Taints of the nodes are patched in the fake client.
+func buildCompactionTest(t *testing.T, targetPodCpu int64, evictionsPerHour int) (*ScaleDown, []*apiv1.Node, []*apiv1.Pod, chan string) {
+	ownerRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+
+	n1 := BuildTestNode("n1", 1000, 10)
+	n2 := BuildTestNode("n2", 1000, 10)
+	n3 := BuildTestNode("n3", 1000, 10)
+	nodes := []*apiv1.Node{n1, n2, n3}
+	for _, node := range nodes {
+		SetNodeReadyState(node, true, time.Time{})
+	}
+
+	unmovable := BuildTestPod("unmovable", 100, 0)
+	unmovable.Spec.NodeName = "n1"
+	movable := BuildTestPod("movable", 300, 0)
+	movable.OwnerReferences = ownerRefs
+	movable.Spec.NodeName = "n1"
+	target := BuildTestPod("target", targetPodCpu, 0)
+	target.OwnerReferences = GenerateOwnerReferences("other-rs", "ReplicaSet", "extensions/v1beta1", "")
+	target.Spec.NodeName = "n2"
+	filler := BuildTestPod("filler", 500, 0)
+	filler.Spec.NodeName = "n3"
+	pods := []*apiv1.Pod{unmovable, movable, target, filler}
+
+	evictedPods := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+	apiNodes := make(map[string]*apiv1.Node)
+	for _, node := range nodes {
+		apiNodes[node.Name] = node
+	}
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		getAction := action.(core.GetAction)
+		if node, found := apiNodes[getAction.GetName()]; found {
+			return true, node, nil
+		}
+		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		obj, err := ApplyJSONPatchToNode(apiNodes[patch.GetName()], patch.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		apiNodes[patch.GetName()] = obj
+		return true, obj, nil
+	})
+	fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		createAction := action.(core.CreateAction)
+		eviction := createAction.GetObject().(*policyv1.Eviction)
+		evictedPods <- eviction.Name
+		return true, nil, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	for _, node := range nodes {
+		provider.AddNode("ng1", node)
+	}
+
+	options := defaultScaleDownOptions
+	options.ScaleDownUtilizationThreshold = 0.96
+	options.ScaleDownUnneededTime = 10 * time.Minute
+	options.CompactionEvictionsPerHour = evictionsPerHour
+	options.CompactionWorkloadCooldown = time.Hour
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions:   options,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		LogRecorder:          fakeLogRecorder,
+	}
+	sd := NewScaleDown(context)
+	assert.NoError(t, sd.UpdateUnneededNodes(nodes, nodes, pods, time.Now(), nil))
+	assert.Equal(t, simulator.NoPlaceToMovePods, sd.unremovableReasons["n2"])
+	return sd, nodes, pods, evictedPods
+}
+
+func TestTryToCompactEnablesRemoval(t *testing.T) {
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 700, 10)
+	now := time.Now()
+
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-5*time.Minute))
+	assert.Equal(t, 2, len(sd.compaction.candidatesSince))
+	assert.Contains(t, sd.compaction.candidatesSince, "n1")
+	assert.Contains(t, sd.compaction.candidatesSince, "n3")
+
+	// Not underutilized for long enough.
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+
+	compacted, err = sd.TryToCompact(nodes, pods, nil, now.Add(10*time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "movable", getStringFromChan(evictedPods))
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+}
+
+func TestTryToCompactTaintsSource(t *testing.T) {
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 700, 10)
+	now := time.Now()
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+	isTainted := func(name string) bool {
+		node, err := sd.context.ClientSet.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deletetaint.HasToBeDeletedTaint(node)
+	}
+
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "movable", getStringFromChan(evictedPods))
+	assert.True(t, isTainted("n1"))
+
+	// The evicted pod is still terminating.
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(time.Minute))
+	assert.True(t, isTainted("n1"))
+
+	// The evicted pod is gone.
+	remaining := make([]*apiv1.Pod, 0)
+	for _, pod := range pods {
+		if pod.Name != "movable" {
+			remaining = append(remaining, pod)
+		}
+	}
+	sd.UpdateCompactionCandidates(nodes, remaining, now.Add(2*time.Minute))
+	assert.False(t, isTainted("n1"))
+	assert.Empty(t, sd.compaction.tainted)
+}
+
+func TestTryToCompactEvictionFailed(t *testing.T) {
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 700, 10)
+	sd.context.ClientSet.(*fake.Clientset).PrependReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("too many requests")
+	})
+	now := time.Now()
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.Error(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+	node, getErr := sd.context.ClientSet.CoreV1().Nodes().Get("n1", metav1.GetOptions{})
+	assert.NoError(t, getErr)
+	assert.False(t, deletetaint.HasToBeDeletedTaint(node))
+	assert.Empty(t, sd.compaction.tainted)
+}
+
+func TestTryToCompactNoRemovalEnabled(t *testing.T) {
+	// The pod of n2 doesn't fit on n1 even after the movable pod is evicted.
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 950, 10)
+	now := time.Now()
+
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+}
+
+func TestTryToCompactLoopPrevention(t *testing.T) {
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 700, 10)
+	now := time.Now()
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "movable", getStringFromChan(evictedPods))
+
+	// The workload was evicted recently and isn't evicted again until the cooldown passes.
+	compacted, err = sd.TryToCompact(nodes, pods, nil, now.Add(30*time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+	compacted, err = sd.TryToCompact(nodes, pods, nil, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "movable", getStringFromChan(evictedPods))
+}
+
+func TestTryToCompactBudget(t *testing.T) {
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 700, 1)
+	now := time.Now()
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "movable", getStringFromChan(evictedPods))
+	assert.Equal(t, 0, sd.compactionBudget(now.Add(59*time.Minute)))
+	assert.Equal(t, 1, sd.compactionBudget(now.Add(time.Hour)))
+
+	// The budget is too small for nodes with more movable pods.
+	sd.compaction = newCompactionState()
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+	sd.context.CompactionEvictionsPerHour = 0
+	compacted, err = sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+}
+
+func TestTryToCompactSelfNode(t *testing.T) {
+	// The movable pod of n1 is the pod of CA, it isn't evicted.
+	sd, nodes, pods, evictedPods := buildCompactionTest(t, 700, 10)
+	sd.context.Self = SelfIdentity{PodNamespace: "default", PodName: "movable", NodeName: "n1"}
+	now := time.Now()
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+	compacted, err := sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+
+	// CA runs on n1 in another pod, n1 isn't compacted while n3 is being deleted.
+	sd, nodes, pods, evictedPods = buildCompactionTest(t, 700, 10)
+	sd.context.Self = SelfIdentity{PodNamespace: "kube-system", PodName: "cluster-autoscaler", NodeName: "n1"}
+	sd.UpdateCompactionCandidates(nodes, pods, now.Add(-time.Hour))
+	tainted := nodes[2].DeepCopy()
+	tainted.Spec.Taints = []apiv1.Taint{{Key: deletetaint.ToBeDeletedTaint, Effect: apiv1.TaintEffectNoSchedule}}
+	compacted, err = sd.TryToCompact([]*apiv1.Node{nodes[0], nodes[1], tainted}, pods, nil, now)
+	assert.NoError(t, err)
+	assert.False(t, compacted)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+
+	// Once n3 is no longer being deleted, n1 is compacted.
+	compacted, err = sd.TryToCompact(nodes, pods, nil, now)
+	assert.NoError(t, err)
+	assert.True(t, compacted)
+	assert.Equal(t, "movable", getStringFromChan(evictedPods))
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+
+	apiv1 "k8s.io/api/core/v1"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+
+	"github.com/golang/glog"
+)
+
+// drainParallelismLimiter limits the number of nodes removed at the same time in each zone and in
+// each node group. Nodes over the limits stay unneeded and can be removed in later loops.
+type drainParallelismLimiter struct {
+	context      *AutoscalingContext
+	perZone      map[string]int
+	perNodeGroup map[string]int
+}
+
+func newDrainParallelismLimiter(context *AutoscalingContext) *drainParallelismLimiter {
+	return &drainParallelismLimiter{
+		context:      context,
+		perZone:      make(map[string]int),
+		perNodeGroup: make(map[string]int),
+	}
+}
+
+// tryAdd checks if the node from the node group can be removed together with the nodes added so
+// far and if so, adds it. Nodes without a zone label are only limited per node group.
+func (l *drainParallelismLimiter) tryAdd(node *apiv1.Node, nodeGroup cloudprovider.NodeGroup) bool {
+	if l == nil {
+		return true
+	}
+	zone := node.Labels[kubeletapis.LabelZoneFailureDomain]
+	if zone != "" && l.context.MaxDrainParallelismPerZone > 0 && l.perZone[zone] >= l.context.MaxDrainParallelismPerZone {
+		glog.V(2).Infof("Skipping %s - %d nodes of zone %s are already being removed", node.Name, l.perZone[zone], zone)
+		return false
+	}
+	limit := l.context.NodeGroupConfigProcessor.GetOptions(l.context, nodeGroup).MaxDrainParallelism
+	if limit > 0 && l.perNodeGroup[nodeGroup.Id()] >= limit {
+		glog.V(2).Infof("Skipping %s - %d nodes of node group %s are already being removed", node.Name,
+			l.perNodeGroup[nodeGroup.Id()], nodeGroup.Id())
+		return false
+	}
+	if zone != "" {
+		l.perZone[zone]++
+	}
+	l.perNodeGroup[nodeGroup.Id()]++
+	return true
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEmptyNodesDrainParallelism(t *testing.T) {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 4)
+	provider.AddNodeGroup("ng2", 0, 10, 6)
+	nodes := make([]*apiv1.Node, 0)
+	for _, n := range []struct {
+		name  string
+		zone  string
+		group string
+	}{
+		{"n1", "zone-a", "ng1"},
+		{"n2", "zone-a", "ng1"},
+		{"n3", "zone-a", "ng1"},
+		{"n4", "zone-b", "ng1"},
+		{"n5", "zone-b", "ng2"},
+		{"n6", "zone-b", "ng2"},
+		{"n7", "zone-c", "ng2"},
+		{"n8", "zone-c", "ng2"},
+		{"n9", "zone-c", "ng2"},
+		{"n10", "", "ng2"},
+	} {
+		node := BuildTestNode(n.name, 1000, 1000)
+		SetNodeReadyState(node, true, time.Time{})
+		if n.zone != "" {
+			node.Labels[kubeletapis.LabelZoneFailureDomain] = n.zone
+		}
+		provider.AddNode(n.group, node)
+		nodes = append(nodes, node)
+	}
+
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			MaxEmptyBulkDelete:              10,
+			MaxDrainParallelismPerZone:      2,
+			MaxDrainParallelismPerNodeGroup: 3,
+		},
+		CloudProvider: provider,
+	}
+	getNames := func() []string {
+		emptyNodes := getEmptyNodes(nodes, []*apiv1.Pod{}, context.MaxEmptyBulkDelete, config.DefaultMaxClusterCores,
+			config.DefaultMaxClusterMemory, provider, newDrainParallelismLimiter(context))
+		names := make([]string, 0, len(emptyNodes))
+		for _, node := range emptyNodes {
+			names = append(names, node.Name)
+		}
+		return names
+	}
+
+	// At most 2 nodes in each zone and 3 nodes in each node group. Nodes without zone are limited only
+	// per node group.
+	assert.Equal(t, []string{"n1", "n2", "n4", "n5", "n7", "n8"}, getNames())
+
+	context.MaxDrainParallelismPerNodeGroup = 0
+	context.MaxDrainParallelismPerZone = 1
+	assert.Equal(t, []string{"n1", "n4", "n7", "n10"}, getNames())
+
+	// Node group limit overridden in the configuration file.
+	fileConfig, err := config.ParseFileConfig([]byte(`
+nodeGroups:
+- name: ng2
+  maxDrainParallelism: 1
+`))
+	assert.NoError(t, err)
+	context.NodeGroupConfigProcessor = &NodeGroupConfigProcessor{fileConfig: fileConfig}
+	context.MaxDrainParallelismPerZone = 0
+	assert.Equal(t, []string{"n1", "n2", "n3", "n4", "n5"}, getNames())
+
+	// No limits.
+	context.NodeGroupConfigProcessor = nil
+	context.MaxEmptyBulkDelete = 8
+	assert.Equal(t, []string{"n1", "n2", "n3", "n4", "n5", "n6", "n7", "n8"}, getNames())
+}
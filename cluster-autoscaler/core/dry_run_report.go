@@ -0,0 +1,223 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
+
+	"github.com/golang/glog"
+)
+
+// hoursPerMonth converts hourly prices to monthly costs.
+const hoursPerMonth = 730
+
+// DryRunReport describes what CA would do in a single dry-run loop.
+type DryRunReport struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	// ScaleUps are the node group size increases pending pods would trigger.
+	ScaleUps []DryRunScaleUp `json:"scaleUps"`
+	// ScaleDowns are the nodes CA considers unneeded. They are removed once they stay
+	// unneeded for --scale-down-unneeded-time.
+	ScaleDowns []DryRunScaleDown `json:"scaleDowns"`
+	// BlockedNodes are the underutilized nodes CA can't remove because of their pods.
+	BlockedNodes []DryRunBlockedNode `json:"blockedNodes"`
+	// Cost is omitted if the cloud provider doesn't know node prices.
+	Cost *DryRunCost `json:"cost,omitempty"`
+}
+
+// DryRunScaleUp is a node group size increase.
+type DryRunScaleUp struct {
+	NodeGroup   string   `json:"nodeGroup"`
+	CurrentSize int      `json:"currentSize"`
+	NewSize     int      `json:"newSize"`
+	Pods        []string `json:"pods"`
+	// MonthlyCost is the cost of the added nodes.
+	MonthlyCost float64 `json:"monthlyCost,omitempty"`
+
+	template *apiv1.Node
+}
+
+// DryRunScaleDown is a removal of an unneeded node.
+type DryRunScaleDown struct {
+	Node        string  `json:"node"`
+	NodeGroup   string  `json:"nodeGroup"`
+	Utilization float64 `json:"utilization"`
+	// PriorityScore orders nodes with equal utilization, lower scores are removed first. Omitted if
+	// --scale-down-candidate-priority-weight is 0.
+	PriorityScore float64 `json:"priorityScore,omitempty"`
+	// MonthlyCost is the cost of the node saved by removing it.
+	MonthlyCost float64 `json:"monthlyCost,omitempty"`
+
+	node *apiv1.Node
+}
+
+// DryRunBlockedNode is an underutilized node that can't be removed because of its pods.
+type DryRunBlockedNode struct {
+	Node      string `json:"node"`
+	NodeGroup string `json:"nodeGroup"`
+	Reason    string `json:"reason"`
+	// MonthlyCost is the cost of the node that would be saved if it could be removed.
+	MonthlyCost float64 `json:"monthlyCost,omitempty"`
+
+	node *apiv1.Node
+}
+
+// DryRunCost sums up the monthly costs of the report.
+type DryRunCost struct {
+	ScaleUpMonthly          float64 `json:"scaleUpMonthly"`
+	ScaleDownMonthlySavings float64 `json:"scaleDownMonthlySavings"`
+	BlockedMonthlySavings   float64 `json:"blockedMonthlySavings"`
+	// MonthlyDelta is the change of the monthly cost of the cluster after the scale-ups and scale-downs.
+	MonthlyDelta float64 `json:"monthlyDelta"`
+}
+
+func newDryRunReport(timestamp time.Time) *DryRunReport {
+	return &DryRunReport{
+		GeneratedAt:  timestamp,
+		ScaleUps:     []DryRunScaleUp{},
+		ScaleDowns:   []DryRunScaleDown{},
+		BlockedNodes: []DryRunBlockedNode{},
+	}
+}
+
+func (r *DryRunReport) addScaleUp(info nodegroupset.ScaleUpInfo, pods []*apiv1.Pod, template *apiv1.Node) {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+	r.ScaleUps = append(r.ScaleUps, DryRunScaleUp{
+		NodeGroup:   info.Group.Id(),
+		CurrentSize: info.CurrentSize,
+		NewSize:     info.NewSize,
+		Pods:        names,
+		template:    template,
+	})
+}
+
+// recordPlannedScaleUp reports a scale-up that was skipped because CA is running in dry-run mode,
+// including the planned size increase in the status.
+func recordPlannedScaleUp(context *AutoscalingContext, info nodegroupset.ScaleUpInfo, pods []*apiv1.Pod, msg string) {
+	delta := info.NewSize - info.CurrentSize
+	recordDryRunAction(context, metrics.DryRunScaleUp, info.Group.Id(), "%s group %s size to %d (increase %d) for pods: %s",
+		msg, info.Group.Id(), info.NewSize, delta, podNames(pods))
+	context.ClusterStateRegistry.RegisterPlannedScaleUp(api.PlannedScaleUp{
+		NodeGroup:   info.Group.Id(),
+		CurrentSize: info.CurrentSize,
+		NewSize:     info.NewSize,
+		Delta:       delta,
+		Pods:        podKeys(pods),
+	})
+}
+
+// RunOnceWithDryRunReport runs a single loop, which must be a dry-run, and reports what CA would do.
+func (a *StaticAutoscaler) RunOnceWithDryRunReport(currentTime time.Time) (*DryRunReport, errors.AutoscalerError) {
+	if !a.DryRun {
+		return nil, errors.NewAutoscalerError(errors.InternalError, "dry-run report requested outside of dry-run mode")
+	}
+	report := newDryRunReport(currentTime)
+	a.AutoscalingContext.DryRunReport = report
+	defer func() { a.AutoscalingContext.DryRunReport = nil }()
+
+	if typedErr := a.RunOnce(currentTime); typedErr != nil {
+		return nil, typedErr
+	}
+	nodes, err := a.AllNodeLister().List()
+	if err != nil {
+		return nil, errors.ToAutoscalerError(errors.ApiCallError, err)
+	}
+	a.scaleDown.addToDryRunReport(report, nodes)
+
+	if pricingModel, err := a.CloudProvider().Pricing(); err == nil {
+		report.estimateCosts(pricingModel, currentTime)
+	}
+	return report, nil
+}
+
+// addToDryRunReport reports the nodes found unneeded and the nodes blocked by their pods in the last
+// UpdateUnneededNodes call.
+func (sd *ScaleDown) addToDryRunReport(report *DryRunReport, nodes []*apiv1.Node) {
+	for _, node := range sd.unneededNodesList {
+		report.ScaleDowns = append(report.ScaleDowns, DryRunScaleDown{
+			Node:          node.Name,
+			NodeGroup:     nodeGroupIdForNode(sd.context.CloudProvider, node),
+			Utilization:   sd.nodeUtilizationMap[node.Name],
+			PriorityScore: sd.nodePriorityScores[node.Name],
+			node:          node,
+		})
+	}
+	for _, node := range nodes {
+		if _, found := sd.unremovableNodes[node.Name]; !found {
+			continue
+		}
+		reason, found := sd.unremovableReasons[node.Name]
+		if !found {
+			continue
+		}
+		blocked := simulator.UnremovableNode{Node: node, Reason: reason}
+		if !blocked.BlockedByPod() {
+			continue
+		}
+		report.BlockedNodes = append(report.BlockedNodes, DryRunBlockedNode{
+			Node:      node.Name,
+			NodeGroup: nodeGroupIdForNode(sd.context.CloudProvider, node),
+			Reason:    string(reason),
+			node:      node,
+		})
+	}
+}
+
+// estimateCosts fills in monthly costs of the report from the current node prices.
+func (r *DryRunReport) estimateCosts(pricingModel cloudprovider.PricingModel, now time.Time) {
+	monthlyCost := func(node *apiv1.Node) float64 {
+		if node == nil {
+			return 0
+		}
+		price, err := pricingModel.NodePrice(node, now, now.Add(time.Hour))
+		if err != nil {
+			glog.Warningf("Failed to get price of node %s: %v", node.Name, err)
+			return 0
+		}
+		return price * hoursPerMonth
+	}
+
+	cost := &DryRunCost{}
+	for i := range r.ScaleUps {
+		scaleUp := &r.ScaleUps[i]
+		scaleUp.MonthlyCost = monthlyCost(scaleUp.template) * float64(scaleUp.NewSize-scaleUp.CurrentSize)
+		cost.ScaleUpMonthly += scaleUp.MonthlyCost
+	}
+	for i := range r.ScaleDowns {
+		scaleDown := &r.ScaleDowns[i]
+		scaleDown.MonthlyCost = monthlyCost(scaleDown.node)
+		cost.ScaleDownMonthlySavings += scaleDown.MonthlyCost
+	}
+	for i := range r.BlockedNodes {
+		blocked := &r.BlockedNodes[i]
+		blocked.MonthlyCost = monthlyCost(blocked.node)
+		cost.BlockedMonthlySavings += blocked.MonthlyCost
+	}
+	cost.MonthlyDelta = cost.ScaleUpMonthly - cost.ScaleDownMonthlySavings
+	r.Cost = cost
+}
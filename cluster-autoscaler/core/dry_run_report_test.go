@@ -0,0 +1,205 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	policyv1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/client-go/kubernetes/fake"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunOnceWithDryRunReport(t *testing.T) {
+	readyNodeListerMock := &nodeListerMock{}
+	allNodeListerMock := &nodeListerMock{}
+	scheduledPodMock := &podListerMock{}
+	unschedulablePodMock := &podListerMock{}
+	podDisruptionBudgetListerMock := &podDisruptionBudgetListerMock{}
+	daemonSetListerMock := &daemonSetListerMock{}
+
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	now := time.Now()
+
+	// Highly utilized node.
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, now)
+	p1 := BuildTestPod("p1", 600, 100)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.NodeName = "n1"
+	// Unneeded node, its pod fits on n1.
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, now)
+	p2 := BuildTestPod("p2", 100, 100)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+	// Underutilized node blocked by a pod without a controller.
+	n3 := BuildTestNode("n3", 1000, 1000)
+	SetNodeReadyState(n3, true, now)
+	p3 := BuildTestPod("p3", 100, 100)
+	p3.Spec.NodeName = "n3"
+	// Pending pod that doesn't fit on any node.
+	p4 := BuildTestPod("p4", 950, 100)
+
+	tn := BuildTestNode("tn", 1000, 1000)
+	tni := schedulercache.NewNodeInfo()
+	tni.SetNode(tn)
+
+	provider := testprovider.NewTestAutoprovisioningCloudProvider(
+		func(id string, delta int) error {
+			return fmt.Errorf("unexpected scale-up of %s", id)
+		}, func(id string, name string) error {
+			return fmt.Errorf("unexpected scale-down of %s", name)
+		},
+		nil, nil,
+		nil, map[string]*schedulercache.NodeInfo{"ng1": tni, "ng2": tni})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNodeGroup("ng2", 0, 1, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng2", n3)
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{
+		OkTotalUnreadyCount:  1,
+		MaxNodeProvisionTime: 10 * time.Second,
+	}, fakeLogRecorder)
+	clusterState.UpdateNodes([]*apiv1.Node{n1, n2, n3}, now)
+
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:                 estimator.BinpackingEstimatorName,
+			ScaleDownEnabled:              true,
+			ScaleDownUtilizationThreshold: 0.5,
+			MaxNodesTotal:                 10,
+			MaxCoresTotal:                 10,
+			MaxMemoryTotal:                100000,
+			ScaleDownUnreadyTime:          time.Minute,
+			ScaleDownUnneededTime:         time.Minute,
+			DryRun:                        true,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             &kube_record.FakeRecorder{},
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+	listerRegistry := kube_util.NewListerRegistry(allNodeListerMock, readyNodeListerMock, scheduledPodMock,
+		unschedulablePodMock, podDisruptionBudgetListerMock, daemonSetListerMock)
+	autoscaler := &StaticAutoscaler{AutoscalingContext: context,
+		ListerRegistry:        listerRegistry,
+		lastScaleUpTime:       now,
+		lastScaleDownFailTime: now,
+		scaleDown:             NewScaleDown(context)}
+
+	readyNodeListerMock.On("List").Return([]*apiv1.Node{n1, n2, n3}, nil).Once()
+	allNodeListerMock.On("List").Return([]*apiv1.Node{n1, n2, n3}, nil).Twice()
+	scheduledPodMock.On("List").Return([]*apiv1.Pod{p1, p2, p3}, nil).Once()
+	unschedulablePodMock.On("List").Return([]*apiv1.Pod{p4}, nil).Once()
+	podDisruptionBudgetListerMock.On("List").Return([]*policyv1.PodDisruptionBudget{}, nil).Once()
+	daemonSetListerMock.On("List").Return([]*extensionsv1.DaemonSet{}, nil).Once()
+
+	report, err := autoscaler.RunOnceWithDryRunReport(now)
+	assert.NoError(t, err)
+	mock.AssertExpectationsForObjects(t, readyNodeListerMock, allNodeListerMock, scheduledPodMock, unschedulablePodMock,
+		podDisruptionBudgetListerMock, daemonSetListerMock)
+	assert.Nil(t, context.DryRunReport)
+
+	assert.Equal(t, 1, len(report.ScaleUps))
+	assert.Equal(t, 1, len(report.ScaleDowns))
+	assert.Equal(t, 1, len(report.BlockedNodes))
+	// The test cloud provider has no pricing model.
+	assert.Nil(t, report.Cost)
+
+	serialized, jsonErr := json.Marshal(report)
+	assert.NoError(t, jsonErr)
+	var parsed map[string]interface{}
+	assert.NoError(t, json.Unmarshal(serialized, &parsed))
+	assert.Contains(t, parsed, "generatedAt")
+	assert.NotContains(t, parsed, "cost")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"nodeGroup":   "ng1",
+		"currentSize": 2.0,
+		"newSize":     3.0,
+		"pods":        []interface{}{"default/p4"},
+	}}, parsed["scaleUps"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"node":        "n2",
+		"nodeGroup":   "ng1",
+		"utilization": 0.1,
+	}}, parsed["scaleDowns"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"node":      "n3",
+		"nodeGroup": "ng2",
+		"reason":    string(drain.NotReplicated),
+	}}, parsed["blockedNodes"])
+
+	context.DryRun = false
+	_, err = autoscaler.RunOnceWithDryRunReport(now)
+	assert.Error(t, err)
+}
+
+func TestDryRunReportCosts(t *testing.T) {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	ng1 := provider.NodeGroups()[0]
+	template := BuildTestNode("template", 1000, 1000)
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+
+	report := newDryRunReport(time.Now())
+	report.addScaleUp(nodegroupset.ScaleUpInfo{Group: ng1, CurrentSize: 1, NewSize: 3}, []*apiv1.Pod{}, template)
+	report.ScaleDowns = []DryRunScaleDown{{Node: "n1", node: n1}, {Node: "n2", node: n2}}
+	report.BlockedNodes = []DryRunBlockedNode{{Node: "n3", node: n3}}
+	report.estimateCosts(&testNodePricingModel{nodePrice: map[string]float64{
+		"template": 0.1,
+		"n1":       0.2,
+		"n2":       0.3,
+		"n3":       1.0,
+	}}, time.Now())
+
+	assert.InDelta(t, 146.0, report.ScaleUps[0].MonthlyCost, 0.001)
+	assert.InDelta(t, 146.0, report.ScaleDowns[0].MonthlyCost, 0.001)
+	assert.InDelta(t, 146.0, report.Cost.ScaleUpMonthly, 0.001)
+	assert.InDelta(t, 365.0, report.Cost.ScaleDownMonthlySavings, 0.001)
+	assert.InDelta(t, 730.0, report.Cost.BlockedMonthlySavings, 0.001)
+	assert.InDelta(t, -219.0, report.Cost.MonthlyDelta, 0.001)
+}
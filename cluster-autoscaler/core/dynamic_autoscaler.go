@@ -62,6 +62,11 @@ func (a *DynamicAutoscaler) ExitCleanUp() {
 	a.autoscaler.ExitCleanUp()
 }
 
+// LastLoopActive checks if the last loop of the wrapped autoscaler had anything to do
+func (a *DynamicAutoscaler) LastLoopActive() bool {
+	return a.autoscaler.LastLoopActive()
+}
+
 // RunOnce represents a single iteration of a dynamic autoscaler inside the CA's control-loop
 func (a *DynamicAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError {
 	reconfigureStart := time.Now()
@@ -47,6 +47,11 @@ func (m *AutoscalerMock) ExitCleanUp() {
 	m.Called()
 }
 
+func (m *AutoscalerMock) LastLoopActive() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
 type ConfigFetcherMock struct {
 	mock.Mock
 }
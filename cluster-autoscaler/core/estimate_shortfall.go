@@ -0,0 +1,79 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/golang/glog"
+)
+
+// EstimateShortfallTracker finds scale-ups that added fewer nodes than their pods needed. It remembers
+// pods each node group was scaled up for, and once no more nodes of the group are coming, checks if
+// any of them still doesn't fit on existing nodes.
+type EstimateShortfallTracker struct {
+	pods map[string]map[string]bool
+}
+
+// NewEstimateShortfallTracker builds an EstimateShortfallTracker.
+func NewEstimateShortfallTracker() *EstimateShortfallTracker {
+	return &EstimateShortfallTracker{
+		pods: make(map[string]map[string]bool),
+	}
+}
+
+// RegisterScaleUp remembers pods the node group was scaled up for.
+func (t *EstimateShortfallTracker) RegisterScaleUp(nodeGroup string, pods []*apiv1.Pod) {
+	if t.pods[nodeGroup] == nil {
+		t.pods[nodeGroup] = make(map[string]bool)
+	}
+	for _, pod := range pods {
+		t.pods[nodeGroup][estimateShortfallPodKey(pod)] = true
+	}
+}
+
+// Update checks scale-ups of node groups without upcoming nodes against pods that still don't fit on
+// existing nodes, and forgets them. Returns node groups whose scale-up fell short.
+func (t *EstimateShortfallTracker) Update(upcomingNodes map[string]int, unschedulablePods []*apiv1.Pod) []string {
+	result := make([]string, 0)
+	for nodeGroup, pods := range t.pods {
+		if upcomingNodes[nodeGroup] > 0 {
+			continue
+		}
+		delete(t.pods, nodeGroup)
+		pending := 0
+		for _, pod := range unschedulablePods {
+			if pods[estimateShortfallPodKey(pod)] {
+				pending++
+			}
+		}
+		if pending > 0 {
+			glog.V(1).Infof("Scale-up of %s fell short of the estimate: %d of %d pods still don't fit", nodeGroup, pending, len(pods))
+			metrics.RegisterEstimateShortfall()
+			result = append(result, nodeGroup)
+		}
+	}
+	return result
+}
+
+func estimateShortfallPodKey(pod *apiv1.Pod) string {
+	return fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateShortfallTracker(t *testing.T) {
+	p1 := BuildTestPod("p1", 500, 0)
+	p2 := BuildTestPod("p2", 500, 0)
+	p3 := BuildTestPod("p3", 500, 0)
+	tracker := NewEstimateShortfallTracker()
+	tracker.RegisterScaleUp("ng1", []*apiv1.Pod{p1, p2})
+	tracker.RegisterScaleUp("ng2", []*apiv1.Pod{p3})
+
+	// Nodes of ng1 are still coming, ng2 scale-up helped its pod.
+	assert.Empty(t, tracker.Update(map[string]int{"ng1": 1}, []*apiv1.Pod{p1, p2}))
+	// ng1 nodes are ready, but p2 still doesn't fit.
+	assert.Equal(t, []string{"ng1"}, tracker.Update(map[string]int{}, []*apiv1.Pod{p2}))
+	// Finished scale-ups are checked once.
+	assert.Empty(t, tracker.Update(map[string]int{}, []*apiv1.Pod{p2}))
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+
+	apiv1 "k8s.io/api/core/v1"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// zonalLabels are node labels that differ between templates of per-zone node groups.
+var zonalLabels = map[string]bool{
+	kubeletapis.LabelHostname:          true,
+	kubeletapis.LabelZoneFailureDomain: true,
+	kubeletapis.LabelZoneRegion:        true,
+}
+
+// estimationCache reuses node count estimates between expansion options of node groups with
+// equivalent templates, such as per-zone node groups balanced with BalanceSimilarNodeGroups. The
+// estimate depends only on the pods, the template and the upcoming nodes, which are the same for all
+// options of a scale-up, so a cache is built for a single scale-up.
+type estimationCache struct {
+	estimates map[string]estimationResult
+	// estimateNodeCount is estimateNodeCount, replaceable in tests.
+	estimateNodeCount func(context *AutoscalingContext, pods []*apiv1.Pod, nodeInfo *schedulercache.NodeInfo,
+		upcomingNodes []*schedulercache.NodeInfo) (int, string, []estimator.PodPlacement)
+}
+
+type estimationResult struct {
+	nodeCount int
+	debug     string
+	trace     []estimator.PodPlacement
+}
+
+func newEstimationCache() *estimationCache {
+	return &estimationCache{
+		estimates:         make(map[string]estimationResult),
+		estimateNodeCount: estimateNodeCount,
+	}
+}
+
+// estimate returns the number of nodes needed for the pods, reusing the estimate for the same pods on
+// an equivalent template. Pods with zonal constraints may need a different number of nodes in each
+// zone, so they are always estimated.
+func (c *estimationCache) estimate(context *AutoscalingContext, pods []*apiv1.Pod, nodeInfo *schedulercache.NodeInfo,
+	upcomingNodes []*schedulercache.NodeInfo) (int, string, []estimator.PodPlacement) {
+	for _, pod := range pods {
+		if hasZonalConstraints(pod) {
+			return c.estimateNodeCount(context, pods, nodeInfo, upcomingNodes)
+		}
+	}
+	key := templateFingerprint(nodeInfo) + "|" + podNames(pods)
+	if result, found := c.estimates[key]; found {
+		glog.V(4).Infof("Reusing estimate of %d nodes for template %s", result.nodeCount, nodeInfo.Node().Name)
+		return result.nodeCount, result.debug, result.trace
+	}
+	nodeCount, debug, trace := c.estimateNodeCount(context, pods, nodeInfo, upcomingNodes)
+	c.estimates[key] = estimationResult{nodeCount: nodeCount, debug: debug, trace: trace}
+	return nodeCount, debug, trace
+}
+
+// templateFingerprint describes what binpacking pods on the template depends on: its capacity,
+// allocatable, resources requested by its pods, labels other than zonal ones and taints.
+func templateFingerprint(nodeInfo *schedulercache.NodeInfo) string {
+	node := nodeInfo.Node()
+	parts := make([]string, 0)
+	for name, quantity := range node.Status.Capacity {
+		parts = append(parts, fmt.Sprintf("capacity:%s=%s", name, quantity.String()))
+	}
+	for name, quantity := range node.Status.Allocatable {
+		parts = append(parts, fmt.Sprintf("allocatable:%s=%s", name, quantity.String()))
+	}
+	requested := nodeInfo.RequestedResource()
+	for name, quantity := range (&requested).ResourceList() {
+		parts = append(parts, fmt.Sprintf("requested:%s=%s", name, quantity.String()))
+	}
+	for key, value := range node.Labels {
+		if !zonalLabels[key] {
+			parts = append(parts, fmt.Sprintf("label:%s=%s", key, value))
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		parts = append(parts, fmt.Sprintf("taint:%s=%s:%s", taint.Key, taint.Value, taint.Effect))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// hasZonalConstraints returns true if the pod selects nodes by zonal labels, has affinity or
+// anti-affinity on a zonal topology or uses volumes bound to a zone.
+func hasZonalConstraints(pod *apiv1.Pod) bool {
+	for key := range pod.Spec.NodeSelector {
+		if zonalLabels[key] {
+			return true
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil || volume.GCEPersistentDisk != nil || volume.AWSElasticBlockStore != nil {
+			return true
+		}
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		return false
+	}
+	if affinity.NodeAffinity != nil {
+		terms := make([]apiv1.NodeSelectorTerm, 0)
+		if required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+			terms = append(terms, required.NodeSelectorTerms...)
+		}
+		for _, preferred := range affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, preferred.Preference)
+		}
+		for _, term := range terms {
+			for _, requirement := range term.MatchExpressions {
+				if zonalLabels[requirement.Key] {
+					return true
+				}
+			}
+		}
+	}
+	podTerms := make([]apiv1.PodAffinityTerm, 0)
+	if affinity.PodAffinity != nil {
+		podTerms = append(podTerms, affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, weighted := range affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			podTerms = append(podTerms, weighted.PodAffinityTerm)
+		}
+	}
+	if affinity.PodAntiAffinity != nil {
+		podTerms = append(podTerms, affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, weighted := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			podTerms = append(podTerms, weighted.PodAffinityTerm)
+		}
+	}
+	for _, term := range podTerms {
+		if zonalLabels[term.TopologyKey] && term.TopologyKey != kubeletapis.LabelHostname {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildZoneTemplate(name, zone string, pods ...*apiv1.Pod) *schedulercache.NodeInfo {
+	node := BuildTestNode(name, 4000, 4000*MB)
+	node.Labels[kubeletapis.LabelHostname] = name
+	node.Labels[kubeletapis.LabelZoneFailureDomain] = zone
+	node.Labels["pool"] = "default"
+	nodeInfo := schedulercache.NewNodeInfo(pods...)
+	nodeInfo.SetNode(node)
+	return nodeInfo
+}
+
+func TestTemplateFingerprint(t *testing.T) {
+	a := buildZoneTemplate("a", "zone-a")
+	b := buildZoneTemplate("b", "zone-b")
+	assert.Equal(t, templateFingerprint(a), templateFingerprint(b))
+
+	labeled := buildZoneTemplate("labeled", "zone-b")
+	labeled.Node().Labels["pool"] = "other"
+	assert.NotEqual(t, templateFingerprint(a), templateFingerprint(labeled))
+
+	tainted := buildZoneTemplate("tainted", "zone-b")
+	tainted.Node().Spec.Taints = []apiv1.Taint{{Key: "dedicated", Value: "gpu", Effect: apiv1.TaintEffectNoSchedule}}
+	assert.NotEqual(t, templateFingerprint(a), templateFingerprint(tainted))
+
+	withDaemonSet := buildZoneTemplate("ds", "zone-b", BuildTestPod("ds", 500, 0))
+	assert.NotEqual(t, templateFingerprint(a), templateFingerprint(withDaemonSet))
+}
+
+func TestEstimationCache(t *testing.T) {
+	templates := []*schedulercache.NodeInfo{
+		buildZoneTemplate("a", "zone-a"),
+		buildZoneTemplate("b", "zone-b"),
+		buildZoneTemplate("c", "zone-c"),
+	}
+	p1 := BuildTestPod("p1", 1000, 0)
+	p2 := BuildTestPod("p2", 1000, 0)
+	zonal := BuildTestPod("zonal", 1000, 0)
+	zonal.Spec.Affinity = &apiv1.Affinity{
+		PodAntiAffinity: &apiv1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.WeightedPodAffinityTerm{{
+				Weight:          1,
+				PodAffinityTerm: apiv1.PodAffinityTerm{TopologyKey: kubeletapis.LabelZoneFailureDomain},
+			}},
+		},
+	}
+
+	estimated := 0
+	cache := newEstimationCache()
+	cache.estimateNodeCount = func(context *AutoscalingContext, pods []*apiv1.Pod, nodeInfo *schedulercache.NodeInfo,
+		upcomingNodes []*schedulercache.NodeInfo) (int, string, []estimator.PodPlacement) {
+		estimated++
+		return len(pods), "", nil
+	}
+
+	for _, template := range templates {
+		nodeCount, _, _ := cache.estimate(nil, []*apiv1.Pod{p1, p2}, template, nil)
+		assert.Equal(t, 2, nodeCount)
+	}
+	assert.Equal(t, 1, estimated)
+
+	// Other pods need their own estimate.
+	nodeCount, _, _ := cache.estimate(nil, []*apiv1.Pod{p1}, templates[0], nil)
+	assert.Equal(t, 1, nodeCount)
+	assert.Equal(t, 2, estimated)
+
+	// Pods with zonal constraints are estimated for every template.
+	for _, template := range templates {
+		cache.estimate(nil, []*apiv1.Pod{p1, zonal}, template, nil)
+	}
+	assert.Equal(t, 5, estimated)
+}
+
+func TestHasZonalConstraints(t *testing.T) {
+	assert.False(t, hasZonalConstraints(BuildTestPod("plain", 100, 0)))
+
+	selector := BuildTestPod("selector", 100, 0)
+	selector.Spec.NodeSelector = map[string]string{kubeletapis.LabelZoneFailureDomain: "zone-a"}
+	assert.True(t, hasZonalConstraints(selector))
+
+	nodeAffinity := BuildTestPod("node-affinity", 100, 0)
+	nodeAffinity.Spec.Affinity = &apiv1.Affinity{
+		NodeAffinity: &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{{
+					MatchExpressions: []apiv1.NodeSelectorRequirement{{
+						Key:      kubeletapis.LabelZoneRegion,
+						Operator: apiv1.NodeSelectorOpIn,
+						Values:   []string{"region"},
+					}},
+				}},
+			},
+		},
+	}
+	assert.True(t, hasZonalConstraints(nodeAffinity))
+
+	hostAntiAffinity := BuildTestPod("host-anti-affinity", 100, 0)
+	hostAntiAffinity.Spec.Affinity = &apiv1.Affinity{
+		PodAntiAffinity: &apiv1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []apiv1.PodAffinityTerm{{TopologyKey: kubeletapis.LabelHostname}},
+		},
+	}
+	assert.False(t, hasZonalConstraints(hostAntiAffinity))
+
+	volume := BuildTestPod("volume", 100, 0)
+	volume.Spec.Volumes = []apiv1.Volume{{
+		Name:         "data",
+		VolumeSource: apiv1.VolumeSource{PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+	}}
+	assert.True(t, hasZonalConstraints(volume))
+}
+
+func BenchmarkEstimateSimilarNodeGroups(b *testing.B) {
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{EstimatorName: estimator.BinpackingEstimatorName},
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+	}
+	templates := make([]*schedulercache.NodeInfo, 0)
+	for i := 0; i < 12; i++ {
+		templates = append(templates, buildZoneTemplate(fmt.Sprintf("ng-%d", i), fmt.Sprintf("zone-%d", i%3)))
+	}
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 100; i++ {
+		pods = append(pods, BuildTestPod(fmt.Sprintf("p-%d", i), 300, 200*MB))
+	}
+
+	for _, dedup := range []bool{false, true} {
+		b.Run(fmt.Sprintf("dedup-%v", dedup), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cache := newEstimationCache()
+				for _, template := range templates {
+					if dedup {
+						cache.estimate(context, pods, template, nil)
+					} else {
+						estimateNodeCount(context, pods, template, nil)
+					}
+				}
+			}
+		})
+	}
+}
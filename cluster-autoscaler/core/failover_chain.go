@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+
+	"github.com/golang/glog"
+)
+
+// failoverChainPosition returns the name of the failover chain the node group belongs to and
+// its position in the chain, 0 being the most preferred group.
+func failoverChainPosition(context *AutoscalingContext, nodeGroupId string) (string, int, bool) {
+	chain, found := context.NodeGroupConfigProcessor.GetFailoverChain(nodeGroupId)
+	if !found {
+		return "", 0, false
+	}
+	for i, id := range chain.NodeGroups {
+		if id == nodeGroupId {
+			return chain.Name, i, true
+		}
+	}
+	return "", 0, false
+}
+
+// preferFailoverChainOptions drops expansion options of node groups that have a more preferred
+// group of their failover chain among the options, helping the same pods. Node groups that are
+// backed off, at max size or don't fit the pods are never options, so the next group of the chain
+// takes over until they recover.
+func preferFailoverChainOptions(context *AutoscalingContext, options []expander.Option) []expander.Option {
+	result := make([]expander.Option, 0, len(options))
+	for _, option := range options {
+		chainName, position, found := failoverChainPosition(context, option.NodeGroup.Id())
+		preferred := ""
+		if found && position > 0 {
+			for _, other := range options {
+				otherChain, otherPosition, otherFound := failoverChainPosition(context, other.NodeGroup.Id())
+				if otherFound && otherChain == chainName && otherPosition < position && containsAllPods(other.Pods, option.Pods) {
+					preferred = other.NodeGroup.Id()
+					break
+				}
+			}
+		}
+		if preferred != "" {
+			glog.V(2).Infof("Skipping node group %s - %s is preferred in failover chain %s", option.NodeGroup.Id(), preferred, chainName)
+			continue
+		}
+		result = append(result, option)
+	}
+	return result
+}
+
+// containsAllPods returns true if all pods are in the superset.
+func containsAllPods(superset []*apiv1.Pod, pods []*apiv1.Pod) bool {
+	for _, pod := range pods {
+		if !containsPod(superset, pod) {
+			return false
+		}
+	}
+	return true
+}
+
+// preferFailoverNodesForScaleDown moves nodes of fallback node groups in front of other scale-down
+// candidates when a more preferred group of their failover chain can be scaled up again, so that
+// capacity returns to the preferred group.
+func preferFailoverNodesForScaleDown(context *AutoscalingContext, candidates []*apiv1.Node, now time.Time) []*apiv1.Node {
+	fallback := make(map[string]bool)
+	for _, node := range candidates {
+		nodeGroup, err := context.CloudProvider.NodeGroupForNode(node)
+		if err != nil || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		chain, found := context.NodeGroupConfigProcessor.GetFailoverChain(nodeGroup.Id())
+		if !found {
+			continue
+		}
+		for _, id := range chain.NodeGroups {
+			if id == nodeGroup.Id() {
+				break
+			}
+			if isFailoverTargetAvailable(context, id, now) {
+				fallback[node.Name] = true
+				break
+			}
+		}
+	}
+	if len(fallback) == 0 {
+		return candidates
+	}
+	result := make([]*apiv1.Node, len(candidates))
+	copy(result, candidates)
+	sort.SliceStable(result, func(i, j int) bool {
+		return fallback[result[i].Name] && !fallback[result[j].Name]
+	})
+	return result
+}
+
+// isFailoverTargetAvailable returns true if the node group can be scaled up.
+func isFailoverTargetAvailable(context *AutoscalingContext, nodeGroupId string, now time.Time) bool {
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		if nodeGroup.Id() != nodeGroupId {
+			continue
+		}
+		if !context.ClusterStateRegistry.IsNodeGroupSafeToScaleUp(nodeGroupId, now) {
+			return false
+		}
+		targetSize, err := nodeGroup.TargetSize()
+		return err == nil && targetSize < nodeGroup.MaxSize()
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFailoverChainConfig = `
+failoverChains:
+- name: workers
+  nodeGroups: [spot, ondemand]
+`
+
+// buildFailoverChainTest builds a context with spot and ondemand node groups in a failover chain,
+// one node each. Size increases are sent to the returned channel.
+func buildFailoverChainTest(t *testing.T, dir string, spotMaxSize int) (*AutoscalingContext, []*apiv1.Node, chan string) {
+	path := filepath.Join(dir, "config.yaml")
+	writeTestConfigFile(t, path, testFailoverChainConfig)
+	processor, err := NewNodeGroupConfigProcessor(path, nil)
+	assert.NoError(t, err)
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	nodes := make([]*apiv1.Node, 0)
+	for _, nodeGroup := range []string{"spot", "ondemand"} {
+		node := BuildTestNode(nodeGroup+"-1", 1000, 1000)
+		SetNodeReadyState(node, true, time.Now())
+		maxSize := 10
+		if nodeGroup == "spot" {
+			maxSize = spotMaxSize
+		}
+		provider.AddNodeGroup(nodeGroup, 1, maxSize, 1)
+		provider.AddNode(nodeGroup, node)
+		nodes = append(nodes, node)
+	}
+
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+		},
+		PredicateChecker:         simulator.NewTestPredicateChecker(),
+		CloudProvider:            provider,
+		ClientSet:                fakeClient,
+		Recorder:                 kube_record.NewFakeRecorder(10),
+		ExpanderStrategy:         random.NewStrategy(),
+		ClusterStateRegistry:     clusterState,
+		LogRecorder:              fakeLogRecorder,
+		NodeGroupConfigProcessor: processor,
+	}
+	return context, nodes, sizeChanges
+}
+
+func TestScaleUpFailoverChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-failover")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The preferred group is used while it's healthy.
+	context, nodes, sizeChanges := buildFailoverChainTest(t, dir, 10)
+	scaledUp, typedErr := ScaleUp(context, []*apiv1.Pod{BuildTestPod("p-new", 800, 0)}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, typedErr)
+	assert.True(t, scaledUp)
+	assert.Equal(t, "spot-1", getStringFromChan(sizeChanges))
+
+	// Out of capacity, the preferred group is backed off and scale-up fails over.
+	context, nodes, sizeChanges = buildFailoverChainTest(t, dir, 10)
+	context.ClusterStateRegistry.RegisterFailedScaleUp("spot", metrics.Timeout)
+	scaledUp, typedErr = ScaleUp(context, []*apiv1.Pod{BuildTestPod("p-new", 800, 0)}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, typedErr)
+	assert.True(t, scaledUp)
+	assert.Equal(t, "ondemand-1", getStringFromChan(sizeChanges))
+
+	// The preferred group reached its max size.
+	context, nodes, sizeChanges = buildFailoverChainTest(t, dir, 1)
+	scaledUp, typedErr = ScaleUp(context, []*apiv1.Pod{BuildTestPod("p-new", 800, 0)}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, typedErr)
+	assert.True(t, scaledUp)
+	assert.Equal(t, "ondemand-1", getStringFromChan(sizeChanges))
+}
+
+func TestPreferFailoverChainOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-failover")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	context, _, _ := buildFailoverChainTest(t, dir, 10)
+	provider := context.CloudProvider.(*testprovider.TestCloudProvider)
+	provider.AddNodeGroup("other", 1, 10, 1)
+
+	p1 := BuildTestPod("p1", 100, 0)
+	p2 := BuildTestPod("p2", 100, 0)
+	spot := expander.Option{NodeGroup: getTestNodeGroup(provider, "spot"), Pods: []*apiv1.Pod{p1}}
+	ondemand := expander.Option{NodeGroup: getTestNodeGroup(provider, "ondemand"), Pods: []*apiv1.Pod{p1}}
+	other := expander.Option{NodeGroup: getTestNodeGroup(provider, "other"), Pods: []*apiv1.Pod{p1}}
+
+	assert.Equal(t, []expander.Option{spot, other}, preferFailoverChainOptions(context, []expander.Option{ondemand, spot, other}))
+	assert.Equal(t, []expander.Option{ondemand, other}, preferFailoverChainOptions(context, []expander.Option{ondemand, other}))
+
+	// Pods that don't fit the preferred group can still use the fallback.
+	ondemand.Pods = []*apiv1.Pod{p1, p2}
+	assert.Equal(t, []expander.Option{ondemand, spot}, preferFailoverChainOptions(context, []expander.Option{ondemand, spot}))
+}
+
+func TestPreferFailoverNodesForScaleDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-failover")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	now := time.Now()
+
+	// Capacity returned to the preferred group, nodes of the fallback group are removed first.
+	context, nodes, _ := buildFailoverChainTest(t, dir, 10)
+	spotNode, ondemandNode := nodes[0], nodes[1]
+	other := BuildTestNode("other-1", 1000, 1000)
+	assert.Equal(t, []*apiv1.Node{ondemandNode, spotNode, other},
+		preferFailoverNodesForScaleDown(context, []*apiv1.Node{spotNode, other, ondemandNode}, now))
+
+	// The preferred group is still out of capacity.
+	context.ClusterStateRegistry.RegisterFailedScaleUp("spot", metrics.Timeout)
+	assert.Equal(t, []*apiv1.Node{spotNode, other, ondemandNode},
+		preferFailoverNodesForScaleDown(context, []*apiv1.Node{spotNode, other, ondemandNode}, now))
+
+	// The preferred group can't grow.
+	context, nodes, _ = buildFailoverChainTest(t, dir, 1)
+	assert.Equal(t, nodes, preferFailoverNodesForScaleDown(context, nodes, now))
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ForceNodeGroupAnnotationKey is the annotation on a pending pod naming the node group that
+	// should be expanded for it. It is a break-glass mechanism for incidents: the expander and
+	// node group backoff are bypassed, but max node group size and cluster-wide limits are not.
+	ForceNodeGroupAnnotationKey = "cluster-autoscaler.kubernetes.io/force-node-group"
+)
+
+// splitForcedPods groups pods annotated with ForceNodeGroupAnnotationKey by the requested node group.
+func splitForcedPods(pods []*apiv1.Pod) (map[string][]*apiv1.Pod, []*apiv1.Pod) {
+	forced := make(map[string][]*apiv1.Pod)
+	other := make([]*apiv1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if nodeGroupId := pod.Annotations[ForceNodeGroupAnnotationKey]; nodeGroupId != "" {
+			forced[nodeGroupId] = append(forced[nodeGroupId], pod)
+		} else {
+			other = append(other, pod)
+		}
+	}
+	return forced, other
+}
+
+// forceScaleUp expands node groups requested by ForceNodeGroupAnnotationKey on the unschedulable pods.
+// At most one node group is expanded per call. Returns true if a scale-up was performed and the pods
+// that should go through the regular scale-up, which includes pods requesting node groups that don't
+// exist, are at max size or can't run them.
+func forceScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes []*apiv1.Node,
+	nodeInfos map[string]*schedulercache.NodeInfo, upcomingNodes []*schedulercache.NodeInfo,
+	coresTotal, memoryTotal int64, resourceLimiter *cloudprovider.ResourceLimiter) (bool, []*apiv1.Pod, errors.AutoscalerError) {
+
+	forcedPods, remainingPods := splitForcedPods(unschedulablePods)
+	if len(forcedPods) == 0 {
+		return false, unschedulablePods, nil
+	}
+	nodeGroups := make(map[string]cloudprovider.NodeGroup)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		nodeGroups[nodeGroup.Id()] = nodeGroup
+	}
+	nodeGroupIds := make([]string, 0, len(forcedPods))
+	for nodeGroupId := range forcedPods {
+		nodeGroupIds = append(nodeGroupIds, nodeGroupId)
+	}
+	sort.Strings(nodeGroupIds)
+
+	for _, nodeGroupId := range nodeGroupIds {
+		pods := forcedPods[nodeGroupId]
+		nodeGroup, found := nodeGroups[nodeGroupId]
+		if !found || !nodeGroup.Exist() {
+			ignoreForcedScaleUp(context, pods, nodeGroupId, "node group doesn't exist")
+			remainingPods = append(remainingPods, pods...)
+			continue
+		}
+		currentTargetSize, err := nodeGroup.TargetSize()
+		if err != nil {
+			glog.Errorf("Failed to get node group size: %v", err)
+			remainingPods = append(remainingPods, pods...)
+			continue
+		}
+		if currentTargetSize >= nodeGroup.MaxSize() {
+			ignoreForcedScaleUp(context, pods, nodeGroupId, "max size reached")
+			remainingPods = append(remainingPods, pods...)
+			continue
+		}
+		nodeInfo, found := nodeInfos[nodeGroupId]
+		if !found {
+			glog.Errorf("No node info for: %s", nodeGroupId)
+			remainingPods = append(remainingPods, pods...)
+			continue
+		}
+		fittingPods := make([]*apiv1.Pod, 0, len(pods))
+		for _, pod := range pods {
+			if err := context.PredicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnVerboseError); err == nil {
+				fittingPods = append(fittingPods, pod)
+			} else {
+				glog.V(2).Infof("Forced scale-up predicate failed: %v", err)
+				remainingPods = append(remainingPods, pod)
+			}
+		}
+		if len(fittingPods) == 0 {
+			ignoreForcedScaleUp(context, pods, nodeGroupId, "pods wouldn't fit on a new node")
+			continue
+		}
+
+		newNodes, _, _ := estimateNodeCount(context, fittingPods, nodeInfo, upcomingNodes)
+		if newNodes == 0 {
+			glog.V(2).Infof("No need for any nodes in %s for forced pods", nodeGroupId)
+			continue
+		}
+		if context.MaxNodesTotal > 0 && len(nodes)+newNodes > context.MaxNodesTotal {
+			glog.V(1).Infof("Capping size to max cluster total size (%d)", context.MaxNodesTotal)
+			newNodes = context.MaxNodesTotal - len(nodes)
+			if newNodes < 1 {
+				return false, remainingPods, errors.NewAutoscalerError(
+					errors.TransientError,
+					"max node total count already reached")
+			}
+		}
+		newNodes, typedErr := applyMaxClusterCoresMemoryLimits(newNodes, coresTotal, memoryTotal,
+			resourceLimiter.GetMax(cloudprovider.ResourceNameCores), resourceLimiter.GetMax(cloudprovider.ResourceNameMemory), nodeInfo)
+		if typedErr != nil {
+			return false, remainingPods, typedErr
+		}
+		scaleUpInfos, typedErr := nodegroupset.BalanceScaleUpBetweenGroups([]cloudprovider.NodeGroup{nodeGroup}, newNodes)
+		if typedErr != nil {
+			return false, remainingPods, typedErr
+		}
+		info := scaleUpInfos[0]
+
+		glog.Warningf("Forcing scale-up of node group %s to %d nodes for pods %s, requested with %s annotation. Expander and node group backoff are bypassed.",
+			nodeGroupId, info.NewSize, podNames(fittingPods), ForceNodeGroupAnnotationKey)
+		if context.DryRun {
+			recordPlannedScaleUp(context, info, fittingPods, "would force")
+			return true, remainingPods, nil
+		}
+		if !applyScaleUpRateLimit(context, &info) {
+			return false, remainingPods, nil
+		}
+		context.LogRecorder.Eventf(apiv1.EventTypeWarning, "ForcedScaleUp",
+			"Forced scale-up of group %s to %d nodes requested by pods: %s", nodeGroupId, info.NewSize, podNames(fittingPods))
+		if typedErr := executeScaleUp(context, info, fittingPods); typedErr != nil {
+			return false, remainingPods, typedErr
+		}
+		for _, pod := range fittingPods {
+			context.Recorder.Eventf(pod, apiv1.EventTypeNormal, "TriggeredScaleUp",
+				"pod forced scale-up: %v", []nodegroupset.ScaleUpInfo{info})
+		}
+		context.ClusterStateRegistry.Recalculate()
+		return true, remainingPods, nil
+	}
+	return false, remainingPods, nil
+}
+
+func ignoreForcedScaleUp(context *AutoscalingContext, pods []*apiv1.Pod, nodeGroupId string, reason string) {
+	glog.Warningf("Ignoring forced scale-up of node group %s for pods %s: %s", nodeGroupId, podNames(pods), reason)
+	for _, pod := range pods {
+		context.Recorder.Eventf(pod, apiv1.EventTypeWarning, "ForcedScaleUpIgnored",
+			"forced scale-up of node group %s ignored: %s", nodeGroupId, reason)
+	}
+}
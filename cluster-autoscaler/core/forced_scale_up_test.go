@@ -0,0 +1,152 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runForcedScaleUpTest runs scale-up for pod p-new requesting ng2, with ng2 in backoff and ng1
+// being able to run the pod as well. Returns the scale-up result, node group size changes and events.
+func runForcedScaleUpTest(t *testing.T, forceEnabled bool, ng2MaxSize int) (bool, []string, []string) {
+	fakeClient := &fake.Clientset{}
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Now())
+	n2 := BuildTestNode("n2", 2000, 1000)
+	SetNodeReadyState(n2, true, time.Now())
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, ng2MaxSize, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes([]*apiv1.Node{n1, n2}, time.Now())
+	clusterState.RegisterFailedScaleUp("ng2", metrics.APIError)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:                   estimator.BinpackingEstimatorName,
+			MaxCoresTotal:                   config.DefaultMaxClusterCores,
+			MaxMemoryTotal:                  config.DefaultMaxClusterMemory,
+			ForceNodeGroupAnnotationEnabled: forceEnabled,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	p1 := BuildTestPod("p-new", 500, 0)
+	p1.Annotations = map[string]string{ForceNodeGroupAnnotationKey: "ng2"}
+	p2 := BuildTestPod("p-new-2", 500, 0)
+	p2.Annotations = map[string]string{ForceNodeGroupAnnotationKey: "ng2"}
+	result, err := ScaleUp(context, []*apiv1.Pod{p1, p2}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+
+	changes := make([]string, 0)
+	for len(sizeChanges) > 0 {
+		changes = append(changes, <-sizeChanges)
+	}
+	events := make([]string, 0)
+	for len(fakeRecorder.Events) > 0 {
+		events = append(events, <-fakeRecorder.Events)
+	}
+	return result, changes, events
+}
+
+func TestForcedScaleUpBypassesBackoff(t *testing.T) {
+	result, changes, events := runForcedScaleUpTest(t, true, 10)
+	assert.True(t, result)
+	assert.Equal(t, []string{"ng2-1"}, changes)
+	assert.Equal(t, 2, len(events))
+	for _, event := range events {
+		assert.Contains(t, event, "pod forced scale-up")
+	}
+}
+
+func TestForcedScaleUpRespectsMaxSize(t *testing.T) {
+	result, changes, events := runForcedScaleUpTest(t, true, 1)
+	// The pods go through the regular scale-up, which skips ng2 in backoff.
+	assert.True(t, result)
+	assert.Equal(t, []string{"ng1-1"}, changes)
+	ignored := 0
+	for _, event := range events {
+		if strings.Contains(event, "ForcedScaleUpIgnored") {
+			assert.Contains(t, event, "max size reached")
+			ignored++
+		}
+	}
+	assert.Equal(t, 2, ignored)
+}
+
+func TestForcedScaleUpDisabled(t *testing.T) {
+	result, changes, events := runForcedScaleUpTest(t, false, 10)
+	assert.True(t, result)
+	assert.Equal(t, []string{"ng1-1"}, changes)
+	for _, event := range events {
+		assert.NotContains(t, event, "ForcedScaleUp")
+	}
+}
+
+func TestSplitForcedPods(t *testing.T) {
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Annotations = map[string]string{ForceNodeGroupAnnotationKey: "ng1"}
+	p2 := BuildTestPod("p2", 100, 0)
+	p3 := BuildTestPod("p3", 100, 0)
+	p3.Annotations = map[string]string{ForceNodeGroupAnnotationKey: "ng2"}
+	p4 := BuildTestPod("p4", 100, 0)
+	p4.Annotations = map[string]string{ForceNodeGroupAnnotationKey: "ng1"}
+
+	forced, other := splitForcedPods([]*apiv1.Pod{p1, p2, p3, p4})
+	assert.Equal(t, map[string][]*apiv1.Pod{"ng1": {p1, p4}, "ng2": {p3}}, forced)
+	assert.Equal(t, []*apiv1.Pod{p2}, other)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// SyntheticPodAnnotationKey marks pods built by CA to stand for spare capacity. They don't exist
+// in the API server, so no events are recorded on them.
+const SyntheticPodAnnotationKey = "cluster-autoscaler.kubernetes.io/synthetic-pod"
+
+// headroom is the state of spare capacity requested with headroom specs. Headroom is represented
+// by synthetic pods, which are placed on existing nodes if possible and treated as pending otherwise.
+type headroom struct {
+	// pending are synthetic pods that didn't fit on existing nodes and need a scale-up.
+	pending []*apiv1.Pod
+	// placed are synthetic pods assigned to existing nodes. They occupy capacity during scale-down simulation.
+	placed []*apiv1.Pod
+	// reservedNodes are names of nodes holding some headroom. They are not considered for scale-down.
+	reservedNodes map[string]bool
+	statuses      []clusterstate.HeadroomStatus
+}
+
+// buildHeadroomPods builds synthetic pods for the headroom spec with the given priority. Node group
+// headroom in nodes is represented by pods taking all resources of an empty node built from the
+// node group template.
+func buildHeadroomPods(spec *config.HeadroomSpec, specIndex int, nodeInfos map[string]*schedulercache.NodeInfo,
+	priority int32) ([]*apiv1.Pod, error) {
+	selector := spec.Labels
+	count := spec.Replicas
+	cpu := spec.CPU
+	memory := spec.Memory
+	if spec.NodeGroup != "" {
+		nodeInfo, found := nodeInfos[spec.NodeGroup]
+		if !found || nodeInfo.Node() == nil {
+			return nil, fmt.Errorf("no node info for node group %s", spec.NodeGroup)
+		}
+		// Nodes of the group are matched by the template labels, so nodes of other groups with
+		// the same labels can hold the headroom as well.
+		selector = templateNodeSelector(nodeInfo.Node())
+		if spec.Nodes > 0 {
+			count = spec.Nodes
+			allocatable := nodeInfo.Node().Status.Allocatable
+			allocatableCPU := allocatable[apiv1.ResourceCPU]
+			allocatableMemory := allocatable[apiv1.ResourceMemory]
+			requested := nodeInfo.RequestedResource()
+			cpu = *resource.NewMilliQuantity(allocatableCPU.MilliValue()-requested.MilliCPU, resource.DecimalSI)
+			memory = *resource.NewQuantity(allocatableMemory.Value()-requested.Memory, resource.DecimalSI)
+		}
+	}
+
+	pods := make([]*apiv1.Pod, 0, count)
+	for i := 0; i < count; i++ {
+		pod := buildSyntheticPod(fmt.Sprintf("headroom-%d-%d", specIndex, i), selector, cpu, memory)
+		pod.Spec.Priority = &priority
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// templateNodeSelector returns a node selector matching nodes built from the template node.
+func templateNodeSelector(template *apiv1.Node) map[string]string {
+	selector := make(map[string]string)
+	for key, value := range template.Labels {
+		if key != kubeletapis.LabelHostname {
+			selector[key] = value
+		}
+	}
+	return selector
+}
+
+// buildSyntheticPod builds a pod standing for spare capacity that doesn't belong to any real pod.
+func buildSyntheticPod(name string, selector map[string]string, cpu, memory resource.Quantity) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "kube-system",
+			UID:         types.UID(name),
+			Annotations: map[string]string{SyntheticPodAnnotationKey: "true"},
+		},
+		Spec: apiv1.PodSpec{
+			NodeSelector: selector,
+			Containers: []apiv1.Container{
+				{
+					Resources: apiv1.ResourceRequirements{
+						Requests: apiv1.ResourceList{
+							apiv1.ResourceCPU:    cpu,
+							apiv1.ResourceMemory: memory,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// isSyntheticPod returns true if the pod was built by CA to stand for spare capacity.
+func isSyntheticPod(pod *apiv1.Pod) bool {
+	return pod.Annotations[SyntheticPodAnnotationKey] == "true"
+}
+
+// computeHeadroom builds headroom pods for all specs and places them on existing nodes, which
+// already run the given scheduled pods. Each pod is placed on the first node it fits on. Headroom
+// pods get the lowest priority of pods that aren't expendable, so that they yield to real pods
+// without being ignored like expendable pods.
+func computeHeadroom(specs []*config.HeadroomSpec, nodes []*apiv1.Node, scheduledPods []*apiv1.Pod,
+	nodeInfos map[string]*schedulercache.NodeInfo, predicateChecker *simulator.PredicateChecker,
+	expendablePodsPriorityCutoff int) *headroom {
+
+	result := &headroom{
+		pending:       make([]*apiv1.Pod, 0),
+		placed:        make([]*apiv1.Pod, 0),
+		reservedNodes: make(map[string]bool),
+	}
+	sortedNodes := make([]*apiv1.Node, len(nodes))
+	copy(sortedNodes, nodes)
+	sort.Slice(sortedNodes, func(i, j int) bool { return sortedNodes[i].Name < sortedNodes[j].Name })
+	nodeNameToNodeInfo := make(map[string]*schedulercache.NodeInfo)
+	podsOnNodes := make(map[string][]*apiv1.Pod)
+	for _, pod := range scheduledPods {
+		podsOnNodes[pod.Spec.NodeName] = append(podsOnNodes[pod.Spec.NodeName], pod)
+	}
+	for _, node := range sortedNodes {
+		nodeInfo := schedulercache.NewNodeInfo(podsOnNodes[node.Name]...)
+		nodeInfo.SetNode(node)
+		nodeNameToNodeInfo[node.Name] = nodeInfo
+	}
+
+	for i, spec := range specs {
+		pods, err := buildHeadroomPods(spec, i, nodeInfos, int32(expendablePodsPriorityCutoff))
+		if err != nil {
+			glog.Warningf("Failed to build headroom %s: %v", spec, err)
+			continue
+		}
+		current := 0
+		for _, pod := range pods {
+			placed := false
+			for _, node := range sortedNodes {
+				nodeInfo := nodeNameToNodeInfo[node.Name]
+				if err := predicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnSimpleError); err == nil {
+					podCopy := *pod
+					podCopy.Spec.NodeName = node.Name
+					nodeInfo.AddPod(&podCopy)
+					result.placed = append(result.placed, &podCopy)
+					result.reservedNodes[node.Name] = true
+					placed = true
+					break
+				}
+			}
+			if placed {
+				current++
+			} else {
+				result.pending = append(result.pending, pod)
+			}
+		}
+		if current < len(pods) {
+			glog.V(1).Infof("Headroom %s: %d of %d units available", spec, current, len(pods))
+		}
+		result.statuses = append(result.statuses, clusterstate.HeadroomStatus{
+			Spec:    spec.String(),
+			Current: current,
+			Desired: len(pods),
+		})
+	}
+	return result
+}
+
+func filterOutReservedNodes(nodes []*apiv1.Node, reserved map[string]bool) []*apiv1.Node {
+	result := make([]*apiv1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !reserved[node.Name] {
+			result = append(result, node)
+		}
+	}
+	return result
+}
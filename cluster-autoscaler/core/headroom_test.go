@@ -0,0 +1,157 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runHeadroomTest computes headroom for the spec in a cluster with node group ng1 made of nodes n1,
+// running a 600m pod, and n2, which is empty. Pending headroom is then passed to scale-up.
+// Returns the computed headroom and node group size changes.
+func runHeadroomTest(t *testing.T, specValue string, maxNodesTotal int) (*headroom, []string) {
+	fakeClient := &fake.Clientset{}
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Labels["pool"] = "a"
+	SetNodeReadyState(n1, true, time.Now())
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Labels["pool"] = "a"
+	SetNodeReadyState(n2, true, time.Now())
+	p1 := BuildTestPod("p1", 600, 0)
+	p1.Spec.NodeName = "n1"
+	nodes := []*apiv1.Node{n1, n2}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+			MaxNodesTotal:  maxNodesTotal,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	spec, err := config.HeadroomSpecFromString(specValue)
+	assert.NoError(t, err)
+	nodeInfos, err := GetNodeInfosForGroups(nodes, provider, fakeClient, []*extensionsv1.DaemonSet{},
+		nil, nil, nil)
+	assert.NoError(t, err)
+	result := computeHeadroom([]*config.HeadroomSpec{spec}, nodes, []*apiv1.Pod{p1}, nodeInfos, context.PredicateChecker, -10)
+
+	for _, pod := range append(result.placed, result.pending...) {
+		assert.True(t, isSyntheticPod(pod))
+		assert.Equal(t, int32(-10), *pod.Spec.Priority)
+	}
+
+	changes := make([]string, 0)
+	if len(result.pending) > 0 {
+		_, err := ScaleUp(context, result.pending, nodes, []*extensionsv1.DaemonSet{}, nil)
+		assert.NoError(t, err)
+	}
+	// Headroom pods don't exist in the cluster, no events are recorded for them.
+	assert.Equal(t, 0, len(fakeRecorder.Events))
+	for len(sizeChanges) > 0 {
+		changes = append(changes, <-sizeChanges)
+	}
+	return result, changes
+}
+
+func TestHeadroomNodes(t *testing.T) {
+	result, changes := runHeadroomTest(t, "nodes=3:nodeGroup=ng1", 0)
+	// Only n2 is empty, the remaining two nodes have to be added.
+	assert.Equal(t, 1, len(result.placed))
+	assert.Equal(t, "n2", result.placed[0].Spec.NodeName)
+	assert.Equal(t, 2, len(result.pending))
+	assert.Equal(t, map[string]bool{"n2": true}, result.reservedNodes)
+	assert.Equal(t, []clusterstate.HeadroomStatus{{Spec: "nodes=3:nodeGroup=ng1", Current: 1, Desired: 3}}, result.statuses)
+	assert.Equal(t, []string{"ng1-2"}, changes)
+}
+
+func TestHeadroomResources(t *testing.T) {
+	result, changes := runHeadroomTest(t, "cpu=300m,memory=0,replicas=5:labels=pool=a", 0)
+	// n1 fits one replica next to p1 and n2 fits three, the last one needs a new node.
+	assert.Equal(t, 4, len(result.placed))
+	assert.Equal(t, 1, len(result.pending))
+	assert.Equal(t, map[string]bool{"n1": true, "n2": true}, result.reservedNodes)
+	assert.Equal(t, 4, result.statuses[0].Current)
+	assert.Equal(t, 5, result.statuses[0].Desired)
+	assert.Equal(t, []string{"ng1-1"}, changes)
+}
+
+func TestHeadroomRespectsMaxNodesTotal(t *testing.T) {
+	result, changes := runHeadroomTest(t, "nodes=3:nodeGroup=ng1", 3)
+	assert.Equal(t, 2, len(result.pending))
+	assert.Equal(t, []string{"ng1-1"}, changes)
+}
+
+func TestHeadroomLabelsNotMatching(t *testing.T) {
+	result, changes := runHeadroomTest(t, "cpu=100m,memory=0:labels=pool=b", 0)
+	// No node group can run pods with the selector.
+	assert.Equal(t, 0, len(result.placed))
+	assert.Equal(t, 1, len(result.pending))
+	assert.Equal(t, 0, len(result.reservedNodes))
+	assert.Equal(t, []string{}, changes)
+}
+
+func TestFilterOutReservedNodes(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	result := filterOutReservedNodes([]*apiv1.Node{n1, n2}, map[string]bool{"n1": true})
+	assert.Equal(t, []*apiv1.Node{n2}, result)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kube_client "k8s.io/client-go/kubernetes"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ScaleUpOptOutNamespaceLabel is the label on a namespace which, set to "false", prevents pending
+	// pods of the namespace from triggering a scale-up.
+	ScaleUpOptOutNamespaceLabel = "cluster-autoscaler.kubernetes.io/scale-up"
+	// DefaultScaleUpOptOutNamespaceSelector selects namespaces which opted out with the label.
+	DefaultScaleUpOptOutNamespaceSelector = ScaleUpOptOutNamespaceLabel + "=false"
+
+	// scaleUpOptOutEventInterval is how often an event is emitted for a namespace with pending pods
+	// that opted out of scale-up.
+	scaleUpOptOutEventInterval = time.Hour
+)
+
+// NamespaceScaleUpOptOutFilter removes pending pods of namespaces matching a label selector from the
+// pods that can trigger a scale-up. Scheduled pods of these namespaces are not affected, so they
+// still block scale-down of the nodes they run on like any other pods.
+type NamespaceScaleUpOptOutFilter struct {
+	selector  labels.Selector
+	client    kube_client.Interface
+	recorder  kube_record.EventRecorder
+	lastEvent map[string]time.Time
+}
+
+// NewNamespaceScaleUpOptOutFilter builds a NamespaceScaleUpOptOutFilter from autoscaling options. It
+// returns nil if no namespace selector is configured.
+func NewNamespaceScaleUpOptOutFilter(options AutoscalingOptions, client kube_client.Interface,
+	recorder kube_record.EventRecorder) (*NamespaceScaleUpOptOutFilter, error) {
+	if options.ScaleUpOptOutNamespaceSelector == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(options.ScaleUpOptOutNamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("wrong scale-up opt-out namespace selector %q: %v", options.ScaleUpOptOutNamespaceSelector, err)
+	}
+	return &NamespaceScaleUpOptOutFilter{
+		selector:  selector,
+		client:    client,
+		recorder:  recorder,
+		lastEvent: make(map[string]time.Time),
+	}, nil
+}
+
+// FilterOutOptedOutPods returns the pods whose namespace didn't opt out of scale-up. An event is
+// emitted on each opted out namespace with pending pods at most once per scaleUpOptOutEventInterval.
+// If namespaces can't be listed, no pods are filtered out.
+func (f *NamespaceScaleUpOptOutFilter) FilterOutOptedOutPods(pods []*apiv1.Pod, now time.Time) []*apiv1.Pod {
+	for namespace, last := range f.lastEvent {
+		if now.Sub(last) >= scaleUpOptOutEventInterval {
+			delete(f.lastEvent, namespace)
+		}
+	}
+	if len(pods) == 0 {
+		metrics.UpdatePodsOptedOutOfScaleUp(0)
+		return pods
+	}
+	namespaceList, err := f.client.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: f.selector.String()})
+	if err != nil {
+		glog.Warningf("Failed to list namespaces opted out of scale-up: %v", err)
+		return pods
+	}
+	optedOut := make(map[string]*apiv1.Namespace, len(namespaceList.Items))
+	for i := range namespaceList.Items {
+		namespace := &namespaceList.Items[i]
+		optedOut[namespace.Name] = namespace
+	}
+
+	result := make([]*apiv1.Pod, 0, len(pods))
+	filteredCount := make(map[string]int)
+	for _, pod := range pods {
+		if _, found := optedOut[pod.Namespace]; found {
+			filteredCount[pod.Namespace]++
+			continue
+		}
+		result = append(result, pod)
+	}
+	for name, count := range filteredCount {
+		glog.V(2).Infof("%d pending pods in namespace %s don't trigger scale-up, the namespace opted out", count, name)
+		if _, found := f.lastEvent[name]; found {
+			continue
+		}
+		f.lastEvent[name] = now
+		f.recorder.Eventf(optedOut[name], apiv1.EventTypeNormal, "ScaleUpOptedOut",
+			"%d pending pods didn't trigger scale-up, the namespace opted out of scale-up", count)
+	}
+	metrics.UpdatePodsOptedOutOfScaleUp(len(pods) - len(result))
+	return result
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestNamespace(name string, labels map[string]string) *apiv1.Namespace {
+	return &apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func buildTestPodInNamespace(name, namespace string) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 0)
+	pod.Namespace = namespace
+	return pod
+}
+
+func newTestNamespaceScaleUpOptOutFilter(t *testing.T, selector string, recorder kube_record.EventRecorder) *NamespaceScaleUpOptOutFilter {
+	fakeClient := fake.NewSimpleClientset(
+		buildTestNamespace("sandbox", map[string]string{ScaleUpOptOutNamespaceLabel: "false"}),
+		buildTestNamespace("default", nil),
+		buildTestNamespace("batch", map[string]string{ScaleUpOptOutNamespaceLabel: "true", "team": "batch"}))
+	options := AutoscalingOptions{ScaleUpOptOutNamespaceSelector: selector}
+	filter, err := NewNamespaceScaleUpOptOutFilter(options, fakeClient, recorder)
+	assert.NoError(t, err)
+	return filter
+}
+
+func TestNewNamespaceScaleUpOptOutFilter(t *testing.T) {
+	filter, err := NewNamespaceScaleUpOptOutFilter(AutoscalingOptions{}, fake.NewSimpleClientset(), kube_record.NewFakeRecorder(5))
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	_, err = NewNamespaceScaleUpOptOutFilter(AutoscalingOptions{ScaleUpOptOutNamespaceSelector: "a=b=c"},
+		fake.NewSimpleClientset(), kube_record.NewFakeRecorder(5))
+	assert.Error(t, err)
+}
+
+func TestFilterOutOptedOutPods(t *testing.T) {
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	filter := newTestNamespaceScaleUpOptOutFilter(t, DefaultScaleUpOptOutNamespaceSelector, fakeRecorder)
+
+	p1 := buildTestPodInNamespace("p1", "sandbox")
+	p2 := buildTestPodInNamespace("p2", "default")
+	p3 := buildTestPodInNamespace("p3", "batch")
+	p4 := buildTestPodInNamespace("p4", "sandbox")
+
+	result := filter.FilterOutOptedOutPods([]*apiv1.Pod{p1, p2, p3, p4}, time.Now())
+	assert.Equal(t, []*apiv1.Pod{p2, p3}, result)
+	assert.Equal(t, "Normal ScaleUpOptedOut 2 pending pods didn't trigger scale-up, the namespace opted out of scale-up",
+		getStringFromChan(fakeRecorder.Events))
+	assert.Empty(t, fakeRecorder.Events)
+}
+
+func TestFilterOutOptedOutPodsCustomSelector(t *testing.T) {
+	filter := newTestNamespaceScaleUpOptOutFilter(t, "team=batch", kube_record.NewFakeRecorder(5))
+
+	p1 := buildTestPodInNamespace("p1", "sandbox")
+	p2 := buildTestPodInNamespace("p2", "batch")
+
+	result := filter.FilterOutOptedOutPods([]*apiv1.Pod{p1, p2}, time.Now())
+	assert.Equal(t, []*apiv1.Pod{p1}, result)
+}
+
+func TestFilterOutOptedOutPodsEventRateLimit(t *testing.T) {
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	filter := newTestNamespaceScaleUpOptOutFilter(t, DefaultScaleUpOptOutNamespaceSelector, fakeRecorder)
+	pods := []*apiv1.Pod{buildTestPodInNamespace("p1", "sandbox")}
+	now := time.Now()
+
+	assert.Empty(t, filter.FilterOutOptedOutPods(pods, now))
+	assert.Equal(t, "Normal ScaleUpOptedOut 1 pending pods didn't trigger scale-up, the namespace opted out of scale-up",
+		getStringFromChan(fakeRecorder.Events))
+
+	assert.Empty(t, filter.FilterOutOptedOutPods(pods, now.Add(59*time.Minute)))
+	assert.Empty(t, fakeRecorder.Events)
+
+	assert.Empty(t, filter.FilterOutOptedOutPods(pods, now.Add(61*time.Minute)))
+	assert.Equal(t, "Normal ScaleUpOptedOut 1 pending pods didn't trigger scale-up, the namespace opted out of scale-up",
+		getStringFromChan(fakeRecorder.Events))
+}
+
+func TestFilterOutOptedOutPodsListError(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "namespaces", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("failed to list")
+	})
+	filter, err := NewNamespaceScaleUpOptOutFilter(AutoscalingOptions{ScaleUpOptOutNamespaceSelector: DefaultScaleUpOptOutNamespaceSelector},
+		fakeClient, kube_record.NewFakeRecorder(5))
+	assert.NoError(t, err)
+
+	pods := []*apiv1.Pod{buildTestPodInNamespace("p1", "sandbox")}
+	assert.Equal(t, pods, filter.FilterOutOptedOutPods(pods, time.Now()))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/golang/glog"
+)
+
+// AllocatableChange is a change of allocatable of a node between loops.
+type AllocatableChange struct {
+	// Node is the node with the new allocatable.
+	Node *apiv1.Node
+	// Previous is the allocatable of the node in the previous loop.
+	Previous apiv1.ResourceList
+}
+
+// NodeAllocatableTracker finds nodes whose allocatable changed, for example after a live resize of
+// their VM. Such nodes no longer match the template of their node group. Only CPU and memory are
+// compared, as other resources, like GPUs, are added by device plugins after nodes register.
+type NodeAllocatableTracker struct {
+	sync.Mutex
+	// original is the allocatable of each node when it was first seen.
+	original map[string]apiv1.ResourceList
+	// last is the allocatable of each node in the last loop.
+	last map[string]apiv1.ResourceList
+}
+
+// NewNodeAllocatableTracker builds a NodeAllocatableTracker.
+func NewNodeAllocatableTracker() *NodeAllocatableTracker {
+	return &NodeAllocatableTracker{
+		original: make(map[string]apiv1.ResourceList),
+		last:     make(map[string]apiv1.ResourceList),
+	}
+}
+
+// Update records allocatable of the nodes and returns the nodes whose allocatable changed since
+// the previous call. Nodes that are gone are forgotten. A nil tracker reports no changes.
+func (t *NodeAllocatableTracker) Update(nodes []*apiv1.Node) []AllocatableChange {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	changes := make([]AllocatableChange, 0)
+	original := make(map[string]apiv1.ResourceList, len(nodes))
+	last := make(map[string]apiv1.ResourceList, len(nodes))
+	for _, node := range nodes {
+		allocatable := node.Status.Allocatable
+		last[node.Name] = allocatable
+		if previous, found := t.last[node.Name]; found {
+			original[node.Name] = t.original[node.Name]
+			if !equalCpuAndMemory(previous, allocatable) {
+				changes = append(changes, AllocatableChange{Node: node, Previous: previous})
+			}
+		} else {
+			original[node.Name] = allocatable
+		}
+	}
+	t.original = original
+	t.last = last
+	return changes
+}
+
+// resized returns true if allocatable of the node differs from when it was first seen. A nil
+// tracker reports no resized nodes.
+func (t *NodeAllocatableTracker) resized(nodeName string) bool {
+	if t == nil {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+	original, found := t.original[nodeName]
+	return found && !equalCpuAndMemory(original, t.last[nodeName])
+}
+
+func equalCpuAndMemory(a, b apiv1.ResourceList) bool {
+	for _, name := range []apiv1.ResourceName{apiv1.ResourceCPU, apiv1.ResourceMemory} {
+		quantity, other := a[name], b[name]
+		if quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// handleAllocatableChanges reports nodes whose allocatable changed since the last loop and drops
+// scale down calculations that assumed their previous allocatable.
+func handleAllocatableChanges(context *AutoscalingContext, scaleDown *ScaleDown, changes []AllocatableChange) {
+	for _, change := range changes {
+		node := change.Node
+		previous, current := formatResourceList(change.Previous), formatResourceList(node.Status.Allocatable)
+		glog.V(1).Infof("Allocatable of node %s changed from %s to %s", node.Name, previous, current)
+		context.Recorder.Eventf(node, apiv1.EventTypeNormal, "NodeAllocatableChanged",
+			"allocatable changed from %s to %s", previous, current)
+		scaleDown.forgetNode(node.Name)
+	}
+}
+
+// formatResourceList formats cpu and memory of the resource list for events.
+func formatResourceList(resources apiv1.ResourceList) string {
+	cpu := resources[apiv1.ResourceCPU]
+	memory := resources[apiv1.ResourceMemory]
+	return "cpu=" + cpu.String() + " memory=" + memory.String()
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resizeTestNode(node *apiv1.Node, memory int64) *apiv1.Node {
+	resized := *node
+	resized.Status.Allocatable = apiv1.ResourceList{}
+	for name, quantity := range node.Status.Allocatable {
+		resized.Status.Allocatable[name] = quantity
+	}
+	resized.Status.Allocatable[apiv1.ResourceMemory] = *resource.NewQuantity(memory, resource.DecimalSI)
+	return &resized
+}
+
+func TestNodeAllocatableTracker(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000*MB)
+	n2 := BuildTestNode("n2", 1000, 2000*MB)
+	tracker := NewNodeAllocatableTracker()
+
+	assert.Empty(t, tracker.Update([]*apiv1.Node{n1, n2}))
+	assert.False(t, tracker.resized("n1"))
+
+	// Memory allocatable of n1 changes between loops.
+	resized := resizeTestNode(n1, 4000*MB)
+	changes := tracker.Update([]*apiv1.Node{resized, n2})
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, resized, changes[0].Node)
+	assert.Equal(t, n1.Status.Allocatable, changes[0].Previous)
+	assert.True(t, tracker.resized("n1"))
+	assert.False(t, tracker.resized("n2"))
+
+	// The change is reported once, but the node stays resized.
+	assert.Empty(t, tracker.Update([]*apiv1.Node{resized, n2}))
+	assert.True(t, tracker.resized("n1"))
+
+	// GPUs advertised by the device plugin after registration don't make the node resized.
+	withGpu := resizeTestNode(n2, 2000*MB)
+	withGpu.Status.Allocatable[gpu.ResourceNvidiaGPU] = *resource.NewQuantity(1, resource.DecimalSI)
+	assert.Empty(t, tracker.Update([]*apiv1.Node{resized, withGpu}))
+	assert.False(t, tracker.resized("n2"))
+
+	// Nodes that are gone are forgotten.
+	assert.Empty(t, tracker.Update([]*apiv1.Node{n2}))
+	assert.False(t, tracker.resized("n1"))
+	assert.Empty(t, tracker.Update([]*apiv1.Node{n1, n2}))
+
+	var nilTracker *NodeAllocatableTracker
+	assert.Empty(t, nilTracker.Update([]*apiv1.Node{n1}))
+	assert.False(t, nilTracker.resized("n1"))
+}
+
+func TestGetNodeInfosForGroupsWithResizedNode(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000*MB)
+	SetNodeReadyState(n1, true, time.Now())
+	n2 := BuildTestNode("n2", 1000, 2000*MB)
+	SetNodeReadyState(n2, true, time.Now())
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	tracker := NewNodeAllocatableTracker()
+	tracker.Update([]*apiv1.Node{n1, n2})
+	resized := resizeTestNode(n1, 4000*MB)
+	tracker.Update([]*apiv1.Node{resized, n2})
+
+	// The template is built from the node that wasn't resized.
+	nodeInfos, err := GetNodeInfosForGroups([]*apiv1.Node{resized, n2}, provider, fakeClient,
+		[]*extensionsv1.DaemonSet{}, nil, tracker, nil)
+	assert.NoError(t, err)
+	memory := nodeInfos["ng1"].Node().Status.Allocatable[apiv1.ResourceMemory]
+	assert.Equal(t, int64(2000*MB), memory.Value())
+
+	// A resized node is used if there is no other.
+	nodeInfos, err = GetNodeInfosForGroups([]*apiv1.Node{resized}, provider, fakeClient,
+		[]*extensionsv1.DaemonSet{}, nil, tracker, nil)
+	assert.NoError(t, err)
+	memory = nodeInfos["ng1"].Node().Status.Allocatable[apiv1.ResourceMemory]
+	assert.Equal(t, int64(4000*MB), memory.Value())
+}
+
+func TestHandleAllocatableChanges(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000*MB)
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	context := &AutoscalingContext{Recorder: fakeRecorder}
+	scaleDown := NewScaleDown(context)
+	scaleDown.unremovableNodes["n1"] = time.Now().Add(UnremovableNodeRecheckTimeout)
+	scaleDown.unremovableNodes["n2"] = time.Now().Add(UnremovableNodeRecheckTimeout)
+	scaleDown.podLocationHints["default/p1"] = "n1"
+	scaleDown.podLocationHints["default/p2"] = "n2"
+
+	resized := resizeTestNode(n1, 4000*MB)
+	handleAllocatableChanges(context, scaleDown, []AllocatableChange{{Node: resized, Previous: n1.Status.Allocatable}})
+	assert.Equal(t, "Normal NodeAllocatableChanged allocatable changed from cpu=1 memory=2097152k to cpu=1 memory=4194304k",
+		getStringFromChan(fakeRecorder.Events))
+	assert.NotContains(t, scaleDown.unremovableNodes, "n1")
+	assert.Contains(t, scaleDown.unremovableNodes, "n2")
+	assert.Equal(t, map[string]string{"default/p2": "n2"}, scaleDown.podLocationHints)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	"github.com/golang/glog"
+)
+
+// NodeGroupConfigProcessor provides autoscaling options of node groups, with overrides from the
+// configuration file. The file is reloaded in every loop, so that options that are safe to change
+// at runtime take effect without a restart.
+type NodeGroupConfigProcessor struct {
+	sync.Mutex
+	path             string
+	fileConfig       *config.FileConfig
+	commandLineFlags map[string]bool
+	// baseOptions are options the autoscaler was started with. Reloadable options are reset to
+	// them before applying the file, so that removed overrides stop taking effect.
+	baseOptions   *AutoscalingOptions
+	activeProfile string
+}
+
+// NewNodeGroupConfigProcessor builds a NodeGroupConfigProcessor reading the configuration file from
+// the given path. Empty path means there's no configuration file. Options given in commandLineFlags
+// were set on the command line and are never taken from the file.
+func NewNodeGroupConfigProcessor(path string, commandLineFlags map[string]bool) (*NodeGroupConfigProcessor, error) {
+	processor := &NodeGroupConfigProcessor{
+		path:             path,
+		commandLineFlags: commandLineFlags,
+	}
+	if path == "" {
+		return processor, nil
+	}
+	fileConfig, err := config.LoadFileConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	processor.fileConfig = fileConfig
+	metrics.UpdateConfigFileHash(fileConfig.Hash())
+	return processor, nil
+}
+
+// Reload reads the configuration file and applies reloadable options from it to the given options,
+// followed by options of the time profile active at currentTime. If the file is not valid, the last
+// valid configuration stays in use.
+func (p *NodeGroupConfigProcessor) Reload(options *AutoscalingOptions, currentTime time.Time) {
+	if p == nil || p.path == "" {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	fileConfig, err := config.LoadFileConfig(p.path)
+	if err != nil {
+		glog.Errorf("Failed to reload configuration file, using the last valid one: %v", err)
+	} else if fileConfig.Hash() != p.fileConfig.Hash() {
+		glog.V(1).Infof("Configuration file %s reloaded, hash %s", p.path, fileConfig.Hash())
+		p.fileConfig = fileConfig
+		metrics.UpdateConfigFileHash(fileConfig.Hash())
+	}
+
+	if p.baseOptions == nil {
+		base := *options
+		p.baseOptions = &base
+	}
+	copyReloadableOptions(options, p.baseOptions)
+
+	profile := p.fileConfig.ActiveTimeProfile(currentTime)
+	profileName := ""
+	if profile != nil {
+		profileName = profile.Name
+	}
+	if profileName != p.activeProfile {
+		glog.V(1).Infof("Active time profile changed from %q to %q", p.activeProfile, profileName)
+		p.activeProfile = profileName
+	}
+	metrics.UpdateActiveTimeProfile(profileName)
+
+	for _, name := range config.ReloadableOptions {
+		if p.commandLineFlags[name] {
+			continue
+		}
+		// Values of reloadable options are validated when the file is loaded.
+		values, _ := p.fileConfig.OptionValues(name)
+		if profile != nil {
+			if profileValues, _ := profile.OptionValues(name); len(profileValues) > 0 {
+				values = profileValues
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		duration, _ := time.ParseDuration(values[0])
+		switch name {
+		case "scale-down-utilization-threshold":
+			options.ScaleDownUtilizationThreshold, _ = strconv.ParseFloat(values[0], 64)
+		case "scale-down-unneeded-time":
+			options.ScaleDownUnneededTime = duration
+		case "scale-down-unready-time":
+			options.ScaleDownUnreadyTime = duration
+		case "scale-down-delay-after-add":
+			options.ScaleDownDelayAfterAdd = duration
+		case "scale-down-delay-after-delete":
+			options.ScaleDownDelayAfterDelete = duration
+		case "scale-down-delay-after-failure":
+			options.ScaleDownDelayAfterFailure = duration
+		}
+	}
+}
+
+// ActiveTimeProfile returns the name of the time profile applied by the last reload, or an empty
+// string if no profile is active.
+func (p *NodeGroupConfigProcessor) ActiveTimeProfile() string {
+	if p == nil {
+		return ""
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.activeProfile
+}
+
+func copyReloadableOptions(dst, src *AutoscalingOptions) {
+	dst.ScaleDownUtilizationThreshold = src.ScaleDownUtilizationThreshold
+	dst.ScaleDownUnneededTime = src.ScaleDownUnneededTime
+	dst.ScaleDownUnreadyTime = src.ScaleDownUnreadyTime
+	dst.ScaleDownDelayAfterAdd = src.ScaleDownDelayAfterAdd
+	dst.ScaleDownDelayAfterDelete = src.ScaleDownDelayAfterDelete
+	dst.ScaleDownDelayAfterFailure = src.ScaleDownDelayAfterFailure
+}
+
+// GetFailoverChain returns the failover chain from the configuration file the node group belongs to.
+func (p *NodeGroupConfigProcessor) GetFailoverChain(nodeGroupId string) (config.FailoverChainConfig, bool) {
+	if p == nil {
+		return config.FailoverChainConfig{}, false
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.fileConfig.FailoverChain(nodeGroupId)
+}
+
+// GetOptions returns autoscaling options of the node group. Options not overridden for the node
+// group in the configuration file are taken from the context.
+func (p *NodeGroupConfigProcessor) GetOptions(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup) config.NodeGroupAutoscalingOptions {
+	global := config.NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: context.ScaleDownUtilizationThreshold,
+		ScaleDownUnneededTime:         context.ScaleDownUnneededTime,
+		ScaleDownUnreadyTime:          context.ScaleDownUnreadyTime,
+		MaxGracefulTerminationSec:     context.MaxGracefulTerminationSec,
+		MaxDrainParallelism:           context.MaxDrainParallelismPerNodeGroup,
+		MaxNodesPerMinute:             context.MaxNodesPerMinutePerNodeGroup,
+		SplitWeight:                   config.DefaultSplitWeight,
+	}
+	if p == nil || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+		return global
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.fileConfig.NodeGroupOptions(nodeGroup.Id(), global)
+}
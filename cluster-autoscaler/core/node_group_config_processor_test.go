@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfigFile(t *testing.T, path string, content string) {
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func getTestNodeGroup(provider *testprovider.TestCloudProvider, id string) cloudprovider.NodeGroup {
+	for _, nodeGroup := range provider.NodeGroups() {
+		if nodeGroup.Id() == id {
+			return nodeGroup
+		}
+	}
+	return nil
+}
+
+func TestNodeGroupConfigProcessorReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	writeTestConfigFile(t, path, `
+options:
+  scale-down-utilization-threshold: 0.3
+  scale-down-delay-after-add: 1m
+nodeGroups:
+- name: ng1
+  scaleDownUnneededTime: 1h
+`)
+
+	processor, err := NewNodeGroupConfigProcessor(path, map[string]bool{"scale-down-delay-after-add": true})
+	assert.NoError(t, err)
+	options := AutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.5,
+		ScaleDownUnneededTime:         10 * time.Minute,
+		ScaleDownDelayAfterAdd:        10 * time.Minute,
+	}
+	processor.Reload(&options, time.Now())
+	assert.Equal(t, 0.3, options.ScaleDownUtilizationThreshold)
+	// Set on the command line.
+	assert.Equal(t, 10*time.Minute, options.ScaleDownDelayAfterAdd)
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	ng1 := getTestNodeGroup(provider, "ng1")
+	ng2 := getTestNodeGroup(provider, "ng2")
+	context := &AutoscalingContext{AutoscalingOptions: options}
+	assert.Equal(t, config.NodeGroupAutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.3,
+		ScaleDownUnneededTime:         time.Hour,
+		SplitWeight:                   config.DefaultSplitWeight,
+	}, processor.GetOptions(context, ng1))
+	assert.Equal(t, 10*time.Minute, processor.GetOptions(context, ng2).ScaleDownUnneededTime)
+
+	// Reloaded options take effect.
+	writeTestConfigFile(t, path, `
+options:
+  scale-down-utilization-threshold: 0.6
+  scale-down-unneeded-time: 2m
+nodeGroups:
+- name: ng2
+  scaleDownUnneededTime: 1h
+`)
+	processor.Reload(&context.AutoscalingOptions, time.Now())
+	assert.Equal(t, 0.6, context.ScaleDownUtilizationThreshold)
+	assert.Equal(t, 2*time.Minute, context.ScaleDownUnneededTime)
+	assert.Equal(t, 2*time.Minute, processor.GetOptions(context, ng1).ScaleDownUnneededTime)
+	assert.Equal(t, time.Hour, processor.GetOptions(context, ng2).ScaleDownUnneededTime)
+
+	// Invalid configuration is ignored.
+	writeTestConfigFile(t, path, `
+options:
+  scale-down-utilization-threshold: 2
+`)
+	processor.Reload(&context.AutoscalingOptions, time.Now())
+	assert.Equal(t, 0.6, context.ScaleDownUtilizationThreshold)
+	assert.Equal(t, time.Hour, processor.GetOptions(context, ng2).ScaleDownUnneededTime)
+}
+
+func TestNodeGroupConfigProcessorNoFile(t *testing.T) {
+	processor, err := NewNodeGroupConfigProcessor("", nil)
+	assert.NoError(t, err)
+	options := AutoscalingOptions{ScaleDownUtilizationThreshold: 0.5, ScaleDownUnneededTime: time.Minute}
+	processor.Reload(&options, time.Now())
+	assert.Equal(t, 0.5, options.ScaleDownUtilizationThreshold)
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	context := &AutoscalingContext{AutoscalingOptions: options}
+	expected := config.NodeGroupAutoscalingOptions{ScaleDownUtilizationThreshold: 0.5, ScaleDownUnneededTime: time.Minute,
+		SplitWeight: config.DefaultSplitWeight}
+	assert.Equal(t, expected, processor.GetOptions(context, getTestNodeGroup(provider, "ng1")))
+
+	var noProcessor *NodeGroupConfigProcessor
+	assert.Equal(t, expected, noProcessor.GetOptions(context, getTestNodeGroup(provider, "ng1")))
+
+	_, err = NewNodeGroupConfigProcessor("/no/such/file", nil)
+	assert.Error(t, err)
+}
+
+func TestNodeGroupConfigProcessorTimeProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	writeTestConfigFile(t, path, `
+options:
+  scale-down-unneeded-time: 20m
+timeProfiles:
+- name: night
+  schedule: "0 22 * * *"
+  duration: 8h
+  options:
+    scale-down-utilization-threshold: 0.7
+    scale-down-unneeded-time: 2m
+    scale-down-delay-after-add: 1m
+`)
+
+	processor, err := NewNodeGroupConfigProcessor(path, map[string]bool{"scale-down-delay-after-add": true})
+	assert.NoError(t, err)
+	options := AutoscalingOptions{
+		ScaleDownUtilizationThreshold: 0.5,
+		ScaleDownUnneededTime:         10 * time.Minute,
+		ScaleDownDelayAfterAdd:        10 * time.Minute,
+	}
+	day := time.Date(2018, 1, 3, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2018, 1, 3, 23, 0, 0, 0, time.UTC)
+
+	processor.Reload(&options, day)
+	assert.Equal(t, "", processor.ActiveTimeProfile())
+	assert.Equal(t, 0.5, options.ScaleDownUtilizationThreshold)
+	assert.Equal(t, 20*time.Minute, options.ScaleDownUnneededTime)
+
+	processor.Reload(&options, night)
+	assert.Equal(t, "night", processor.ActiveTimeProfile())
+	assert.Equal(t, 0.7, options.ScaleDownUtilizationThreshold)
+	assert.Equal(t, 2*time.Minute, options.ScaleDownUnneededTime)
+	// Set on the command line.
+	assert.Equal(t, 10*time.Minute, options.ScaleDownDelayAfterAdd)
+
+	// Options not set in the file go back to their original values once the window ends.
+	processor.Reload(&options, night.Add(8*time.Hour))
+	assert.Equal(t, "", processor.ActiveTimeProfile())
+	assert.Equal(t, 0.5, options.ScaleDownUtilizationThreshold)
+	assert.Equal(t, 20*time.Minute, options.ScaleDownUnneededTime)
+
+	// A profile overriding an option that can't be changed at runtime is rejected and the last
+	// valid configuration stays in use.
+	writeTestConfigFile(t, path, `
+timeProfiles:
+- name: night
+  schedule: "0 22 * * *"
+  duration: 8h
+  options:
+    max-nodes-total: 10
+`)
+	processor.Reload(&options, night)
+	assert.Equal(t, "night", processor.ActiveTimeProfile())
+	assert.Equal(t, 0.7, options.ScaleDownUtilizationThreshold)
+
+	var noProcessor *NodeGroupConfigProcessor
+	assert.Equal(t, "", noProcessor.ActiveTimeProfile())
+}
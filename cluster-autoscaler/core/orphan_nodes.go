@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"reflect"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/golang/glog"
+)
+
+// UpdateOrphanNodes updates the list of orphan nodes, i.e. empty nodes that don't belong to any
+// node group and match OrphanNodesSelector, together with the time they were first seen empty.
+func (sd *ScaleDown) UpdateOrphanNodes(nodes []*apiv1.Node, pods []*apiv1.Pod, timestamp time.Time) errors.AutoscalerError {
+	if sd.context.OrphanNodesSelector == "" {
+		return errors.NewAutoscalerError(errors.InternalError, "orphan nodes selector is required to scale down orphan nodes")
+	}
+	selector, err := labels.Parse(sd.context.OrphanNodesSelector)
+	if err != nil {
+		return errors.ToAutoscalerError(errors.InternalError, err)
+	}
+
+	// Masters are never removed, like in regular scale-down. Self-managed masters often don't
+	// belong to any node group.
+	candidates := make([]*apiv1.Node, 0)
+	for _, node := range filterOutMasters(nodes, pods) {
+		if !selector.Matches(labels.Set(node.Labels)) || hasNoScaleDownAnnotation(node) || deletetaint.HasToBeDeletedTaint(node) {
+			continue
+		}
+		nodeGroup, err := sd.context.CloudProvider.NodeGroupForNode(node)
+		if err != nil {
+			glog.Warningf("Failed to get node group for %s, not treating it as orphan: %v", node.Name, err)
+			continue
+		}
+		if nodeGroup != nil && !reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	result := make(map[string]time.Time)
+	emptyNodes := simulator.FindEmptyNodesToRemove(candidates, pods)
+	for _, node := range emptyNodes {
+		if since, found := sd.orphanNodes[node.Name]; found {
+			result[node.Name] = since
+		} else {
+			result[node.Name] = timestamp
+		}
+	}
+	sd.orphanNodes = result
+	sd.orphanNodesList = emptyNodes
+	return nil
+}
+
+// TryToRemoveOrphanNodes deletes the instances of orphan nodes that have been empty for
+// ScaleDownUnneededTime. Returns the number of removed nodes.
+func (sd *ScaleDown) TryToRemoveOrphanNodes(timestamp time.Time) (int, errors.AutoscalerError) {
+	provider, ok := sd.context.CloudProvider.(cloudprovider.InstanceDeletingCloudProvider)
+	if !ok {
+		glog.Warningf("Cloud provider %s can't delete instances, not removing orphan nodes", sd.context.CloudProvider.Name())
+		return 0, nil
+	}
+
+	var finalError errors.AutoscalerError
+	removed := 0
+	for _, node := range sd.orphanNodesList {
+		if removed >= sd.context.MaxEmptyBulkDelete {
+			break
+		}
+		since, found := sd.orphanNodes[node.Name]
+		if !found || since.Add(sd.context.ScaleDownUnneededTime).After(timestamp) {
+			continue
+		}
+		if sd.context.DryRun {
+			recordDryRunAction(sd.context, metrics.DryRunRemoveOrphan, "", "would remove orphan node %s, empty since %s", node.Name, since)
+			continue
+		}
+		if err := sd.removeOrphanNode(provider, node); err != nil {
+			glog.Errorf("Failed to remove orphan node %s: %v", node.Name, err)
+			finalError = err
+			continue
+		}
+		delete(sd.orphanNodes, node.Name)
+		removed++
+	}
+	return removed, finalError
+}
+
+func (sd *ScaleDown) removeOrphanNode(provider cloudprovider.InstanceDeletingCloudProvider, node *apiv1.Node) errors.AutoscalerError {
+	if err := deletetaint.MarkToBeDeleted(node, sd.context.ClientSet); err != nil {
+		sd.context.Recorder.Eventf(node, apiv1.EventTypeWarning, "ScaleDownOrphanFailed", "failed to mark the node as toBeDeleted/unschedulable: %v", err)
+		return errors.ToAutoscalerError(errors.ApiCallError, err)
+	}
+
+	glog.V(0).Infof("Scale-down: removing orphan node %s", node.Name)
+	sd.context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleDownOrphan", "Scale-down: removing empty node %s outside of node groups", node.Name)
+	sd.context.Recorder.Eventf(node, apiv1.EventTypeNormal, "ScaleDownOrphan", "deleting empty node outside of node groups")
+
+	if err := provider.DeleteInstance(node.Spec.ProviderID); err != nil {
+		deletetaint.CleanToBeDeleted(node, sd.context.ClientSet)
+		sd.context.Recorder.Eventf(node, apiv1.EventTypeWarning, "ScaleDownOrphanFailed", "failed to delete the instance: %v", err)
+		return errors.NewAutoscalerError(errors.CloudProviderError, "failed to delete instance %s: %v", node.Spec.ProviderID, err)
+	}
+	metrics.RegisterScaleDown(1, metrics.Orphan)
+	return nil
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/kubernetes/pkg/kubelet/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildOrphanNodesTest builds a context with a node group ng1 and the given nodes, all but n1
+// outside of node groups. Nodes updated through the API and deleted instances are sent to the
+// returned channels.
+func buildOrphanNodesTest(t *testing.T, nodes []*apiv1.Node, deleteErr error) (*AutoscalingContext, chan string, chan string) {
+	updatedNodes := make(chan string, 10)
+	deletedInstances := make(chan string, 10)
+	nodesMap := make(map[string]*apiv1.Node)
+	for _, node := range nodes {
+		nodesMap[node.Name] = node
+	}
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		getAction := action.(core.GetAction)
+		if node, found := nodesMap[getAction.GetName()]; found {
+			return true, node, nil
+		}
+		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		node, found := nodesMap[patch.GetName()]
+		if !found {
+			return true, nil, fmt.Errorf("Wrong node: %v", patch.GetName())
+		}
+		patched, err := ApplyJSONPatchToNode(node, patch.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		nodesMap[patch.GetName()] = patched
+		updatedNodes <- patch.GetName()
+		return true, patched, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.SetOnDeleteInstance(func(providerID string) error {
+		deletedInstances <- providerID
+		return deleteErr
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", nodesMap["n1"])
+
+	options := defaultScaleDownOptions
+	options.ScaleDownOrphanNodes = true
+	options.OrphanNodesSelector = "role=spare"
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: options,
+		CloudProvider:      provider,
+		ClientSet:          fakeClient,
+		Recorder:           fakeRecorder,
+		LogRecorder:        fakeLogRecorder,
+	}
+	return context, updatedNodes, deletedInstances
+}
+
+func buildSpareNode(name string) *apiv1.Node {
+	node := BuildTestNode(name, 1000, 1000)
+	SetNodeReadyState(node, true, time.Time{})
+	node.Labels["role"] = "spare"
+	return node
+}
+
+func TestRemoveOrphanNodes(t *testing.T) {
+	orphan := buildSpareNode("orphan")
+	busy := buildSpareNode("busy")
+	inGroup := buildSpareNode("n1")
+	unlabeled := BuildTestNode("unlabeled", 1000, 1000)
+	SetNodeReadyState(unlabeled, true, time.Time{})
+	master := buildSpareNode("master")
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "busy"
+	apiServer := BuildTestPod("kube-apiserver", 100, 0)
+	apiServer.Namespace = "kube-system"
+	apiServer.Labels = map[string]string{"component": "kube-apiserver"}
+	apiServer.Annotations = map[string]string{types.ConfigMirrorAnnotationKey: "mirror"}
+	apiServer.Spec.NodeName = "master"
+	nodes := []*apiv1.Node{orphan, busy, inGroup, unlabeled, master}
+	pods := []*apiv1.Pod{p1, apiServer}
+
+	context, updatedNodes, deletedInstances := buildOrphanNodesTest(t, nodes, nil)
+	scaleDown := NewScaleDown(context)
+	now := time.Now()
+
+	assert.NoError(t, scaleDown.UpdateOrphanNodes(nodes, pods, now.Add(-30*time.Second)))
+	assert.Equal(t, 1, len(scaleDown.orphanNodes))
+	assert.Contains(t, scaleDown.orphanNodes, "orphan")
+
+	// Not empty for long enough.
+	removed, err := scaleDown.TryToRemoveOrphanNodes(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(deletedInstances))
+
+	// Time of the first observation is kept.
+	assert.NoError(t, scaleDown.UpdateOrphanNodes(nodes, pods, now))
+	removed, err = scaleDown.TryToRemoveOrphanNodes(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, "orphan", getStringFromChanImmediately(deletedInstances))
+	assert.Equal(t, "orphan", getStringFromChanImmediately(updatedNodes))
+
+	// Nodes outside of the selector, in a node group, with pods or running the API server are never
+	// touched.
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(deletedInstances))
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(updatedNodes))
+}
+
+func TestRemoveOrphanNodesDeleteFailed(t *testing.T) {
+	orphan := buildSpareNode("orphan")
+	inGroup := buildSpareNode("n1")
+	nodes := []*apiv1.Node{orphan, inGroup}
+
+	context, updatedNodes, deletedInstances := buildOrphanNodesTest(t, nodes, fmt.Errorf("instance not found"))
+	scaleDown := NewScaleDown(context)
+	now := time.Now()
+
+	assert.NoError(t, scaleDown.UpdateOrphanNodes(nodes, []*apiv1.Pod{}, now.Add(-2*time.Minute)))
+	removed, err := scaleDown.TryToRemoveOrphanNodes(now)
+	assert.Error(t, err)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, "orphan", getStringFromChanImmediately(deletedInstances))
+	// The node is tainted and the taint is removed after the failure.
+	assert.Equal(t, "orphan", getStringFromChanImmediately(updatedNodes))
+	assert.Equal(t, "orphan", getStringFromChanImmediately(updatedNodes))
+}
+
+func TestRemoveOrphanNodesDryRun(t *testing.T) {
+	orphan := buildSpareNode("orphan")
+	inGroup := buildSpareNode("n1")
+	nodes := []*apiv1.Node{orphan, inGroup}
+
+	context, updatedNodes, deletedInstances := buildOrphanNodesTest(t, nodes, nil)
+	context.DryRun = true
+	scaleDown := NewScaleDown(context)
+	now := time.Now()
+
+	assert.NoError(t, scaleDown.UpdateOrphanNodes(nodes, []*apiv1.Pod{}, now.Add(-2*time.Minute)))
+	removed, err := scaleDown.TryToRemoveOrphanNodes(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(deletedInstances))
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(updatedNodes))
+}
+
+func TestUpdateOrphanNodesRequiresSelector(t *testing.T) {
+	nodes := []*apiv1.Node{buildSpareNode("orphan"), buildSpareNode("n1")}
+	context, _, _ := buildOrphanNodesTest(t, nodes, nil)
+	context.OrphanNodesSelector = ""
+	scaleDown := NewScaleDown(context)
+
+	assert.Error(t, scaleDown.UpdateOrphanNodes(nodes, []*apiv1.Pod{}, time.Now()))
+	assert.Equal(t, 0, len(scaleDown.orphanNodes))
+}
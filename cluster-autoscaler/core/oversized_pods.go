@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+type oversizedPodsEntry struct {
+	checked time.Time
+	reason  string
+	// reported are namespace/name of pods already told that they don't fit any node group.
+	reported map[string]bool
+}
+
+// OversizedPodsCache remembers pending pods requesting more of some resource than the template
+// node of any node group has free, so that scale-up doesn't check them against all node groups in
+// every loop. Pods are keyed by controller and equivalence hash like in FilterOutSchedulable, so
+// replicas of a controller share an entry. Entries are checked again after the recheck interval,
+// and all of them are dropped when existing node groups are added or removed or their templates
+// change. Node groups that could be autoprovisioned don't invalidate entries, as they are built
+// from the pending pods and change with them.
+type OversizedPodsCache struct {
+	recheckInterval time.Duration
+	templates       string
+	entries         map[string]*oversizedPodsEntry
+}
+
+// NewOversizedPodsCache builds an empty OversizedPodsCache. Returns nil if the recheck interval is
+// not positive, which disables the cache.
+func NewOversizedPodsCache(recheckInterval time.Duration) *OversizedPodsCache {
+	if recheckInterval <= 0 {
+		return nil
+	}
+	return &OversizedPodsCache{
+		recheckInterval: recheckInterval,
+		entries:         make(map[string]*oversizedPodsEntry),
+	}
+}
+
+// filter returns the pods that may fit a new node of some node group. Pods that don't fit any
+// are told so with a single event. A nil cache returns all pods.
+func (c *OversizedPodsCache) filter(context *AutoscalingContext, pods []*apiv1.Pod, nodeGroups []cloudprovider.NodeGroup,
+	nodeInfos map[string]*schedulercache.NodeInfo, now time.Time) []*apiv1.Pod {
+	if c == nil {
+		return pods
+	}
+	freeCapacities := templateFreeCapacities(nodeGroups, nodeInfos)
+	if len(freeCapacities) == 0 {
+		return pods
+	}
+	if templates := capacitiesFingerprint(freeCapacities, nodeGroups); templates != c.templates {
+		if c.templates != "" {
+			glog.V(2).Infof("Node groups or their templates changed, checking pods that didn't fit any node group again")
+		}
+		c.templates = templates
+		c.entries = make(map[string]*oversizedPodsEntry)
+	}
+	maxFree := maxCapacity(freeCapacities)
+
+	result := make([]*apiv1.Pod, 0, len(pods))
+	seen := make(map[string]bool)
+	for _, pod := range pods {
+		key := oversizedPodKey(pod, context.PodEquivalenceCache)
+		seen[key] = true
+		entry, found := c.entries[key]
+		if !found || now.Sub(entry.checked) >= c.recheckInterval {
+			reason := exceededRequest(pod, maxFree)
+			if reason == "" {
+				delete(c.entries, key)
+				result = append(result, pod)
+				continue
+			}
+			if !found {
+				entry = &oversizedPodsEntry{reported: make(map[string]bool)}
+				c.entries[key] = entry
+			}
+			entry.checked = now
+			entry.reason = reason
+		}
+		glog.V(4).Infof("Pod %s/%s doesn't fit any node group: %s. Ignoring in scale up.", pod.Namespace, pod.Name, entry.reason)
+		podName := pod.Namespace + "/" + pod.Name
+		if !entry.reported[podName] {
+			recordPodEvent(context, pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
+				"pod didn't trigger scale-up (no node group can ever satisfy requests: %s)", entry.reason)
+			entry.reported[podName] = true
+		}
+	}
+	for key := range c.entries {
+		if !seen[key] {
+			delete(c.entries, key)
+		}
+	}
+	return result
+}
+
+func oversizedPodKey(pod *apiv1.Pod, equivalenceCache *PodEquivalenceCache) string {
+	if key := podSchedulableKey(pod, equivalenceCache); key != "" {
+		return key
+	}
+	return "pod/" + pod.Namespace + "/" + pod.Name
+}
+
+// templateFreeCapacities returns allocatable resources of template nodes of node groups that are
+// not requested by pods running on them, such as daemon set pods, keyed by node group id.
+func templateFreeCapacities(nodeGroups []cloudprovider.NodeGroup, nodeInfos map[string]*schedulercache.NodeInfo) map[string]apiv1.ResourceList {
+	result := make(map[string]apiv1.ResourceList)
+	for _, nodeGroup := range nodeGroups {
+		nodeInfo, found := nodeInfos[nodeGroup.Id()]
+		if !found || nodeInfo.Node() == nil {
+			continue
+		}
+		requested := nodeInfo.RequestedResource()
+		requestedList := (&requested).ResourceList()
+		free := make(apiv1.ResourceList)
+		for name, allocatable := range nodeInfo.Node().Status.Allocatable {
+			if name == apiv1.ResourcePods {
+				continue
+			}
+			quantity := allocatable.Copy()
+			if used, found := requestedList[name]; found {
+				quantity.Sub(used)
+			}
+			free[name] = *quantity
+		}
+		result[nodeGroup.Id()] = free
+	}
+	return result
+}
+
+// capacitiesFingerprint describes free capacities of template nodes of existing node groups.
+func capacitiesFingerprint(capacities map[string]apiv1.ResourceList, nodeGroups []cloudprovider.NodeGroup) string {
+	parts := make([]string, 0, len(capacities))
+	for _, nodeGroup := range nodeGroups {
+		id := nodeGroup.Id()
+		capacity, found := capacities[id]
+		if !found || !nodeGroup.Exist() {
+			continue
+		}
+		resources := make([]string, 0, len(capacity))
+		for name, quantity := range capacity {
+			resources = append(resources, fmt.Sprintf("%s=%s", name, quantity.String()))
+		}
+		sort.Strings(resources)
+		parts = append(parts, id+":"+strings.Join(resources, ","))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
+
+func maxCapacity(capacities map[string]apiv1.ResourceList) apiv1.ResourceList {
+	result := make(apiv1.ResourceList)
+	for _, capacity := range capacities {
+		for name, quantity := range capacity {
+			if current, found := result[name]; !found || quantity.Cmp(current) > 0 {
+				result[name] = quantity
+			}
+		}
+	}
+	return result
+}
+
+// exceededRequest describes a resource the pod requests more of than maxFree has, e.g. "cpu 200 > max 96".
+// It's empty if maxFree has enough of every resource.
+func exceededRequest(pod *apiv1.Pod, maxFree apiv1.ResourceList) string {
+	requests := predicates.GetResourceRequest(pod).ResourceList()
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resourceName := apiv1.ResourceName(name)
+		request := requests[resourceName]
+		if resourceName == apiv1.ResourcePods || request.IsZero() {
+			continue
+		}
+		free := maxFree[resourceName]
+		if request.Cmp(free) > 0 {
+			return fmt.Sprintf("%s %s > max %s", name, request.String(), free.String())
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTemplateNodeInfo(name string, millicpu int64, mem int64, pods ...*apiv1.Pod) *schedulercache.NodeInfo {
+	nodeInfo := schedulercache.NewNodeInfo(pods...)
+	nodeInfo.SetNode(BuildTestNode(name, millicpu, mem))
+	return nodeInfo
+}
+
+func assertNoEvent(t *testing.T, recorder *kube_record.FakeRecorder) {
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Unexpected event: %s", event)
+	default:
+	}
+}
+
+func TestOversizedPodsCache(t *testing.T) {
+	now := time.Now()
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	ng1 := provider.NodeGroups()[0]
+	daemonSetPod := BuildTestPod("ds", 4000, 0)
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTemplateNodeInfo("ng1-template", 100000, 100*1024*1024*1024, daemonSetPod),
+	}
+
+	rc := apiv1.ReplicationController{}
+	rc.UID = "rc-uid"
+	huge1 := BuildTestPod("huge1", 200000, 0)
+	huge1.OwnerReferences = GenerateOwnerReferences("rc", "ReplicationController", "extensions/v1beta1", rc.UID)
+	huge2 := BuildTestPod("huge2", 200000, 0)
+	huge2.OwnerReferences = GenerateOwnerReferences("rc", "ReplicationController", "extensions/v1beta1", rc.UID)
+	small := BuildTestPod("small", 1000, 0)
+
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{Recorder: fakeRecorder}
+	cache := NewOversizedPodsCache(10 * time.Minute)
+
+	pods := []*apiv1.Pod{huge1, small, huge2}
+	nodeGroups := []cloudprovider.NodeGroup{ng1}
+	assert.Equal(t, []*apiv1.Pod{small}, cache.filter(context, pods, nodeGroups, nodeInfos, now))
+	// The daemon set pod requests 4 of 100 CPUs.
+	assert.Equal(t, "Normal NotTriggerScaleUp pod didn't trigger scale-up (no node group can ever satisfy requests: cpu 200 > max 96)",
+		getStringFromChan(fakeRecorder.Events))
+	assert.Equal(t, "Normal NotTriggerScaleUp pod didn't trigger scale-up (no node group can ever satisfy requests: cpu 200 > max 96)",
+		getStringFromChan(fakeRecorder.Events))
+	assert.Equal(t, 1, len(cache.entries))
+
+	// Pods are told only once, also after they are checked again.
+	assert.Equal(t, []*apiv1.Pod{small}, cache.filter(context, pods, nodeGroups, nodeInfos, now.Add(time.Minute)))
+	assert.Equal(t, []*apiv1.Pod{small}, cache.filter(context, pods, nodeGroups, nodeInfos, now.Add(20*time.Minute)))
+	assertNoEvent(t, fakeRecorder)
+
+	// Autoprovisioning candidates built from pending pods don't drop entries.
+	candidate, err := provider.NewNodeGroup("n1-standard-8", nil, nil)
+	assert.NoError(t, err)
+	nodeInfos[candidate.Id()] = buildTemplateNodeInfo("candidate-template", 8000, 30*1024*1024*1024)
+	withCandidate := []cloudprovider.NodeGroup{ng1, candidate}
+	assert.Equal(t, []*apiv1.Pod{small}, cache.filter(context, pods, withCandidate, nodeInfos, now.Add(20*time.Minute+30*time.Second)))
+	assert.Equal(t, 1, len(cache.entries))
+	assertNoEvent(t, fakeRecorder)
+	delete(nodeInfos, candidate.Id())
+
+	// A bigger node group appears.
+	provider.AddNodeGroup("ng2", 0, 10, 0)
+	nodeInfos["ng2"] = buildTemplateNodeInfo("ng2-template", 256000, 100*1024*1024*1024)
+	assert.Equal(t, pods, cache.filter(context, pods, provider.NodeGroups(), nodeInfos, now.Add(21*time.Minute)))
+	assert.Empty(t, cache.entries)
+	assertNoEvent(t, fakeRecorder)
+
+	// Pods that no longer fit after the template changed are told again.
+	nodeInfos["ng2"] = buildTemplateNodeInfo("ng2-template", 128000, 100*1024*1024*1024)
+	assert.Equal(t, []*apiv1.Pod{small}, cache.filter(context, pods, provider.NodeGroups(), nodeInfos, now.Add(22*time.Minute)))
+	assert.Equal(t, "Normal NotTriggerScaleUp pod didn't trigger scale-up (no node group can ever satisfy requests: cpu 200 > max 128)",
+		getStringFromChan(fakeRecorder.Events))
+
+	// Entries of pods that are no longer pending are dropped.
+	assert.Equal(t, []*apiv1.Pod{small}, cache.filter(context, []*apiv1.Pod{small}, provider.NodeGroups(), nodeInfos, now.Add(23*time.Minute)))
+	assert.Empty(t, cache.entries)
+
+	// A nil cache doesn't filter pods.
+	var nilCache *OversizedPodsCache
+	assert.Equal(t, pods, nilCache.filter(context, pods, nodeGroups, nodeInfos, now))
+	assert.Nil(t, NewOversizedPodsCache(0))
+}
+
+func TestExceededRequest(t *testing.T) {
+	maxFree := apiv1.ResourceList{
+		apiv1.ResourceCPU:    *resource.NewMilliQuantity(96000, resource.DecimalSI),
+		apiv1.ResourceMemory: *resource.NewQuantity(64*1024*1024*1024, resource.BinarySI),
+	}
+	assert.Equal(t, "", exceededRequest(BuildTestPod("p1", 96000, 64*1024*1024*1024), maxFree))
+	assert.Equal(t, "cpu 97 > max 96", exceededRequest(BuildTestPod("p2", 97000, 0), maxFree))
+	assert.Equal(t, "memory 128Gi > max 64Gi", exceededRequest(BuildTestPod("p3", 1000, 128*1024*1024*1024), maxFree))
+
+	gpuPod := BuildTestPod("p4", 1000, 0)
+	gpuPod.Spec.Containers[0].Resources.Requests["nvidia.com/gpu"] = *resource.NewQuantity(1, resource.DecimalSI)
+	assert.Equal(t, "nvidia.com/gpu 1 > max 0", exceededRequest(gpuPod, maxFree))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/golang/glog"
+)
+
+type podEquivalenceEntry struct {
+	resourceVersion string
+	hash            string
+}
+
+// PodEquivalenceCache keeps equivalence hashes of pods between loops, so that pods which didn't
+// change since the last loop aren't hashed again. Hashes are keyed by pod UID and are valid as long
+// as the resource version of the pod is the same. Entries of pods not seen in a loop are dropped
+// by CleanUp.
+type PodEquivalenceCache struct {
+	entries map[types.UID]podEquivalenceEntry
+	used    map[types.UID]bool
+}
+
+// NewPodEquivalenceCache builds an empty PodEquivalenceCache.
+func NewPodEquivalenceCache() *PodEquivalenceCache {
+	return &PodEquivalenceCache{
+		entries: make(map[types.UID]podEquivalenceEntry),
+		used:    make(map[types.UID]bool),
+	}
+}
+
+// hash returns the equivalence hash of the pod, from the cache if the pod didn't change. Pods
+// without UID or resource version, such as pods built by CA itself, are always hashed. A nil
+// cache hashes every pod.
+func (c *PodEquivalenceCache) hash(pod *apiv1.Pod) string {
+	if c == nil || pod.UID == "" || pod.ResourceVersion == "" {
+		return podEquivalenceHash(pod)
+	}
+	c.used[pod.UID] = true
+	if entry, found := c.entries[pod.UID]; found && entry.resourceVersion == pod.ResourceVersion {
+		return entry.hash
+	}
+	hash := podEquivalenceHash(pod)
+	c.entries[pod.UID] = podEquivalenceEntry{resourceVersion: pod.ResourceVersion, hash: hash}
+	return hash
+}
+
+// CleanUp drops hashes of pods that weren't looked up since the previous CleanUp.
+func (c *PodEquivalenceCache) CleanUp() {
+	for uid := range c.entries {
+		if !c.used[uid] {
+			delete(c.entries, uid)
+		}
+	}
+	c.used = make(map[types.UID]bool)
+}
+
+// podEquivalenceHash returns a hash of labels and spec of the pod. Pods of the same controller
+// with the same hash behave the same way in scheduling simulations.
+func podEquivalenceHash(pod *apiv1.Pod) string {
+	data, err := json.Marshal(struct {
+		Labels map[string]string
+		Spec   apiv1.PodSpec
+	}{pod.Labels, pod.Spec})
+	if err != nil {
+		// Pods come from the API server, so this shouldn't happen. Such a pod is only equivalent to itself.
+		glog.Errorf("Failed to serialize pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return fmt.Sprintf("pod:%p", pod)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodEquivalenceHash(t *testing.T) {
+	p1 := BuildTestPod("p1", 500, 1000)
+	p2 := BuildTestPod("p2", 500, 1000)
+	p3 := BuildTestPod("p3", 1000, 1000)
+	assert.Equal(t, podEquivalenceHash(p1), podEquivalenceHash(p2))
+	assert.NotEqual(t, podEquivalenceHash(p1), podEquivalenceHash(p3))
+
+	p2.Labels = map[string]string{"app": "web"}
+	assert.NotEqual(t, podEquivalenceHash(p1), podEquivalenceHash(p2))
+}
+
+func TestPodEquivalenceCache(t *testing.T) {
+	cache := NewPodEquivalenceCache()
+	pod := BuildTestPod("p1", 500, 1000)
+	pod.UID = "uid-1"
+	pod.ResourceVersion = "1"
+	cache.entries[pod.UID] = podEquivalenceEntry{resourceVersion: "1", hash: "cached"}
+
+	// Unchanged pods aren't hashed again.
+	assert.Equal(t, "cached", cache.hash(pod))
+
+	// Changed pods are.
+	pod.ResourceVersion = "2"
+	assert.Equal(t, podEquivalenceHash(pod), cache.hash(pod))
+	assert.Equal(t, "2", cache.entries[pod.UID].resourceVersion)
+
+	// Pods without resource version aren't cached.
+	other := BuildTestPod("p2", 500, 1000)
+	other.UID = "uid-2"
+	assert.Equal(t, podEquivalenceHash(other), cache.hash(other))
+	assert.NotContains(t, cache.entries, other.UID)
+
+	// Entries of pods not looked up since the previous clean up are dropped.
+	cache.CleanUp()
+	assert.Contains(t, cache.entries, pod.UID)
+	cache.CleanUp()
+	assert.NotContains(t, cache.entries, pod.UID)
+
+	var nilCache *PodEquivalenceCache
+	assert.Equal(t, podEquivalenceHash(pod), nilCache.hash(pod))
+}
+
+func buildTestPendingPods(count, controllers int) []*apiv1.Pod {
+	pods := make([]*apiv1.Pod, 0, count)
+	for i := 0; i < count; i++ {
+		pod := BuildTestPod(fmt.Sprintf("p%d", i), 500, 1000)
+		pod.UID = types.UID(fmt.Sprintf("uid-%d", i))
+		pod.ResourceVersion = "1"
+		controller := fmt.Sprintf("rs%d", i%controllers)
+		pod.OwnerReferences = GenerateOwnerReferences(controller, "ReplicaSet", "extensions/v1beta1", types.UID(controller))
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func TestFilterOutSchedulableWithCache(t *testing.T) {
+	pods := buildTestPendingPods(10, 2)
+	node := BuildTestNode("n1", 1000, 2000)
+	SetNodeReadyState(node, true, time.Time{})
+	cache := NewPodEquivalenceCache()
+
+	result := FilterOutSchedulableWithCache(pods, []*apiv1.Node{node}, []*apiv1.Pod{}, []*apiv1.Pod{},
+		simulator.NewTestPredicateChecker(), 10, cache)
+	assert.Empty(t, result)
+	assert.Len(t, cache.entries, 10)
+
+	// Pods that are no longer pending are dropped from the cache.
+	full := BuildTestNode("n1", 100, 2000)
+	SetNodeReadyState(full, true, time.Time{})
+	result = FilterOutSchedulableWithCache(pods[:4], []*apiv1.Node{full}, []*apiv1.Pod{}, []*apiv1.Pod{},
+		simulator.NewTestPredicateChecker(), 10, cache)
+	assert.Equal(t, pods[:4], result)
+	assert.Len(t, cache.entries, 4)
+}
+
+func benchmarkFilterOutSchedulable(b *testing.B, cache *PodEquivalenceCache) {
+	pods := buildTestPendingPods(50000, 100)
+	node := BuildTestNode("n1", 100, 2000)
+	SetNodeReadyState(node, true, time.Time{})
+	predicateChecker := simulator.NewTestPredicateChecker()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FilterOutSchedulableWithCache(pods, []*apiv1.Node{node}, []*apiv1.Pod{}, []*apiv1.Pod{}, predicateChecker, 10, cache)
+	}
+}
+
+func BenchmarkFilterOutSchedulable50kPods(b *testing.B) {
+	benchmarkFilterOutSchedulable(b, nil)
+}
+
+func BenchmarkFilterOutSchedulable50kPodsWithCache(b *testing.B) {
+	benchmarkFilterOutSchedulable(b, NewPodEquivalenceCache())
+}
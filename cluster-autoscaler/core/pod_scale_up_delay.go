@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// PodScaleUpDelayAnnotationKey is the annotation on a pod overriding how old the pod has to be
+	// before it can trigger a scale-up, for example "2m".
+	PodScaleUpDelayAnnotationKey = "cluster-autoscaler.kubernetes.io/pod-scale-up-delay"
+
+	// fastFailuresForAdaptiveDelay is the number of pods of a controller that have to terminate
+	// quickly before new pods of the controller get the adaptive delay.
+	fastFailuresForAdaptiveDelay = 2
+	// controllerHistoryTTL is how long the history of a controller without any pods is kept.
+	controllerHistoryTTL = time.Hour
+)
+
+// podObservation is the last time a started pod was seen scheduled.
+type podObservation struct {
+	owner    types.UID
+	started  time.Time
+	lastSeen time.Time
+}
+
+// controllerHistory counts pods of a controller that terminated soon after starting.
+type controllerHistory struct {
+	fastFailures int
+	lastUpdate   time.Time
+}
+
+// PodScaleUpDelayFilter holds back pending pods that are too new to trigger a scale-up. Pods of
+// controllers whose previous pods kept terminating soon after starting wait longer, so that
+// controllers rapidly recreating pods don't cause scale-ups of nodes which are empty minutes later.
+type PodScaleUpDelayFilter struct {
+	defaultDelay         time.Duration
+	namespaceDelays      map[string]time.Duration
+	adaptiveDelay        time.Duration
+	fastFailureThreshold time.Duration
+	observations         map[types.UID]podObservation
+	history              map[types.UID]*controllerHistory
+}
+
+// NewPodScaleUpDelayFilter builds a PodScaleUpDelayFilter from autoscaling options.
+func NewPodScaleUpDelayFilter(options AutoscalingOptions) (*PodScaleUpDelayFilter, error) {
+	namespaceDelays := make(map[string]time.Duration)
+	for _, value := range options.NewPodScaleUpDelayPerNamespace {
+		tokens := strings.SplitN(value, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("wrong namespace scale-up delay %q, expected <namespace>=<duration>", value)
+		}
+		delay, err := time.ParseDuration(tokens[1])
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("wrong namespace scale-up delay %q, expected a non-negative duration", value)
+		}
+		namespaceDelays[tokens[0]] = delay
+	}
+	return &PodScaleUpDelayFilter{
+		defaultDelay:         options.NewPodScaleUpDelay,
+		namespaceDelays:      namespaceDelays,
+		adaptiveDelay:        options.AdaptivePodScaleUpDelay,
+		fastFailureThreshold: options.FastPodFailureThreshold,
+		observations:         make(map[types.UID]podObservation),
+		history:              make(map[types.UID]*controllerHistory),
+	}, nil
+}
+
+// ObservePods updates the history of controllers with the currently scheduled pods. A pod that is
+// gone within fastFailureThreshold of starting counts as a fast failure of its controller, while a
+// pod running for longer resets the controller history.
+func (f *PodScaleUpDelayFilter) ObservePods(scheduledPods []*apiv1.Pod, now time.Time) {
+	if f.adaptiveDelay == 0 {
+		return
+	}
+	seen := make(map[types.UID]bool, len(scheduledPods))
+	for _, pod := range scheduledPods {
+		ref := drain.ControllerRef(pod)
+		if ref == nil || pod.Status.StartTime == nil {
+			continue
+		}
+		seen[pod.UID] = true
+		started := pod.Status.StartTime.Time
+		f.observations[pod.UID] = podObservation{owner: ref.UID, started: started, lastSeen: now}
+		if pod.Status.Phase == apiv1.PodRunning && now.Sub(started) >= f.fastFailureThreshold {
+			if history, found := f.history[ref.UID]; found && history.fastFailures > 0 {
+				glog.V(4).Infof("Pod %s/%s is running for %v, resetting fast failures of its controller", pod.Namespace, pod.Name, now.Sub(started))
+			}
+			delete(f.history, ref.UID)
+		}
+	}
+	for uid, observation := range f.observations {
+		if seen[uid] {
+			continue
+		}
+		delete(f.observations, uid)
+		if observation.lastSeen.Sub(observation.started) < f.fastFailureThreshold {
+			history, found := f.history[observation.owner]
+			if !found {
+				history = &controllerHistory{}
+				f.history[observation.owner] = history
+			}
+			history.fastFailures++
+			history.lastUpdate = now
+		}
+	}
+	for owner, history := range f.history {
+		if now.Sub(history.lastUpdate) > controllerHistoryTTL {
+			delete(f.history, owner)
+		}
+	}
+}
+
+// ScaleUpDelay returns how old the pod has to be before it can trigger a scale-up.
+func (f *PodScaleUpDelayFilter) ScaleUpDelay(pod *apiv1.Pod) time.Duration {
+	delay := f.defaultDelay
+	if namespaceDelay, found := f.namespaceDelays[pod.Namespace]; found {
+		delay = namespaceDelay
+	}
+	if value, found := pod.Annotations[PodScaleUpDelayAnnotationKey]; found {
+		if podDelay, err := time.ParseDuration(value); err == nil && podDelay >= 0 {
+			delay = podDelay
+		} else {
+			glog.Warningf("Pod %s/%s has invalid %s annotation %q", pod.Namespace, pod.Name, PodScaleUpDelayAnnotationKey, value)
+		}
+	}
+	if ref := drain.ControllerRef(pod); ref != nil && f.adaptiveDelay > 0 {
+		if history, found := f.history[ref.UID]; found && history.fastFailures >= fastFailuresForAdaptiveDelay {
+			delay += f.adaptiveDelay
+		}
+	}
+	return delay
+}
+
+// FilterOutNewPods returns the pods that are old enough to trigger a scale-up.
+func (f *PodScaleUpDelayFilter) FilterOutNewPods(pods []*apiv1.Pod, now time.Time) []*apiv1.Pod {
+	result := make([]*apiv1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		delay := f.ScaleUpDelay(pod)
+		if age := now.Sub(pod.CreationTimestamp.Time); age < delay {
+			glog.V(4).Infof("Pod %s/%s is too new to trigger a scale-up (%v < %v)", pod.Namespace, pod.Name, age, delay)
+			continue
+		}
+		result = append(result, pod)
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildDelayTestPod(name string, ownerRefs []metav1.OwnerReference, created time.Time) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 0)
+	pod.UID = types.UID(name)
+	pod.OwnerReferences = ownerRefs
+	pod.CreationTimestamp = metav1.NewTime(created)
+	return pod
+}
+
+func startDelayTestPod(pod *apiv1.Pod, started time.Time) *apiv1.Pod {
+	startTime := metav1.NewTime(started)
+	pod.Status.StartTime = &startTime
+	pod.Status.Phase = apiv1.PodRunning
+	pod.Spec.NodeName = "n1"
+	return pod
+}
+
+func TestPodScaleUpDelay(t *testing.T) {
+	filter, err := NewPodScaleUpDelayFilter(AutoscalingOptions{
+		NewPodScaleUpDelay:             30 * time.Second,
+		NewPodScaleUpDelayPerNamespace: []string{"batch=2m", "urgent=0s"},
+	})
+	assert.NoError(t, err)
+	now := time.Now()
+
+	p1 := buildDelayTestPod("p1", nil, now.Add(-time.Minute))
+	p2 := buildDelayTestPod("p2", nil, now.Add(-10*time.Second))
+	p3 := buildDelayTestPod("p3", nil, now.Add(-time.Minute))
+	p3.Namespace = "batch"
+	p4 := buildDelayTestPod("p4", nil, now)
+	p4.Namespace = "urgent"
+	p5 := buildDelayTestPod("p5", nil, now.Add(-time.Minute))
+	p5.Namespace = "batch"
+	p5.Annotations = map[string]string{PodScaleUpDelayAnnotationKey: "10s"}
+	p6 := buildDelayTestPod("p6", nil, now.Add(-time.Minute))
+	p6.Annotations = map[string]string{PodScaleUpDelayAnnotationKey: "a while"}
+
+	assert.Equal(t, 2*time.Minute, filter.ScaleUpDelay(p3))
+	assert.Equal(t, 10*time.Second, filter.ScaleUpDelay(p5))
+	assert.Equal(t, []*apiv1.Pod{p1, p4, p5, p6}, filter.FilterOutNewPods([]*apiv1.Pod{p1, p2, p3, p4, p5, p6}, now))
+
+	_, err = NewPodScaleUpDelayFilter(AutoscalingOptions{NewPodScaleUpDelayPerNamespace: []string{"batch"}})
+	assert.Error(t, err)
+	_, err = NewPodScaleUpDelayFilter(AutoscalingOptions{NewPodScaleUpDelayPerNamespace: []string{"batch=-1m"}})
+	assert.Error(t, err)
+}
+
+func TestAdaptivePodScaleUpDelay(t *testing.T) {
+	filter, err := NewPodScaleUpDelayFilter(AutoscalingOptions{
+		AdaptivePodScaleUpDelay: 5 * time.Minute,
+		FastPodFailureThreshold: 30 * time.Second,
+	})
+	assert.NoError(t, err)
+	ownerRefs := GenerateOwnerReferences("job", "Job", "batch/v1", "job-uid")
+	otherRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "rs-uid")
+	now := time.Now()
+	pending := buildDelayTestPod("pending", ownerRefs, now.Add(-time.Minute))
+	other := buildDelayTestPod("other", otherRefs, now.Add(-time.Minute))
+
+	// First fast failure.
+	filter.ObservePods([]*apiv1.Pod{startDelayTestPod(buildDelayTestPod("f1", ownerRefs, now), now)}, now)
+	now = now.Add(10 * time.Second)
+	filter.ObservePods([]*apiv1.Pod{}, now)
+	assert.Equal(t, time.Duration(0), filter.ScaleUpDelay(pending))
+	assert.Equal(t, []*apiv1.Pod{pending, other}, filter.FilterOutNewPods([]*apiv1.Pod{pending, other}, now))
+
+	// Second fast failure, the adaptive delay kicks in.
+	filter.ObservePods([]*apiv1.Pod{startDelayTestPod(buildDelayTestPod("f2", ownerRefs, now), now)}, now)
+	now = now.Add(10 * time.Second)
+	filter.ObservePods([]*apiv1.Pod{}, now)
+	assert.Equal(t, 5*time.Minute, filter.ScaleUpDelay(pending))
+	assert.Equal(t, time.Duration(0), filter.ScaleUpDelay(other))
+	assert.Equal(t, []*apiv1.Pod{other}, filter.FilterOutNewPods([]*apiv1.Pod{pending, other}, now))
+
+	// A pod running longer than the threshold resets the history.
+	running := startDelayTestPod(buildDelayTestPod("r1", ownerRefs, now), now)
+	filter.ObservePods([]*apiv1.Pod{running}, now)
+	assert.Equal(t, 5*time.Minute, filter.ScaleUpDelay(pending))
+	now = now.Add(time.Minute)
+	filter.ObservePods([]*apiv1.Pod{running}, now)
+	assert.Equal(t, time.Duration(0), filter.ScaleUpDelay(pending))
+
+	// Pods terminating after running for long are not fast failures.
+	now = now.Add(10 * time.Second)
+	filter.ObservePods([]*apiv1.Pod{}, now)
+	assert.Equal(t, time.Duration(0), filter.ScaleUpDelay(pending))
+}
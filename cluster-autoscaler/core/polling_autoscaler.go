@@ -61,6 +61,11 @@ func (a *PollingAutoscaler) ExitCleanUp() {
 	a.autoscaler.ExitCleanUp()
 }
 
+// LastLoopActive checks if the last loop of the wrapped autoscaler had anything to do
+func (a *PollingAutoscaler) LastLoopActive() bool {
+	return a.autoscaler.LastLoopActive()
+}
+
 // RunOnce represents a single iteration of a polling autoscaler inside the CA's control-loop
 func (a *PollingAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError {
 	reconfigureStart := time.Now()
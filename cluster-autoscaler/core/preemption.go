@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// preemptionCandidate is a node on which a pod fits after preempting victims.
+type preemptionCandidate struct {
+	node      *apiv1.Node
+	remaining []*apiv1.Pod
+	victims   []*apiv1.Pod
+}
+
+// maxVictimPriority returns the highest priority among the victims.
+func (c *preemptionCandidate) maxVictimPriority() int32 {
+	result := podPriority(c.victims[0])
+	for _, victim := range c.victims[1:] {
+		if priority := podPriority(victim); priority > result {
+			result = priority
+		}
+	}
+	return result
+}
+
+func (c *preemptionCandidate) betterThan(other *preemptionCandidate) bool {
+	if other == nil {
+		return true
+	}
+	if c.maxVictimPriority() != other.maxVictimPriority() {
+		return c.maxVictimPriority() < other.maxVictimPriority()
+	}
+	return len(c.victims) < len(other.victims)
+}
+
+// FilterOutPreemptingPods simulates scheduler preemption for pods that don't fit on any node as is.
+// Pods that the scheduler will place by preempting lower priority pods don't need new nodes, so
+// they are filtered out. Instead, the returned victims are pods that will be preempted and recreated
+// by their controllers, as they will need capacity. Pods are processed in the order of decreasing
+// priority and each preemption is applied to the simulated cluster before the next pod is processed.
+// Like other pending pods, victims that fit on existing nodes after all preemptions are filtered out.
+func FilterOutPreemptingPods(unschedulablePods []*apiv1.Pod, nodes []*apiv1.Node, allScheduled []*apiv1.Pod,
+	podsWaitingForLowerPriorityPreemption []*apiv1.Pod, predicateChecker *simulator.PredicateChecker,
+	expendablePodsPriorityCutoff int) ([]*apiv1.Pod, []*apiv1.Pod) {
+
+	podsOnNodes := make(map[string][]*apiv1.Pod)
+	for _, pod := range allScheduled {
+		podsOnNodes[pod.Spec.NodeName] = append(podsOnNodes[pod.Spec.NodeName], pod)
+	}
+	// Pods waiting for preemption already have a place reserved and are not going to be preempted.
+	notPreemptible := make(map[*apiv1.Pod]bool)
+	for _, pod := range podsWaitingForLowerPriorityPreemption {
+		nodeName := pod.Annotations[scheduler_util.NominatedNodeAnnotationKey]
+		podsOnNodes[nodeName] = append(podsOnNodes[nodeName], pod)
+		notPreemptible[pod] = true
+	}
+	sortedNodes := make([]*apiv1.Node, len(nodes))
+	copy(sortedNodes, nodes)
+	sort.Slice(sortedNodes, func(i, j int) bool { return sortedNodes[i].Name < sortedNodes[j].Name })
+
+	sortedPods := make([]*apiv1.Pod, len(unschedulablePods))
+	copy(sortedPods, unschedulablePods)
+	sort.SliceStable(sortedPods, func(i, j int) bool { return podPriority(sortedPods[i]) > podPriority(sortedPods[j]) })
+
+	result := make([]*apiv1.Pod, 0, len(unschedulablePods))
+	victims := make([]*apiv1.Pod, 0)
+	for _, pod := range sortedPods {
+		var best *preemptionCandidate
+		for _, node := range sortedNodes {
+			candidate := simulatePreemption(pod, node, podsOnNodes[node.Name], notPreemptible, predicateChecker)
+			if candidate != nil && candidate.betterThan(best) {
+				best = candidate
+			}
+		}
+		if best == nil {
+			result = append(result, pod)
+			continue
+		}
+		glog.V(4).Infof("Pod %s/%s will preempt %d pods on %s. Ignoring in scale up.", pod.Namespace, pod.Name, len(best.victims), best.node.Name)
+		podsOnNodes[best.node.Name] = append(best.remaining, pod)
+		for _, victim := range best.victims {
+			if podCopy := recreatedPodCopy(victim); podCopy != nil {
+				victims = append(victims, podCopy)
+			}
+		}
+	}
+	if len(victims) == 0 {
+		return result, victims
+	}
+
+	// Preempting pods are bound to their nodes in the simulated cluster, victims are gone.
+	scheduledAfterPreemption := make([]*apiv1.Pod, 0, len(allScheduled))
+	for nodeName, pods := range podsOnNodes {
+		for _, pod := range pods {
+			if notPreemptible[pod] {
+				continue
+			}
+			if pod.Spec.NodeName != nodeName {
+				podCopy := *pod
+				podCopy.Spec.NodeName = nodeName
+				pod = &podCopy
+			}
+			scheduledAfterPreemption = append(scheduledAfterPreemption, pod)
+		}
+	}
+	victims = FilterOutSchedulable(victims, nodes, scheduledAfterPreemption, podsWaitingForLowerPriorityPreemption,
+		predicateChecker, expendablePodsPriorityCutoff)
+	return result, victims
+}
+
+// simulatePreemption checks if the pod fits on the node after removing lower priority pods. Like the
+// scheduler, it removes all of them first and then reprieves as many as possible, starting from the
+// highest priority ones. Returns nil if the pod doesn't fit or no preemption is needed.
+func simulatePreemption(pod *apiv1.Pod, node *apiv1.Node, podsOnNode []*apiv1.Pod, notPreemptible map[*apiv1.Pod]bool,
+	predicateChecker *simulator.PredicateChecker) *preemptionCandidate {
+
+	priority := podPriority(pod)
+	remaining := make([]*apiv1.Pod, 0, len(podsOnNode))
+	potentialVictims := make([]*apiv1.Pod, 0)
+	for _, podOnNode := range podsOnNode {
+		if !notPreemptible[podOnNode] && podPriority(podOnNode) < priority {
+			potentialVictims = append(potentialVictims, podOnNode)
+		} else {
+			remaining = append(remaining, podOnNode)
+		}
+	}
+	if len(potentialVictims) == 0 {
+		return nil
+	}
+	fits := func(pods []*apiv1.Pod) bool {
+		nodeInfo := schedulercache.NewNodeInfo(pods...)
+		nodeInfo.SetNode(node)
+		return predicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnSimpleError) == nil
+	}
+	if !fits(remaining) {
+		return nil
+	}
+
+	sort.SliceStable(potentialVictims, func(i, j int) bool {
+		return podPriority(potentialVictims[i]) > podPriority(potentialVictims[j])
+	})
+	victims := make([]*apiv1.Pod, 0)
+	for _, potentialVictim := range potentialVictims {
+		if fits(append(remaining, potentialVictim)) {
+			remaining = append(remaining, potentialVictim)
+		} else {
+			victims = append(victims, potentialVictim)
+		}
+	}
+	if len(victims) == 0 {
+		return nil
+	}
+	return &preemptionCandidate{
+		node:      node,
+		remaining: remaining,
+		victims:   victims,
+	}
+}
+
+func podPriority(pod *apiv1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
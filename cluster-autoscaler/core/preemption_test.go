@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	lowPriority  = int32(10)
+	midPriority  = int32(100)
+	highPriority = int32(1000)
+)
+
+func buildPriorityPod(name string, cpu int64, priority int32, nodeName string) *apiv1.Pod {
+	pod := BuildTestPod(name, cpu, 0)
+	pod.Spec.Priority = &priority
+	pod.Spec.NodeName = nodeName
+	pod.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	return pod
+}
+
+func podNameList(pods []*apiv1.Pod) []string {
+	result := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		result = append(result, pod.Name)
+	}
+	return result
+}
+
+func TestFilterOutPreemptingPods(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 2000000)
+	SetNodeReadyState(n2, true, time.Time{})
+	n3 := BuildTestNode("n3", 1000, 2000000)
+	SetNodeReadyState(n3, true, time.Time{})
+
+	// n1 runs low priority pods, the bare one won't be recreated after preemption.
+	lowOnN1 := buildPriorityPod("low-n1", 500, lowPriority, "n1")
+	bareLowOnN1 := buildPriorityPod("bare-low-n1", 400, lowPriority, "n1")
+	bareLowOnN1.OwnerReferences = nil
+	// n2 runs a mid priority pod, n3 is full of high priority pods.
+	midOnN2 := buildPriorityPod("mid-n2", 800, midPriority, "n2")
+	highOnN3 := buildPriorityPod("high-n3", 800, highPriority, "n3")
+
+	// Preempts both pods on n1, as reprieving any of them wouldn't leave enough space.
+	highPending := buildPriorityPod("high-pending", 700, highPriority, "")
+	// Nothing with lower priority is left on n1 and n3, n2 has a pod of the same priority.
+	midPending := buildPriorityPod("mid-pending", 500, midPriority, "")
+	// Can't preempt anything.
+	lowPending := buildPriorityPod("low-pending", 500, lowPriority, "")
+
+	scheduled := []*apiv1.Pod{lowOnN1, bareLowOnN1, midOnN2, highOnN3}
+	podsToHelp, victims := FilterOutPreemptingPods([]*apiv1.Pod{lowPending, midPending, highPending},
+		[]*apiv1.Node{n1, n2, n3}, scheduled, []*apiv1.Pod{}, simulator.NewTestPredicateChecker(), -10)
+
+	assert.Equal(t, []string{"mid-pending", "low-pending"}, podNameList(podsToHelp))
+	assert.Equal(t, []string{"low-n1"}, podNameList(victims))
+	assert.Equal(t, "", victims[0].Spec.NodeName)
+	assert.Equal(t, "n1", lowOnN1.Spec.NodeName)
+}
+
+func TestFilterOutPreemptingPodsReprieve(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 2000000)
+	SetNodeReadyState(n2, true, time.Time{})
+
+	lowOnN1 := buildPriorityPod("low-n1", 300, lowPriority, "n1")
+	midOnN1 := buildPriorityPod("mid-n1", 300, midPriority, "n1")
+	lowOnN2 := buildPriorityPod("low-n2", 800, lowPriority, "n2")
+
+	// Fits on n1 after preempting only the low priority pod, mid priority pod is reprieved.
+	highPending := buildPriorityPod("high-pending", 600, highPriority, "")
+	podsToHelp, victims := FilterOutPreemptingPods([]*apiv1.Pod{highPending}, []*apiv1.Node{n1, n2},
+		[]*apiv1.Pod{lowOnN1, midOnN1, lowOnN2}, []*apiv1.Pod{}, simulator.NewTestPredicateChecker(), -10)
+	assert.Empty(t, podsToHelp)
+	assert.Equal(t, []string{"low-n1"}, podNameList(victims))
+}
+
+func TestFilterOutPreemptingPodsWaitingForPreemption(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(n1, true, time.Time{})
+
+	lowOnN1 := buildPriorityPod("low-n1", 200, lowPriority, "n1")
+	// Already nominated to n1, its place is reserved.
+	nominated := buildPriorityPod("nominated", 700, lowPriority, "")
+	nominated.Annotations = map[string]string{scheduler_util.NominatedNodeAnnotationKey: "n1"}
+
+	highPending := buildPriorityPod("high-pending", 500, highPriority, "")
+	podsToHelp, victims := FilterOutPreemptingPods([]*apiv1.Pod{highPending}, []*apiv1.Node{n1},
+		[]*apiv1.Pod{lowOnN1}, []*apiv1.Pod{nominated}, simulator.NewTestPredicateChecker(), -10)
+	assert.Equal(t, []string{"high-pending"}, podNameList(podsToHelp))
+	assert.Empty(t, victims)
+}
+
+func TestFilterOutPreemptingPodsVictimFitsElsewhere(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 2000000)
+	SetNodeReadyState(n2, true, time.Time{})
+
+	lowOnN1 := buildPriorityPod("low-n1", 600, lowPriority, "n1")
+	midOnN2 := buildPriorityPod("mid-n2", 300, midPriority, "n2")
+
+	// Preempts the pod on n1, which is then recreated and fits on n2.
+	highPending := buildPriorityPod("high-pending", 800, highPriority, "")
+	podsToHelp, victims := FilterOutPreemptingPods([]*apiv1.Pod{highPending}, []*apiv1.Node{n1, n2},
+		[]*apiv1.Pod{lowOnN1, midOnN2}, []*apiv1.Pod{}, simulator.NewTestPredicateChecker(), -10)
+	assert.Empty(t, podsToHelp)
+	assert.Empty(t, victims)
+}
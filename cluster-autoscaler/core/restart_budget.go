@@ -0,0 +1,251 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+
+	apiv1 "k8s.io/api/core/v1"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DailyRestartBudgetAnnotationKey is the name of the pod annotation limiting how many pods of the
+	// pod's controller CA may evict in 24 hours, across scale-downs and compactions. It should be set
+	// in the pod template, so that all pods of the controller have the same budget.
+	DailyRestartBudgetAnnotationKey = "cluster-autoscaler.kubernetes.io/daily-restart-budget"
+	// RestartBudgetConfigMapName is the name of the ConfigMap in which CA keeps evictions of pods
+	// with a restart budget, so that a restart of CA doesn't reset the budgets.
+	RestartBudgetConfigMapName = "cluster-autoscaler-restart-budget"
+	// restartBudgetWindow is the rolling window restart budgets apply to.
+	restartBudgetWindow = 24 * time.Hour
+	// restartBudgetEvictionsKey is the ConfigMap data key holding the evictions.
+	restartBudgetEvictionsKey = "evictions"
+)
+
+// restartBudgetTracker remembers evictions of pods with DailyRestartBudgetAnnotationKey done by CA in
+// the last 24 hours, per controller UID. Pods without the annotation are not tracked.
+type restartBudgetTracker struct {
+	sync.Mutex
+	client    kube_client.Interface
+	namespace string
+	// evictions are the times of evictions by controller UID. Nil until loaded from the ConfigMap.
+	evictions map[string][]time.Time
+	// unsaved are evictions recorded while the ConfigMap couldn't be loaded. They are merged into
+	// evictions once it is.
+	unsaved map[string][]time.Time
+}
+
+func newRestartBudgetTracker(client kube_client.Interface, namespace string) *restartBudgetTracker {
+	return &restartBudgetTracker{
+		client:    client,
+		namespace: namespace,
+		unsaved:   make(map[string][]time.Time),
+	}
+}
+
+// restartBudget returns the daily restart budget of the pod and the UID of its controller. False
+// if the pod has no valid budget.
+func restartBudget(pod *apiv1.Pod) (int, string, bool) {
+	value, found := pod.Annotations[DailyRestartBudgetAnnotationKey]
+	if !found {
+		return 0, "", false
+	}
+	budget, err := strconv.Atoi(value)
+	if err != nil || budget < 0 {
+		glog.Warningf("Pod %s/%s has invalid %s annotation %q", pod.Namespace, pod.Name, DailyRestartBudgetAnnotationKey, value)
+		return 0, "", false
+	}
+	if controllerRef := drain.ControllerRef(pod); controllerRef != nil {
+		return budget, string(controllerRef.UID), true
+	}
+	return budget, string(pod.UID), true
+}
+
+// exceedingPods returns the pods whose controllers would exceed their restart budgets if all
+// pods were evicted at now. If the evictions can't be loaded, all pods with a budget are returned.
+func (t *restartBudgetTracker) exceedingPods(pods []*apiv1.Pod, now time.Time) []*apiv1.Pod {
+	budgets := make(map[string]int)
+	planned := make(map[string]int)
+	for _, pod := range pods {
+		if budget, uid, found := restartBudget(pod); found {
+			budgets[uid] = budget
+			planned[uid]++
+		}
+	}
+	if len(budgets) == 0 {
+		return nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	loadErr := t.load()
+	if loadErr != nil {
+		glog.Warningf("Failed to load restart budgets, not evicting pods with budgets: %v", loadErr)
+	}
+	exceeding := make(map[string]bool)
+	for uid, budget := range budgets {
+		if loadErr != nil || len(t.recentEvictions(uid, now))+planned[uid] > budget {
+			exceeding[uid] = true
+		}
+	}
+	result := make([]*apiv1.Pod, 0)
+	for _, pod := range pods {
+		if _, uid, found := restartBudget(pod); found && exceeding[uid] {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
+
+// recordEvictions remembers evictions of the pods with restart budgets and saves them to the
+// ConfigMap. If the ConfigMap can't be loaded, the evictions are kept in memory and saved once it
+// is, so that the evictions already in the ConfigMap are never overwritten.
+func (t *restartBudgetTracker) recordEvictions(pods []*apiv1.Pod, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	for _, pod := range pods {
+		if _, uid, found := restartBudget(pod); found {
+			t.unsaved[uid] = append(t.unsaved[uid], now)
+		}
+	}
+	if len(t.unsaved) == 0 {
+		return
+	}
+	if err := t.load(); err != nil {
+		glog.Warningf("Failed to load restart budgets, evictions will be saved later: %v", err)
+		return
+	}
+	for uid := range t.evictions {
+		if recent := t.recentEvictions(uid, now); len(recent) > 0 {
+			t.evictions[uid] = recent
+		} else {
+			delete(t.evictions, uid)
+		}
+	}
+	if err := t.save(); err != nil {
+		glog.Errorf("Failed to save restart budgets: %v", err)
+	}
+}
+
+// recentEvictions returns evictions of the controller within restartBudgetWindow before now.
+// To be executed under a lock.
+func (t *restartBudgetTracker) recentEvictions(uid string, now time.Time) []time.Time {
+	result := make([]time.Time, 0, len(t.evictions[uid]))
+	for _, evicted := range t.evictions[uid] {
+		if evicted.Add(restartBudgetWindow).After(now) {
+			result = append(result, evicted)
+		}
+	}
+	return result
+}
+
+// load reads the evictions from the ConfigMap, unless they were already read, and merges the
+// unsaved evictions into them. To be executed under a lock.
+func (t *restartBudgetTracker) load() error {
+	if t.evictions == nil {
+		configMap, err := t.client.CoreV1().ConfigMaps(t.namespace).Get(RestartBudgetConfigMapName, metav1.GetOptions{})
+		evictions := make(map[string][]time.Time)
+		if err != nil && !kube_errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			if data, found := configMap.Data[restartBudgetEvictionsKey]; found {
+				if err := json.Unmarshal([]byte(data), &evictions); err != nil {
+					return fmt.Errorf("invalid %s ConfigMap: %v", RestartBudgetConfigMapName, err)
+				}
+			}
+		}
+		t.evictions = evictions
+	}
+	for uid, evicted := range t.unsaved {
+		t.evictions[uid] = append(t.evictions[uid], evicted...)
+	}
+	t.unsaved = make(map[string][]time.Time)
+	return nil
+}
+
+// save writes the evictions to the ConfigMap, creating it if needed.
+// To be executed under a lock.
+func (t *restartBudgetTracker) save() error {
+	data, err := json.Marshal(t.evictions)
+	if err != nil {
+		return err
+	}
+	maps := t.client.CoreV1().ConfigMaps(t.namespace)
+	configMap, err := maps.Get(RestartBudgetConfigMapName, metav1.GetOptions{})
+	if kube_errors.IsNotFound(err) {
+		_, err = maps.Create(&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: t.namespace,
+				Name:      RestartBudgetConfigMapName,
+			},
+			Data: map[string]string{restartBudgetEvictionsKey: string(data)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[restartBudgetEvictionsKey] = string(data)
+	_, err = maps.Update(configMap)
+	return err
+}
+
+// filterOutOverRestartBudget moves nodes whose pods would exceed the restart budgets of their
+// controllers from nodesToRemove to the returned unremovable nodes.
+func (sd *ScaleDown) filterOutOverRestartBudget(nodesToRemove []simulator.NodeToBeRemoved, now time.Time) (
+	[]simulator.NodeToBeRemoved, []*simulator.UnremovableNode) {
+	result := make([]simulator.NodeToBeRemoved, 0, len(nodesToRemove))
+	unremovable := make([]*simulator.UnremovableNode, 0)
+	for _, toRemove := range nodesToRemove {
+		exceeding := sd.restartBudget.exceedingPods(toRemove.PodsToReschedule, now)
+		if len(exceeding) == 0 {
+			result = append(result, toRemove)
+			continue
+		}
+		blockingPods := make([]drain.BlockingPod, 0, len(exceeding))
+		for _, pod := range exceeding {
+			blockingPods = append(blockingPods, drain.BlockingPod{
+				Pod:    pod,
+				Reason: drain.RestartBudgetExhausted,
+				Detail: fmt.Sprintf("daily restart budget of %s exhausted", workloadKey(pod)),
+			})
+		}
+		glog.V(2).Infof("Node %s cannot be removed: %s", toRemove.Node.Name, drain.SummarizeBlockingPods(blockingPods))
+		unremovable = append(unremovable, &simulator.UnremovableNode{
+			Node:         toRemove.Node,
+			Reason:       simulator.UnremovableReason(drain.RestartBudgetExhausted),
+			BlockingPods: blockingPods,
+		})
+	}
+	return result, unremovable
+}
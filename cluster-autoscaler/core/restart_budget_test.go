@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildBudgetedPods(controller string, budget string, count int) []*apiv1.Pod {
+	pods := make([]*apiv1.Pod, 0, count)
+	for i := 0; i < count; i++ {
+		pod := BuildTestPod(fmt.Sprintf("%s-%d", controller, i), 100, 0)
+		pod.OwnerReferences = GenerateOwnerReferences(controller, "ReplicaSet", "extensions/v1beta1", types.UID("uid-"+controller))
+		pod.Annotations = map[string]string{DailyRestartBudgetAnnotationKey: budget}
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func TestRestartBudgetExhausted(t *testing.T) {
+	now := time.Now()
+	tracker := newRestartBudgetTracker(fake.NewSimpleClientset(), "kube-system")
+	pods := buildBudgetedPods("rs", "2", 3)
+	unbudgeted := BuildTestPod("naked", 100, 0)
+
+	assert.Empty(t, tracker.exceedingPods(pods[:2], now))
+	assert.Equal(t, pods, tracker.exceedingPods(pods, now))
+	assert.Empty(t, tracker.exceedingPods([]*apiv1.Pod{unbudgeted}, now))
+
+	tracker.recordEvictions(pods[:1], now)
+	assert.Empty(t, tracker.exceedingPods(pods[1:2], now))
+	assert.Equal(t, pods[1:3], tracker.exceedingPods(pods[1:3], now))
+
+	tracker.recordEvictions(pods[1:2], now)
+	assert.Equal(t, pods[2:3], tracker.exceedingPods(append([]*apiv1.Pod{unbudgeted}, pods[2]), now))
+
+	other := buildBudgetedPods("other-rs", "1", 1)
+	assert.Empty(t, tracker.exceedingPods(other, now))
+}
+
+func TestRestartBudgetInvalidAnnotation(t *testing.T) {
+	tracker := newRestartBudgetTracker(fake.NewSimpleClientset(), "kube-system")
+	pods := buildBudgetedPods("rs", "-1", 2)
+	pods = append(pods, buildBudgetedPods("other-rs", "many", 2)...)
+	assert.Empty(t, tracker.exceedingPods(pods, time.Now()))
+}
+
+func TestRestartBudgetPersistedAcrossRestart(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset()
+	pods := buildBudgetedPods("rs", "1", 2)
+
+	newRestartBudgetTracker(client, "kube-system").recordEvictions(pods[:1], now)
+	configMap, err := client.CoreV1().ConfigMaps("kube-system").Get(RestartBudgetConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, configMap.Data[restartBudgetEvictionsKey], "uid-rs")
+
+	restarted := newRestartBudgetTracker(client, "kube-system")
+	assert.Equal(t, pods[1:], restarted.exceedingPods(pods[1:], now.Add(time.Hour)))
+}
+
+func TestRestartBudgetLoadFailure(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset()
+	pods := buildBudgetedPods("rs", "2", 2)
+	other := buildBudgetedPods("other-rs", "2", 1)
+	newRestartBudgetTracker(client, "kube-system").recordEvictions(pods[:1], now)
+
+	failing := true
+	client.Fake.PrependReactor("get", "configmaps", func(action core.Action) (bool, runtime.Object, error) {
+		return failing, nil, fmt.Errorf("connection refused")
+	})
+	tracker := newRestartBudgetTracker(client, "kube-system")
+	tracker.recordEvictions(other, now)
+	assert.Equal(t, other, tracker.exceedingPods(other, now))
+
+	// Evictions in the ConfigMap are not overwritten and the unsaved one is saved with them later.
+	failing = false
+	tracker.recordEvictions(nil, now)
+	restarted := newRestartBudgetTracker(client, "kube-system")
+	assert.NoError(t, restarted.load())
+	assert.Len(t, restarted.evictions["uid-rs"], 1)
+	assert.Len(t, restarted.evictions["uid-other-rs"], 1)
+}
+
+func TestRestartBudgetExpiry(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset()
+	tracker := newRestartBudgetTracker(client, "kube-system")
+	pods := buildBudgetedPods("rs", "1", 1)
+	other := buildBudgetedPods("other-rs", "1", 1)
+
+	tracker.recordEvictions(pods, now)
+	assert.Equal(t, pods, tracker.exceedingPods(pods, now.Add(restartBudgetWindow-time.Minute)))
+	assert.Empty(t, tracker.exceedingPods(pods, now.Add(restartBudgetWindow)))
+
+	// Expired evictions are dropped from the ConfigMap on the next save.
+	tracker.recordEvictions(other, now.Add(restartBudgetWindow))
+	restarted := newRestartBudgetTracker(client, "kube-system")
+	assert.NoError(t, restarted.load())
+	_, found := restarted.evictions["uid-rs"]
+	assert.False(t, found)
+	assert.Len(t, restarted.evictions["uid-other-rs"], 1)
+}
+
+func TestFilterOutOverRestartBudget(t *testing.T) {
+	now := time.Now()
+	n1 := BuildTestNode("n1", 1000, 10)
+	n2 := BuildTestNode("n2", 1000, 10)
+	budgeted := buildBudgetedPods("rs", "1", 2)
+	budgeted[0].Spec.NodeName = "n1"
+	budgeted[1].Spec.NodeName = "n2"
+	naked := BuildTestPod("naked", 100, 0)
+	naked.Spec.NodeName = "n2"
+
+	sd := &ScaleDown{restartBudget: newRestartBudgetTracker(fake.NewSimpleClientset(), "kube-system")}
+	sd.restartBudget.recordEvictions(budgeted[:1], now)
+	nodesToRemove := []simulator.NodeToBeRemoved{
+		{Node: n1},
+		{Node: n2, PodsToReschedule: []*apiv1.Pod{naked, budgeted[1]}},
+	}
+
+	result, unremovable := sd.filterOutOverRestartBudget(nodesToRemove, now)
+	assert.Equal(t, nodesToRemove[:1], result)
+	assert.Len(t, unremovable, 1)
+	assert.Equal(t, n2, unremovable[0].Node)
+	assert.Equal(t, simulator.UnremovableReason(drain.RestartBudgetExhausted), unremovable[0].Reason)
+	assert.True(t, unremovable[0].BlockedByPod())
+	assert.Len(t, unremovable[0].BlockingPods, 1)
+	assert.Equal(t, budgeted[1], unremovable[0].BlockingPods[0].Pod)
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -31,16 +32,20 @@ import (
 	"k8s.io/autoscaler/cluster-autoscaler/metrics"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
 
 	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
 	policyv1 "k8s.io/api/policy/v1beta1"
 	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	kube_client "k8s.io/client-go/kubernetes"
 	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
 )
@@ -61,6 +66,12 @@ const (
 	ScaleDownNodeDeleteStarted ScaleDownResult = iota
 	// ScaleDownDisabledKey is the name of annotation marking node as not eligible for scale down.
 	ScaleDownDisabledKey = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+	// PodDrainTimeoutAnnotationKey is the name of annotation on a pod overriding MaxGracefulTerminationSec
+	// for the pod, for example "15m".
+	PodDrainTimeoutAnnotationKey = "cluster-autoscaler.kubernetes.io/drain-timeout"
+	// AllowForceDeleteAnnotationKey is the name of annotation on a pod allowing CA to delete the pod
+	// directly when its eviction is denied by an admission webhook.
+	AllowForceDeleteAnnotationKey = "cluster-autoscaler.kubernetes.io/allow-force-delete"
 )
 
 const (
@@ -79,6 +90,9 @@ const (
 	UnremovableNodeRecheckTimeout = 5 * time.Minute
 )
 
+// podTerminationCheckInterval is how often drain checks whether evicted pods are gone.
+var podTerminationCheckInterval = 5 * time.Second
+
 // NodeDeleteStatus tells whether a node is being deleted right now.
 type NodeDeleteStatus struct {
 	sync.Mutex
@@ -105,23 +119,72 @@ type ScaleDown struct {
 	unneededNodes      map[string]time.Time
 	unneededNodesList  []*apiv1.Node
 	unremovableNodes   map[string]time.Time
+	unremovableReasons map[string]simulator.UnremovableReason
 	podLocationHints   map[string]string
 	nodeUtilizationMap map[string]float64
+	// nodePriorityScores order unneeded nodes with equal utilization, see
+	// ScaleDownCandidatePriorityWeight. Empty if the weight is 0.
+	nodePriorityScores map[string]float64
 	usageTracker       *simulator.UsageTracker
 	nodeDeleteStatus   *NodeDeleteStatus
+	// calculateUtilization is simulator.CalculateUtilization or, with ScaleDownGpuUtilization,
+	// simulator.CalculateGpuAwareUtilization. Replaceable in tests.
+	calculateUtilization func(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo) (float64, error)
+	// simulationCursor is the name of the last node simulated when simulation is time-sliced.
+	simulationCursor string
+	// tentativeNodes are unneeded nodes whose pods can only be moved to upcoming nodes. They are
+	// not removed until the upcoming nodes register.
+	tentativeNodes map[string]bool
+	// unneededNodePods are pods to reschedule from unneeded nodes found in the simulation, kept
+	// for nodes carried over to the next loops when simulation is time-sliced.
+	unneededNodePods map[string][]*apiv1.Pod
+	// orphanNodes are empty nodes outside of node groups eligible for removal, with the time they
+	// were first seen empty.
+	orphanNodes     map[string]time.Time
+	orphanNodesList []*apiv1.Node
+	// compaction is the state of evictions from nodes kept by some of their pods, see TryToCompact.
+	compaction *compactionState
+	// restartBudget tracks evictions of pods with daily restart budgets.
+	restartBudget *restartBudgetTracker
+	// reportedOrphanedDaemonSetPods are keys of terminating pods of deleted DaemonSets already
+	// reported with an event.
+	reportedOrphanedDaemonSetPods map[string]bool
+	// cooldown are the removals held back by scale down delays in the current loop, set before
+	// TryToScaleDown. Nil if nothing is held back.
+	cooldown *scaleDownCooldown
+	// wastedNodes are nodes added by failed scale-ups whose pods are still pending, set before
+	// TryToScaleDown. They are removed when empty without waiting for ScaleDownUnneededTime.
+	wastedNodes map[string]clusterstate.WastedNode
+	// emptyNodeGroups are autoprovisioned node groups with target size 0, with the time they were
+	// first seen empty.
+	emptyNodeGroups map[string]time.Time
 }
 
 // NewScaleDown builds new ScaleDown object.
 func NewScaleDown(context *AutoscalingContext) *ScaleDown {
+	calculateUtilization := simulator.CalculateUtilization
+	if context.ScaleDownGpuUtilization {
+		calculateUtilization = simulator.CalculateGpuAwareUtilization
+	}
 	return &ScaleDown{
-		context:            context,
-		unneededNodes:      make(map[string]time.Time),
-		unremovableNodes:   make(map[string]time.Time),
-		podLocationHints:   make(map[string]string),
-		nodeUtilizationMap: make(map[string]float64),
-		usageTracker:       simulator.NewUsageTracker(),
-		unneededNodesList:  make([]*apiv1.Node, 0),
-		nodeDeleteStatus:   &NodeDeleteStatus{},
+		context:                       context,
+		unneededNodes:                 make(map[string]time.Time),
+		unremovableNodes:              make(map[string]time.Time),
+		unremovableReasons:            make(map[string]simulator.UnremovableReason),
+		podLocationHints:              make(map[string]string),
+		nodeUtilizationMap:            make(map[string]float64),
+		usageTracker:                  simulator.NewUsageTracker(),
+		unneededNodesList:             make([]*apiv1.Node, 0),
+		nodeDeleteStatus:              &NodeDeleteStatus{},
+		tentativeNodes:                make(map[string]bool),
+		unneededNodePods:              make(map[string][]*apiv1.Pod),
+		orphanNodes:                   make(map[string]time.Time),
+		orphanNodesList:               make([]*apiv1.Node, 0),
+		compaction:                    newCompactionState(),
+		restartBudget:                 newRestartBudgetTracker(context.ClientSet, context.ConfigNamespace),
+		reportedOrphanedDaemonSetPods: make(map[string]bool),
+		calculateUtilization:          calculateUtilization,
+		emptyNodeGroups:               make(map[string]time.Time),
 	}
 }
 
@@ -139,6 +202,8 @@ func (sd *ScaleDown) GetCandidatesForScaleDown() []*apiv1.Node {
 func (sd *ScaleDown) CleanUpUnneededNodes() {
 	sd.unneededNodesList = make([]*apiv1.Node, 0)
 	sd.unneededNodes = make(map[string]time.Time)
+	sd.tentativeNodes = make(map[string]bool)
+	sd.unneededNodePods = make(map[string][]*apiv1.Pod)
 }
 
 // UpdateUnneededNodes calculates which nodes are not needed, i.e. all pods can be scheduled somewhere else,
@@ -159,65 +224,24 @@ func (sd *ScaleDown) UpdateUnneededNodes(
 	utilizationMap := make(map[string]float64)
 
 	sd.updateUnremovableNodes(nodes)
-	// Filter out nodes that were recently checked
-	filteredNodesToCheck := make([]*apiv1.Node, 0)
+
+	// Phase1 - look at the nodes utilization. Cheaper rules run first, so the utilization is
+	// only calculated for the managed nodes that can be removed otherwise.
+	eligibility := sd.eligibilityPipeline(nodeNameToNodeInfo, utilizationMap, timestamp)
 	for _, node := range nodesToCheck {
-		if unremovableTimestamp, found := sd.unremovableNodes[node.Name]; found {
-			if unremovableTimestamp.After(timestamp) {
-				continue
-			}
-			delete(sd.unremovableNodes, node.Name)
+		if eligibility.eligible(node) {
+			currentlyUnneededNodes = append(currentlyUnneededNodes, node)
 		}
-		filteredNodesToCheck = append(filteredNodesToCheck, node)
 	}
-	skipped := len(nodesToCheck) - len(filteredNodesToCheck)
-	if skipped > 0 {
+	if skipped := eligibility.rejections[RecentlyUnremovableRule]; skipped > 0 {
 		glog.V(1).Infof("Scale-down calculation: ignoring %v nodes, that were unremovable in the last %v", skipped, UnremovableNodeRecheckTimeout)
 	}
-
-	// Phase1 - look at the nodes utilization. Calculate the utilization
-	// only for the managed nodes.
-	for _, node := range filteredNodesToCheck {
-
-		// Skip nodes marked to be deleted, if they were marked recently.
-		// Old-time marked nodes are again eligible for deletion - something went wrong with them
-		// and they have not been deleted.
-		deleteTime, _ := deletetaint.GetToBeDeletedTime(node)
-		if deleteTime != nil && (timestamp.Sub(*deleteTime) < MaxCloudProviderNodeDeletionTime || timestamp.Sub(*deleteTime) < MaxKubernetesEmptyNodeDeletionTime) {
-			glog.V(1).Infof("Skipping %s from delete considerations - the node is currently being deleted", node.Name)
-			continue
-		}
-
-		// Skip nodes marked with no scale down annotation
-		if hasNoScaleDownAnnotation(node) {
-			glog.V(1).Infof("Skipping %s from delete consideration - the node is marked as no scale down", node.Name)
-			continue
-		}
-
-		nodeInfo, found := nodeNameToNodeInfo[node.Name]
-		if !found {
-			glog.Errorf("Node info for %s not found", node.Name)
-			continue
-		}
-		utilization, err := simulator.CalculateUtilization(node, nodeInfo)
-
-		if err != nil {
-			glog.Warningf("Failed to calculate utilization for %s: %v", node.Name, err)
-		}
-		glog.V(4).Infof("Node %s - utilization %f", node.Name, utilization)
-		utilizationMap[node.Name] = utilization
-
-		if utilization >= sd.context.ScaleDownUtilizationThreshold {
-			glog.V(4).Infof("Node %s is not suitable for removal - utilization too big (%f)", node.Name, utilization)
-			continue
-		}
-		currentlyUnneededNodes = append(currentlyUnneededNodes, node)
-	}
+	metrics.RegisterScaleDownIneligibleNodes(eligibility.rejections)
 
 	emptyNodes := make(map[string]bool)
 
 	emptyNodesList := getEmptyNodes(currentlyUnneededNodes, pods, len(currentlyUnneededNodes),
-		config.DefaultMaxClusterCores, config.DefaultMaxClusterMemory, sd.context.CloudProvider)
+		config.DefaultMaxClusterCores, config.DefaultMaxClusterMemory, sd.context.CloudProvider, nil)
 	for _, node := range emptyNodesList {
 		emptyNodes[node.Name] = true
 	}
@@ -229,13 +253,50 @@ func (sd *ScaleDown) UpdateUnneededNodes(
 		}
 	}
 
+	// In large clusters only a slice of the nodes is simulated in every loop. Nodes outside the
+	// slice keep their previous state until their turn comes.
+	nodesToSimulate, carriedOverNodes := sd.nextSimulationSlice(currentlyUnneededNonEmptyNodes)
+	// Carried over nodes keep the placement of their pods from the previous loops: they are not
+	// destinations for the simulated nodes and their pods take up capacity where they'd be moved.
+	// Nodes whose placement is no longer valid are simulated again.
+	carriedOver, carriedOverPods, resimulated := sd.carryOverPlacements(carriedOverNodes, nodes)
+	nodesToSimulate = append(nodesToSimulate, resimulated...)
+	carriedOverNames := make(map[string]bool, len(carriedOver))
+	for _, node := range carriedOver {
+		carriedOverNames[node.Node.Name] = true
+	}
+
 	// Phase2 - check which nodes can be probably removed using fast drain.
-	currentCandidates, currentNonCandidates := sd.chooseCandidates(currentlyUnneededNonEmptyNodes)
+	currentCandidates, currentNonCandidates := sd.chooseCandidates(nodesToSimulate)
+
+	// Nodes from scale-ups that are still in progress can be used as a place for pods from
+	// removed nodes. Their pods are added to the ones passed to the simulation.
+	destinationNodes := make([]*apiv1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !carriedOverNames[node.Name] {
+			destinationNodes = append(destinationNodes, node)
+		}
+	}
+	simulatedPods := append(append([]*apiv1.Pod{}, nonExpendablePods...), carriedOverPods...)
+	upcomingNodeNames := make(map[string]bool)
+	if sd.context.ScaleDownSimulateUpcomingNodes && len(nodesToSimulate) > 0 {
+		upcomingNodes, upcomingPods, err := sd.getUpcomingNodes(nodes)
+		if err != nil {
+			glog.Warningf("Failed to build upcoming nodes for scale-down simulation: %v", err)
+		} else if len(upcomingNodes) > 0 {
+			glog.V(3).Infof("Scale-down simulation includes %d upcoming nodes", len(upcomingNodes))
+			destinationNodes = append(destinationNodes, upcomingNodes...)
+			simulatedPods = append(simulatedPods, upcomingPods...)
+			for _, node := range upcomingNodes {
+				upcomingNodeNames[node.Name] = true
+			}
+		}
+	}
 
 	// Look for nodes to remove in the current candidates
 	nodesToRemove, unremovable, newHints, simulatorErr := simulator.FindNodesToRemove(
-		currentCandidates, nodes, nonExpendablePods, nil, sd.context.PredicateChecker,
-		len(currentCandidates), true, sd.podLocationHints, sd.usageTracker, timestamp, pdbs)
+		currentCandidates, destinationNodes, simulatedPods, nil, sd.context.PredicateChecker,
+		len(currentCandidates), true, sd.podLocationHints, sd.usageTracker, timestamp, pdbs, sd.context.ScoringStrategy)
 	if simulatorErr != nil {
 		return sd.markSimulationError(simulatorErr, timestamp)
 	}
@@ -256,9 +317,9 @@ func (sd *ScaleDown) UpdateUnneededNodes(
 		// Look for addidtional nodes to remove among the rest of nodes
 		glog.V(3).Infof("Finding additional %v candidates for scale down.", additionalCandidatesCount)
 		additionalNodesToRemove, additionalUnremovable, additionalNewHints, simulatorErr :=
-			simulator.FindNodesToRemove(currentNonCandidates[:additionalCandidatesPoolSize], nodes, nonExpendablePods, nil,
+			simulator.FindNodesToRemove(currentNonCandidates[:additionalCandidatesPoolSize], destinationNodes, simulatedPods, nil,
 				sd.context.PredicateChecker, additionalCandidatesCount, true,
-				sd.podLocationHints, sd.usageTracker, timestamp, pdbs)
+				sd.podLocationHints, sd.usageTracker, timestamp, pdbs, sd.context.ScoringStrategy)
 		if simulatorErr != nil {
 			return sd.markSimulationError(simulatorErr, timestamp)
 		}
@@ -269,15 +330,45 @@ func (sd *ScaleDown) UpdateUnneededNodes(
 		}
 	}
 
+	nodesToRemove, overBudget := sd.filterOutOverRestartBudget(nodesToRemove, timestamp)
+	unremovable = append(unremovable, overBudget...)
+
+	tentativeNodes := make(map[string]bool)
+	for _, node := range nodesToRemove {
+		for _, pod := range node.PodsToReschedule {
+			if upcomingNodeNames[newHints[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)]] {
+				glog.V(2).Infof("%s is unneeded only if upcoming nodes register, pod %s/%s would be moved to one of them",
+					node.Node.Name, pod.Namespace, pod.Name)
+				tentativeNodes[node.Node.Name] = true
+				break
+			}
+		}
+	}
+
 	for _, node := range emptyNodesList {
 		nodesToRemove = append(nodesToRemove, simulator.NodeToBeRemoved{Node: node, PodsToReschedule: []*apiv1.Pod{}})
 	}
+	for _, node := range carriedOver {
+		nodesToRemove = append(nodesToRemove, node)
+		if sd.tentativeNodes[node.Node.Name] {
+			tentativeNodes[node.Node.Name] = true
+		}
+	}
+	if len(carriedOver) > 0 {
+		for key, value := range sd.podLocationHints {
+			if _, found := newHints[key]; !found {
+				newHints[key] = value
+			}
+		}
+	}
 	// Update the timestamp map.
 	result := make(map[string]time.Time)
 	unneededNodesList := make([]*apiv1.Node, 0, len(nodesToRemove))
+	unneededNodePods := make(map[string][]*apiv1.Pod, len(nodesToRemove))
 	for _, node := range nodesToRemove {
 		name := node.Node.Name
 		unneededNodesList = append(unneededNodesList, node.Node)
+		unneededNodePods[name] = node.PodsToReschedule
 		if val, found := sd.unneededNodes[name]; !found {
 			result[name] = timestamp
 		} else {
@@ -289,7 +380,12 @@ func (sd *ScaleDown) UpdateUnneededNodes(
 	if len(unremovable) > 0 {
 		unremovableTimeout := timestamp.Add(UnremovableNodeRecheckTimeout)
 		for _, node := range unremovable {
-			sd.unremovableNodes[node.Name] = unremovableTimeout
+			sd.unremovableNodes[node.Node.Name] = unremovableTimeout
+			sd.unremovableReasons[node.Node.Name] = node.Reason
+			if len(node.BlockingPods) > 0 {
+				sd.context.Recorder.Eventf(node.Node, apiv1.EventTypeNormal, "NoScaleDown",
+					"node cannot be removed, pods blocked by drainability rules: %s", drain.SummarizeBlockingPods(node.BlockingPods))
+			}
 		}
 		glog.V(1).Infof("%v nodes found unremovable in simulation, will re-check them at %v", len(unremovable), unremovableTimeout)
 	}
@@ -298,12 +394,176 @@ func (sd *ScaleDown) UpdateUnneededNodes(
 	sd.unneededNodesList = unneededNodesList
 	sd.unneededNodes = result
 	sd.podLocationHints = newHints
+	sd.tentativeNodes = tentativeNodes
+	sd.unneededNodePods = unneededNodePods
 	sd.nodeUtilizationMap = utilizationMap
+	sd.nodePriorityScores = nodePriorityScores(unneededNodesList, nodeNameToNodeInfo, sd.context.ScaleDownCandidatePriorityWeight)
 	sd.context.ClusterStateRegistry.UpdateScaleDownCandidates(sd.unneededNodesList, timestamp)
 	metrics.UpdateUnneededNodesCount(len(sd.unneededNodesList))
+	sd.updateBlockedNodesMetrics(nodes, timestamp)
 	return nil
 }
 
+// forgetNode drops calculations about the node that may no longer be valid, e.g. after its
+// allocatable changed. The time since which the node is unneeded is kept, as its utilization is
+// calculated again in every loop.
+func (sd *ScaleDown) forgetNode(nodeName string) {
+	delete(sd.unremovableNodes, nodeName)
+	delete(sd.unremovableReasons, nodeName)
+	for pod, hint := range sd.podLocationHints {
+		if hint == nodeName {
+			delete(sd.podLocationHints, pod)
+		}
+	}
+}
+
+// updateBlockedNodesMetrics exports the number of nodes that would be removed if not for their
+// pods, grouped by the blocking reason, and their total hourly price. It uses the reasons found
+// in the simulations of the current and previous loops, so no extra simulation is done.
+func (sd *ScaleDown) updateBlockedNodesMetrics(nodes []*apiv1.Node, timestamp time.Time) {
+	blockedNodes := make(map[string]simulator.UnremovableReason)
+	for name := range sd.unremovableNodes {
+		if reason, found := sd.unremovableReasons[name]; found {
+			blockedNodes[name] = reason
+		}
+	}
+	pricingModel, err := sd.context.CloudProvider.Pricing()
+	if err != nil {
+		pricingModel = nil
+	}
+	counts, price := blockedNodesSummary(nodes, blockedNodes, pricingModel, timestamp)
+	reasonCounts := make(map[string]int, len(counts))
+	for reason, count := range counts {
+		reasonCounts[string(reason)] = count
+	}
+	metrics.UpdateScaleDownBlockedNodes(reasonCounts)
+	if pricingModel != nil {
+		metrics.UpdateScaleDownBlockedNodesHourlyPrice(price)
+	}
+}
+
+// getUpcomingNodes builds nodes that are expected to register soon as a result of scale-ups in progress,
+// together with pods that will be running on them, based on node group templates.
+func (sd *ScaleDown) getUpcomingNodes(nodes []*apiv1.Node) ([]*apiv1.Node, []*apiv1.Pod, errors.AutoscalerError) {
+	upcomingCounts := sd.context.ClusterStateRegistry.GetUpcomingNodes()
+	if len(upcomingCounts) == 0 {
+		return []*apiv1.Node{}, []*apiv1.Pod{}, nil
+	}
+	// Daemon sets are not known here, so templates that don't come from existing nodes
+	// may lack daemon set pods.
+	nodeInfos, err := GetNodeInfosForGroups(nodes, sd.context.CloudProvider, sd.context.ClientSet,
+		[]*extensionsv1.DaemonSet{}, sd.context.TemplateNodeInfoCache, sd.context.NodeAllocatableTracker, sd.context.IgnoredTaints)
+	if err != nil {
+		return nil, nil, err
+	}
+	upcomingNodes := make([]*apiv1.Node, 0)
+	upcomingPods := make([]*apiv1.Pod, 0)
+	for nodeGroup, count := range upcomingCounts {
+		template, found := nodeInfos[nodeGroup]
+		if !found {
+			glog.Warningf("No template node for %s, upcoming nodes from it are not simulated", nodeGroup)
+			continue
+		}
+		for i := 0; i < count; i++ {
+			nodeInfo, err := sanitizeNodeInfo(template, nodeGroup, sd.context.IgnoredTaints)
+			if err != nil {
+				return nil, nil, err
+			}
+			upcomingNodes = append(upcomingNodes, nodeInfo.Node())
+			upcomingPods = append(upcomingPods, nodeInfo.Pods()...)
+		}
+	}
+	return upcomingNodes, upcomingPods, nil
+}
+
+// nextSimulationSlice returns nodes that should be simulated in this loop and previously unneeded
+// nodes whose state is carried over without a simulation. If time-slicing is disabled all nodes
+// are simulated.
+func (sd *ScaleDown) nextSimulationSlice(nodes []*apiv1.Node) ([]*apiv1.Node, []*apiv1.Node) {
+	sliceSize := sd.context.ScaleDownSimulationSliceSize
+	if sliceSize <= 0 || len(nodes) <= sliceSize {
+		return nodes, []*apiv1.Node{}
+	}
+	sorted := make([]*apiv1.Node, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	start := 0
+	for i, node := range sorted {
+		if node.Name > sd.simulationCursor {
+			start = i
+			break
+		}
+	}
+
+	toSimulate := make([]*apiv1.Node, 0, sliceSize)
+	inSlice := make(map[string]bool, sliceSize)
+	for i := 0; i < sliceSize; i++ {
+		node := sorted[(start+i)%len(sorted)]
+		toSimulate = append(toSimulate, node)
+		inSlice[node.Name] = true
+	}
+	sd.simulationCursor = toSimulate[len(toSimulate)-1].Name
+
+	carriedOver := make([]*apiv1.Node, 0)
+	for _, node := range nodes {
+		if _, found := sd.unneededNodes[node.Name]; found && !inSlice[node.Name] {
+			carriedOver = append(carriedOver, node)
+		}
+	}
+	glog.V(3).Infof("Scale-down simulation limited to %d of %d nodes, %d nodes carried over from previous loops",
+		len(toSimulate), len(nodes), len(carriedOver))
+	return toSimulate, carriedOver
+}
+
+// carryOverPlacements splits nodes carried over from the previous loops into the ones whose pods
+// still have a place on nodes that exist and aren't carried over themselves, and the ones that
+// have to be simulated again. For the former it returns copies of their pods placed on the nodes
+// they'd be moved to.
+func (sd *ScaleDown) carryOverPlacements(carriedOverNodes []*apiv1.Node, nodes []*apiv1.Node) (
+	[]simulator.NodeToBeRemoved, []*apiv1.Pod, []*apiv1.Node) {
+	if len(carriedOverNodes) == 0 {
+		return []simulator.NodeToBeRemoved{}, []*apiv1.Pod{}, []*apiv1.Node{}
+	}
+	existing := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		existing[node.Name] = true
+	}
+	carriedOverNames := make(map[string]bool, len(carriedOverNodes))
+	for _, node := range carriedOverNodes {
+		carriedOverNames[node.Name] = true
+	}
+
+	carriedOver := make([]simulator.NodeToBeRemoved, 0, len(carriedOverNodes))
+	placedPods := make([]*apiv1.Pod, 0)
+	resimulated := make([]*apiv1.Node, 0)
+nodeloop:
+	for _, node := range carriedOverNodes {
+		podsToReschedule, found := sd.unneededNodePods[node.Name]
+		if !found {
+			resimulated = append(resimulated, node)
+			continue
+		}
+		placed := make([]*apiv1.Pod, 0, len(podsToReschedule))
+		for _, pod := range podsToReschedule {
+			hint := sd.podLocationHints[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)]
+			if !existing[hint] || carriedOverNames[hint] {
+				glog.V(3).Infof("Pod %s/%s from unneeded node %s has no place outside of unneeded nodes, simulating the node again",
+					pod.Namespace, pod.Name, node.Name)
+				resimulated = append(resimulated, node)
+				continue nodeloop
+			}
+			placedPod := *pod
+			placedPod.Spec.NodeName = hint
+			placed = append(placed, &placedPod)
+		}
+		carriedOver = append(carriedOver, simulator.NodeToBeRemoved{Node: node, PodsToReschedule: podsToReschedule})
+		placedPods = append(placedPods, placed...)
+	}
+	return carriedOver, placedPods, resimulated
+}
+
 // updateUnremovableNodes updates unremovableNodes map according to current
 // state of the cluster. Removes from the map nodes that are no longer in the
 // nodes list.
@@ -324,6 +584,7 @@ func (sd *ScaleDown) updateUnremovableNodes(nodes []*apiv1.Node) {
 	}
 	for nodeName := range nodesToDelete {
 		delete(sd.unremovableNodes, nodeName)
+		delete(sd.unremovableReasons, nodeName)
 	}
 }
 
@@ -368,6 +629,9 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 	defer updateScaleDownMetrics(time.Now(), &findNodesToRemoveDuration, &nodeDeletionDuration)
 	nodesWithoutMaster := filterOutMasters(allNodes, pods)
 	candidates := make([]*apiv1.Node, 0)
+	candidateNodeGroups := make(map[string]string)
+	// wastedCandidates are wasted nodes that weren't unneeded for long enough to be drained.
+	wastedCandidates := make(map[string]bool)
 	readinessMap := make(map[string]bool)
 
 	resourceLimiter, errCP := sd.context.CloudProvider.GetResourceLimiter()
@@ -386,6 +650,11 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 
 			glog.V(2).Infof("%s was unneeded for %s", node.Name, currentTime.Sub(val).String())
 
+			if sd.tentativeNodes[node.Name] {
+				glog.V(2).Infof("Skipping %s - waiting for upcoming nodes to register", node.Name)
+				continue
+			}
+
 			// Check if node is marked with no scale down annotation.
 			if hasNoScaleDownAnnotation(node) {
 				glog.V(4).Infof("Skipping %s - scale down disabled annotation found", node.Name)
@@ -395,16 +664,6 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 			ready, _, _ := kube_util.GetReadinessState(node)
 			readinessMap[node.Name] = ready
 
-			// Check how long the node was underutilized.
-			if ready && !val.Add(sd.context.ScaleDownUnneededTime).Before(currentTime) {
-				continue
-			}
-
-			// Unready nodes may be deleted after a different time than unrerutilized.
-			if !ready && !val.Add(sd.context.ScaleDownUnreadyTime).Before(currentTime) {
-				continue
-			}
-
 			nodeGroup, err := sd.context.CloudProvider.NodeGroupForNode(node)
 			if err != nil {
 				glog.Errorf("Error while checking node group for %s: %v", node.Name, err)
@@ -414,6 +673,24 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 				glog.V(4).Infof("Skipping %s - no node group config", node.Name)
 				continue
 			}
+			if !sd.cooldown.allowsEmpty(nodeGroup.Id()) {
+				glog.V(4).Infof("Skipping %s - node group %s was scaled up recently", node.Name, nodeGroup.Id())
+				continue
+			}
+			nodeGroupOptions := sd.context.NodeGroupConfigProcessor.GetOptions(sd.context, nodeGroup)
+
+			// Check how long the node was underutilized.
+			if ready && !val.Add(nodeGroupOptions.ScaleDownUnneededTime).Before(currentTime) {
+				if _, found := sd.wastedNodes[node.Name]; !found {
+					continue
+				}
+				wastedCandidates[node.Name] = true
+			}
+
+			// Unready nodes may be deleted after a different time than unrerutilized.
+			if !ready && !val.Add(nodeGroupOptions.ScaleDownUnreadyTime).Before(currentTime) {
+				continue
+			}
 
 			size, found := nodeGroupSize[nodeGroup.Id()]
 			if !found {
@@ -440,21 +717,43 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 			}
 
 			candidates = append(candidates, node)
+			candidateNodeGroups[node.Name] = nodeGroup.Id()
 		}
 	}
 	if len(candidates) == 0 {
 		glog.V(1).Infof("No candidates for scale down")
 		return ScaleDownNoUnneeded, nil
 	}
+	candidates = orderCandidatesByPriorityScore(candidates, sd.nodeUtilizationMap, sd.nodePriorityScores)
+	candidates = preferFailoverNodesForScaleDown(sd.context, candidates, currentTime)
+	candidates = orderSelfNodeLast(candidates, sd.context.Self)
 
 	// Trying to delete empty nodes in bulk. If there are no empty nodes then CA will
 	// try to delete not-so-empty nodes, possibly killing some pods and allowing them
 	// to recreate on other nodes.
-	emptyNodes := getEmptyNodes(candidates, pods, sd.context.MaxEmptyBulkDelete, coresLeft, memoryLeft, sd.context.CloudProvider)
+	emptyNodes := getEmptyNodes(candidates, pods, sd.context.MaxEmptyBulkDelete, coresLeft, memoryLeft, sd.context.CloudProvider,
+		newDrainParallelismLimiter(sd.context))
+	if len(emptyNodes) > 0 && sd.context.DryRun {
+		for _, node := range emptyNodes {
+			recordDryRunAction(sd.context, metrics.DryRunScaleDownEmpty, nodeGroupIdForNode(sd.context.CloudProvider, node),
+				"would remove empty node %s", node.Name)
+		}
+		return ScaleDownNoNodeDeleted, nil
+	}
 	if len(emptyNodes) > 0 {
+		for _, node := range emptyNodes {
+			if wasted, found := sd.wastedNodes[node.Name]; found {
+				request := wasted.ScaleUp.Request
+				glog.V(1).Infof("Removing empty node %s added by failed scale-up of %s requested at %v", node.Name,
+					request.NodeGroupName, request.Time)
+				sd.context.Recorder.Eventf(node, apiv1.EventTypeNormal, "ScaleDownWastedNode",
+					"node added by scale-up of group %s by %d requested at %v, which failed at %v, is empty while pods it was requested for are still pending",
+					request.NodeGroupName, request.Increase, request.Time, wasted.ScaleUp.FailureTime)
+			}
+		}
 		nodeDeletionStart := time.Now()
 		confirmation := make(chan errors.AutoscalerError, len(emptyNodes))
-		sd.scheduleDeleteEmptyNodes(emptyNodes, sd.context.ClientSet, sd.context.Recorder, readinessMap, confirmation)
+		sd.scheduleDeleteEmptyNodes(emptyNodes, pods, sd.context.ClientSet, sd.context.Recorder, readinessMap, confirmation)
 		err := sd.waitForEmptyNodesDeleted(emptyNodes, confirmation)
 		nodeDeletionDuration = time.Now().Sub(nodeDeletionStart)
 		if err == nil {
@@ -463,35 +762,58 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 		return ScaleDownError, err.AddPrefix("failed to delete at least one empty node: ")
 	}
 
+	if sd.cooldown != nil || len(wastedCandidates) > 0 {
+		drainCandidates := make([]*apiv1.Node, 0, len(candidates))
+		for _, node := range candidates {
+			if sd.cooldown.allowsDrain(candidateNodeGroups[node.Name]) && !wastedCandidates[node.Name] {
+				drainCandidates = append(drainCandidates, node)
+			}
+		}
+		if len(drainCandidates) == 0 {
+			glog.V(1).Infof("No candidates for scale down of nodes with pods")
+			return ScaleDownNoNodeDeleted, nil
+		}
+		candidates = drainCandidates
+	}
+
 	findNodesToRemoveStart := time.Now()
 	// Only scheduled non expendable pods are taken into account and have to be moved.
 	nonExpendablePods := FilterOutExpendablePods(pods, sd.context.ExpendablePodsPriorityCutoff)
 	// We look for only 1 node so new hints may be incomplete.
 	nodesToRemove, _, _, err := simulator.FindNodesToRemove(candidates, nodesWithoutMaster, nonExpendablePods, sd.context.ClientSet,
 		sd.context.PredicateChecker, 1, false,
-		sd.podLocationHints, sd.usageTracker, time.Now(), pdbs)
+		sd.podLocationHints, sd.usageTracker, time.Now(), pdbs, sd.context.ScoringStrategy)
 	findNodesToRemoveDuration = time.Now().Sub(findNodesToRemoveStart)
 
 	if err != nil {
 		return ScaleDownError, err.AddPrefix("Find node to remove failed: ")
 	}
+	// Evictions done since the last simulation, e.g. by compaction, may have used up the budgets.
+	nodesToRemove, _ = sd.filterOutOverRestartBudget(nodesToRemove, currentTime)
 	if len(nodesToRemove) == 0 {
 		glog.V(1).Infof("No node to remove")
 		return ScaleDownNoNodeDeleted, nil
 	}
 	toRemove := nodesToRemove[0]
 	utilization := sd.nodeUtilizationMap[toRemove.Node.Name]
-	podNames := make([]string, 0, len(toRemove.PodsToReschedule))
-	for _, pod := range toRemove.PodsToReschedule {
-		podNames = append(podNames, pod.Namespace+"/"+pod.Name)
+	podsToReschedule := podNames(toRemove.PodsToReschedule)
+	if sd.context.DryRun {
+		recordDryRunAction(sd.context, metrics.DryRunScaleDown, nodeGroupIdForNode(sd.context.CloudProvider, toRemove.Node),
+			"would remove node %s, utilization: %v, pods to reschedule: %s", toRemove.Node.Name, utilization, podsToReschedule)
+		return ScaleDownNoNodeDeleted, nil
+	}
+	podsToDrain, removable := podsToEvictBeforeRemoval(toRemove, allNodes, sd.context.Self)
+	if !removable {
+		return ScaleDownNoNodeDeleted, nil
 	}
 	glog.V(0).Infof("Scale-down: removing node %s, utilization: %v, pods to reschedule: %s", toRemove.Node.Name, utilization,
-		strings.Join(podNames, ","))
+		podsToReschedule)
 	sd.context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleDown", "Scale-down: removing node %s, utilization: %v, pods to reschedule: %s",
-		toRemove.Node.Name, utilization, strings.Join(podNames, ","))
+		toRemove.Node.Name, utilization, podsToReschedule)
 
 	// Nothing super-bad should happen if the node is removed from tracker prematurely.
 	simulator.RemoveNodeFromTracker(sd.usageTracker, toRemove.Node.Name, sd.unneededNodes)
+	sd.restartBudget.recordEvictions(toRemove.PodsToReschedule, currentTime)
 	nodeDeletionStart := time.Now()
 
 	// Starting deletion.
@@ -501,7 +823,7 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 	go func() {
 		// Finishing the delete probess once this goroutine is over.
 		defer sd.nodeDeleteStatus.SetDeleteInProgress(false)
-		err := deleteNode(sd.context, toRemove.Node, toRemove.PodsToReschedule)
+		err := deleteNode(sd.context, toRemove.Node, podsToDrain)
 		if err != nil {
 			glog.Errorf("Failed to delete %s: %v", toRemove.Node.Name, err)
 			return
@@ -516,6 +838,143 @@ func (sd *ScaleDown) TryToScaleDown(allNodes []*apiv1.Node, pods []*apiv1.Pod, p
 	return ScaleDownNodeDeleteStarted, nil
 }
 
+// EnforceNodeGroupMaxSize removes nodes from node groups that are above their max size, for example after
+// the max size was lowered. Nodes are removed without waiting for them to be unneeded: empty nodes are
+// deleted in bulk first, then the least utilized nodes one at a time, provided their pods can be moved
+// elsewhere without violating PodDisruptionBudgets.
+func (sd *ScaleDown) EnforceNodeGroupMaxSize(allNodes []*apiv1.Node, pods []*apiv1.Pod, pdbs []*policyv1.PodDisruptionBudget,
+	currentTime time.Time) (ScaleDownResult, errors.AutoscalerError) {
+	nodesWithoutMaster := filterOutMasters(allNodes, pods)
+	candidates, excess := sd.findNodesAboveMaxSize(nodesWithoutMaster, pods)
+	if len(candidates) == 0 {
+		return ScaleDownNoUnneeded, nil
+	}
+	candidates = orderSelfNodeLast(candidates, sd.context.Self)
+	readinessMap := make(map[string]bool)
+	for _, node := range candidates {
+		ready, _, _ := kube_util.GetReadinessState(node)
+		readinessMap[node.Name] = ready
+	}
+
+	emptyNodes := make([]*apiv1.Node, 0)
+	for _, node := range simulator.FindEmptyNodesToRemove(candidates, pods) {
+		nodeGroupId := nodeGroupIdForNode(sd.context.CloudProvider, node)
+		if excess[nodeGroupId] > 0 && len(emptyNodes) < sd.context.MaxEmptyBulkDelete {
+			emptyNodes = append(emptyNodes, node)
+			excess[nodeGroupId]--
+		}
+	}
+	if len(emptyNodes) > 0 && sd.context.DryRun {
+		for _, node := range emptyNodes {
+			recordDryRunAction(sd.context, metrics.DryRunScaleDownEmpty, nodeGroupIdForNode(sd.context.CloudProvider, node),
+				"would remove empty node %s from node group above max size", node.Name)
+		}
+		return ScaleDownNoNodeDeleted, nil
+	}
+	if len(emptyNodes) > 0 {
+		glog.V(1).Infof("Removing %d empty nodes from node groups above max size", len(emptyNodes))
+		confirmation := make(chan errors.AutoscalerError, len(emptyNodes))
+		sd.scheduleDeleteEmptyNodes(emptyNodes, pods, sd.context.ClientSet, sd.context.Recorder, readinessMap, confirmation)
+		if err := sd.waitForEmptyNodesDeleted(emptyNodes, confirmation); err != nil {
+			return ScaleDownError, err.AddPrefix("failed to delete at least one empty node above max size: ")
+		}
+		return ScaleDownNodeDeleted, nil
+	}
+
+	nonExpendablePods := FilterOutExpendablePods(pods, sd.context.ExpendablePodsPriorityCutoff)
+	nodesToRemove, _, _, err := simulator.FindNodesToRemove(candidates, nodesWithoutMaster, nonExpendablePods, sd.context.ClientSet,
+		sd.context.PredicateChecker, 1, false, sd.podLocationHints, sd.usageTracker, currentTime, pdbs, sd.context.ScoringStrategy)
+	if err != nil {
+		return ScaleDownError, err.AddPrefix("Find node to remove above max size failed: ")
+	}
+	nodesToRemove, _ = sd.filterOutOverRestartBudget(nodesToRemove, currentTime)
+	if len(nodesToRemove) == 0 {
+		glog.V(1).Infof("No node can be removed from node groups above max size")
+		return ScaleDownNoNodeDeleted, nil
+	}
+	toRemove := nodesToRemove[0]
+	podsToReschedule := podNames(toRemove.PodsToReschedule)
+	if sd.context.DryRun {
+		recordDryRunAction(sd.context, metrics.DryRunScaleDown, nodeGroupIdForNode(sd.context.CloudProvider, toRemove.Node),
+			"would remove node %s from node group above max size, pods to reschedule: %s", toRemove.Node.Name, podsToReschedule)
+		return ScaleDownNoNodeDeleted, nil
+	}
+	podsToDrain, removable := podsToEvictBeforeRemoval(toRemove, allNodes, sd.context.Self)
+	if !removable {
+		return ScaleDownNoNodeDeleted, nil
+	}
+	glog.V(0).Infof("Scale-down: removing node %s from node group above max size, pods to reschedule: %s",
+		toRemove.Node.Name, podsToReschedule)
+	sd.context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleDown", "Scale-down: removing node %s from node group above max size, pods to reschedule: %s",
+		toRemove.Node.Name, podsToReschedule)
+
+	simulator.RemoveNodeFromTracker(sd.usageTracker, toRemove.Node.Name, sd.unneededNodes)
+	sd.restartBudget.recordEvictions(toRemove.PodsToReschedule, currentTime)
+	sd.nodeDeleteStatus.SetDeleteInProgress(true)
+	go func() {
+		defer sd.nodeDeleteStatus.SetDeleteInProgress(false)
+		err := deleteNode(sd.context, toRemove.Node, podsToDrain)
+		if err != nil {
+			glog.Errorf("Failed to delete %s: %v", toRemove.Node.Name, err)
+			return
+		}
+		metrics.RegisterScaleDown(1, metrics.AboveMaxSize)
+	}()
+	return ScaleDownNodeDeleteStarted, nil
+}
+
+// findNodesAboveMaxSize returns nodes of node groups above their max size, starting from the least
+// utilized ones, and the number of nodes to remove from each of these groups. Nodes with scale down
+// disabled are not returned.
+func (sd *ScaleDown) findNodesAboveMaxSize(nodes []*apiv1.Node, pods []*apiv1.Pod) ([]*apiv1.Node, map[string]int) {
+	excess := make(map[string]int)
+	for _, nodeGroup := range sd.context.CloudProvider.NodeGroups() {
+		size, err := nodeGroup.TargetSize()
+		if err != nil {
+			glog.Errorf("Error while checking node group size %s: %v", nodeGroup.Id(), err)
+			continue
+		}
+		if size > nodeGroup.MaxSize() {
+			glog.V(1).Infof("Node group %s is above max size: %d > %d", nodeGroup.Id(), size, nodeGroup.MaxSize())
+			excess[nodeGroup.Id()] = size - nodeGroup.MaxSize()
+		}
+	}
+	if len(excess) == 0 {
+		return []*apiv1.Node{}, excess
+	}
+
+	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(pods, nodes)
+	utilization := make(map[string]float64)
+	candidates := make([]*apiv1.Node, 0)
+	for _, node := range nodes {
+		if excess[nodeGroupIdForNode(sd.context.CloudProvider, node)] == 0 {
+			continue
+		}
+		if hasNoScaleDownAnnotation(node) {
+			glog.V(4).Infof("Skipping %s - scale down disabled annotation found", node.Name)
+			continue
+		}
+		if deletetaint.HasToBeDeletedTaint(node) {
+			continue
+		}
+		if nodeInfo, found := nodeNameToNodeInfo[node.Name]; found {
+			nodeUtilization, err := sd.calculateUtilization(node, nodeInfo)
+			if err != nil {
+				glog.Warningf("Failed to calculate utilization for %s: %v", node.Name, err)
+			}
+			utilization[node.Name] = nodeUtilization
+		}
+		candidates = append(candidates, node)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if utilization[candidates[i].Name] != utilization[candidates[j].Name] {
+			return utilization[candidates[i].Name] < utilization[candidates[j].Name]
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates, excess
+}
+
 // updateScaleDownMetrics registers duration of different parts of scale down.
 // Separates time spent on finding nodes to remove, deleting nodes and other operations.
 func updateScaleDownMetrics(scaleDownStart time.Time, findNodesToRemoveDuration *time.Duration, nodeDeletionDuration *time.Duration) {
@@ -526,10 +985,39 @@ func updateScaleDownMetrics(scaleDownStart time.Time, findNodesToRemoveDuration
 	metrics.UpdateDuration(metrics.ScaleDownMiscOperations, miscDuration)
 }
 
+// blockedNodesSummary counts nodes that could not be removed only because of their pods, grouped
+// by the reason, and sums their hourly price. The price is 0 if pricingModel is nil.
+func blockedNodesSummary(nodes []*apiv1.Node, reasons map[string]simulator.UnremovableReason,
+	pricingModel cloudprovider.PricingModel, now time.Time) (map[simulator.UnremovableReason]int, float64) {
+	counts := make(map[simulator.UnremovableReason]int)
+	price := 0.0
+	for _, node := range nodes {
+		reason, found := reasons[node.Name]
+		if !found {
+			continue
+		}
+		blocked := simulator.UnremovableNode{Node: node, Reason: reason}
+		if !blocked.BlockedByPod() {
+			continue
+		}
+		counts[reason]++
+		if pricingModel == nil {
+			continue
+		}
+		nodePrice, err := pricingModel.NodePrice(node, now, now.Add(time.Hour))
+		if err != nil {
+			glog.Warningf("Failed to get price of blocked node %s: %v", node.Name, err)
+			continue
+		}
+		price += nodePrice
+	}
+	return counts, price
+}
+
 // This functions finds empty nodes among passed candidates and returns a list of empty nodes
 // that can be deleted at the same time.
 func getEmptyNodes(candidates []*apiv1.Node, pods []*apiv1.Pod, maxEmptyBulkDelete int,
-	coresLimit, memoryLimit int64, cloudProvider cloudprovider.CloudProvider) []*apiv1.Node {
+	coresLimit, memoryLimit int64, cloudProvider cloudprovider.CloudProvider, limiter *drainParallelismLimiter) []*apiv1.Node {
 
 	emptyNodes := simulator.FindEmptyNodesToRemove(candidates, pods)
 	availabilityMap := make(map[string]int)
@@ -574,6 +1062,9 @@ func getEmptyNodes(candidates []*apiv1.Node, pods []*apiv1.Pod, maxEmptyBulkDele
 			if memory > memoryLeft {
 				continue
 			}
+			if !limiter.tryAdd(node, nodeGroup) {
+				continue
+			}
 			coresLeft = coresLeft - cores
 			memoryLeft = memoryLeft - memory
 			available -= 1
@@ -588,8 +1079,15 @@ func getEmptyNodes(candidates []*apiv1.Node, pods []*apiv1.Pod, maxEmptyBulkDele
 	return result[:limit]
 }
 
-func (sd *ScaleDown) scheduleDeleteEmptyNodes(emptyNodes []*apiv1.Node, client kube_client.Interface,
+// scheduleDeleteEmptyNodes deletes the empty nodes in the background, reporting the result of each
+// deletion to confirmation. Pods matching --ignore-pods-for-empty-nodes are evicted first.
+func (sd *ScaleDown) scheduleDeleteEmptyNodes(emptyNodes []*apiv1.Node, pods []*apiv1.Pod, client kube_client.Interface,
 	recorder kube_record.EventRecorder, readinessMap map[string]bool, confirmation chan errors.AutoscalerError) {
+	_, ignoredPods := simulator.SplitIgnoredPods(pods)
+	ignoredPodsByNode := make(map[string][]*apiv1.Pod)
+	for _, pod := range ignoredPods {
+		ignoredPodsByNode[pod.Spec.NodeName] = append(ignoredPodsByNode[pod.Spec.NodeName], pod)
+	}
 	for _, node := range emptyNodes {
 		glog.V(0).Infof("Scale-down: removing empty node %s", node.Name)
 		sd.context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleDownEmpty", "Scale-down: removing empty node %s", node.Name)
@@ -611,6 +1109,16 @@ func (sd *ScaleDown) scheduleDeleteEmptyNodes(emptyNodes []*apiv1.Node, client k
 				}
 			}()
 
+			if ignored := ignoredPodsByNode[nodeToDelete.Name]; len(ignored) > 0 {
+				maxGracefulTerminationSec, maxNodeDrainTime := nodeDrainLimits(sd.context, nodeToDelete)
+				deleteErr = drainNode(nodeToDelete, ignored, client, recorder, maxGracefulTerminationSec, maxNodeDrainTime,
+					MaxPodEvictionTime, EvictionRetryTime, sd.context.EvictionDeleteFallback)
+				if deleteErr != nil {
+					confirmation <- deleteErr
+					return
+				}
+			}
+
 			deleteErr = deleteNodeFromCloudProvider(nodeToDelete, sd.context.CloudProvider,
 				sd.context.Recorder, sd.context.ClusterStateRegistry)
 			if deleteErr == nil {
@@ -648,6 +1156,14 @@ func (sd *ScaleDown) waitForEmptyNodesDeleted(emptyNodes []*apiv1.Node, confirma
 	return finalError
 }
 
+// podsToEvict returns the pods evicted when the node is drained: pods rescheduled in the simulation
+// and pods ignored in it.
+func podsToEvict(toRemove simulator.NodeToBeRemoved) []*apiv1.Pod {
+	pods := make([]*apiv1.Pod, 0, len(toRemove.PodsToReschedule)+len(toRemove.IgnoredPods))
+	pods = append(pods, toRemove.PodsToReschedule...)
+	return append(pods, toRemove.IgnoredPods...)
+}
+
 func deleteNode(context *AutoscalingContext, node *apiv1.Node, pods []*apiv1.Pod) errors.AutoscalerError {
 	deleteSuccessful := false
 	drainSuccessful := false
@@ -672,7 +1188,9 @@ func deleteNode(context *AutoscalingContext, node *apiv1.Node, pods []*apiv1.Pod
 	context.Recorder.Eventf(node, apiv1.EventTypeNormal, "ScaleDown", "marked the node as toBeDeleted/unschedulable")
 
 	// attempt drain
-	if err := drainNode(node, pods, context.ClientSet, context.Recorder, context.MaxGracefulTerminationSec, MaxPodEvictionTime, EvictionRetryTime); err != nil {
+	maxGracefulTerminationSec, maxNodeDrainTime := nodeDrainLimits(context, node)
+	if err := drainNode(node, pods, context.ClientSet, context.Recorder, maxGracefulTerminationSec, maxNodeDrainTime,
+		MaxPodEvictionTime, EvictionRetryTime, context.EvictionDeleteFallback); err != nil {
 		return err
 	}
 	drainSuccessful = true
@@ -687,18 +1205,34 @@ func deleteNode(context *AutoscalingContext, node *apiv1.Node, pods []*apiv1.Pod
 	return nil
 }
 
+// nodeDrainLimits returns the maximum graceful termination of pods of the node and the maximum drain
+// time of the node. Node groups given a longer graceful termination than the global one get their
+// drain time extended by the difference, so that drains aren't aborted before pods terminate.
+func nodeDrainLimits(context *AutoscalingContext, node *apiv1.Node) (int, time.Duration) {
+	var nodeGroup cloudprovider.NodeGroup
+	if context.NodeGroupConfigProcessor != nil {
+		var err error
+		nodeGroup, err = context.CloudProvider.NodeGroupForNode(node)
+		if err != nil {
+			glog.Warningf("Failed to get node group of %s, using global graceful termination: %v", node.Name, err)
+		}
+	}
+	maxGracefulTerminationSec := context.NodeGroupConfigProcessor.GetOptions(context, nodeGroup).MaxGracefulTerminationSec
+	maxNodeDrainTime := context.MaxNodeDrainTime
+	if maxNodeDrainTime > 0 && maxGracefulTerminationSec > context.MaxGracefulTerminationSec {
+		maxNodeDrainTime += time.Duration(maxGracefulTerminationSec-context.MaxGracefulTerminationSec) * time.Second
+	}
+	return maxGracefulTerminationSec, maxNodeDrainTime
+}
+
+// evictPod evicts the pod, retrying until retryUntil. If deleteFallback is set, pods annotated with
+// AllowForceDeleteAnnotationKey whose eviction is denied by an admission webhook are deleted instead,
+// unless a PodDisruptionBudget covers them.
 func evictPod(podToEvict *apiv1.Pod, client kube_client.Interface, recorder kube_record.EventRecorder,
-	maxGracefulTerminationSec int, retryUntil time.Time, waitBetweenRetries time.Duration) error {
+	maxGracefulTerminationSec int, retryUntil time.Time, waitBetweenRetries time.Duration, deleteFallback bool) error {
 	recorder.Eventf(podToEvict, apiv1.EventTypeNormal, "ScaleDown", "deleting pod for node scale down")
 
-	maxTermination := int64(apiv1.DefaultTerminationGracePeriodSeconds)
-	if podToEvict.Spec.TerminationGracePeriodSeconds != nil {
-		if *podToEvict.Spec.TerminationGracePeriodSeconds < int64(maxGracefulTerminationSec) {
-			maxTermination = *podToEvict.Spec.TerminationGracePeriodSeconds
-		} else {
-			maxTermination = int64(maxGracefulTerminationSec)
-		}
-	}
+	maxTermination := podGracePeriodSec(podToEvict, maxGracefulTerminationSec)
 
 	var lastError error
 	for first := true; first || time.Now().Before(retryUntil); time.Sleep(waitBetweenRetries) {
@@ -716,23 +1250,98 @@ func evictPod(podToEvict *apiv1.Pod, client kube_client.Interface, recorder kube
 		if lastError == nil || kube_errors.IsNotFound(lastError) {
 			return nil
 		}
+		if deleteFallback && isAdmissionWebhookDenial(lastError) && podAllowsForceDelete(podToEvict) {
+			protected, err := isProtectedByPodDisruptionBudget(podToEvict, client)
+			if err != nil {
+				glog.Warningf("Failed to check PodDisruptionBudgets of %s/%s, not deleting it: %v", podToEvict.Namespace, podToEvict.Name, err)
+			} else if !protected {
+				return deletePodAfterDeniedEviction(podToEvict, client, recorder, maxTermination, lastError)
+			}
+		}
 	}
 	glog.Errorf("Failed to evict pod %s, error: %v", podToEvict.Name, lastError)
 	recorder.Eventf(podToEvict, apiv1.EventTypeWarning, "ScaleDownFailed", "failed to delete pod for ScaleDown")
 	return fmt.Errorf("Failed to evict pod %s/%s within allowed timeout (last error: %v)", podToEvict.Namespace, podToEvict.Name, lastError)
 }
 
+// isAdmissionWebhookDenial checks if the eviction was rejected by an admission webhook, either denying it or
+// failing closed. Evictions refused because of PodDisruptionBudgets are never webhook denials.
+func isAdmissionWebhookDenial(err error) bool {
+	if kube_errors.IsTooManyRequests(err) {
+		return false
+	}
+	status, ok := err.(kube_errors.APIStatus)
+	return ok && strings.Contains(status.Status().Message, "admission webhook")
+}
+
+func podAllowsForceDelete(pod *apiv1.Pod) bool {
+	return pod.Annotations[AllowForceDeleteAnnotationKey] == "true"
+}
+
+// isProtectedByPodDisruptionBudget checks if any PodDisruptionBudget in the namespace of the pod selects it.
+func isProtectedByPodDisruptionBudget(pod *apiv1.Pod, client kube_client.Interface) (bool, error) {
+	pdbs, err := client.PolicyV1beta1().PodDisruptionBudgets(pod.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, pdb := range pdbs.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Empty() && selector.Matches(labels.Set(pod.Labels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func deletePodAfterDeniedEviction(pod *apiv1.Pod, client kube_client.Interface, recorder kube_record.EventRecorder,
+	gracePeriodSec int64, evictionErr error) error {
+	glog.Warningf("Eviction of %s/%s denied by admission webhook, deleting it: %v", pod.Namespace, pod.Name, evictionErr)
+	recorder.Eventf(pod, apiv1.EventTypeWarning, "ScaleDownPodDeleted", "eviction denied by admission webhook, deleting pod for node scale down")
+	err := client.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{GracePeriodSeconds: &gracePeriodSec})
+	if err == nil || kube_errors.IsNotFound(err) {
+		return nil
+	}
+	recorder.Eventf(pod, apiv1.EventTypeWarning, "ScaleDownFailed", "failed to delete pod for ScaleDown")
+	return fmt.Errorf("Failed to delete pod %s/%s after its eviction was denied: %v", pod.Namespace, pod.Name, err)
+}
+
+// podGracePeriodSec returns the grace period given to the pod when evicting it, which is the pod's own
+// termination grace period capped at maxGracefulTerminationSec or at the pod's drain-timeout annotation.
+func podGracePeriodSec(pod *apiv1.Pod, maxGracefulTerminationSec int) int64 {
+	maxTermination := int64(maxGracefulTerminationSec)
+	if value, found := pod.Annotations[PodDrainTimeoutAnnotationKey]; found {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout >= 0 {
+			maxTermination = int64(timeout.Seconds())
+		} else {
+			glog.Warningf("Pod %s/%s has invalid %s annotation %q", pod.Namespace, pod.Name, PodDrainTimeoutAnnotationKey, value)
+		}
+	}
+	gracePeriod := int64(apiv1.DefaultTerminationGracePeriodSeconds)
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+	}
+	if gracePeriod > maxTermination {
+		return maxTermination
+	}
+	return gracePeriod
+}
+
 // Performs drain logic on the node. Marks the node as unschedulable and later removes all pods, giving
-// them up to MaxGracefulTerminationTime to finish.
+// each of them up to its own grace period to finish. The drain ends when the last pod is gone, when all
+// remaining pods are past their grace period or after maxNodeDrainTime.
 func drainNode(node *apiv1.Node, pods []*apiv1.Pod, client kube_client.Interface, recorder kube_record.EventRecorder,
-	maxGracefulTerminationSec int, maxPodEvictionTime time.Duration, waitBetweenRetries time.Duration) errors.AutoscalerError {
+	maxGracefulTerminationSec int, maxNodeDrainTime time.Duration, maxPodEvictionTime time.Duration,
+	waitBetweenRetries time.Duration, deleteFallback bool) errors.AutoscalerError {
 
 	toEvict := len(pods)
 	retryUntil := time.Now().Add(maxPodEvictionTime)
 	confirmations := make(chan error, toEvict)
 	for _, pod := range pods {
 		go func(podToEvict *apiv1.Pod) {
-			confirmations <- evictPod(podToEvict, client, recorder, maxGracefulTerminationSec, retryUntil, waitBetweenRetries)
+			confirmations <- evictPod(podToEvict, client, recorder, maxGracefulTerminationSec, retryUntil, waitBetweenRetries, deleteFallback)
 		}(pod)
 	}
 
@@ -756,30 +1365,55 @@ func drainNode(node *apiv1.Node, pods []*apiv1.Pod, client kube_client.Interface
 			errors.ApiCallError, "Failed to drain node %s/%s, due to following errors: %v", node.Namespace, node.Name, evictionErrs)
 	}
 
-	// Evictions created successfully, wait maxGracefulTerminationSec + PodEvictionHeadroom to see if pods really disappeared.
-	allGone := true
-	for start := time.Now(); time.Now().Sub(start) < time.Duration(maxGracefulTerminationSec)*time.Second+PodEvictionHeadroom; time.Sleep(5 * time.Second) {
-		allGone = true
-		for _, pod := range pods {
+	// Evictions created successfully, wait for each pod its grace period + PodEvictionHeadroom to see if
+	// pods really disappeared.
+	evictionsCreated := time.Now()
+	deadlines := make(map[*apiv1.Pod]time.Time, len(pods))
+	for _, pod := range pods {
+		gracePeriod := time.Duration(podGracePeriodSec(pod, maxGracefulTerminationSec)) * time.Second
+		deadlines[pod] = evictionsCreated.Add(gracePeriod + PodEvictionHeadroom)
+	}
+	for {
+		for pod := range deadlines {
 			podreturned, err := client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
 			if err == nil {
-				glog.Errorf("Not deleted yet %v", podreturned)
-				allGone = false
-				break
-			}
-			if !kube_errors.IsNotFound(err) {
+				glog.V(4).Infof("Not deleted yet %s/%s", podreturned.Namespace, podreturned.Name)
+			} else if kube_errors.IsNotFound(err) {
+				delete(deadlines, pod)
+			} else {
 				glog.Errorf("Failed to check pod %s/%s: %v", pod.Namespace, pod.Name, err)
-				allGone = false
 			}
 		}
-		if allGone {
+		if len(deadlines) == 0 {
 			glog.V(1).Infof("All pods removed from %s", node.Name)
-			// Let the deferred function know there is no need for cleanup
 			return nil
 		}
+		// The node deadline is recomputed from the pods which are still there.
+		var deadline time.Time
+		for _, podDeadline := range deadlines {
+			if podDeadline.After(deadline) {
+				deadline = podDeadline
+			}
+		}
+		if maxNodeDrainTime > 0 && deadline.After(evictionsCreated.Add(maxNodeDrainTime)) {
+			deadline = evictionsCreated.Add(maxNodeDrainTime)
+		}
+		wait := deadline.Sub(time.Now())
+		if wait <= 0 {
+			break
+		}
+		if wait > podTerminationCheckInterval {
+			wait = podTerminationCheckInterval
+		}
+		time.Sleep(wait)
 	}
+	remaining := make([]string, 0, len(deadlines))
+	for pod := range deadlines {
+		remaining = append(remaining, pod.Namespace+"/"+pod.Name)
+	}
+	sort.Strings(remaining)
 	return errors.NewAutoscalerError(
-		errors.TransientError, "Failed to drain node %s/%s: pods remaining after timeout", node.Namespace, node.Name)
+		errors.TransientError, "Failed to drain node %s/%s: pods remaining after timeout: %v", node.Namespace, node.Name, remaining)
 }
 
 // cleanToBeDeleted cleans ToBeDeleted taints.
@@ -797,6 +1431,34 @@ func cleanToBeDeleted(nodes []*apiv1.Node, client kube_client.Interface, recorde
 	}
 }
 
+// CleanUpStaleToBeDeletedTaints removes ToBeDeleted taints older than ToBeDeletedTaintTTL. Such
+// taints are left when CA restarts in the middle of a scale-down and the startup cleanup misses
+// the node. Nothing is removed while a node deletion is in progress.
+func (sd *ScaleDown) CleanUpStaleToBeDeletedTaints(nodes []*apiv1.Node, timestamp time.Time) {
+	if sd.nodeDeleteStatus.IsDeleteInProgress() {
+		return
+	}
+	addedBefore := timestamp.Add(-sd.context.ToBeDeletedTaintTTL)
+	for _, node := range nodes {
+		deleteTime, err := deletetaint.GetToBeDeletedTime(node)
+		if err != nil {
+			glog.Warningf("Failed to parse toBeDeletedTaint on node %v: %v", node.Name, err)
+			continue
+		}
+		if deleteTime == nil || !deleteTime.Before(addedBefore) {
+			continue
+		}
+		cleaned, err := deletetaint.CleanStaleToBeDeleted(node, sd.context.ClientSet, addedBefore)
+		if err != nil {
+			sd.context.Recorder.Eventf(node, apiv1.EventTypeWarning, "ClusterAutoscalerCleanup",
+				"failed to clean stale toBeDeletedTaint: %v", err)
+		} else if cleaned {
+			sd.context.Recorder.Eventf(node, apiv1.EventTypeNormal, "ClusterAutoscalerCleanup",
+				"marking the node as schedulable, toBeDeletedTaint added at %v expired", deleteTime)
+		}
+	}
+}
+
 // Removes the given node from cloud provider. No extra pre-deletion actions are executed on
 // the Kubernetes side.
 func deleteNodeFromCloudProvider(node *apiv1.Node, cloudProvider cloudprovider.CloudProvider,
@@ -812,22 +1474,48 @@ func deleteNodeFromCloudProvider(node *apiv1.Node, cloudProvider cloudprovider.C
 	if err = nodeGroup.DeleteNodes([]*apiv1.Node{node}); err != nil {
 		return errors.NewAutoscalerError(errors.CloudProviderError, "failed to delete %s: %v", node.Name, err)
 	}
-	recorder.Eventf(node, apiv1.EventTypeNormal, "ScaleDown", "node removed by cluster autoscaler")
+	savings, savingsKnown := estimateSavings(node, cloudProvider, time.Now())
+	if savingsKnown {
+		recorder.Eventf(node, apiv1.EventTypeNormal, "ScaleDown", "node removed by cluster autoscaler, estimated savings: $%.4f/hour", savings)
+		metrics.RegisterEstimatedSavings(nodeGroup.Id(), savings)
+	} else {
+		recorder.Eventf(node, apiv1.EventTypeNormal, "ScaleDown", "node removed by cluster autoscaler")
+	}
 	registry.RegisterScaleDown(&clusterstate.ScaleDownRequest{
-		NodeGroupName:      nodeGroup.Id(),
-		NodeName:           node.Name,
-		Time:               time.Now(),
-		ExpectedDeleteTime: time.Now().Add(MaxCloudProviderNodeDeletionTime),
+		NodeGroupName:           nodeGroup.Id(),
+		NodeName:                node.Name,
+		Time:                    time.Now(),
+		ExpectedDeleteTime:      time.Now().Add(MaxCloudProviderNodeDeletionTime),
+		EstimatedSavingsPerHour: savings,
 	})
 	return nil
 }
 
+// estimateSavings returns the hourly price of a node removed by scale-down. The second result is false
+// if the cloud provider has no pricing model or the price is unknown.
+func estimateSavings(node *apiv1.Node, cloudProvider cloudprovider.CloudProvider, now time.Time) (float64, bool) {
+	pricingModel, typedErr := cloudProvider.Pricing()
+	if typedErr != nil || pricingModel == nil {
+		return 0, false
+	}
+	price, err := pricingModel.NodePrice(node, now, now.Add(time.Hour))
+	if err != nil {
+		glog.Warningf("Failed to get price of removed node %s: %v", node.Name, err)
+		return 0, false
+	}
+	return price, true
+}
+
 func hasNoScaleDownAnnotation(node *apiv1.Node) bool {
 	return node.Annotations[ScaleDownDisabledKey] == "true"
 }
 
-func cleanUpNodeAutoprovisionedGroups(cloudProvider cloudprovider.CloudProvider, logRecorder *utils.LogEventRecorder) error {
+// cleanUpNodeAutoprovisionedGroups deletes autoprovisioned node groups that have been empty for
+// at least emptyTTL. emptySince keeps the time each empty node group was first seen empty.
+func cleanUpNodeAutoprovisionedGroups(cloudProvider cloudprovider.CloudProvider, logRecorder *utils.LogEventRecorder,
+	emptySince map[string]time.Time, emptyTTL time.Duration, now time.Time) error {
 	nodeGroups := cloudProvider.NodeGroups()
+	empty := make(map[string]bool)
 	for _, nodeGroup := range nodeGroups {
 		if !nodeGroup.Autoprovisioned() {
 			continue
@@ -838,6 +1526,16 @@ func cleanUpNodeAutoprovisionedGroups(cloudProvider cloudprovider.CloudProvider,
 		}
 		if size == 0 {
 			ngId := nodeGroup.Id()
+			since, found := emptySince[ngId]
+			if !found {
+				emptySince[ngId] = now
+				since = now
+			}
+			if since.Add(emptyTTL).After(now) {
+				empty[ngId] = true
+				glog.V(4).Infof("Autoprovisioned node group %s is empty since %s, waiting before deleting it", ngId, since)
+				continue
+			}
 			if err := nodeGroup.Delete(); err != nil {
 				logRecorder.Eventf(apiv1.EventTypeWarning, "FailedToDeleteNodeGroup",
 					"NodeAutoprovisioning: attempt to delete node group %v failed: %v", ngId, err)
@@ -849,6 +1547,11 @@ func cleanUpNodeAutoprovisionedGroups(cloudProvider cloudprovider.CloudProvider,
 			metrics.RegisterNodeGroupDeletion()
 		}
 	}
+	for ngId := range emptySince {
+		if !empty[ngId] {
+			delete(emptySince, ngId)
+		}
+	}
 	return nil
 }
 
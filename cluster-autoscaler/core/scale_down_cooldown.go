@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+)
+
+// scaleDownCooldown describes the node removals held back by ScaleDownDelayAfterAdd and
+// ScaleDownDelayAfterFailure when they don't hold back the whole scale down. A nil cooldown
+// holds back nothing.
+type scaleDownCooldown struct {
+	// nodeGroups are node groups none of whose nodes can be removed.
+	nodeGroups map[string]bool
+	// drainNodeGroups are node groups whose nodes with pods can't be removed.
+	drainNodeGroups map[string]bool
+	// drainAll is true if no node with pods can be removed.
+	drainAll bool
+}
+
+// allowsEmpty returns true if empty nodes of the node group can be removed.
+func (c *scaleDownCooldown) allowsEmpty(nodeGroupId string) bool {
+	return c == nil || !c.nodeGroups[nodeGroupId]
+}
+
+// allowsDrain returns true if nodes of the node group can be drained and removed.
+func (c *scaleDownCooldown) allowsDrain(nodeGroupId string) bool {
+	return c == nil || (!c.drainAll && !c.nodeGroups[nodeGroupId] && !c.drainNodeGroups[nodeGroupId])
+}
+
+// buildScaleDownCooldown returns the removals held back by scale down delays after the last scale up,
+// lastScaleUpTimes of individual node groups and the last failed scale down. The second value is
+// true if the whole scale down is held back.
+func buildScaleDownCooldown(context *AutoscalingContext, lastScaleUpTime time.Time, lastScaleUpTimes map[string]time.Time,
+	lastScaleDownFailTime time.Time, now time.Time) (*scaleDownCooldown, bool) {
+	clusterCooldown := lastScaleDownFailTime.Add(context.ScaleDownDelayAfterFailure).After(now)
+	groupCooldowns := make(map[string]bool)
+	if context.ScaleDownDelayPerNodeGroup {
+		for id, scaleUpTime := range lastScaleUpTimes {
+			if scaleUpTime.Add(context.ScaleDownDelayAfterAdd).After(now) {
+				groupCooldowns[id] = true
+			}
+		}
+	} else if lastScaleUpTime.Add(context.ScaleDownDelayAfterAdd).After(now) {
+		clusterCooldown = true
+	}
+
+	if context.BypassScaleDownDelayForEmpty {
+		if !clusterCooldown && len(groupCooldowns) == 0 {
+			return nil, false
+		}
+		return &scaleDownCooldown{drainNodeGroups: groupCooldowns, drainAll: clusterCooldown}, false
+	}
+	if clusterCooldown {
+		return nil, true
+	}
+	if len(groupCooldowns) == 0 {
+		return nil, false
+	}
+	return &scaleDownCooldown{nodeGroups: groupCooldowns}, false
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildScaleDownCooldown(t *testing.T) {
+	now := time.Now()
+	recently := now.Add(-time.Minute)
+	longAgo := now.Add(-time.Hour)
+	options := AutoscalingOptions{
+		ScaleDownDelayAfterAdd:     10 * time.Minute,
+		ScaleDownDelayAfterFailure: 3 * time.Minute,
+	}
+	build := func(perNodeGroup, bypass bool, lastScaleUp time.Time, lastScaleUps map[string]time.Time, lastFail time.Time) (*scaleDownCooldown, bool) {
+		context := &AutoscalingContext{AutoscalingOptions: options}
+		context.ScaleDownDelayPerNodeGroup = perNodeGroup
+		context.BypassScaleDownDelayForEmpty = bypass
+		return buildScaleDownCooldown(context, lastScaleUp, lastScaleUps, lastFail, now)
+	}
+	scaleUps := map[string]time.Time{"ng1": recently, "ng2": longAgo}
+
+	// Nothing happened recently.
+	cooldown, delayed := build(false, false, longAgo, nil, longAgo)
+	assert.Nil(t, cooldown)
+	assert.False(t, delayed)
+
+	// Any scale up delays the whole scale down.
+	cooldown, delayed = build(false, false, recently, scaleUps, longAgo)
+	assert.Nil(t, cooldown)
+	assert.True(t, delayed)
+
+	// Only the node group scaled up recently is delayed.
+	cooldown, delayed = build(true, false, recently, scaleUps, longAgo)
+	assert.False(t, delayed)
+	assert.False(t, cooldown.allowsEmpty("ng1"))
+	assert.False(t, cooldown.allowsDrain("ng1"))
+	assert.True(t, cooldown.allowsEmpty("ng2"))
+	assert.True(t, cooldown.allowsDrain("ng2"))
+
+	// Failures delay the whole scale down regardless of the delay type.
+	cooldown, delayed = build(true, false, longAgo, nil, recently)
+	assert.Nil(t, cooldown)
+	assert.True(t, delayed)
+
+	// Empty nodes bypass the delays, drains don't.
+	cooldown, delayed = build(false, true, recently, scaleUps, longAgo)
+	assert.False(t, delayed)
+	assert.True(t, cooldown.allowsEmpty("ng1"))
+	assert.False(t, cooldown.allowsDrain("ng2"))
+
+	cooldown, delayed = build(true, true, recently, scaleUps, recently)
+	assert.False(t, delayed)
+	assert.True(t, cooldown.allowsEmpty("ng1"))
+	assert.False(t, cooldown.allowsDrain("ng2"))
+
+	cooldown, delayed = build(true, true, recently, scaleUps, longAgo)
+	assert.False(t, delayed)
+	assert.True(t, cooldown.allowsEmpty("ng1"))
+	assert.False(t, cooldown.allowsDrain("ng1"))
+	assert.True(t, cooldown.allowsDrain("ng2"))
+
+	var nilCooldown *scaleDownCooldown
+	assert.True(t, nilCooldown.allowsEmpty("ng1"))
+	assert.True(t, nilCooldown.allowsDrain("ng1"))
+}
+
+func TestScaleDownEmptyAfterScaleUpOfOtherNodeGroup(t *testing.T) {
+	config := &scaleTestConfig{
+		nodes: []nodeConfig{
+			{"n1_1", 1000, 1000, true, "ng1"},
+			{"n1_2", 1000, 1000, true, "ng1"},
+			{"n2_1", 1000, 1000, true, "ng2"},
+			{"n2_2", 1000, 1000, true, "ng2"},
+		},
+		options:            defaultScaleDownOptions,
+		expectedScaleDowns: []string{"n2_1"},
+		// ng1 was just scaled up.
+		scaleDownCooldown: &scaleDownCooldown{nodeGroups: map[string]bool{"ng1": true}},
+	}
+	simpleScaleDownEmpty(t, config)
+}
+
+func TestScaleDownEmptyBypassingDelay(t *testing.T) {
+	config := &scaleTestConfig{
+		nodes: []nodeConfig{
+			{"n1_1", 1000, 1000, true, "ng1"},
+			{"n1_2", 1000, 1000, true, "ng1"},
+			{"n2_1", 1000, 1000, true, "ng2"},
+			{"n2_2", 1000, 1000, true, "ng2"},
+		},
+		options:            defaultScaleDownOptions,
+		expectedScaleDowns: []string{"n1_1", "n2_1"},
+		scaleDownCooldown:  &scaleDownCooldown{drainAll: true},
+	}
+	simpleScaleDownEmpty(t, config)
+}
+
+func TestScaleDownDrainHeldBackByDelay(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, time.Time{})
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 800, 0)
+	p2.Spec.NodeName = "n2"
+
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{*p1, *p2}}, nil
+	})
+	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(apiv1.Resource("pod"), "whatever")
+	})
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		getAction := action.(core.GetAction)
+		switch getAction.GetName() {
+		case n1.Name:
+			return true, n1, nil
+		case n2.Name:
+			return true, n2, nil
+		}
+		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+	})
+	fakeClient.Fake.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		t.FailNow()
+		return false, nil, nil
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		t.FailNow()
+		return false, nil, nil
+	})
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		t.FailNow()
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold: 0.5,
+			ScaleDownUnneededTime:         time.Minute,
+			MaxGracefulTerminationSec:     60,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		LogRecorder:          fakeLogRecorder,
+	}
+	scaleDown := NewScaleDown(context)
+	scaleDown.cooldown = &scaleDownCooldown{drainNodeGroups: map[string]bool{"ng1": true}}
+	scaleDown.UpdateUnneededNodes([]*apiv1.Node{n1, n2},
+		[]*apiv1.Node{n1, n2}, []*apiv1.Pod{p1, p2}, time.Now().Add(-5*time.Minute), nil)
+	result, err := scaleDown.TryToScaleDown([]*apiv1.Node{n1, n2}, []*apiv1.Pod{p1, p2}, nil, time.Now())
+	waitForDeleteToFinish(t, scaleDown)
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// Names of scale-down eligibility rules, used as the rule label of rejection metrics.
+const (
+	// RecentlyUnremovableRule rejects nodes found unremovable in a recent simulation.
+	RecentlyUnremovableRule = "recently_unremovable"
+	// BeingDeletedRule rejects nodes recently marked to be deleted.
+	BeingDeletedRule = "being_deleted"
+	// ScaleDownDisabledRule rejects nodes with the scale-down-disabled annotation.
+	ScaleDownDisabledRule = "scale_down_disabled"
+	// NodeInfoMissingRule rejects nodes without a node info.
+	NodeInfoMissingRule = "node_info_missing"
+	// UtilizationRule rejects nodes with utilization at or above the scale-down threshold.
+	UtilizationRule = "utilization"
+)
+
+// scaleDownEligibilityRule excludes nodes from scale-down considerations.
+type scaleDownEligibilityRule struct {
+	name string
+	// rejects returns true if the node is not eligible for scale-down.
+	rejects func(node *apiv1.Node) bool
+}
+
+// scaleDownEligibilityPipeline runs rules in order and stops at the first one rejecting a node.
+// Rules are ordered from the cheapest, and rejections are counted per rule, so it's visible which
+// rule excludes how many nodes.
+type scaleDownEligibilityPipeline struct {
+	rules      []scaleDownEligibilityRule
+	rejections map[string]int
+}
+
+func newScaleDownEligibilityPipeline(rules ...scaleDownEligibilityRule) *scaleDownEligibilityPipeline {
+	return &scaleDownEligibilityPipeline{
+		rules:      rules,
+		rejections: make(map[string]int),
+	}
+}
+
+// eligible returns true if none of the rules rejects the node.
+func (p *scaleDownEligibilityPipeline) eligible(node *apiv1.Node) bool {
+	for _, rule := range p.rules {
+		if rule.rejects(node) {
+			p.rejections[rule.name]++
+			return false
+		}
+	}
+	return true
+}
+
+// ruleNames returns names of the rules in the order they are run.
+func (p *scaleDownEligibilityPipeline) ruleNames() []string {
+	names := make([]string, 0, len(p.rules))
+	for _, rule := range p.rules {
+		names = append(names, rule.name)
+	}
+	return names
+}
+
+// eligibilityPipeline builds the rules deciding which nodes are checked for removal in the
+// simulation. Utilization, the only rule that isn't a simple lookup, is calculated last and stored
+// in utilizationMap.
+func (sd *ScaleDown) eligibilityPipeline(nodeNameToNodeInfo map[string]*schedulercache.NodeInfo,
+	utilizationMap map[string]float64, timestamp time.Time) *scaleDownEligibilityPipeline {
+	return newScaleDownEligibilityPipeline(
+		scaleDownEligibilityRule{
+			name: RecentlyUnremovableRule,
+			rejects: func(node *apiv1.Node) bool {
+				unremovableTimestamp, found := sd.unremovableNodes[node.Name]
+				if !found {
+					return false
+				}
+				if unremovableTimestamp.After(timestamp) {
+					return true
+				}
+				delete(sd.unremovableNodes, node.Name)
+				delete(sd.unremovableReasons, node.Name)
+				return false
+			},
+		},
+		scaleDownEligibilityRule{
+			name: BeingDeletedRule,
+			rejects: func(node *apiv1.Node) bool {
+				// Old-time marked nodes are again eligible for deletion - something went wrong with them
+				// and they have not been deleted.
+				deleteTime, _ := deletetaint.GetToBeDeletedTime(node)
+				if deleteTime != nil && (timestamp.Sub(*deleteTime) < MaxCloudProviderNodeDeletionTime || timestamp.Sub(*deleteTime) < MaxKubernetesEmptyNodeDeletionTime) {
+					glog.V(1).Infof("Skipping %s from delete considerations - the node is currently being deleted", node.Name)
+					return true
+				}
+				return false
+			},
+		},
+		scaleDownEligibilityRule{
+			name: ScaleDownDisabledRule,
+			rejects: func(node *apiv1.Node) bool {
+				if hasNoScaleDownAnnotation(node) {
+					glog.V(1).Infof("Skipping %s from delete consideration - the node is marked as no scale down", node.Name)
+					return true
+				}
+				return false
+			},
+		},
+		scaleDownEligibilityRule{
+			name: NodeInfoMissingRule,
+			rejects: func(node *apiv1.Node) bool {
+				if _, found := nodeNameToNodeInfo[node.Name]; !found {
+					glog.Errorf("Node info for %s not found", node.Name)
+					return true
+				}
+				return false
+			},
+		},
+		scaleDownEligibilityRule{
+			name: UtilizationRule,
+			rejects: func(node *apiv1.Node) bool {
+				utilization, err := sd.calculateUtilization(node, nodeNameToNodeInfo[node.Name])
+				if err != nil {
+					glog.Warningf("Failed to calculate utilization for %s: %v", node.Name, err)
+				}
+				glog.V(4).Infof("Node %s - utilization %f", node.Name, utilization)
+				utilizationMap[node.Name] = utilization
+
+				threshold := sd.context.ScaleDownUtilizationThreshold
+				if nodeGroup, err := sd.context.CloudProvider.NodeGroupForNode(node); err == nil {
+					threshold = sd.context.NodeGroupConfigProcessor.GetOptions(sd.context, nodeGroup).ScaleDownUtilizationThreshold
+				}
+				if utilization >= threshold {
+					glog.V(4).Infof("Node %s is not suitable for removal - utilization too big (%f)", node.Name, utilization)
+					return true
+				}
+				return false
+			},
+		},
+	)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleDownEligibilityRuleOrder(t *testing.T) {
+	sd, _, _ := buildTimeSlicedScaleDownTest(1, 0)
+	pipeline := sd.eligibilityPipeline(map[string]*schedulercache.NodeInfo{}, map[string]float64{}, time.Now())
+	assert.Equal(t, []string{RecentlyUnremovableRule, BeingDeletedRule, ScaleDownDisabledRule, NodeInfoMissingRule, UtilizationRule},
+		pipeline.ruleNames())
+}
+
+func TestScaleDownEligibilitySkipsUtilization(t *testing.T) {
+	sd, nodes, _ := buildTimeSlicedScaleDownTest(6, 0)
+	now := time.Now()
+
+	// n0 is underutilized and n1 has high utilization, both need the utilization calculated.
+	// n2 was recently found unremovable.
+	sd.unremovableNodes["n2"] = now.Add(time.Minute)
+	// n3 is being deleted.
+	nodes[3].Spec.Taints = []apiv1.Taint{{Key: deletetaint.ToBeDeletedTaint, Value: strconv.FormatInt(now.Unix()-60, 10)}}
+	// n4 has scale-down disabled.
+	nodes[4].Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+	// n5 has no node info.
+	nodeNameToNodeInfo := map[string]*schedulercache.NodeInfo{}
+	for _, node := range nodes[:5] {
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(node)
+		nodeNameToNodeInfo[node.Name] = nodeInfo
+	}
+	calculated := make([]string, 0)
+	sd.calculateUtilization = func(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo) (float64, error) {
+		calculated = append(calculated, node.Name)
+		if node.Name == "n1" {
+			return 0.9, nil
+		}
+		return simulator.CalculateUtilization(node, nodeInfo)
+	}
+
+	utilizationMap := make(map[string]float64)
+	pipeline := sd.eligibilityPipeline(nodeNameToNodeInfo, utilizationMap, now)
+	eligible := make([]string, 0)
+	for _, node := range nodes {
+		if pipeline.eligible(node) {
+			eligible = append(eligible, node.Name)
+		}
+	}
+
+	assert.Equal(t, []string{"n0"}, eligible)
+	assert.Equal(t, []string{"n0", "n1"}, calculated)
+	assert.Equal(t, map[string]float64{"n0": 0, "n1": 0.9}, utilizationMap)
+	assert.Equal(t, map[string]int{
+		RecentlyUnremovableRule: 1,
+		BeingDeletedRule:        1,
+		ScaleDownDisabledRule:   1,
+		NodeInfoMissingRule:     1,
+		UtilizationRule:         1,
+	}, pipeline.rejections)
+}
+
+func TestUpdateUnneededNodesCalculatesUtilizationOfEligibleNodes(t *testing.T) {
+	sd, nodes, pods := buildTimeSlicedScaleDownTest(3, 0)
+	now := time.Now()
+	nodes[1].Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+	sd.unremovableNodes["n2"] = now.Add(time.Minute)
+	calculated := make([]string, 0)
+	sd.calculateUtilization = func(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo) (float64, error) {
+		calculated = append(calculated, node.Name)
+		return simulator.CalculateUtilization(node, nodeInfo)
+	}
+
+	assert.NoError(t, sd.UpdateUnneededNodes(nodes, nodes, pods, now, nil))
+	assert.Equal(t, []string{"n0"}, calculated)
+	assert.Contains(t, sd.nodeUtilizationMap, "n0")
+	assert.Len(t, sd.nodeUtilizationMap, 1)
+}
+
+func TestScaleDownEligibilityAcceleratorOnlyNode(t *testing.T) {
+	sd, nodes, pods := buildTimeSlicedScaleDownTest(1, 0)
+	now := time.Now()
+	// The node has an AMD GPU nobody uses, but its CPU is almost fully requested.
+	nodes[0].Status.Capacity["amd.com/gpu"] = *resource.NewQuantity(1, resource.DecimalSI)
+	nodes[0].Status.Allocatable["amd.com/gpu"] = *resource.NewQuantity(1, resource.DecimalSI)
+	pods[0].Spec.Containers[0].Resources.Requests[apiv1.ResourceCPU] = *resource.NewMilliQuantity(900, resource.DecimalSI)
+	nodeInfo := schedulercache.NewNodeInfo(pods[0])
+	nodeInfo.SetNode(nodes[0])
+	nodeNameToNodeInfo := map[string]*schedulercache.NodeInfo{"n0": nodeInfo}
+
+	// Without the accelerator registered, the node is utilized by CPU.
+	utilizationMap := make(map[string]float64)
+	assert.False(t, sd.eligibilityPipeline(nodeNameToNodeInfo, utilizationMap, now).eligible(nodes[0]))
+	assert.Equal(t, map[string]float64{"n0": 0.9}, utilizationMap)
+
+	// With the accelerator registered and GPU utilization enabled, only its utilization counts, as for Nvidia GPUs.
+	sd.calculateUtilization = simulator.CalculateGpuAwareUtilization
+	gpu.RegisterAccelerators([]gpu.Accelerator{{ResourceName: "amd.com/gpu"}})
+	defer gpu.RegisterAccelerators(nil)
+	utilizationMap = make(map[string]float64)
+	assert.True(t, sd.eligibilityPipeline(nodeNameToNodeInfo, utilizationMap, now).eligible(nodes[0]))
+	assert.Equal(t, map[string]float64{"n0": 0}, utilizationMap)
+}
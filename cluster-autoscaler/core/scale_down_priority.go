@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// nodePriorityScores scores nodes by the highest priority of the pods that would have to be moved
+// from them, multiplied by weight. DaemonSet and mirror pods are skipped, as they don't move.
+// Returns nil if weight is 0.
+func nodePriorityScores(nodes []*apiv1.Node, nodeNameToNodeInfo map[string]*schedulercache.NodeInfo, weight float64) map[string]float64 {
+	if weight == 0 {
+		return nil
+	}
+	scores := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		nodeInfo, found := nodeNameToNodeInfo[node.Name]
+		if !found {
+			continue
+		}
+		var maxPriority int32
+		first := true
+		for _, pod := range nodeInfo.Pods() {
+			if drain.IsMirrorPod(pod) {
+				continue
+			}
+			if controllerRef := drain.ControllerRef(pod); controllerRef != nil && controllerRef.Kind == "DaemonSet" {
+				continue
+			}
+			if priority := podPriority(pod); first || priority > maxPriority {
+				maxPriority = priority
+				first = false
+			}
+		}
+		scores[node.Name] = weight * float64(maxPriority)
+		glog.V(4).Infof("Node %s has scale-down priority score %v", node.Name, scores[node.Name])
+	}
+	return scores
+}
+
+// orderCandidatesByPriorityScore reorders scale-down candidates with the same utilization, rounded to
+// a percent, so that the ones with lower priority scores come first. Candidates with different
+// utilization keep their positions.
+func orderCandidatesByPriorityScore(candidates []*apiv1.Node, utilization map[string]float64, scores map[string]float64) []*apiv1.Node {
+	if len(scores) == 0 {
+		return candidates
+	}
+	positions := make(map[int64][]int)
+	for i, node := range candidates {
+		percent := int64(utilization[node.Name]*100 + 0.5)
+		positions[percent] = append(positions[percent], i)
+	}
+	result := make([]*apiv1.Node, len(candidates))
+	copy(result, candidates)
+	for _, group := range positions {
+		if len(group) < 2 {
+			continue
+		}
+		members := make([]*apiv1.Node, 0, len(group))
+		for _, i := range group {
+			members = append(members, candidates[i])
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return scores[members[i].Name] < scores[members[j].Name]
+		})
+		for k, i := range group {
+			result[i] = members[k]
+		}
+	}
+	return result
+}
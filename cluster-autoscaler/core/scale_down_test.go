@@ -17,6 +17,7 @@ limitations under the License.
 package core
 
 import (
+	"flag"
 	"fmt"
 	"sort"
 	"testing"
@@ -24,21 +25,28 @@ import (
 
 	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
 	policyv1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider/gce"
 	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
 	"k8s.io/autoscaler/cluster-autoscaler/config"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	autoscaler_errors "k8s.io/autoscaler/cluster-autoscaler/utils/errors"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 	"k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"strconv"
 
@@ -130,6 +138,7 @@ func TestFindUnneededNodes(t *testing.T) {
 		},
 		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
 		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
 		LogRecorder:          fakeLogRecorder,
 		CloudProvider:        provider,
 	}
@@ -168,12 +177,250 @@ func TestFindUnneededNodes(t *testing.T) {
 	assert.Equal(t, 0, len(sd.unneededNodes))
 	// Verify that no other nodes are in unremovable map.
 	assert.Equal(t, 1, len(sd.unremovableNodes))
+	assert.Equal(t, simulator.UnremovableReason(drain.NotReplicated), sd.unremovableReasons["n1"])
 
 	// But it should be checked after timeout
 	sd.UpdateUnneededNodes([]*apiv1.Node{n1}, []*apiv1.Node{n1}, []*apiv1.Pod{}, time.Now().Add(UnremovableNodeRecheckTimeout+time.Second), nil)
 	assert.Equal(t, 1, len(sd.unneededNodes))
 	// Verify that nodes that are no longer unremovable are removed.
 	assert.Equal(t, 0, len(sd.unremovableNodes))
+	assert.Equal(t, 0, len(sd.unremovableReasons))
+}
+
+func buildTimeSlicedScaleDownTest(nodeCount int, sliceSize int) (*ScaleDown, []*apiv1.Node, []*apiv1.Pod) {
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, nodeCount, nodeCount)
+
+	nodes := make([]*apiv1.Node, 0, nodeCount)
+	pods := make([]*apiv1.Pod, 0, nodeCount)
+	for i := 0; i < nodeCount; i++ {
+		node := BuildTestNode(fmt.Sprintf("n%d", i), 1000, 10)
+		SetNodeReadyState(node, true, time.Time{})
+		provider.AddNode("ng1", node)
+		nodes = append(nodes, node)
+
+		pod := BuildTestPod(fmt.Sprintf("p%d", i), 100, 0)
+		pod.OwnerReferences = ownerRef
+		pod.Spec.NodeName = node.Name
+		pods = append(pods, pod)
+	}
+
+	fakeClient := &fake.Clientset{}
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold: 0.35,
+			ScaleDownSimulationSliceSize:  sliceSize,
+		},
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
+		LogRecorder:          fakeLogRecorder,
+		CloudProvider:        provider,
+	}
+	return NewScaleDown(&context), nodes, pods
+}
+
+func TestFindUnneededNodesTimeSliced(t *testing.T) {
+	sd, nodes, pods := buildTimeSlicedScaleDownTest(4, 2)
+	t1 := time.Now()
+	t2 := t1.Add(10 * time.Second)
+	t3 := t2.Add(10 * time.Second)
+
+	sd.UpdateUnneededNodes(nodes, nodes, pods, t1, nil)
+	assert.Equal(t, map[string]time.Time{"n0": t1, "n1": t1}, sd.unneededNodes)
+
+	// The second slice is simulated, results for the first one are kept.
+	sd.UpdateUnneededNodes(nodes, nodes, pods, t2, nil)
+	assert.Equal(t, map[string]time.Time{"n0": t1, "n1": t1, "n2": t2, "n3": t2}, sd.unneededNodes)
+
+	// Simulation wraps around and timers are preserved.
+	sd.UpdateUnneededNodes(nodes, nodes, pods, t3, nil)
+	assert.Equal(t, map[string]time.Time{"n0": t1, "n1": t1, "n2": t2, "n3": t2}, sd.unneededNodes)
+
+	// A node that is no longer underutilized is dropped even if it's not simulated in this loop.
+	bigPod := BuildTestPod("big", 900, 0)
+	bigPod.Spec.NodeName = "n3"
+	sd.UpdateUnneededNodes(nodes, nodes, append(pods, bigPod), t3, nil)
+	assert.Equal(t, map[string]time.Time{"n0": t1, "n1": t1, "n2": t2}, sd.unneededNodes)
+}
+
+func TestFindUnneededNodesTimeSlicedKeepsPlacements(t *testing.T) {
+	sd, _, _ := buildTimeSlicedScaleDownTest(0, 1)
+	sd.context.ScaleDownUtilizationThreshold = 0.7
+	provider := sd.context.CloudProvider.(*testprovider.TestCloudProvider)
+	provider.AddNodeGroup("ng2", 1, 3, 3)
+
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	nodes := make([]*apiv1.Node, 0)
+	pods := make([]*apiv1.Pod, 0)
+	for i, size := range []struct{ node, pod int64 }{{1000, 400}, {1000, 400}, {2000, 1500}} {
+		node := BuildTestNode(fmt.Sprintf("n%d", i), size.node, 10)
+		SetNodeReadyState(node, true, time.Time{})
+		provider.AddNode("ng2", node)
+		nodes = append(nodes, node)
+		pod := BuildTestPod(fmt.Sprintf("p%d", i), size.pod, 0)
+		pod.OwnerReferences = ownerRef
+		pod.Spec.NodeName = node.Name
+		pods = append(pods, pod)
+	}
+	t1 := time.Now()
+	t2 := t1.Add(10 * time.Second)
+
+	// p0 is moved to n2, which is then too full to take p1.
+	sd.podLocationHints["default/p0"] = "n2"
+	sd.UpdateUnneededNodes(nodes, nodes, pods, t1, nil)
+	assert.Equal(t, map[string]time.Time{"n0": t1}, sd.unneededNodes)
+
+	// n0 is carried over, so p1 can go neither to n0 nor to the capacity of n2 taken by p0.
+	sd.UpdateUnneededNodes(nodes, nodes, pods, t2, nil)
+	assert.Equal(t, map[string]time.Time{"n0": t1}, sd.unneededNodes)
+	assert.Contains(t, sd.unremovableNodes, "n1")
+}
+
+func TestFindUnneededNodesWithUpcomingNodes(t *testing.T) {
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	n1 := BuildTestNode("n1", 1000, 10)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 10)
+	SetNodeReadyState(n2, true, time.Time{})
+	p1 := BuildTestPod("p1", 600, 0)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 600, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+	nodes := []*apiv1.Node{n1, n2}
+	pods := []*apiv1.Pod{p1, p2}
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	// A scale-up of ng1 to 3 nodes is in progress.
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	now := time.Now()
+	clusterState.UpdateNodes(nodes, now)
+	assert.Equal(t, 1, clusterState.GetUpcomingNodes()["ng1"])
+
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold: 0.7,
+			ScaleDownUnneededTime:         time.Minute,
+		},
+		ClusterStateRegistry: clusterState,
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
+		LogRecorder:          fakeLogRecorder,
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+	}
+
+	// Without upcoming nodes pods have nowhere to go.
+	sd := NewScaleDown(context)
+	sd.UpdateUnneededNodes(nodes, nodes, pods, now, nil)
+	assert.Empty(t, sd.unneededNodes)
+
+	// With upcoming nodes both nodes are unneeded, but can't be removed yet.
+	context.ScaleDownSimulateUpcomingNodes = true
+	sd = NewScaleDown(context)
+	sd.UpdateUnneededNodes(nodes, nodes, pods, now, nil)
+	assert.Equal(t, 2, len(sd.unneededNodes))
+	assert.Equal(t, map[string]bool{"n1": true, "n2": true}, sd.tentativeNodes)
+
+	result, err := sd.TryToScaleDown(nodes, pods, nil, now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoUnneeded, result)
+
+	// The scale-up never completes and the node group goes back to its previous size.
+	provider.NodeGroups()[0].(*testprovider.TestNodeGroup).SetTargetSize(2)
+	clusterState.UpdateNodes(nodes, now.Add(3*time.Minute))
+	sd.UpdateUnneededNodes(nodes, nodes, pods, now.Add(3*time.Minute), nil)
+	assert.Empty(t, sd.unneededNodes)
+	assert.Empty(t, sd.tentativeNodes)
+}
+
+func TestFindUnneededNodesUpcomingNodeRegistered(t *testing.T) {
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	n1 := BuildTestNode("n1", 1000, 10)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 10)
+	SetNodeReadyState(n2, true, time.Time{})
+	n3 := BuildTestNode("n3", 1000, 10)
+	SetNodeReadyState(n3, true, time.Time{})
+	p1 := BuildTestPod("p1", 600, 0)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 600, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+	pods := []*apiv1.Pod{p1, p2}
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold:  0.7,
+			ScaleDownSimulateUpcomingNodes: true,
+		},
+		ClusterStateRegistry: clusterState,
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
+		LogRecorder:          fakeLogRecorder,
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+	}
+	sd := NewScaleDown(context)
+
+	// Scale-down doesn't stall waiting for the new node, the unneeded time is counted from the start.
+	t1 := time.Now()
+	clusterState.UpdateNodes([]*apiv1.Node{n1, n2}, t1)
+	sd.UpdateUnneededNodes([]*apiv1.Node{n1, n2}, []*apiv1.Node{n1, n2}, pods, t1, nil)
+	assert.Equal(t, map[string]time.Time{"n1": t1, "n2": t1}, sd.unneededNodes)
+	assert.Equal(t, 2, len(sd.tentativeNodes))
+
+	// Once the node registers the removal is no longer tentative.
+	t2 := t1.Add(time.Minute)
+	provider.AddNode("ng1", n3)
+	nodes := []*apiv1.Node{n1, n2, n3}
+	clusterState.UpdateNodes(nodes, t2)
+	sd.UpdateUnneededNodes(nodes, []*apiv1.Node{n1, n2}, pods, t2, nil)
+	assert.Equal(t, map[string]time.Time{"n1": t1, "n2": t1}, sd.unneededNodes)
+	assert.Empty(t, sd.tentativeNodes)
+}
+
+func BenchmarkUpdateUnneededNodes(b *testing.B) {
+	for _, sliceSize := range []int{0, 50} {
+		b.Run(fmt.Sprintf("slice-%d", sliceSize), func(b *testing.B) {
+			sd, nodes, pods := buildTimeSlicedScaleDownTest(500, sliceSize)
+			now := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sd.UpdateUnneededNodes(nodes, nodes, pods, now, nil)
+			}
+		})
+	}
 }
 
 func TestPodsWithPrioritiesFindUnneededNodes(t *testing.T) {
@@ -249,6 +496,7 @@ func TestPodsWithPrioritiesFindUnneededNodes(t *testing.T) {
 		},
 		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
 		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
 		LogRecorder:          fakeLogRecorder,
 		CloudProvider:        provider,
 	}
@@ -268,6 +516,52 @@ func TestPodsWithPrioritiesFindUnneededNodes(t *testing.T) {
 	assert.Equal(t, 4, len(sd.nodeUtilizationMap))
 }
 
+func TestOrderCandidatesByPriorityScore(t *testing.T) {
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	dsOwnerRef := GenerateOwnerReferences("ds", "DaemonSet", "extensions/v1beta1", "")
+	var critical int32 = 1000
+	var batch int32 = -10
+	var agent int32 = 2000
+
+	n1 := BuildTestNode("n1", 1000, 10)
+	n2 := BuildTestNode("n2", 1000, 10)
+	n3 := BuildTestNode("n3", 1000, 10)
+	n4 := BuildTestNode("n4", 1000, 10)
+
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.Priority = &critical
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.Priority = &batch
+	// DaemonSet pods stay on their nodes, so they don't count.
+	p3 := BuildTestPod("p3", 100, 0)
+	p3.OwnerReferences = dsOwnerRef
+	p3.Spec.Priority = &agent
+	p4 := BuildTestPod("p4", 100, 0)
+	p4.OwnerReferences = ownerRef
+
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"n1": schedulercache.NewNodeInfo(p1),
+		"n2": schedulercache.NewNodeInfo(p2, p3),
+		"n3": schedulercache.NewNodeInfo(p4),
+		"n4": schedulercache.NewNodeInfo(p1),
+	}
+	nodes := []*apiv1.Node{n1, n2, n3, n4}
+
+	assert.Nil(t, nodePriorityScores(nodes, nodeInfos, 0))
+	scores := nodePriorityScores(nodes, nodeInfos, 0.5)
+	assert.Equal(t, map[string]float64{"n1": 500, "n2": -5, "n3": 0, "n4": 500}, scores)
+
+	// n1, n2 and n4 are equally utilized, n3 is less utilized.
+	utilization := map[string]float64{"n1": 0.4, "n2": 0.401, "n3": 0.3, "n4": 0.4}
+	assert.Equal(t, []*apiv1.Node{n2, n1, n3, n4}, orderCandidatesByPriorityScore(nodes, utilization, scores))
+	assert.Equal(t, nodes, orderCandidatesByPriorityScore(nodes, utilization, nil))
+	// Different utilization levels keep their order, even with higher scores.
+	utilization = map[string]float64{"n1": 0.1, "n2": 0.2, "n3": 0.3, "n4": 0.4}
+	assert.Equal(t, nodes, orderCandidatesByPriorityScore(nodes, utilization, scores))
+}
+
 func TestFindUnneededMaxCandidates(t *testing.T) {
 	provider := testprovider.NewTestCloudProvider(nil, nil)
 	provider.AddNodeGroup("ng1", 1, 100, 2)
@@ -307,6 +601,7 @@ func TestFindUnneededMaxCandidates(t *testing.T) {
 		},
 		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
 		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
 		LogRecorder:          fakeLogRecorder,
 		CloudProvider:        provider,
 	}
@@ -380,6 +675,7 @@ func TestFindUnneededEmptyNodes(t *testing.T) {
 		},
 		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
 		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
 		LogRecorder:          fakeLogRecorder,
 		CloudProvider:        provider,
 	}
@@ -431,6 +727,7 @@ func TestFindUnneededNodePool(t *testing.T) {
 		},
 		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
 		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		Recorder:             fakeRecorder,
 		LogRecorder:          fakeLogRecorder,
 		CloudProvider:        provider,
 	}
@@ -535,10 +832,14 @@ func TestDeleteNode(t *testing.T) {
 			fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
 				return true, n1, nil
 			})
-			fakeClient.Fake.AddReactor("update", "nodes",
+			fakeClient.Fake.AddReactor("patch", "nodes",
 				func(action core.Action) (bool, runtime.Object, error) {
-					update := action.(core.UpdateAction)
-					obj := update.GetObject().(*apiv1.Node)
+					patch := action.(core.PatchAction)
+					obj, err := ApplyJSONPatchToNode(n1, patch.GetPatch())
+					if err != nil {
+						return true, nil, err
+					}
+					n1 = obj
 					taints := make([]string, 0, len(obj.Spec.Taints))
 					for _, taint := range obj.Spec.Taints {
 						taints = append(taints, taint.Key)
@@ -625,7 +926,7 @@ func TestDrainNode(t *testing.T) {
 		deletedPods <- eviction.Name
 		return true, nil, nil
 	})
-	err := drainNode(n1, []*apiv1.Pod{p1, p2}, fakeClient, kube_util.CreateEventRecorder(fakeClient), 20, 5*time.Second, 0*time.Second)
+	err := drainNode(n1, []*apiv1.Pod{p1, p2}, fakeClient, kube_util.CreateEventRecorder(fakeClient), 20, 0, 5*time.Second, 0*time.Second, false)
 	assert.NoError(t, err)
 	deleted := make([]string, 0)
 	deleted = append(deleted, getStringFromChan(deletedPods))
@@ -672,7 +973,7 @@ func TestDrainNodeWithRetries(t *testing.T) {
 			return true, nil, fmt.Errorf("Too many concurrent evictions")
 		}
 	})
-	err := drainNode(n1, []*apiv1.Pod{p1, p2, p3}, fakeClient, kube_util.CreateEventRecorder(fakeClient), 20, 5*time.Second, 0*time.Second)
+	err := drainNode(n1, []*apiv1.Pod{p1, p2, p3}, fakeClient, kube_util.CreateEventRecorder(fakeClient), 20, 0, 5*time.Second, 0*time.Second, false)
 	assert.NoError(t, err)
 	deleted := make([]string, 0)
 	deleted = append(deleted, getStringFromChan(deletedPods))
@@ -684,6 +985,241 @@ func TestDrainNodeWithRetries(t *testing.T) {
 	assert.Equal(t, p3.Name, deleted[2])
 }
 
+func buildMixedGracePeriodDrainTest(podGoneAfter map[string]time.Duration) (*fake.Clientset, []*apiv1.Pod, chan string) {
+	evictions := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+
+	gracePeriod := int64(10)
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	// A long preStop hook, allowed by the annotation.
+	longGracePeriod := int64(600)
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Spec.TerminationGracePeriodSeconds = &longGracePeriod
+	p2.Annotations = map[string]string{PodDrainTimeoutAnnotationKey: "15m"}
+	// The default grace period, capped.
+	p3 := BuildTestPod("p3", 100, 0)
+
+	evicted := time.Now()
+	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		name := action.(core.GetAction).GetName()
+		if goneAfter, found := podGoneAfter[name]; !found || time.Now().Sub(evicted) > goneAfter {
+			return true, nil, errors.NewNotFound(apiv1.Resource("pod"), name)
+		}
+		return true, BuildTestPod(name, 100, 0), nil
+	})
+	fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		eviction := action.(core.CreateAction).GetObject().(*policyv1.Eviction)
+		evictions <- fmt.Sprintf("%s:%d", eviction.Name, *eviction.DeleteOptions.GracePeriodSeconds)
+		return true, nil, nil
+	})
+	return fakeClient, []*apiv1.Pod{p1, p2, p3}, evictions
+}
+
+func TestDrainNodeMixedGracePeriods(t *testing.T) {
+	defer func(interval time.Duration) { podTerminationCheckInterval = interval }(podTerminationCheckInterval)
+	podTerminationCheckInterval = 10 * time.Millisecond
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	// The drain is done as soon as the pod with the longest grace period is gone.
+	fakeClient, pods, evictions := buildMixedGracePeriodDrainTest(map[string]time.Duration{"p2": 200 * time.Millisecond})
+	start := time.Now()
+	err := drainNode(n1, pods, fakeClient, kube_util.CreateEventRecorder(fakeClient), 20, 20*time.Minute, 5*time.Second, 0*time.Second, false)
+	assert.NoError(t, err)
+	assert.True(t, time.Now().Sub(start) < 5*time.Second)
+	evicted := []string{getStringFromChan(evictions), getStringFromChan(evictions), getStringFromChan(evictions)}
+	sort.Strings(evicted)
+	assert.Equal(t, []string{"p1:10", "p2:600", "p3:20"}, evicted)
+
+	// The pod would be given 10 minutes, but the node drain is limited.
+	fakeClient, pods, _ = buildMixedGracePeriodDrainTest(map[string]time.Duration{"p2": time.Hour})
+	start = time.Now()
+	err = drainNode(n1, pods, fakeClient, kube_util.CreateEventRecorder(fakeClient), 20, 300*time.Millisecond, 5*time.Second, 0*time.Second, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "pods remaining after timeout: [default/p2]")
+	}
+	elapsed := time.Now().Sub(start)
+	assert.True(t, elapsed >= 300*time.Millisecond && elapsed < 5*time.Second, "drain took %v", elapsed)
+}
+
+func TestDeleteNodeNodeGroupMaxGracefulTermination(t *testing.T) {
+	fileConfig, err := config.ParseFileConfig([]byte(`
+nodeGroups:
+- name: ng-service
+  maxGracefulTerminationSec: 60
+- name: ng-batch
+  maxGracefulTerminationSec: 3600
+`))
+	assert.NoError(t, err)
+
+	gracePeriod := int64(7200)
+	nodes := make(map[string]*apiv1.Node)
+	pods := make(map[string]*apiv1.Pod)
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error { return nil })
+	for _, nodeGroup := range []string{"ng-service", "ng-batch"} {
+		node := BuildTestNode("n-"+nodeGroup, 1000, 1000)
+		SetNodeReadyState(node, true, time.Time{})
+		provider.AddNodeGroup(nodeGroup, 1, 10, 1)
+		provider.AddNode(nodeGroup, node)
+		nodes[node.Name] = node
+		pod := BuildTestPod("p-"+nodeGroup, 100, 0)
+		pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+		pods[node.Name] = pod
+	}
+
+	evictions := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nodes[action.(core.GetAction).GetName()], nil
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		obj, err := ApplyJSONPatchToNode(nodes[patch.GetName()], patch.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		nodes[obj.Name] = obj
+		return true, obj, nil
+	})
+	fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		eviction := action.(core.CreateAction).GetObject().(*policyv1.Eviction)
+		evictions <- fmt.Sprintf("%s:%d", eviction.Name, *eviction.DeleteOptions.GracePeriodSeconds)
+		return true, nil, nil
+	})
+	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(apiv1.Resource("pod"), "whatever")
+	})
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			MaxGracefulTerminationSec: 600,
+			MaxNodeDrainTime:          20 * time.Minute,
+		},
+		ClientSet:                fakeClient,
+		Recorder:                 fakeRecorder,
+		LogRecorder:              fakeLogRecorder,
+		CloudProvider:            provider,
+		ClusterStateRegistry:     clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		NodeGroupConfigProcessor: &NodeGroupConfigProcessor{fileConfig: fileConfig},
+	}
+
+	maxGracefulTerminationSec, maxNodeDrainTime := nodeDrainLimits(context, nodes["n-ng-service"])
+	assert.Equal(t, 60, maxGracefulTerminationSec)
+	assert.Equal(t, 20*time.Minute, maxNodeDrainTime)
+	// The drain time is extended by how much longer than the global one the graceful termination is.
+	maxGracefulTerminationSec, maxNodeDrainTime = nodeDrainLimits(context, nodes["n-ng-batch"])
+	assert.Equal(t, 3600, maxGracefulTerminationSec)
+	assert.Equal(t, 70*time.Minute, maxNodeDrainTime)
+
+	assert.NoError(t, deleteNode(context, nodes["n-ng-service"], []*apiv1.Pod{pods["n-ng-service"]}))
+	assert.Equal(t, "p-ng-service:60", getStringFromChan(evictions))
+	assert.NoError(t, deleteNode(context, nodes["n-ng-batch"], []*apiv1.Pod{pods["n-ng-batch"]}))
+	assert.Equal(t, "p-ng-batch:3600", getStringFromChan(evictions))
+
+	// Nodes outside of node groups use the global setting.
+	other := BuildTestNode("other", 1000, 1000)
+	maxGracefulTerminationSec, maxNodeDrainTime = nodeDrainLimits(context, other)
+	assert.Equal(t, 600, maxGracefulTerminationSec)
+	assert.Equal(t, 20*time.Minute, maxNodeDrainTime)
+}
+
+func TestEvictPodDeleteFallback(t *testing.T) {
+	webhookDenial := errors.NewForbidden(apiv1.Resource("pods"), "p", fmt.Errorf(`admission webhook "deny.example.com" denied the request: no evictions`))
+	pdbDenial := errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+	otherError := errors.NewInternalError(fmt.Errorf("etcd unavailable"))
+
+	for _, tc := range []struct {
+		name             string
+		evictionErr      error
+		allowForceDelete bool
+		protected        bool
+		deleteFallback   bool
+		expectDelete     bool
+		expectError      bool
+	}{
+		{name: "webhook denial, annotated", evictionErr: webhookDenial, allowForceDelete: true, deleteFallback: true, expectDelete: true},
+		{name: "webhook denial, fallback disabled", evictionErr: webhookDenial, allowForceDelete: true, expectError: true},
+		{name: "webhook denial, not annotated", evictionErr: webhookDenial, deleteFallback: true, expectError: true},
+		{name: "webhook denial, covered by PDB", evictionErr: webhookDenial, allowForceDelete: true, protected: true, deleteFallback: true, expectError: true},
+		{name: "PDB denial", evictionErr: pdbDenial, allowForceDelete: true, deleteFallback: true, expectError: true},
+		{name: "other error", evictionErr: otherError, allowForceDelete: true, deleteFallback: true, expectError: true},
+		{name: "evicted", allowForceDelete: true, deleteFallback: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := BuildTestPod("p", 100, 0)
+			pod.Labels = map[string]string{"app": "batch"}
+			if tc.allowForceDelete {
+				pod.Annotations = map[string]string{AllowForceDeleteAnnotationKey: "true"}
+			}
+			pdbLabels := map[string]string{"app": "other"}
+			if tc.protected {
+				pdbLabels = pod.Labels
+			}
+			evictions, deletions := 0, 0
+			fakeClient := &fake.Clientset{}
+			fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+				evictions++
+				return true, nil, tc.evictionErr
+			})
+			fakeClient.Fake.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
+				deletions++
+				return true, nil, nil
+			})
+			fakeClient.Fake.AddReactor("list", "poddisruptionbudgets", func(action core.Action) (bool, runtime.Object, error) {
+				return true, &policyv1.PodDisruptionBudgetList{Items: []policyv1.PodDisruptionBudget{{
+					ObjectMeta: metav1.ObjectMeta{Name: "pdb", Namespace: pod.Namespace},
+					Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: pdbLabels}},
+				}}}, nil
+			})
+			recorder := kube_record.NewFakeRecorder(10)
+
+			err := evictPod(pod, fakeClient, recorder, 60, time.Now(), 0, tc.deleteFallback)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, 1, evictions)
+			if tc.expectDelete {
+				assert.Equal(t, 1, deletions)
+				assert.Contains(t, drainEvents(recorder), "ScaleDownPodDeleted")
+			} else {
+				assert.Equal(t, 0, deletions)
+				assert.NotContains(t, drainEvents(recorder), "ScaleDownPodDeleted")
+			}
+		})
+	}
+}
+
+func drainEvents(recorder *kube_record.FakeRecorder) string {
+	events := ""
+	for {
+		select {
+		case event := <-recorder.Events:
+			events += event + "\n"
+		default:
+			return events
+		}
+	}
+}
+
+func TestPodGracePeriodSec(t *testing.T) {
+	gracePeriod := int64(600)
+	pod := BuildTestPod("p1", 100, 0)
+	assert.Equal(t, int64(apiv1.DefaultTerminationGracePeriodSeconds), podGracePeriodSec(pod, 60))
+	assert.Equal(t, int64(20), podGracePeriodSec(pod, 20))
+	pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+	assert.Equal(t, int64(60), podGracePeriodSec(pod, 60))
+	pod.Annotations = map[string]string{PodDrainTimeoutAnnotationKey: "5m"}
+	assert.Equal(t, int64(300), podGracePeriodSec(pod, 60))
+	pod.Annotations = map[string]string{PodDrainTimeoutAnnotationKey: "1h"}
+	assert.Equal(t, int64(600), podGracePeriodSec(pod, 60))
+	pod.Annotations = map[string]string{PodDrainTimeoutAnnotationKey: "forever"}
+	assert.Equal(t, int64(60), podGracePeriodSec(pod, 60))
+}
+
 func TestScaleDown(t *testing.T) {
 	deletedPods := make(chan string, 10)
 	updatedNodes := make(chan string, 10)
@@ -735,11 +1271,10 @@ func TestScaleDown(t *testing.T) {
 		deletedPods <- deleteAction.GetName()
 		return true, nil, nil
 	})
-	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
-		update := action.(core.UpdateAction)
-		obj := update.GetObject().(*apiv1.Node)
-		updatedNodes <- obj.Name
-		return true, obj, nil
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		updatedNodes <- patch.GetName()
+		return true, nil, nil
 	})
 
 	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
@@ -778,6 +1313,90 @@ func TestScaleDown(t *testing.T) {
 	assert.Equal(t, n1.Name, getStringFromChan(updatedNodes))
 }
 
+func TestScaleDownPriorityScore(t *testing.T) {
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	var critical int32 = 1000
+
+	// n1 and n2 are equally utilized, n1 runs a critical pod.
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Time{})
+	SetNodeReadyState(n2, true, time.Time{})
+	SetNodeReadyState(n3, true, time.Time{})
+	p1 := BuildTestPod("p1", 400, 0)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.NodeName = "n1"
+	p1.Spec.Priority = &critical
+	p2 := BuildTestPod("p2", 400, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+	p3 := BuildTestPod("p3", 600, 0)
+	p3.OwnerReferences = ownerRef
+	p3.Spec.NodeName = "n3"
+	nodes := []*apiv1.Node{n1, n2, n3}
+	pods := []*apiv1.Pod{p1, p2, p3}
+
+	for _, tc := range []struct {
+		weight  float64
+		removed string
+	}{
+		{weight: 0, removed: "n1"},
+		{weight: 1, removed: "n2"},
+	} {
+		deletedNodes := make(chan string, 10)
+		fakeClient := &fake.Clientset{}
+		fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewNotFound(apiv1.Resource("pod"), "whatever")
+		})
+		fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+			getAction := action.(core.GetAction)
+			for _, node := range nodes {
+				if node.Name == getAction.GetName() {
+					return true, node, nil
+				}
+			}
+			return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+		})
+		fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+			return true, nil, nil
+		})
+
+		provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+			deletedNodes <- node
+			return nil
+		})
+		provider.AddNodeGroup("ng1", 1, 10, 3)
+		for _, node := range nodes {
+			provider.AddNode("ng1", node)
+		}
+
+		fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+		fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+		context := &AutoscalingContext{
+			AutoscalingOptions: AutoscalingOptions{
+				ScaleDownUtilizationThreshold:    0.5,
+				ScaleDownUnneededTime:            time.Minute,
+				MaxGracefulTerminationSec:        60,
+				ScaleDownCandidatePriorityWeight: tc.weight,
+			},
+			PredicateChecker:     simulator.NewTestPredicateChecker(),
+			CloudProvider:        provider,
+			ClientSet:            fakeClient,
+			Recorder:             fakeRecorder,
+			ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+			LogRecorder:          fakeLogRecorder,
+		}
+		scaleDown := NewScaleDown(context)
+		scaleDown.UpdateUnneededNodes(nodes, nodes, pods, time.Now().Add(-5*time.Minute), nil)
+		result, err := scaleDown.TryToScaleDown(nodes, pods, nil, time.Now())
+		waitForDeleteToFinish(t, scaleDown)
+		assert.NoError(t, err)
+		assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+		assert.Equal(t, tc.removed, getStringFromChan(deletedNodes), "weight %v", tc.weight)
+	}
+}
+
 func waitForDeleteToFinish(t *testing.T, sd *ScaleDown) {
 	for start := time.Now(); time.Since(start) < 20*time.Second; time.Sleep(100 * time.Millisecond) {
 		if !sd.nodeDeleteStatus.IsDeleteInProgress() {
@@ -887,6 +1506,126 @@ func TestScaleDownEmptyMinGroupSizeLimitHit(t *testing.T) {
 	}
 	simpleScaleDownEmpty(t, config)
 }
+func TestScaleDownEmptyWastedNodes(t *testing.T) {
+	options := defaultScaleDownOptions
+	options.ScaleDownUnneededTime = time.Hour
+	nodes := []nodeConfig{
+		{"n1_1", 1000, 1000, true, "ng1"},
+		{"n1_2", 1000, 1000, true, "ng1"},
+		{"n1_3", 1000, 1000, true, "ng1"},
+		{"n2_1", 1000, 1000, true, "ng2"},
+		{"n2_2", 1000, 1000, true, "ng2"},
+	}
+	failed := clusterstate.FailedScaleUp{
+		Request:     &clusterstate.ScaleUpRequest{NodeGroupName: "ng1", Increase: 4, Time: time.Now().Add(-time.Hour)},
+		FailureTime: time.Now().Add(-time.Minute),
+	}
+
+	// Wasted nodes don't wait for scale down unneeded time.
+	simpleScaleDownEmpty(t, &scaleTestConfig{
+		nodes:              nodes,
+		options:            options,
+		expectedScaleDowns: []string{"n1_1", "n1_2"},
+		wastedNodes: map[string]clusterstate.WastedNode{
+			"n1_1": {ScaleUp: failed},
+			"n1_2": {ScaleUp: failed},
+		},
+	})
+
+	// Pods of the scale-up got scheduled, so the nodes are not wasted.
+	simpleScaleDownEmpty(t, &scaleTestConfig{
+		nodes:              nodes,
+		options:            options,
+		expectedScaleDowns: []string{},
+	})
+}
+
+func TestScaleDownEmptyWithIgnoredPods(t *testing.T) {
+	defer flag.Set("ignore-pods-for-empty-nodes", "")
+	assert.NoError(t, flag.Set("ignore-pods-for-empty-nodes", "ns=observability,label=app=node-agent"))
+
+	nothingReturned := "Nothing returned"
+	deletedPods := make(chan string, 10)
+	deletedNodes := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, time.Time{})
+	nodes := []*apiv1.Node{n1, n2}
+
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	agent1 := BuildTestPod("agent1", 100, 0)
+	agent1.Labels = map[string]string{"app": "node-agent"}
+	agent1.OwnerReferences = ownerRef
+	agent1.Spec.NodeName = "n1"
+	logs1 := BuildTestPod("logs1", 100, 0)
+	logs1.Namespace = "observability"
+	logs1.OwnerReferences = ownerRef
+	logs1.Spec.NodeName = "n1"
+	agent2 := BuildTestPod("agent2", 100, 0)
+	agent2.Labels = map[string]string{"app": "node-agent"}
+	agent2.OwnerReferences = ownerRef
+	agent2.Spec.NodeName = "n2"
+	// Not replicated, keeps n2 from being removed.
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Spec.NodeName = "n2"
+	pods := []*apiv1.Pod{agent1, logs1, agent2, p2}
+
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(apiv1.Resource("pod"), "whatever")
+	})
+	fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		eviction := action.(core.CreateAction).GetObject().(*policyv1.Eviction)
+		deletedPods <- eviction.Namespace + "/" + eviction.Name
+		return true, nil, nil
+	})
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, n1, nil
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deletedNodes <- node
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions:   defaultScaleDownOptions,
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		LogRecorder:          fakeLogRecorder,
+	}
+	scaleDown := NewScaleDown(context)
+	scaleDown.UpdateUnneededNodes(nodes, nodes, pods, time.Now().Add(-5*time.Minute), nil)
+	result, err := scaleDown.TryToScaleDown(nodes, pods, nil, time.Now())
+	waitForDeleteToFinish(t, scaleDown)
+
+	// n1 is removed as empty, after its ignored pods are evicted.
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, "n1", getStringFromChan(deletedNodes))
+	assert.Equal(t, nothingReturned, getStringFromChanImmediately(deletedNodes))
+	evicted := []string{getStringFromChan(deletedPods), getStringFromChan(deletedPods)}
+	sort.Strings(evicted)
+	assert.Equal(t, []string{"default/agent1", "observability/logs1"}, evicted)
+	assert.Equal(t, nothingReturned, getStringFromChanImmediately(deletedPods))
+}
+
 func simpleScaleDownEmpty(t *testing.T, config *scaleTestConfig) {
 	updatedNodes := make(chan string, 10)
 	deletedNodes := make(chan string, 10)
@@ -917,11 +1656,10 @@ func simpleScaleDownEmpty(t *testing.T, config *scaleTestConfig) {
 		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
 
 	})
-	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
-		update := action.(core.UpdateAction)
-		obj := update.GetObject().(*apiv1.Node)
-		updatedNodes <- obj.Name
-		return true, obj, nil
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		updatedNodes <- patch.GetName()
+		return true, nil, nil
 	})
 
 	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
@@ -955,6 +1693,8 @@ func simpleScaleDownEmpty(t *testing.T, config *scaleTestConfig) {
 		LogRecorder:          fakeLogRecorder,
 	}
 	scaleDown := NewScaleDown(context)
+	scaleDown.cooldown = config.scaleDownCooldown
+	scaleDown.wastedNodes = config.wastedNodes
 	scaleDown.UpdateUnneededNodes(nodes,
 		nodes, []*apiv1.Pod{}, time.Now().Add(-5*time.Minute), nil)
 	result, err := scaleDown.TryToScaleDown(nodes, []*apiv1.Pod{}, nil, time.Now())
@@ -986,6 +1726,52 @@ func simpleScaleDownEmpty(t *testing.T, config *scaleTestConfig) {
 	assertEqualSet(t, config.expectedScaleDowns, deleted)
 }
 
+func TestScaleDownEmptyDryRun(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, time.Time{})
+	nodes := []*apiv1.Node{n1, n2}
+
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		t.Fatalf("Unexpected node update in dry-run mode")
+		return true, nil, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		t.Fatalf("Unexpected deletion of %s in dry-run mode", node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	assert.NotNil(t, provider)
+
+	options := defaultScaleDownOptions
+	options.DryRun = true
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions:   options,
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		LogRecorder:          fakeLogRecorder,
+	}
+	scaleDown := NewScaleDown(context)
+	scaleDown.UpdateUnneededNodes(nodes, nodes, []*apiv1.Pod{}, time.Now().Add(-5*time.Minute), nil)
+	result, err := scaleDown.TryToScaleDown(nodes, []*apiv1.Pod{}, nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+	assert.False(t, scaleDown.nodeDeleteStatus.IsDeleteInProgress())
+}
+
 func TestNoScaleDownUnready(t *testing.T) {
 	fakeClient := &fake.Clientset{}
 	n1 := BuildTestNode("n1", 1000, 1000)
@@ -1115,7 +1901,7 @@ func TestScaleDownNoMove(t *testing.T) {
 		t.FailNow()
 		return false, nil, nil
 	})
-	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
 		t.FailNow()
 		return false, nil, nil
 	})
@@ -1188,16 +1974,25 @@ func TestCleanToBeDeleted(t *testing.T) {
 		}
 		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
 	})
-	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
-		update := action.(core.UpdateAction)
-		obj := update.GetObject().(*apiv1.Node)
-		switch obj.Name {
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		switch patch.GetName() {
 		case n1.Name:
+			obj, err := ApplyJSONPatchToNode(n1, patch.GetPatch())
+			if err != nil {
+				return true, nil, err
+			}
 			n1 = obj
+			return true, obj, nil
 		case n2.Name:
+			obj, err := ApplyJSONPatchToNode(n2, patch.GetPatch())
+			if err != nil {
+				return true, nil, err
+			}
 			n2 = obj
+			return true, obj, nil
 		}
-		return true, obj, nil
+		return true, nil, fmt.Errorf("Wrong node: %v", patch.GetName())
 	})
 	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
 
@@ -1207,6 +2002,53 @@ func TestCleanToBeDeleted(t *testing.T) {
 	assert.Equal(t, 0, len(n2.Spec.Taints))
 }
 
+func TestCleanUpStaleToBeDeletedTaints(t *testing.T) {
+	now := time.Now()
+	// Taints left by a previous run of CA, e.g. one that crashed in the middle of a scale-down.
+	stale := BuildTestNode("stale", 1000, 10)
+	stale.Spec.Taints = []apiv1.Taint{{Key: deletetaint.ToBeDeletedTaint, Value: strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)}}
+	fresh := BuildTestNode("fresh", 1000, 10)
+	fresh.Spec.Taints = []apiv1.Taint{{Key: deletetaint.ToBeDeletedTaint, Value: strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}}
+	nodes := map[string]*apiv1.Node{stale.Name: stale, fresh.Name: fresh}
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		getAction := action.(core.GetAction)
+		if node, found := nodes[getAction.GetName()]; found {
+			return true, node, nil
+		}
+		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		obj, err := ApplyJSONPatchToNode(nodes[patch.GetName()], patch.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		nodes[patch.GetName()] = obj
+		return true, obj, nil
+	})
+
+	options := defaultScaleDownOptions
+	options.ToBeDeletedTaintTTL = 30 * time.Minute
+	context := &AutoscalingContext{
+		AutoscalingOptions: options,
+		ClientSet:          fakeClient,
+		Recorder:           kube_util.CreateEventRecorder(fakeClient),
+	}
+	sd := NewScaleDown(context)
+
+	// Nothing is removed while a node is being deleted.
+	sd.nodeDeleteStatus.SetDeleteInProgress(true)
+	sd.CleanUpStaleToBeDeletedTaints([]*apiv1.Node{stale, fresh}, now)
+	assert.True(t, deletetaint.HasToBeDeletedTaint(nodes[stale.Name]))
+
+	sd.nodeDeleteStatus.SetDeleteInProgress(false)
+	sd.CleanUpStaleToBeDeletedTaints([]*apiv1.Node{stale, fresh}, now)
+	assert.False(t, deletetaint.HasToBeDeletedTaint(nodes[stale.Name]))
+	assert.True(t, deletetaint.HasToBeDeletedTaint(nodes[fresh.Name]))
+}
+
 func TestCleanUpNodeAutoprovisionedGroups(t *testing.T) {
 	n1 := BuildTestNode("n1", 1000, 1000)
 
@@ -1228,7 +2070,45 @@ func TestCleanUpNodeAutoprovisionedGroups(t *testing.T) {
 	fakeClient := &fake.Clientset{}
 	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
 	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
-	assert.NoError(t, cleanUpNodeAutoprovisionedGroups(provider, fakeLogRecorder))
+	assert.NoError(t, cleanUpNodeAutoprovisionedGroups(provider, fakeLogRecorder, make(map[string]time.Time), 0, time.Now()))
+}
+
+func TestCleanUpNodeAutoprovisionedGroupsWithTTL(t *testing.T) {
+	deleted := make([]string, 0)
+	provider := testprovider.NewTestAutoprovisioningCloudProvider(
+		nil, nil,
+		nil, func(id string) error {
+			deleted = append(deleted, id)
+			return nil
+		},
+		nil, nil)
+	provider.AddAutoprovisionedNodeGroup("ng1", 0, 10, 0, "mt1")
+	provider.AddAutoprovisionedNodeGroup("ng2", 0, 10, 0, "mt1")
+
+	fakeClient := &fake.Clientset{}
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	emptySince := make(map[string]time.Time)
+	now := time.Now()
+
+	// Empty node groups are kept until the TTL passes.
+	assert.NoError(t, cleanUpNodeAutoprovisionedGroups(provider, fakeLogRecorder, emptySince, 10*time.Minute, now))
+	assert.Empty(t, deleted)
+	assert.Equal(t, map[string]time.Time{"ng1": now, "ng2": now}, emptySince)
+
+	// A node group that was scaled up in the meantime is forgotten.
+	for _, nodeGroup := range provider.NodeGroups() {
+		if nodeGroup.Id() == "ng2" {
+			nodeGroup.(*testprovider.TestNodeGroup).SetTargetSize(1)
+		}
+	}
+	assert.NoError(t, cleanUpNodeAutoprovisionedGroups(provider, fakeLogRecorder, emptySince, 10*time.Minute, now.Add(5*time.Minute)))
+	assert.Empty(t, deleted)
+	assert.Equal(t, map[string]time.Time{"ng1": now}, emptySince)
+
+	assert.NoError(t, cleanUpNodeAutoprovisionedGroups(provider, fakeLogRecorder, emptySince, 10*time.Minute, now.Add(11*time.Minute)))
+	assert.Equal(t, []string{"ng1"}, deleted)
+	assert.Empty(t, emptySince)
 }
 
 func TestCalculateCoresAndMemoryTotal(t *testing.T) {
@@ -1304,3 +2184,285 @@ func TestFilterOutMasters(t *testing.T) {
 	}
 	assertEqualSet(t, []string{"n1", "n2", "n4", "n5", "n6"}, withoutMastersNames)
 }
+
+func TestEnforceNodeGroupMaxSize(t *testing.T) {
+	deletedPods := make(chan string, 10)
+	deletedNodes := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "job",
+			Namespace: "default",
+			SelfLink:  "/apivs/extensions/v1beta1/namespaces/default/jobs/job",
+		},
+	}
+	// Max size of ng1 was lowered from 10 to 6. n0-n3 are busy, n4-n6 run a single small pod
+	// each and n7-n9 are empty.
+	nodes := make([]*apiv1.Node, 0)
+	pods := make([]*apiv1.Pod, 0)
+	nodesByName := make(map[string]*apiv1.Node)
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deletedNodes <- node
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 6, 10)
+	for i := 0; i < 10; i++ {
+		node := BuildTestNode(fmt.Sprintf("n%d", i), 1000, 1000)
+		SetNodeReadyState(node, true, time.Time{})
+		provider.AddNode("ng1", node)
+		nodes = append(nodes, node)
+		nodesByName[node.Name] = node
+
+		var cpu int64
+		switch {
+		case i < 4:
+			cpu = 600
+		case i < 7:
+			cpu = int64(i-3) * 100
+		default:
+			continue
+		}
+		pod := BuildTestPod(fmt.Sprintf("p%d", i), cpu, 0)
+		pod.OwnerReferences = GenerateOwnerReferences(job.Name, "Job", "extensions/v1beta1", "")
+		pod.Labels = map[string]string{"app": fmt.Sprintf("app%d", i)}
+		pod.Spec.NodeName = node.Name
+		pods = append(pods, pod)
+	}
+	// n0 can't be removed, scale down is disabled on it.
+	nodes[0].Annotations = map[string]string{ScaleDownDisabledKey: "true"}
+
+	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(apiv1.Resource("pod"), "whatever")
+	})
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		getAction := action.(core.GetAction)
+		if node, found := nodesByName[getAction.GetName()]; found {
+			return true, node, nil
+		}
+		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+	})
+	fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		createAction := action.(core.CreateAction)
+		if eviction, ok := createAction.GetObject().(*policyv1.Eviction); ok {
+			deletedPods <- eviction.Name
+		}
+		return true, nil, nil
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold: 0.5,
+			ScaleDownUnneededTime:         time.Hour,
+			MaxGracefulTerminationSec:     60,
+			MaxEmptyBulkDelete:            10,
+			EnforceNodeGroupMaxSize:       true,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		LogRecorder:          fakeLogRecorder,
+	}
+	scaleDown := NewScaleDown(context)
+
+	candidates, excess := scaleDown.findNodesAboveMaxSize(nodes, pods)
+	assert.Equal(t, map[string]int{"ng1": 4}, excess)
+	candidateNames := make([]string, 0)
+	for _, node := range candidates {
+		candidateNames = append(candidateNames, node.Name)
+	}
+	assert.Equal(t, []string{"n7", "n8", "n9", "n4", "n5", "n6", "n1", "n2", "n3"}, candidateNames)
+
+	// Empty nodes go first, although they were never unneeded.
+	result, err := scaleDown.EnforceNodeGroupMaxSize(nodes, pods, nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	deleted := []string{getStringFromChan(deletedNodes), getStringFromChan(deletedNodes), getStringFromChan(deletedNodes)}
+	sort.Strings(deleted)
+	assert.Equal(t, []string{"n7", "n8", "n9"}, deleted)
+	nodes = nodes[:7]
+
+	// The pod from n4 is protected by a PodDisruptionBudget, so n5 is drained instead.
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "pdb", Namespace: "default"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app4"}},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{PodDisruptionsAllowed: 0},
+	}
+	result, err = scaleDown.EnforceNodeGroupMaxSize(nodes, pods, []*policyv1.PodDisruptionBudget{pdb}, time.Now())
+	waitForDeleteToFinish(t, scaleDown)
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+	assert.Equal(t, "p5", getStringFromChan(deletedPods))
+	assert.Equal(t, "n5", getStringFromChan(deletedNodes))
+
+	// ng1 is at its max size now.
+	result, err = scaleDown.EnforceNodeGroupMaxSize(append(nodes[:5], nodes[6]), pods, nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoUnneeded, result)
+}
+
+func TestEnforceNodeGroupMaxSizeRestartBudget(t *testing.T) {
+	now := time.Now()
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Time{})
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, time.Time{})
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 1, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	// The replica set already used up its daily restart budget.
+	pods := buildBudgetedPods("rs", "1", 3)
+	pods[0].Spec.NodeName = "n1"
+	pods[1].Spec.NodeName = "n2"
+	evicted := pods[2]
+	pods = pods[:2]
+
+	fakeClient := fake.NewSimpleClientset(&extensionsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "rs", Namespace: "default"},
+	})
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold: 0.5,
+			MaxGracefulTerminationSec:     60,
+			MaxEmptyBulkDelete:            10,
+			EnforceNodeGroupMaxSize:       true,
+			ConfigNamespace:               "kube-system",
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		LogRecorder:          fakeLogRecorder,
+	}
+	scaleDown := NewScaleDown(context)
+	scaleDown.restartBudget.recordEvictions([]*apiv1.Pod{evicted}, now)
+
+	result, err := scaleDown.EnforceNodeGroupMaxSize([]*apiv1.Node{n1, n2}, pods, nil, now)
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+}
+
+type testNodePricingModel struct {
+	nodePrice map[string]float64
+}
+
+func (tpm *testNodePricingModel) NodePrice(node *apiv1.Node, startTime time.Time, endTime time.Time) (float64, error) {
+	if price, found := tpm.nodePrice[node.Name]; found {
+		return price * endTime.Sub(startTime).Hours(), nil
+	}
+	return 0.0, fmt.Errorf("price for node %v not found", node.Name)
+}
+
+func (tpm *testNodePricingModel) PodPrice(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (float64, error) {
+	return 0.0, fmt.Errorf("price for pod %v not found", pod.Name)
+}
+
+func TestBlockedNodesSummary(t *testing.T) {
+	nodes := []*apiv1.Node{
+		BuildTestNode("n1", 1000, 1000),
+		BuildTestNode("n2", 1000, 1000),
+		BuildTestNode("n3", 1000, 1000),
+		BuildTestNode("n4", 1000, 1000),
+		BuildTestNode("n5", 1000, 1000),
+	}
+	reasons := map[string]simulator.UnremovableReason{
+		"n1": simulator.UnremovableReason(drain.NotEnoughPdb),
+		"n2": simulator.UnremovableReason(drain.LocalStorageRequested),
+		"n3": simulator.UnremovableReason(drain.LocalStorageRequested),
+		"n4": simulator.NoPlaceToMovePods,
+		// Nodes no longer in the cluster are not counted.
+		"n6": simulator.UnremovableReason(drain.NotEnoughPdb),
+	}
+	expectedCounts := map[simulator.UnremovableReason]int{
+		simulator.UnremovableReason(drain.NotEnoughPdb):          1,
+		simulator.UnremovableReason(drain.LocalStorageRequested): 2,
+	}
+
+	counts, price := blockedNodesSummary(nodes, reasons, nil, time.Now())
+	assert.Equal(t, expectedCounts, counts)
+	assert.Equal(t, 0.0, price)
+
+	pricingModel := &testNodePricingModel{nodePrice: map[string]float64{"n1": 1.5, "n2": 0.25, "n4": 10, "n5": 10, "n6": 10}}
+	counts, price = blockedNodesSummary(nodes, reasons, pricingModel, time.Now())
+	assert.Equal(t, expectedCounts, counts)
+	// n3 has no price, n4 is not blocked by its pods and n5 is removable.
+	assert.InDelta(t, 1.75, price, 0.001)
+}
+
+// pricedCloudProvider is a test cloud provider with a pricing model.
+type pricedCloudProvider struct {
+	*testprovider.TestCloudProvider
+	pricingModel cloudprovider.PricingModel
+}
+
+func (p *pricedCloudProvider) Pricing() (cloudprovider.PricingModel, autoscaler_errors.AutoscalerError) {
+	return p.pricingModel, nil
+}
+
+func TestDeleteNodeFromCloudProviderEstimatedSavings(t *testing.T) {
+	now := time.Now()
+	n1 := BuildTestNode("n1", 4000, 16*1024*1024*1024)
+	n1.Labels = map[string]string{
+		kubeletapis.LabelInstanceType: "n2-standard-4",
+		"cloud.google.com/gke-spot":   "true",
+	}
+	n2 := BuildTestNode("n2", 4000, 16*1024*1024*1024)
+
+	deletedNodes := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deletedNodes <- node
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	pricingModel := &gce.GcePriceModel{}
+	pricedProvider := &pricedCloudProvider{TestCloudProvider: provider, pricingModel: pricingModel}
+
+	spotPrice, err := pricingModel.NodePrice(n1, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	regularPrice, err := pricingModel.NodePrice(n2, now, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, spotPrice > 0)
+	assert.True(t, spotPrice < regularPrice)
+
+	savings, known := estimateSavings(n1, pricedProvider, now)
+	assert.True(t, known)
+	assert.InDelta(t, spotPrice, savings, 1e-9)
+	_, known = estimateSavings(n1, provider, now)
+	assert.False(t, known)
+
+	fakeClient := &fake.Clientset{}
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	registry := clusterstate.NewClusterStateRegistry(pricedProvider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+
+	assert.NoError(t, deleteNodeFromCloudProvider(n1, pricedProvider, fakeRecorder, registry))
+	assert.Equal(t, "n1", getStringFromChan(deletedNodes))
+	assert.Equal(t, fmt.Sprintf("Normal ScaleDown node removed by cluster autoscaler, estimated savings: $%.4f/hour", spotPrice),
+		getStringFromChan(fakeRecorder.Events))
+	status := registry.GetStatus(now)
+	assert.Contains(t, status.NodeGroupStatuses[0].Conditions[2].Message, fmt.Sprintf("estimatedSavingsUsdPerHourTotal=%.4f", spotPrice))
+	assert.Contains(t, status.ClusterwideConditions[2].Message, fmt.Sprintf("estimatedSavingsUsdPerHourTotal=%.4f", spotPrice))
+
+	// Without a pricing model savings are skipped.
+	assert.NoError(t, deleteNodeFromCloudProvider(n2, provider, fakeRecorder, registry))
+	assert.Equal(t, "n2", getStringFromChan(deletedNodes))
+	assert.Equal(t, "Normal ScaleDown node removed by cluster autoscaler", getStringFromChan(fakeRecorder.Events))
+	status = registry.GetStatus(now)
+	assert.Contains(t, status.NodeGroupStatuses[0].Conditions[2].Message, fmt.Sprintf("estimatedSavingsUsdPerHourTotal=%.4f", spotPrice))
+}
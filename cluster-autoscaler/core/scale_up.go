@@ -24,6 +24,7 @@ import (
 	extensionsv1 "k8s.io/api/extensions/v1beta1"
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
 	"k8s.io/autoscaler/cluster-autoscaler/estimator"
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
 	"k8s.io/autoscaler/cluster-autoscaler/metrics"
@@ -38,9 +39,10 @@ import (
 
 // ScaleUp tries to scale the cluster up. Return true if it found a way to increase the size,
 // false if it didn't and error if an error occurred. Assumes that all nodes in the cluster are
-// ready and in sync with instance groups.
+// ready and in sync with instance groups. nodeInfos are node infos of node groups already built
+// in this loop, or nil to build them.
 func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes []*apiv1.Node,
-	daemonSets []*extensionsv1.DaemonSet) (bool, errors.AutoscalerError) {
+	daemonSets []*extensionsv1.DaemonSet, nodeInfos map[string]*schedulercache.NodeInfo) (bool, errors.AutoscalerError) {
 	// From now on we only care about unschedulable pods that were marked after the newest
 	// node became available for the scheduler.
 	if len(unschedulablePods) == 0 {
@@ -50,13 +52,29 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 
 	now := time.Now()
 
+	// Pods that would fit on a new node if not for cluster-wide limits.
+	blockedByLimits := make(map[*apiv1.Pod]scaleUpLimit)
+	defer func() {
+		reportPodsBlockedByLimits(context, blockedByLimits, now)
+	}()
+
 	for _, pod := range unschedulablePods {
 		glog.V(1).Infof("Pod %s/%s is unschedulable", pod.Namespace, pod.Name)
 	}
-	nodeInfos, err := GetNodeInfosForGroups(nodes, context.CloudProvider, context.ClientSet,
-		daemonSets, context.PredicateChecker)
-	if err != nil {
-		return false, err.AddPrefix("failed to build node infos for node groups: ")
+	var err errors.AutoscalerError
+	if nodeInfos == nil {
+		nodeInfos, err = GetNodeInfosForGroups(nodes, context.CloudProvider, context.ClientSet,
+			daemonSets, context.TemplateNodeInfoCache, context.NodeAllocatableTracker, context.IgnoredTaints)
+		if err != nil {
+			return false, err.AddPrefix("failed to build node infos for node groups: ")
+		}
+	} else {
+		// Scale-up adds node infos of autoprovisioned node groups, the caller's map is left alone.
+		nodeInfosCopy := make(map[string]*schedulercache.NodeInfo, len(nodeInfos))
+		for id, nodeInfo := range nodeInfos {
+			nodeInfosCopy[id] = nodeInfo
+		}
+		nodeInfos = nodeInfosCopy
 	}
 
 	nodeGroups := context.CloudProvider.NodeGroups()
@@ -85,14 +103,35 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 	}
 	glog.V(4).Infof("Upcoming %d nodes", len(upcomingNodes))
 
+	if context.ForceNodeGroupAnnotationEnabled {
+		scaledUp, remainingPods, typedErr := forceScaleUp(context, unschedulablePods, nodes, nodeInfos, upcomingNodes,
+			coresTotal, memoryTotal, resourceLimiter)
+		if typedErr != nil || scaledUp {
+			return scaledUp, typedErr
+		}
+		unschedulablePods = remainingPods
+	}
+
 	podsPassingPredicates := make(map[string][]*apiv1.Pod)
 	podsRemainUnschedulable := make(map[*apiv1.Pod]bool)
+	podsFittingLimitedGroups := make(map[*apiv1.Pod]scaleUpLimit)
+	networkBudgets := newNetworkBudgets(context, nodes, nodeGroups, context.ClusterStateRegistry.GetUpcomingNodes())
 	expansionOptions := make([]expander.Option, 0)
+	packingTraces := make(map[string][]estimator.PodPlacement)
+	estimates := newEstimationCache()
+	zoneAntiAffinityGroups := estimator.FindZoneAntiAffinityGroups(unschedulablePods)
+	volumeZones := newVolumeZoneChecker(context.ClientSet)
 
 	if context.AutoscalingOptions.NodeAutoprovisioningEnabled {
 		nodeGroups, nodeInfos = addAutoprovisionedCandidates(context, nodeGroups, nodeInfos, unschedulablePods)
 	}
 
+	unschedulablePods = context.OversizedPodsCache.filter(context, unschedulablePods, nodeGroups, nodeInfos, now)
+	if len(unschedulablePods) == 0 {
+		glog.V(1).Info("No unschedulable pods fit any node group")
+		return false, nil
+	}
+
 	for _, nodeGroup := range nodeGroups {
 		// Autoprovisioned node groups without nodes are created later so skip check for them.
 		if nodeGroup.Exist() && !context.ClusterStateRegistry.IsNodeGroupSafeToScaleUp(nodeGroup.Id(), now) {
@@ -121,14 +160,21 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 		if err != nil {
 			glog.Errorf("Failed to get node resources: %v", err)
 		}
-		if nodeCPU > (resourceLimiter.GetMax(cloudprovider.ResourceNameCores) - coresTotal) {
-			// skip this node group
+		// Node groups over the cores or memory limit are skipped after checking which pods would
+		// fit them, so that these pods can be told what blocks them.
+		var groupLimit *scaleUpLimit
+		if maxCores := resourceLimiter.GetMax(cloudprovider.ResourceNameCores); nodeCPU > (maxCores - coresTotal) {
 			glog.V(4).Infof("Skipping node group %s - not enough cores limit left", nodeGroup.Id())
-			continue
+			limit := maxCoresTotalLimit(coresTotal, maxCores)
+			groupLimit = &limit
+		} else if maxMemory := resourceLimiter.GetMax(cloudprovider.ResourceNameMemory); nodeMemory > (maxMemory - memoryTotal) {
+			glog.V(4).Infof("Skipping node group %s - not enough memory limit left", nodeGroup.Id())
+			limit := maxMemoryTotalLimit(memoryTotal, maxMemory)
+			groupLimit = &limit
 		}
-		if nodeMemory > (resourceLimiter.GetMax(cloudprovider.ResourceNameMemory) - memoryTotal) {
+		if maxNodes, limited := networkBudgets.maxNodes(nodeGroup); groupLimit == nil && limited && maxNodes == 0 {
 			// skip this node group
-			glog.V(4).Infof("Skipping node group %s - not enough memory limit left", nodeGroup.Id())
+			glog.V(1).Infof("Skipping node group %s - no addresses left for new nodes", nodeGroup.Id())
 			continue
 		}
 
@@ -137,34 +183,49 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 			Pods:      make([]*apiv1.Pod, 0),
 		}
 
+		prefilterVerified := false
 		for _, pod := range unschedulablePods {
-			err = context.PredicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnVerboseError)
+			err = volumeZones.check(pod, nodeInfo)
+			if err != nil {
+				glog.V(4).Infof("Scale-up volume zone check failed: %v", err)
+			} else {
+				err = checkScaleUpPrefilter(context, pod, nodeInfo, &prefilterVerified)
+			}
 			if err == nil {
+				err = context.PredicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnVerboseError)
+				if err != nil {
+					glog.V(2).Infof("Scale-up predicate failed: %v", err)
+					metrics.RegisterScaleUpPredicateRejection(metrics.FullPredicateCheck)
+				}
+			}
+			if err == nil && groupLimit != nil {
+				if _, found := podsFittingLimitedGroups[pod]; !found {
+					podsFittingLimitedGroups[pod] = *groupLimit
+				}
+			} else if err == nil {
 				option.Pods = append(option.Pods, pod)
 				podsRemainUnschedulable[pod] = false
 			} else {
-				glog.V(2).Infof("Scale-up predicate failed: %v", err)
 				if _, exists := podsRemainUnschedulable[pod]; !exists {
 					podsRemainUnschedulable[pod] = true
 				}
 			}
 		}
+		if groupLimit != nil {
+			continue
+		}
 		passingPods := make([]*apiv1.Pod, len(option.Pods))
 		copy(passingPods, option.Pods)
 		podsPassingPredicates[nodeGroup.Id()] = passingPods
+		// Nodes of a single node group share the zone, so they can help only one pod from each
+		// zone anti-affinity group. Other pods of the group are handled by planZoneAntiAffinityScaleUp.
+		option.Pods, _ = estimator.LimitZoneAntiAffinePods(option.Pods, zoneAntiAffinityGroups)
 
 		if len(option.Pods) > 0 {
-			if context.EstimatorName == estimator.BinpackingEstimatorName {
-				binpackingEstimator := estimator.NewBinpackingNodeEstimator(context.PredicateChecker)
-				option.NodeCount = binpackingEstimator.Estimate(option.Pods, nodeInfo, upcomingNodes)
-			} else if context.EstimatorName == estimator.BasicEstimatorName {
-				basicEstimator := estimator.NewBasicNodeEstimator()
-				for _, pod := range option.Pods {
-					basicEstimator.Add(pod)
-				}
-				option.NodeCount, option.Debug = basicEstimator.Estimate(nodeInfo.Node(), upcomingNodes)
-			} else {
-				glog.Fatalf("Unrecognized estimator: %s", context.EstimatorName)
+			var trace []estimator.PodPlacement
+			option.NodeCount, option.Debug, trace = estimates.estimate(context, option.Pods, nodeInfo, upcomingNodes)
+			if context.RecordPackingTrace {
+				packingTraces[nodeGroup.Id()] = trace
 			}
 			if option.NodeCount > 0 {
 				expansionOptions = append(expansionOptions, option)
@@ -176,19 +237,36 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 		}
 	}
 
+	for pod, limit := range podsFittingLimitedGroups {
+		if unschedulable, found := podsRemainUnschedulable[pod]; !found || unschedulable {
+			blockedByLimits[pod] = limit
+		}
+	}
+
+	expansionOptions = dropArchConflictingOptions(expansionOptions, nodeInfos)
+	expansionOptions = preferFailoverChainOptions(context, expansionOptions)
+	expansionOptions = preferNotStockedOutOptions(context, expansionOptions, nodeInfos, now)
+
 	if len(expansionOptions) == 0 {
 		glog.V(1).Info("No expansion options")
-		for pod, unschedulable := range podsRemainUnschedulable {
-			if unschedulable {
-				context.Recorder.Event(pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
-					"pod didn't trigger scale-up (it wouldn't fit if a new node is added)")
-			}
-		}
+		reportPodsNotTriggeringScaleUp(context, podsRemainUnschedulable, blockedByLimits, volumeZones)
 		return false, nil
 	}
 
 	// Pick some expansion option.
 	bestOption := context.ExpanderStrategy.BestOption(expansionOptions, nodeInfos)
+	if context.DecisionRecorder != nil || context.CrashReporter != nil {
+		decision := debug.NewScaleUpDecision(time.Now(), context.ExpanderName, unschedulablePods, expansionOptions, nodeInfos, bestOption)
+		if context.RecordPackingTrace {
+			decision.PackingTraces = packingTraces
+		}
+		if context.DecisionRecorder != nil {
+			if err := context.DecisionRecorder.RecordScaleUpDecision(decision); err != nil {
+				glog.Warningf("Failed to record scale-up decision: %v", err)
+			}
+		}
+		context.CrashReporter.RecordScaleUpDecision(decision)
+	}
 	if bestOption != nil && bestOption.NodeCount > 0 {
 		glog.V(1).Infof("Best option to resize: %s", bestOption.NodeGroup.Id())
 		if len(bestOption.Debug) > 0 {
@@ -202,13 +280,21 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 			glog.V(1).Infof("Capping size to max cluster total size (%d)", context.MaxNodesTotal)
 			newNodes = context.MaxNodesTotal - len(nodes)
 			if newNodes < 1 {
+				for _, option := range expansionOptions {
+					for _, pod := range option.Pods {
+						blockedByLimits[pod] = maxNodesTotalLimit(len(nodes), context.MaxNodesTotal)
+					}
+				}
 				return false, errors.NewAutoscalerError(
 					errors.TransientError,
 					"max node total count already reached")
 			}
 		}
 		if context.AutoscalingOptions.NodeAutoprovisioningEnabled {
-			if !bestOption.NodeGroup.Exist() {
+			if !bestOption.NodeGroup.Exist() && context.DryRun {
+				recordDryRunAction(context, metrics.DryRunCreateNodeGroup, bestOption.NodeGroup.Id(),
+					"would create node group %v", bestOption.NodeGroup.Id())
+			} else if !bestOption.NodeGroup.Exist() {
 				// Node group id may change when we create node group and we need to update
 				// our data structures
 				oldId := bestOption.NodeGroup.Id()
@@ -278,35 +364,417 @@ func ScaleUp(context *AutoscalingContext, unschedulablePods []*apiv1.Pod, nodes
 				glog.V(1).Infof("Splitting scale-up between %v similar node groups: {%v}", len(targetNodeGroups), buffer.String())
 			}
 		}
-		scaleUpInfos, typedErr := nodegroupset.BalanceScaleUpBetweenGroups(
-			targetNodeGroups, newNodes)
+		var splittingStrategy nodegroupset.SplittingStrategy = nodegroupset.EvenSplittingStrategy{}
+		if context.SplittingStrategy != nil {
+			splittingStrategy = context.SplittingStrategy
+		}
+		scaleUpInfos, typedErr := nodegroupset.SplitScaleUpBetweenGroups(
+			targetNodeGroups, newNodes, splittingStrategy)
 		if typedErr != nil {
 			return false, typedErr
 		}
+		scaledUpPods := bestOption.Pods
+		if len(zoneAntiAffinityGroups) > 0 {
+			nodeCPU, nodeMemory, err := getNodeInfoCoresAndMemory(nodeInfo)
+			if err == nil {
+				coresTotal += int64(newNodes) * nodeCPU
+				memoryTotal += int64(newNodes) * nodeMemory
+			}
+			zoneScaleUpInfos, zonePods := planZoneAntiAffinityScaleUp(context, bestOption, zoneAntiAffinityGroups,
+				nodeGroups, nodeInfos, podsPassingPredicates, scaleUpInfos, upcomingNodes, len(nodes)+newNodes,
+				coresTotal, memoryTotal, resourceLimiter)
+			scaleUpInfos = append(scaleUpInfos, zoneScaleUpInfos...)
+			scaledUpPods = append(append([]*apiv1.Pod{}, bestOption.Pods...), zonePods...)
+		}
+		scaleUpInfos = applyNetworkCapacityLimits(context, scaleUpInfos, networkBudgets)
+		if len(scaleUpInfos) == 0 {
+			return false, nil
+		}
 		glog.V(1).Infof("Final scale-up plan: %v", scaleUpInfos)
+		executedScaleUpInfos := make([]nodegroupset.ScaleUpInfo, 0, len(scaleUpInfos))
 		for _, info := range scaleUpInfos {
-			typedErr := executeScaleUp(context, info)
+			if context.DryRun {
+				recordPlannedScaleUp(context, info, scaledUpPods, "would set")
+				if context.DryRunReport != nil {
+					var template *apiv1.Node
+					if nodeInfo, found := nodeInfos[info.Group.Id()]; found {
+						template = nodeInfo.Node()
+					}
+					context.DryRunReport.addScaleUp(info, scaledUpPods, template)
+				}
+				continue
+			}
+			if !applyScaleUpRateLimit(context, &info) {
+				continue
+			}
+			typedErr := executeScaleUp(context, info, scaledUpPods)
 			if typedErr != nil {
 				return false, typedErr
 			}
+			executedScaleUpInfos = append(executedScaleUpInfos, info)
+			if context.EstimateShortfallTracker != nil {
+				context.EstimateShortfallTracker.RegisterScaleUp(info.Group.Id(), scaledUpPods)
+			}
+		}
+
+		if context.DryRun {
+			for _, pod := range scaledUpPods {
+				recordPodEvent(context, pod, apiv1.EventTypeNormal, "DryRunTriggeredScaleUp",
+					"pod would trigger scale-up: %v", scaleUpInfos)
+			}
+			return true, nil
+		}
+
+		if len(executedScaleUpInfos) == 0 {
+			// The whole scale-up was rate limited, it will be retried in the next loop.
+			return false, nil
 		}
 
-		for _, pod := range bestOption.Pods {
-			context.Recorder.Eventf(pod, apiv1.EventTypeNormal, "TriggeredScaleUp",
-				"pod triggered scale-up: %v", scaleUpInfos)
+		for _, pod := range scaledUpPods {
+			recordPodEvent(context, pod, apiv1.EventTypeNormal, "TriggeredScaleUp",
+				"pod triggered scale-up: %v", executedScaleUpInfos)
 		}
 
 		context.ClusterStateRegistry.Recalculate()
 		return true, nil
 	}
+	reportPodsNotTriggeringScaleUp(context, podsRemainUnschedulable, blockedByLimits, volumeZones)
+
+	return false, nil
+}
+
+// reportPodsNotTriggeringScaleUp emits NotTriggerScaleUp events for pods that don't fit any node
+// group, unless they are reported as blocked by limits. Pods whose volumes are in a zone without
+// node groups are told so.
+func reportPodsNotTriggeringScaleUp(context *AutoscalingContext, podsRemainUnschedulable map[*apiv1.Pod]bool,
+	blockedByLimits map[*apiv1.Pod]scaleUpLimit, volumeZones *volumeZoneChecker) {
 	for pod, unschedulable := range podsRemainUnschedulable {
-		if unschedulable {
-			context.Recorder.Event(pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
-				"pod didn't trigger scale-up (it wouldn't fit if a new node is added)")
+		if _, blocked := blockedByLimits[pod]; !unschedulable || blocked {
+			continue
 		}
+		if reason := volumeZones.unmatchedReason(pod); reason != "" {
+			recordPodEvent(context, pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
+				"pod didn't trigger scale-up (%s)", reason)
+			continue
+		}
+		recordPodEvent(context, pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
+			"pod didn't trigger scale-up (it wouldn't fit if a new node is added)")
 	}
+}
 
-	return false, nil
+// checkScaleUpPrefilter rules out a pod not matching labels or taints of the node group template with
+// PredicateChecker.CheckStaticPredicates, if the pre-filter is enabled. The first pod rejected for a node
+// group in the loop is verified against all predicates and isn't rejected if they pass, so divergence of
+// the pre-filter shows up in metrics without checking every rejected pod twice.
+func checkScaleUpPrefilter(context *AutoscalingContext, pod *apiv1.Pod, nodeInfo *schedulercache.NodeInfo, verified *bool) error {
+	if !context.ScaleUpPrefilterEnabled {
+		return nil
+	}
+	err := context.PredicateChecker.CheckStaticPredicates(pod, nodeInfo.Node())
+	if err == nil {
+		return nil
+	}
+	if !*verified {
+		*verified = true
+		if context.PredicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnSimpleError) == nil {
+			glog.Warningf("Scale-up pre-filter rejected pod %s/%s passing all predicates: %v", pod.Namespace, pod.Name, err)
+			metrics.RegisterScaleUpPrefilterDivergence()
+			return nil
+		}
+	}
+	glog.V(4).Infof("Scale-up pre-filter failed: %v", err)
+	metrics.RegisterScaleUpPredicateRejection(metrics.PrefilterCheck)
+	return err
+}
+
+// estimateNodeCount returns how many nodes built from nodeInfo are needed for the pods, using the
+// configured estimator. Packing trace is only returned by the binpacking estimator if
+// RecordPackingTrace is set.
+func estimateNodeCount(context *AutoscalingContext, pods []*apiv1.Pod, nodeInfo *schedulercache.NodeInfo,
+	upcomingNodes []*schedulercache.NodeInfo) (int, string, []estimator.PodPlacement) {
+	if context.EstimatorCapacityMargin != nil {
+		nodeInfo = estimator.WithCapacityMargin(nodeInfo, context.EstimatorCapacityMargin)
+		nodesWithMargin := make([]*schedulercache.NodeInfo, 0, len(upcomingNodes))
+		for _, upcomingNode := range upcomingNodes {
+			nodesWithMargin = append(nodesWithMargin, estimator.WithCapacityMargin(upcomingNode, context.EstimatorCapacityMargin))
+		}
+		upcomingNodes = nodesWithMargin
+	}
+	if context.EstimatorName == estimator.BinpackingEstimatorName {
+		binpackingEstimator := estimator.NewBinpackingNodeEstimator(context.PredicateChecker)
+		binpackingEstimator.SetScoringStrategy(context.ScoringStrategy)
+		if context.RecordPackingTrace {
+			binpackingEstimator.EnableTrace()
+		}
+		nodeCount := binpackingEstimator.Estimate(pods, nodeInfo, upcomingNodes)
+		return nodeCount, "", binpackingEstimator.Trace()
+	} else if context.EstimatorName == estimator.BasicEstimatorName {
+		basicEstimator := estimator.NewBasicNodeEstimator()
+		for _, pod := range pods {
+			basicEstimator.Add(pod)
+		}
+		nodeCount, debug := basicEstimator.Estimate(nodeInfo.Node(), upcomingNodes)
+		return nodeCount, debug, nil
+	}
+	glog.Fatalf("Unrecognized estimator: %s", context.EstimatorName)
+	return 0, "", nil
+}
+
+// planZoneAntiAffinityScaleUp extends the scale-up plan with nodes for pods that have a required
+// anti-affinity on zone to a pod helped by the best option. Each of them gets a node in a different
+// zone, from node groups not in the plan yet. Pods for which no zone is left are reported with an
+// event. Returns scale-ups to add to the plan and pods they were planned for.
+func planZoneAntiAffinityScaleUp(context *AutoscalingContext, bestOption *expander.Option, groups [][]*apiv1.Pod,
+	nodeGroups []cloudprovider.NodeGroup, nodeInfos map[string]*schedulercache.NodeInfo,
+	podsPassingPredicates map[string][]*apiv1.Pod, plan []nodegroupset.ScaleUpInfo,
+	upcomingNodes []*schedulercache.NodeInfo, clusterSize int, coresTotal, memoryTotal int64,
+	resourceLimiter *cloudprovider.ResourceLimiter) ([]nodegroupset.ScaleUpInfo, []*apiv1.Pod) {
+
+	inPlan := make(map[string]bool)
+	planZones := make(map[string]bool)
+	for _, info := range plan {
+		inPlan[info.Group.Id()] = true
+		if info.NewSize > info.CurrentSize {
+			if zone := estimator.NodeZone(nodeInfos[info.Group.Id()]); zone != "" {
+				planZones[zone] = true
+			}
+		}
+	}
+	inPlan[bestOption.NodeGroup.Id()] = true
+
+	options := make([]estimator.ZonalOption, 0)
+	for _, nodeGroup := range nodeGroups {
+		pods, found := podsPassingPredicates[nodeGroup.Id()]
+		if !found || inPlan[nodeGroup.Id()] || !nodeGroup.Exist() {
+			continue
+		}
+		options = append(options, estimator.ZonalOption{
+			Id:   nodeGroup.Id(),
+			Zone: estimator.NodeZone(nodeInfos[nodeGroup.Id()]),
+			Pods: pods,
+		})
+	}
+
+	podsPerNodeGroup := make(map[string][]*apiv1.Pod)
+	for _, group := range groups {
+		remaining := make([]*apiv1.Pod, 0, len(group))
+		helped := false
+		for _, pod := range group {
+			if containsPod(bestOption.Pods, pod) {
+				helped = true
+			} else {
+				remaining = append(remaining, pod)
+			}
+		}
+		if !helped || len(remaining) == 0 {
+			continue
+		}
+		assigned, unplaced := estimator.SpreadAcrossZones(remaining, options, planZones)
+		for id, pods := range assigned {
+			podsPerNodeGroup[id] = append(podsPerNodeGroup[id], pods...)
+		}
+		for _, pod := range unplaced {
+			glog.V(1).Infof("No zone left for pod %s/%s with required zone anti-affinity", pod.Namespace, pod.Name)
+			recordPodEvent(context, pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
+				"pod didn't trigger scale-up (not enough zones to satisfy its required anti-affinity on zone)")
+		}
+	}
+
+	infos := make([]nodegroupset.ScaleUpInfo, 0)
+	scaledUpPods := make([]*apiv1.Pod, 0)
+	for _, nodeGroup := range nodeGroups {
+		pods, found := podsPerNodeGroup[nodeGroup.Id()]
+		if !found {
+			continue
+		}
+		nodeInfo := nodeInfos[nodeGroup.Id()]
+		increase, _, _ := estimateNodeCount(context, pods, nodeInfo, upcomingNodes)
+		currentSize, err := nodeGroup.TargetSize()
+		if err != nil {
+			glog.Errorf("Failed to get node group size: %v", err)
+			continue
+		}
+		if currentSize+increase > nodeGroup.MaxSize() {
+			increase = nodeGroup.MaxSize() - currentSize
+		}
+		if context.MaxNodesTotal > 0 && clusterSize+increase > context.MaxNodesTotal {
+			glog.V(1).Infof("Capping zone anti-affinity scale-up of %s to max cluster total size (%d)", nodeGroup.Id(), context.MaxNodesTotal)
+			increase = context.MaxNodesTotal - clusterSize
+		}
+		if increase <= 0 {
+			continue
+		}
+		increase, typedErr := applyMaxClusterCoresMemoryLimits(increase, coresTotal, memoryTotal,
+			resourceLimiter.GetMax(cloudprovider.ResourceNameCores), resourceLimiter.GetMax(cloudprovider.ResourceNameMemory), nodeInfo)
+		if typedErr != nil {
+			glog.V(1).Infof("Skipping zone anti-affinity scale-up of %s: %v", nodeGroup.Id(), typedErr)
+			continue
+		}
+		if nodeCPU, nodeMemory, err := getNodeInfoCoresAndMemory(nodeInfo); err == nil {
+			coresTotal += int64(increase) * nodeCPU
+			memoryTotal += int64(increase) * nodeMemory
+		}
+		clusterSize += increase
+		glog.V(1).Infof("Adding %d nodes in %s to the scale-up for pods with required zone anti-affinity", increase, nodeGroup.Id())
+		infos = append(infos, nodegroupset.ScaleUpInfo{
+			Group:       nodeGroup,
+			CurrentSize: currentSize,
+			NewSize:     currentSize + increase,
+			MaxSize:     nodeGroup.MaxSize(),
+		})
+		scaledUpPods = append(scaledUpPods, pods...)
+	}
+	return infos, scaledUpPods
+}
+
+func containsPod(pods []*apiv1.Pod, pod *apiv1.Pod) bool {
+	for _, p := range pods {
+		if p == pod {
+			return true
+		}
+	}
+	return false
+}
+
+// applyScaleUpRateLimit truncates the scale-up to the number of nodes allowed by the scale-up rate limiter
+// and takes the tokens for them. Returns false if no node can be added now.
+func applyScaleUpRateLimit(context *AutoscalingContext, info *nodegroupset.ScaleUpInfo) bool {
+	if context.ScaleUpRateLimiter == nil {
+		return true
+	}
+	now := time.Now()
+	increase := info.NewSize - info.CurrentSize
+	limit := context.NodeGroupConfigProcessor.GetOptions(context, info.Group).MaxNodesPerMinute
+	allowed := context.ScaleUpRateLimiter.Allowed(info.Group.Id(), limit, increase, now)
+	if allowed < increase {
+		glog.V(1).Infof("Scale-up of group %s limited by rate limit: %d of %d nodes allowed", info.Group.Id(), allowed, increase)
+		context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleUpRateLimited",
+			"Scale-up of group %s truncated from %d to %d nodes by scale-up rate limit", info.Group.Id(), increase, allowed)
+	}
+	if allowed <= 0 {
+		return false
+	}
+	info.NewSize = info.CurrentSize + allowed
+	context.ScaleUpRateLimiter.RegisterScaleUp(info.Group.Id(), limit, allowed, now)
+	return true
+}
+
+// refundScaleUpRateLimit gives back the tokens taken by applyScaleUpRateLimit for nodes that were
+// not requested in the end.
+func refundScaleUpRateLimit(context *AutoscalingContext, nodeGroupId string, count int) {
+	if context.ScaleUpRateLimiter == nil || count <= 0 {
+		return
+	}
+	context.ScaleUpRateLimiter.Refund(nodeGroupId, count, time.Now())
+}
+
+// networkBudgets tracks the addresses left for new nodes in each network during a scale-up. Node
+// groups in the same network share its addresses, and nodes of scale-ups still in progress take
+// theirs before any new nodes do.
+type networkBudgets struct {
+	context       *AutoscalingContext
+	nodes         []*apiv1.Node
+	nodeGroups    []cloudprovider.NodeGroup
+	upcomingNodes map[string]int
+	// capacities contains network capacities by node group id, nil if not known. The cloud
+	// provider is asked about each node group once per scale-up.
+	capacities map[string]*cloudprovider.NetworkCapacity
+	// addressesLeft contains addresses left by network.
+	addressesLeft map[string]int
+}
+
+func newNetworkBudgets(context *AutoscalingContext, nodes []*apiv1.Node, nodeGroups []cloudprovider.NodeGroup,
+	upcomingNodes map[string]int) *networkBudgets {
+	return &networkBudgets{
+		context:       context,
+		nodes:         nodes,
+		nodeGroups:    nodeGroups,
+		upcomingNodes: upcomingNodes,
+		capacities:    make(map[string]*cloudprovider.NetworkCapacity),
+		addressesLeft: make(map[string]int),
+	}
+}
+
+func (b *networkBudgets) capacity(nodeGroup cloudprovider.NodeGroup) *cloudprovider.NetworkCapacity {
+	if capacity, found := b.capacities[nodeGroup.Id()]; found {
+		return capacity
+	}
+	var capacity *cloudprovider.NetworkCapacity
+	// Node groups that don't exist yet have no network to check.
+	if provider, ok := b.context.CloudProvider.(cloudprovider.NetworkCapacityCloudProvider); ok && nodeGroup.Exist() {
+		var err error
+		capacity, err = provider.NodeGroupNetworkCapacity(nodeGroup, b.nodes)
+		if err != nil {
+			glog.Warningf("Failed to get network capacity of node group %s: %v", nodeGroup.Id(), err)
+			capacity = nil
+		}
+	}
+	b.capacities[nodeGroup.Id()] = capacity
+	return capacity
+}
+
+// maxNodes returns how many nodes can still be added to the node group before its network is
+// exhausted. False is returned if it is not known.
+func (b *networkBudgets) maxNodes(nodeGroup cloudprovider.NodeGroup) (int, bool) {
+	capacity := b.capacity(nodeGroup)
+	if capacity == nil {
+		return 0, false
+	}
+	left, found := b.addressesLeft[capacity.Network]
+	if !found {
+		left = capacity.Addresses - b.upcomingAddresses(capacity.Network)
+		b.addressesLeft[capacity.Network] = left
+	}
+	return capacity.MaxNodes(left), true
+}
+
+// take registers that the given number of nodes is added to the node group.
+func (b *networkBudgets) take(nodeGroup cloudprovider.NodeGroup, newNodes int) {
+	if _, limited := b.maxNodes(nodeGroup); !limited {
+		return
+	}
+	capacity := b.capacity(nodeGroup)
+	b.addressesLeft[capacity.Network] -= newNodes * capacity.AddressesPerNode
+}
+
+// upcomingAddresses returns how many addresses of the network will be taken by nodes that were
+// requested but haven't registered yet.
+func (b *networkBudgets) upcomingAddresses(network string) int {
+	result := 0
+	for _, nodeGroup := range b.nodeGroups {
+		upcoming := b.upcomingNodes[nodeGroup.Id()]
+		if upcoming <= 0 {
+			continue
+		}
+		if capacity := b.capacity(nodeGroup); capacity != nil && capacity.Network == network {
+			result += upcoming * capacity.AddressesPerNode
+		}
+	}
+	return result
+}
+
+// applyNetworkCapacityLimits truncates the scale-ups to the number of nodes that still fit in the
+// network of each node group. Scale-ups of node groups without any addresses left are dropped.
+func applyNetworkCapacityLimits(context *AutoscalingContext, infos []nodegroupset.ScaleUpInfo,
+	budgets *networkBudgets) []nodegroupset.ScaleUpInfo {
+	result := make([]nodegroupset.ScaleUpInfo, 0, len(infos))
+	for _, info := range infos {
+		increase := info.NewSize - info.CurrentSize
+		if maxNodes, limited := budgets.maxNodes(info.Group); limited && increase > maxNodes {
+			glog.V(1).Infof("Scale-up of group %s limited by network capacity: %d of %d nodes fit", info.Group.Id(), maxNodes, increase)
+			context.LogRecorder.Eventf(apiv1.EventTypeWarning, "ScaleUpLimitedByNetwork",
+				"Scale-up of group %s truncated from %d to %d nodes, no addresses left for more nodes", info.Group.Id(), increase, maxNodes)
+			metrics.RegisterNetworkLimitedScaleUp(info.Group.Id())
+			if maxNodes == 0 {
+				continue
+			}
+			increase = maxNodes
+			info.NewSize = info.CurrentSize + increase
+		}
+		budgets.take(info.Group, increase)
+		result = append(result, info)
+	}
+	return result
 }
 
 func filterNodeGroupsByPods(groups []cloudprovider.NodeGroup, podsRequiredToFit []*apiv1.Pod,
@@ -334,21 +802,60 @@ groupsloop:
 	return result
 }
 
-func executeScaleUp(context *AutoscalingContext, info nodegroupset.ScaleUpInfo) errors.AutoscalerError {
-	glog.V(0).Infof("Scale-up: setting group %s size to %d", info.Group.Id(), info.NewSize)
-	increase := info.NewSize - info.CurrentSize
-	if err := info.Group.IncreaseSize(increase); err != nil {
+func executeScaleUp(context *AutoscalingContext, info nodegroupset.ScaleUpInfo, pods []*apiv1.Pod) errors.AutoscalerError {
+	// Rate limit tokens were taken for the whole planned increase, the ones of nodes that end up
+	// not being requested are given back.
+	planned := info.NewSize - info.CurrentSize
+	requested := 0
+	defer func() {
+		refundScaleUpRateLimit(context, info.Group.Id(), planned-requested)
+	}()
+
+	// The target size may have been changed outside of CA since it was read for planning, e.g. by a
+	// surge of node auto-repair. Target sizes are cheap to read again, mostly served from the cache
+	// refreshed in this loop.
+	if currentSize, err := info.Group.TargetSize(); err != nil {
+		glog.Warningf("Failed to read target size of %s before scale-up, using %d: %v", info.Group.Id(), info.CurrentSize, err)
+	} else {
+		info = reconcileTargetSize(context, info, currentSize)
+	}
+	if info.NewSize <= info.CurrentSize {
+		return nil
+	}
+	operationId, err := increaseSize(info)
+	if sizeChangedErr, ok := err.(*cloudprovider.TargetSizeChangedError); ok && sizeChangedErr.Actual > sizeChangedErr.Expected {
+		// The conditional resize read a fresher target size than the one above.
+		info = reconcileTargetSize(context, info, sizeChangedErr.Actual)
+		if info.NewSize <= info.CurrentSize {
+			return nil
+		}
+		operationId, err = increaseSize(info)
+	}
+	if sizeChangedErr, ok := err.(*cloudprovider.TargetSizeChangedError); ok {
+		glog.Warningf("Scale-up of %s aborted, will retry in the next loop: %v", info.Group.Id(), sizeChangedErr)
+		context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleUpRetried", "Scale-up of group %s aborted: %v", info.Group.Id(), sizeChangedErr)
+		return errors.NewAutoscalerError(errors.TransientError, "failed to increase node group size: %v", sizeChangedErr)
+	}
+	if err != nil {
 		context.LogRecorder.Eventf(apiv1.EventTypeWarning, "FailedToScaleUpGroup", "Scale-up failed for group %s: %v", info.Group.Id(), err)
-		context.ClusterStateRegistry.RegisterFailedScaleUp(info.Group.Id(), metrics.APIError)
+		reason := metrics.APIError
+		if _, ok := err.(*cloudprovider.OutOfResourcesError); ok {
+			reason = metrics.OutOfResources
+		}
+		context.ClusterStateRegistry.RegisterFailedScaleUp(info.Group.Id(), reason)
 		return errors.NewAutoscalerError(errors.CloudProviderError,
 			"failed to increase node group size: %v", err)
 	}
+	increase := info.NewSize - info.CurrentSize
+	requested = increase
 	context.ClusterStateRegistry.RegisterScaleUp(
 		&clusterstate.ScaleUpRequest{
 			NodeGroupName:   info.Group.Id(),
 			Increase:        increase,
 			Time:            time.Now(),
-			ExpectedAddTime: time.Now().Add(context.MaxNodeProvisionTime),
+			ExpectedAddTime: time.Now().Add(context.ClusterStateRegistry.GetProvisionTimeout(info.Group.Id())),
+			OperationId:     operationId,
+			Pods:            podKeys(pods),
 		})
 	metrics.RegisterScaleUp(increase)
 	context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaledUpGroup",
@@ -356,6 +863,47 @@ func executeScaleUp(context *AutoscalingContext, info nodegroupset.ScaleUpInfo)
 	return nil
 }
 
+// increaseSize increases the size of the node group from info.CurrentSize to info.NewSize. It
+// returns the id of the resize operation if the node group is resized asynchronously.
+func increaseSize(info nodegroupset.ScaleUpInfo) (string, error) {
+	glog.V(0).Infof("Scale-up: setting group %s size to %d", info.Group.Id(), info.NewSize)
+	increase := info.NewSize - info.CurrentSize
+	// Conditional resizes fail if the target size changed since it was read, so that CA doesn't
+	// repeat a scale-up done concurrently by someone else.
+	switch group := info.Group.(type) {
+	case cloudprovider.ConditionalAsyncNodeGroup:
+		return group.IncreaseSizeAsyncFrom(info.CurrentSize, increase)
+	case cloudprovider.AsyncNodeGroup:
+		return group.IncreaseSizeAsync(increase)
+	case cloudprovider.ConditionalNodeGroup:
+		return "", group.IncreaseSizeFrom(info.CurrentSize, increase)
+	default:
+		return "", info.Group.IncreaseSize(increase)
+	}
+}
+
+// reconcileTargetSize updates the scale-up to the current target size of the node group. Nodes added
+// outside of CA since the scale-up was planned are upcoming nodes for the pods that triggered it, so
+// the increase is reduced by them instead of being requested on top. A smaller target size doesn't
+// change the increase: the removed nodes weren't taken into account by the estimation anyway.
+func reconcileTargetSize(context *AutoscalingContext, info nodegroupset.ScaleUpInfo, currentSize int) nodegroupset.ScaleUpInfo {
+	if currentSize <= info.CurrentSize {
+		return info
+	}
+	externalIncrease := currentSize - info.CurrentSize
+	increase := info.NewSize - info.CurrentSize - externalIncrease
+	if increase < 0 {
+		increase = 0
+	}
+	glog.Warningf("Target size of %s changed from %d to %d since scale-up was planned, reducing the increase from %d to %d",
+		info.Group.Id(), info.CurrentSize, currentSize, info.NewSize-info.CurrentSize, increase)
+	context.LogRecorder.Eventf(apiv1.EventTypeNormal, "ScaleUpReconciled",
+		"Scale-up of group %s reduced to %d nodes, %d nodes were added outside of cluster autoscaler", info.Group.Id(), increase, externalIncrease)
+	info.CurrentSize = currentSize
+	info.NewSize = currentSize + increase
+	return info
+}
+
 func addAutoprovisionedCandidates(context *AutoscalingContext, nodeGroups []cloudprovider.NodeGroup,
 	nodeInfos map[string]*schedulercache.NodeInfo, unschedulablePods []*apiv1.Pod) ([]cloudprovider.NodeGroup,
 	map[string]*schedulercache.NodeInfo) {
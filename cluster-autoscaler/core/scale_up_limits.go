@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+)
+
+const (
+	// ScaleUpLimitEventInterval is the minimum time between events about the same pod blocked by a
+	// cluster-wide limit.
+	ScaleUpLimitEventInterval = 10 * time.Minute
+
+	nodesLimit  = "nodes"
+	coresLimit  = "cores"
+	memoryLimit = "memory"
+)
+
+// scaleUpLimit is a cluster-wide limit that keeps pods from triggering a scale-up.
+type scaleUpLimit struct {
+	// name is the limit label used in metrics and status: nodes, cores or memory.
+	name string
+	// reason is the reason reported in events of blocked pods.
+	reason string
+	// current is the cluster size in units of the limit. Memory is in MiB.
+	current int64
+	// max is the value of the limit.
+	max int64
+}
+
+func maxNodesTotalLimit(current, max int) scaleUpLimit {
+	return scaleUpLimit{name: nodesLimit, reason: "MaxNodesTotalReached", current: int64(current), max: int64(max)}
+}
+
+func maxCoresTotalLimit(current, max int64) scaleUpLimit {
+	return scaleUpLimit{name: coresLimit, reason: "MaxCoresTotalReached", current: current, max: max}
+}
+
+func maxMemoryTotalLimit(current, max int64) scaleUpLimit {
+	return scaleUpLimit{name: memoryLimit, reason: "MaxMemoryTotalReached", current: current, max: max}
+}
+
+// ScaleUpLimitEventLimiter makes sure a pod blocked by a cluster-wide limit gets an event at most
+// once per ScaleUpLimitEventInterval, as it stays blocked for many loops.
+type ScaleUpLimitEventLimiter struct {
+	sync.Mutex
+	lastEvent map[types.UID]time.Time
+}
+
+// NewScaleUpLimitEventLimiter builds a ScaleUpLimitEventLimiter.
+func NewScaleUpLimitEventLimiter() *ScaleUpLimitEventLimiter {
+	return &ScaleUpLimitEventLimiter{
+		lastEvent: make(map[types.UID]time.Time),
+	}
+}
+
+// allow returns true if an event about the pod can be emitted now and records it. A nil limiter
+// allows all events.
+func (l *ScaleUpLimitEventLimiter) allow(pod *apiv1.Pod, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if last, found := l.lastEvent[pod.UID]; found && now.Sub(last) < ScaleUpLimitEventInterval {
+		return false
+	}
+	l.lastEvent[pod.UID] = now
+	return true
+}
+
+// cleanUp forgets pods whose last event is old enough not to limit the next one.
+func (l *ScaleUpLimitEventLimiter) cleanUp(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for uid, last := range l.lastEvent {
+		if now.Sub(last) >= ScaleUpLimitEventInterval {
+			delete(l.lastEvent, uid)
+		}
+	}
+}
+
+// reportPodsBlockedByLimits emits events on pods blocked by cluster-wide limits and updates the
+// metrics and the status with the number of pods blocked by each limit.
+func reportPodsBlockedByLimits(context *AutoscalingContext, blocked map[*apiv1.Pod]scaleUpLimit, now time.Time) {
+	context.ScaleUpLimitEventLimiter.cleanUp(now)
+	counts := make(map[string]int)
+	limits := make(map[string]scaleUpLimit)
+	for pod, limit := range blocked {
+		counts[limit.name]++
+		limits[limit.name] = limit
+		if context.ScaleUpLimitEventLimiter.allow(pod, now) {
+			recordPodEvent(context, pod, apiv1.EventTypeNormal, "NotTriggerScaleUp",
+				"pod didn't trigger scale-up (%s: %s current=%d max=%d)", limit.reason, limit.name, limit.current, limit.max)
+		}
+	}
+	statuses := make([]clusterstate.ScaleUpLimitStatus, 0)
+	for _, name := range []string{nodesLimit, coresLimit, memoryLimit} {
+		metrics.UpdatePodsBlockedByLimit(name, counts[name])
+		if counts[name] > 0 {
+			statuses = append(statuses, clusterstate.ScaleUpLimitStatus{
+				Limit:       name,
+				Current:     limits[name].current,
+				Max:         limits[name].max,
+				BlockedPods: counts[name],
+			})
+		}
+	}
+	if context.ClusterStateRegistry != nil {
+		context.ClusterStateRegistry.UpdateScaleUpLimits(statuses, now)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleUpBlockedByLimits(t *testing.T) {
+	testCases := []struct {
+		name          string
+		maxNodesTotal int
+		maxCores      int64
+		maxMemory     int64
+		expectedEvent string
+		expectedLimit string
+	}{
+		{
+			name:          "nodes",
+			maxNodesTotal: 1,
+			maxCores:      config.DefaultMaxClusterCores,
+			maxMemory:     config.DefaultMaxClusterMemory,
+			expectedEvent: "Normal NotTriggerScaleUp pod didn't trigger scale-up (MaxNodesTotalReached: nodes current=1 max=1)",
+			expectedLimit: "nodes current=1 max=1 blockedPods=1",
+		},
+		{
+			name:          "cores",
+			maxCores:      3,
+			maxMemory:     config.DefaultMaxClusterMemory,
+			expectedEvent: "Normal NotTriggerScaleUp pod didn't trigger scale-up (MaxCoresTotalReached: cores current=2 max=3)",
+			expectedLimit: "cores current=2 max=3 blockedPods=1",
+		},
+		{
+			name:          "memory",
+			maxCores:      config.DefaultMaxClusterCores,
+			maxMemory:     1500,
+			expectedEvent: "Normal NotTriggerScaleUp pod didn't trigger scale-up (MaxMemoryTotalReached: memory current=1000 max=1500)",
+			expectedLimit: "memory current=1000 max=1500 blockedPods=1",
+		},
+	}
+
+	for _, tc := range testCases {
+		n1 := BuildTestNode("n1", 2000, 1000*MB)
+		SetNodeReadyState(n1, true, time.Now())
+
+		fakeClient := &fake.Clientset{}
+		fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+			return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+		})
+
+		provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+			t.Fatalf("%s: no expansion is expected", tc.name)
+			return nil
+		}, nil)
+		provider.AddNodeGroup("ng1", 1, 10, 1)
+		provider.AddNode("ng1", n1)
+		provider.SetResourceLimiter(cloudprovider.NewResourceLimiter(
+			map[string]int64{cloudprovider.ResourceNameCores: 0, cloudprovider.ResourceNameMemory: 0},
+			map[string]int64{cloudprovider.ResourceNameCores: tc.maxCores, cloudprovider.ResourceNameMemory: tc.maxMemory}))
+
+		fakeRecorder := kube_record.NewFakeRecorder(5)
+		fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+		clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+		clusterState.UpdateNodes([]*apiv1.Node{n1}, time.Now())
+		context := &AutoscalingContext{
+			AutoscalingOptions: AutoscalingOptions{
+				EstimatorName:  estimator.BinpackingEstimatorName,
+				MaxNodesTotal:  tc.maxNodesTotal,
+				MaxCoresTotal:  tc.maxCores,
+				MaxMemoryTotal: tc.maxMemory,
+			},
+			PredicateChecker:         simulator.NewTestPredicateChecker(),
+			CloudProvider:            provider,
+			ClientSet:                fakeClient,
+			Recorder:                 fakeRecorder,
+			ExpanderStrategy:         random.NewStrategy(),
+			ClusterStateRegistry:     clusterState,
+			LogRecorder:              fakeLogRecorder,
+			ScaleUpLimitEventLimiter: NewScaleUpLimitEventLimiter(),
+		}
+		p1 := BuildTestPod("p1", 1500, 0)
+
+		result, _ := ScaleUp(context, []*apiv1.Pod{p1}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{}, nil)
+		assert.False(t, result, tc.name)
+		assert.Equal(t, tc.expectedEvent, getStringFromChan(fakeRecorder.Events), tc.name)
+		assert.Equal(t, "Nothing returned", getStringFromChanImmediately(fakeRecorder.Events), tc.name)
+
+		conditions := clusterState.GetStatus(time.Now()).ClusterwideConditions
+		condition := conditions[len(conditions)-1]
+		assert.Equal(t, api.ClusterAutoscalerScaleUpLimits, condition.Type, tc.name)
+		assert.Equal(t, tc.expectedLimit, condition.Message, tc.name)
+
+		// The pod is still blocked, but it got an event recently.
+		ScaleUp(context, []*apiv1.Pod{p1}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{}, nil)
+		assert.Equal(t, "Nothing returned", getStringFromChanImmediately(fakeRecorder.Events), tc.name)
+	}
+}
+
+func TestScaleUpLimitEventLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleUpLimitEventLimiter()
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.UID = "p1"
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.UID = "p2"
+
+	assert.True(t, limiter.allow(p1, now))
+	assert.False(t, limiter.allow(p1, now.Add(time.Minute)))
+	assert.True(t, limiter.allow(p2, now.Add(time.Minute)))
+
+	limiter.cleanUp(now.Add(ScaleUpLimitEventInterval))
+	assert.Equal(t, 1, len(limiter.lastEvent))
+	assert.True(t, limiter.allow(p1, now.Add(ScaleUpLimitEventInterval)))
+	assert.False(t, limiter.allow(p2, now.Add(ScaleUpLimitEventInterval)))
+
+	var nilLimiter *ScaleUpLimitEventLimiter
+	assert.True(t, nilLimiter.allow(p1, now))
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket holds up to capacity tokens and refills at the rate of capacity tokens per minute.
+type tokenBucket struct {
+	capacity   float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(nodesPerMinute int) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(nodesPerMinute),
+		tokens:   float64(nodesPerMinute),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.lastRefill.IsZero() && now.After(b.lastRefill) {
+		b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.lastRefill).Minutes()*b.capacity)
+	}
+	if b.lastRefill.IsZero() || now.After(b.lastRefill) {
+		b.lastRefill = now
+	}
+}
+
+func (b *tokenBucket) available(now time.Time) int {
+	b.refill(now)
+	return int(math.Floor(b.tokens))
+}
+
+func (b *tokenBucket) take(count int, now time.Time) {
+	b.refill(now)
+	b.tokens -= float64(count)
+}
+
+func (b *tokenBucket) refund(count int, now time.Time) {
+	b.refill(now)
+	b.tokens = math.Min(b.capacity, b.tokens+float64(count))
+}
+
+// ScaleUpRateLimiter limits the number of nodes added by scale-ups per minute, both in the whole
+// cluster and in each node group. Tokens are taken as soon as a scale-up is requested, so nodes
+// that are still being provisioned count against the limit. Tokens of nodes that failed to be
+// created are given back once the failure is found.
+type ScaleUpRateLimiter struct {
+	sync.Mutex
+	global     *tokenBucket
+	nodeGroups map[string]*tokenBucket
+}
+
+// NewScaleUpRateLimiter builds a ScaleUpRateLimiter with the given limit for the whole cluster.
+// Limits of node groups are passed with each call. Limits equal to 0 mean no limit.
+func NewScaleUpRateLimiter(maxNodesPerMinute int) *ScaleUpRateLimiter {
+	limiter := &ScaleUpRateLimiter{
+		nodeGroups: make(map[string]*tokenBucket),
+	}
+	if maxNodesPerMinute > 0 {
+		limiter.global = newTokenBucket(maxNodesPerMinute)
+	}
+	return limiter
+}
+
+// Allowed returns how many of the requested nodes can be added to the given node group now, with
+// nodeGroupLimit nodes per minute allowed in the node group.
+func (l *ScaleUpRateLimiter) Allowed(nodeGroupId string, nodeGroupLimit int, increase int, now time.Time) int {
+	l.Lock()
+	defer l.Unlock()
+
+	allowed := increase
+	if l.global != nil {
+		allowed = minInt(allowed, l.global.available(now))
+	}
+	if bucket := l.nodeGroupBucket(nodeGroupId, nodeGroupLimit); bucket != nil {
+		allowed = minInt(allowed, bucket.available(now))
+	}
+	if allowed < 0 {
+		return 0
+	}
+	return allowed
+}
+
+// RegisterScaleUp takes tokens for nodes requested from the given node group.
+func (l *ScaleUpRateLimiter) RegisterScaleUp(nodeGroupId string, nodeGroupLimit int, increase int, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.global != nil {
+		l.global.take(increase, now)
+	}
+	if bucket := l.nodeGroupBucket(nodeGroupId, nodeGroupLimit); bucket != nil {
+		bucket.take(increase, now)
+	}
+}
+
+// Refund gives back tokens of nodes of the given node group that failed to be created.
+func (l *ScaleUpRateLimiter) Refund(nodeGroupId string, count int, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.global != nil {
+		l.global.refund(count, now)
+	}
+	if bucket, found := l.nodeGroups[nodeGroupId]; found {
+		bucket.refund(count, now)
+	}
+}
+
+// nodeGroupBucket returns the bucket of the node group, updating its capacity if the limit changed.
+// To be executed under a lock.
+func (l *ScaleUpRateLimiter) nodeGroupBucket(nodeGroupId string, limit int) *tokenBucket {
+	if limit <= 0 {
+		delete(l.nodeGroups, nodeGroupId)
+		return nil
+	}
+	bucket, found := l.nodeGroups[nodeGroupId]
+	if !found {
+		bucket = newTokenBucket(limit)
+		l.nodeGroups[nodeGroupId] = bucket
+	} else if bucket.capacity != float64(limit) {
+		bucket.capacity = float64(limit)
+		bucket.tokens = math.Min(bucket.capacity, bucket.tokens)
+	}
+	return bucket
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleUpRateLimiterNoLimits(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleUpRateLimiter(0)
+	assert.Equal(t, 400, limiter.Allowed("ng1", 0, 400, now))
+	limiter.RegisterScaleUp("ng1", 0, 400, now)
+	assert.Equal(t, 400, limiter.Allowed("ng1", 0, 400, now))
+}
+
+func TestScaleUpRateLimiterTruncationAndRefill(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleUpRateLimiter(10)
+
+	assert.Equal(t, 10, limiter.Allowed("ng1", 0, 400, now))
+	limiter.RegisterScaleUp("ng1", 0, 10, now)
+	assert.Equal(t, 0, limiter.Allowed("ng1", 0, 400, now))
+
+	// Half a minute refills half of the bucket.
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, 5, limiter.Allowed("ng1", 0, 400, now))
+	assert.Equal(t, 3, limiter.Allowed("ng1", 0, 3, now))
+	limiter.RegisterScaleUp("ng1", 0, 3, now)
+	assert.Equal(t, 2, limiter.Allowed("ng1", 0, 400, now))
+
+	// Bucket never holds more than a minute worth of nodes.
+	now = now.Add(time.Hour)
+	assert.Equal(t, 10, limiter.Allowed("ng1", 0, 400, now))
+}
+
+func TestScaleUpRateLimiterPerNodeGroup(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleUpRateLimiter(0)
+
+	assert.Equal(t, 4, limiter.Allowed("ng1", 4, 10, now))
+	limiter.RegisterScaleUp("ng1", 4, 4, now)
+	assert.Equal(t, 0, limiter.Allowed("ng1", 4, 10, now))
+	assert.Equal(t, 4, limiter.Allowed("ng2", 4, 10, now))
+	// Node groups can have different limits.
+	assert.Equal(t, 8, limiter.Allowed("ng3", 8, 10, now))
+
+	now = now.Add(15 * time.Second)
+	assert.Equal(t, 1, limiter.Allowed("ng1", 4, 10, now))
+
+	// A lower limit caps the tokens left, removing it lifts the limit.
+	limiter.RegisterScaleUp("ng3", 8, 2, now)
+	assert.Equal(t, 3, limiter.Allowed("ng3", 3, 10, now))
+	assert.Equal(t, 10, limiter.Allowed("ng3", 0, 10, now))
+}
+
+func TestScaleUpRateLimiterGlobalContention(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleUpRateLimiter(6)
+
+	allowed := limiter.Allowed("ng1", 4, 10, now)
+	assert.Equal(t, 4, allowed)
+	limiter.RegisterScaleUp("ng1", 4, allowed, now)
+
+	// ng2 has its own per group budget, but only 2 nodes are left in the global one.
+	allowed = limiter.Allowed("ng2", 4, 10, now)
+	assert.Equal(t, 2, allowed)
+	limiter.RegisterScaleUp("ng2", 4, allowed, now)
+
+	assert.Equal(t, 0, limiter.Allowed("ng3", 4, 10, now))
+
+	// After a minute all budgets are refilled.
+	now = now.Add(time.Minute)
+	assert.Equal(t, 4, limiter.Allowed("ng2", 4, 10, now))
+	assert.Equal(t, 4, limiter.Allowed("ng3", 4, 10, now))
+}
+
+func TestScaleUpRateLimiterRefund(t *testing.T) {
+	now := time.Now()
+	limiter := NewScaleUpRateLimiter(6)
+
+	limiter.RegisterScaleUp("ng1", 4, 4, now)
+	limiter.RegisterScaleUp("ng2", 4, 2, now)
+	assert.Equal(t, 0, limiter.Allowed("ng1", 4, 10, now))
+
+	// 3 nodes of ng1 failed, their tokens are given back to both budgets.
+	limiter.Refund("ng1", 3, now)
+	assert.Equal(t, 3, limiter.Allowed("ng1", 4, 10, now))
+	assert.Equal(t, 2, limiter.Allowed("ng2", 4, 10, now))
+
+	// Buckets are never refilled above their capacity.
+	limiter.Refund("ng1", 10, now)
+	assert.Equal(t, 4, limiter.Allowed("ng1", 4, 10, now))
+	assert.Equal(t, 6, limiter.Allowed("ng3", 0, 10, now))
+}
@@ -19,6 +19,7 @@ package core
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -26,20 +27,25 @@ import (
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/api"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
 	"k8s.io/autoscaler/cluster-autoscaler/config"
 	"k8s.io/autoscaler/cluster-autoscaler/estimator"
 	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 
 	apiv1 "k8s.io/api/core/v1"
 	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	kube_record "k8s.io/client-go/tools/record"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/stretchr/testify/assert"
@@ -68,6 +74,12 @@ type scaleTestConfig struct {
 	expectedScaleUpGroup string
 	expectedScaleDowns   []string
 	options              AutoscalingOptions
+	// networkCapacity is the number of nodes that fit in the network of a node group, unlimited if not set.
+	networkCapacity map[string]int
+	// scaleDownCooldown are the node removals held back by scale down delays.
+	scaleDownCooldown *scaleDownCooldown
+	// wastedNodes are nodes added by failed scale-ups whose pods are still pending.
+	wastedNodes map[string]clusterstate.WastedNode
 }
 
 var defaultOptions = AutoscalingOptions{
@@ -175,6 +187,121 @@ func TestScaleUpCapToMaxTotalNodesLimit(t *testing.T) {
 	simpleScaleUpTest(t, config)
 }
 
+func TestScaleUpRateLimited(t *testing.T) {
+	options := defaultOptions
+	options.MaxNodesPerMinute = 2
+	config := &scaleTestConfig{
+		nodes: []nodeConfig{
+			{"n1", 2000, 100 * MB, true, "ng1"},
+			{"n2", 4000, 1000 * MB, true, "ng2"},
+		},
+		pods: []podConfig{
+			{"p1", 1000, 0, "n1"},
+			{"p2", 3000, 0, "n2"},
+		},
+		extraPods: []podConfig{
+			{"p-new-1", 4000, 100 * MB, ""},
+			{"p-new-2", 4000, 100 * MB, ""},
+			{"p-new-3", 4000, 100 * MB, ""},
+		},
+		expectedScaleUp:      "ng2-2",
+		expectedScaleUpGroup: "ng2",
+		options:              options,
+	}
+
+	simpleScaleUpTest(t, config)
+}
+
+func TestScaleUpNetworkCapacityLimited(t *testing.T) {
+	config := &scaleTestConfig{
+		nodes: []nodeConfig{
+			{"n1", 2000, 100 * MB, true, "ng1"},
+			{"n2", 4000, 1000 * MB, true, "ng2"},
+		},
+		pods: []podConfig{
+			{"p1", 1000, 0, "n1"},
+			{"p2", 3000, 0, "n2"},
+		},
+		extraPods: []podConfig{
+			{"p-new-1", 4000, 100 * MB, ""},
+			{"p-new-2", 4000, 100 * MB, ""},
+			{"p-new-3", 4000, 100 * MB, ""},
+		},
+		expectedScaleUp:      "ng2-1",
+		expectedScaleUpGroup: "ng2",
+		options:              defaultOptions,
+		networkCapacity:      map[string]int{"ng2": 1},
+	}
+
+	simpleScaleUpTest(t, config)
+}
+
+func TestScaleUpNetworkCapacityExhausted(t *testing.T) {
+	config := &scaleTestConfig{
+		nodes: []nodeConfig{
+			{"n1", 2000, 100 * MB, true, "ng1"},
+			{"n2", 4000, 1000 * MB, true, "ng2"},
+		},
+		pods: []podConfig{
+			{"p1", 1000, 0, "n1"},
+			{"p2", 3000, 0, "n2"},
+		},
+		extraPods: []podConfig{
+			{"p-new", 1500, 0, ""},
+		},
+		expectedScaleUp:      "ng2-1",
+		expectedScaleUpGroup: "ng2",
+		options:              defaultOptions,
+		networkCapacity:      map[string]int{"ng1": 0},
+	}
+
+	simpleScaleUpTest(t, config)
+}
+
+func TestApplyNetworkCapacityLimitsSharedNetwork(t *testing.T) {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNodeGroup("ng2", 0, 10, 0)
+	provider.AddNodeGroup("ng3", 0, 10, 0)
+	provider.AddNodeGroup("ng4", 0, 10, 0)
+	// ng1 and ng2 share a subnet with addresses for 5 nodes.
+	provider.SetNetworkCapacity("ng1", &cloudprovider.NetworkCapacity{Network: "subnet-1", Addresses: 10, AddressesPerNode: 2})
+	provider.SetNetworkCapacity("ng2", &cloudprovider.NetworkCapacity{Network: "subnet-1", Addresses: 10, AddressesPerNode: 2})
+	provider.SetNetworkCapacity("ng3", &cloudprovider.NetworkCapacity{Network: "subnet-2", Addresses: 1, AddressesPerNode: 1})
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(&fake.Clientset{}, "kube-system", kube_record.NewFakeRecorder(5), false)
+	context := &AutoscalingContext{
+		CloudProvider: provider,
+		LogRecorder:   fakeLogRecorder,
+	}
+	groups := make(map[string]cloudprovider.NodeGroup)
+	for _, group := range provider.NodeGroups() {
+		groups[group.Id()] = group
+	}
+	ng1, ng2, ng3, ng4 := groups["ng1"], groups["ng2"], groups["ng3"], groups["ng4"]
+
+	// The 2 nodes ng1 is still waiting for leave addresses for 3 more nodes in the subnet.
+	budgets := newNetworkBudgets(context, nil, provider.NodeGroups(), map[string]int{"ng1": 2})
+	infos := applyNetworkCapacityLimits(context, []nodegroupset.ScaleUpInfo{
+		{Group: ng1, CurrentSize: 2, NewSize: 4, MaxSize: 10},
+		{Group: ng2, CurrentSize: 0, NewSize: 3, MaxSize: 10},
+		{Group: ng3, CurrentSize: 0, NewSize: 2, MaxSize: 10},
+		{Group: ng4, CurrentSize: 0, NewSize: 5, MaxSize: 10},
+	}, budgets)
+	assert.Equal(t, []nodegroupset.ScaleUpInfo{
+		{Group: ng1, CurrentSize: 2, NewSize: 4, MaxSize: 10},
+		{Group: ng2, CurrentSize: 0, NewSize: 1, MaxSize: 10},
+		{Group: ng3, CurrentSize: 0, NewSize: 1, MaxSize: 10},
+		{Group: ng4, CurrentSize: 0, NewSize: 5, MaxSize: 10},
+	}, infos)
+
+	// Nothing is left for another scale-up in the same loop.
+	maxNodes, limited := budgets.maxNodes(ng2)
+	assert.True(t, limited)
+	assert.Equal(t, 0, maxNodes)
+	_, limited = budgets.maxNodes(ng4)
+	assert.False(t, limited)
+}
+
 func simpleScaleUpTest(t *testing.T, config *scaleTestConfig) {
 	expandedGroups := make(chan string, 10)
 	fakeClient := &fake.Clientset{}
@@ -217,6 +344,9 @@ func simpleScaleUpTest(t *testing.T, config *scaleTestConfig) {
 			provider.AddNode(name, n)
 		}
 	}
+	for name, capacity := range config.networkCapacity {
+		provider.SetNetworkCapacity(name, &cloudprovider.NetworkCapacity{Network: name, Addresses: capacity, AddressesPerNode: 1})
+	}
 
 	resourceLimiter := cloudprovider.NewResourceLimiter(
 		map[string]int64{cloudprovider.ResourceNameCores: config.options.MinCoresTotal, cloudprovider.ResourceNameMemory: config.options.MinMemoryTotal},
@@ -240,6 +370,7 @@ func simpleScaleUpTest(t *testing.T, config *scaleTestConfig) {
 		ExpanderStrategy:     random.NewStrategy(),
 		ClusterStateRegistry: clusterState,
 		LogRecorder:          fakeLogRecorder,
+		ScaleUpRateLimiter:   NewScaleUpRateLimiter(config.options.MaxNodesPerMinute),
 	}
 
 	extraPods := make([]*apiv1.Pod, len(config.extraPods))
@@ -248,7 +379,7 @@ func simpleScaleUpTest(t *testing.T, config *scaleTestConfig) {
 		extraPods[i] = pod
 	}
 
-	result, err := ScaleUp(context, extraPods, nodes, []*extensionsv1.DaemonSet{})
+	result, err := ScaleUp(context, extraPods, nodes, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, err)
 	assert.True(t, result)
 
@@ -329,7 +460,7 @@ func TestScaleUpNodeComingNoScale(t *testing.T) {
 	}
 	p3 := BuildTestPod("p-new", 550, 0)
 
-	result, err := ScaleUp(context, []*apiv1.Pod{p3}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{})
+	result, err := ScaleUp(context, []*apiv1.Pod{p3}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, err)
 	// A node is already coming - no need for scale up.
 	assert.False(t, result)
@@ -392,7 +523,7 @@ func TestScaleUpNodeComingHasScale(t *testing.T) {
 	}
 	p3 := BuildTestPod("p-new", 550, 0)
 
-	result, err := ScaleUp(context, []*apiv1.Pod{p3, p3}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{})
+	result, err := ScaleUp(context, []*apiv1.Pod{p3, p3}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, err)
 	// Twho nodes needed but one node is already coming, so it should increase by one.
 	assert.True(t, result)
@@ -452,7 +583,7 @@ func TestScaleUpUnhealthy(t *testing.T) {
 	}
 	p3 := BuildTestPod("p-new", 550, 0)
 
-	result, err := ScaleUp(context, []*apiv1.Pod{p3}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{})
+	result, err := ScaleUp(context, []*apiv1.Pod{p3}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, err)
 	// Node group is unhealthy.
 	assert.False(t, result)
@@ -503,7 +634,7 @@ func TestScaleUpNoHelp(t *testing.T) {
 	}
 	p3 := BuildTestPod("p-new", 500, 0)
 
-	result, err := ScaleUp(context, []*apiv1.Pod{p3}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{})
+	result, err := ScaleUp(context, []*apiv1.Pod{p3}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, err)
 	assert.False(t, result)
 	var event string
@@ -515,6 +646,121 @@ func TestScaleUpNoHelp(t *testing.T) {
 	assert.Regexp(t, regexp.MustCompile("NotTriggerScaleUp"), event)
 }
 
+func TestScaleUpOversizedPod(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	n1 := BuildTestNode("n1", 100, 1000)
+	SetNodeReadyState(n1, true, time.Now())
+
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		t.Fatalf("No expansion is expected")
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes([]*apiv1.Node{n1}, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+		OversizedPodsCache:   NewOversizedPodsCache(time.Hour),
+	}
+	p1 := BuildTestPod("p1", 500, 0)
+
+	result, err := ScaleUp(context, []*apiv1.Pod{p1}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	assert.False(t, result)
+	assert.Equal(t, "Normal NotTriggerScaleUp pod didn't trigger scale-up (no node group can ever satisfy requests: cpu 500m > max 100m)",
+		getStringFromChan(fakeRecorder.Events))
+
+	// The pod isn't checked against node groups and told again in the next loop.
+	result, err = ScaleUp(context, []*apiv1.Pod{p1}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	assert.False(t, result)
+	assertNoEvent(t, fakeRecorder)
+}
+
+func TestScaleUpDryRun(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	n1 := BuildTestNode("n1", 100, 1000)
+	SetNodeReadyState(n1, true, time.Now())
+
+	p1 := BuildTestPod("p1", 80, 0)
+	p1.Spec.NodeName = "n1"
+
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		list := action.(core.ListAction)
+		fieldstring := list.GetListRestrictions().Fields.String()
+		if strings.Contains(fieldstring, "n1") {
+			return true, &apiv1.PodList{Items: []apiv1.Pod{*p1}}, nil
+		}
+		return true, nil, fmt.Errorf("Failed to list: %v", list)
+	})
+
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		t.Fatalf("No expansion is expected in dry-run mode")
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	assert.NotNil(t, provider)
+
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes([]*apiv1.Node{n1}, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+			DryRun:         true,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+	p2 := BuildTestPod("p-new", 50, 0)
+
+	result, err := ScaleUp(context, []*apiv1.Pod{p2}, []*apiv1.Node{n1}, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, 0, clusterState.GetUpcomingNodes()["ng1"])
+	var event string
+	select {
+	case event = <-fakeRecorder.Events:
+	default:
+		t.Fatal("No Event recorded, expected DryRunTriggeredScaleUp event")
+	}
+	assert.Regexp(t, regexp.MustCompile("DryRunTriggeredScaleUp"), event)
+
+	// The planned delta is reported in the status until the next loop.
+	planned := clusterState.GetStatus(time.Now()).PlannedScaleUps
+	assert.Equal(t, []api.PlannedScaleUp{{NodeGroup: "ng1", CurrentSize: 1, NewSize: 2, Delta: 1, Pods: []string{"default/p-new"}}}, planned)
+	clusterState.ClearPlannedScaleUps()
+	assert.Empty(t, clusterState.GetStatus(time.Now()).PlannedScaleUps)
+}
+
 func TestScaleUpBalanceGroups(t *testing.T) {
 	fakeClient := &fake.Clientset{}
 	provider := testprovider.NewTestCloudProvider(func(string, int) error {
@@ -588,7 +834,7 @@ func TestScaleUpBalanceGroups(t *testing.T) {
 		pods = append(pods, BuildTestPod(fmt.Sprintf("test-pod-%v", i), 80, 0))
 	}
 
-	result, typedErr := ScaleUp(context, pods, nodes, []*extensionsv1.DaemonSet{})
+	result, typedErr := ScaleUp(context, pods, nodes, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, typedErr)
 	assert.True(t, result)
 	groupMap := make(map[string]cloudprovider.NodeGroup, 3)
@@ -647,7 +893,7 @@ func TestScaleUpAutoprovisionedNodeGroup(t *testing.T) {
 		LogRecorder:          fakeLogRecorder,
 	}
 
-	result, err := ScaleUp(context, []*apiv1.Pod{p1}, []*apiv1.Node{}, []*extensionsv1.DaemonSet{})
+	result, err := ScaleUp(context, []*apiv1.Pod{p1}, []*apiv1.Node{}, []*extensionsv1.DaemonSet{}, nil)
 	assert.NoError(t, err)
 	assert.True(t, result)
 	assert.Equal(t, "autoprovisioned-T1", getStringFromChan(createdGroups))
@@ -715,3 +961,323 @@ func TestAddAutoprovisionedCandidatesToMany(t *testing.T) {
 	assert.Equal(t, 1, len(nodeGroups))
 	assert.Equal(t, 1, len(nodeInfos))
 }
+
+func TestScaleUpZoneAntiAffinity(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	nodes := make([]*apiv1.Node, 0)
+	for _, zone := range []string{"a", "b"} {
+		nodeGroup := "ng-" + zone
+		node := BuildTestNode("n-"+zone, 1000, 1000)
+		node.Labels[kubeletapis.LabelZoneFailureDomain] = "zone-" + zone
+		SetNodeReadyState(node, true, time.Now())
+		provider.AddNodeGroup(nodeGroup, 1, 10, 1)
+		provider.AddNode(nodeGroup, node)
+		nodes = append(nodes, node)
+	}
+
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:  estimator.BinpackingEstimatorName,
+			MaxCoresTotal:  config.DefaultMaxClusterCores,
+			MaxMemoryTotal: config.DefaultMaxClusterMemory,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 3; i++ {
+		pod := BuildTestPod(fmt.Sprintf("p%d", i), 100, 0)
+		pod.Labels = map[string]string{"app": "db"}
+		pod.Spec.Affinity = &apiv1.Affinity{
+			PodAntiAffinity: &apiv1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []apiv1.PodAffinityTerm{
+					{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+						TopologyKey:   kubeletapis.LabelZoneFailureDomain,
+					},
+				},
+			},
+		}
+		pods = append(pods, pod)
+	}
+
+	result, err := ScaleUp(context, pods, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	assert.True(t, result)
+
+	// Each zone gets one node, the third pod has no zone left.
+	changes := make([]string, 0)
+	for len(sizeChanges) > 0 {
+		changes = append(changes, <-sizeChanges)
+	}
+	sort.Strings(changes)
+	assert.Equal(t, []string{"ng-a-1", "ng-b-1"}, changes)
+
+	triggered := 0
+	notTriggered := 0
+	for len(fakeRecorder.Events) > 0 {
+		event := <-fakeRecorder.Events
+		if strings.Contains(event, "TriggeredScaleUp") && !strings.Contains(event, "NotTriggerScaleUp") {
+			triggered++
+		}
+		if strings.Contains(event, "NotTriggerScaleUp") {
+			assert.Contains(t, event, "not enough zones")
+			notTriggered++
+		}
+	}
+	assert.Equal(t, 2, triggered)
+	assert.Equal(t, 1, notTriggered)
+}
+
+type conditionalTestNodeGroup struct {
+	*testprovider.TestNodeGroup
+	targetSize int
+}
+
+func (ng *conditionalTestNodeGroup) IncreaseSizeFrom(expectedSize int, delta int) error {
+	if expectedSize != ng.targetSize {
+		return &cloudprovider.TargetSizeChangedError{NodeGroup: ng.Id(), Expected: expectedSize, Actual: ng.targetSize}
+	}
+	return ng.IncreaseSize(delta)
+}
+
+func buildExecuteScaleUpTest(targetSize int) (*AutoscalingContext, *testprovider.TestNodeGroup, chan string) {
+	expandedGroups := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		expandedGroups <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, targetSize)
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	context := &AutoscalingContext{
+		CloudProvider:        provider,
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+	return context, provider.NodeGroups()[0].(*testprovider.TestNodeGroup), expandedGroups
+}
+
+func TestExecuteScaleUpTargetSizeChanged(t *testing.T) {
+	context, testGroup, expandedGroups := buildExecuteScaleUpTest(2)
+	// Another CA replica has already increased the size from 2 to 3, but the cached target size is still 2.
+	group := &conditionalTestNodeGroup{TestNodeGroup: testGroup, targetSize: 3}
+
+	// The increase done concurrently is enough, nothing more is requested.
+	err := executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 2, NewSize: 3, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(expandedGroups))
+	assert.Empty(t, context.ClusterStateRegistry.GetBackoffs(time.Now()))
+
+	// Only the rest of the increase is requested.
+	err = executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 2, NewSize: 5, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ng1-2", getStringFromChan(expandedGroups))
+
+	// A smaller target size aborts the scale-up, to be retried in the next loop without backoff.
+	group.targetSize = 1
+	testGroup.SetTargetSize(2)
+	err = executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 2, NewSize: 3, MaxSize: 10}, nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, errors.TransientError, err.Type())
+		assert.Contains(t, err.Error(), "expected 2, found 1")
+	}
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(expandedGroups))
+	assert.Empty(t, context.ClusterStateRegistry.GetBackoffs(time.Now()))
+}
+
+func TestExecuteScaleUpTargetSizeIncreasedExternally(t *testing.T) {
+	context, group, expandedGroups := buildExecuteScaleUpTest(2)
+	// The target size was increased from 2 to 3 after the scale-up to 5 was planned.
+	group.SetTargetSize(3)
+
+	err := executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 2, NewSize: 5, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ng1-2", getStringFromChan(expandedGroups))
+	size, _ := group.TargetSize()
+	assert.Equal(t, 5, size)
+
+	// The target size was increased beyond the planned size.
+	group.SetTargetSize(7)
+	err = executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 5, NewSize: 6, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(expandedGroups))
+	size, _ = group.TargetSize()
+	assert.Equal(t, 7, size)
+}
+
+func TestExecuteScaleUpTargetSizeDecreasedExternally(t *testing.T) {
+	context, group, expandedGroups := buildExecuteScaleUpTest(3)
+	// The target size was decreased from 3 to 2 after the scale-up to 5 was planned.
+	group.SetTargetSize(2)
+
+	err := executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 3, NewSize: 5, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ng1-2", getStringFromChan(expandedGroups))
+}
+
+func TestExecuteScaleUpRateLimitRefund(t *testing.T) {
+	context, testGroup, expandedGroups := buildExecuteScaleUpTest(2)
+	context.ScaleUpRateLimiter = NewScaleUpRateLimiter(10)
+	group := &conditionalTestNodeGroup{TestNodeGroup: testGroup, targetSize: 2}
+	takeTokens := func(increase int) {
+		context.ScaleUpRateLimiter.RegisterScaleUp("ng1", 0, increase, time.Now())
+	}
+	available := func() int {
+		return context.ScaleUpRateLimiter.Allowed("ng1", 0, 100, time.Now())
+	}
+
+	// Nodes added outside of CA reduce the increase, only the requested nodes keep their tokens.
+	takeTokens(3)
+	group.targetSize = 3
+	testGroup.SetTargetSize(3)
+	err := executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 2, NewSize: 5, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ng1-2", getStringFromChan(expandedGroups))
+	assert.Equal(t, 8, available())
+
+	// The increase reduced to 0 gives back all tokens.
+	takeTokens(2)
+	err = executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 3, NewSize: 5, MaxSize: 10}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(expandedGroups))
+	assert.Equal(t, 8, available())
+
+	// A scale-up aborted because of a smaller target size gives back all tokens.
+	takeTokens(2)
+	group.targetSize = 4
+	testGroup.SetTargetSize(5)
+	err = executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: group, CurrentSize: 5, NewSize: 7, MaxSize: 10}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 8, available())
+
+	// A failed resize gives back all tokens.
+	takeTokens(2)
+	failingProvider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		return fmt.Errorf("quota exceeded")
+	}, nil)
+	failingProvider.AddNodeGroup("ng1", 1, 10, 2)
+	err = executeScaleUp(context, nodegroupset.ScaleUpInfo{Group: failingProvider.NodeGroups()[0], CurrentSize: 2, NewSize: 4, MaxSize: 10}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 8, available())
+}
+
+func TestScaleUpPrefilter(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+	expandedGroups := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		expandedGroups <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	nodes := make([]*apiv1.Node, 0)
+	for i := 0; i < 80; i++ {
+		node := BuildTestNode(fmt.Sprintf("n%d", i), 1000, 1000)
+		node.Labels["pool"] = fmt.Sprintf("pool-%d", i)
+		SetNodeReadyState(node, true, time.Now())
+		provider.AddNodeGroup(fmt.Sprintf("ng%d", i), 1, 10, 1)
+		provider.AddNode(fmt.Sprintf("ng%d", i), node)
+		nodes = append(nodes, node)
+	}
+
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:           estimator.BinpackingEstimatorName,
+			MaxCoresTotal:           config.DefaultMaxClusterCores,
+			MaxMemoryTotal:          config.DefaultMaxClusterMemory,
+			ScaleUpPrefilterEnabled: true,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Spec.NodeSelector = map[string]string{"pool": "pool-57"}
+	result, err := ScaleUp(context, []*apiv1.Pod{p1}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, "ng57-1", getStringFromChan(expandedGroups))
+	assert.Regexp(t, regexp.MustCompile("TriggeredScaleUp"), <-fakeRecorder.Events)
+
+	p2 := BuildTestPod("p2", 800, 0)
+	p2.Spec.NodeSelector = map[string]string{"pool": "pool-80"}
+	result, err = ScaleUp(context, []*apiv1.Pod{p2}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	assert.False(t, result)
+	assert.Regexp(t, regexp.MustCompile("NotTriggerScaleUp"), <-fakeRecorder.Events)
+}
+
+func TestEstimateNodeCountWithCapacityMargin(t *testing.T) {
+	node := BuildTestNode("n1", 1000, 1000*1024*1024)
+	SetNodeReadyState(node, true, time.Now())
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 5; i++ {
+		pods = append(pods, BuildTestPod(fmt.Sprintf("p%d", i), 200, 100*1024*1024))
+	}
+	upcomingNode := BuildTestNode("upcoming", 1000, 1000*1024*1024)
+	SetNodeReadyState(upcomingNode, true, time.Now())
+	upcoming := schedulercache.NewNodeInfo()
+	upcoming.SetNode(upcomingNode)
+
+	for _, tc := range []struct {
+		margin   string
+		upcoming []*schedulercache.NodeInfo
+		expected int
+	}{
+		{margin: "", expected: 1},
+		{margin: "2%", expected: 2},
+		{margin: "cpu=100m", expected: 2},
+		{margin: "memory=700Mi", expected: 2},
+		{margin: "memory=701Mi", expected: 3},
+		// The margin applies to upcoming nodes as well.
+		{margin: "", upcoming: []*schedulercache.NodeInfo{upcoming}, expected: 0},
+		{margin: "2%", upcoming: []*schedulercache.NodeInfo{upcoming}, expected: 1},
+	} {
+		context := &AutoscalingContext{
+			AutoscalingOptions: AutoscalingOptions{EstimatorName: estimator.BinpackingEstimatorName},
+			PredicateChecker:   simulator.NewTestPredicateChecker(),
+		}
+		if tc.margin != "" {
+			margin, err := config.CapacityMarginFromString(tc.margin)
+			assert.NoError(t, err)
+			context.EstimatorCapacityMargin = margin
+		}
+		count, _, _ := estimateNodeCount(context, pods, nodeInfo, tc.upcoming)
+		assert.Equal(t, tc.expected, count, "margin %q", tc.margin)
+	}
+	// Template nodes are left untouched.
+	assert.Equal(t, int64(1000), nodeInfo.AllocatableResource().MilliCPU)
+}
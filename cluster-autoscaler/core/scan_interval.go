@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	"github.com/golang/glog"
+)
+
+// AdaptiveScanInterval decides how long to wait before the next loop. After a loop with pending pods
+// or scale-up or scale-down in progress, the interval drops to the minimum. After every quietLoops
+// consecutive loops with nothing to do, it doubles, up to the maximum. A wake-up cuts the wait
+// for the next loop short, so that a long interval doesn't delay a forced refresh.
+type AdaptiveScanInterval struct {
+	min        time.Duration
+	max        time.Duration
+	quietLoops int
+	current    time.Duration
+	quiet      int
+	wakeUp     chan struct{}
+}
+
+// NewAdaptiveScanInterval builds an AdaptiveScanInterval starting at the given interval. Equal
+// min and max give a fixed interval.
+func NewAdaptiveScanInterval(initial, min, max time.Duration, quietLoops int) (*AdaptiveScanInterval, error) {
+	if min <= 0 || min > initial || initial > max {
+		return nil, fmt.Errorf("scan interval bounds must satisfy 0 < min <= initial <= max, got min=%v initial=%v max=%v", min, initial, max)
+	}
+	if quietLoops <= 0 {
+		return nil, fmt.Errorf("number of quiet loops must be positive, got %d", quietLoops)
+	}
+	metrics.UpdateScanInterval(initial)
+	return &AdaptiveScanInterval{
+		min:        min,
+		max:        max,
+		quietLoops: quietLoops,
+		current:    initial,
+		wakeUp:     make(chan struct{}, 1),
+	}, nil
+}
+
+// Next returns the interval to wait for after a loop, given whether the loop had anything to do.
+func (s *AdaptiveScanInterval) Next(active bool) time.Duration {
+	previous := s.current
+	if active {
+		s.quiet = 0
+		s.current = s.min
+	} else {
+		s.quiet++
+		if s.quiet >= s.quietLoops {
+			s.quiet = 0
+			s.current *= 2
+			if s.current > s.max {
+				s.current = s.max
+			}
+		}
+	}
+	if s.current != previous {
+		glog.V(4).Infof("Scan interval changed from %v to %v", previous, s.current)
+		metrics.UpdateScanInterval(s.current)
+	}
+	return s.current
+}
+
+// WakeUp requests the next loop to start without waiting for the rest of the interval. Wake-ups
+// requested while a loop is running start the next loop as soon as it finishes. Several wake-ups
+// requested before the next loop starts result in a single loop.
+func (s *AdaptiveScanInterval) WakeUp() {
+	select {
+	case s.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// Wait waits for the given interval or until a wake-up is requested, whichever comes first.
+// Returns true if the wait was cut short by a wake-up.
+func (s *AdaptiveScanInterval) Wait(interval time.Duration) bool {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-s.wakeUp:
+		glog.V(1).Infof("Scan interval of %v cut short by a wake-up", interval)
+		return true
+	}
+}
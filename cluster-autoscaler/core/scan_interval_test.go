@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveScanInterval(t *testing.T) {
+	s, err := NewAdaptiveScanInterval(10*time.Second, 2*time.Second, time.Minute, 3)
+	assert.NoError(t, err)
+
+	// Activity of consecutive loops and the interval expected after each of them.
+	activity := []bool{false, false, false, true, true, false, false, false, false, false, false,
+		false, false, false, false, false, false, false, false, false, false, false, false, true}
+	expected := []time.Duration{10, 10, 20, 2, 2, 2, 2, 4, 4, 4, 8,
+		8, 8, 16, 16, 16, 32, 32, 32, 60, 60, 60, 60, 2}
+	for i, active := range activity {
+		assert.Equal(t, expected[i]*time.Second, s.Next(active), "loop %d", i)
+	}
+}
+
+func TestAdaptiveScanIntervalFixed(t *testing.T) {
+	s, err := NewAdaptiveScanInterval(10*time.Second, 10*time.Second, 10*time.Second, 1)
+	assert.NoError(t, err)
+	for _, active := range []bool{true, false, false, true} {
+		assert.Equal(t, 10*time.Second, s.Next(active))
+	}
+}
+
+func TestAdaptiveScanIntervalWakeUp(t *testing.T) {
+	s, err := NewAdaptiveScanInterval(10*time.Second, 2*time.Second, time.Minute, 3)
+	assert.NoError(t, err)
+
+	assert.False(t, s.Wait(time.Millisecond))
+
+	// Several wake-ups before the wait result in a single loop.
+	s.WakeUp()
+	s.WakeUp()
+	start := time.Now()
+	assert.True(t, s.Wait(time.Hour))
+	assert.True(t, time.Now().Sub(start) < time.Minute)
+	assert.False(t, s.Wait(time.Millisecond))
+
+	// A wake-up during the wait.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.WakeUp()
+	}()
+	assert.True(t, s.Wait(time.Hour))
+}
+
+func TestAdaptiveScanIntervalErrors(t *testing.T) {
+	_, err := NewAdaptiveScanInterval(10*time.Second, 0, time.Minute, 3)
+	assert.Error(t, err)
+	_, err = NewAdaptiveScanInterval(10*time.Second, 20*time.Second, time.Minute, 3)
+	assert.Error(t, err)
+	_, err = NewAdaptiveScanInterval(10*time.Second, time.Second, 5*time.Second, 3)
+	assert.Error(t, err)
+	_, err = NewAdaptiveScanInterval(10*time.Second, time.Second, time.Minute, 0)
+	assert.Error(t, err)
+}
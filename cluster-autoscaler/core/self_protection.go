@@ -0,0 +1,143 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// NodeNameEnvVar is the environment variable with the name of the node CA runs on, set from
+	// spec.nodeName with the downward API.
+	NodeNameEnvVar = "NODE_NAME"
+	// PodNameEnvVar is the environment variable with the name of the pod CA runs in, set from
+	// metadata.name with the downward API.
+	PodNameEnvVar = "POD_NAME"
+	// PodNamespaceEnvVar is the environment variable with the namespace of the pod CA runs in, set
+	// from metadata.namespace with the downward API. Defaults to --namespace.
+	PodNamespaceEnvVar = "POD_NAMESPACE"
+)
+
+// SelfIdentity identifies the pod CA runs in and the node it runs on, so that CA doesn't evict
+// itself in the middle of a scale-down. Fields are empty if they are unknown, e.g. when CA runs
+// outside of the cluster.
+type SelfIdentity struct {
+	PodNamespace string
+	PodName      string
+	NodeName     string
+}
+
+// GetSelfIdentity identifies the pod and the node of CA from the downward API environment
+// variables. If only the pod is known, its node is read from the pod's spec.nodeName.
+func GetSelfIdentity(client kube_client.Interface, getenv func(string) string, defaultNamespace string) SelfIdentity {
+	self := SelfIdentity{
+		PodName:  getenv(PodNameEnvVar),
+		NodeName: getenv(NodeNameEnvVar),
+	}
+	if self.PodName != "" {
+		self.PodNamespace = getenv(PodNamespaceEnvVar)
+		if self.PodNamespace == "" {
+			self.PodNamespace = defaultNamespace
+		}
+	}
+	if self.NodeName == "" && self.PodName != "" {
+		pod, err := client.CoreV1().Pods(self.PodNamespace).Get(self.PodName, metav1.GetOptions{})
+		if err != nil {
+			glog.Warningf("Failed to get pod %s/%s to find the node cluster autoscaler runs on: %v", self.PodNamespace, self.PodName, err)
+		} else {
+			self.NodeName = pod.Spec.NodeName
+		}
+	}
+	if self.NodeName == "" {
+		glog.V(1).Infof("Node cluster autoscaler runs on is unknown, it won't be protected in scale-down")
+	} else {
+		glog.V(1).Infof("Cluster autoscaler runs on node %s", self.NodeName)
+	}
+	return self
+}
+
+// isSelfNode returns true if CA runs on the node.
+func (s SelfIdentity) isSelfNode(node *apiv1.Node) bool {
+	return s.NodeName != "" && node.Name == s.NodeName
+}
+
+// isSelfPod returns true if the pod is the pod CA runs in.
+func (s SelfIdentity) isSelfPod(pod *apiv1.Pod) bool {
+	return s.PodName != "" && pod.Name == s.PodName && pod.Namespace == s.PodNamespace
+}
+
+// orderSelfNodeLast moves the node CA runs on to the end of scale-down candidates, so that it is
+// removed only if no other candidate can be.
+func orderSelfNodeLast(candidates []*apiv1.Node, self SelfIdentity) []*apiv1.Node {
+	for i, node := range candidates {
+		if !self.isSelfNode(node) {
+			continue
+		}
+		result := make([]*apiv1.Node, 0, len(candidates))
+		result = append(result, candidates[:i]...)
+		result = append(result, candidates[i+1:]...)
+		return append(result, node)
+	}
+	return candidates
+}
+
+// withoutSelfPod returns the pods to evict from the node CA runs on without the pod of CA. CA isn't
+// evicted: it terminates with the node, after all other pods are evicted and the node is deleted.
+func withoutSelfPod(pods []*apiv1.Pod, self SelfIdentity) []*apiv1.Pod {
+	result := make([]*apiv1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if !self.isSelfPod(pod) {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
+
+// otherNodesBeingDeleted returns names of nodes other than the node CA runs on that are being
+// deleted. The node CA runs on is deleted only after them, so that CA doesn't leave them tainted.
+func otherNodesBeingDeleted(nodes []*apiv1.Node, self SelfIdentity) []string {
+	result := make([]string, 0)
+	for _, node := range nodes {
+		if !self.isSelfNode(node) && deletetaint.HasToBeDeletedTaint(node) {
+			result = append(result, node.Name)
+		}
+	}
+	return result
+}
+
+// podsToEvictBeforeRemoval returns the pods to evict from the node before it's removed. The node CA
+// runs on is drained without the pod of CA, and only once no other node is being deleted. Returns
+// false if the node can't be removed yet.
+func podsToEvictBeforeRemoval(toRemove simulator.NodeToBeRemoved, allNodes []*apiv1.Node, self SelfIdentity) ([]*apiv1.Pod, bool) {
+	pods := podsToEvict(toRemove)
+	if !self.isSelfNode(toRemove.Node) {
+		return pods, true
+	}
+	if beingDeleted := otherNodesBeingDeleted(allNodes, self); len(beingDeleted) > 0 {
+		glog.V(1).Infof("Not removing node %s cluster autoscaler runs on until other nodes are deleted: %v", toRemove.Node.Name, beingDeleted)
+		return nil, false
+	}
+	glog.V(0).Infof("Scale-down: removing node %s cluster autoscaler runs on, it will be restarted elsewhere", toRemove.Node.Name)
+	return withoutSelfPod(pods, self), true
+}
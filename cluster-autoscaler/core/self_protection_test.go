@@ -0,0 +1,289 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestGetSelfIdentity(t *testing.T) {
+	caPod := BuildTestPod("cluster-autoscaler", 100, 0)
+	caPod.Namespace = "kube-system"
+	caPod.Spec.NodeName = "n1"
+	fakeClient := fake.NewSimpleClientset(caPod)
+
+	// Running outside of the cluster.
+	assert.Equal(t, SelfIdentity{}, GetSelfIdentity(fakeClient, testEnv(nil), "kube-system"))
+
+	assert.Equal(t, SelfIdentity{NodeName: "n1"},
+		GetSelfIdentity(fakeClient, testEnv(map[string]string{NodeNameEnvVar: "n1"}), "kube-system"))
+
+	// The node is read from the pod.
+	assert.Equal(t, SelfIdentity{PodNamespace: "kube-system", PodName: "cluster-autoscaler", NodeName: "n1"},
+		GetSelfIdentity(fakeClient, testEnv(map[string]string{PodNameEnvVar: "cluster-autoscaler"}), "kube-system"))
+
+	assert.Equal(t, SelfIdentity{PodNamespace: "autoscaling", PodName: "cluster-autoscaler", NodeName: "n2"},
+		GetSelfIdentity(fakeClient, testEnv(map[string]string{
+			PodNameEnvVar:      "cluster-autoscaler",
+			PodNamespaceEnvVar: "autoscaling",
+			NodeNameEnvVar:     "n2",
+		}), "kube-system"))
+
+	// The pod doesn't exist.
+	assert.Equal(t, SelfIdentity{PodNamespace: "autoscaling", PodName: "cluster-autoscaler"},
+		GetSelfIdentity(fakeClient, testEnv(map[string]string{
+			PodNameEnvVar:      "cluster-autoscaler",
+			PodNamespaceEnvVar: "autoscaling",
+		}), "kube-system"))
+}
+
+func TestOrderSelfNodeLast(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	candidates := []*apiv1.Node{n1, n2, n3}
+
+	assert.Equal(t, []*apiv1.Node{n2, n3, n1}, orderSelfNodeLast(candidates, SelfIdentity{NodeName: "n1"}))
+	assert.Equal(t, []*apiv1.Node{n1, n3, n2}, orderSelfNodeLast(candidates, SelfIdentity{NodeName: "n2"}))
+	assert.Equal(t, candidates, orderSelfNodeLast(candidates, SelfIdentity{NodeName: "n3"}))
+	assert.Equal(t, candidates, orderSelfNodeLast(candidates, SelfIdentity{NodeName: "n4"}))
+	// Running outside of the cluster.
+	assert.Equal(t, candidates, orderSelfNodeLast(candidates, SelfIdentity{}))
+	// The input isn't modified.
+	assert.Equal(t, []*apiv1.Node{n1, n2, n3}, candidates)
+}
+
+func TestWithoutSelfPod(t *testing.T) {
+	p1 := BuildTestPod("cluster-autoscaler", 100, 0)
+	p1.Namespace = "kube-system"
+	p2 := BuildTestPod("cluster-autoscaler", 100, 0)
+	p2.Namespace = "default"
+	p3 := BuildTestPod("p3", 100, 0)
+	pods := []*apiv1.Pod{p1, p2, p3}
+
+	assert.Equal(t, []*apiv1.Pod{p2, p3}, withoutSelfPod(pods, SelfIdentity{PodNamespace: "kube-system", PodName: "cluster-autoscaler"}))
+	assert.Equal(t, pods, withoutSelfPod(pods, SelfIdentity{}))
+}
+
+// buildSelfProtectionTest builds a scale-down of n1 with the CA pod, n2 with a pod of the same size
+// and n3 with a bigger pod, where either n1 or n2 can be removed.
+func buildSelfProtectionTest(self SelfIdentity) (*ScaleDown, []*apiv1.Node, []*apiv1.Pod, chan string, chan string) {
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Time{})
+	SetNodeReadyState(n2, true, time.Time{})
+	SetNodeReadyState(n3, true, time.Time{})
+	// In kube-system the pod would need a PodDisruptionBudget to be moved.
+	caPod := BuildTestPod("cluster-autoscaler", 400, 0)
+	caPod.Namespace = "autoscaling"
+	caPod.OwnerReferences = ownerRef
+	caPod.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 400, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+	p3 := BuildTestPod("p3", 600, 0)
+	p3.OwnerReferences = ownerRef
+	p3.Spec.NodeName = "n3"
+	nodes := []*apiv1.Node{n1, n2, n3}
+	pods := []*apiv1.Pod{caPod, p2, p3}
+
+	deletedNodes := make(chan string, 10)
+	evictedPods := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("create", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "eviction" {
+			evictedPods <- action.(core.CreateAction).GetObject().(*policyv1.Eviction).Name
+		}
+		return true, nil, nil
+	})
+	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewNotFound(apiv1.Resource("pod"), "whatever")
+	})
+	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		getAction := action.(core.GetAction)
+		for _, node := range nodes {
+			if node.Name == getAction.GetName() {
+				return true, node, nil
+			}
+		}
+		return true, nil, fmt.Errorf("Wrong node: %v", getAction.GetName())
+	})
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	provider := testprovider.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deletedNodes <- node
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	for _, node := range nodes {
+		provider.AddNode("ng1", node)
+	}
+
+	fakeRecorder := kube_util.CreateEventRecorder(fakeClient)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ScaleDownUtilizationThreshold: 0.5,
+			ScaleDownUnneededTime:         time.Minute,
+			MaxGracefulTerminationSec:     60,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		LogRecorder:          fakeLogRecorder,
+		Self:                 self,
+	}
+	return NewScaleDown(context), nodes, pods, deletedNodes, evictedPods
+}
+
+func TestScaleDownSelfNodeLast(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		self    SelfIdentity
+		removed string
+		evicted string
+	}{
+		{
+			name:    "outside of the cluster",
+			self:    SelfIdentity{},
+			removed: "n1",
+			evicted: "cluster-autoscaler",
+		},
+		{
+			name:    "on n1",
+			self:    SelfIdentity{PodNamespace: "autoscaling", PodName: "cluster-autoscaler", NodeName: "n1"},
+			removed: "n2",
+			evicted: "p2",
+		},
+	} {
+		scaleDown, nodes, pods, deletedNodes, evictedPods := buildSelfProtectionTest(tc.self)
+		scaleDown.UpdateUnneededNodes(nodes, nodes, pods, time.Now().Add(-5*time.Minute), nil)
+		result, err := scaleDown.TryToScaleDown(nodes, pods, nil, time.Now())
+		waitForDeleteToFinish(t, scaleDown)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, ScaleDownNodeDeleteStarted, result, tc.name)
+		assert.Equal(t, tc.removed, getStringFromChan(deletedNodes), tc.name)
+		assert.Equal(t, tc.evicted, getStringFromChan(evictedPods), tc.name)
+	}
+}
+
+func TestScaleDownSelfNodeWithoutSelfEviction(t *testing.T) {
+	self := SelfIdentity{PodNamespace: "autoscaling", PodName: "cluster-autoscaler", NodeName: "n1"}
+	scaleDown, nodes, pods, deletedNodes, evictedPods := buildSelfProtectionTest(self)
+	// n2 can't be removed, so n1 is removed, with the CA pod terminated with the node.
+	scaleDown.context.AutoscalingOptions.ScaleDownUtilizationThreshold = 0.45
+	pods[1].Spec.Containers[0].Resources.Requests[apiv1.ResourceCPU] = *resource.NewMilliQuantity(500, resource.DecimalSI)
+
+	scaleDown.UpdateUnneededNodes(nodes, nodes, pods, time.Now().Add(-5*time.Minute), nil)
+	result, err := scaleDown.TryToScaleDown(nodes, pods, nil, time.Now())
+	waitForDeleteToFinish(t, scaleDown)
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+	assert.Equal(t, "n1", getStringFromChan(deletedNodes))
+	assert.Empty(t, evictedPods)
+}
+
+func TestScaleDownSelfNodeAfterOtherDeletions(t *testing.T) {
+	self := SelfIdentity{PodNamespace: "autoscaling", PodName: "cluster-autoscaler", NodeName: "n1"}
+	scaleDown, nodes, pods, deletedNodes, _ := buildSelfProtectionTest(self)
+	// n2 is still being deleted.
+	nodes[1].Spec.Taints = []apiv1.Taint{{
+		Key:    deletetaint.ToBeDeletedTaint,
+		Value:  strconv.FormatInt(time.Now().Unix(), 10),
+		Effect: apiv1.TaintEffectNoSchedule,
+	}}
+
+	scaleDown.UpdateUnneededNodes(nodes, nodes, pods, time.Now().Add(-5*time.Minute), nil)
+	result, err := scaleDown.TryToScaleDown(nodes, pods, nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(deletedNodes))
+}
+
+func TestEnforceNodeGroupMaxSizeSelfNode(t *testing.T) {
+	self := SelfIdentity{PodNamespace: "autoscaling", PodName: "cluster-autoscaler", NodeName: "n1"}
+
+	// n1 and n2 are equally utilized, n2 is removed first.
+	scaleDown, nodes, pods, deletedNodes, evictedPods := buildSelfProtectionTest(self)
+	scaleDown.context.CloudProvider.(*testprovider.TestCloudProvider).AddNodeGroup("ng1", 1, 2, 3)
+	result, err := scaleDown.EnforceNodeGroupMaxSize(nodes, pods, nil, time.Now())
+	waitForDeleteToFinish(t, scaleDown)
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+	assert.Equal(t, "n2", getStringFromChan(deletedNodes))
+	assert.Equal(t, "p2", getStringFromChan(evictedPods))
+
+	// Pods without controllers keep n2 and n3, so only n1 can be removed. The CA pod is terminated with the node.
+	scaleDown, nodes, pods, deletedNodes, evictedPods = buildSelfProtectionTest(self)
+	scaleDown.context.CloudProvider.(*testprovider.TestCloudProvider).AddNodeGroup("ng1", 1, 2, 3)
+	pods[1].OwnerReferences = nil
+	pods[2].OwnerReferences = nil
+	result, err = scaleDown.EnforceNodeGroupMaxSize(nodes, pods, nil, time.Now())
+	waitForDeleteToFinish(t, scaleDown)
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+	assert.Equal(t, "n1", getStringFromChan(deletedNodes))
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(evictedPods))
+
+	// n1 isn't removed while n3 is being deleted.
+	scaleDown, nodes, pods, deletedNodes, _ = buildSelfProtectionTest(self)
+	scaleDown.context.CloudProvider.(*testprovider.TestCloudProvider).AddNodeGroup("ng1", 1, 2, 3)
+	pods[1].OwnerReferences = nil
+	pods[2].OwnerReferences = nil
+	nodes[2].Spec.Taints = []apiv1.Taint{{
+		Key:    deletetaint.ToBeDeletedTaint,
+		Value:  strconv.FormatInt(time.Now().Unix(), 10),
+		Effect: apiv1.TaintEffectNoSchedule,
+	}}
+	result, err = scaleDown.EnforceNodeGroupMaxSize(nodes, pods, nil, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(deletedNodes))
+}
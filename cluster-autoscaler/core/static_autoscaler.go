@@ -19,13 +19,17 @@ package core
 import (
 	"time"
 
+	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
 	"k8s.io/autoscaler/cluster-autoscaler/metrics"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 	kube_client "k8s.io/client-go/kubernetes"
 	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
@@ -40,6 +44,8 @@ type StaticAutoscaler struct {
 	lastScaleDownDeleteTime time.Time
 	lastScaleDownFailTime   time.Time
 	scaleDown               *ScaleDown
+	optionsHash             string
+	lastLoopActive          bool
 }
 
 // NewStaticAutoscaler creates an instance of Autoscaler filled with provided parameters
@@ -72,7 +78,11 @@ func NewStaticAutoscaler(opts AutoscalingOptions, predicateChecker *simulator.Pr
 // CleanUp cleans up ToBeDeleted taints added by the previously run and then failed CA
 func (a *StaticAutoscaler) CleanUp() {
 	// CA can die at any time. Removing taints that might have been left from the previous run.
-	if readyNodes, err := a.ReadyNodeLister().List(); err != nil {
+	// In dry-run mode CA never adds the taints, so there is nothing to clean up.
+	if a.DryRun {
+		return
+	}
+	if readyNodes, err := a.ReadyNodeLister().List(); err == nil {
 		cleanToBeDeleted(readyNodes, a.AutoscalingContext.ClientSet, a.Recorder)
 	}
 }
@@ -94,6 +104,12 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 	runStart := time.Now()
 
 	glog.V(4).Info("Starting main loop")
+	a.lastLoopActive = false
+	a.ClusterStateRegistry.ClearPlannedScaleUps()
+
+	autoscalingContext.NodeGroupConfigProcessor.Reload(&autoscalingContext.AutoscalingOptions, currentTime)
+	autoscalingContext.ClusterStateRegistry.SetActiveTimeProfile(autoscalingContext.NodeGroupConfigProcessor.ActiveTimeProfile())
+	a.updateBuildInfo()
 
 	err := autoscalingContext.CloudProvider.Refresh()
 	if err != nil {
@@ -123,6 +139,8 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		return nil
 	}
 
+	allNodes, readyNodes = gpu.FilterOutNodesWithUnreadyAccelerators(allNodes, readyNodes)
+
 	err = a.ClusterStateRegistry.UpdateNodes(allNodes, currentTime)
 	if err != nil {
 		glog.Errorf("Failed to update node registry: %v", err)
@@ -130,6 +148,13 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		return errors.ToAutoscalerError(errors.CloudProviderError, err)
 	}
 	UpdateClusterStateMetrics(a.ClusterStateRegistry)
+	// Nodes that scale-ups failed to add don't count against the scale-up rate limits.
+	for nodeGroupId, count := range a.ClusterStateRegistry.TakeFailedScaleUpNodes() {
+		if a.ScaleUpRateLimiter != nil {
+			a.ScaleUpRateLimiter.Refund(nodeGroupId, count, currentTime)
+		}
+	}
+	handleAllocatableChanges(autoscalingContext, scaleDown, autoscalingContext.NodeAllocatableTracker.Update(allNodes))
 
 	// Update status information when the loop is done (regardless of reason)
 	defer func() {
@@ -145,6 +170,10 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		return nil
 	}
 
+	if autoscalingContext.ToBeDeletedTaintTTL > 0 && !autoscalingContext.DryRun {
+		scaleDown.CleanUpStaleToBeDeletedTaints(allNodes, currentTime)
+	}
+
 	metrics.UpdateDurationFromStart(metrics.UpdateState, runStart)
 	metrics.UpdateLastTime(metrics.Autoscaling, time.Now())
 
@@ -171,6 +200,19 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		}
 	}
 
+	// Nodes whose instances no longer exist, for example reclaimed preemptible instances, stay in
+	// Kubernetes until the node controller removes them. They provide no capacity, and pods running
+	// on them are treated as pending.
+	deletedInstanceNodes := a.ClusterStateRegistry.GetDeletedInstanceNodes()
+	if len(deletedInstanceNodes) > 0 {
+		glog.V(1).Infof("%d nodes without instances present", len(deletedInstanceNodes))
+		if a.DeletedInstanceNodeRemovalTime > 0 {
+			removeOldDeletedInstanceNodes(deletedInstanceNodes, autoscalingContext, currentTime, autoscalingContext.LogRecorder)
+		}
+		allNodes = filterOutDeletedInstanceNodes(allNodes, deletedInstanceNodes)
+		readyNodes = filterOutDeletedInstanceNodes(readyNodes, deletedInstanceNodes)
+	}
+
 	// Check if there has been a constant difference between the number of nodes in k8s and
 	// the number of nodes on the cloud provider side.
 	// TODO: andrewskim - add protection for ready AWS nodes.
@@ -183,6 +225,17 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		glog.V(0).Infof("Some node group target size was fixed, skipping the iteration")
 		return nil
 	}
+	if a.ReconcileTargetSize {
+		reconciled, err := reconcileTargetSizes(autoscalingContext, currentTime)
+		if err != nil {
+			glog.Errorf("Failed to reconcile node group target sizes: %v", err)
+			return errors.ToAutoscalerError(errors.CloudProviderError, err)
+		}
+		if reconciled {
+			glog.V(0).Infof("Some node group target size was reconciled, skipping the iteration")
+			return nil
+		}
+	}
 
 	allUnschedulablePods, err := unschedulablePodLister.List()
 	if err != nil {
@@ -199,6 +252,16 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 
 	ConfigurePredicateCheckerForLoop(allUnschedulablePods, allScheduled, a.PredicateChecker)
 
+	// Nodes going through graceful shutdown are already being drained by kubelet. They are not
+	// considered for scale-down nor as a place for other pods, and pods running on them are
+	// treated as pending so that replacement capacity is provisioned promptly.
+	availableNodes := filterOutShuttingDownNodes(readyNodes)
+	departingPods := getPodsOnShuttingDownNodes(allNodes, allScheduled)
+	departingPods = append(departingPods, getPodsOnDeletedInstanceNodes(deletedInstanceNodes, allScheduled)...)
+	if len(departingPods) > 0 {
+		glog.V(1).Infof("%d pods are running on nodes that are shutting down or without instances", len(departingPods))
+	}
+
 	// We need to check whether pods marked as unschedulable are actually unschedulable.
 	// It's likely we added a new node and the scheduler just haven't managed to put the
 	// pod on in yet. In this situation we don't want to trigger another scale-up.
@@ -219,11 +282,16 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 	// Some unschedulable pods can be waiting for lower priority pods preemption so they have nominated node to run.
 	// Such pods don't require scale up but should be considered during scale down.
 	unschedulablePods, unschedulableWaitingForLowerPriorityPreemption := FilterOutExpendableAndSplit(allUnschedulablePods, a.ExpendablePodsPriorityCutoff)
+	unschedulablePods = append(unschedulablePods, FilterOutExpendablePods(departingPods, a.ExpendablePodsPriorityCutoff)...)
+
+	if a.UnschedulablePodLimiter != nil {
+		unschedulablePods = a.UnschedulablePodLimiter.Limit(unschedulablePods)
+	}
 
 	glog.V(4).Infof("Filtering out schedulables")
 	filterOutSchedulableStart := time.Now()
-	unschedulablePodsToHelp := FilterOutSchedulable(unschedulablePods, readyNodes, allScheduled,
-		unschedulableWaitingForLowerPriorityPreemption, a.PredicateChecker, a.ExpendablePodsPriorityCutoff)
+	unschedulablePodsToHelp := FilterOutSchedulableWithCache(unschedulablePods, availableNodes, allScheduled,
+		unschedulableWaitingForLowerPriorityPreemption, a.PredicateChecker, a.ExpendablePodsPriorityCutoff, a.PodEquivalenceCache)
 	metrics.UpdateDurationFromStart(metrics.FilterOutSchedulable, filterOutSchedulableStart)
 
 	if len(unschedulablePodsToHelp) != len(unschedulablePods) {
@@ -233,10 +301,112 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		glog.V(4).Info("No schedulable pods")
 	}
 
+	if a.NamespaceScaleUpOptOutFilter != nil {
+		unschedulablePodsToHelp = a.NamespaceScaleUpOptOutFilter.FilterOutOptedOutPods(unschedulablePodsToHelp, currentTime)
+	}
+
+	if a.EstimateShortfallTracker != nil {
+		a.EstimateShortfallTracker.Update(a.ClusterStateRegistry.GetUpcomingNodes(), unschedulablePodsToHelp)
+	}
+
+	if a.PodScaleUpDelayFilter != nil {
+		a.PodScaleUpDelayFilter.ObservePods(allScheduled, currentTime)
+		unschedulablePodsToHelp = a.PodScaleUpDelayFilter.FilterOutNewPods(unschedulablePodsToHelp, currentTime)
+	}
+
+	// Pods that will get a place by preempting lower priority pods don't need new nodes,
+	// but the preempted pods will.
+	if a.ConsiderPreemptionInScaleUp && len(unschedulablePodsToHelp) > 0 {
+		podsToHelp, victims := FilterOutPreemptingPods(unschedulablePodsToHelp, availableNodes, allScheduled,
+			unschedulableWaitingForLowerPriorityPreemption, a.PredicateChecker, a.ExpendablePodsPriorityCutoff)
+		if len(podsToHelp) != len(unschedulablePodsToHelp) {
+			glog.V(2).Infof("%d pods will preempt lower priority pods, %d preempted pods need to be rescheduled",
+				len(unschedulablePodsToHelp)-len(podsToHelp), len(victims))
+			schedulablePodsPresent = true
+		}
+		unschedulablePodsToHelp = append(podsToHelp, FilterOutExpendablePods(victims, a.ExpendablePodsPriorityCutoff)...)
+	}
+
+	// Headroom that doesn't fit on existing nodes is requested from scale-up like pending pods,
+	// and nodes holding it are kept from scale-down.
+	// Capacity reserved for namespaces is kept the same way, after the headroom.
+	headroom := &headroom{reservedNodes: make(map[string]bool)}
+	// Node infos of node groups built for headroom are reused by scale-up.
+	var nodeInfos map[string]*schedulercache.NodeInfo
+	var reservations []*config.CapacityReservation
+	if a.CapacityReservationSource != nil {
+		var err error
+		reservations, err = a.CapacityReservationSource.CapacityReservations()
+		if err != nil {
+			glog.Warningf("Failed to get capacity reservations: %v", err)
+		} else if len(reservations) == 0 {
+			a.ClusterStateRegistry.UpdateCapacityReservations(nil, currentTime)
+		}
+	}
+	if len(a.HeadroomSpecs) > 0 || len(reservations) > 0 {
+		daemonsets, err := a.ListerRegistry.DaemonSetLister().List()
+		if err != nil {
+			glog.Errorf("Failed to get daemonset list")
+			return errors.ToAutoscalerError(errors.ApiCallError, err)
+		}
+		var typedErr errors.AutoscalerError
+		nodeInfos, typedErr = GetNodeInfosForGroups(availableNodes, autoscalingContext.CloudProvider, autoscalingContext.ClientSet, daemonsets,
+			autoscalingContext.TemplateNodeInfoCache, autoscalingContext.NodeAllocatableTracker, autoscalingContext.IgnoredTaints)
+		if typedErr != nil {
+			return typedErr.AddPrefix("failed to build node infos for headroom: ")
+		}
+		scheduledForHeadroom := append(FilterOutExpendablePods(allScheduled, a.ExpendablePodsPriorityCutoff),
+			unschedulableWaitingForLowerPriorityPreemption...)
+		if len(a.HeadroomSpecs) > 0 {
+			headroom = computeHeadroom(a.HeadroomSpecs, availableNodes, scheduledForHeadroom, nodeInfos, a.PredicateChecker,
+				a.ExpendablePodsPriorityCutoff)
+			a.ClusterStateRegistry.UpdateHeadroom(headroom.statuses, currentTime)
+			unschedulablePodsToHelp = append(unschedulablePodsToHelp, headroom.pending...)
+		}
+		if len(reservations) > 0 {
+			reserved := computeCapacityReservations(reservations, availableNodes, append(scheduledForHeadroom, headroom.placed...),
+				nodeInfos, autoscalingContext.CloudProvider)
+			a.ClusterStateRegistry.UpdateCapacityReservations(reserved.statuses, currentTime)
+			unschedulablePodsToHelp = append(unschedulablePodsToHelp, reserved.pending...)
+			headroom.placed = append(headroom.placed, reserved.placed...)
+			for name := range reserved.reservedNodes {
+				headroom.reservedNodes[name] = true
+			}
+		}
+	}
+
+	if autoscalingContext.CapacityForecaster != nil {
+		daemonsets, err := a.ListerRegistry.DaemonSetLister().List()
+		if err == nil {
+			err = autoscalingContext.CapacityForecaster.UpdateSnapshot(autoscalingContext, availableNodes, allScheduled,
+				unschedulableWaitingForLowerPriorityPreemption, daemonsets, currentTime)
+		}
+		if err != nil {
+			glog.Warningf("Failed to update capacity forecast snapshot: %v", err)
+		}
+	}
+
+	if autoscalingContext.CrashReporter != nil {
+		autoscalingContext.CrashReporter.UpdateLoop(buildLoopSummary(autoscalingContext, allNodes, unschedulablePodsToHelp, currentTime))
+	}
+
+	a.lastLoopActive = len(unschedulablePodsToHelp) > 0 || scaleDown.nodeDeleteStatus.IsDeleteInProgress()
+	for _, upcoming := range a.ClusterStateRegistry.GetUpcomingNodes() {
+		if upcoming > 0 {
+			a.lastLoopActive = true
+		}
+	}
+
 	if len(unschedulablePodsToHelp) == 0 {
 		glog.V(1).Info("No unschedulable pods")
+		reportPodsBlockedByLimits(autoscalingContext, nil, currentTime)
 	} else if a.MaxNodesTotal > 0 && len(readyNodes) >= a.MaxNodesTotal {
 		glog.V(1).Info("Max total nodes in cluster reached")
+		blockedByLimits := make(map[*apiv1.Pod]scaleUpLimit)
+		for _, pod := range unschedulablePodsToHelp {
+			blockedByLimits[pod] = maxNodesTotalLimit(len(readyNodes), a.MaxNodesTotal)
+		}
+		reportPodsBlockedByLimits(autoscalingContext, blockedByLimits, currentTime)
 	} else {
 		daemonsets, err := a.ListerRegistry.DaemonSetLister().List()
 		if err != nil {
@@ -247,14 +417,14 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 		scaleUpStart := time.Now()
 		metrics.UpdateLastTime(metrics.ScaleUp, scaleUpStart)
 
-		scaledUp, typedErr := ScaleUp(autoscalingContext, unschedulablePodsToHelp, readyNodes, daemonsets)
+		scaledUp, typedErr := ScaleUp(autoscalingContext, unschedulablePodsToHelp, availableNodes, daemonsets, nodeInfos)
 
 		metrics.UpdateDurationFromStart(metrics.ScaleUp, scaleUpStart)
 
 		if typedErr != nil {
 			glog.Errorf("Failed to scale up: %v", typedErr)
 			return typedErr
-		} else if scaledUp {
+		} else if scaledUp && !autoscalingContext.DryRun {
 			a.lastScaleUpTime = currentTime
 			// No scale down in this iteration.
 			return nil
@@ -268,20 +438,42 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 			return errors.ToAutoscalerError(errors.ApiCallError, err)
 		}
 
+		// Pods of deleted DaemonSets are going away and can't be evicted, so they neither keep
+		// nodes from scale-down nor count into their utilization.
+		scaleDownScheduled, orphanedDaemonSetPods, err := filterOutOrphanedDaemonSetPods(allScheduled, a.DaemonSetLister())
+		if err != nil {
+			glog.Errorf("Failed to get daemonset list")
+			return errors.ToAutoscalerError(errors.ApiCallError, err)
+		}
+		recordOrphanedDaemonSetPods(autoscalingContext, allNodes, orphanedDaemonSetPods, scaleDown.reportedOrphanedDaemonSetPods)
+
 		unneededStart := time.Now()
 
 		glog.V(4).Infof("Calculating unneeded nodes")
 
 		scaleDown.CleanUp(currentTime)
-		potentiallyUnneeded := getPotentiallyUnneededNodes(autoscalingContext, allNodes)
+		potentiallyUnneeded := filterOutReservedNodes(getPotentiallyUnneededNodes(autoscalingContext, allNodes), headroom.reservedNodes)
 
-		typedErr := scaleDown.UpdateUnneededNodes(allNodes, potentiallyUnneeded, append(allScheduled, unschedulableWaitingForLowerPriorityPreemption...), currentTime, pdbs)
+		scaleDownPods := append(append(scaleDownScheduled, unschedulableWaitingForLowerPriorityPreemption...), headroom.placed...)
+		typedErr := scaleDown.UpdateUnneededNodes(filterOutShuttingDownNodes(allNodes), potentiallyUnneeded, scaleDownPods, currentTime, pdbs)
 		if typedErr != nil {
 			glog.Errorf("Failed to scale down: %v", typedErr)
 			return typedErr
 		}
 
+		if a.ScaleDownOrphanNodes {
+			if typedErr := scaleDown.UpdateOrphanNodes(filterOutShuttingDownNodes(allNodes), scaleDownPods, currentTime); typedErr != nil {
+				glog.Errorf("Failed to find orphan nodes: %v", typedErr)
+				return typedErr
+			}
+		}
+
+		if a.CompactionEvictionsPerHour > 0 {
+			scaleDown.UpdateCompactionCandidates(filterOutShuttingDownNodes(allNodes), scaleDownPods, currentTime)
+		}
+
 		metrics.UpdateDurationFromStart(metrics.FindUnneeded, unneededStart)
+		autoscalingContext.CrashReporter.UpdateUnneededNodes(scaleDown.unneededNodes)
 
 		for key, val := range scaleDown.unneededNodes {
 			if glog.V(4) {
@@ -289,9 +481,30 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 			}
 		}
 
+		// Node groups above max size are shrunk regardless of scale down delays.
+		if a.EnforceNodeGroupMaxSize && !scaleDown.nodeDeleteStatus.IsDeleteInProgress() {
+			result, typedErr := scaleDown.EnforceNodeGroupMaxSize(allNodes, append(scaleDownScheduled, headroom.placed...), pdbs, currentTime)
+			if typedErr != nil {
+				glog.Errorf("Failed to enforce node group max size: %v", typedErr)
+				return typedErr
+			}
+			if result == ScaleDownNodeDeleted {
+				a.lastScaleDownDeleteTime = currentTime
+			}
+			if result == ScaleDownNodeDeleted || result == ScaleDownNodeDeleteStarted {
+				a.lastLoopActive = true
+				return nil
+			}
+		}
+
+		// Scale down delays after scale up and failure may only hold back some of the removals.
+		cooldown, scaleDownDelayed := buildScaleDownCooldown(a.AutoscalingContext, a.lastScaleUpTime,
+			a.ClusterStateRegistry.GetLastScaleUpTimes(), a.lastScaleDownFailTime, currentTime)
+		scaleDown.cooldown = cooldown
+		scaleDown.wastedNodes = a.ClusterStateRegistry.GetWastedNodes(allUnschedulablePods, currentTime)
+
 		// In dry run only utilization is updated
-		calculateUnneededOnly := a.lastScaleUpTime.Add(a.ScaleDownDelayAfterAdd).After(currentTime) ||
-			a.lastScaleDownFailTime.Add(a.ScaleDownDelayAfterFailure).After(currentTime) ||
+		calculateUnneededOnly := scaleDownDelayed ||
 			a.lastScaleDownDeleteTime.Add(a.ScaleDownDelayAfterDelete).After(currentTime) ||
 			schedulablePodsPresent ||
 			scaleDown.nodeDeleteStatus.IsDeleteInProgress()
@@ -306,16 +519,23 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 
 			// We want to delete unneeded Node Groups only if there was no recent scale up,
 			// and there is no current delete in progress and there was no recent errors.
-			if a.AutoscalingContext.NodeAutoprovisioningEnabled {
-				err := cleanUpNodeAutoprovisionedGroups(a.AutoscalingContext.CloudProvider, a.AutoscalingContext.LogRecorder)
+			if a.AutoscalingContext.NodeAutoprovisioningEnabled && !a.AutoscalingContext.DryRun && cooldown == nil {
+				err := cleanUpNodeAutoprovisionedGroups(a.AutoscalingContext.CloudProvider, a.AutoscalingContext.LogRecorder,
+					scaleDown.emptyNodeGroups, a.EmptyAutoprovisionedGroupTTL, currentTime)
 				if err != nil {
 					glog.Warningf("Failed to clean up unneded node groups: %v", err)
 				}
 			}
 
+			if a.ScaleDownOrphanNodes {
+				if _, typedErr := scaleDown.TryToRemoveOrphanNodes(currentTime); typedErr != nil {
+					glog.Errorf("Failed to remove orphan nodes: %v", typedErr)
+				}
+			}
+
 			scaleDownStart := time.Now()
 			metrics.UpdateLastTime(metrics.ScaleDown, scaleDownStart)
-			result, typedErr := scaleDown.TryToScaleDown(allNodes, allScheduled, pdbs, currentTime)
+			result, typedErr := scaleDown.TryToScaleDown(allNodes, append(scaleDownScheduled, headroom.placed...), pdbs, currentTime)
 			metrics.UpdateDurationFromStart(metrics.ScaleDown, scaleDownStart)
 
 			// TODO: revisit result handling
@@ -328,11 +548,26 @@ func (a *StaticAutoscaler) RunOnce(currentTime time.Time) errors.AutoscalerError
 			} else if result == ScaleDownNodeDeleted {
 				a.lastScaleDownDeleteTime = currentTime
 			}
+			if result == ScaleDownNodeDeleted || result == ScaleDownNodeDeleteStarted {
+				a.lastLoopActive = true
+			}
+
+			// Compaction only runs when no node can be removed, as it only helps remove others.
+			if a.CompactionEvictionsPerHour > 0 && result == ScaleDownNoUnneeded && cooldown == nil {
+				if _, typedErr := scaleDown.TryToCompact(allNodes, append(scaleDownScheduled, headroom.placed...), pdbs, currentTime); typedErr != nil {
+					glog.Errorf("Failed to compact nodes: %v", typedErr)
+				}
+			}
 		}
 	}
 	return nil
 }
 
+// LastLoopActive checks if the last loop had pending pods to help, or scale-up or scale-down in progress.
+func (a *StaticAutoscaler) LastLoopActive() bool {
+	return a.lastLoopActive
+}
+
 // ExitCleanUp removes status configmap.
 func (a *StaticAutoscaler) ExitCleanUp() {
 	if !a.AutoscalingContext.WriteStatusConfigMap {
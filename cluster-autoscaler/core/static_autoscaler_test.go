@@ -221,6 +221,7 @@ func TestStaticAutoscalerRunOnce(t *testing.T) {
 	context.MaxNodesTotal = 10
 	err = autoscaler.RunOnce(time.Now().Add(time.Hour))
 	assert.NoError(t, err)
+	assert.True(t, autoscaler.LastLoopActive())
 	mock.AssertExpectationsForObjects(t, readyNodeListerMock, allNodeListerMock, scheduledPodMock, unschedulablePodMock,
 		podDisruptionBudgetListerMock, daemonSetListerMock, onScaleUpMock, onScaleDownMock)
 
@@ -236,6 +237,7 @@ func TestStaticAutoscalerRunOnce(t *testing.T) {
 
 	err = autoscaler.RunOnce(time.Now().Add(2 * time.Hour))
 	assert.NoError(t, err)
+	assert.False(t, autoscaler.LastLoopActive())
 	mock.AssertExpectationsForObjects(t, readyNodeListerMock, allNodeListerMock, scheduledPodMock, unschedulablePodMock,
 		podDisruptionBudgetListerMock, daemonSetListerMock, onScaleUpMock, onScaleDownMock)
 
@@ -250,6 +252,7 @@ func TestStaticAutoscalerRunOnce(t *testing.T) {
 	err = autoscaler.RunOnce(time.Now().Add(3 * time.Hour))
 	waitForDeleteToFinish(t, autoscaler.scaleDown)
 	assert.NoError(t, err)
+	assert.True(t, autoscaler.LastLoopActive())
 	mock.AssertExpectationsForObjects(t, readyNodeListerMock, allNodeListerMock, scheduledPodMock, unschedulablePodMock,
 		podDisruptionBudgetListerMock, daemonSetListerMock, onScaleUpMock, onScaleDownMock)
 
@@ -328,7 +331,7 @@ func TestStaticAutoscalerRunOnceWithAutoprovisionedEnabled(t *testing.T) {
 		[]string{"TN1", "TN2"}, map[string]*schedulercache.NodeInfo{"TN1": tni1, "TN2": tni2, "ng1": tni3})
 	provider.AddNodeGroup("ng1", 1, 10, 1)
 	provider.AddAutoprovisionedNodeGroup("autoprovisioned-TN1", 0, 10, 0, "TN1")
-	autoprovisionedTN1 := reflect.ValueOf(provider.NodeGroups()[1]).Interface().(*testprovider.TestNodeGroup)
+	autoprovisionedTN1 := getTestNodeGroup(provider, "autoprovisioned-TN1").(*testprovider.TestNodeGroup)
 	provider.AddNode("ng1,", n1)
 	assert.NotNil(t, provider)
 
@@ -600,7 +603,7 @@ func TestStaticAutoscalerRunOncePodsWithPriorities(t *testing.T) {
 	provider.AddNode("ng2", n2)
 	provider.AddNode("ng2", n3)
 	assert.NotNil(t, provider)
-	ng2 := reflect.ValueOf(provider.NodeGroups()[1]).Interface().(*testprovider.TestNodeGroup)
+	ng2 := getTestNodeGroup(provider, "ng2").(*testprovider.TestNodeGroup)
 
 	fakeClient := &fake.Clientset{}
 	fakeRecorder := kube_record.NewFakeRecorder(5)
@@ -689,3 +692,183 @@ func TestStaticAutoscalerRunOncePodsWithPriorities(t *testing.T) {
 		podDisruptionBudgetListerMock, daemonSetListerMock, onScaleUpMock, onScaleDownMock)
 
 }
+
+func TestStaticAutoscalerRunOnceNodeShuttingDown(t *testing.T) {
+	readyNodeListerMock := &nodeListerMock{}
+	allNodeListerMock := &nodeListerMock{}
+	scheduledPodMock := &podListerMock{}
+	unschedulablePodMock := &podListerMock{}
+	podDisruptionBudgetListerMock := &podDisruptionBudgetListerMock{}
+	daemonSetListerMock := &daemonSetListerMock{}
+	onScaleUpMock := &onScaleUpMock{}
+	onScaleDownMock := &onScaleDownMock{}
+
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Now())
+	n1.Spec.Taints = []apiv1.Taint{{Key: kube_util.ImpendingTerminationTaint, Effect: apiv1.TaintEffectNoSchedule}}
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, time.Now())
+
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	p1 := BuildTestPod("p1", 600, 0)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 600, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+
+	provider := testprovider.NewTestCloudProvider(
+		func(id string, delta int) error {
+			return onScaleUpMock.ScaleUp(id, delta)
+		}, func(id string, name string) error {
+			return onScaleDownMock.ScaleDown(id, name)
+		})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	fakeClient := &fake.Clientset{}
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterStateConfig := clusterstate.ClusterStateRegistryConfig{
+		OkTotalUnreadyCount:  1,
+		MaxNodeProvisionTime: 10 * time.Second,
+	}
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterStateConfig, fakeLogRecorder)
+
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:                 estimator.BinpackingEstimatorName,
+			ScaleDownEnabled:              true,
+			ScaleDownUtilizationThreshold: 0.5,
+			MaxNodesTotal:                 10,
+			MaxCoresTotal:                 10,
+			MaxMemoryTotal:                100000,
+			ScaleDownUnreadyTime:          time.Minute,
+			ScaleDownUnneededTime:         time.Minute,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	listerRegistry := kube_util.NewListerRegistry(allNodeListerMock, readyNodeListerMock, scheduledPodMock,
+		unschedulablePodMock, podDisruptionBudgetListerMock, daemonSetListerMock)
+
+	autoscaler := &StaticAutoscaler{AutoscalingContext: context,
+		ListerRegistry:        listerRegistry,
+		lastScaleUpTime:       time.Now(),
+		lastScaleDownFailTime: time.Now(),
+		scaleDown:             NewScaleDown(context)}
+
+	// p1 is still running on n1, but it doesn't fit on n2 so a replacement node is needed.
+	readyNodeListerMock.On("List").Return([]*apiv1.Node{n1, n2}, nil).Once()
+	allNodeListerMock.On("List").Return([]*apiv1.Node{n1, n2}, nil).Once()
+	scheduledPodMock.On("List").Return([]*apiv1.Pod{p1, p2}, nil).Once()
+	unschedulablePodMock.On("List").Return([]*apiv1.Pod{}, nil).Once()
+	daemonSetListerMock.On("List").Return([]*extensionsv1.DaemonSet{}, nil).Once()
+	onScaleUpMock.On("ScaleUp", "ng1", 1).Return(nil).Once()
+
+	err := autoscaler.RunOnce(time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	mock.AssertExpectationsForObjects(t, readyNodeListerMock, allNodeListerMock, scheduledPodMock, unschedulablePodMock,
+		podDisruptionBudgetListerMock, daemonSetListerMock, onScaleUpMock, onScaleDownMock)
+
+	readiness := clusterState.GetClusterReadiness()
+	assert.Equal(t, 1, readiness.ShuttingDown)
+	assert.Equal(t, 1, readiness.Ready)
+}
+
+func TestStaticAutoscalerRunOnceNodeWithoutInstance(t *testing.T) {
+	readyNodeListerMock := &nodeListerMock{}
+	allNodeListerMock := &nodeListerMock{}
+	scheduledPodMock := &podListerMock{}
+	unschedulablePodMock := &podListerMock{}
+	podDisruptionBudgetListerMock := &podDisruptionBudgetListerMock{}
+	daemonSetListerMock := &daemonSetListerMock{}
+	onScaleUpMock := &onScaleUpMock{}
+	onScaleDownMock := &onScaleDownMock{}
+
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Now())
+	n2 := BuildTestNode("n2", 1000, 1000)
+	SetNodeReadyState(n2, true, time.Now())
+
+	ownerRef := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	p1 := BuildTestPod("p1", 600, 0)
+	p1.OwnerReferences = ownerRef
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 600, 0)
+	p2.OwnerReferences = ownerRef
+	p2.Spec.NodeName = "n2"
+
+	provider := testprovider.NewTestCloudProvider(
+		func(id string, delta int) error {
+			return onScaleUpMock.ScaleUp(id, delta)
+		}, func(id string, name string) error {
+			return onScaleDownMock.ScaleDown(id, name)
+		})
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.MarkInstanceDeleted("n1")
+
+	fakeClient := &fake.Clientset{}
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterStateConfig := clusterstate.ClusterStateRegistryConfig{
+		OkTotalUnreadyCount:  1,
+		MaxNodeProvisionTime: 10 * time.Second,
+	}
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterStateConfig, fakeLogRecorder)
+
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:                 estimator.BinpackingEstimatorName,
+			ScaleDownEnabled:              true,
+			ScaleDownUtilizationThreshold: 0.5,
+			MaxNodesTotal:                 10,
+			MaxCoresTotal:                 10,
+			MaxMemoryTotal:                100000,
+			ScaleDownUnreadyTime:          time.Minute,
+			ScaleDownUnneededTime:         time.Minute,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	listerRegistry := kube_util.NewListerRegistry(allNodeListerMock, readyNodeListerMock, scheduledPodMock,
+		unschedulablePodMock, podDisruptionBudgetListerMock, daemonSetListerMock)
+
+	autoscaler := &StaticAutoscaler{AutoscalingContext: context,
+		ListerRegistry:        listerRegistry,
+		lastScaleUpTime:       time.Now(),
+		lastScaleDownFailTime: time.Now(),
+		scaleDown:             NewScaleDown(context)}
+
+	// n1 is still Ready, but its instance is gone. p1 doesn't fit on n2 so a replacement node is needed.
+	readyNodeListerMock.On("List").Return([]*apiv1.Node{n1, n2}, nil).Once()
+	allNodeListerMock.On("List").Return([]*apiv1.Node{n1, n2}, nil).Once()
+	scheduledPodMock.On("List").Return([]*apiv1.Pod{p1, p2}, nil).Once()
+	unschedulablePodMock.On("List").Return([]*apiv1.Pod{}, nil).Once()
+	daemonSetListerMock.On("List").Return([]*extensionsv1.DaemonSet{}, nil).Once()
+	onScaleUpMock.On("ScaleUp", "ng1", 1).Return(nil).Once()
+
+	err := autoscaler.RunOnce(time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	mock.AssertExpectationsForObjects(t, readyNodeListerMock, allNodeListerMock, scheduledPodMock, unschedulablePodMock,
+		podDisruptionBudgetListerMock, daemonSetListerMock, onScaleUpMock, onScaleDownMock)
+
+	readiness := clusterState.GetClusterReadiness()
+	assert.Equal(t, 1, readiness.InstanceDeleted)
+	assert.Equal(t, 1, readiness.Ready)
+}
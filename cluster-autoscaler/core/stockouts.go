@@ -0,0 +1,54 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// preferNotStockedOutOptions drops expansion options of node groups whose template has an instance
+// type and zone in which a recent scale-up, possibly of another node group, ran out of resources.
+// Such options are only kept if all options are stocked out, so that scale-up is still attempted.
+func preferNotStockedOutOptions(context *AutoscalingContext, options []expander.Option,
+	nodeInfos map[string]*schedulercache.NodeInfo, now time.Time) []expander.Option {
+	if context.StockoutMemoryTTL <= 0 {
+		return options
+	}
+	result := make([]expander.Option, 0, len(options))
+	for _, option := range options {
+		nodeInfo, found := nodeInfos[option.NodeGroup.Id()]
+		if found && nodeInfo.Node() != nil {
+			key, found := clusterstate.InstanceTypeZoneOfNode(nodeInfo.Node())
+			if found && context.ClusterStateRegistry.IsStockedOut(key, now) {
+				glog.V(2).Infof("Deprioritizing node group %s - recent stockout of %s in zone %q",
+					option.NodeGroup.Id(), key.InstanceType, key.Zone)
+				continue
+			}
+		}
+		result = append(result, option)
+	}
+	if len(result) == 0 {
+		return options
+	}
+	return result
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildStockoutTest builds a context with node groups of g5.xlarge machines, one node each, in the
+// given zones. Size increases are sent to the returned channel.
+func buildStockoutTest(t *testing.T, zones map[string]string, stockoutMemoryTTL time.Duration) (*AutoscalingContext, []*apiv1.Node, chan string) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+	sizeChanges := make(chan string, 10)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		sizeChanges <- fmt.Sprintf("%s-%d", nodeGroup, increase)
+		return nil
+	}, nil)
+	nodes := make([]*apiv1.Node, 0)
+	for nodeGroup, zone := range zones {
+		node := BuildTestNode(nodeGroup+"-1", 1000, 1000)
+		node.Labels = map[string]string{
+			kubeletapis.LabelInstanceType:      "g5.xlarge",
+			kubeletapis.LabelZoneFailureDomain: zone,
+		}
+		SetNodeReadyState(node, true, time.Now())
+		provider.AddNodeGroup(nodeGroup, 1, 10, 1)
+		provider.AddNode(nodeGroup, node)
+		nodes = append(nodes, node)
+	}
+
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(10), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{
+		StockoutMemoryTTL: stockoutMemoryTTL,
+	}, fakeLogRecorder)
+	clusterState.UpdateNodes(nodes, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			EstimatorName:     estimator.BinpackingEstimatorName,
+			MaxCoresTotal:     config.DefaultMaxClusterCores,
+			MaxMemoryTotal:    config.DefaultMaxClusterMemory,
+			StockoutMemoryTTL: stockoutMemoryTTL,
+		},
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             kube_record.NewFakeRecorder(10),
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+	return context, nodes, sizeChanges
+}
+
+func TestScaleUpAvoidsStockedOutInstanceType(t *testing.T) {
+	zones := map[string]string{"a": "zone-1", "b": "zone-1", "c": "zone-2"}
+
+	// A stockout in group a makes b, with the same instance type in the same zone, less preferred than c.
+	for i := 0; i < 5; i++ {
+		context, nodes, sizeChanges := buildStockoutTest(t, zones, 10*time.Minute)
+		context.ClusterStateRegistry.RegisterFailedScaleUp("a", metrics.OutOfResources)
+		scaledUp, typedErr := ScaleUp(context, []*apiv1.Pod{BuildTestPod("p-new", 800, 0)}, nodes, []*extensionsv1.DaemonSet{}, nil)
+		assert.NoError(t, typedErr)
+		assert.True(t, scaledUp)
+		assert.Equal(t, "c-1", getStringFromChan(sizeChanges))
+	}
+
+	// Stocked out groups are still used if no other group can help.
+	context, nodes, sizeChanges := buildStockoutTest(t, map[string]string{"a": "zone-1", "b": "zone-1"}, 10*time.Minute)
+	context.ClusterStateRegistry.RegisterFailedScaleUp("a", metrics.OutOfResources)
+	scaledUp, typedErr := ScaleUp(context, []*apiv1.Pod{BuildTestPod("p-new", 800, 0)}, nodes, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, typedErr)
+	assert.True(t, scaledUp)
+	assert.Equal(t, "b-1", getStringFromChan(sizeChanges))
+}
+
+func TestPreferNotStockedOutOptions(t *testing.T) {
+	zones := map[string]string{"a": "zone-1", "b": "zone-1", "c": "zone-2"}
+	context, nodes, _ := buildStockoutTest(t, zones, 10*time.Minute)
+	provider := context.CloudProvider.(*testprovider.TestCloudProvider)
+	nodeInfos, err := GetNodeInfosForGroups(nodes, provider, context.ClientSet, []*extensionsv1.DaemonSet{}, nil, nil, nil)
+	assert.NoError(t, err)
+
+	p1 := BuildTestPod("p1", 100, 0)
+	b := expander.Option{NodeGroup: getTestNodeGroup(provider, "b"), Pods: []*apiv1.Pod{p1}}
+	c := expander.Option{NodeGroup: getTestNodeGroup(provider, "c"), Pods: []*apiv1.Pod{p1}}
+	now := time.Now()
+
+	assert.Equal(t, []expander.Option{b, c}, preferNotStockedOutOptions(context, []expander.Option{b, c}, nodeInfos, now))
+	context.ClusterStateRegistry.RegisterFailedScaleUp("a", metrics.OutOfResources)
+	assert.Equal(t, []expander.Option{c}, preferNotStockedOutOptions(context, []expander.Option{b, c}, nodeInfos, now))
+	assert.Equal(t, []expander.Option{b}, preferNotStockedOutOptions(context, []expander.Option{b}, nodeInfos, now))
+	// The stockout is forgotten after the TTL.
+	assert.Equal(t, []expander.Option{b, c}, preferNotStockedOutOptions(context, []expander.Option{b, c}, nodeInfos, now.Add(11*time.Minute)))
+
+	// Disabled without TTL.
+	context, _, _ = buildStockoutTest(t, zones, 0)
+	context.ClusterStateRegistry.RegisterFailedScaleUp("a", metrics.OutOfResources)
+	assert.Equal(t, []expander.Option{b, c}, preferNotStockedOutOptions(context, []expander.Option{b, c}, nodeInfos, now))
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+type templateNodeInfoCacheEntry struct {
+	nodeInfo    *schedulercache.NodeInfo
+	fingerprint string
+	added       time.Time
+}
+
+// TemplateNodeInfoCache keeps template node infos of node groups that implement
+// cloudprovider.TemplateFingerprintNodeGroup, so that they are not rebuilt from cloud provider
+// APIs in every loop. A cached node info is used until the template fingerprint of its node
+// group changes or it gets older than ttl. Returned node infos are shared and must not be modified.
+type TemplateNodeInfoCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]templateNodeInfoCacheEntry
+}
+
+// NewTemplateNodeInfoCache builds a TemplateNodeInfoCache. A ttl of 0 disables caching.
+func NewTemplateNodeInfoCache(ttl time.Duration) *TemplateNodeInfoCache {
+	return &TemplateNodeInfoCache{
+		ttl:     ttl,
+		entries: make(map[string]templateNodeInfoCacheEntry),
+	}
+}
+
+// TemplateNodeInfo returns the template node info of the given node group, using the cached one
+// if it is still valid. It is safe to call on a nil cache.
+func (c *TemplateNodeInfoCache) TemplateNodeInfo(nodeGroup cloudprovider.NodeGroup, now time.Time) (*schedulercache.NodeInfo, error) {
+	if c == nil || c.ttl <= 0 {
+		return nodeGroup.TemplateNodeInfo()
+	}
+	fingerprintNodeGroup, ok := nodeGroup.(cloudprovider.TemplateFingerprintNodeGroup)
+	if !ok {
+		return nodeGroup.TemplateNodeInfo()
+	}
+	id := nodeGroup.Id()
+	fingerprint, err := fingerprintNodeGroup.TemplateFingerprint()
+	if err != nil {
+		glog.V(4).Infof("Not using cached template for %s: %v", id, err)
+		c.forget(id)
+		return nodeGroup.TemplateNodeInfo()
+	}
+
+	c.Lock()
+	entry, found := c.entries[id]
+	c.Unlock()
+	if found && entry.fingerprint == fingerprint && now.Sub(entry.added) < c.ttl {
+		metrics.RegisterTemplateNodeInfoCacheRequest(true)
+		return entry.nodeInfo, nil
+	}
+	metrics.RegisterTemplateNodeInfoCacheRequest(false)
+	if found && entry.fingerprint != fingerprint {
+		glog.V(2).Infof("Template of %s changed from %s to %s", id, entry.fingerprint, fingerprint)
+	}
+
+	nodeInfo, err := nodeGroup.TemplateNodeInfo()
+	if err != nil {
+		c.forget(id)
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.entries[id] = templateNodeInfoCacheEntry{
+		nodeInfo:    nodeInfo,
+		fingerprint: fingerprint,
+		added:       now,
+	}
+	return nodeInfo, nil
+}
+
+func (c *TemplateNodeInfoCache) forget(nodeGroupId string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, nodeGroupId)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fingerprintNodeGroup struct {
+	*testprovider.TestNodeGroup
+	fingerprint      string
+	fingerprintErr   error
+	templateRequests int
+}
+
+func (ng *fingerprintNodeGroup) TemplateFingerprint() (string, error) {
+	return ng.fingerprint, ng.fingerprintErr
+}
+
+func (ng *fingerprintNodeGroup) TemplateNodeInfo() (*schedulercache.NodeInfo, error) {
+	ng.templateRequests++
+	return ng.TestNodeGroup.TemplateNodeInfo()
+}
+
+func buildFingerprintNodeGroup() *fingerprintNodeGroup {
+	template := schedulercache.NewNodeInfo()
+	template.SetNode(BuildTestNode("template", 1000, 1000))
+	provider := testprovider.NewTestAutoprovisioningCloudProvider(nil, nil, nil, nil, nil,
+		map[string]*schedulercache.NodeInfo{"ng1": template})
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	return &fingerprintNodeGroup{
+		TestNodeGroup: provider.NodeGroups()[0].(*testprovider.TestNodeGroup),
+		fingerprint:   "template-1",
+	}
+}
+
+func TestTemplateNodeInfoCache(t *testing.T) {
+	nodeGroup := buildFingerprintNodeGroup()
+	cache := NewTemplateNodeInfoCache(10 * time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		nodeInfo, err := cache.TemplateNodeInfo(nodeGroup, now.Add(time.Duration(i)*time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "template", nodeInfo.Node().Name)
+	}
+	assert.Equal(t, 1, nodeGroup.templateRequests)
+
+	// Template change invalidates the cached node info.
+	nodeGroup.fingerprint = "template-2"
+	_, err := cache.TemplateNodeInfo(nodeGroup, now.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, nodeGroup.templateRequests)
+	_, err = cache.TemplateNodeInfo(nodeGroup, now.Add(4*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, nodeGroup.templateRequests)
+
+	// So does ttl expiry.
+	_, err = cache.TemplateNodeInfo(nodeGroup, now.Add(13*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, nodeGroup.templateRequests)
+
+	// Fingerprint errors bypass the cache.
+	nodeGroup.fingerprintErr = fmt.Errorf("mig not found")
+	_, err = cache.TemplateNodeInfo(nodeGroup, now.Add(14*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, nodeGroup.templateRequests)
+	nodeGroup.fingerprintErr = nil
+	_, err = cache.TemplateNodeInfo(nodeGroup, now.Add(15*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, nodeGroup.templateRequests)
+}
+
+func TestTemplateNodeInfoCacheDisabled(t *testing.T) {
+	nodeGroup := buildFingerprintNodeGroup()
+	now := time.Now()
+
+	var nilCache *TemplateNodeInfoCache
+	for _, cache := range []*TemplateNodeInfoCache{nilCache, NewTemplateNodeInfoCache(0)} {
+		nodeGroup.templateRequests = 0
+		for i := 0; i < 3; i++ {
+			_, err := cache.TemplateNodeInfo(nodeGroup, now)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 3, nodeGroup.templateRequests)
+	}
+}
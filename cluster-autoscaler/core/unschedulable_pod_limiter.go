@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"sort"
+
+	"k8s.io/autoscaler/cluster-autoscaler/metrics"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/golang/glog"
+)
+
+// UnschedulablePodLimiter caps the number of unschedulable pods considered in a loop, so that a
+// flood of pending pods doesn't make the loop take minutes. Pods with higher priority are
+// considered first, then pods deferred in more consecutive loops, then older pods. Deferred pods
+// move forward in every loop they are deferred in, so all of them are eventually considered even
+// if the considered pods stay pending.
+type UnschedulablePodLimiter struct {
+	maxPods int
+	// deferredLoops is the number of consecutive loops each pod was deferred in.
+	deferredLoops map[types.UID]int
+}
+
+// NewUnschedulablePodLimiter builds an UnschedulablePodLimiter. It returns nil if maxPods is 0,
+// which means there's no limit.
+func NewUnschedulablePodLimiter(maxPods int) *UnschedulablePodLimiter {
+	if maxPods <= 0 {
+		return nil
+	}
+	return &UnschedulablePodLimiter{
+		maxPods:       maxPods,
+		deferredLoops: make(map[types.UID]int),
+	}
+}
+
+// Limit returns at most maxPods of the pods to be considered in this loop and defers the rest to
+// next loops.
+func (l *UnschedulablePodLimiter) Limit(pods []*apiv1.Pod) []*apiv1.Pod {
+	if len(pods) <= l.maxPods {
+		l.deferredLoops = make(map[types.UID]int)
+		metrics.UpdateDeferredUnschedulablePodsCount(0)
+		return pods
+	}
+	sorted := make([]*apiv1.Pod, len(pods))
+	copy(sorted, pods)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if pi, pj := podPriority(sorted[i]), podPriority(sorted[j]); pi != pj {
+			return pi > pj
+		}
+		if di, dj := l.deferredLoops[sorted[i].UID], l.deferredLoops[sorted[j].UID]; di != dj {
+			return di > dj
+		}
+		return sorted[i].CreationTimestamp.Before(&sorted[j].CreationTimestamp)
+	})
+
+	deferredLoops := make(map[types.UID]int, len(sorted)-l.maxPods)
+	for _, pod := range sorted[l.maxPods:] {
+		deferredLoops[pod.UID] = l.deferredLoops[pod.UID] + 1
+	}
+	l.deferredLoops = deferredLoops
+
+	deferred := len(sorted) - l.maxPods
+	glog.V(1).Infof("%d unschedulable pods exceed the limit of %d pods considered in a loop, deferring them", deferred, l.maxPods)
+	metrics.UpdateDeferredUnschedulablePodsCount(deferred)
+	return sorted[:l.maxPods]
+}
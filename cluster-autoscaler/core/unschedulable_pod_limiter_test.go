@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestPodCreatedAt(name string, created time.Time, priority int32) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 0)
+	pod.UID = types.UID(name)
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.Spec.Priority = &priority
+	return pod
+}
+
+func podNamesOf(pods []*apiv1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+func TestNewUnschedulablePodLimiter(t *testing.T) {
+	assert.Nil(t, NewUnschedulablePodLimiter(0))
+	assert.NotNil(t, NewUnschedulablePodLimiter(10))
+}
+
+func TestUnschedulablePodLimiterPriorityAndAge(t *testing.T) {
+	now := time.Now()
+	pods := []*apiv1.Pod{
+		buildTestPodCreatedAt("new", now, 0),
+		buildTestPodCreatedAt("old", now.Add(-time.Hour), 0),
+		buildTestPodCreatedAt("important", now, 100),
+		buildTestPodCreatedAt("older", now.Add(-2*time.Hour), 0),
+	}
+	limiter := NewUnschedulablePodLimiter(2)
+	assert.Equal(t, []string{"important", "older"}, podNamesOf(limiter.Limit(pods)))
+
+	// Under the limit all pods are considered in their order.
+	limiter = NewUnschedulablePodLimiter(4)
+	assert.Equal(t, pods, limiter.Limit(pods))
+}
+
+func TestUnschedulablePodLimiterDeferredPodsProcessed(t *testing.T) {
+	now := time.Now()
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 5; i++ {
+		pods = append(pods, buildTestPodCreatedAt(fmt.Sprintf("p%d", i), now.Add(time.Duration(i)*time.Minute), 0))
+	}
+	limiter := NewUnschedulablePodLimiter(2)
+
+	// All pods stay pending, every pod is considered within 3 loops.
+	considered := make(map[string]bool)
+	assert.Equal(t, []string{"p0", "p1"}, podNamesOf(limiter.Limit(pods)))
+	assert.Equal(t, []string{"p2", "p3"}, podNamesOf(limiter.Limit(pods)))
+	for _, pod := range limiter.Limit(pods) {
+		considered[pod.Name] = true
+	}
+	assert.True(t, considered["p4"])
+
+	// Considered pods got scheduled, the deferred ones are next.
+	limiter = NewUnschedulablePodLimiter(2)
+	assert.Equal(t, []string{"p0", "p1"}, podNamesOf(limiter.Limit(pods)))
+	assert.Equal(t, []string{"p2", "p3"}, podNamesOf(limiter.Limit(pods[2:])))
+	assert.Equal(t, []string{"p4"}, podNamesOf(limiter.Limit(pods[4:])))
+	assert.Empty(t, limiter.deferredLoops)
+}
+
+func BenchmarkUnschedulablePodLimiter50kPods(b *testing.B) {
+	now := time.Now()
+	pods := make([]*apiv1.Pod, 0, 50000)
+	for i := 0; i < 50000; i++ {
+		pods = append(pods, buildTestPodCreatedAt(fmt.Sprintf("p%d", i), now.Add(-time.Duration(i)*time.Second), int32(i%3)))
+	}
+	limiter := NewUnschedulablePodLimiter(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		limiter.Limit(pods)
+	}
+}
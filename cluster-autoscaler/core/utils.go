@@ -21,11 +21,13 @@ import (
 	"math"
 	"math/rand"
 	"reflect"
+	"strings"
 	"time"
 
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
 	"k8s.io/autoscaler/cluster-autoscaler/metrics"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/daemonset"
@@ -37,10 +39,13 @@ import (
 
 	apiv1 "k8s.io/api/core/v1"
 	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	kube_client "k8s.io/client-go/kubernetes"
 	api "k8s.io/kubernetes/pkg/api"
-	"k8s.io/kubernetes/pkg/api/helper"
 	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
@@ -51,6 +56,23 @@ const (
 	ReschedulerTaintKey = "CriticalAddonsOnly"
 )
 
+// transientTaints are taints that node lifecycle controller and cloud controller manager put on
+// nodes for a while, e.g. when a node is not ready yet or under pressure. New nodes won't have them
+// once they start, so they are removed from templates built from existing nodes. Both alpha and
+// GA keys of the not ready and unreachable taints are included.
+var transientTaints = map[string]bool{
+	algorithm.TaintNodeNotReady:           true,
+	"node.kubernetes.io/not-ready":        true,
+	algorithm.TaintNodeUnreachable:        true,
+	"node.kubernetes.io/unreachable":      true,
+	algorithm.TaintNodeOutOfDisk:          true,
+	algorithm.TaintNodeMemoryPressure:     true,
+	algorithm.TaintNodeDiskPressure:       true,
+	algorithm.TaintNodeNetworkUnavailable: true,
+	algorithm.TaintExternalCloudProvider:  true,
+	"node.kubernetes.io/unschedulable":    true,
+}
+
 // Following data structure is used to avoid running predicates #pending_pods * #nodes
 // times (which turned out to be very expensive if there are thousands of pending pods).
 // This optimization is based on the assumption that if there are that many pods they're
@@ -60,65 +82,62 @@ const (
 // the pod would fit anywhere right now) and if so we use the result we already
 // calculated.
 // To decide if two pods are similar enough we check if they have identical label
-// and spec and are owned by the same controller. The problem is the whole
-// podSchedulableInfo struct is not hashable and keeping a list and runnig deep
-// equality checks would likely also be expensive. So instead we use controller
-// UID as a key in initial lookup and only run full comparison on a set of
-// podSchedulableInfos created for pods owned by this controller.
-type podSchedulableInfo struct {
-	spec        apiv1.PodSpec
-	labels      map[string]string
-	schedulable bool
-}
-
-type podSchedulableMap map[string][]podSchedulableInfo
-
-func (psi *podSchedulableInfo) match(pod *apiv1.Pod) bool {
-	return reflect.DeepEqual(pod.Labels, psi.labels) && helper.Semantic.DeepEqual(pod.Spec, psi.spec)
-}
+// and spec and are owned by the same controller. Labels and spec are compared by
+// their equivalence hash, which is kept in PodEquivalenceCache between loops, so
+// with thousands of pending pods only pods that changed are hashed again.
+type podSchedulableMap map[string]bool
 
-func (podMap podSchedulableMap) get(pod *apiv1.Pod) (bool, bool) {
+func podSchedulableKey(pod *apiv1.Pod, equivalenceCache *PodEquivalenceCache) string {
 	ref := drain.ControllerRef(pod)
 	if ref == nil {
-		return false, false
+		return ""
 	}
-	uid := string(ref.UID)
-	if infos, found := podMap[uid]; found {
-		for _, info := range infos {
-			if info.match(pod) {
-				return info.schedulable, true
-			}
-		}
+	return string(ref.UID) + "/" + equivalenceCache.hash(pod)
+}
+
+func (podMap podSchedulableMap) get(pod *apiv1.Pod, equivalenceCache *PodEquivalenceCache) (bool, bool) {
+	key := podSchedulableKey(pod, equivalenceCache)
+	if key == "" {
+		return false, false
 	}
-	return false, false
+	schedulable, found := podMap[key]
+	return schedulable, found
 }
 
-func (podMap podSchedulableMap) set(pod *apiv1.Pod, schedulable bool) {
-	ref := drain.ControllerRef(pod)
-	if ref == nil {
+func (podMap podSchedulableMap) set(pod *apiv1.Pod, equivalenceCache *PodEquivalenceCache, schedulable bool) {
+	key := podSchedulableKey(pod, equivalenceCache)
+	if key == "" {
 		return
 	}
-	uid := string(ref.UID)
-	podMap[uid] = append(podMap[uid], podSchedulableInfo{
-		spec:        pod.Spec,
-		labels:      pod.Labels,
-		schedulable: schedulable,
-	})
+	podMap[key] = schedulable
 }
 
 // FilterOutSchedulable checks whether pods from <unschedulableCandidates> marked as unschedulable
 // by Scheduler actually can't be scheduled on any node and filter out the ones that can.
 // It takes into account pods that are bound to node and will be scheduled after lower priority pod preemption.
+// Nodes excluded from rebalancing, including nodes under pressure, are not considered as destinations,
+// but pods running on them are still taken into account.
 func FilterOutSchedulable(unschedulableCandidates []*apiv1.Pod, nodes []*apiv1.Node, allScheduled []*apiv1.Pod, podsWaitingForLowerPriorityPreemption []*apiv1.Pod,
 	predicateChecker *simulator.PredicateChecker, expendablePodsPriorityCutoff int) []*apiv1.Pod {
+	return FilterOutSchedulableWithCache(unschedulableCandidates, nodes, allScheduled, podsWaitingForLowerPriorityPreemption,
+		predicateChecker, expendablePodsPriorityCutoff, nil)
+}
+
+// FilterOutSchedulableWithCache is FilterOutSchedulable reusing equivalence hashes of pods from
+// previous loops. The cache is cleaned up of pods that are no longer candidates.
+func FilterOutSchedulableWithCache(unschedulableCandidates []*apiv1.Pod, nodes []*apiv1.Node, allScheduled []*apiv1.Pod, podsWaitingForLowerPriorityPreemption []*apiv1.Pod,
+	predicateChecker *simulator.PredicateChecker, expendablePodsPriorityCutoff int, equivalenceCache *PodEquivalenceCache) []*apiv1.Pod {
 
 	unschedulablePods := []*apiv1.Pod{}
 	nonExpendableScheduled := FilterOutExpendablePods(allScheduled, expendablePodsPriorityCutoff)
 	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(append(nonExpendableScheduled, podsWaitingForLowerPriorityPreemption...), nodes)
+	if pressured := simulator.CountNodesUnderPressure(nodes); pressured > 0 {
+		glog.V(2).Infof("%d nodes under pressure are not considered as existing capacity for unschedulable pods", pressured)
+	}
 	podSchedulable := make(podSchedulableMap)
 
 	for _, pod := range unschedulableCandidates {
-		if schedulable, found := podSchedulable.get(pod); found {
+		if schedulable, found := podSchedulable.get(pod, equivalenceCache); found {
 			if !schedulable {
 				unschedulablePods = append(unschedulablePods, pod)
 			} else {
@@ -126,14 +145,17 @@ func FilterOutSchedulable(unschedulableCandidates []*apiv1.Pod, nodes []*apiv1.N
 			}
 			continue
 		}
-		if nodeName, err := predicateChecker.FitsAny(pod, nodeNameToNodeInfo); err == nil {
+		if nodeName, err := predicateChecker.FitsAnyRebalanceTarget(pod, nodeNameToNodeInfo); err == nil {
 			glog.V(4).Infof("Pod %s marked as unschedulable can be scheduled on %s. Ignoring in scale up.", pod.Name, nodeName)
-			podSchedulable.set(pod, true)
+			podSchedulable.set(pod, equivalenceCache, true)
 		} else {
 			unschedulablePods = append(unschedulablePods, pod)
-			podSchedulable.set(pod, false)
+			podSchedulable.set(pod, equivalenceCache, false)
 		}
 	}
+	if equivalenceCache != nil {
+		equivalenceCache.CleanUp()
+	}
 
 	return unschedulablePods
 }
@@ -172,8 +194,10 @@ func FilterOutExpendablePods(pods []*apiv1.Pod, expendablePodsPriorityCutoff int
 // TODO(mwielgus): This returns map keyed by url, while most code (including scheduler) uses node.Name for a key.
 //
 // TODO(mwielgus): Review error policy - sometimes we may continue with partial errors.
+// Taints with keys in ignoredTaints are removed from the templates, along with transient taints.
 func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.CloudProvider, kubeClient kube_client.Interface,
-	daemonsets []*extensionsv1.DaemonSet, predicateChecker *simulator.PredicateChecker) (map[string]*schedulercache.NodeInfo, errors.AutoscalerError) {
+	daemonsets []*extensionsv1.DaemonSet, templateCache *TemplateNodeInfoCache, allocatableTracker *NodeAllocatableTracker,
+	ignoredTaints []string) (map[string]*schedulercache.NodeInfo, errors.AutoscalerError) {
 	result := make(map[string]*schedulercache.NodeInfo)
 
 	// processNode returns information whether the nodeTemplate was generated and if there was an error.
@@ -192,7 +216,7 @@ func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.Clou
 			if err != nil {
 				return false, err
 			}
-			sanitizedNodeInfo, err := sanitizeNodeInfo(nodeInfo, id)
+			sanitizedNodeInfo, err := sanitizeNodeInfo(nodeInfo, id, ignoredTaints)
 			if err != nil {
 				return false, err
 			}
@@ -202,12 +226,37 @@ func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.Clou
 		return false, nil
 	}
 
+	// Transient taints are removed from templates, but the least tainted node of a node group is
+	// still the most likely to look like its new nodes.
+	exemplars := make(map[string]*apiv1.Node)
+	exemplarGroups := make([]string, 0)
 	for _, node := range nodes {
 		// Broken nodes might have some stuff missing. Skipping.
 		if !kube_util.IsNodeReadyAndSchedulable(node) {
 			continue
 		}
-		_, typedErr := processNode(node)
+		// Resized nodes don't look like new nodes of their node group.
+		if allocatableTracker.resized(node.Name) {
+			continue
+		}
+		nodeGroup, err := cloudProvider.NodeGroupForNode(node)
+		if err != nil {
+			return map[string]*schedulercache.NodeInfo{}, errors.ToAutoscalerError(errors.CloudProviderError, err)
+		}
+		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		id := nodeGroup.Id()
+		exemplar, found := exemplars[id]
+		if !found {
+			exemplarGroups = append(exemplarGroups, id)
+		}
+		if !found || len(node.Spec.Taints) < len(exemplar.Spec.Taints) {
+			exemplars[id] = node
+		}
+	}
+	for _, id := range exemplarGroups {
+		_, typedErr := processNode(exemplars[id])
 		if typedErr != nil {
 			return map[string]*schedulercache.NodeInfo{}, typedErr
 		}
@@ -220,7 +269,7 @@ func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.Clou
 
 		// No good template, trying to generate one. This is called only if there are no
 		// working nodes in the node groups. By default CA tries to usa a real-world example.
-		baseNodeInfo, err := nodeGroup.TemplateNodeInfo()
+		baseNodeInfo, err := templateCache.TemplateNodeInfo(nodeGroup, time.Now())
 		if err != nil {
 			if err == cloudprovider.ErrNotImplemented {
 				continue
@@ -230,21 +279,21 @@ func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.Clou
 					errors.CloudProviderError, err)
 			}
 		}
-		pods := daemonset.GetDaemonSetPodsForNode(baseNodeInfo, daemonsets, predicateChecker)
+		pods := daemonset.GetDaemonSetPodsForNode(baseNodeInfo, daemonsets)
 		pods = append(pods, baseNodeInfo.Pods()...)
 		fullNodeInfo := schedulercache.NewNodeInfo(pods...)
 		fullNodeInfo.SetNode(baseNodeInfo.Node())
-		sanitizedNodeInfo, typedErr := sanitizeNodeInfo(fullNodeInfo, id)
+		sanitizedNodeInfo, typedErr := sanitizeNodeInfo(fullNodeInfo, id, ignoredTaints)
 		if typedErr != nil {
 			return map[string]*schedulercache.NodeInfo{}, typedErr
 		}
 		result[id] = sanitizedNodeInfo
 	}
 
-	// Last resort - unready/unschedulable nodes.
+	// Last resort - unready/unschedulable or resized nodes.
 	for _, node := range nodes {
 		// Allowing broken nodes
-		if !kube_util.IsNodeReadyAndSchedulable(node) {
+		if !kube_util.IsNodeReadyAndSchedulable(node) || allocatableTracker.resized(node.Name) {
 			added, typedErr := processNode(node)
 			if typedErr != nil {
 				return map[string]*schedulercache.NodeInfo{}, typedErr
@@ -255,7 +304,7 @@ func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.Clou
 					errors.CloudProviderError, err)
 			}
 			if added {
-				glog.Warningf("Built template for %s based on unready/unschedulable node %s", nodeGroup.Id(), node.Name)
+				glog.Warningf("Built template for %s based on unready/unschedulable or resized node %s", nodeGroup.Id(), node.Name)
 			}
 		}
 	}
@@ -263,9 +312,9 @@ func GetNodeInfosForGroups(nodes []*apiv1.Node, cloudProvider cloudprovider.Clou
 	return result, nil
 }
 
-func sanitizeNodeInfo(nodeInfo *schedulercache.NodeInfo, nodeGroupName string) (*schedulercache.NodeInfo, errors.AutoscalerError) {
+func sanitizeNodeInfo(nodeInfo *schedulercache.NodeInfo, nodeGroupName string, ignoredTaints []string) (*schedulercache.NodeInfo, errors.AutoscalerError) {
 	// Sanitize node name.
-	sanitizedNode, err := sanitizeTemplateNode(nodeInfo.Node(), nodeGroupName)
+	sanitizedNode, err := sanitizeTemplateNode(nodeInfo.Node(), nodeGroupName, ignoredTaints)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +339,7 @@ func sanitizeNodeInfo(nodeInfo *schedulercache.NodeInfo, nodeGroupName string) (
 	return sanitizedNodeInfo, nil
 }
 
-func sanitizeTemplateNode(node *apiv1.Node, nodeGroup string) (*apiv1.Node, errors.AutoscalerError) {
+func sanitizeTemplateNode(node *apiv1.Node, nodeGroup string, ignoredTaints []string) (*apiv1.Node, errors.AutoscalerError) {
 	obj, err := api.Scheme.DeepCopy(node)
 	if err != nil {
 		return nil, errors.ToAutoscalerError(errors.InternalError, err)
@@ -317,13 +366,38 @@ func sanitizeTemplateNode(node *apiv1.Node, nodeGroup string) (*apiv1.Node, erro
 		case deletetaint.ToBeDeletedTaint:
 			glog.V(4).Infof("Removing autoscaler taint when creating template from node %s", node.Name)
 		default:
-			newTaints = append(newTaints, taint)
+			if transientTaints[taint.Key] {
+				glog.V(4).Infof("Removing transient taint %s when creating template from node %s", taint.Key, node.Name)
+			} else if isIgnoredTaint(taint.Key, ignoredTaints) {
+				glog.V(4).Infof("Removing ignored taint %s when creating template from node %s", taint.Key, node.Name)
+			} else {
+				newTaints = append(newTaints, taint)
+			}
 		}
 	}
 	newNode.Spec.Taints = newTaints
+	// New nodes are ready and schedulable, even if the node the template is built from isn't.
+	newNode.Spec.Unschedulable = false
+	for i := range newNode.Status.Conditions {
+		switch newNode.Status.Conditions[i].Type {
+		case apiv1.NodeReady:
+			newNode.Status.Conditions[i].Status = apiv1.ConditionTrue
+		case apiv1.NodeOutOfDisk, apiv1.NodeMemoryPressure, apiv1.NodeDiskPressure, apiv1.NodeNetworkUnavailable:
+			newNode.Status.Conditions[i].Status = apiv1.ConditionFalse
+		}
+	}
 	return newNode, nil
 }
 
+func isIgnoredTaint(key string, ignoredTaints []string) bool {
+	for _, ignored := range ignoredTaints {
+		if key == ignored {
+			return true
+		}
+	}
+	return false
+}
+
 // Removes unregistered nodes if needed. Returns true if anything was removed and error if such occurred.
 func removeOldUnregisteredNodes(unregisteredNodes []clusterstate.UnregisteredNode, context *AutoscalingContext,
 	currentTime time.Time, logRecorder *utils.LogEventRecorder) (bool, error) {
@@ -349,6 +423,11 @@ func removeOldUnregisteredNodes(unregisteredNodes []clusterstate.UnregisteredNod
 				glog.Warningf("Failed to remove node %s: node group min size reached, skipping unregistered node removal", unregisteredNode.Node.Name)
 				continue
 			}
+			if context.DryRun {
+				recordDryRunAction(context, metrics.DryRunRemoveUnregistered, nodeGroup.Id(),
+					"would remove unregistered node %v", unregisteredNode.Node.Name)
+				continue
+			}
 			logRecorder.Eventf(apiv1.EventTypeNormal, "DeleteUnregistered",
 				"Removing unregistered node %v", unregisteredNode.Node.Name)
 			err = nodeGroup.DeleteNodes([]*apiv1.Node{unregisteredNode.Node})
@@ -363,18 +442,38 @@ func removeOldUnregisteredNodes(unregisteredNodes []clusterstate.UnregisteredNod
 }
 
 // Sets the target size of node groups to the current number of nodes in them
-// if the difference was constant for a prolonged time. Returns true if managed
-// to fix something.
+// if the difference was constant for a prolonged time or the cloud provider stopped
+// fulfilling a scale-up. Returns true if managed to fix something.
 func fixNodeGroupSize(context *AutoscalingContext, currentTime time.Time) (bool, error) {
 	fixed := false
 	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		if partial := context.ClusterStateRegistry.GetPartialScaleUp(nodeGroup.Id()); partial != nil {
+			delta := partial.FulfilledSize - partial.TargetSize
+			if context.DryRun {
+				recordDryRunAction(context, metrics.DryRunFixNodeGroupSize, nodeGroup.Id(),
+					"would decrease size of %s by %d after partial scale-up", nodeGroup.Id(), -delta)
+				continue
+			}
+			glog.V(0).Infof("Decreasing size of %s to %d nodes created by partial scale-up, target=%d delta=%d", nodeGroup.Id(),
+				partial.FulfilledSize,
+				partial.TargetSize,
+				delta)
+			if err := nodeGroup.DecreaseTargetSize(delta); err != nil {
+				return fixed, fmt.Errorf("Failed to decrease %s: %v", nodeGroup.Id(), err)
+			}
+			fixed = true
+			continue
+		}
 		incorrectSize := context.ClusterStateRegistry.GetIncorrectNodeGroupSize(nodeGroup.Id())
 		if incorrectSize == nil {
 			continue
 		}
 		if incorrectSize.FirstObserved.Add(context.UnregisteredNodeRemovalTime).Before(currentTime) {
 			delta := incorrectSize.CurrentSize - incorrectSize.ExpectedSize
-			if delta < 0 {
+			if delta < 0 && context.DryRun {
+				recordDryRunAction(context, metrics.DryRunFixNodeGroupSize, nodeGroup.Id(),
+					"would decrease size of %s by %d", nodeGroup.Id(), -delta)
+			} else if delta < 0 {
 				glog.V(0).Infof("Decreasing size of %s, expected=%d current=%d delta=%d", nodeGroup.Id(),
 					incorrectSize.ExpectedSize,
 					incorrectSize.CurrentSize,
@@ -389,15 +488,48 @@ func fixNodeGroupSize(context *AutoscalingContext, currentTime time.Time) (bool,
 	return fixed, nil
 }
 
+// reconcileTargetSizes decreases target sizes of node groups that stayed above their numbers of
+// instances with no scale-up in progress for longer than the provision timeout, for example after a
+// manual resize the cloud provider couldn't fulfill. Returns true if any target size was changed.
+func reconcileTargetSizes(context *AutoscalingContext, currentTime time.Time) (bool, error) {
+	reconciled := false
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		drift := context.ClusterStateRegistry.GetNodeGroupDrift(nodeGroup.Id())
+		if drift == nil || drift.TargetMismatch == 0 {
+			continue
+		}
+		if !drift.FirstObserved.Add(context.ClusterStateRegistry.GetProvisionTimeout(nodeGroup.Id())).Before(currentTime) {
+			continue
+		}
+		if context.DryRun {
+			recordDryRunAction(context, metrics.DryRunReconcileTargetSize, nodeGroup.Id(),
+				"would decrease size of %s by %d to match its instances", nodeGroup.Id(), drift.TargetMismatch)
+			continue
+		}
+		glog.V(0).Infof("Decreasing size of %s by %d to match its instances, target size drifted since %v",
+			nodeGroup.Id(), drift.TargetMismatch, drift.FirstObserved)
+		if err := nodeGroup.DecreaseTargetSize(-drift.TargetMismatch); err != nil {
+			return reconciled, fmt.Errorf("Failed to decrease %s: %v", nodeGroup.Id(), err)
+		}
+		reconciled = true
+	}
+	return reconciled, nil
+}
+
 // getPotentiallyUnneededNodes returns nodes that are:
 // - managed by the cluster autoscaler
 // - in groups with size > min size
+// - not going through graceful shutdown
 func getPotentiallyUnneededNodes(context *AutoscalingContext, nodes []*apiv1.Node) []*apiv1.Node {
 	result := make([]*apiv1.Node, 0, len(nodes))
 
 	nodeGroupSize := getNodeGroupSizeMap(context.CloudProvider)
 
 	for _, node := range nodes {
+		if kube_util.IsNodeShuttingDown(node) {
+			glog.V(1).Infof("Skipping %s - node is shutting down", node.Name)
+			continue
+		}
 		nodeGroup, err := context.CloudProvider.NodeGroupForNode(node)
 		if err != nil {
 			glog.Warningf("Error while checking node group for %s: %v", node.Name, err)
@@ -421,6 +553,112 @@ func getPotentiallyUnneededNodes(context *AutoscalingContext, nodes []*apiv1.Nod
 	return result
 }
 
+// filterOutShuttingDownNodes returns nodes that are not going through graceful shutdown.
+func filterOutShuttingDownNodes(nodes []*apiv1.Node) []*apiv1.Node {
+	result := make([]*apiv1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !kube_util.IsNodeShuttingDown(node) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// getPodsOnShuttingDownNodes returns pods that are being terminated by kubelet because their node
+// is going through graceful shutdown and that will be recreated by their controllers. Returned pods
+// are copies with node name cleared, so that they can be treated as pending.
+func getPodsOnShuttingDownNodes(nodes []*apiv1.Node, pods []*apiv1.Pod) []*apiv1.Pod {
+	shuttingDown := make(map[string]bool)
+	for _, node := range nodes {
+		if kube_util.IsNodeShuttingDown(node) {
+			shuttingDown[node.Name] = true
+		}
+	}
+	return recreatedPodsOnNodes(shuttingDown, pods)
+}
+
+// filterOutDeletedInstanceNodes returns nodes whose instances still exist on the cloud provider side.
+func filterOutDeletedInstanceNodes(nodes []*apiv1.Node, deletedInstanceNodes []clusterstate.DeletedInstanceNode) []*apiv1.Node {
+	deleted := make(map[string]bool, len(deletedInstanceNodes))
+	for _, deletedInstanceNode := range deletedInstanceNodes {
+		deleted[deletedInstanceNode.Node.Name] = true
+	}
+	result := make([]*apiv1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !deleted[node.Name] {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// getPodsOnDeletedInstanceNodes returns pods left on nodes whose instances no longer exist. Their
+// controllers will recreate them once the node controller evicts them, so they are returned as
+// pending copies, like pods on shutting down nodes.
+func getPodsOnDeletedInstanceNodes(deletedInstanceNodes []clusterstate.DeletedInstanceNode, pods []*apiv1.Pod) []*apiv1.Pod {
+	deleted := make(map[string]bool, len(deletedInstanceNodes))
+	for _, deletedInstanceNode := range deletedInstanceNodes {
+		deleted[deletedInstanceNode.Node.Name] = true
+	}
+	return recreatedPodsOnNodes(deleted, pods)
+}
+
+// recreatedPodsOnNodes returns pending copies of pods running on the given nodes that will be
+// recreated elsewhere by their controllers.
+func recreatedPodsOnNodes(nodeNames map[string]bool, pods []*apiv1.Pod) []*apiv1.Pod {
+	result := make([]*apiv1.Pod, 0)
+	if len(nodeNames) == 0 {
+		return result
+	}
+	for _, pod := range pods {
+		if !nodeNames[pod.Spec.NodeName] {
+			continue
+		}
+		if podCopy := recreatedPodCopy(pod); podCopy != nil {
+			result = append(result, podCopy)
+		}
+	}
+	return result
+}
+
+// recreatedPodCopy returns a pending copy of a pod that is going to be removed from its node,
+// or nil if the pod won't be recreated elsewhere by its controller.
+func recreatedPodCopy(pod *apiv1.Pod) *apiv1.Pod {
+	if drain.IsMirrorPod(pod) {
+		return nil
+	}
+	controllerRef := drain.ControllerRef(pod)
+	if controllerRef == nil || controllerRef.Kind == "DaemonSet" {
+		return nil
+	}
+	podCopy := *pod
+	podCopy.Spec.NodeName = ""
+	return &podCopy
+}
+
+// removeOldDeletedInstanceNodes deletes node objects whose instances have been gone for longer than
+// DeletedInstanceNodeRemovalTime, instead of waiting for the node controller to do it.
+func removeOldDeletedInstanceNodes(deletedInstanceNodes []clusterstate.DeletedInstanceNode, context *AutoscalingContext,
+	currentTime time.Time, logRecorder *utils.LogEventRecorder) {
+	for _, deleted := range deletedInstanceNodes {
+		if !deleted.DeletedSince.Add(context.DeletedInstanceNodeRemovalTime).Before(currentTime) {
+			continue
+		}
+		if context.DryRun {
+			recordDryRunAction(context, metrics.DryRunRemoveDeletedInstance, deleted.NodeGroupId,
+				"would remove node %v without instance", deleted.Node.Name)
+			continue
+		}
+		glog.V(0).Infof("Removing node %v, its instance no longer exists", deleted.Node.Name)
+		logRecorder.Eventf(apiv1.EventTypeNormal, "DeleteNodeWithoutInstance",
+			"Removing node %v, its instance no longer exists", deleted.Node.Name)
+		err := context.ClientSet.CoreV1().Nodes().Delete(deleted.Node.Name, &metav1.DeleteOptions{})
+		if err != nil && !kube_errors.IsNotFound(err) {
+			glog.Warningf("Failed to remove node %s: %v", deleted.Node.Name, err)
+		}
+	}
+}
+
 // ConfigurePredicateCheckerForLoop can be run to update predicateChecker configuration
 // based on current state of the cluster.
 func ConfigurePredicateCheckerForLoop(unschedulablePods []*apiv1.Pod, schedulablePods []*apiv1.Pod, predicateChecker *simulator.PredicateChecker) {
@@ -491,6 +729,66 @@ func getNodeGroupSizeMap(cloudProvider cloudprovider.CloudProvider) map[string]i
 	return nodeGroupSize
 }
 
+// buildLoopSummary describes the state seen by the current main loop for crash dumps.
+func buildLoopSummary(context *AutoscalingContext, allNodes []*apiv1.Node, pendingPods []*apiv1.Pod, now time.Time) *debug.LoopSummary {
+	summary := &debug.LoopSummary{
+		Time:        now,
+		Nodes:       len(allNodes),
+		PendingPods: pendingPods,
+		Backoffs:    context.ClusterStateRegistry.GetBackoffs(now),
+	}
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		size, err := nodeGroup.TargetSize()
+		if err != nil {
+			continue
+		}
+		summary.NodeGroups = append(summary.NodeGroups, debug.NodeGroupRecord{
+			Id:         nodeGroup.Id(),
+			MinSize:    nodeGroup.MinSize(),
+			MaxSize:    nodeGroup.MaxSize(),
+			TargetSize: size,
+		})
+	}
+	return summary
+}
+
+// recordDryRunAction reports an action that was skipped because CA is running in dry-run mode.
+func recordDryRunAction(context *AutoscalingContext, action metrics.DryRunAction, nodeGroup string, msg string, args ...interface{}) {
+	message := fmt.Sprintf("Dry-run: "+msg, args...)
+	glog.V(0).Info(message)
+	context.LogRecorder.Event(apiv1.EventTypeNormal, "DryRun", message)
+	metrics.RegisterDryRunAction(action, nodeGroup)
+}
+
+func nodeGroupIdForNode(cloudProvider cloudprovider.CloudProvider, node *apiv1.Node) string {
+	nodeGroup, err := cloudProvider.NodeGroupForNode(node)
+	if err != nil || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+		return ""
+	}
+	return nodeGroup.Id()
+}
+
+// recordPodEvent records an event on the pod, unless it's a synthetic pod that doesn't exist in the
+// API server.
+func recordPodEvent(context *AutoscalingContext, pod *apiv1.Pod, eventType, reason, messageFmt string, args ...interface{}) {
+	if isSyntheticPod(pod) {
+		return
+	}
+	context.Recorder.Eventf(pod, eventType, reason, messageFmt, args...)
+}
+
+func podNames(pods []*apiv1.Pod) string {
+	return strings.Join(podKeys(pods), ",")
+}
+
+func podKeys(pods []*apiv1.Pod) []string {
+	keys := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		keys = append(keys, pod.Namespace+"/"+pod.Name)
+	}
+	return keys
+}
+
 // UpdateClusterStateMetrics updates metrics related to cluster state
 func UpdateClusterStateMetrics(csr *clusterstate.ClusterStateRegistry) {
 	if csr == nil || reflect.ValueOf(csr).IsNil() {
@@ -500,3 +798,69 @@ func UpdateClusterStateMetrics(csr *clusterstate.ClusterStateRegistry) {
 	readiness := csr.GetClusterReadiness()
 	metrics.UpdateNodesCount(readiness.Ready, readiness.Unready+readiness.LongNotStarted, readiness.NotStarted)
 }
+
+// filterOutOrphanedDaemonSetPods splits off terminating pods of DaemonSets that no longer exist.
+// They linger on nodes until their termination finishes, but can't be evicted and free their
+// resources soon, so scale-down ignores them. Daemon sets are only listed if there are terminating
+// DaemonSet pods.
+func filterOutOrphanedDaemonSetPods(pods []*apiv1.Pod, daemonSetLister kube_util.DaemonSetLister) ([]*apiv1.Pod, []*apiv1.Pod, error) {
+	terminating := false
+	for _, pod := range pods {
+		if drain.IsTerminatingDaemonSetPod(pod) {
+			terminating = true
+			break
+		}
+	}
+	if !terminating {
+		return pods, []*apiv1.Pod{}, nil
+	}
+	daemonSets, err := daemonSetLister.List()
+	if err != nil {
+		return pods, []*apiv1.Pod{}, err
+	}
+	existing := make(map[types.UID]bool, len(daemonSets))
+	for _, daemonSet := range daemonSets {
+		existing[daemonSet.UID] = true
+	}
+	kept := make([]*apiv1.Pod, 0, len(pods))
+	orphaned := make([]*apiv1.Pod, 0)
+	for _, pod := range pods {
+		if drain.IsTerminatingDaemonSetPod(pod) && !existing[drain.ControllerRef(pod).UID] {
+			orphaned = append(orphaned, pod)
+		} else {
+			kept = append(kept, pod)
+		}
+	}
+	return kept, orphaned, nil
+}
+
+// recordOrphanedDaemonSetPods emits an event on nodes with orphaned DaemonSet pods that weren't
+// reported before, listing the pods. reported holds the keys of reported pods and is updated.
+func recordOrphanedDaemonSetPods(context *AutoscalingContext, nodes []*apiv1.Node, orphaned []*apiv1.Pod, reported map[string]bool) {
+	current := make(map[string]bool, len(orphaned))
+	newPodsByNode := make(map[string][]*apiv1.Pod)
+	for _, pod := range orphaned {
+		key := fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+		current[key] = true
+		if !reported[key] {
+			newPodsByNode[pod.Spec.NodeName] = append(newPodsByNode[pod.Spec.NodeName], pod)
+		}
+	}
+	for key := range reported {
+		if !current[key] {
+			delete(reported, key)
+		}
+	}
+	for _, node := range nodes {
+		pods, found := newPodsByNode[node.Name]
+		if !found {
+			continue
+		}
+		glog.V(1).Infof("Ignoring terminating pods of deleted DaemonSets on %s in scale-down: %s", node.Name, podNames(pods))
+		context.Recorder.Eventf(node, apiv1.EventTypeNormal, "OrphanedDaemonSetPods",
+			"ignoring terminating pods of deleted DaemonSets in scale-down: %s", podNames(pods))
+		for _, pod := range pods {
+			reported[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)] = true
+		}
+	}
+}
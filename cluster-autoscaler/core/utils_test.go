@@ -26,6 +26,7 @@ import (
 	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/deletetaint"
+	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 
@@ -33,6 +34,7 @@ import (
 	extensionsv1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	kube_record "k8s.io/client-go/tools/record"
@@ -40,6 +42,7 @@ import (
 	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 
 	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 )
 
@@ -71,49 +74,49 @@ func TestPodSchedulableMap(t *testing.T) {
 	podInRc2.OwnerReferences = GenerateOwnerReferences(rc2.Name, "ReplicationController", "extensions/v1beta1", rc2.UID)
 
 	// Basic sanity checks
-	_, found := pMap.get(podInRc1_1)
+	_, found := pMap.get(podInRc1_1, nil)
 	assert.False(t, found)
-	pMap.set(podInRc1_1, true)
-	sched, found := pMap.get(podInRc1_1)
+	pMap.set(podInRc1_1, nil, true)
+	sched, found := pMap.get(podInRc1_1, nil)
 	assert.True(t, found)
 	assert.True(t, sched)
 
 	// Pod in different RC
-	_, found = pMap.get(podInRc2)
+	_, found = pMap.get(podInRc2, nil)
 	assert.False(t, found)
-	pMap.set(podInRc2, false)
-	sched, found = pMap.get(podInRc2)
+	pMap.set(podInRc2, nil, false)
+	sched, found = pMap.get(podInRc2, nil)
 	assert.True(t, found)
 	assert.False(t, sched)
 
 	// Another replica in rc1
 	podInRc1_2 := BuildTestPod("podInRc1_1", 500, 1000)
 	podInRc1_2.OwnerReferences = GenerateOwnerReferences(rc1.Name, "ReplicationController", "extensions/v1beta1", rc1.UID)
-	sched, found = pMap.get(podInRc1_2)
+	sched, found = pMap.get(podInRc1_2, nil)
 	assert.True(t, found)
 	assert.True(t, sched)
 
 	// A pod in rc1, but with different requests
 	differentPodInRc1 := BuildTestPod("differentPodInRc1", 1000, 1000)
 	differentPodInRc1.OwnerReferences = GenerateOwnerReferences(rc1.Name, "ReplicationController", "extensions/v1beta1", rc1.UID)
-	_, found = pMap.get(differentPodInRc1)
+	_, found = pMap.get(differentPodInRc1, nil)
 	assert.False(t, found)
-	pMap.set(differentPodInRc1, false)
-	sched, found = pMap.get(differentPodInRc1)
+	pMap.set(differentPodInRc1, nil, false)
+	sched, found = pMap.get(differentPodInRc1, nil)
 	assert.True(t, found)
 	assert.False(t, sched)
 
 	// A non-repliated pod
 	nonReplicatedPod := BuildTestPod("nonReplicatedPod", 1000, 1000)
-	_, found = pMap.get(nonReplicatedPod)
+	_, found = pMap.get(nonReplicatedPod, nil)
 	assert.False(t, found)
-	pMap.set(nonReplicatedPod, false)
-	_, found = pMap.get(nonReplicatedPod)
+	pMap.set(nonReplicatedPod, nil, false)
+	_, found = pMap.get(nonReplicatedPod, nil)
 	assert.False(t, found)
 
 	// Verify information about first pod has not been overwritten by adding
 	// other pods
-	sched, found = pMap.get(podInRc1_1)
+	sched, found = pMap.get(podInRc1_1, nil)
 	assert.True(t, found)
 	assert.True(t, sched)
 }
@@ -183,6 +186,19 @@ func TestFilterOutSchedulable(t *testing.T) {
 	assert.Equal(t, p1, res3[0])
 	assert.Equal(t, p2_1, res3[1])
 	assert.Equal(t, p2_2, res3[2])
+
+	excludedNode := BuildTestNode("node1", 2000, 2000000)
+	excludedNode.Annotations = map[string]string{simulator.NoRebalanceTargetAnnotationKey: "true"}
+	SetNodeReadyState(excludedNode, true, time.Time{})
+	res4 := FilterOutSchedulable(unschedulablePods, []*apiv1.Node{excludedNode}, []*apiv1.Pod{scheduledPod1, scheduledPod3}, []*apiv1.Pod{}, predicateChecker, 10)
+	assert.Equal(t, unschedulablePods, res4)
+
+	pressuredNode := BuildTestNode("node1", 2000, 2000000)
+	SetNodeReadyState(pressuredNode, true, time.Time{})
+	pressuredNode.Status.Conditions = append(pressuredNode.Status.Conditions,
+		apiv1.NodeCondition{Type: apiv1.NodeDiskPressure, Status: apiv1.ConditionTrue})
+	res5 := FilterOutSchedulable(unschedulablePods, []*apiv1.Node{pressuredNode}, []*apiv1.Pod{scheduledPod1, scheduledPod3}, []*apiv1.Pod{}, predicateChecker, 10)
+	assert.Equal(t, unschedulablePods, res5)
 }
 
 func TestFilterOutExpendableAndSplit(t *testing.T) {
@@ -282,10 +298,8 @@ func TestGetNodeInfosForGroups(t *testing.T) {
 		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
 	})
 
-	predicateChecker := simulator.NewTestPredicateChecker()
-
 	res, err := GetNodeInfosForGroups([]*apiv1.Node{n1, n2, n3, n4}, provider1, fakeClient,
-		[]*extensionsv1.DaemonSet{}, predicateChecker)
+		[]*extensionsv1.DaemonSet{}, nil, nil, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 4, len(res))
 	_, found := res["n1"]
@@ -299,11 +313,74 @@ func TestGetNodeInfosForGroups(t *testing.T) {
 
 	// Test for a nodegroup without nodes and TempleteNodeInfo not implemented by cloud proivder
 	res, err = GetNodeInfosForGroups([]*apiv1.Node{}, provider2, fakeClient,
-		[]*extensionsv1.DaemonSet{}, predicateChecker)
+		[]*extensionsv1.DaemonSet{}, nil, nil, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 0, len(res))
 }
 
+func TestGetNodeInfosForGroupsOnlyNodeNotReady(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, false, time.Now())
+	n1.Spec.Taints = []apiv1.Taint{
+		{Key: algorithm.TaintNodeNotReady, Effect: apiv1.TaintEffectNoExecute},
+		{Key: "node.kubernetes.io/not-ready", Effect: apiv1.TaintEffectNoSchedule},
+		{Key: "example.com/startup", Effect: apiv1.TaintEffectNoSchedule},
+		{Key: "dedicated", Value: "batch", Effect: apiv1.TaintEffectNoSchedule},
+	}
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	res, err := GetNodeInfosForGroups([]*apiv1.Node{n1}, provider, fakeClient,
+		[]*extensionsv1.DaemonSet{}, nil, nil, []string{"example.com/startup"})
+	assert.NoError(t, err)
+	nodeInfo, found := res["ng1"]
+	assert.True(t, found)
+	assert.Equal(t, []apiv1.Taint{{Key: "dedicated", Value: "batch", Effect: apiv1.TaintEffectNoSchedule}}, nodeInfo.Node().Spec.Taints)
+
+	// New nodes are ready, so pending pods can be put on the template.
+	assert.True(t, kube_util.IsNodeReadyAndSchedulable(nodeInfo.Node()))
+	pod := BuildTestPod("p1", 100, 0)
+	assert.NoError(t, simulator.NewTestPredicateChecker().CheckPredicates(pod, nil, nodeInfo, simulator.ReturnVerboseError))
+}
+
+func TestGetNodeInfosForGroupsLeastTaintedNode(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	SetNodeReadyState(n1, true, time.Now())
+	n1.Spec.Taints = []apiv1.Taint{{Key: "example.com/maintenance", Effect: apiv1.TaintEffectNoSchedule}}
+	n2 := BuildTestNode("n2", 2000, 1000)
+	SetNodeReadyState(n2, true, time.Now())
+	n3 := BuildTestNode("n3", 3000, 1000)
+	SetNodeReadyState(n3, true, time.Now())
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng1", n3)
+
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, &apiv1.PodList{Items: []apiv1.Pod{}}, nil
+	})
+
+	res, err := GetNodeInfosForGroups([]*apiv1.Node{n1, n2, n3}, provider, fakeClient,
+		[]*extensionsv1.DaemonSet{}, nil, nil, nil)
+	assert.NoError(t, err)
+	nodeInfo, found := res["ng1"]
+	assert.True(t, found)
+	// The first node without taints is used.
+	assert.Empty(t, nodeInfo.Node().Spec.Taints)
+	cpu := nodeInfo.Node().Status.Capacity[apiv1.ResourceCPU]
+	assert.Equal(t, int64(2000), cpu.MilliValue())
+}
+
 func TestRemoveOldUnregisteredNodes(t *testing.T) {
 	deletedNodes := make(chan string, 10)
 
@@ -362,7 +439,7 @@ func TestSanitizeNodeInfo(t *testing.T) {
 	nodeInfo := schedulercache.NewNodeInfo(pod)
 	nodeInfo.SetNode(node)
 
-	res, err := sanitizeNodeInfo(nodeInfo, "test-group")
+	res, err := sanitizeNodeInfo(nodeInfo, "test-group", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(res.Pods()))
 }
@@ -373,7 +450,7 @@ func TestSanitizeLabels(t *testing.T) {
 		kubeletapis.LabelHostname: "abc",
 		"x": "y",
 	}
-	node, err := sanitizeTemplateNode(oldNode, "bzium")
+	node, err := sanitizeTemplateNode(oldNode, "bzium", nil)
 	assert.NoError(t, err)
 	assert.NotEqual(t, node.Labels[kubeletapis.LabelHostname], "abc")
 	assert.Equal(t, node.Labels["x"], "y")
@@ -399,8 +476,17 @@ func TestSanitizeTaints(t *testing.T) {
 		Value:  "1",
 		Effect: apiv1.TaintEffectNoSchedule,
 	})
+	taints = append(taints, apiv1.Taint{
+		Key:    algorithm.TaintNodeDiskPressure,
+		Effect: apiv1.TaintEffectNoSchedule,
+	})
+	taints = append(taints, apiv1.Taint{
+		Key:    "ignored-taint",
+		Value:  "test3",
+		Effect: apiv1.TaintEffectNoSchedule,
+	})
 	oldNode.Spec.Taints = taints
-	node, err := sanitizeTemplateNode(oldNode, "bzium")
+	node, err := sanitizeTemplateNode(oldNode, "bzium", []string{"ignored-taint"})
 	assert.NoError(t, err)
 	assert.Equal(t, len(node.Spec.Taints), 1)
 	assert.Equal(t, node.Spec.Taints[0].Key, "test-taint")
@@ -449,6 +535,118 @@ func TestRemoveFixNodeTargetSize(t *testing.T) {
 	assert.Equal(t, "ng1/-2", change)
 }
 
+func TestFixNodeGroupSizePartialScaleUp(t *testing.T) {
+	sizeChanges := make(chan string, 10)
+	now := time.Now()
+
+	provider := testprovider.NewTestCloudProvider(func(nodegroup string, delta int) error {
+		sizeChanges <- fmt.Sprintf("%s/%d", nodegroup, delta)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 0, 20, 10)
+	nodes := make([]*apiv1.Node, 0)
+	for i := 0; i < 6; i++ {
+		node := BuildTestNode(fmt.Sprintf("ng1-%d", i), 1000, 1000)
+		SetNodeReadyState(node, true, now.Add(-time.Hour))
+		provider.AddNode("ng1", node)
+		nodes = append(nodes, node)
+	}
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		MaxNodeProvisionTime:      15 * time.Minute,
+	}, fakeLogRecorder)
+	clusterState.RegisterScaleUp(&clusterstate.ScaleUpRequest{
+		NodeGroupName:   "ng1",
+		Increase:        10,
+		Time:            now.Add(-20 * time.Minute),
+		ExpectedAddTime: now.Add(-5 * time.Minute),
+	})
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			UnregisteredNodeRemovalTime: 45 * time.Minute,
+		},
+		CloudProvider:        provider,
+		ClusterStateRegistry: clusterState,
+	}
+
+	// Instances may still arrive.
+	err := clusterState.UpdateNodes(nodes, now.Add(-16*time.Minute))
+	assert.NoError(t, err)
+	fixed, err := fixNodeGroupSize(context, now.Add(-16*time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, fixed)
+
+	// Target size should be lowered to the achieved size without waiting for UnregisteredNodeRemovalTime.
+	err = clusterState.UpdateNodes(nodes, now)
+	assert.NoError(t, err)
+	fixed, err = fixNodeGroupSize(context, now)
+	assert.NoError(t, err)
+	assert.True(t, fixed)
+	assert.Equal(t, "ng1/-4", getStringFromChan(sizeChanges))
+}
+
+func TestReconcileTargetSizes(t *testing.T) {
+	sizeChanges := make(chan string, 10)
+	now := time.Now()
+
+	// The target size was raised outside of CA, but no instances were added.
+	provider := testprovider.NewTestCloudProvider(func(nodegroup string, delta int) error {
+		sizeChanges <- fmt.Sprintf("%s/%d", nodegroup, delta)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 0, 20, 5)
+	nodes := make([]*apiv1.Node, 0)
+	for i := 0; i < 3; i++ {
+		node := BuildTestNode(fmt.Sprintf("ng1-%d", i), 1000, 1000)
+		SetNodeReadyState(node, true, now.Add(-time.Hour))
+		provider.AddNode("ng1", node)
+		nodes = append(nodes, node)
+	}
+
+	fakeClient := &fake.Clientset{}
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{
+		MaxTotalUnreadyPercentage: 10,
+		OkTotalUnreadyCount:       1,
+		MaxNodeProvisionTime:      15 * time.Minute,
+	}, fakeLogRecorder)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			ReconcileTargetSize: true,
+		},
+		CloudProvider:        provider,
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	// Instances may still arrive.
+	err := clusterState.UpdateNodes(nodes, now)
+	assert.NoError(t, err)
+	reconciled, err := reconcileTargetSizes(context, now)
+	assert.NoError(t, err)
+	assert.False(t, reconciled)
+
+	// Dry run only reports the change.
+	later := now.Add(16 * time.Minute)
+	err = clusterState.UpdateNodes(nodes, later)
+	assert.NoError(t, err)
+	context.DryRun = true
+	reconciled, err = reconcileTargetSizes(context, later)
+	assert.NoError(t, err)
+	assert.False(t, reconciled)
+	assert.Empty(t, sizeChanges)
+
+	context.DryRun = false
+	reconciled, err = reconcileTargetSizes(context, later)
+	assert.NoError(t, err)
+	assert.True(t, reconciled)
+	assert.Equal(t, "ng1/-2", getStringFromChan(sizeChanges))
+}
+
 func TestGetPotentiallyUnneededNodes(t *testing.T) {
 	ng1_1 := BuildTestNode("ng1-1", 1000, 1000)
 	ng1_2 := BuildTestNode("ng1-2", 1000, 1000)
@@ -527,3 +725,144 @@ func TestGetNodeCoresAndMemory(t *testing.T) {
 	_, _, err = getNodeCoresAndMemory(node)
 	assert.Error(t, err)
 }
+
+func TestGetPodsOnShuttingDownNodes(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Spec.Taints = []apiv1.Taint{{Key: kube_util.OutOfServiceTaint, Effect: apiv1.TaintEffectNoExecute}}
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	replicated := BuildTestPod("replicated", 100, 0)
+	replicated.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	replicated.Spec.NodeName = "n1"
+	ds := BuildTestPod("ds", 100, 0)
+	ds.OwnerReferences = GenerateOwnerReferences("ds", "DaemonSet", "extensions/v1beta1", "")
+	ds.Spec.NodeName = "n1"
+	unreplicated := BuildTestPod("unreplicated", 100, 0)
+	unreplicated.Spec.NodeName = "n1"
+	other := BuildTestPod("other", 100, 0)
+	other.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	other.Spec.NodeName = "n2"
+
+	departing := getPodsOnShuttingDownNodes([]*apiv1.Node{n1, n2}, []*apiv1.Pod{replicated, ds, unreplicated, other})
+	assert.Equal(t, 1, len(departing))
+	assert.Equal(t, "replicated", departing[0].Name)
+	assert.Equal(t, "", departing[0].Spec.NodeName)
+	assert.Equal(t, "n1", replicated.Spec.NodeName)
+
+	assert.Equal(t, []*apiv1.Node{n2}, filterOutShuttingDownNodes([]*apiv1.Node{n1, n2}))
+}
+
+func TestRemoveOldDeletedInstanceNodes(t *testing.T) {
+	now := time.Now()
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	deletedInstanceNodes := []clusterstate.DeletedInstanceNode{
+		{Node: n1, NodeGroupId: "ng1", DeletedSince: now.Add(-10 * time.Minute)},
+		{Node: n2, NodeGroupId: "ng1", DeletedSince: now.Add(-time.Minute)},
+	}
+
+	deletedNodes := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("delete", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		deletedNodes <- action.(core.DeleteAction).GetName()
+		return true, nil, nil
+	})
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	context := &AutoscalingContext{
+		AutoscalingOptions: AutoscalingOptions{
+			DeletedInstanceNodeRemovalTime: 5 * time.Minute,
+		},
+		ClientSet: fakeClient,
+	}
+
+	// Only n1 has been without an instance for long enough.
+	removeOldDeletedInstanceNodes(deletedInstanceNodes, context, now, fakeLogRecorder)
+	assert.Equal(t, "n1", getStringFromChanImmediately(deletedNodes))
+	assert.Equal(t, "Nothing returned", getStringFromChanImmediately(deletedNodes))
+
+	assert.Equal(t, []*apiv1.Node{n2}, filterOutDeletedInstanceNodes([]*apiv1.Node{n1, n2}, deletedInstanceNodes[:1]))
+}
+
+func TestFilterOutOrphanedDaemonSetPods(t *testing.T) {
+	deletionTime := metav1.NewTime(time.Now().Add(-time.Minute))
+	buildDaemonSetPod := func(name string, uid types.UID, terminating bool) *apiv1.Pod {
+		pod := BuildTestPod(name, 600, 0)
+		pod.OwnerReferences = GenerateOwnerReferences("ds", "DaemonSet", "extensions/v1beta1", uid)
+		pod.Spec.NodeName = "n1"
+		if terminating {
+			pod.DeletionTimestamp = &deletionTime
+		}
+		return pod
+	}
+	existing := &extensionsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "ds", Namespace: "default", UID: "existing"}}
+
+	running := buildDaemonSetPod("running", "existing", false)
+	updated := buildDaemonSetPod("updated", "existing", true)
+	orphaned := buildDaemonSetPod("orphaned", "deleted", true)
+
+	// Daemon sets are not listed without terminating DaemonSet pods.
+	lister := &daemonSetListerMock{}
+	kept, filtered, err := filterOutOrphanedDaemonSetPods([]*apiv1.Pod{running}, lister)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{running}, kept)
+	assert.Empty(t, filtered)
+	lister.AssertNotCalled(t, "List")
+
+	lister.On("List").Return([]*extensionsv1.DaemonSet{existing}, nil)
+	kept, filtered, err = filterOutOrphanedDaemonSetPods([]*apiv1.Pod{running, updated, orphaned}, lister)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{running, updated}, kept)
+	assert.Equal(t, []*apiv1.Pod{orphaned}, filtered)
+}
+
+func TestScaleDownNodeWithOrphanedDaemonSetPod(t *testing.T) {
+	// The only pod of n1 is a terminating pod of a deleted DaemonSet, which makes the node look
+	// highly utilized and, in detailed drain checks, unremovable.
+	n1 := BuildTestNode("n1", 1000, 10)
+	n2 := BuildTestNode("n2", 1000, 10)
+	SetNodeReadyState(n1, true, time.Time{})
+	SetNodeReadyState(n2, true, time.Time{})
+	orphaned := BuildTestPod("orphaned", 900, 0)
+	orphaned.OwnerReferences = GenerateOwnerReferences("ds", "DaemonSet", "extensions/v1beta1", "deleted")
+	orphaned.Spec.NodeName = "n1"
+	deletionTime := metav1.NewTime(time.Now().Add(-time.Minute))
+	orphaned.DeletionTimestamp = &deletionTime
+
+	fakeClient := &fake.Clientset{}
+	fakeRecorder := kube_record.NewFakeRecorder(10)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", fakeRecorder, false)
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	context := &AutoscalingContext{
+		AutoscalingOptions:   defaultScaleDownOptions,
+		ClusterStateRegistry: clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder),
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		LogRecorder:          fakeLogRecorder,
+	}
+	nodes := []*apiv1.Node{n1, n2}
+
+	sd := NewScaleDown(context)
+	assert.NoError(t, sd.UpdateUnneededNodes(nodes, nodes, []*apiv1.Pod{orphaned}, time.Now(), nil))
+	assert.NotContains(t, sd.unneededNodes, "n1")
+
+	lister := &daemonSetListerMock{}
+	lister.On("List").Return([]*extensionsv1.DaemonSet{}, nil)
+	pods, orphanedPods, err := filterOutOrphanedDaemonSetPods([]*apiv1.Pod{orphaned}, lister)
+	assert.NoError(t, err)
+	recordOrphanedDaemonSetPods(context, nodes, orphanedPods, sd.reportedOrphanedDaemonSetPods)
+	assert.Equal(t, "Normal OrphanedDaemonSetPods ignoring terminating pods of deleted DaemonSets in scale-down: default/orphaned",
+		<-fakeRecorder.Events)
+	// The pods are reported only once.
+	recordOrphanedDaemonSetPods(context, nodes, orphanedPods, sd.reportedOrphanedDaemonSetPods)
+	assert.Empty(t, fakeRecorder.Events)
+
+	sd = NewScaleDown(context)
+	assert.NoError(t, sd.UpdateUnneededNodes(nodes, nodes, pods, time.Now(), nil))
+	assert.Contains(t, sd.unneededNodes, "n1")
+	assert.Equal(t, 0.0, sd.nodeUtilizationMap["n1"])
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	v1helper "k8s.io/kubernetes/pkg/api/v1/helper"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// volumeZoneChecker rules out node groups in zones other than the zone of persistent volumes bound
+// to pods, so that scale-up doesn't add nodes in a zone the pods can't use. Zones of pods, and the
+// claims and volumes they come from, are looked up once per scale-up.
+type volumeZoneChecker struct {
+	client kube_client.Interface
+	// zones are the zones the volumes of each pod are in, nil for pods without zonal volumes.
+	zones map[*apiv1.Pod]map[string]bool
+	// matched are pods with zonal volumes for which some node group in the right zone was found.
+	matched map[*apiv1.Pod]bool
+	// claimVolumes are the names of volumes bound to claims by namespace/name of the claim, empty
+	// for unbound claims.
+	claimVolumes map[string]string
+	// volumeZones are the zones of persistent volumes by name, nil for volumes that aren't zonal.
+	volumeZones map[string]map[string]bool
+}
+
+func newVolumeZoneChecker(client kube_client.Interface) *volumeZoneChecker {
+	return &volumeZoneChecker{
+		client:       client,
+		zones:        make(map[*apiv1.Pod]map[string]bool),
+		matched:      make(map[*apiv1.Pod]bool),
+		claimVolumes: make(map[string]string),
+		volumeZones:  make(map[string]map[string]bool),
+	}
+}
+
+// check returns an error if the template node of a node group is in a zone none of the bound
+// volumes of the pod can be attached in. Template nodes without the zone label are not ruled out.
+func (c *volumeZoneChecker) check(pod *apiv1.Pod, nodeInfo *schedulercache.NodeInfo) error {
+	zones := c.podZones(pod)
+	if zones == nil || nodeInfo.Node() == nil {
+		return nil
+	}
+	zone, found := nodeInfo.Node().Labels[kubeletapis.LabelZoneFailureDomain]
+	if !found {
+		return nil
+	}
+	if !zones[zone] {
+		return fmt.Errorf("volumes of pod %s/%s are in %s, node is in zone %s", pod.Namespace, pod.Name, formatZones(zones), zone)
+	}
+	c.matched[pod] = true
+	return nil
+}
+
+// unmatchedReason returns why the pod didn't trigger scale-up if none of the node groups is in
+// the zone of its volumes, or an empty string otherwise.
+func (c *volumeZoneChecker) unmatchedReason(pod *apiv1.Pod) string {
+	zones := c.zones[pod]
+	if zones == nil || c.matched[pod] {
+		return ""
+	}
+	if len(zones) == 0 {
+		return "volumes of the pod are in different zones"
+	}
+	if len(zones) == 1 {
+		return fmt.Sprintf("no node group matches volume zone %s", formatZones(zones))
+	}
+	return fmt.Sprintf("no node group matches volume zones %s", formatZones(zones))
+}
+
+func (c *volumeZoneChecker) podZones(pod *apiv1.Pod) map[string]bool {
+	if zones, found := c.zones[pod]; found {
+		return zones
+	}
+	if c.client == nil {
+		return nil
+	}
+	zones, err := c.getPodVolumeZones(pod)
+	if err != nil {
+		glog.V(4).Infof("Failed to get zones of volumes of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		zones = nil
+	}
+	c.zones[pod] = zones
+	return zones
+}
+
+// getPodVolumeZones returns the zones the pod can run in because of the persistent volumes bound
+// to its claims, or nil if its volumes don't restrict the zone. Unbound claims are skipped.
+func (c *volumeZoneChecker) getPodVolumeZones(pod *apiv1.Pod) (map[string]bool, error) {
+	var result map[string]bool
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		volumeName, err := c.getClaimVolume(pod.Namespace, volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return nil, err
+		}
+		if volumeName == "" {
+			continue
+		}
+		zones, err := c.getVolumeZones(volumeName)
+		if err != nil {
+			return nil, err
+		}
+		if zones == nil {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]bool, len(zones))
+			for zone := range zones {
+				result[zone] = true
+			}
+			continue
+		}
+		// The pod needs a zone all of its volumes are in.
+		for zone := range result {
+			if !zones[zone] {
+				delete(result, zone)
+			}
+		}
+	}
+	return result, nil
+}
+
+// getClaimVolume returns the name of the volume bound to the claim, or an empty string if the
+// claim is unbound.
+func (c *volumeZoneChecker) getClaimVolume(namespace, name string) (string, error) {
+	key := namespace + "/" + name
+	if volumeName, found := c.claimVolumes[key]; found {
+		return volumeName, nil
+	}
+	pvc, err := c.client.CoreV1().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	c.claimVolumes[key] = pvc.Spec.VolumeName
+	return pvc.Spec.VolumeName, nil
+}
+
+func (c *volumeZoneChecker) getVolumeZones(name string) (map[string]bool, error) {
+	if zones, found := c.volumeZones[name]; found {
+		return zones, nil
+	}
+	pv, err := c.client.CoreV1().PersistentVolumes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	zones, err := getPersistentVolumeZones(pv)
+	if err != nil {
+		return nil, err
+	}
+	c.volumeZones[name] = zones
+	return zones, nil
+}
+
+// getPersistentVolumeZones returns the zones of the persistent volume from its zone label or the
+// zones required by its node affinity, or nil if the volume isn't zonal.
+func getPersistentVolumeZones(pv *apiv1.PersistentVolume) (map[string]bool, error) {
+	if label, found := pv.Labels[kubeletapis.LabelZoneFailureDomain]; found && label != "" {
+		zones := make(map[string]bool)
+		for _, zone := range strings.Split(label, kubeletapis.LabelMultiZoneDelimiter) {
+			zones[zone] = true
+		}
+		return zones, nil
+	}
+	affinity, err := v1helper.GetStorageNodeAffinityFromAnnotation(pv.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if affinity == nil || affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil, nil
+	}
+	terms := affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	// Terms are ORed, so the volume is zonal only if each of the terms requires a zone.
+	zones := make(map[string]bool)
+	for _, term := range terms {
+		termRequiresZone := false
+		for _, requirement := range term.MatchExpressions {
+			if requirement.Operator != apiv1.NodeSelectorOpIn || requirement.Key != kubeletapis.LabelZoneFailureDomain {
+				continue
+			}
+			termRequiresZone = true
+			for _, value := range requirement.Values {
+				zones[value] = true
+			}
+		}
+		if !termRequiresZone {
+			return nil, nil
+		}
+	}
+	return zones, nil
+}
+
+func formatZones(zones map[string]bool) string {
+	result := make([]string, 0, len(zones))
+	for zone := range zones {
+		result = append(result, zone)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ", ")
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate"
+	"k8s.io/autoscaler/cluster-autoscaler/clusterstate/utils"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	kube_record "k8s.io/client-go/tools/record"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestPodWithClaim(name, claimName string) *apiv1.Pod {
+	pod := BuildTestPod(name, 500, 0)
+	pod.Spec.Volumes = []apiv1.Volume{{
+		Name: "data",
+		VolumeSource: apiv1.VolumeSource{
+			PersistentVolumeClaim: &apiv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+		},
+	}}
+	return pod
+}
+
+func buildTestBoundClaim(name, volumeName string) *apiv1.PersistentVolumeClaim {
+	return &apiv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       apiv1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+	}
+}
+
+func buildTestZonalVolume(name, zone string) *apiv1.PersistentVolume {
+	return &apiv1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{kubeletapis.LabelZoneFailureDomain: zone},
+		},
+	}
+}
+
+func buildTestZonalNode(name, zone string) *apiv1.Node {
+	node := BuildTestNode(name, 1000, 1000)
+	node.Labels[kubeletapis.LabelZoneFailureDomain] = zone
+	SetNodeReadyState(node, true, time.Now())
+	return node
+}
+
+func TestGetPersistentVolumeZones(t *testing.T) {
+	zones, err := getPersistentVolumeZones(buildTestZonalVolume("pv1", "us-east1-b"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"us-east1-b": true}, zones)
+
+	zones, err = getPersistentVolumeZones(buildTestZonalVolume("pv1", "us-east1-b__us-east1-c"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"us-east1-b": true, "us-east1-c": true}, zones)
+
+	zones, err = getPersistentVolumeZones(&apiv1.PersistentVolume{})
+	assert.NoError(t, err)
+	assert.Nil(t, zones)
+
+	withAffinity := &apiv1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				apiv1.AlphaStorageNodeAffinityAnnotation: `{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[` +
+					`{"matchExpressions":[{"key":"failure-domain.beta.kubernetes.io/zone","operator":"In","values":["us-east1-d"]}]}]}}`,
+			},
+		},
+	}
+	zones, err = getPersistentVolumeZones(withAffinity)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"us-east1-d": true}, zones)
+
+	// Volumes restricted to hosts aren't zonal.
+	withHostAffinity := &apiv1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				apiv1.AlphaStorageNodeAffinityAnnotation: `{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[` +
+					`{"matchExpressions":[{"key":"kubernetes.io/hostname","operator":"In","values":["n1"]}]}]}}`,
+			},
+		},
+	}
+	zones, err = getPersistentVolumeZones(withHostAffinity)
+	assert.NoError(t, err)
+	assert.Nil(t, zones)
+}
+
+func TestGetPodVolumeZones(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(
+		buildTestBoundClaim("claim-b", "pv-b"),
+		buildTestBoundClaim("claim-bc", "pv-bc"),
+		&apiv1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "unbound", Namespace: "default"}},
+		buildTestZonalVolume("pv-b", "us-east1-b"),
+		buildTestZonalVolume("pv-bc", "us-east1-b__us-east1-c"))
+	checker := newVolumeZoneChecker(fakeClient)
+
+	zones, err := checker.getPodVolumeZones(buildTestPodWithClaim("p1", "claim-bc"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"us-east1-b": true, "us-east1-c": true}, zones)
+
+	pod := buildTestPodWithClaim("p2", "claim-bc")
+	pod.Spec.Volumes = append(pod.Spec.Volumes, buildTestPodWithClaim("p2", "claim-b").Spec.Volumes...)
+	zones, err = checker.getPodVolumeZones(pod)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"us-east1-b": true}, zones)
+
+	// Claims and volumes are looked up once, and intersecting zones doesn't change cached zones.
+	assert.Equal(t, 4, len(fakeClient.Actions()))
+	zones, err = checker.getPodVolumeZones(buildTestPodWithClaim("p5", "claim-bc"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"us-east1-b": true, "us-east1-c": true}, zones)
+	assert.Equal(t, 4, len(fakeClient.Actions()))
+
+	zones, err = checker.getPodVolumeZones(buildTestPodWithClaim("p3", "unbound"))
+	assert.NoError(t, err)
+	assert.Nil(t, zones)
+
+	_, err = checker.getPodVolumeZones(buildTestPodWithClaim("p4", "missing"))
+	assert.Error(t, err)
+}
+
+func runVolumeZoneScaleUp(t *testing.T, volumeZone string) (bool, []string, *kube_record.FakeRecorder) {
+	n1 := buildTestZonalNode("n1", "us-east1-a")
+	n2 := buildTestZonalNode("n2", "us-east1-b")
+	fakeClient := fake.NewSimpleClientset(
+		buildTestBoundClaim("data", "pv1"),
+		buildTestZonalVolume("pv1", volumeZone))
+
+	scaledUp := make([]string, 0)
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		scaledUp = append(scaledUp, nodeGroup)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng2", n2)
+
+	fakeRecorder := kube_record.NewFakeRecorder(5)
+	fakeLogRecorder, _ := utils.NewStatusMapRecorder(fakeClient, "kube-system", kube_record.NewFakeRecorder(5), false)
+	clusterState := clusterstate.NewClusterStateRegistry(provider, clusterstate.ClusterStateRegistryConfig{}, fakeLogRecorder)
+	clusterState.UpdateNodes([]*apiv1.Node{n1, n2}, time.Now())
+	context := &AutoscalingContext{
+		AutoscalingOptions:   defaultOptions,
+		PredicateChecker:     simulator.NewTestPredicateChecker(),
+		CloudProvider:        provider,
+		ClientSet:            fakeClient,
+		Recorder:             fakeRecorder,
+		ExpanderStrategy:     random.NewStrategy(),
+		ClusterStateRegistry: clusterState,
+		LogRecorder:          fakeLogRecorder,
+	}
+
+	result, err := ScaleUp(context, []*apiv1.Pod{buildTestPodWithClaim("p1", "data")}, []*apiv1.Node{n1, n2}, []*extensionsv1.DaemonSet{}, nil)
+	assert.NoError(t, err)
+	return result, scaledUp, fakeRecorder
+}
+
+func TestScaleUpVolumeZone(t *testing.T) {
+	result, scaledUp, _ := runVolumeZoneScaleUp(t, "us-east1-b")
+	assert.True(t, result)
+	assert.Equal(t, []string{"ng2"}, scaledUp)
+}
+
+func TestScaleUpVolumeZoneWithoutNodeGroup(t *testing.T) {
+	result, scaledUp, fakeRecorder := runVolumeZoneScaleUp(t, "us-east1-c")
+	assert.False(t, result)
+	assert.Empty(t, scaledUp)
+	assert.Equal(t, "Normal NotTriggerScaleUp pod didn't trigger scale-up (no node group matches volume zone us-east1-c)",
+		getStringFromChan(fakeRecorder.Events))
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// DefaultCrashDumpMaxSize is the default limit of the size of a crash dump, in bytes.
+	DefaultCrashDumpMaxSize = 1024 * 1024
+	redactedValue           = "<redacted>"
+)
+
+// NodeGroupRecord describes the size and limits of a node group.
+type NodeGroupRecord struct {
+	Id         string `json:"id"`
+	MinSize    int    `json:"minSize"`
+	MaxSize    int    `json:"maxSize"`
+	TargetSize int    `json:"targetSize"`
+}
+
+// LoopSummary describes the cluster as seen by the last main loop.
+type LoopSummary struct {
+	Time       time.Time         `json:"time"`
+	Nodes      int               `json:"nodes"`
+	NodeGroups []NodeGroupRecord `json:"nodeGroups,omitempty"`
+	// PendingPods are copies of the pods CA tried to help, with env values and annotation values redacted.
+	PendingPods []*apiv1.Pod `json:"pendingPods,omitempty"`
+	// UnneededNodes map names of unneeded nodes to the time since when they are unneeded.
+	UnneededNodes map[string]time.Time `json:"unneededNodes,omitempty"`
+	// Backoffs map ids of node groups in scale-up backoff to the time the backoff ends.
+	Backoffs map[string]time.Time `json:"backoffs,omitempty"`
+}
+
+// CrashState is the content of a crash dump.
+type CrashState struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Stack  string    `json:"stack,omitempty"`
+	// LastLoop is the summary of the last main loop, if any.
+	LastLoop *LoopSummary `json:"lastLoop,omitempty"`
+	// LastScaleUpDecision contains scale-up options considered in the last scale-up.
+	LastScaleUpDecision *ScaleUpDecision `json:"lastScaleUpDecision,omitempty"`
+	// Truncated is set if some of the state was dropped to fit the size limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// CrashReporter keeps the latest internal state of CA and writes it out when CA panics or exits
+// with a fatal error. A nil CrashReporter does nothing.
+type CrashReporter struct {
+	sync.Mutex
+	destination  string
+	maxSize      int
+	lastLoop     *LoopSummary
+	lastDecision *ScaleUpDecision
+	write        func(destination, name string, data []byte) error
+}
+
+// NewCrashReporter builds a CrashReporter writing dumps of at most maxSize bytes to the destination,
+// which is either a local directory or a gs://bucket/prefix or s3://bucket/prefix URL. Uploads use
+// ambient credentials. Returns nil if destination is empty.
+func NewCrashReporter(destination string, maxSize int) (*CrashReporter, error) {
+	if destination == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crash dump destination %s: %v", destination, err)
+	}
+	switch u.Scheme {
+	case "", "file":
+		if err := os.MkdirAll(localPath(u), 0755); err != nil {
+			return nil, err
+		}
+	case "gs", "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid crash dump destination %s: missing bucket", destination)
+		}
+	default:
+		return nil, fmt.Errorf("invalid crash dump destination %s: unsupported scheme %s", destination, u.Scheme)
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultCrashDumpMaxSize
+	}
+	return &CrashReporter{
+		destination: destination,
+		maxSize:     maxSize,
+		write:       writeCrashDump,
+	}, nil
+}
+
+// UpdateLoop replaces the summary of the last main loop. Pending pods are redacted.
+func (r *CrashReporter) UpdateLoop(summary *LoopSummary) {
+	if r == nil {
+		return
+	}
+	redacted := *summary
+	redacted.PendingPods = make([]*apiv1.Pod, 0, len(summary.PendingPods))
+	for _, pod := range summary.PendingPods {
+		redacted.PendingPods = append(redacted.PendingPods, redactPod(pod))
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.lastLoop = &redacted
+}
+
+// UpdateUnneededNodes sets unneeded nodes in the summary of the last main loop.
+func (r *CrashReporter) UpdateUnneededNodes(unneededNodes map[string]time.Time) {
+	if r == nil {
+		return
+	}
+	copied := make(map[string]time.Time, len(unneededNodes))
+	for name, since := range unneededNodes {
+		copied[name] = since
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.lastLoop == nil {
+		r.lastLoop = &LoopSummary{}
+	}
+	r.lastLoop.UnneededNodes = copied
+}
+
+// RecordScaleUpDecision keeps the decision as the last one made. It implements DecisionRecorder.
+func (r *CrashReporter) RecordScaleUpDecision(decision *ScaleUpDecision) error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.lastDecision = decision
+	return nil
+}
+
+// HandlePanic writes a crash dump if the calling goroutine is panicking and continues the panic.
+// It has to be deferred.
+func (r *CrashReporter) HandlePanic() {
+	if p := recover(); p != nil {
+		if err := r.Dump(fmt.Sprintf("panic: %v", p), debug.Stack()); err != nil {
+			glog.Errorf("Failed to write crash dump: %v", err)
+		}
+		panic(p)
+	}
+}
+
+// Fatalf writes a crash dump and exits like glog.Fatalf.
+func (r *CrashReporter) Fatalf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if err := r.Dump("fatal: "+message, debug.Stack()); err != nil {
+		glog.Errorf("Failed to write crash dump: %v", err)
+	}
+	glog.Fatal(message)
+}
+
+// Dump writes the current state with the given reason to the destination.
+func (r *CrashReporter) Dump(reason string, stack []byte) error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	state := &CrashState{
+		Time:                time.Now(),
+		Reason:              reason,
+		Stack:               string(stack),
+		LastLoop:            r.lastLoop,
+		LastScaleUpDecision: r.lastDecision,
+	}
+	r.Unlock()
+
+	data, err := serializeCrashState(state, r.maxSize)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("crash-%d.json", state.Time.UnixNano())
+	if err := r.write(r.destination, name, data); err != nil {
+		return err
+	}
+	glog.Infof("Crash dump written to %s", r.destination)
+	return nil
+}
+
+// serializeCrashState marshals the state, dropping its least important parts until it fits maxSize:
+// pending pods, the scale-up decision, unneeded nodes and finally the stack trace.
+func serializeCrashState(state *CrashState, maxSize int) ([]byte, error) {
+	// The state is shared with the reporter, so it's trimmed on a copy.
+	trimmed := *state
+	if state.LastLoop != nil {
+		loop := *state.LastLoop
+		trimmed.LastLoop = &loop
+	}
+	for {
+		data, err := json.Marshal(&trimmed)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= maxSize {
+			return data, nil
+		}
+		trimmed.Truncated = true
+		switch {
+		case trimmed.LastLoop != nil && len(trimmed.LastLoop.PendingPods) > 0:
+			trimmed.LastLoop.PendingPods = trimmed.LastLoop.PendingPods[:len(trimmed.LastLoop.PendingPods)/2]
+		case trimmed.LastScaleUpDecision != nil:
+			trimmed.LastScaleUpDecision = nil
+		case trimmed.LastLoop != nil && len(trimmed.LastLoop.UnneededNodes) > 0:
+			trimmed.LastLoop.UnneededNodes = nil
+		case trimmed.LastLoop != nil:
+			trimmed.LastLoop = nil
+		case len(trimmed.Stack) > 0:
+			trimmed.Stack = trimmed.Stack[:len(trimmed.Stack)/2]
+		default:
+			return nil, fmt.Errorf("crash dump doesn't fit in %d bytes", maxSize)
+		}
+	}
+}
+
+// redactPod returns a copy of the pod without values of annotations and environment variables,
+// which may contain secrets.
+func redactPod(pod *apiv1.Pod) *apiv1.Pod {
+	redacted := pod.DeepCopy()
+	for key := range redacted.Annotations {
+		redacted.Annotations[key] = redactedValue
+	}
+	redactContainers := func(containers []apiv1.Container) {
+		for i := range containers {
+			for j := range containers[i].Env {
+				containers[i].Env[j].Value = redactedValue
+				containers[i].Env[j].ValueFrom = nil
+			}
+		}
+	}
+	redactContainers(redacted.Spec.Containers)
+	redactContainers(redacted.Spec.InitContainers)
+	return redacted
+}
+
+func localPath(u *url.URL) string {
+	if u.Scheme == "file" {
+		return u.Path
+	}
+	return u.String()
+}
+
+func writeCrashDump(destination, name string, data []byte) error {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return err
+	}
+	object := path.Join(trimSlash(u.Path), name)
+	switch u.Scheme {
+	case "gs":
+		return uploadToGcs(u.Host, object, data)
+	case "s3":
+		return uploadToS3(u.Host, object, data)
+	default:
+		return ioutil.WriteFile(filepath.Join(localPath(u), name), data, 0644)
+	}
+}
+
+func trimSlash(p string) string {
+	for len(p) > 0 && p[0] == '/' {
+		p = p[1:]
+	}
+	return p
+}
+
+func uploadToGcs(bucket, object string, data []byte) error {
+	client, err := google.DefaultClient(oauth2.NoContext, "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return err
+	}
+	uploadUrl := fmt.Sprintf("https://www.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		url.PathEscape(bucket), url.QueryEscape(object))
+	resp, err := client.Post(uploadUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return checkUploadResponse(resp)
+}
+
+func uploadToS3(bucket, object string, data []byte) error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, object), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signer := v4.NewSigner(sess.Config.Credentials, func(s *v4.Signer) {
+		s.DisableURIPathEscaping = true
+	})
+	if _, err := signer.Sign(req, bytes.NewReader(data), "s3", region, time.Now()); err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkUploadResponse(resp)
+}
+
+func checkUploadResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed with status %s: %s", resp.Status, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildCrashTestLoop(podCount int) *LoopSummary {
+	pods := make([]*apiv1.Pod, 0, podCount)
+	for i := 0; i < podCount; i++ {
+		pod := BuildTestPod(fmt.Sprintf("p%d", i), 100, 0)
+		pod.Annotations = map[string]string{"secret-annotation": "hunter2"}
+		pod.Spec.Containers[0].Env = []apiv1.EnvVar{
+			{Name: "PASSWORD", Value: "hunter2"},
+			{Name: "TOKEN", ValueFrom: &apiv1.EnvVarSource{SecretKeyRef: &apiv1.SecretKeySelector{Key: "token"}}},
+		}
+		pods = append(pods, pod)
+	}
+	now := time.Unix(1500000000, 0).UTC()
+	return &LoopSummary{
+		Time:          now,
+		Nodes:         3,
+		NodeGroups:    []NodeGroupRecord{{Id: "ng1", MinSize: 1, MaxSize: 10, TargetSize: 3}},
+		PendingPods:   pods,
+		UnneededNodes: map[string]time.Time{"n1": now.Add(-time.Minute)},
+		Backoffs:      map[string]time.Time{"ng2": now.Add(5 * time.Minute)},
+	}
+}
+
+func readCrashDumps(t *testing.T, dir string) []*CrashState {
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	result := make([]*CrashState, 0, len(files))
+	for _, file := range files {
+		assert.True(t, strings.HasPrefix(file.Name(), "crash-"))
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		assert.NoError(t, err)
+		state := &CrashState{}
+		assert.NoError(t, json.Unmarshal(data, state))
+		result = append(result, state)
+	}
+	return result
+}
+
+func TestCrashReporterDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crash")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	reporter, err := NewCrashReporter(filepath.Join(dir, "dumps"), 0)
+	assert.NoError(t, err)
+	loop := buildCrashTestLoop(2)
+	reporter.UpdateLoop(loop)
+	reporter.UpdateUnneededNodes(map[string]time.Time{"n2": loop.Time})
+	pods, options, nodeInfos := buildDecisionInput()
+	reporter.RecordScaleUpDecision(NewScaleUpDecision(loop.Time, expander.MostPodsExpanderName, pods, options, nodeInfos, &options[1]))
+
+	assert.NoError(t, reporter.Dump("panic: test", []byte("goroutine 1")))
+	states := readCrashDumps(t, filepath.Join(dir, "dumps"))
+	assert.Len(t, states, 1)
+	state := states[0]
+	assert.Equal(t, "panic: test", state.Reason)
+	assert.Equal(t, "goroutine 1", state.Stack)
+	assert.False(t, state.Truncated)
+	assert.Equal(t, 3, state.LastLoop.Nodes)
+	assert.Equal(t, loop.NodeGroups, state.LastLoop.NodeGroups)
+	assert.Equal(t, map[string]time.Time{"n2": loop.Time}, state.LastLoop.UnneededNodes)
+	assert.Equal(t, loop.Backoffs, state.LastLoop.Backoffs)
+	assert.Equal(t, "ng2", state.LastScaleUpDecision.Choice)
+
+	assert.Len(t, state.LastLoop.PendingPods, 2)
+	pod := state.LastLoop.PendingPods[0]
+	assert.Equal(t, "p0", pod.Name)
+	assert.Equal(t, map[string]string{"secret-annotation": redactedValue}, pod.Annotations)
+	assert.Equal(t, []apiv1.EnvVar{{Name: "PASSWORD", Value: redactedValue}, {Name: "TOKEN", Value: redactedValue}},
+		pod.Spec.Containers[0].Env)
+	// Pods of the loop are not modified.
+	assert.Equal(t, "hunter2", loop.PendingPods[0].Annotations["secret-annotation"])
+	assert.Equal(t, "hunter2", loop.PendingPods[0].Spec.Containers[0].Env[0].Value)
+}
+
+func TestCrashReporterDumpSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crash")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	reporter, err := NewCrashReporter(dir, 20000)
+	assert.NoError(t, err)
+	reporter.UpdateLoop(buildCrashTestLoop(100))
+	pods, options, nodeInfos := buildDecisionInput()
+	reporter.RecordScaleUpDecision(NewScaleUpDecision(time.Now(), expander.MostPodsExpanderName, pods, options, nodeInfos, &options[1]))
+
+	assert.NoError(t, reporter.Dump("fatal: test", []byte("goroutine 1")))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.True(t, files[0].Size() <= 20000)
+	state := readCrashDumps(t, dir)[0]
+	assert.True(t, state.Truncated)
+	assert.True(t, len(state.LastLoop.PendingPods) > 0)
+	assert.True(t, len(state.LastLoop.PendingPods) < 100)
+	assert.NotNil(t, state.LastScaleUpDecision)
+	assert.Equal(t, "goroutine 1", state.Stack)
+
+	// Everything but the reason and part of the stack is dropped if needed.
+	data, err := serializeCrashState(&CrashState{
+		Reason:   "fatal: test",
+		Stack:    strings.Repeat("x", 1000),
+		LastLoop: buildCrashTestLoop(10),
+	}, 300)
+	assert.NoError(t, err)
+	assert.True(t, len(data) <= 300)
+	state = &CrashState{}
+	assert.NoError(t, json.Unmarshal(data, state))
+	assert.Equal(t, "fatal: test", state.Reason)
+	assert.Nil(t, state.LastLoop)
+	assert.NotEmpty(t, state.Stack)
+
+	_, err = serializeCrashState(&CrashState{Reason: strings.Repeat("x", 1000)}, 300)
+	assert.Error(t, err)
+}
+
+func TestCrashReporterHandlePanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crash")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	reporter, err := NewCrashReporter("file://"+dir, 0)
+	assert.NoError(t, err)
+	assert.Panics(t, func() {
+		defer reporter.HandlePanic()
+		panic("test")
+	})
+	states := readCrashDumps(t, dir)
+	assert.Len(t, states, 1)
+	assert.Equal(t, "panic: test", states[0].Reason)
+	assert.Contains(t, states[0].Stack, "TestCrashReporterHandlePanic")
+}
+
+func TestNewCrashReporter(t *testing.T) {
+	reporter, err := NewCrashReporter("", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, reporter)
+	// A nil reporter does nothing.
+	reporter.UpdateLoop(buildCrashTestLoop(1))
+	assert.NoError(t, reporter.Dump("panic: test", nil))
+
+	_, err = NewCrashReporter("gs://bucket/prefix", 0)
+	assert.NoError(t, err)
+	_, err = NewCrashReporter("s3://bucket", 0)
+	assert.NoError(t, err)
+	_, err = NewCrashReporter("s3:///prefix", 0)
+	assert.Error(t, err)
+	_, err = NewCrashReporter("http://example.com/dumps", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/estimator"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/mostpods"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/waste"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// PodRecord identifies a pending pod and the resources it requests.
+type PodRecord struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Hash is a hash of the pod spec, allowing to tell apart pods with reused names.
+	Hash string `json:"hash"`
+	// MilliCPU is the total CPU requested by the pod containers.
+	MilliCPU int64 `json:"milliCPU"`
+	// Memory is the total memory (in bytes) requested by the pod containers.
+	Memory int64 `json:"memory"`
+}
+
+// OptionRecord describes a single expansion option considered by the expander.
+type OptionRecord struct {
+	NodeGroup string `json:"nodeGroup"`
+	NodeCount int    `json:"nodeCount"`
+	Debug     string `json:"debug,omitempty"`
+	// Pods contains namespace/name of the pods that would be helped by the option.
+	Pods []string `json:"pods"`
+	// Scores of the option by the scoring strategies in the expander chain, keyed by strategy name.
+	Scores map[string]float64 `json:"scores,omitempty"`
+}
+
+// NodeTemplateRecord contains capacity of a node from a node group template.
+type NodeTemplateRecord struct {
+	MilliCPU int64 `json:"milliCPU"`
+	Memory   int64 `json:"memory"`
+}
+
+// ScaleUpDecision is a record of a single expander decision made during scale-up.
+type ScaleUpDecision struct {
+	Time     time.Time `json:"time"`
+	Expander string    `json:"expander"`
+	// PendingPods are all pods CA tried to help in the loop.
+	PendingPods []PodRecord    `json:"pendingPods"`
+	Options     []OptionRecord `json:"options"`
+	// NodeTemplates are keyed by node group id.
+	NodeTemplates map[string]NodeTemplateRecord `json:"nodeTemplates"`
+	// Choice is the id of the node group picked by the expander, empty if there was none.
+	Choice string `json:"choice"`
+	// PackingTraces contain pod placements simulated by the binpacking estimator, keyed by node group id.
+	PackingTraces map[string][]estimator.PodPlacement `json:"packingTraces,omitempty"`
+}
+
+// NewScaleUpDecision builds a ScaleUpDecision from the expander input and output.
+func NewScaleUpDecision(now time.Time, expanderName string, pendingPods []*apiv1.Pod, options []expander.Option,
+	nodeInfos map[string]*schedulercache.NodeInfo, choice *expander.Option) *ScaleUpDecision {
+	decision := &ScaleUpDecision{
+		Time:          now,
+		Expander:      expanderName,
+		PendingPods:   make([]PodRecord, 0, len(pendingPods)),
+		Options:       make([]OptionRecord, 0, len(options)),
+		NodeTemplates: make(map[string]NodeTemplateRecord),
+	}
+	for _, pod := range pendingPods {
+		milliCPU, memory := podRequests(pod)
+		decision.PendingPods = append(decision.PendingPods, PodRecord{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Hash:      podSpecHash(pod),
+			MilliCPU:  milliCPU,
+			Memory:    memory,
+		})
+	}
+	for _, option := range options {
+		record := OptionRecord{
+			NodeGroup: option.NodeGroup.Id(),
+			NodeCount: option.NodeCount,
+			Debug:     option.Debug,
+			Pods:      make([]string, 0, len(option.Pods)),
+			Scores:    OptionScores(expanderName, option, nodeInfos),
+		}
+		for _, pod := range option.Pods {
+			record.Pods = append(record.Pods, podKey(pod.Namespace, pod.Name))
+		}
+		decision.Options = append(decision.Options, record)
+
+		if nodeInfo, found := nodeInfos[option.NodeGroup.Id()]; found && nodeInfo.Node() != nil {
+			capacity := nodeInfo.Node().Status.Capacity
+			cpu := capacity[apiv1.ResourceCPU]
+			memory := capacity[apiv1.ResourceMemory]
+			decision.NodeTemplates[option.NodeGroup.Id()] = NodeTemplateRecord{
+				MilliCPU: cpu.MilliValue(),
+				Memory:   memory.Value(),
+			}
+		}
+	}
+	if choice != nil {
+		decision.Choice = choice.NodeGroup.Id()
+	}
+	return decision
+}
+
+// OptionScores returns scores of the option by the scoring strategies in the chain of the expander,
+// keyed by strategy name. Random choice doesn't score options and the price expander is not supported,
+// so they have no scores.
+func OptionScores(expanderName string, option expander.Option, nodeInfos map[string]*schedulercache.NodeInfo) map[string]float64 {
+	switch expanderName {
+	case expander.MostPodsExpanderName:
+		return map[string]float64{expander.MostPodsExpanderName: float64(mostpods.Score(option))}
+	case expander.LeastWasteExpanderName:
+		if nodeInfo, found := nodeInfos[option.NodeGroup.Id()]; found && nodeInfo.Node() != nil {
+			return map[string]float64{expander.LeastWasteExpanderName: waste.Score(option, nodeInfo)}
+		}
+	}
+	return nil
+}
+
+// DecisionRecorder stores scale-up decisions for offline analysis.
+type DecisionRecorder interface {
+	RecordScaleUpDecision(decision *ScaleUpDecision) error
+}
+
+type directoryDecisionRecorder struct {
+	dir string
+}
+
+// NewDirectoryDecisionRecorder returns a DecisionRecorder that writes each decision as
+// a separate JSON file in the given directory.
+func NewDirectoryDecisionRecorder(dir string) (DecisionRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &directoryDecisionRecorder{dir: dir}, nil
+}
+
+// RecordScaleUpDecision writes the decision to a new file.
+func (r *directoryDecisionRecorder) RecordScaleUpDecision(decision *ScaleUpDecision) error {
+	data, err := json.MarshalIndent(decision, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("scale-up-%d.json", decision.Time.UnixNano())
+	return ioutil.WriteFile(filepath.Join(r.dir, name), data, 0644)
+}
+
+// LoadScaleUpDecision reads a decision written by the directory recorder.
+func LoadScaleUpDecision(path string) (*ScaleUpDecision, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decision := &ScaleUpDecision{}
+	if err := json.Unmarshal(data, decision); err != nil {
+		return nil, fmt.Errorf("failed to parse decision from %s: %v", path, err)
+	}
+	return decision, nil
+}
+
+func podRequests(pod *apiv1.Pod) (milliCPU int64, memory int64) {
+	for _, container := range pod.Spec.Containers {
+		if request, ok := container.Resources.Requests[apiv1.ResourceCPU]; ok {
+			milliCPU += request.MilliValue()
+		}
+		if request, ok := container.Resources.Requests[apiv1.ResourceMemory]; ok {
+			memory += request.Value()
+		}
+	}
+	return milliCPU, memory
+}
+
+func podSpecHash(pod *apiv1.Pod) string {
+	data, err := json.Marshal(pod.Spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func podKey(namespace, name string) string {
+	return namespace + "/" + name
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildDecisionInput() ([]*apiv1.Pod, []expander.Option, map[string]*schedulercache.NodeInfo) {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	provider.AddNodeGroup("ng2", 0, 10, 1)
+	provider.AddNodeGroup("ng3", 0, 10, 1)
+	nodeGroups := make(map[string]cloudprovider.NodeGroup)
+	for _, ng := range provider.NodeGroups() {
+		nodeGroups[ng.Id()] = ng
+	}
+
+	p1 := BuildTestPod("p1", 1000, 1000)
+	p2 := BuildTestPod("p2", 1000, 1000)
+	p3 := BuildTestPod("p3", 1000, 1000)
+	pods := []*apiv1.Pod{p1, p2, p3}
+
+	nodeInfos := make(map[string]*schedulercache.NodeInfo)
+	for id, cpu := range map[string]int64{"ng1": 2000, "ng2": 4000, "ng3": 2000} {
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(BuildTestNode(id+"-template", cpu, 4000))
+		nodeInfos[id] = nodeInfo
+	}
+
+	options := []expander.Option{
+		{NodeGroup: nodeGroups["ng1"], NodeCount: 1, Pods: []*apiv1.Pod{p1, p2}},
+		{NodeGroup: nodeGroups["ng2"], NodeCount: 1, Pods: []*apiv1.Pod{p1, p2, p3}},
+		{NodeGroup: nodeGroups["ng3"], NodeCount: 2, Pods: []*apiv1.Pod{p1, p2, p3}},
+	}
+	return pods, options, nodeInfos
+}
+
+func TestScaleUpDecisionRoundTrip(t *testing.T) {
+	pods, options, nodeInfos := buildDecisionInput()
+	decision := NewScaleUpDecision(time.Unix(1500000000, 0).UTC(), expander.MostPodsExpanderName,
+		pods, options, nodeInfos, &options[1])
+
+	assert.Equal(t, "ng2", decision.Choice)
+	assert.Len(t, decision.PendingPods, 3)
+	assert.Equal(t, int64(1000), decision.PendingPods[0].MilliCPU)
+	assert.NotEmpty(t, decision.PendingPods[0].Hash)
+	assert.Equal(t, []string{"default/p1", "default/p2", "default/p3"}, decision.Options[1].Pods)
+	assert.Equal(t, NodeTemplateRecord{MilliCPU: 4000, Memory: 4000}, decision.NodeTemplates["ng2"])
+	assert.Equal(t, map[string]float64{expander.MostPodsExpanderName: 3}, decision.Options[1].Scores)
+
+	dir, err := ioutil.TempDir("", "decisions")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorder, err := NewDirectoryDecisionRecorder(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.RecordScaleUpDecision(decision))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	loaded, err := LoadScaleUpDecision(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, decision, loaded)
+}
+
+func TestOptionScores(t *testing.T) {
+	_, options, nodeInfos := buildDecisionInput()
+
+	assert.Equal(t, map[string]float64{expander.MostPodsExpanderName: 2},
+		OptionScores(expander.MostPodsExpanderName, options[0], nodeInfos))
+	// 1 of 4 cores and 5000 of 8000 bytes of memory of the two nodes are left unused.
+	assert.Equal(t, map[string]float64{expander.LeastWasteExpanderName: 0.875},
+		OptionScores(expander.LeastWasteExpanderName, options[2], nodeInfos))
+	assert.Nil(t, OptionScores(expander.RandomExpanderName, options[0], nodeInfos))
+}
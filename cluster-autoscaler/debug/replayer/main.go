@@ -0,0 +1,60 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// replayer loads scale-up decisions recorded with --record-decisions-dir and re-runs
+// the expander on them, reporting decisions that can't be reproduced.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s DECISION_FILE...\n", os.Args[0])
+		os.Exit(2)
+	}
+	mismatches := 0
+	for _, path := range flag.Args() {
+		decision, err := debug.LoadScaleUpDecision(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+		result, err := replay(decision)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+		if result.choice != decision.Choice || len(result.scoreMismatches) > 0 {
+			mismatches++
+			fmt.Printf("%s: MISMATCH expander=%s recorded=%q replayed=%q\n", path, decision.Expander, decision.Choice, result.choice)
+			for _, scoreMismatch := range result.scoreMismatches {
+				fmt.Printf("  %s\n", scoreMismatch)
+			}
+		} else {
+			fmt.Printf("%s: OK expander=%s choice=%q\n", path, decision.Expander, decision.Choice)
+		}
+	}
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/mostpods"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/waste"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// recordedChoiceStrategy breaks ties the same way they were broken when the decision was
+// recorded, so that replays don't depend on the random fallback used by expanders.
+type recordedChoiceStrategy struct {
+	choice string
+}
+
+func (r *recordedChoiceStrategy) BestOption(options []expander.Option, nodeInfo map[string]*schedulercache.NodeInfo) *expander.Option {
+	if len(options) == 0 {
+		return nil
+	}
+	best := &options[0]
+	for i := range options {
+		if options[i].NodeGroup.Id() == r.choice {
+			return &options[i]
+		}
+		if options[i].NodeGroup.Id() < best.NodeGroup.Id() {
+			best = &options[i]
+		}
+	}
+	return best
+}
+
+// replayResult is the outcome of a replayed decision.
+type replayResult struct {
+	// choice is the id of the node group picked by the replayed expander.
+	choice string
+	// scoreMismatches describe recorded option scores that differ from the replayed ones.
+	scoreMismatches []string
+}
+
+// replay re-runs the expander on the recorded options, returning the id of the node group it
+// picks and recorded scores it doesn't agree with. Ties between equally good options are resolved
+// in favour of the recorded choice, so a replay picking a different node group means the expander
+// logic disagrees with the recorded decision. The random expander picks any option, so its replay
+// only checks that the recorded choice was one of them.
+func replay(decision *debug.ScaleUpDecision) (*replayResult, error) {
+	recorded := &recordedChoiceStrategy{choice: decision.Choice}
+	var strategy expander.Strategy
+	switch decision.Expander {
+	case expander.RandomExpanderName:
+		strategy = recorded
+	case expander.MostPodsExpanderName:
+		strategy = mostpods.NewStrategyWithFallback(recorded)
+	case expander.LeastWasteExpanderName:
+		strategy = waste.NewStrategyWithFallback(recorded)
+	default:
+		return nil, fmt.Errorf("replay of expander %q is not supported", decision.Expander)
+	}
+
+	pods := make(map[string]*apiv1.Pod)
+	for _, record := range decision.PendingPods {
+		pods[record.Namespace+"/"+record.Name] = buildReplayPod(record)
+	}
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	nodeInfos := make(map[string]*schedulercache.NodeInfo)
+	for id, template := range decision.NodeTemplates {
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(buildReplayNode(id, template))
+		nodeInfos[id] = nodeInfo
+	}
+
+	options := make([]expander.Option, 0, len(decision.Options))
+	for _, record := range decision.Options {
+		provider.AddNodeGroup(record.NodeGroup, 0, record.NodeCount, 0)
+		option := expander.Option{
+			NodeCount: record.NodeCount,
+			Debug:     record.Debug,
+			Pods:      make([]*apiv1.Pod, 0, len(record.Pods)),
+		}
+		for _, key := range record.Pods {
+			pod, found := pods[key]
+			if !found {
+				return nil, fmt.Errorf("option for %s references unknown pod %s", record.NodeGroup, key)
+			}
+			option.Pods = append(option.Pods, pod)
+		}
+		options = append(options, option)
+	}
+	// Node groups are looked up after all of them are added, as the provider
+	// keeps them in a map.
+	nodeGroups := make(map[string]*testprovider.TestNodeGroup)
+	for _, nodeGroup := range provider.NodeGroups() {
+		nodeGroups[nodeGroup.Id()] = nodeGroup.(*testprovider.TestNodeGroup)
+	}
+	result := &replayResult{}
+	for i, record := range decision.Options {
+		options[i].NodeGroup = nodeGroups[record.NodeGroup]
+		result.scoreMismatches = append(result.scoreMismatches,
+			compareScores(record, debug.OptionScores(decision.Expander, options[i], nodeInfos))...)
+	}
+
+	if best := strategy.BestOption(options, nodeInfos); best != nil {
+		result.choice = best.NodeGroup.Id()
+	}
+	return result, nil
+}
+
+// compareScores describes scores of the option that differ between the record and the replay.
+func compareScores(record debug.OptionRecord, replayed map[string]float64) []string {
+	strategies := make([]string, 0, len(replayed))
+	for strategy := range replayed {
+		strategies = append(strategies, strategy)
+	}
+	for strategy := range record.Scores {
+		if _, found := replayed[strategy]; !found {
+			strategies = append(strategies, strategy)
+		}
+	}
+	sort.Strings(strategies)
+
+	result := make([]string, 0)
+	for _, strategy := range strategies {
+		recordedScore, recordedFound := record.Scores[strategy]
+		replayedScore, replayedFound := replayed[strategy]
+		if recordedFound != replayedFound || recordedScore != replayedScore {
+			result = append(result, fmt.Sprintf("%s %s score: recorded=%v replayed=%v",
+				record.NodeGroup, strategy, formatScore(recordedScore, recordedFound), formatScore(replayedScore, replayedFound)))
+		}
+	}
+	return result
+}
+
+func formatScore(score float64, found bool) string {
+	if !found {
+		return "none"
+	}
+	return fmt.Sprintf("%g", score)
+}
+
+func buildReplayPod(record debug.PodRecord) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: record.Namespace,
+			Name:      record.Name,
+		},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{
+					Resources: apiv1.ResourceRequirements{
+						Requests: apiv1.ResourceList{
+							apiv1.ResourceCPU:    *resource.NewMilliQuantity(record.MilliCPU, resource.DecimalSI),
+							apiv1.ResourceMemory: *resource.NewQuantity(record.Memory, resource.DecimalSI),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func buildReplayNode(name string, template debug.NodeTemplateRecord) *apiv1.Node {
+	capacity := apiv1.ResourceList{
+		apiv1.ResourceCPU:    *resource.NewMilliQuantity(template.MilliCPU, resource.DecimalSI),
+		apiv1.ResourceMemory: *resource.NewQuantity(template.Memory, resource.DecimalSI),
+	}
+	return &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Status: apiv1.NodeStatus{
+			Capacity:    capacity,
+			Allocatable: capacity,
+		},
+	}
+}
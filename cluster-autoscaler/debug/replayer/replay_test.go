@@ -0,0 +1,118 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/mostpods"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/random"
+	"k8s.io/autoscaler/cluster-autoscaler/expander/waste"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildReplayInput() ([]*apiv1.Pod, []expander.Option, map[string]*schedulercache.NodeInfo) {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	provider.AddNodeGroup("ng2", 0, 10, 1)
+	provider.AddNodeGroup("ng3", 0, 10, 1)
+	nodeGroups := make(map[string]cloudprovider.NodeGroup)
+	for _, ng := range provider.NodeGroups() {
+		nodeGroups[ng.Id()] = ng
+	}
+
+	p1 := BuildTestPod("p1", 1000, 1000)
+	p2 := BuildTestPod("p2", 1000, 1000)
+	p3 := BuildTestPod("p3", 1000, 1000)
+	pods := []*apiv1.Pod{p1, p2, p3}
+
+	nodeInfos := make(map[string]*schedulercache.NodeInfo)
+	for id, cpu := range map[string]int64{"ng1": 2000, "ng2": 4000, "ng3": 2000} {
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(BuildTestNode(id+"-template", cpu, 4000))
+		nodeInfos[id] = nodeInfo
+	}
+
+	options := []expander.Option{
+		{NodeGroup: nodeGroups["ng1"], NodeCount: 1, Pods: []*apiv1.Pod{p1, p2}},
+		{NodeGroup: nodeGroups["ng2"], NodeCount: 1, Pods: []*apiv1.Pod{p1, p2, p3}},
+		{NodeGroup: nodeGroups["ng3"], NodeCount: 2, Pods: []*apiv1.Pod{p1, p2, p3}},
+	}
+	return pods, options, nodeInfos
+}
+
+func TestReplayMatchesRecordedChoice(t *testing.T) {
+	pods, options, nodeInfos := buildReplayInput()
+
+	testCases := []struct {
+		expanderName string
+		strategy     expander.Strategy
+	}{
+		{expander.RandomExpanderName, random.NewStrategy()},
+		{expander.MostPodsExpanderName, mostpods.NewStrategy()},
+		{expander.LeastWasteExpanderName, waste.NewStrategy()},
+	}
+	for _, tc := range testCases {
+		// Most-pods ties between ng2 and ng3, so repeat to exercise the random fallback.
+		for i := 0; i < 10; i++ {
+			choice := tc.strategy.BestOption(options, nodeInfos)
+			decision := debug.NewScaleUpDecision(time.Now(), tc.expanderName, pods, options, nodeInfos, choice)
+			result, err := replay(decision)
+			assert.NoError(t, err)
+			assert.Equal(t, decision.Choice, result.choice, "expander %s", tc.expanderName)
+			assert.Empty(t, result.scoreMismatches, "expander %s", tc.expanderName)
+		}
+	}
+}
+
+func TestReplayDetectsMismatch(t *testing.T) {
+	pods, options, nodeInfos := buildReplayInput()
+
+	// ng1 helps fewer pods than other options, most-pods would never pick it.
+	decision := debug.NewScaleUpDecision(time.Now(), expander.MostPodsExpanderName, pods, options, nodeInfos, &options[0])
+	result, err := replay(decision)
+	assert.NoError(t, err)
+	assert.NotEqual(t, decision.Choice, result.choice)
+
+	// Scores that don't follow from the recorded options are reported.
+	decision = debug.NewScaleUpDecision(time.Now(), expander.LeastWasteExpanderName, pods, options, nodeInfos, &options[1])
+	decision.Options[0].Scores[expander.LeastWasteExpanderName] = 0
+	result, err = replay(decision)
+	assert.NoError(t, err)
+	assert.Equal(t, "ng2", result.choice)
+	assert.Equal(t, []string{"ng1 least-waste score: recorded=0 replayed=0.5"}, result.scoreMismatches)
+
+	// Random expander can only pick one of the options.
+	decision = debug.NewScaleUpDecision(time.Now(), expander.RandomExpanderName, pods, options, nodeInfos, &options[2])
+	decision.Choice = "ng4"
+	result, err = replay(decision)
+	assert.NoError(t, err)
+	assert.NotEqual(t, decision.Choice, result.choice)
+
+	decision.Expander = expander.PriceBasedExpanderName
+	_, err = replay(decision)
+	assert.Error(t, err)
+}
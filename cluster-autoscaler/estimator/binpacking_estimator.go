@@ -17,11 +17,14 @@ limitations under the License.
 package estimator
 
 import (
+	"reflect"
 	"sort"
 
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	"k8s.io/kubernetes/pkg/api/helper"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 )
 
@@ -33,13 +36,30 @@ type podInfo struct {
 
 type byScoreDesc []*podInfo
 
-func (a byScoreDesc) Len() int           { return len(a) }
-func (a byScoreDesc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byScoreDesc) Less(i, j int) bool { return a[i].score > a[j].score }
+func (a byScoreDesc) Len() int      { return len(a) }
+func (a byScoreDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScoreDesc) Less(i, j int) bool {
+	if a[i].score != a[j].score {
+		return a[i].score > a[j].score
+	}
+	return podKey(a[i].pod) < podKey(a[j].pod)
+}
+
+// PodPlacement describes where a pod was put by the estimator. Nodes are numbered
+// in the order they were considered: coming nodes first, then new nodes.
+type PodPlacement struct {
+	Pod       string `json:"pod"`
+	Node      int    `json:"node"`
+	NewNode   bool   `json:"newNode"`
+	Exclusive bool   `json:"exclusive,omitempty"`
+}
 
 // BinpackingNodeEstimator estimates the number of needed nodes to handle the given amount of pods.
 type BinpackingNodeEstimator struct {
 	predicateChecker *simulator.PredicateChecker
+	scoringStrategy  simulator.ScoringStrategy
+	traceEnabled     bool
+	trace            []PodPlacement
 }
 
 // NewBinpackingNodeEstimator builds a new BinpackingNodeEstimator.
@@ -49,45 +69,193 @@ func NewBinpackingNodeEstimator(predicateChecker *simulator.PredicateChecker) *B
 	}
 }
 
+// SetScoringStrategy makes the estimator put each pod on the node preferred by the strategy
+// among the nodes the pod fits on, instead of the first one.
+func (estimator *BinpackingNodeEstimator) SetScoringStrategy(strategy simulator.ScoringStrategy) {
+	estimator.scoringStrategy = strategy
+}
+
+// EnableTrace makes the estimator record where each pod was placed. The trace of
+// the last estimation is available through Trace().
+func (estimator *BinpackingNodeEstimator) EnableTrace() {
+	estimator.traceEnabled = true
+}
+
+// Trace returns pod placements made by the last call to Estimate, if tracing is enabled.
+func (estimator *BinpackingNodeEstimator) Trace() []PodPlacement {
+	return estimator.trace
+}
+
 // Estimate implements First Fit Decreasing bin-packing approximation algorithm.
 // See https://en.wikipedia.org/wiki/Bin_packing_problem for more details.
 // While it is a multi-dimensional bin packing (cpu, mem, ports) in most cases the main dimension
 // will be cpu thus the estimated overprovisioning of 11/9 * optimal + 6/9 should be
 // still be maintained.
 // It is assumed that all pods from the given list can fit to nodeTemplate.
+// Groups of identical pods that can't share a node are placed one per node without
+// running the full simulation for each of them.
+// The result doesn't depend on the order of pods: pods are processed starting from the
+// biggest ones, with ties broken by namespace and name, and each pod goes to the first
+// node (in the order nodes were added) it fits on. With a scoring strategy set, a pod goes
+// to the node preferred by the strategy instead, as the scheduler would place it.
 // Returns the number of nodes needed to accommodate all pods from the list.
 func (estimator *BinpackingNodeEstimator) Estimate(pods []*apiv1.Pod, nodeTemplate *schedulercache.NodeInfo,
 	comingNodes []*schedulercache.NodeInfo) int {
 
-	podInfos := calculatePodScore(pods, nodeTemplate)
-	sort.Sort(byScoreDesc(podInfos))
+	estimator.trace = nil
+	newNodes := make([]*schedulercache.NodeInfo, 0)
+	newNodes = append(newNodes, comingNodes...)
 
-	// nodeWithPod function returns NodeInfo, which is a copy of nodeInfo argument with an additional pod scheduled on it.
-	nodeWithPod := func(nodeInfo *schedulercache.NodeInfo, pod *apiv1.Pod) *schedulercache.NodeInfo {
-		podsOnNode := nodeInfo.Pods()
-		podsOnNode = append(podsOnNode, pod)
-		newNodeInfo := schedulercache.NewNodeInfo(podsOnNode...)
-		newNodeInfo.SetNode(nodeInfo.Node())
-		return newNodeInfo
+	exclusiveGroups, otherPods := estimator.splitExclusivePods(pods, nodeTemplate)
+	for _, group := range exclusiveGroups {
+		newNodes = estimator.placeExclusivePods(group, nodeTemplate, newNodes, len(comingNodes))
 	}
 
-	newNodes := make([]*schedulercache.NodeInfo, 0)
-	newNodes = append(newNodes, comingNodes...)
+	podInfos := calculatePodScore(otherPods, nodeTemplate)
+	sort.Sort(byScoreDesc(podInfos))
 
 	for _, podInfo := range podInfos {
-		found := false
-		for i, nodeInfo := range newNodes {
-			if err := estimator.predicateChecker.CheckPredicates(podInfo.pod, nil, nodeInfo, simulator.ReturnSimpleError); err == nil {
-				found = true
-				newNodes[i] = nodeWithPod(nodeInfo, podInfo.pod)
+		if i := estimator.findNodeForPod(podInfo.pod, newNodes); i >= 0 {
+			newNodes[i] = nodeWithPod(newNodes[i], podInfo.pod)
+			estimator.recordPlacement(podInfo.pod, i, len(comingNodes), false)
+		} else {
+			estimator.recordPlacement(podInfo.pod, len(newNodes), len(comingNodes), false)
+			newNodes = append(newNodes, nodeWithPod(nodeTemplate, podInfo.pod))
+		}
+	}
+	return len(newNodes) - len(comingNodes)
+}
+
+// findNodeForPod returns the index of the node the pod should be put on, or -1 if it fits on none.
+func (estimator *BinpackingNodeEstimator) findNodeForPod(pod *apiv1.Pod, nodes []*schedulercache.NodeInfo) int {
+	best := -1
+	bestScore := float64(0)
+	for i, nodeInfo := range nodes {
+		if err := estimator.predicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnSimpleError); err != nil {
+			continue
+		}
+		if estimator.scoringStrategy == simulator.FirstFit {
+			return i
+		}
+		score := simulator.AllocationScore(nodeInfo)
+		if best < 0 || estimator.scoringStrategy.Prefers(score, bestScore) {
+			best = i
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// splitExclusivePods finds groups of identical pods owned by the same controller that can't share
+// a node with each other, for example because of a host port or a required anti-affinity to their
+// own labels. Such pods need a node each, so there is no point in binpacking them one by one.
+// A group is only considered exclusive if a single pod fits on an empty template node, otherwise
+// the regular algorithm is used. Pods that don't belong to any exclusive group are returned separately.
+func (estimator *BinpackingNodeEstimator) splitExclusivePods(pods []*apiv1.Pod, nodeTemplate *schedulercache.NodeInfo) ([][]*apiv1.Pod, []*apiv1.Pod) {
+	type podGroup struct {
+		pods []*apiv1.Pod
+	}
+	groupsByController := make(map[string][]*podGroup)
+	groups := make([]*podGroup, 0)
+	otherPods := make([]*apiv1.Pod, 0, len(pods))
+
+	for _, pod := range pods {
+		ref := drain.ControllerRef(pod)
+		if ref == nil {
+			otherPods = append(otherPods, pod)
+			continue
+		}
+		uid := string(ref.UID)
+		var matching *podGroup
+		for _, group := range groupsByController[uid] {
+			if samePodTemplate(group.pods[0], pod) {
+				matching = group
 				break
 			}
 		}
-		if !found {
-			newNodes = append(newNodes, nodeWithPod(nodeTemplate, podInfo.pod))
+		if matching == nil {
+			matching = &podGroup{}
+			groupsByController[uid] = append(groupsByController[uid], matching)
+			groups = append(groups, matching)
 		}
+		matching.pods = append(matching.pods, pod)
 	}
-	return len(newNodes) - len(comingNodes)
+
+	for _, group := range groups {
+		sort.Slice(group.pods, func(i, j int) bool { return podKey(group.pods[i]) < podKey(group.pods[j]) })
+	}
+	sort.Slice(groups, func(i, j int) bool { return podKey(groups[i].pods[0]) < podKey(groups[j].pods[0]) })
+
+	exclusiveGroups := make([][]*apiv1.Pod, 0)
+	for _, group := range groups {
+		if len(group.pods) > 1 && estimator.isExclusive(group.pods[0], nodeTemplate) {
+			exclusiveGroups = append(exclusiveGroups, group.pods)
+		} else {
+			otherPods = append(otherPods, group.pods...)
+		}
+	}
+	return exclusiveGroups, otherPods
+}
+
+// isExclusive returns true if the pod fits on the template node, but two copies of it don't.
+func (estimator *BinpackingNodeEstimator) isExclusive(pod *apiv1.Pod, nodeTemplate *schedulercache.NodeInfo) bool {
+	if err := estimator.predicateChecker.CheckPredicates(pod, nil, nodeTemplate, simulator.ReturnSimpleError); err != nil {
+		return false
+	}
+	err := estimator.predicateChecker.CheckPredicates(pod, nil, nodeWithPod(nodeTemplate, pod), simulator.ReturnSimpleError)
+	return err != nil
+}
+
+// placeExclusivePods puts pods from an exclusive group on the given nodes, at most one pod per node,
+// and adds a new node for each pod that didn't fit anywhere. As the pods are identical, each node
+// needs to be checked only once.
+func (estimator *BinpackingNodeEstimator) placeExclusivePods(pods []*apiv1.Pod, nodeTemplate *schedulercache.NodeInfo,
+	nodes []*schedulercache.NodeInfo, comingNodesCount int) []*schedulercache.NodeInfo {
+	placed := 0
+	for i, nodeInfo := range nodes {
+		if placed == len(pods) {
+			break
+		}
+		if err := estimator.predicateChecker.CheckPredicates(pods[placed], nil, nodeInfo, simulator.ReturnSimpleError); err == nil {
+			nodes[i] = nodeWithPod(nodeInfo, pods[placed])
+			estimator.recordPlacement(pods[placed], i, comingNodesCount, true)
+			placed++
+		}
+	}
+	for _, pod := range pods[placed:] {
+		estimator.recordPlacement(pod, len(nodes), comingNodesCount, true)
+		nodes = append(nodes, nodeWithPod(nodeTemplate, pod))
+	}
+	return nodes
+}
+
+func (estimator *BinpackingNodeEstimator) recordPlacement(pod *apiv1.Pod, node int, comingNodesCount int, exclusive bool) {
+	if !estimator.traceEnabled {
+		return
+	}
+	estimator.trace = append(estimator.trace, PodPlacement{
+		Pod:       podKey(pod),
+		Node:      node,
+		NewNode:   node >= comingNodesCount,
+		Exclusive: exclusive,
+	})
+}
+
+func podKey(pod *apiv1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func samePodTemplate(a, b *apiv1.Pod) bool {
+	return reflect.DeepEqual(a.Labels, b.Labels) && helper.Semantic.DeepEqual(a.Spec, b.Spec)
+}
+
+// nodeWithPod returns NodeInfo, which is a copy of nodeInfo argument with an additional pod scheduled on it.
+func nodeWithPod(nodeInfo *schedulercache.NodeInfo, pod *apiv1.Pod) *schedulercache.NodeInfo {
+	podsOnNode := nodeInfo.Pods()
+	podsOnNode = append(podsOnNode, pod)
+	newNodeInfo := schedulercache.NewNodeInfo(podsOnNode...)
+	newNodeInfo.SetNode(nodeInfo.Node())
+	return newNodeInfo
 }
 
 // Calculates score for all pods and returns podInfo structure.
@@ -17,6 +17,8 @@ limitations under the License.
 package estimator
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -120,3 +122,179 @@ func TestBinpackingEstimateWithPorts(t *testing.T) {
 	estimate := estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{})
 	assert.Equal(t, 8, estimate)
 }
+
+func makeHostPortPods(count int, cpuPerPod, memoryPerPod int64, replicated bool) []*apiv1.Pod {
+	pods := make([]*apiv1.Pod, 0, count)
+	for i := 0; i < count; i++ {
+		pod := makePod(cpuPerPod, memoryPerPod)
+		pod.Spec.Containers[0].Ports = []apiv1.ContainerPort{
+			{
+				HostPort: 5555,
+			},
+		}
+		if replicated {
+			pod.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "rs-uid")
+		}
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func makeHostPortTestNodeInfo(cpu, memory int64) *schedulercache.NodeInfo {
+	node := &apiv1.Node{
+		Status: apiv1.NodeStatus{
+			Capacity: apiv1.ResourceList{
+				apiv1.ResourceCPU:    *resource.NewMilliQuantity(cpu, resource.DecimalSI),
+				apiv1.ResourceMemory: *resource.NewQuantity(memory, resource.DecimalSI),
+				apiv1.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	node.Status.Allocatable = node.Status.Capacity
+	SetNodeReadyState(node, true, time.Time{})
+
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	return nodeInfo
+}
+
+func TestBinpackingEstimateExclusivePods(t *testing.T) {
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+
+	cpuPerPod := int64(200)
+	memoryPerPod := int64(1000 * 1024 * 1024)
+	nodeInfo := makeHostPortTestNodeInfo(5*cpuPerPod, 5*memoryPerPod)
+	pods := makeHostPortPods(8, cpuPerPod, memoryPerPod, true)
+
+	exclusiveGroups, otherPods := estimator.splitExclusivePods(pods, nodeInfo)
+	assert.Equal(t, 1, len(exclusiveGroups))
+	assert.Equal(t, 8, len(exclusiveGroups[0]))
+	assert.Equal(t, 0, len(otherPods))
+
+	assert.Equal(t, 8, estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{}))
+	// Coming nodes are used, but only one pod fits on each of them.
+	assert.Equal(t, 6, estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{nodeInfo, nodeInfo}))
+
+	// Regular pods are still packed together with the exclusive ones.
+	regular := makePod(cpuPerPod, memoryPerPod)
+	regular.OwnerReferences = GenerateOwnerReferences("rs2", "ReplicaSet", "extensions/v1beta1", "rs2-uid")
+	for i := 0; i < 16; i++ {
+		pods = append(pods, regular)
+	}
+	assert.Equal(t, 8, estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{}))
+	for i := 0; i < 20; i++ {
+		pods = append(pods, regular)
+	}
+	assert.Equal(t, 9, estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{}))
+}
+
+func TestBinpackingEstimateNotExclusivePods(t *testing.T) {
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+
+	cpuPerPod := int64(350)
+	memoryPerPod := int64(1000 * 1024 * 1024)
+	nodeInfo := makeHostPortTestNodeInfo(cpuPerPod*3-50, 2*memoryPerPod)
+
+	pod := makePod(cpuPerPod, memoryPerPod)
+	pod.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "rs-uid")
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 10; i++ {
+		pods = append(pods, pod)
+	}
+
+	exclusiveGroups, otherPods := estimator.splitExclusivePods(pods, nodeInfo)
+	assert.Equal(t, 0, len(exclusiveGroups))
+	assert.Equal(t, 10, len(otherPods))
+	assert.Equal(t, 5, estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{}))
+
+	// A pod that doesn't fit on the template isn't treated as exclusive.
+	tooBig := makeHostPortPods(3, 10*cpuPerPod, memoryPerPod, true)
+	exclusiveGroups, otherPods = estimator.splitExclusivePods(tooBig, nodeInfo)
+	assert.Equal(t, 0, len(exclusiveGroups))
+	assert.Equal(t, 3, len(otherPods))
+}
+
+func BenchmarkBinpackingEstimateHostPortPods(b *testing.B) {
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+	cpuPerPod := int64(200)
+	memoryPerPod := int64(1000 * 1024 * 1024)
+	nodeInfo := makeHostPortTestNodeInfo(5*cpuPerPod, 5*memoryPerPod)
+
+	for _, replicated := range []bool{false, true} {
+		pods := makeHostPortPods(500, cpuPerPod, memoryPerPod, replicated)
+		b.Run(fmt.Sprintf("replicated-%v", replicated), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{})
+			}
+		})
+	}
+}
+
+func TestBinpackingEstimateDeterministic(t *testing.T) {
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+	estimator.EnableTrace()
+
+	memoryPerPod := int64(1000 * 1024 * 1024)
+	nodeInfo := makeHostPortTestNodeInfo(1000, 5*memoryPerPod)
+
+	pods := makeHostPortPods(5, 200, memoryPerPod, true)
+	for i, cpu := range []int64{300, 300, 300, 150, 150, 450, 450, 100, 300, 150} {
+		pod := makePod(cpu, memoryPerPod/2)
+		if i%2 == 0 {
+			pod.Spec.Containers[0].Ports = []apiv1.ContainerPort{{HostPort: 6666}}
+		}
+		pods = append(pods, pod)
+	}
+	for i, pod := range pods {
+		pod.Name = fmt.Sprintf("p%d", i)
+	}
+
+	expectedCount := estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{nodeInfo})
+	expectedTrace := estimator.Trace()
+	assert.Equal(t, len(pods), len(expectedTrace))
+
+	shuffler := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		shuffled := make([]*apiv1.Pod, len(pods))
+		for j, k := range shuffler.Perm(len(pods)) {
+			shuffled[j] = pods[k]
+		}
+		assert.Equal(t, expectedCount, estimator.Estimate(shuffled, nodeInfo, []*schedulercache.NodeInfo{nodeInfo}))
+		assert.Equal(t, expectedTrace, estimator.Trace())
+	}
+}
+
+func TestBinpackingEstimateScoringStrategy(t *testing.T) {
+	// Pod sizes in tenths of a node. Packing puts 4 next to 6 and 3 and 2 next to 5, while spreading
+	// puts 4 next to 5 and 3 next to 6, leaving no room for 2 on either node.
+	pods := make([]*apiv1.Pod, 0)
+	for i, size := range []int64{6, 5, 4, 3, 2} {
+		pod := makePod(size*100, size*100*1024*1024)
+		pod.Name = fmt.Sprintf("p%d", i)
+		pods = append(pods, pod)
+	}
+	node := &apiv1.Node{
+		Status: apiv1.NodeStatus{
+			Capacity: apiv1.ResourceList{
+				apiv1.ResourceCPU:    *resource.NewMilliQuantity(1000, resource.DecimalSI),
+				apiv1.ResourceMemory: *resource.NewQuantity(1000*1024*1024, resource.DecimalSI),
+				apiv1.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	node.Status.Allocatable = node.Status.Capacity
+	SetNodeReadyState(node, true, time.Time{})
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+
+	expected := map[simulator.ScoringStrategy]int{
+		simulator.FirstFit:       2,
+		simulator.MostAllocated:  2,
+		simulator.LeastAllocated: 3,
+	}
+	for strategy, expectedCount := range expected {
+		estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+		estimator.SetScoringStrategy(strategy)
+		assert.Equal(t, expectedCount, estimator.Estimate(pods, nodeInfo, []*schedulercache.NodeInfo{}), "strategy %q", strategy)
+	}
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/config"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// WithCapacityMargin returns a copy of the node info with the margin subtracted from allocatable
+// cpu and memory of its node. Pods of the node info are kept. It's meant for template nodes used
+// in estimation only, real nodes should never be modified this way.
+func WithCapacityMargin(nodeInfo *schedulercache.NodeInfo, margin *config.CapacityMargin) *schedulercache.NodeInfo {
+	if nodeInfo.Node() == nil {
+		return nodeInfo
+	}
+	node := nodeInfo.Node().DeepCopy()
+	if node.Status.Allocatable == nil {
+		node.Status.Allocatable = node.Status.Capacity
+	}
+	if node.Status.Allocatable == nil {
+		return nodeInfo
+	}
+	cpu := node.Status.Allocatable[apiv1.ResourceCPU]
+	memory := node.Status.Allocatable[apiv1.ResourceMemory]
+	milliCPU, memoryBytes := margin.Apply(cpu.MilliValue(), memory.Value())
+	node.Status.Allocatable[apiv1.ResourceCPU] = *resource.NewMilliQuantity(milliCPU, resource.DecimalSI)
+	node.Status.Allocatable[apiv1.ResourceMemory] = *resource.NewQuantity(memoryBytes, resource.BinarySI)
+
+	result := schedulercache.NewNodeInfo(nodeInfo.Pods()...)
+	result.SetNode(node)
+	return result
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// ZoneTopologyKeys are node labels holding the zone of the node.
+var ZoneTopologyKeys = []string{kubeletapis.LabelZoneFailureDomain, "topology.kubernetes.io/zone"}
+
+// ZonalOption is a node group, located in a single zone, that can be expanded to help pending pods.
+type ZonalOption struct {
+	// Id of the node group.
+	Id string
+	// Zone of the nodes in the node group.
+	Zone string
+	// Pods are pending pods that fit on a node from the node group.
+	Pods []*apiv1.Pod
+}
+
+// FindZoneAntiAffinityGroups returns groups of pending pods that have a required anti-affinity on zone
+// to each other, so every pod of a group needs a different zone. The predicate checker doesn't see pods
+// placed on new nodes during estimation, so without it all such pods would be packed into a single zone.
+// Only groups of at least two pods are returned.
+func FindZoneAntiAffinityGroups(pods []*apiv1.Pod) [][]*apiv1.Pod {
+	sorted := make([]*apiv1.Pod, len(pods))
+	copy(sorted, pods)
+	sort.Slice(sorted, func(i, j int) bool { return podKey(sorted[i]) < podKey(sorted[j]) })
+
+	// Only pods with zone anti-affinity terms and pods selected by them can conflict.
+	owners := make([]*apiv1.Pod, 0)
+	for _, pod := range sorted {
+		if hasZoneAntiAffinity(pod) {
+			owners = append(owners, pod)
+		}
+	}
+	if len(owners) == 0 {
+		return [][]*apiv1.Pod{}
+	}
+	candidates := make([]*apiv1.Pod, 0)
+	for _, pod := range sorted {
+		for _, owner := range owners {
+			if pod == owner || termsMatchPod(zoneAntiAffinityTerms(owner), owner, pod) {
+				candidates = append(candidates, pod)
+				break
+			}
+		}
+	}
+
+	groups := make([][]*apiv1.Pod, 0)
+	for _, pod := range candidates {
+		added := false
+		for i, group := range groups {
+			conflictsWithAll := true
+			for _, other := range group {
+				if !zoneAntiAffine(pod, other) {
+					conflictsWithAll = false
+					break
+				}
+			}
+			if conflictsWithAll {
+				groups[i] = append(group, pod)
+				added = true
+				break
+			}
+		}
+		if !added {
+			groups = append(groups, []*apiv1.Pod{pod})
+		}
+	}
+
+	result := make([][]*apiv1.Pod, 0, len(groups))
+	for _, group := range groups {
+		if len(group) > 1 {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// LimitZoneAntiAffinePods keeps at most one pod from every zone anti-affinity group in the list,
+// as nodes from a single zonal node group can only help one of them. Returns the kept and removed pods.
+func LimitZoneAntiAffinePods(pods []*apiv1.Pod, groups [][]*apiv1.Pod) ([]*apiv1.Pod, []*apiv1.Pod) {
+	groupOfPod := make(map[*apiv1.Pod]int)
+	for i, group := range groups {
+		for _, pod := range group {
+			groupOfPod[pod] = i
+		}
+	}
+	groupUsed := make(map[int]bool)
+	kept := make([]*apiv1.Pod, 0, len(pods))
+	removed := make([]*apiv1.Pod, 0)
+	for _, pod := range pods {
+		group, found := groupOfPod[pod]
+		if !found {
+			kept = append(kept, pod)
+			continue
+		}
+		if groupUsed[group] {
+			removed = append(removed, pod)
+			continue
+		}
+		groupUsed[group] = true
+		kept = append(kept, pod)
+	}
+	return kept, removed
+}
+
+// SpreadAcrossZones assigns pods of a zone anti-affinity group to options, using each zone at most once.
+// Zones in usedZones are already taken by other pods of the group. Options are considered in the order
+// they are given. Returns pods assigned to each option id and pods for which no zone was left.
+func SpreadAcrossZones(pods []*apiv1.Pod, options []ZonalOption, usedZones map[string]bool) (map[string][]*apiv1.Pod, []*apiv1.Pod) {
+	taken := make(map[string]bool, len(usedZones))
+	for zone, used := range usedZones {
+		taken[zone] = used
+	}
+	plan := make(map[string][]*apiv1.Pod)
+	unplaced := make([]*apiv1.Pod, 0)
+	for _, pod := range pods {
+		placed := false
+		for _, option := range options {
+			if option.Zone == "" || taken[option.Zone] || !containsPod(option.Pods, pod) {
+				continue
+			}
+			plan[option.Id] = append(plan[option.Id], pod)
+			taken[option.Zone] = true
+			placed = true
+			break
+		}
+		if !placed {
+			unplaced = append(unplaced, pod)
+		}
+	}
+	return plan, unplaced
+}
+
+// NodeZone returns the zone of the node template, or an empty string if it's unknown.
+func NodeZone(nodeInfo *schedulercache.NodeInfo) string {
+	if nodeInfo == nil || nodeInfo.Node() == nil {
+		return ""
+	}
+	for _, key := range ZoneTopologyKeys {
+		if zone, found := nodeInfo.Node().Labels[key]; found {
+			return zone
+		}
+	}
+	return ""
+}
+
+func containsPod(pods []*apiv1.Pod, pod *apiv1.Pod) bool {
+	for _, p := range pods {
+		if p == pod {
+			return true
+		}
+	}
+	return false
+}
+
+func isZoneTopologyKey(key string) bool {
+	for _, zoneKey := range ZoneTopologyKeys {
+		if key == zoneKey {
+			return true
+		}
+	}
+	return false
+}
+
+func zoneAntiAffinityTerms(pod *apiv1.Pod) []apiv1.PodAffinityTerm {
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		return nil
+	}
+	terms := make([]apiv1.PodAffinityTerm, 0)
+	for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if isZoneTopologyKey(term.TopologyKey) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func hasZoneAntiAffinity(pod *apiv1.Pod) bool {
+	return len(zoneAntiAffinityTerms(pod)) > 0
+}
+
+// zoneAntiAffine returns true if any of the two pods has a required anti-affinity on zone to the other one.
+func zoneAntiAffine(a, b *apiv1.Pod) bool {
+	return termsMatchPod(zoneAntiAffinityTerms(a), a, b) || termsMatchPod(zoneAntiAffinityTerms(b), b, a)
+}
+
+// termsMatchPod returns true if any of the terms of the owner pod selects the target pod.
+func termsMatchPod(terms []apiv1.PodAffinityTerm, owner, target *apiv1.Pod) bool {
+	for _, term := range terms {
+		namespaces := term.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{owner.Namespace}
+		}
+		namespaceMatches := false
+		for _, namespace := range namespaces {
+			if namespace == target.Namespace {
+				namespaceMatches = true
+				break
+			}
+		}
+		if !namespaceMatches {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(target.Labels)) {
+			return true
+		}
+	}
+	return false
+}
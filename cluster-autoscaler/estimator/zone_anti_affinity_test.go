@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildZoneAntiAffinePod(name, app, topologyKey string) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 0)
+	pod.Labels = map[string]string{"app": app}
+	pod.Spec.Affinity = &apiv1.Affinity{
+		PodAntiAffinity: &apiv1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []apiv1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+					TopologyKey:   topologyKey,
+				},
+			},
+		},
+	}
+	return pod
+}
+
+func TestFindZoneAntiAffinityGroups(t *testing.T) {
+	p1 := buildZoneAntiAffinePod("p1", "db", kubeletapis.LabelZoneFailureDomain)
+	p2 := buildZoneAntiAffinePod("p2", "db", "topology.kubernetes.io/zone")
+	p3 := buildZoneAntiAffinePod("p3", "db", kubeletapis.LabelZoneFailureDomain)
+	// Anti-affinity on hostname doesn't need separate zones.
+	p4 := buildZoneAntiAffinePod("p4", "web", kubeletapis.LabelHostname)
+	p5 := buildZoneAntiAffinePod("p5", "web", kubeletapis.LabelHostname)
+	// Selected by p1, p2 and p3.
+	p6 := BuildTestPod("p6", 100, 0)
+	p6.Labels = map[string]string{"app": "db"}
+	// Other namespace.
+	p7 := buildZoneAntiAffinePod("p7", "db", kubeletapis.LabelZoneFailureDomain)
+	p7.Namespace = "other"
+	p8 := BuildTestPod("p8", 100, 0)
+
+	groups := FindZoneAntiAffinityGroups([]*apiv1.Pod{p8, p7, p6, p5, p4, p3, p2, p1})
+	assert.Equal(t, [][]*apiv1.Pod{{p1, p2, p3, p6}}, groups)
+
+	assert.Empty(t, FindZoneAntiAffinityGroups([]*apiv1.Pod{p1, p4, p5, p7}))
+}
+
+func TestLimitZoneAntiAffinePods(t *testing.T) {
+	p1 := buildZoneAntiAffinePod("p1", "db", kubeletapis.LabelZoneFailureDomain)
+	p2 := buildZoneAntiAffinePod("p2", "db", kubeletapis.LabelZoneFailureDomain)
+	p3 := buildZoneAntiAffinePod("p3", "db", kubeletapis.LabelZoneFailureDomain)
+	p4 := BuildTestPod("p4", 100, 0)
+	groups := FindZoneAntiAffinityGroups([]*apiv1.Pod{p1, p2, p3, p4})
+
+	kept, removed := LimitZoneAntiAffinePods([]*apiv1.Pod{p4, p2, p1, p3}, groups)
+	assert.Equal(t, []*apiv1.Pod{p4, p2}, kept)
+	assert.Equal(t, []*apiv1.Pod{p1, p3}, removed)
+
+	kept, removed = LimitZoneAntiAffinePods([]*apiv1.Pod{p4, p2, p1, p3}, nil)
+	assert.Equal(t, []*apiv1.Pod{p4, p2, p1, p3}, kept)
+	assert.Empty(t, removed)
+}
+
+func TestSpreadAcrossZonesNotEnoughZones(t *testing.T) {
+	p1 := buildZoneAntiAffinePod("p1", "db", kubeletapis.LabelZoneFailureDomain)
+	p2 := buildZoneAntiAffinePod("p2", "db", kubeletapis.LabelZoneFailureDomain)
+	p3 := buildZoneAntiAffinePod("p3", "db", kubeletapis.LabelZoneFailureDomain)
+	pods := []*apiv1.Pod{p1, p2, p3}
+	options := []ZonalOption{
+		{Id: "ng-a", Zone: "zone-a", Pods: pods},
+		{Id: "ng-a2", Zone: "zone-a", Pods: pods},
+		{Id: "ng-b", Zone: "zone-b", Pods: pods},
+		{Id: "ng-unknown", Zone: "", Pods: pods},
+	}
+
+	plan, unplaced := SpreadAcrossZones(pods, options, nil)
+	assert.Equal(t, map[string][]*apiv1.Pod{"ng-a": {p1}, "ng-b": {p2}}, plan)
+	assert.Equal(t, []*apiv1.Pod{p3}, unplaced)
+
+	// p1 already has a node in zone-a.
+	plan, unplaced = SpreadAcrossZones([]*apiv1.Pod{p2, p3}, options, map[string]bool{"zone-a": true})
+	assert.Equal(t, map[string][]*apiv1.Pod{"ng-b": {p2}}, plan)
+	assert.Equal(t, []*apiv1.Pod{p3}, unplaced)
+}
+
+func TestSpreadAcrossZonesPodsNotFitting(t *testing.T) {
+	p1 := buildZoneAntiAffinePod("p1", "db", kubeletapis.LabelZoneFailureDomain)
+	p2 := buildZoneAntiAffinePod("p2", "db", kubeletapis.LabelZoneFailureDomain)
+	options := []ZonalOption{
+		{Id: "ng-a", Zone: "zone-a", Pods: []*apiv1.Pod{p2}},
+		{Id: "ng-b", Zone: "zone-b", Pods: []*apiv1.Pod{p1, p2}},
+	}
+	plan, unplaced := SpreadAcrossZones([]*apiv1.Pod{p1, p2}, options, nil)
+	assert.Equal(t, map[string][]*apiv1.Pod{"ng-b": {p1}, "ng-a": {p2}}, plan)
+	assert.Empty(t, unplaced)
+}
+
+func TestNodeZone(t *testing.T) {
+	node := BuildTestNode("n1", 1000, 1000)
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	assert.Equal(t, "", NodeZone(nodeInfo))
+
+	node.Labels["topology.kubernetes.io/zone"] = "zone-b"
+	assert.Equal(t, "zone-b", NodeZone(nodeInfo))
+	node.Labels[kubeletapis.LabelZoneFailureDomain] = "zone-a"
+	assert.Equal(t, "zone-a", NodeZone(nodeInfo))
+
+	assert.Equal(t, "", NodeZone(nil))
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package factory
 
 import (
+	"time"
+
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
 	"k8s.io/autoscaler/cluster-autoscaler/expander/mostpods"
@@ -28,9 +30,13 @@ import (
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
 )
 
-// ExpanderStrategyFromString creates an expander.Strategy according to its name
+// ExpanderStrategyFromString creates an expander.Strategy according to its name. The price expander
+// keeps its previous choice for the same pods for priceStabilityDuration unless another option is
+// cheaper by more than priceStabilityMargin; 0 margin disables it. Options with fit efficiency below
+// priceMinFitEfficiency are not considered by the price expander if any other option reaches it.
 func ExpanderStrategyFromString(expanderFlag string, cloudProvider cloudprovider.CloudProvider,
-	nodeLister kube_util.NodeLister) (expander.Strategy, errors.AutoscalerError) {
+	nodeLister kube_util.NodeLister, priceStabilityMargin float64, priceStabilityDuration time.Duration,
+	priceMinFitEfficiency float64) (expander.Strategy, errors.AutoscalerError) {
 	switch expanderFlag {
 	case expander.RandomExpanderName:
 		return random.NewStrategy(), nil
@@ -43,9 +49,20 @@ func ExpanderStrategyFromString(expanderFlag string, cloudProvider cloudprovider
 		if err != nil {
 			return nil, err
 		}
-		return price.NewStrategy(pricing,
+		strategy := price.NewStrategy(pricing,
 			price.NewSimplePreferredNodeProvider(nodeLister),
-			price.SimpleNodeUnfitness), nil
+			price.SimpleNodeUnfitness)
+		if priceStabilityMargin > 0 {
+			strategy = price.NewStableStrategy(pricing,
+				price.NewSimplePreferredNodeProvider(nodeLister),
+				price.SimpleNodeUnfitness,
+				priceStabilityMargin,
+				priceStabilityDuration)
+		}
+		if priceMinFitEfficiency > 0 {
+			strategy = price.NewEfficiencyFilter(priceMinFitEfficiency, strategy)
+		}
+		return strategy, nil
 	}
 	return nil, errors.NewAutoscalerError(errors.InternalError, "Expander %s not supported", expanderFlag)
 }
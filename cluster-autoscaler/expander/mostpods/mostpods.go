@@ -28,7 +28,12 @@ type mostpods struct {
 
 // NewStrategy returns a scale up strategy (expander) that picks the node group that can schedule the most pods
 func NewStrategy() expander.Strategy {
-	return &mostpods{random.NewStrategy()}
+	return NewStrategyWithFallback(random.NewStrategy())
+}
+
+// NewStrategyWithFallback returns a most-pods strategy that breaks ties with the given fallback strategy.
+func NewStrategyWithFallback(fallbackStrategy expander.Strategy) expander.Strategy {
+	return &mostpods{fallbackStrategy}
 }
 
 // BestOption Selects the expansion option that schedules the most pods
@@ -37,12 +42,12 @@ func (m *mostpods) BestOption(expansionOptions []expander.Option, nodeInfo map[s
 	var maxOptions []expander.Option
 
 	for _, option := range expansionOptions {
-		if len(option.Pods) == maxPods {
+		if Score(option) == maxPods {
 			maxOptions = append(maxOptions, option)
 		}
 
-		if len(option.Pods) > maxPods {
-			maxPods = len(option.Pods)
+		if Score(option) > maxPods {
+			maxPods = Score(option)
 			maxOptions = []expander.Option{option}
 		}
 	}
@@ -53,3 +58,8 @@ func (m *mostpods) BestOption(expansionOptions []expander.Option, nodeInfo map[s
 
 	return m.fallbackStrategy.BestOption(maxOptions, nodeInfo)
 }
+
+// Score returns the number of pods scheduled by the option. Higher is better.
+func Score(option expander.Option) int {
+	return len(option.Pods)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package price
+
+import (
+	"math"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+type efficiencyFilter struct {
+	minFitEfficiency float64
+	strategy         expander.Strategy
+}
+
+// NewEfficiencyFilter returns a strategy that discards options whose fit efficiency is below
+// minFitEfficiency and picks the best of the remaining ones with strategy. Fit efficiency of an
+// option is the lowest, over cpu and memory, fraction of the added nodes' capacity requested by
+// the pods, so a memory-heavy group scaled up for cpu-bound pods has a low efficiency. If no
+// option reaches minFitEfficiency, strategy chooses among all options.
+func NewEfficiencyFilter(minFitEfficiency float64, strategy expander.Strategy) expander.Strategy {
+	return &efficiencyFilter{
+		minFitEfficiency: minFitEfficiency,
+		strategy:         strategy,
+	}
+}
+
+// BestOption selects the best of the options with sufficient fit efficiency.
+func (e *efficiencyFilter) BestOption(expansionOptions []expander.Option, nodeInfos map[string]*schedulercache.NodeInfo) *expander.Option {
+	efficient := make([]expander.Option, 0, len(expansionOptions))
+	for _, option := range expansionOptions {
+		nodeInfo, found := nodeInfos[option.NodeGroup.Id()]
+		if !found {
+			continue
+		}
+		efficiency := fitEfficiency(option, nodeInfo.Node())
+		if efficiency < e.minFitEfficiency {
+			glog.V(4).Infof("Price expander skips %s, fit efficiency %f is below %f", option.NodeGroup.Id(), efficiency, e.minFitEfficiency)
+			continue
+		}
+		efficient = append(efficient, option)
+	}
+	if len(efficient) == 0 {
+		glog.V(4).Infof("No option reaches fit efficiency %f, considering all options", e.minFitEfficiency)
+		return e.strategy.BestOption(expansionOptions, nodeInfos)
+	}
+	return e.strategy.BestOption(efficient, nodeInfos)
+}
+
+// fitEfficiency returns the lowest fraction of cpu or memory capacity of the option's nodes
+// requested by its pods. Resources the node doesn't have are ignored.
+func fitEfficiency(option expander.Option, node *apiv1.Node) float64 {
+	efficiency := math.Inf(1)
+	for _, resourceName := range []apiv1.ResourceName{apiv1.ResourceCPU, apiv1.ResourceMemory} {
+		capacity := node.Status.Capacity[resourceName]
+		available := float64(capacity.MilliValue()) * float64(option.NodeCount)
+		if available <= 0 {
+			continue
+		}
+		requested := 0.0
+		for _, pod := range option.Pods {
+			for _, container := range pod.Spec.Containers {
+				if request, found := container.Resources.Requests[resourceName]; found {
+					requested += float64(request.MilliValue())
+				}
+			}
+		}
+		efficiency = math.Min(efficiency, requested/available)
+	}
+	if math.IsInf(efficiency, 1) {
+		return 0
+	}
+	return efficiency
+}
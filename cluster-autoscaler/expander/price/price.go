@@ -19,6 +19,8 @@ package price
 import (
 	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -26,6 +28,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
@@ -41,6 +44,22 @@ type priceBased struct {
 	pricingModel          cloudprovider.PricingModel
 	preferredNodeProvider PreferredNodeProvider
 	nodeUnfitness         NodeUnfitness
+
+	// stabilityMargin is the relative price advantage another option needs to replace
+	// the group remembered for the same pods. Stability is disabled if it is 0.
+	stabilityMargin float64
+	// stabilityDuration is how long a choice is remembered after it was last made.
+	stabilityDuration time.Duration
+	// choices holds the remembered choices, keyed by pods signature.
+	choices map[string]stableChoice
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+}
+
+// stableChoice is the node group chosen for a set of pods.
+type stableChoice struct {
+	nodeGroupId string
+	chosenAt    time.Time
 }
 
 var (
@@ -67,6 +86,28 @@ func NewStrategy(pricingModel cloudprovider.PricingModel,
 		pricingModel:          pricingModel,
 		preferredNodeProvider: preferredNodeProvider,
 		nodeUnfitness:         nodeUnfitness,
+		now:                   time.Now,
+	}
+}
+
+// NewStableStrategy returns a price based expansion strategy that keeps choosing the node group
+// it chose for the same pods within stabilityDuration, unless another option is cheaper by more
+// than stabilityMargin (e.g. 0.05 for 5%). This prevents alternating between groups with
+// near-equal prices. The choices are remembered in memory only.
+func NewStableStrategy(pricingModel cloudprovider.PricingModel,
+	preferredNodeProvider PreferredNodeProvider,
+	nodeUnfitness NodeUnfitness,
+	stabilityMargin float64,
+	stabilityDuration time.Duration,
+) expander.Strategy {
+	return &priceBased{
+		pricingModel:          pricingModel,
+		preferredNodeProvider: preferredNodeProvider,
+		nodeUnfitness:         nodeUnfitness,
+		stabilityMargin:       stabilityMargin,
+		stabilityDuration:     stabilityDuration,
+		choices:               make(map[string]stableChoice),
+		now:                   time.Now,
 	}
 }
 
@@ -74,7 +115,7 @@ func NewStrategy(pricingModel cloudprovider.PricingModel,
 func (p *priceBased) BestOption(expansionOptions []expander.Option, nodeInfos map[string]*schedulercache.NodeInfo) *expander.Option {
 	var bestOption *expander.Option
 	bestOptionScore := 0.0
-	now := time.Now()
+	now := p.now()
 	then := now.Add(time.Hour)
 
 	preferredNode, err := p.preferredNodeProvider.Node()
@@ -82,6 +123,7 @@ func (p *priceBased) BestOption(expansionOptions []expander.Option, nodeInfos ma
 		glog.Errorf("Failed to get preferred node, switching to default: %v", err)
 		preferredNode = defaultPreferredNode
 	}
+	scoredOptions := make(map[string]scoredOption)
 	stabilizationPrice, err := p.pricingModel.PodPrice(priceStabilizationPod, now, then)
 	if err != nil {
 		glog.Errorf("Failed to get price for stabilization pod: %v", err)
@@ -102,6 +144,7 @@ nextoption:
 		}
 		totalNodePrice := nodePrice * float64(option.NodeCount)
 		totalPodPrice := 0.0
+		podPriceBreakdown := cloudprovider.PodPriceBreakdown{}
 		for _, pod := range option.Pods {
 			podPrice, err := p.pricingModel.PodPrice(pod, now, then)
 			if err != nil {
@@ -109,6 +152,16 @@ nextoption:
 				continue nextoption
 			}
 			totalPodPrice += podPrice
+			if breakdownModel, ok := p.pricingModel.(cloudprovider.PriceBreakdownPricingModel); ok {
+				breakdown, err := breakdownModel.PodPriceBreakdown(pod, now, then)
+				if err != nil {
+					glog.Warningf("Failed to calculate pod price breakdown for %s/%s: %v", pod.Namespace, pod.Name, err)
+					continue
+				}
+				podPriceBreakdown.CPU += breakdown.CPU
+				podPriceBreakdown.Memory += breakdown.Memory
+				podPriceBreakdown.GPU += breakdown.GPU
+			}
 		}
 		// Total pod price is 0 when the pods have no requests. The pods must have some other
 		// requirements that prevent them from scheduling like AntiAffinity, HostPort or the
@@ -137,20 +190,84 @@ nextoption:
 			supressedUnfitness,
 			optionScore,
 		)
+		if _, ok := p.pricingModel.(cloudprovider.PriceBreakdownPricingModel); ok {
+			debug += fmt.Sprintf(" pods_price_cpu=%f pods_price_memory=%f pods_price_gpu=%f",
+				podPriceBreakdown.CPU, podPriceBreakdown.Memory, podPriceBreakdown.GPU)
+		}
 
 		glog.V(5).Infof("Price expander for %s: %s", option.NodeGroup.Id(), debug)
 
+		scored := &expander.Option{
+			NodeGroup: option.NodeGroup,
+			NodeCount: option.NodeCount,
+			Debug:     fmt.Sprintf("%s | price-expander: %s", option.Debug, debug),
+			Pods:      option.Pods,
+		}
+		scoredOptions[option.NodeGroup.Id()] = scoredOption{option: scored, score: optionScore}
 		if bestOption == nil || bestOptionScore > optionScore {
-			bestOption = &expander.Option{
-				NodeGroup: option.NodeGroup,
-				NodeCount: option.NodeCount,
-				Debug:     fmt.Sprintf("%s | price-expander: %s", option.Debug, debug),
-				Pods:      option.Pods,
-			}
+			bestOption = scored
 			bestOptionScore = optionScore
 		}
 	}
-	return bestOption
+	if bestOption == nil || p.stabilityMargin <= 0 {
+		return bestOption
+	}
+	return p.stableOption(expansionOptions, bestOption, bestOptionScore, scoredOptions, now)
+}
+
+// scoredOption is an expansion option together with its price score (lower is better).
+type scoredOption struct {
+	option *expander.Option
+	score  float64
+}
+
+// stableOption returns the option remembered for the pods of expansionOptions if it is still
+// available and best isn't cheaper than it by more than the stability margin. Otherwise best is
+// returned. The returned choice is remembered.
+func (p *priceBased) stableOption(expansionOptions []expander.Option, best *expander.Option, bestScore float64,
+	scoredOptions map[string]scoredOption, now time.Time) *expander.Option {
+	for signature, choice := range p.choices {
+		if now.Sub(choice.chosenAt) > p.stabilityDuration {
+			delete(p.choices, signature)
+		}
+	}
+
+	chosen := best
+	signature := podsSignature(expansionOptions)
+	if choice, found := p.choices[signature]; found && choice.nodeGroupId != best.NodeGroup.Id() {
+		if previous, found := scoredOptions[choice.nodeGroupId]; found {
+			if bestScore >= previous.score*(1-p.stabilityMargin) {
+				glog.V(4).Infof("Price expander keeps %s instead of %s for the same pods, price score %f is within %.2f%% of %f",
+					choice.nodeGroupId, best.NodeGroup.Id(), previous.score, p.stabilityMargin*100, bestScore)
+				chosen = previous.option
+				chosen.Debug = fmt.Sprintf("%s stable_choice=true", chosen.Debug)
+			}
+		}
+	}
+	p.choices[signature] = stableChoice{nodeGroupId: chosen.NodeGroup.Id(), chosenAt: now}
+	return chosen
+}
+
+// podsSignature identifies the pods helped by the expansion options. Pods with a controller are
+// represented by the controller, so that replicas of the same workload pending in different
+// loops share the signature.
+func podsSignature(expansionOptions []expander.Option) string {
+	keys := make(map[string]bool)
+	for _, option := range expansionOptions {
+		for _, pod := range option.Pods {
+			if ref := drain.ControllerRef(pod); ref != nil {
+				keys[fmt.Sprintf("%s/%s/%s", pod.Namespace, ref.Kind, ref.Name)] = true
+			} else {
+				keys[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)] = true
+			}
+		}
+	}
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
 }
 
 // buildPod creates a pod with specified resources.
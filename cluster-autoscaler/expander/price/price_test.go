@@ -24,6 +24,7 @@ import (
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
 
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
@@ -50,6 +51,18 @@ func (tpm *testPricingModel) PodPrice(node *apiv1.Pod, startTime time.Time, endT
 	return 0.0, fmt.Errorf("price for pod %v not found", node.Name)
 }
 
+type testBreakdownPricingModel struct {
+	testPricingModel
+	breakdowns map[string]*cloudprovider.PodPriceBreakdown
+}
+
+func (tpm *testBreakdownPricingModel) PodPriceBreakdown(pod *apiv1.Pod, startTime time.Time, endTime time.Time) (*cloudprovider.PodPriceBreakdown, error) {
+	if breakdown, found := tpm.breakdowns[pod.Name]; found {
+		return breakdown, nil
+	}
+	return nil, fmt.Errorf("price breakdown for pod %v not found", pod.Name)
+}
+
 type testPreferredNodeProvider struct {
 	preferred *apiv1.Node
 }
@@ -305,3 +318,175 @@ func TestPriceExpander(t *testing.T) {
 		SimpleNodeUnfitness,
 	).BestOption(options3, nodeInfosForGroups).Debug, "ng3")
 }
+
+func TestPriceExpanderBreakdownDebug(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	p1 := BuildTestPod("p1", 1000, 0)
+	p2 := BuildTestPod("p2", 500, 0)
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	ng1, _ := provider.NodeGroupForNode(n1)
+	ni1 := schedulercache.NewNodeInfo()
+	ni1.SetNode(n1)
+	options := []expander.Option{
+		{
+			NodeGroup: ng1,
+			NodeCount: 1,
+			Pods:      []*apiv1.Pod{p1, p2},
+			Debug:     "ng1",
+		},
+	}
+
+	pricingModel := &testBreakdownPricingModel{
+		testPricingModel: testPricingModel{
+			podPrice:  map[string]float64{"p1": 20.0, "p2": 10.0, "stabilize": 10},
+			nodePrice: map[string]float64{"n1": 20.0},
+		},
+		breakdowns: map[string]*cloudprovider.PodPriceBreakdown{
+			"p1": {CPU: 15.0, Memory: 5.0},
+			"p2": {CPU: 6.0, Memory: 2.0, GPU: 2.0},
+		},
+	}
+	debug := NewStrategy(
+		pricingModel,
+		&testPreferredNodeProvider{preferred: buildNode(2000, 1024*1024*1024)},
+		SimpleNodeUnfitness,
+	).BestOption(options, map[string]*schedulercache.NodeInfo{"ng1": ni1}).Debug
+	assert.Contains(t, debug, "pods_price=30.000000")
+	assert.Contains(t, debug, "pods_price_cpu=21.000000 pods_price_memory=7.000000 pods_price_gpu=2.000000")
+}
+
+func TestPriceExpanderStability(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	p1 := BuildTestPod("p1", 500, 0)
+	p2 := BuildTestPod("p2", 500, 0)
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+	ng1, _ := provider.NodeGroupForNode(n1)
+	ng2, _ := provider.NodeGroupForNode(n2)
+
+	ni1 := schedulercache.NewNodeInfo()
+	ni1.SetNode(n1)
+	ni2 := schedulercache.NewNodeInfo()
+	ni2.SetNode(n2)
+	nodeInfosForGroups := map[string]*schedulercache.NodeInfo{
+		"ng1": ni1, "ng2": ni2,
+	}
+	options := []expander.Option{
+		{NodeGroup: ng1, NodeCount: 1, Pods: []*apiv1.Pod{p1, p2}, Debug: "ng1"},
+		{NodeGroup: ng2, NodeCount: 1, Pods: []*apiv1.Pod{p1, p2}, Debug: "ng2"},
+	}
+
+	pricing := &testPricingModel{
+		podPrice: map[string]float64{
+			"p1":        10.0,
+			"p2":        10.0,
+			"stabilize": 10.0,
+		},
+		nodePrice: map[string]float64{
+			"n1": 100.0,
+			"n2": 101.0,
+		},
+	}
+	preferred := &testPreferredNodeProvider{preferred: buildNode(1000, 1024*1024*1024)}
+	now := time.Now()
+	strategy := NewStableStrategy(pricing, preferred, SimpleNodeUnfitness, 0.05, 10*time.Minute).(*priceBased)
+	strategy.now = func() time.Time { return now }
+
+	assert.Equal(t, "ng1", strategy.BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+
+	// ng2 is cheaper by ~1%, within the margin.
+	pricing.nodePrice = map[string]float64{"n1": 101.0, "n2": 100.0}
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		best := strategy.BestOption(options, nodeInfosForGroups)
+		assert.Equal(t, "ng1", best.NodeGroup.Id())
+		assert.Contains(t, best.Debug, "stable_choice=true")
+	}
+	// Without stability the cheaper group is chosen.
+	assert.Equal(t, "ng2", NewStrategy(pricing, preferred, SimpleNodeUnfitness).BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+
+	// ng2 is cheaper by ~10%, above the margin.
+	pricing.nodePrice = map[string]float64{"n1": 101.0, "n2": 90.0}
+	now = now.Add(time.Minute)
+	assert.Equal(t, "ng2", strategy.BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+
+	// ng2 is remembered now.
+	pricing.nodePrice = map[string]float64{"n1": 100.0, "n2": 101.0}
+	now = now.Add(time.Minute)
+	assert.Equal(t, "ng2", strategy.BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+
+	// The choice expires.
+	now = now.Add(11 * time.Minute)
+	assert.Equal(t, "ng1", strategy.BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+
+	// Different pods don't share the remembered choice.
+	p3 := BuildTestPod("p3", 500, 0)
+	pricing.podPrice["p3"] = 10.0
+	pricing.nodePrice = map[string]float64{"n1": 101.0, "n2": 100.0}
+	otherOptions := []expander.Option{
+		{NodeGroup: ng1, NodeCount: 1, Pods: []*apiv1.Pod{p3}, Debug: "ng1"},
+		{NodeGroup: ng2, NodeCount: 1, Pods: []*apiv1.Pod{p3}, Debug: "ng2"},
+	}
+	assert.Equal(t, "ng2", strategy.BestOption(otherOptions, nodeInfosForGroups).NodeGroup.Id())
+}
+
+func TestPriceExpanderFitEfficiency(t *testing.T) {
+	gb := int64(1024 * 1024 * 1024)
+	n1 := BuildTestNode("n1", 4000, 4*gb)
+	n2 := BuildTestNode("n2", 4000, 32*gb)
+	p1 := BuildTestPod("p1", 1500, gb)
+	p2 := BuildTestPod("p2", 1500, gb)
+
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+	ng1, _ := provider.NodeGroupForNode(n1)
+	ng2, _ := provider.NodeGroupForNode(n2)
+
+	ni1 := schedulercache.NewNodeInfo()
+	ni1.SetNode(n1)
+	ni2 := schedulercache.NewNodeInfo()
+	ni2.SetNode(n2)
+	nodeInfosForGroups := map[string]*schedulercache.NodeInfo{
+		"ng1": ni1, "ng2": ni2,
+	}
+	options := []expander.Option{
+		{NodeGroup: ng1, NodeCount: 1, Pods: []*apiv1.Pod{p1, p2}, Debug: "ng1"},
+		{NodeGroup: ng2, NodeCount: 1, Pods: []*apiv1.Pod{p1, p2}, Debug: "ng2"},
+	}
+
+	// Uses 75% of cpu and 50% of memory of ng1 nodes, but only 6.25% of memory of ng2 nodes.
+	assert.InDelta(t, 0.5, fitEfficiency(options[0], n1), 0.0001)
+	assert.InDelta(t, 0.0625, fitEfficiency(options[1], n2), 0.0001)
+
+	pricing := &testPricingModel{
+		podPrice: map[string]float64{
+			"p1":        20.0,
+			"p2":        20.0,
+			"stabilize": 10.0,
+		},
+		nodePrice: map[string]float64{
+			"n1": 100.0,
+			"n2": 60.0,
+		},
+	}
+	preferred := &testPreferredNodeProvider{preferred: buildNode(4000, 32*gb)}
+	strategy := NewStrategy(pricing, preferred, SimpleNodeUnfitness)
+
+	// The memory-heavy ng2 is cheaper.
+	assert.Equal(t, "ng2", strategy.BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+	// ng2 is filtered out.
+	assert.Equal(t, "ng1", NewEfficiencyFilter(0.3, strategy).BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+	// No option reaches the floor, all are considered.
+	assert.Equal(t, "ng2", NewEfficiencyFilter(0.9, strategy).BestOption(options, nodeInfosForGroups).NodeGroup.Id())
+}
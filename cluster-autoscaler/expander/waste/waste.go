@@ -31,7 +31,12 @@ type leastwaste struct {
 
 // NewStrategy returns a strategy that selects the best scale up option based on which node group returns the least waste
 func NewStrategy() expander.Strategy {
-	return &leastwaste{random.NewStrategy()}
+	return NewStrategyWithFallback(random.NewStrategy())
+}
+
+// NewStrategyWithFallback returns a least-waste strategy that breaks ties with the given fallback strategy.
+func NewStrategyWithFallback(fallbackStrategy expander.Strategy) expander.Strategy {
+	return &leastwaste{fallbackStrategy}
 }
 
 // BestOption Finds the option that wastes the least fraction of CPU and Memory
@@ -40,18 +45,12 @@ func (l *leastwaste) BestOption(expansionOptions []expander.Option, nodeInfo map
 	var leastWastedOptions []expander.Option
 
 	for _, option := range expansionOptions {
-		requestedCPU, requestedMemory := resourcesForPods(option.Pods)
 		node, found := nodeInfo[option.NodeGroup.Id()]
 		if !found {
 			glog.Errorf("No node info for: %s", option.NodeGroup.Id())
 			continue
 		}
-
-		nodeCPU, nodeMemory := resourcesForNode(node.Node())
-		availCPU := nodeCPU.MilliValue() * int64(option.NodeCount)
-		availMemory := nodeMemory.Value() * int64(option.NodeCount)
-		wastedCPU := float64(availCPU-requestedCPU.MilliValue()) / float64(availCPU)
-		wastedMemory := float64(availMemory-requestedMemory.Value()) / float64(availMemory)
+		wastedCPU, wastedMemory := wastedFractions(option, node)
 		wastedScore := wastedCPU + wastedMemory
 
 		glog.V(1).Infof("Expanding Node Group %s would waste %0.2f%% CPU, %0.2f%% Memory, %0.2f%% Blended\n", option.NodeGroup.Id(), wastedCPU*100.0, wastedMemory*100.0, wastedScore*50.0)
@@ -73,6 +72,23 @@ func (l *leastwaste) BestOption(expansionOptions []expander.Option, nodeInfo map
 	return l.fallbackStrategy.BestOption(leastWastedOptions, nodeInfo)
 }
 
+// Score returns the sum of fractions of CPU and memory of the option's new nodes that would be left
+// unused by its pods. Lower is better.
+func Score(option expander.Option, nodeInfo *schedulercache.NodeInfo) float64 {
+	wastedCPU, wastedMemory := wastedFractions(option, nodeInfo)
+	return wastedCPU + wastedMemory
+}
+
+func wastedFractions(option expander.Option, nodeInfo *schedulercache.NodeInfo) (cpu float64, memory float64) {
+	requestedCPU, requestedMemory := resourcesForPods(option.Pods)
+	nodeCPU, nodeMemory := resourcesForNode(nodeInfo.Node())
+	availCPU := nodeCPU.MilliValue() * int64(option.NodeCount)
+	availMemory := nodeMemory.Value() * int64(option.NodeCount)
+	cpu = float64(availCPU-requestedCPU.MilliValue()) / float64(availCPU)
+	memory = float64(availMemory-requestedMemory.Value()) / float64(availMemory)
+	return cpu, memory
+}
+
 func resourcesForPods(pods []*apiv1.Pod) (cpu resource.Quantity, memory resource.Quantity) {
 	for _, pod := range pods {
 		for _, container := range pod.Spec.Containers {
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
@@ -29,20 +30,25 @@ import (
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	kube_flag "k8s.io/apiserver/pkg/util/flag"
 	"k8s.io/autoscaler/cluster-autoscaler/config"
 	"k8s.io/autoscaler/cluster-autoscaler/config/dynamic"
 	"k8s.io/autoscaler/cluster-autoscaler/core"
+	"k8s.io/autoscaler/cluster-autoscaler/debug"
 	"k8s.io/autoscaler/cluster-autoscaler/estimator"
 	"k8s.io/autoscaler/cluster-autoscaler/expander"
 	"k8s.io/autoscaler/cluster-autoscaler/metrics"
 	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	kube_util "k8s.io/autoscaler/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/nodegroupset"
 	kube_client "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	kube_leaderelection "k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	kube_record "k8s.io/client-go/tools/record"
 	"k8s.io/kubernetes/pkg/apis/componentconfig"
 
 	"github.com/golang/glog"
@@ -66,12 +72,18 @@ func (flag *MultiStringFlag) Set(value string) error {
 
 var (
 	nodeGroupsFlag         MultiStringFlag
+	headroomFlag           MultiStringFlag
+	nsScaleUpDelayFlag     MultiStringFlag
+	acceleratorFlag        MultiStringFlag
+	ignoreTaintFlag        MultiStringFlag
 	clusterName            = flag.String("cluster-name", "", "Autoscaled cluster name, if available")
 	address                = flag.String("address", ":8085", "The address to expose prometheus metrics.")
 	kubernetes             = flag.String("kubernetes", "", "Kubernetes master location. Leave blank for default")
 	kubeConfigFile         = flag.String("kubeconfig", "", "Path to kubeconfig file with authorization and master location information.")
 	cloudConfig            = flag.String("cloud-config", "", "The path to the cloud provider configuration file.  Empty string for no configuration file.")
 	configMapName          = flag.String("configmap", "", "The name of the ConfigMap containing settings used for dynamic reconfiguration. Empty string for no ConfigMap.")
+	profile                = flag.String("profile", "", "Preset of flag values: balanced, cost-optimized or latency-optimized. Flags set on the command line or in the configuration file take precedence. Empty string for no profile.")
+	configFile             = flag.String("config", "", "The path to the configuration file with values of flags and per node group options. Flags set on the command line take precedence. Empty string for no configuration file.")
 	namespace              = flag.String("namespace", "kube-system", "Namespace in which cluster-autoscaler run. If a --configmap flag is also provided, ensure that the configmap exists in this namespace before CA runs.")
 	nodeGroupAutoDiscovery = flag.String("node-group-auto-discovery", "", "One or more definition(s) of node group auto-discovery. A definition is expressed `<name of discoverer per cloud provider>:[<key>[=<value>]]`. Only the `aws` cloud provider is currently supported. The only valid discoverer for it is `asg` and the valid key is `tag`. For example, specifying `--cloud-provider aws` and `--node-group-auto-discovery asg:tag=cluster-autoscaler/auto-discovery/enabled,kubernetes.io/cluster/<YOUR CLUSTER NAME>` results in ASGs tagged with `cluster-autoscaler/auto-discovery/enabled` and `kubernetes.io/cluster/<YOUR CLUSTER NAME>` to be considered as target node groups")
 	scaleDownEnabled       = flag.Bool("scale-down-enabled", true, "Should CA scale down the cluster")
@@ -81,12 +93,29 @@ var (
 		"How long after node deletion that scale down evaluation resumes, defaults to scanInterval")
 	scaleDownDelayAfterFailure = flag.Duration("scale-down-delay-after-failure", 3*time.Minute,
 		"How long after scale down failure that scale down evaluation resumes")
+	scaleDownDelayType = flag.String("scale-down-delay-type", "all",
+		"Scope of --scale-down-delay-after-add: all holds back scale down in the whole cluster, per-nodegroup only in the node groups that were scaled up")
+	bypassScaleDownDelayForEmptyNodes = flag.Bool("bypass-scale-down-delay-for-empty-nodes", false,
+		"Should CA remove empty nodes during --scale-down-delay-after-add and --scale-down-delay-after-failure. Removals of nodes with pods are still delayed")
 	scaleDownUnneededTime = flag.Duration("scale-down-unneeded-time", 10*time.Minute,
 		"How long a node should be unneeded before it is eligible for scale down")
 	scaleDownUnreadyTime = flag.Duration("scale-down-unready-time", 20*time.Minute,
 		"How long an unready node should be unneeded before it is eligible for scale down")
+	enforceNodeGroupMaxSize = flag.Bool("enforce-node-group-max-size", false,
+		"Should CA remove nodes from node groups above their max size, without waiting for the nodes to be unneeded")
+	scaleDownOrphanNodes = flag.Bool("scale-down-orphan-nodes", false,
+		"Should CA remove nodes that don't belong to any node group, match orphan-nodes-selector and are empty for scale-down-unneeded-time")
+	orphanNodesSelector = flag.String("orphan-nodes-selector", "",
+		"Label selector of nodes outside of node groups that CA may remove. Required by scale-down-orphan-nodes")
+	compactionEvictionsPerHour = flag.Int("compaction-evictions-per-hour", 0,
+		"Maximum number of pods per hour CA evicts from underutilized nodes that can't be removed because of their other pods, "+
+			"when that makes another node removable. 0 disables compaction")
+	compactionWorkloadCooldown = flag.Duration("compaction-workload-cooldown", 2*time.Hour,
+		"How long pods of a workload evicted by compaction are not evicted by compaction again")
 	scaleDownUtilizationThreshold = flag.Float64("scale-down-utilization-threshold", 0.5,
 		"Node utilization level, defined as sum of requested resources divided by capacity, below which a node can be considered for scale down")
+	scaleDownGpuUtilization = flag.Bool("scale-down-gpu-utilization", false,
+		"Should CA measure utilization of GPU nodes by requested GPUs only, ignoring CPU and memory")
 	scaleDownNonEmptyCandidatesCount = flag.Int("scale-down-non-empty-candidates-count", 30,
 		"Maximum number of non empty nodes considered in one iteration as candidates for scale down with drain."+
 			"Lower value means better CA responsiveness but possible slower scale down latency."+
@@ -103,34 +132,100 @@ var (
 			"for scale down when some candidates from previous iteration are no longer valid."+
 			"When calculating the pool size for additional candidates we take"+
 			"max(#nodes * scale-down-candidates-pool-ratio, scale-down-candidates-pool-min-count).")
+	scaleDownCandidatePriorityWeight = flag.Float64("scale-down-candidate-priority-weight", 0,
+		"Weight of the highest priority of pods on a node in its scale-down priority score. "+
+			"Among unneeded nodes with equal utilization, the ones with lower scores are removed first. "+
+			"0 disables the scores.")
 	scanInterval                = flag.Duration("scan-interval", 10*time.Second, "How often cluster is reevaluated for scale up or down")
+	adaptiveScanInterval        = flag.Bool("adaptive-scan-interval", false, "If true, the scan interval drops to min-scan-interval after loops with pending pods or scale-up or scale-down in progress, and doubles up to max-scan-interval after every scan-interval-quiet-loops loops with nothing to do")
+	minScanInterval             = flag.Duration("min-scan-interval", 2*time.Second, "Lower bound of the scan interval, if adaptive-scan-interval is set")
+	maxScanInterval             = flag.Duration("max-scan-interval", time.Minute, "Upper bound of the scan interval, if adaptive-scan-interval is set")
+	scanIntervalQuietLoops      = flag.Int("scan-interval-quiet-loops", 3, "Number of consecutive loops with nothing to do after which the scan interval doubles, if adaptive-scan-interval is set")
 	maxNodesTotal               = flag.Int("max-nodes-total", 0, "Maximum number of nodes in all node groups. Cluster autoscaler will not grow the cluster beyond this number.")
 	coresTotal                  = flag.String("cores-total", minMaxFlagString(0, config.DefaultMaxClusterCores), "Minimum and maximum number of cores in cluster, in the format <min>:<max>. Cluster autoscaler will not scale the cluster beyond these numbers.")
 	memoryTotal                 = flag.String("memory-total", minMaxFlagString(0, config.DefaultMaxClusterMemory), "Minimum and maximum number of gigabytes of memory in cluster, in the format <min>:<max>. Cluster autoscaler will not scale the cluster beyond these numbers.")
 	cloudProviderFlag           = flag.String("cloud-provider", "gce", "Cloud provider type. Allowed values: gce, aws, kubemark")
 	maxEmptyBulkDeleteFlag      = flag.Int("max-empty-bulk-delete", 10, "Maximum number of empty nodes that can be deleted at the same time.")
-	maxGracefulTerminationFlag  = flag.Int("max-graceful-termination-sec", 10*60, "Maximum number of seconds CA waits for pod termination when trying to scale down a node.")
+	maxDrainParallelismPerZone  = flag.Int("max-drain-parallelism-per-zone", 0, "Maximum number of nodes in a zone that can be removed at the same time. 0 means no limit.")
+	maxDrainParallelismPerGroup = flag.Int("max-drain-parallelism-per-node-group", 0, "Maximum number of nodes of a node group that can be removed at the same time, unless overridden in the configuration file. 0 means no limit.")
+	maxGracefulTerminationFlag  = flag.Int("max-graceful-termination-sec", 10*60, "Maximum number of seconds CA waits for termination of each pod when trying to scale down a node.")
+	evictionDeleteFallback      = flag.Bool("eviction-delete-fallback", false, "If true, pods annotated with cluster-autoscaler.kubernetes.io/allow-force-delete=true are deleted during scale down when an admission webhook denies their eviction. Pods covered by a PodDisruptionBudget are never deleted this way")
+	maxNodeDrainTime            = flag.Duration("max-node-drain-time", 20*time.Minute, "Maximum time CA waits for all pods to terminate when trying to scale down a node, including pods with longer drain-timeout annotation. 0 means no limit.")
 	maxTotalUnreadyPercentage   = flag.Float64("max-total-unready-percentage", 33, "Maximum percentage of unready nodes after which CA halts operations")
 	okTotalUnreadyCount         = flag.Int("ok-total-unready-count", 3, "Number of allowed unready nodes, irrespective of max-total-unready-percentage")
 	maxNodeProvisionTime        = flag.Duration("max-node-provision-time", 15*time.Minute, "Maximum time CA waits for node to be provisioned")
+	adaptiveProvisionTimeout    = flag.Bool("adaptive-provision-timeout", false, "Should CA wait for nodes of a node group provision-timeout-factor times the p95 of its recent provision times, instead of max-node-provision-time")
+	provisionTimeoutFactor      = flag.Float64("provision-timeout-factor", 1.5, "Factor applied to the p95 provision time of a node group to get its timeout, if adaptive-provision-timeout is set")
+	minProvisionTimeout         = flag.Duration("min-adaptive-provision-timeout", 2*time.Minute, "Lower bound of adaptive provision timeouts")
+	maxProvisionTimeout         = flag.Duration("max-adaptive-provision-timeout", 30*time.Minute, "Upper bound of adaptive provision timeouts")
+	stockoutMemoryTTL           = flag.Duration("stockout-memory-ttl", 0, "How long scale-up avoids node groups with the instance type and zone of a node group that ran out of cloud provider capacity, unless no other node group can help. 0 disables it")
 	unregisteredNodeRemovalTime = flag.Duration("unregistered-node-removal-time", 15*time.Minute, "Time that CA waits before removing nodes that are not registered in Kubernetes")
+	reconcileTargetSize         = flag.Bool("reconcile-target-size", false, "If true, target sizes of node groups that stay above their numbers of instances with no scale-up in progress for longer than the provision timeout are decreased to match them")
+	deletedInstanceNodeRemoval  = flag.Duration("deleted-instance-node-removal-time", 0, "Time that CA waits before removing nodes whose instances no longer exist on the cloud provider side. 0 disables the removal")
+	toBeDeletedTaintTTL         = flag.Duration("to-be-deleted-taint-ttl", 30*time.Minute, "Time after which ToBeDeleted taints left on nodes, e.g. by a previous run of CA, are removed when no scale-down is in progress. 0 disables the removal")
 
 	estimatorFlag = flag.String("estimator", estimator.BinpackingEstimatorName,
 		"Type of resource estimator to be used in scale up. Available values: ["+strings.Join(estimator.AvailableEstimators, ",")+"]")
+	schedulerConfigFile = flag.String("scheduler-config-file", "", "The path to the scheduler policy file, as passed to the scheduler with --policy-config-file. "+
+		"Simulations place pods on the nodes the configured resource priority prefers. Empty string to place pods on the first node they fit on")
 
 	expanderFlag = flag.String("expander", expander.RandomExpanderName,
 		"Type of node group expander to be used in scale up. Available values: ["+strings.Join(expander.AvailableExpanders, ",")+"]")
+	expanderPriceStabilityMargin = flag.Float64("expander-price-stability-margin", 0,
+		"The price expander keeps choosing the node group it chose for the same pods unless another option is cheaper by more than this fraction, e.g. 0.05. 0 disables it.")
+	expanderPriceStabilityDuration = flag.Duration("expander-price-stability-duration", 30*time.Minute,
+		"How long the price expander remembers the node group it chose for the same pods, see --expander-price-stability-margin.")
+	expanderPriceMinFitEfficiency = flag.Float64("expander-price-min-fit-efficiency", 0,
+		"The price expander skips node groups whose new nodes would have a lower fraction of cpu or memory requested by the pending pods than this, unless no node group reaches it. 0 disables it.")
 
 	writeStatusConfigMapFlag         = flag.Bool("write-status-configmap", true, "Should CA write status information to a configmap")
 	maxInactivityTimeFlag            = flag.Duration("max-inactivity", 10*time.Minute, "Maximum time from last recorded autoscaler activity before automatic restart")
 	maxFailingTimeFlag               = flag.Duration("max-failing-time", 15*time.Minute, "Maximum time from last recorded successful autoscaler run before automatic restart")
+	maxLoopDurationScanIntervals     = flag.Int("max-loop-duration-scan-intervals", 60, "Maximum duration of a single autoscaler loop, as a multiple of scan-interval, before /readyz starts failing")
+	killOnStuckLoop                  = flag.Duration("kill-on-stuck-loop", 0, "If set, the process exits when a single autoscaler loop runs for longer than this, so that it gets restarted. 0 means disabled")
 	balanceSimilarNodeGroupsFlag     = flag.Bool("balance-similar-node-groups", false, "Detect similar node groups and balance the number of nodes between them")
+	nodeGroupSplitStrategy           = flag.String("node-group-split-strategy", nodegroupset.EvenSplittingStrategyName, "Strategy of splitting new nodes between similar node groups with --balance-similar-node-groups. Available values: ["+strings.Join(nodegroupset.AvailableSplittingStrategies, ",")+"]")
 	nodeAutoprovisioningEnabled      = flag.Bool("node-autoprovisioning-enabled", false, "Should CA autoprovision node groups when needed")
 	maxAutoprovisionedNodeGroupCount = flag.Int("max-autoprovisioned-node-group-count", 15, "The maximum number of autoprovisioned groups in the cluster.")
+	emptyAutoprovisionedGroupTTL     = flag.Duration("empty-autoprovisioned-node-group-ttl", 0, "How long an autoprovisioned node group has to be empty before it is deleted. 0 means it is deleted as soon as it's empty.")
 
 	expendablePodsPriorityCutoff = flag.Int("expendable-pods-priority_cutoff", 0, "Pods with priority below cutoff will be expendable. They can be killed without any consideration during scale down and they don't cause scale up. Pods with null priority (PodPriority disabled) are non expendable.")
+	scaleDownSimulationSliceSize = flag.Int("scale-down-simulation-slice-size", 0, "Maximum number of non-empty nodes for which scale-down is simulated in a single loop. In very large clusters this spreads the simulation across loops, bounding loop duration. 0 means all nodes are simulated in every loop.")
+	scaleDownSimulateUpcoming    = flag.Bool("scale-down-simulate-upcoming-nodes", false, "If true, nodes from scale-ups in progress are considered as a place for pods during scale-down simulation. Nodes whose pods fit only on upcoming nodes are removed after those nodes register.")
+	estimatorCapacityMargin      = flag.String("estimator-capacity-margin", "", "Capacity subtracted from allocatable of template nodes when estimating how many nodes pending pods need, to make up for real nodes having less room than their template. Either a percentage, e.g. 2%, or absolute amounts, e.g. cpu=100m,memory=256Mi. Empty for no margin.")
+	capacityReservations         = flag.Bool("capacity-reservations", false, "If true, CA keeps capacity reserved for namespaces in the cluster-autoscaler-capacity-reservations ConfigMap available, expanding node groups and holding back scale-down as needed. Each key of the ConfigMap is a namespace, each value has the format cpu=<quantity>,memory=<quantity>:nodeGroups=<id>[,<id>].")
+	considerPreemption           = flag.Bool("consider-preemption-in-scale-up", false, "If true, pending pods that the scheduler can place by preempting lower priority pods don't trigger scale-up. Pods they would preempt are treated as pending instead.")
+	scaleUpPrefilterEnabled      = flag.Bool("scale-up-prefilter-enabled", true, "If true, scale-up rules out node groups whose template node doesn't match a pod's node selector, required node affinity or doesn't have its taints tolerated before running all scheduler predicates. Disable if pods are wrongly reported as not fitting any node group.")
+	forceNodeGroupAnnotation     = flag.Bool("force-node-group-annotation-enabled", false, "If true, pending pods annotated with cluster-autoscaler.kubernetes.io/force-node-group=<id> trigger scale-up of the named node group, bypassing the expander and node group backoff. Max size and cluster-wide limits still apply.")
+	templateNodeInfoCacheTTL     = flag.Duration("template-node-info-cache-ttl", 10*time.Minute, "Maximum time template nodes built from node group templates are reused, for cloud providers that report template changes. 0 disables caching.")
+	maxNodesPerMinute            = flag.Int("max-nodes-per-minute", 0, "Maximum number of nodes that can be added to the cluster per minute. Larger scale-ups are truncated and continued in later loops. 0 means no limit.")
+	maxNodesPerMinutePerGroup    = flag.Int("max-nodes-per-minute-per-node-group", 0, "Maximum number of nodes that can be added to a single node group per minute, unless overridden with maxNodesPerMinute in the configuration file. 0 means no limit.")
+	recordDecisionsDir           = flag.String("record-decisions-dir", "", "If set, every scale-up decision made by the expander is written as a JSON document to this directory, for offline replay.")
+	recordPackingTrace           = flag.Bool("record-packing-trace", false, "If true, scale-up decisions written to --record-decisions-dir include assignments of pods to simulated nodes made by the binpacking estimator.")
+	newPodScaleUpDelay           = flag.Duration("new-pod-scale-up-delay", 0, "Pending pods younger than this don't trigger scale-up. Can be overridden per namespace with --new-pod-scale-up-delay-per-namespace and per pod with the cluster-autoscaler.kubernetes.io/pod-scale-up-delay annotation.")
+	maxUnschedulablePods         = flag.Int("max-unschedulable-pods-considered", 0, "Maximum number of unschedulable pods considered in a loop, selected by priority and then age. The remaining pods are deferred to next loops. 0 means no limit.")
+	oversizedPodsRecheck         = flag.Duration("oversized-pods-recheck-interval", 10*time.Minute, "Pending pods requesting more of some resource than the template node of any node group has are skipped in scale-up and checked again after this time, or as soon as node groups or their templates change. 0 checks them in every loop.")
+	scaleUpOptOutNsSelector      = flag.String("scale-up-opt-out-namespace-selector", core.DefaultScaleUpOptOutNamespaceSelector, "Label selector of namespaces whose pending pods don't trigger scale-up. Empty string disables the opt-out.")
+	adaptivePodScaleUpDelay      = flag.Duration("adaptive-pod-scale-up-delay", 0, "Added to the scale-up delay of pods whose controller had at least 2 pods terminate within --fast-pod-failure-threshold of starting, until a pod of the controller runs longer. 0 disables the adaptive delay.")
+	fastPodFailureThreshold      = flag.Duration("fast-pod-failure-threshold", 30*time.Second, "A pod terminating within this time of starting counts as a fast failure of its controller for --adaptive-pod-scale-up-delay.")
+	crashDumpDestination         = flag.String("crash-dump-destination", "", "If set, the last known state of CA is written here when CA panics or exits with a fatal error. Either a local directory, for example on a persistent volume, or a gs://<bucket>/<prefix> or s3://<bucket>/<prefix> URL written with ambient credentials.")
+	crashDumpMaxSize             = flag.Int("crash-dump-max-size", debug.DefaultCrashDumpMaxSize, "Maximum size of a crash dump in bytes. Least important parts of the state are dropped to fit.")
+	capacityForecastEnabled      = flag.Bool("capacity-forecast-enabled", false, "If true, POST /simulate accepts a list of pods and returns which node groups could provision nodes for them, how many nodes are needed and how long recent scale-ups of the node groups took.")
+	capacityForecastTimeout      = flag.Duration("capacity-forecast-timeout", 5*time.Second, "Maximum time spent on a single request to /simulate. Node groups not evaluated in time are reported as such.")
+	dryRun                       = flag.Bool("dry-run", false, "If true, CA runs its whole loop but doesn't resize node groups, delete nodes or evict pods. Actions that would be taken are reported as events and metrics instead.")
+	runOnce                      = flag.Bool("run-once", false, "If true, CA runs a single dry-run loop, prints a report of the scale-ups and scale-downs it would make and exits. Implies --dry-run and --leader-elect=false.")
+	outputFormat                 = flag.String("output", "", "Format of the report printed by --run-once. Allowed values: json")
 )
 
+// crashReporter writes the state of CA on crashes. Nil if --crash-dump-destination is not set.
+var crashReporter *debug.CrashReporter
+
+// capacityForecaster serves /simulate. Nil if --capacity-forecast-enabled is not set.
+var capacityForecaster *core.CapacityForecaster
+
+// commandLineFlags are names of flags set on the command line, recorded before values from the configuration file are applied.
+var commandLineFlags = make(map[string]bool)
+
 func createAutoscalerOptions() core.AutoscalerOptions {
 	minCoresTotal, maxCoresTotal, err := parseMinMaxFlag(*coresTotal)
 	if err != nil {
@@ -140,6 +235,52 @@ func createAutoscalerOptions() core.AutoscalerOptions {
 	if err != nil {
 		glog.Fatalf("Failed to parse flags: %v", err)
 	}
+	if *scaleDownOrphanNodes {
+		if *orphanNodesSelector == "" {
+			glog.Fatalf("Failed to parse flags: --scale-down-orphan-nodes requires --orphan-nodes-selector")
+		}
+		if _, err := labels.Parse(*orphanNodesSelector); err != nil {
+			glog.Fatalf("Failed to parse flags: invalid --orphan-nodes-selector: %v", err)
+		}
+	}
+	if *compactionEvictionsPerHour < 0 {
+		glog.Fatalf("Failed to parse flags: --compaction-evictions-per-hour must not be negative, got %d", *compactionEvictionsPerHour)
+	}
+	if *expanderPriceStabilityMargin < 0 || *expanderPriceStabilityMargin >= 1 {
+		glog.Fatalf("Failed to parse flags: --expander-price-stability-margin must be in [0, 1), got %v", *expanderPriceStabilityMargin)
+	}
+	if *outputFormat != "" && *outputFormat != "json" {
+		glog.Fatalf("Failed to parse flags: unsupported --output %q, allowed values: json", *outputFormat)
+	}
+	if *outputFormat != "" && !*runOnce {
+		glog.Fatalf("Failed to parse flags: --output requires --run-once")
+	}
+	if *expanderPriceMinFitEfficiency < 0 || *expanderPriceMinFitEfficiency > 1 {
+		glog.Fatalf("Failed to parse flags: --expander-price-min-fit-efficiency must be in [0, 1], got %v", *expanderPriceMinFitEfficiency)
+	}
+	if *scaleDownDelayType != "all" && *scaleDownDelayType != "per-nodegroup" {
+		glog.Fatalf("Failed to parse flags: unsupported --scale-down-delay-type %q, allowed values: all, per-nodegroup", *scaleDownDelayType)
+	}
+	if err := simulator.ValidateIgnorePodsForEmptyNodes(); err != nil {
+		glog.Fatalf("Failed to parse flags: invalid --ignore-pods-for-empty-nodes: %v", err)
+	}
+	accelerators := make([]gpu.Accelerator, 0, len(acceleratorFlag))
+	for _, value := range acceleratorFlag {
+		accelerator, err := gpu.ParseAccelerator(value)
+		if err != nil {
+			glog.Fatalf("Failed to parse flags: invalid --accelerator: %v", err)
+		}
+		accelerators = append(accelerators, accelerator)
+	}
+	gpu.RegisterAccelerators(accelerators)
+	scoringStrategy := simulator.FirstFit
+	if *schedulerConfigFile != "" {
+		scoringStrategy, err = simulator.LoadScoringStrategy(*schedulerConfigFile)
+		if err != nil {
+			glog.Fatalf("Failed to parse flags: invalid --scheduler-config-file: %v", err)
+		}
+		glog.V(1).Infof("Using %s scoring strategy in simulations", scoringStrategy)
+	}
 	// Convert memory limits to megabytes.
 	minMemoryTotal = minMemoryTotal * 1024
 	maxMemoryTotal = maxMemoryTotal * 1024
@@ -151,34 +292,89 @@ func createAutoscalerOptions() core.AutoscalerOptions {
 		MaxTotalUnreadyPercentage:        *maxTotalUnreadyPercentage,
 		OkTotalUnreadyCount:              *okTotalUnreadyCount,
 		EstimatorName:                    *estimatorFlag,
+		ScoringStrategy:                  scoringStrategy,
 		ExpanderName:                     *expanderFlag,
+		ExpanderPriceStabilityMargin:     *expanderPriceStabilityMargin,
+		ExpanderPriceStabilityDuration:   *expanderPriceStabilityDuration,
+		ExpanderPriceMinFitEfficiency:    *expanderPriceMinFitEfficiency,
 		MaxEmptyBulkDelete:               *maxEmptyBulkDeleteFlag,
+		MaxDrainParallelismPerZone:       *maxDrainParallelismPerZone,
+		MaxDrainParallelismPerNodeGroup:  *maxDrainParallelismPerGroup,
 		MaxGracefulTerminationSec:        *maxGracefulTerminationFlag,
+		MaxNodeDrainTime:                 *maxNodeDrainTime,
+		EvictionDeleteFallback:           *evictionDeleteFallback,
 		MaxNodeProvisionTime:             *maxNodeProvisionTime,
+		AdaptiveProvisionTimeout:         *adaptiveProvisionTimeout,
+		ProvisionTimeoutFactor:           *provisionTimeoutFactor,
+		MinProvisionTimeout:              *minProvisionTimeout,
+		MaxProvisionTimeout:              *maxProvisionTimeout,
+		StockoutMemoryTTL:                *stockoutMemoryTTL,
 		MaxNodesTotal:                    *maxNodesTotal,
 		MaxCoresTotal:                    maxCoresTotal,
 		MinCoresTotal:                    minCoresTotal,
 		MaxMemoryTotal:                   maxMemoryTotal,
 		MinMemoryTotal:                   minMemoryTotal,
 		NodeGroups:                       nodeGroupsFlag,
+		Headroom:                         headroomFlag,
+		EstimatorCapacityMargin:          *estimatorCapacityMargin,
+		CapacityReservationsEnabled:      *capacityReservations,
 		UnregisteredNodeRemovalTime:      *unregisteredNodeRemovalTime,
+		ReconcileTargetSize:              *reconcileTargetSize,
+		DeletedInstanceNodeRemovalTime:   *deletedInstanceNodeRemoval,
+		ToBeDeletedTaintTTL:              *toBeDeletedTaintTTL,
 		ScaleDownDelayAfterAdd:           *scaleDownDelayAfterAdd,
 		ScaleDownDelayAfterDelete:        *scaleDownDelayAfterDelete,
 		ScaleDownDelayAfterFailure:       *scaleDownDelayAfterFailure,
+		ScaleDownDelayPerNodeGroup:       *scaleDownDelayType == "per-nodegroup",
+		BypassScaleDownDelayForEmpty:     *bypassScaleDownDelayForEmptyNodes,
 		ScaleDownEnabled:                 *scaleDownEnabled,
 		ScaleDownUnneededTime:            *scaleDownUnneededTime,
 		ScaleDownUnreadyTime:             *scaleDownUnreadyTime,
 		ScaleDownUtilizationThreshold:    *scaleDownUtilizationThreshold,
+		ScaleDownGpuUtilization:          *scaleDownGpuUtilization,
+		EnforceNodeGroupMaxSize:          *enforceNodeGroupMaxSize,
+		ScaleDownOrphanNodes:             *scaleDownOrphanNodes,
+		OrphanNodesSelector:              *orphanNodesSelector,
+		CompactionEvictionsPerHour:       *compactionEvictionsPerHour,
+		CompactionWorkloadCooldown:       *compactionWorkloadCooldown,
 		ScaleDownNonEmptyCandidatesCount: *scaleDownNonEmptyCandidatesCount,
 		ScaleDownCandidatesPoolRatio:     *scaleDownCandidatesPoolRatio,
 		ScaleDownCandidatesPoolMinCount:  *scaleDownCandidatesPoolMinCount,
+		ScaleDownCandidatePriorityWeight: *scaleDownCandidatePriorityWeight,
 		WriteStatusConfigMap:             *writeStatusConfigMapFlag,
 		BalanceSimilarNodeGroups:         *balanceSimilarNodeGroupsFlag,
+		NodeGroupSplitStrategy:           *nodeGroupSplitStrategy,
 		ConfigNamespace:                  *namespace,
 		ClusterName:                      *clusterName,
 		NodeAutoprovisioningEnabled:      *nodeAutoprovisioningEnabled,
 		MaxAutoprovisionedNodeGroupCount: *maxAutoprovisionedNodeGroupCount,
+		EmptyAutoprovisionedGroupTTL:     *emptyAutoprovisionedGroupTTL,
 		ExpendablePodsPriorityCutoff:     *expendablePodsPriorityCutoff,
+		ScaleDownSimulationSliceSize:     *scaleDownSimulationSliceSize,
+		ScaleDownSimulateUpcomingNodes:   *scaleDownSimulateUpcoming,
+		ConsiderPreemptionInScaleUp:      *considerPreemption,
+		ScaleUpPrefilterEnabled:          *scaleUpPrefilterEnabled,
+		ForceNodeGroupAnnotationEnabled:  *forceNodeGroupAnnotation,
+		TemplateNodeInfoCacheTTL:         *templateNodeInfoCacheTTL,
+		IgnoredTaints:                    ignoreTaintFlag,
+		MaxNodesPerMinute:                *maxNodesPerMinute,
+		MaxNodesPerMinutePerNodeGroup:    *maxNodesPerMinutePerGroup,
+		RecordDecisionsDir:               *recordDecisionsDir,
+		RecordPackingTrace:               *recordPackingTrace,
+		DryRun:                           *dryRun,
+		ConfigFile:                       *configFile,
+		CommandLineFlags:                 commandLineFlags,
+		NewPodScaleUpDelay:               *newPodScaleUpDelay,
+		NewPodScaleUpDelayPerNamespace:   nsScaleUpDelayFlag,
+		AdaptivePodScaleUpDelay:          *adaptivePodScaleUpDelay,
+		ScaleUpOptOutNamespaceSelector:   *scaleUpOptOutNsSelector,
+		MaxUnschedulablePodsConsidered:   *maxUnschedulablePods,
+		OversizedPodsRecheckInterval:     *oversizedPodsRecheck,
+		FastPodFailureThreshold:          *fastPodFailureThreshold,
+		Version:                          ClusterAutoscalerVersion,
+		GitCommit:                        GitCommit,
+		CrashReporter:                    crashReporter,
+		CapacityForecaster:               capacityForecaster,
 	}
 
 	configFetcherOpts := dynamic.ConfigFetcherOptions{
@@ -234,7 +430,9 @@ func registerSignalHandlers(autoscaler core.Autoscaler) {
 	}()
 }
 
-func run(healthCheck *metrics.HealthCheck) {
+func run(healthCheck *metrics.HealthCheck, watchdog *metrics.LoopWatchdog) {
+	defer crashReporter.HandlePanic()
+
 	kubeClient := createKubeClient()
 	kubeEventRecorder := kube_util.CreateEventRecorder(kubeClient)
 	opts := createAutoscalerOptions()
@@ -248,30 +446,88 @@ func run(healthCheck *metrics.HealthCheck) {
 	listerRegistry := kube_util.NewListerRegistryWithDefaultListers(kubeClient, listerRegistryStopChannel)
 	autoscaler, err := core.NewAutoscaler(opts, predicateChecker, kubeClient, kubeEventRecorder, listerRegistry)
 	if err != nil {
-		glog.Fatalf("Failed to create autoscaler: %v", err)
+		crashReporter.Fatalf("Failed to create autoscaler: %v", err)
 	}
 	autoscaler.CleanUp()
 	registerSignalHandlers(autoscaler)
 	healthCheck.StartMonitoring()
 
+	minInterval, maxInterval := *scanInterval, *scanInterval
+	if *adaptiveScanInterval {
+		minInterval, maxInterval = *minScanInterval, *maxScanInterval
+	}
+	scanIntervals, err := core.NewAdaptiveScanInterval(*scanInterval, minInterval, maxInterval, *scanIntervalQuietLoops)
+	if err != nil {
+		glog.Fatalf("Invalid scan interval: %v", err)
+	}
+
+	registerForceRefreshHandler(scanIntervals)
+
+	interval := *scanInterval
 	for {
-		select {
-		case <-time.After(*scanInterval):
-			{
-				loopStart := time.Now()
-				metrics.UpdateLastTime(metrics.Main, loopStart)
-				healthCheck.UpdateLastActivity(loopStart)
-
-				err := autoscaler.RunOnce(loopStart)
-				if err != nil && err.Type() != errors.TransientError {
-					metrics.RegisterError(err)
-				} else {
-					healthCheck.UpdateLastSuccessfulRun(time.Now())
-				}
-
-				metrics.UpdateDurationFromStart(metrics.Main, loopStart)
-			}
+		scanIntervals.Wait(interval)
+
+		loopStart := time.Now()
+		metrics.UpdateLastTime(metrics.Main, loopStart)
+		healthCheck.UpdateLastActivity(loopStart)
+		watchdog.LoopStarted(loopStart)
+
+		err := autoscaler.RunOnce(loopStart)
+		if err != nil && err.Type() != errors.TransientError {
+			metrics.RegisterError(err)
+		} else {
+			healthCheck.UpdateLastSuccessfulRun(time.Now())
 		}
+		watchdog.LoopFinished(time.Now())
+
+		metrics.UpdateDurationFromStart(metrics.Main, loopStart)
+		interval = scanIntervals.Next(autoscaler.LastLoopActive())
+	}
+}
+
+// registerForceRefreshHandler starts the next loop right away on SIGUSR1, without waiting for the
+// rest of the scan interval.
+func registerForceRefreshHandler(scanIntervals *core.AdaptiveScanInterval) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	glog.V(1).Info("Registered force refresh signal handler")
+
+	go func() {
+		for range sigs {
+			glog.V(1).Info("Received force refresh signal")
+			scanIntervals.WakeUp()
+		}
+	}()
+}
+
+// runOnceAndReport runs a single dry-run loop and prints what CA would do to stdout.
+// It only reads from the API server: no events, status configmap or node taints are written.
+func runOnceAndReport() {
+	kubeClient := createKubeClient()
+	opts := createAutoscalerOptions()
+	opts.DryRun = true
+	opts.WriteStatusConfigMap = false
+	predicateChecker, err := simulator.NewPredicateChecker(kubeClient, make(chan struct{}))
+	if err != nil {
+		glog.Fatalf("Failed to create predicate checker: %v", err)
+	}
+	listerRegistry := kube_util.NewListerRegistryWithDefaultListers(kubeClient, make(chan struct{}))
+	autoscaler, autoscalerErr := core.NewStaticAutoscaler(opts.AutoscalingOptions, predicateChecker, kubeClient,
+		&kube_record.FakeRecorder{}, listerRegistry)
+	if autoscalerErr != nil {
+		glog.Fatalf("Failed to create autoscaler: %v", autoscalerErr)
+	}
+	// Give the listers time to sync, like the first iteration of the main loop does.
+	time.Sleep(*scanInterval)
+
+	report, autoscalerErr := autoscaler.RunOnceWithDryRunReport(time.Now())
+	if autoscalerErr != nil {
+		glog.Fatalf("Failed to run dry-run loop: %v", autoscalerErr)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		glog.Fatalf("Failed to write dry-run report: %v", err)
 	}
 }
 
@@ -282,9 +538,62 @@ func main() {
 	bindFlags(&leaderElection, pflag.CommandLine)
 	flag.Var(&nodeGroupsFlag, "nodes", "sets min,max size and other configuration data for a node group in a format accepted by cloud provider."+
 		"Can be used multiple times. Format: <min>:<max>:<other...>")
+	flag.Var(&headroomFlag, "headroom", "spare capacity kept in the cluster on top of what pods need, either as empty nodes of a node group "+
+		"or as replicas of a unit of resources on nodes of a node group or with given labels. Can be used multiple times. "+
+		"Format: nodes=<count>:nodeGroup=<id> or cpu=<quantity>,memory=<quantity>[,replicas=<count>]:{nodeGroup=<id>|labels=<key>=<value>[,<key>=<value>]}")
+	flag.Var(&nsScaleUpDelayFlag, "new-pod-scale-up-delay-per-namespace", "overrides --new-pod-scale-up-delay for pods in a namespace. "+
+		"Can be used multiple times. Format: <namespace>=<duration>")
+	flag.Var(&acceleratorFlag, "accelerator", "extended resource of accelerators, such as amd.com/gpu, treated like Nvidia GPUs: nodes with it are "+
+		"scaled down based on its utilization only. With a node label and detect-unready, labeled nodes without the resource allocatable "+
+		"are treated as unready. Can be used multiple times. Format: <resource name>[:<node label>[:detect-unready]]")
+	flag.Var(&ignoreTaintFlag, "ignore-taint", "key of a taint removed from template nodes built from existing nodes, in addition to transient taints "+
+		"such as node.kubernetes.io/not-ready. Use it for taints that existing nodes get after they start. Can be used multiple times.")
 	kube_flag.InitFlags()
 
+	pflag.CommandLine.Visit(func(f *pflag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+	optionProvenance := make(config.OptionProvenance)
+	optionProvenance.RecordChanged(pflag.CommandLine, config.CommandLineSource)
+	if *configFile != "" {
+		fileConfig, err := config.LoadFileConfig(*configFile)
+		if err != nil {
+			glog.Fatalf("Failed to load configuration file: %v", err)
+		}
+		if err := fileConfig.ApplyToFlags(pflag.CommandLine); err != nil {
+			glog.Fatalf("Invalid configuration file %s: %v", *configFile, err)
+		}
+		optionProvenance.RecordChanged(pflag.CommandLine, config.FileSource)
+	}
+	if *profile != "" {
+		if err := config.ApplyProfile(*profile, pflag.CommandLine, optionProvenance); err != nil {
+			glog.Fatalf("Failed to apply profile: %v", err)
+		}
+	}
+	resolvedOptions := optionProvenance.Resolve(pflag.CommandLine)
+	for _, option := range resolvedOptions {
+		if option.Source != config.DefaultSource {
+			glog.V(1).Infof("Option --%s=%s (%s)", option.Name, option.Value, option.Source)
+		}
+	}
+
+	var err error
+	crashReporter, err = debug.NewCrashReporter(*crashDumpDestination, *crashDumpMaxSize)
+	if err != nil {
+		glog.Fatalf("Failed to set up crash dumps: %v", err)
+	}
+
+	if *capacityForecastEnabled {
+		capacityForecaster = core.NewCapacityForecaster(*capacityForecastTimeout)
+	}
+
+	if *runOnce {
+		runOnceAndReport()
+		return
+	}
+
 	healthCheck := metrics.NewHealthCheck(*maxInactivityTimeFlag, *maxFailingTimeFlag)
+	watchdog := metrics.NewLoopWatchdog(time.Duration(*maxLoopDurationScanIntervals)*(*scanInterval), *killOnStuckLoop)
 
 	glog.V(1).Infof("Cluster Autoscaler %s", ClusterAutoscalerVersion)
 
@@ -301,12 +610,23 @@ func main() {
 	go func() {
 		http.Handle("/metrics", prometheus.Handler())
 		http.Handle("/health-check", healthCheck)
+		http.Handle("/healthz", healthCheck)
+		http.Handle("/readyz", watchdog)
+		http.HandleFunc("/debug/options", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resolvedOptions)
+		})
+		if capacityForecaster != nil {
+			http.Handle("/simulate", capacityForecaster)
+		}
 		err := http.ListenAndServe(*address, nil)
 		glog.Fatalf("Failed to start metrics: %v", err)
 	}()
 
+	go watchdog.Run(*scanInterval, make(chan struct{}))
+
 	if !leaderElection.LeaderElect {
-		run(healthCheck)
+		run(healthCheck, watchdog)
 	} else {
 		id, err := os.Hostname()
 		if err != nil {
@@ -344,7 +664,7 @@ func main() {
 				OnStartedLeading: func(_ <-chan struct{}) {
 					// Since we are committing a suicide after losing
 					// mastership, we can safely ignore the argument.
-					run(healthCheck)
+					run(healthCheck, watchdog)
 				},
 				OnStoppedLeading: func() {
 					glog.Fatalf("lost master")
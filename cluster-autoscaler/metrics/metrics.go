@@ -38,6 +38,15 @@ type FunctionLabel string
 // NodeGroupType describes node group relation to CA
 type NodeGroupType string
 
+// PredicateCheck describes a check of pods against node group templates in scale-up
+type PredicateCheck string
+
+// DryRunAction describes an action CA would have taken if it wasn't running in dry-run mode
+type DryRunAction string
+
+// DriftKind describes how the cloud provider view of a node group differs from the cluster
+type DriftKind string
+
 const (
 	caNamespace   = "cluster_autoscaler"
 	readyLabel    = "ready"
@@ -50,11 +59,19 @@ const (
 	Empty NodeScaleDownReason = "empty"
 	// Unready node was removed
 	Unready NodeScaleDownReason = "unready"
+	// AboveMaxSize node was removed because its node group was above max size
+	AboveMaxSize NodeScaleDownReason = "above_max_size"
+	// Orphan node outside of node groups was removed because it was empty
+	Orphan NodeScaleDownReason = "orphan"
 
 	// APIError caused scale-up to fail
 	APIError FailedScaleUpReason = "apiCallError"
 	// Timeout was encountered when trying to scale-up
 	Timeout FailedScaleUpReason = "timeout"
+	// PartialFulfillment means the cloud provider created only some of the requested nodes
+	PartialFulfillment FailedScaleUpReason = "partialFulfillment"
+	// OutOfResources means the cloud provider had no capacity for the machines of the node group
+	OutOfResources FailedScaleUpReason = "outOfResources"
 
 	// autoscaledGroup is managed by CA
 	autoscaledGroup NodeGroupType = "autoscaled"
@@ -62,6 +79,39 @@ const (
 	// is currently autoscaled and can be removed by CA if it's no longer needed
 	autoprovisionedGroup NodeGroupType = "autoprovisioned"
 
+	// DryRunScaleUp is a node group size increase
+	DryRunScaleUp DryRunAction = "scaleUp"
+	// DryRunScaleDown is a removal of a node that requires pods to be moved
+	DryRunScaleDown DryRunAction = "scaleDown"
+	// DryRunScaleDownEmpty is a removal of an empty node
+	DryRunScaleDownEmpty DryRunAction = "scaleDownEmpty"
+	// DryRunRemoveUnregistered is a removal of a node that failed to register in Kubernetes
+	DryRunRemoveUnregistered DryRunAction = "removeUnregistered"
+	// DryRunRemoveDeletedInstance is a removal of a node whose instance no longer exists
+	DryRunRemoveDeletedInstance DryRunAction = "removeDeletedInstance"
+	// DryRunRemoveOrphan is a removal of an empty node that doesn't belong to any node group
+	DryRunRemoveOrphan DryRunAction = "removeOrphan"
+	// DryRunFixNodeGroupSize is a decrease of node group target size to match registered nodes
+	DryRunFixNodeGroupSize DryRunAction = "fixNodeGroupSize"
+	// DryRunCreateNodeGroup is a creation of an autoprovisioned node group
+	DryRunCreateNodeGroup DryRunAction = "createNodeGroup"
+	// DryRunCompaction is an eviction of pods from a node to make another node removable
+	DryRunCompaction DryRunAction = "compaction"
+	// DryRunReconcileTargetSize is a decrease of a target size not matching the instances of a node group
+	DryRunReconcileTargetSize DryRunAction = "reconcileTargetSize"
+
+	// MissingNodeDrift is an instance that didn't register as a node
+	MissingNodeDrift DriftKind = "missing_node"
+	// ExtraInstanceDrift is an instance above the target size of its node group
+	ExtraInstanceDrift DriftKind = "extra_instance"
+	// TargetMismatchDrift is an instance missing to the target size of its node group
+	TargetMismatchDrift DriftKind = "target_mismatch"
+
+	// PrefilterCheck is a check of node selectors, node affinity and taints only
+	PrefilterCheck PredicateCheck = "prefilter"
+	// FullPredicateCheck is a check of all scheduler predicates
+	FullPredicateCheck PredicateCheck = "full"
+
 	// LogLongDurationThreshold defines the duration after which long function
 	// duration will be logged (in addition to being counted in metric).
 	// This is meant to help find unexpectedly long function execution times for
@@ -119,6 +169,30 @@ var (
 		},
 	)
 
+	podsBlockedByLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "pods_blocked_by_limit",
+			Help:      "Number of unschedulable pods CA would add nodes for if not for a cluster-wide limit, by the limit.",
+		}, []string{"limit"},
+	)
+
+	podsOptedOutOfScaleUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "pods_opted_out_of_scale_up",
+			Help:      "Number of unschedulable pods not triggering a scale-up because their namespace opted out.",
+		},
+	)
+
+	deferredUnschedulablePodsCount = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "deferred_unschedulable_pods_count",
+			Help:      "Number of unschedulable pods deferred to next loops because of the limit of pods considered in a loop.",
+		},
+	)
+
 	/**** Metrics related to autoscaler execution ****/
 	lastActivity = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -128,6 +202,22 @@ var (
 		}, []string{"activity"},
 	)
 
+	mainLoopRunningSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "main_loop_running_seconds",
+			Help:      "Time the current iteration of CA main loop has been running for, 0 if no iteration is in progress.",
+		},
+	)
+
+	scanIntervalSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "scan_interval_seconds",
+			Help:      "Time CA waits between the end of an iteration of the main loop and the start of the next one.",
+		},
+	)
+
 	functionDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: caNamespace,
@@ -186,7 +276,151 @@ var (
 		},
 	)
 
+	scaleDownBlockedNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "scale_down_blocked_nodes",
+			Help:      "Number of underutilized nodes CA would remove if not for their pods, by the blocking reason.",
+		}, []string{"reason"},
+	)
+
+	scaleDownBlockedNodesHourlyPrice = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "scale_down_blocked_nodes_hourly_price",
+			Help:      "Total hourly price of underutilized nodes CA would remove if not for their pods.",
+		},
+	)
+
+	estimatedSavings = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "estimated_savings_usd_per_hour_total",
+			Help:      "Sum of hourly prices of nodes removed by scale-down, by node group. Only counted if the cloud provider has a pricing model.",
+		}, []string{"node_group"},
+	)
+
+	scaleDownIneligibleNodesCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "scale_down_ineligible_nodes_total",
+			Help:      "Number of times nodes were excluded from scale-down considerations, by the first rule rejecting them.",
+		}, []string{"rule"},
+	)
+
+	dryRunActionsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "dryrun_actions_total",
+			Help:      "Number of actions CA would have taken if it wasn't running in dry-run mode.",
+		}, []string{"action", "node_group"},
+	)
+
+	templateNodeInfoCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "template_node_info_cache_requests_total",
+			Help:      "Number of requests for node group template node infos, by whether they were served from cache.",
+		}, []string{"result"},
+	)
+
+	nodeGroupProvisionTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "node_group_provision_time_seconds",
+			Help:      "Duration of recent successful scale-ups of a node group, from the request until the new nodes were ready, by quantile.",
+		}, []string{"node_group", "quantile"},
+	)
+
+	nodeGroupDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "node_group_drift",
+			Help:      "Difference between the cloud provider view of a node group and the cluster, by kind of drift.",
+		}, []string{"node_group", "kind"},
+	)
+
+	networkLimitedScaleUpCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "network_limited_scale_ups_total",
+			Help:      "Number of scale-ups of a node group truncated because the network had no addresses left for more nodes.",
+		}, []string{"node_group"},
+	)
+
+	scaleUpPredicateRejectionsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "scale_up_predicate_rejections_total",
+			Help:      "Number of pods found not to fit a node group template in scale-up, by the check that rejected them.",
+		}, []string{"check"},
+	)
+
+	scaleUpPrefilterDivergencesCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "scale_up_prefilter_divergences_total",
+			Help:      "Number of sampled pods rejected by the scale-up pre-filter that passed all predicates. Anything but 0 is a bug in the pre-filter.",
+		},
+	)
+
+	estimateShortfallsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "scale_up_estimate_shortfalls_total",
+			Help:      "Number of finished scale-ups after which some of the pods they were made for still didn't fit on any node.",
+		},
+	)
+
+	cloudProviderThrottledRequestsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "cloud_provider_throttled_requests_total",
+			Help:      "Number of cloud provider API requests rejected because of the request rate.",
+		}, []string{"cloud_provider", "api"},
+	)
+
+	stockoutsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "stockouts_total",
+			Help:      "Number of scale-ups that failed because the cloud provider had no capacity for the instance type in the zone.",
+		}, []string{"instance_type", "zone"},
+	)
+
+	compactionsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: caNamespace,
+			Name:      "compactions_total",
+			Help:      "Number of times CA evicted pods from a node kept by its other pods to make another node removable.",
+		},
+	)
+
 	/**** Metrics related to NodeAutoprovisioning ****/
+	configFileHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "config_file_hash",
+			Help:      "Hash of the configuration file in use, as a label. The value is always 1.",
+		}, []string{"hash"},
+	)
+
+	activeTimeProfile = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "active_time_profile",
+			Help:      "Time profile of the configuration file in effect, as a label. The value is always 1, there's no series if no profile is active.",
+		}, []string{"profile"},
+	)
+
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: caNamespace,
+			Name:      "build_info",
+			Help:      "CA version, commit, cloud provider and hash of the effective autoscaling options, as labels. The value is always 1.",
+		}, []string{"version", "git_commit", "cloud_provider", "options_hash"},
+	)
+
 	napEnabled = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace: caNamespace,
@@ -217,7 +451,12 @@ func init() {
 	prometheus.MustRegister(nodesCount)
 	prometheus.MustRegister(nodeGroupsCount)
 	prometheus.MustRegister(unschedulablePodsCount)
+	prometheus.MustRegister(podsBlockedByLimit)
+	prometheus.MustRegister(podsOptedOutOfScaleUp)
+	prometheus.MustRegister(deferredUnschedulablePodsCount)
 	prometheus.MustRegister(lastActivity)
+	prometheus.MustRegister(mainLoopRunningSeconds)
+	prometheus.MustRegister(scanIntervalSeconds)
 	prometheus.MustRegister(functionDuration)
 	prometheus.MustRegister(errorsCount)
 	prometheus.MustRegister(scaleUpCount)
@@ -225,6 +464,24 @@ func init() {
 	prometheus.MustRegister(scaleDownCount)
 	prometheus.MustRegister(evictionsCount)
 	prometheus.MustRegister(unneededNodesCount)
+	prometheus.MustRegister(scaleDownBlockedNodes)
+	prometheus.MustRegister(scaleDownBlockedNodesHourlyPrice)
+	prometheus.MustRegister(estimatedSavings)
+	prometheus.MustRegister(scaleDownIneligibleNodesCount)
+	prometheus.MustRegister(dryRunActionsCount)
+	prometheus.MustRegister(templateNodeInfoCacheRequests)
+	prometheus.MustRegister(nodeGroupProvisionTime)
+	prometheus.MustRegister(nodeGroupDrift)
+	prometheus.MustRegister(networkLimitedScaleUpCount)
+	prometheus.MustRegister(scaleUpPredicateRejectionsCount)
+	prometheus.MustRegister(scaleUpPrefilterDivergencesCount)
+	prometheus.MustRegister(estimateShortfallsCount)
+	prometheus.MustRegister(cloudProviderThrottledRequestsCount)
+	prometheus.MustRegister(stockoutsCount)
+	prometheus.MustRegister(compactionsCount)
+	prometheus.MustRegister(configFileHash)
+	prometheus.MustRegister(activeTimeProfile)
+	prometheus.MustRegister(buildInfo)
 	prometheus.MustRegister(napEnabled)
 	prometheus.MustRegister(nodeGroupCreationCount)
 	prometheus.MustRegister(nodeGroupDeletionCount)
@@ -252,6 +509,16 @@ func UpdateLastTime(label FunctionLabel, now time.Time) {
 	lastActivity.WithLabelValues(string(label)).Set(float64(now.Unix()))
 }
 
+// UpdateMainLoopRunningTime records for how long the current iteration of the main loop has been running
+func UpdateMainLoopRunningTime(duration time.Duration) {
+	mainLoopRunningSeconds.Set(duration.Seconds())
+}
+
+// UpdateScanInterval records the time CA waits before the next iteration of the main loop
+func UpdateScanInterval(interval time.Duration) {
+	scanIntervalSeconds.Set(interval.Seconds())
+}
+
 // UpdateClusterSafeToAutoscale records if cluster is safe to autoscale
 func UpdateClusterSafeToAutoscale(safe bool) {
 	if safe {
@@ -279,6 +546,21 @@ func UpdateUnschedulablePodsCount(podsCount int) {
 	unschedulablePodsCount.Set(float64(podsCount))
 }
 
+// UpdatePodsBlockedByLimit records number of unschedulable pods blocked from triggering a scale-up by a cluster-wide limit
+func UpdatePodsBlockedByLimit(limit string, podsCount int) {
+	podsBlockedByLimit.WithLabelValues(limit).Set(float64(podsCount))
+}
+
+// UpdatePodsOptedOutOfScaleUp records number of unschedulable pods not triggering a scale-up because their namespace opted out
+func UpdatePodsOptedOutOfScaleUp(podsCount int) {
+	podsOptedOutOfScaleUp.Set(float64(podsCount))
+}
+
+// UpdateDeferredUnschedulablePodsCount records number of unschedulable pods deferred to next loops
+func UpdateDeferredUnschedulablePodsCount(podsCount int) {
+	deferredUnschedulablePodsCount.Set(float64(podsCount))
+}
+
 // RegisterError records any errors preventing Cluster Autoscaler from working.
 // No more than one error should be recorded per loop.
 func RegisterError(err errors.AutoscalerError) {
@@ -310,6 +592,129 @@ func UpdateUnneededNodesCount(nodesCount int) {
 	unneededNodesCount.Set(float64(nodesCount))
 }
 
+// UpdateScaleDownBlockedNodes records numbers of nodes blocked from scale-down by their pods, by reason
+func UpdateScaleDownBlockedNodes(nodesCountByReason map[string]int) {
+	scaleDownBlockedNodes.Reset()
+	for reason, nodesCount := range nodesCountByReason {
+		scaleDownBlockedNodes.WithLabelValues(reason).Set(float64(nodesCount))
+	}
+}
+
+// UpdateScaleDownBlockedNodesHourlyPrice records total hourly price of nodes blocked from scale-down by their pods
+func UpdateScaleDownBlockedNodesHourlyPrice(price float64) {
+	scaleDownBlockedNodesHourlyPrice.Set(price)
+}
+
+// RegisterEstimatedSavings records the hourly price of a node removed by scale-down
+func RegisterEstimatedSavings(nodeGroup string, hourlyPrice float64) {
+	estimatedSavings.WithLabelValues(nodeGroup).Add(hourlyPrice)
+}
+
+// RegisterScaleDownIneligibleNodes records numbers of nodes excluded from scale-down considerations, by the rule rejecting them
+func RegisterScaleDownIneligibleNodes(nodesCountByRule map[string]int) {
+	for rule, nodesCount := range nodesCountByRule {
+		scaleDownIneligibleNodesCount.WithLabelValues(rule).Add(float64(nodesCount))
+	}
+}
+
+// RegisterTemplateNodeInfoCacheRequest records a request for a template node info and whether it was a cache hit
+func RegisterTemplateNodeInfoCacheRequest(hit bool) {
+	if hit {
+		templateNodeInfoCacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		templateNodeInfoCacheRequests.WithLabelValues("miss").Inc()
+	}
+}
+
+// UpdateNodeGroupProvisionTime records the median and 95th percentile of recent provision times of a node group
+func UpdateNodeGroupProvisionTime(nodeGroup string, p50, p95 time.Duration) {
+	nodeGroupProvisionTime.WithLabelValues(nodeGroup, "0.5").Set(p50.Seconds())
+	nodeGroupProvisionTime.WithLabelValues(nodeGroup, "0.95").Set(p95.Seconds())
+}
+
+// UpdateNodeGroupDrift records the numbers of instances without nodes, instances above the target size
+// and instances missing to the target size of a node group
+func UpdateNodeGroupDrift(nodeGroup string, missingNodes, extraInstances, targetMismatch int) {
+	nodeGroupDrift.WithLabelValues(nodeGroup, string(MissingNodeDrift)).Set(float64(missingNodes))
+	nodeGroupDrift.WithLabelValues(nodeGroup, string(ExtraInstanceDrift)).Set(float64(extraInstances))
+	nodeGroupDrift.WithLabelValues(nodeGroup, string(TargetMismatchDrift)).Set(float64(targetMismatch))
+}
+
+// RegisterNetworkLimitedScaleUp records a scale-up of a node group truncated by network capacity
+func RegisterNetworkLimitedScaleUp(nodeGroup string) {
+	networkLimitedScaleUpCount.WithLabelValues(nodeGroup).Inc()
+}
+
+// RegisterScaleUpPredicateRejection records a pod found not to fit a node group template by the check
+func RegisterScaleUpPredicateRejection(check PredicateCheck) {
+	scaleUpPredicateRejectionsCount.WithLabelValues(string(check)).Inc()
+}
+
+// RegisterScaleUpPrefilterDivergence records a pod rejected by the scale-up pre-filter that passed all predicates
+func RegisterScaleUpPrefilterDivergence() {
+	scaleUpPrefilterDivergencesCount.Inc()
+}
+
+// RegisterEstimateShortfall records a finished scale-up that added fewer nodes than its pods needed
+func RegisterEstimateShortfall() {
+	estimateShortfallsCount.Inc()
+}
+
+// RegisterCloudProviderThrottledRequest records a cloud provider API request rejected because of the request rate
+func RegisterCloudProviderThrottledRequest(cloudProvider, api string) {
+	cloudProviderThrottledRequestsCount.WithLabelValues(cloudProvider, api).Inc()
+}
+
+// RegisterStockout records a scale-up that failed because of no capacity for the instance type in the zone
+func RegisterStockout(instanceType, zone string) {
+	stockoutsCount.WithLabelValues(instanceType, zone).Inc()
+}
+
+// RegisterCompaction records a compaction of a node. Evicted pods are registered with RegisterEvictions.
+func RegisterCompaction() {
+	compactionsCount.Inc()
+}
+
+// RegisterDryRunAction records an action that was skipped because CA is running in dry-run mode
+func RegisterDryRunAction(action DryRunAction, nodeGroup string) {
+	dryRunActionsCount.WithLabelValues(string(action), nodeGroup).Inc()
+}
+
+// UnregisterNodeGroup removes metrics labeled with a node group that no longer exists
+func UnregisterNodeGroup(nodeGroup string) {
+	nodeGroupProvisionTime.DeleteLabelValues(nodeGroup, "0.5")
+	nodeGroupProvisionTime.DeleteLabelValues(nodeGroup, "0.95")
+	networkLimitedScaleUpCount.DeleteLabelValues(nodeGroup)
+	estimatedSavings.DeleteLabelValues(nodeGroup)
+	for _, kind := range []DriftKind{MissingNodeDrift, ExtraInstanceDrift, TargetMismatchDrift} {
+		nodeGroupDrift.DeleteLabelValues(nodeGroup, string(kind))
+	}
+	for _, action := range []DryRunAction{DryRunScaleUp, DryRunScaleDown, DryRunScaleDownEmpty, DryRunRemoveUnregistered,
+		DryRunRemoveDeletedInstance, DryRunFixNodeGroupSize, DryRunCreateNodeGroup, DryRunCompaction, DryRunReconcileTargetSize} {
+		dryRunActionsCount.DeleteLabelValues(string(action), nodeGroup)
+	}
+}
+
+// UpdateConfigFileHash records the hash of the configuration file in use
+func UpdateConfigFileHash(hash string) {
+	configFileHash.Reset()
+	configFileHash.WithLabelValues(hash).Set(1)
+}
+
+// UpdateActiveTimeProfile records the time profile in effect, empty if there's none
+func UpdateActiveTimeProfile(profile string) {
+	activeTimeProfile.Reset()
+	if profile != "" {
+		activeTimeProfile.WithLabelValues(profile).Set(1)
+	}
+}
+
+// UpdateBuildInfo records the CA build and the hash of the options in use
+func UpdateBuildInfo(version, gitCommit, cloudProvider, optionsHash string) {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(version, gitCommit, cloudProvider, optionsHash).Set(1)
+}
+
 // UpdateNapEnabled records if NodeAutoprovisioning is enabled
 func UpdateNapEnabled(enabled bool) {
 	if enabled {
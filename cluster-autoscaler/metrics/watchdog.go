@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// LoopWatchdog tracks the last started and the last completed iteration of the main loop.
+// An iteration that runs for longer than stuckTimeout makes the autoscaler not ready and,
+// if killTimeout is set, an iteration running for longer than killTimeout terminates the process.
+type LoopWatchdog struct {
+	mutex        sync.Mutex
+	lastStart    time.Time
+	lastFinish   time.Time
+	stuckTimeout time.Duration
+	killTimeout  time.Duration
+	exit         func(int)
+}
+
+// NewLoopWatchdog builds new LoopWatchdog. killTimeout equal to 0 means the process is never
+// terminated by the watchdog.
+func NewLoopWatchdog(stuckTimeout, killTimeout time.Duration) *LoopWatchdog {
+	return &LoopWatchdog{
+		stuckTimeout: stuckTimeout,
+		killTimeout:  killTimeout,
+		exit:         os.Exit,
+	}
+}
+
+// LoopStarted records the start of a main loop iteration.
+func (w *LoopWatchdog) LoopStarted(now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.lastStart = now
+}
+
+// LoopFinished records the completion of a main loop iteration, successful or not.
+func (w *LoopWatchdog) LoopFinished(now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.lastFinish = now
+}
+
+// runningFor returns for how long the current iteration has been running, 0 if there's none in progress.
+func (w *LoopWatchdog) runningFor(now time.Time) time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.lastStart.IsZero() || !w.lastStart.After(w.lastFinish) {
+		return 0
+	}
+	return now.Sub(w.lastStart)
+}
+
+// Check updates the loop liveness metric and terminates the process with exit code 2 if the
+// current iteration has been running for longer than killTimeout.
+func (w *LoopWatchdog) Check(now time.Time) {
+	running := w.runningFor(now)
+	UpdateMainLoopRunningTime(running)
+	if w.killTimeout > 0 && running > w.killTimeout {
+		glog.Errorf("Main loop stuck for %v, exiting", running)
+		w.exit(2)
+	}
+}
+
+// Run checks the main loop every checkInterval until stopCh is closed.
+func (w *LoopWatchdog) Run(checkInterval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Check(time.Now())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler interface to provide a readiness endpoint
+func (w *LoopWatchdog) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	running := w.runningFor(time.Now())
+	if running > w.stuckTimeout {
+		rw.WriteHeader(500)
+		rw.Write([]byte(fmt.Sprintf("Error: main loop running for %v, more than %v", running, w.stuckTimeout)))
+	} else {
+		rw.WriteHeader(200)
+		rw.Write([]byte("OK"))
+	}
+}
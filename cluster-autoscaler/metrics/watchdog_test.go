@@ -0,0 +1,89 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getReadyzCode(watchdog *LoopWatchdog) int {
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	watchdog.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestLoopWatchdogStuckOnCloudProvider(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	provider := testprovider.NewTestCloudProvider(func(nodeGroup string, increase int) error {
+		close(entered)
+		<-unblock
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+
+	watchdog := NewLoopWatchdog(50*time.Millisecond, time.Minute)
+	exitCodes := make([]int, 0)
+	watchdog.exit = func(code int) {
+		exitCodes = append(exitCodes, code)
+	}
+	assert.Equal(t, 200, getReadyzCode(watchdog))
+
+	loopStart := time.Now()
+	finished := make(chan struct{})
+	go func() {
+		watchdog.LoopStarted(loopStart)
+		provider.NodeGroups()[0].IncreaseSize(1)
+		watchdog.LoopFinished(time.Now())
+		close(finished)
+	}()
+	<-entered
+	time.Sleep(100 * time.Millisecond)
+
+	// Loop is stuck, but not for long enough to be killed.
+	assert.Equal(t, 500, getReadyzCode(watchdog))
+	watchdog.Check(time.Now())
+	assert.Equal(t, 0, len(exitCodes))
+	watchdog.Check(loopStart.Add(2 * time.Minute))
+	assert.Equal(t, []int{2}, exitCodes)
+
+	close(unblock)
+	<-finished
+	assert.Equal(t, 200, getReadyzCode(watchdog))
+	watchdog.Check(time.Now())
+	assert.Equal(t, 1, len(exitCodes))
+}
+
+func TestLoopWatchdogKillDisabled(t *testing.T) {
+	watchdog := NewLoopWatchdog(time.Second, 0)
+	exitCodes := make([]int, 0)
+	watchdog.exit = func(code int) {
+		exitCodes = append(exitCodes, code)
+	}
+	now := time.Now()
+	watchdog.LoopStarted(now.Add(-time.Hour))
+	assert.Equal(t, 500, getReadyzCode(watchdog))
+	watchdog.Check(now)
+	assert.Equal(t, 0, len(exitCodes))
+}
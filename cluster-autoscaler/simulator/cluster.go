@@ -21,12 +21,16 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
+	"strings"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
 	client "k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
@@ -44,6 +48,38 @@ var (
 
 	minReplicaCount = flag.Int("min-replica-count", 0,
 		"Minimum number or replicas that a replica set or replication controller should have to allow their pods deletion in scale down")
+
+	pressureNodeConditions = flag.String("pressure-node-conditions", "MemoryPressure,DiskPressure,PIDPressure",
+		"Comma-separated list of node condition types which, when true or when the node has the matching "+
+			"node.kubernetes.io/*-pressure taint, exclude the node from destinations of pods moved in scale down simulation "+
+			"and from existing capacity checked before scale up. Empty list disables the check")
+
+	deferEvictionDuringInitAfter = flag.Duration("defer-eviction-during-init-after", 0,
+		"Nodes with pods running init containers for longer than this are not removed until the init containers finish. "+
+			"0 means only pods with the defer-eviction-during-init annotation are waited for")
+
+	doNotDisruptMaxHorizon = flag.Duration("do-not-disrupt-max-horizon", 24*time.Hour,
+		"Maximum time after creation of a pod its do-not-disrupt-until annotation can keep the node from being removed. "+
+			"Later times are capped with a warning, so that a pod can't block scale down forever. 0 means no cap")
+
+	drainabilityAuditNode = flag.String("drainability-audit-node", "",
+		"Name of a node for which the drainability rule blocking each of its pods is logged whenever the node is "+
+			"simulated for removal, even if the node is removable")
+
+	ignorePodsForEmptyNodes = flag.String("ignore-pods-for-empty-nodes", "",
+		"Comma-separated list of ns=<namespace> and label=<label selector> entries. Pods matching any of them, such as "+
+			"per-node agents run by Deployments, don't keep a node from being empty and aren't moved in scale down "+
+			"simulation, but are still evicted when the node is removed")
+)
+
+const (
+	// NoRebalanceTargetAnnotationKey is the name of annotation marking node as not eligible to receive
+	// pods from nodes removed in scale-down simulation.
+	NoRebalanceTargetAnnotationKey = "cluster-autoscaler.kubernetes.io/no-rebalance-target"
+
+	// maxPodGroupPlacementPasses bounds the number of passes over pods from a removed node
+	// done while looking for place for pods whose affinity depends on other pods being moved.
+	maxPodGroupPlacementPasses = 5
 )
 
 // NodeToBeRemoved contain information about a node that can be removed.
@@ -52,35 +88,71 @@ type NodeToBeRemoved struct {
 	Node *apiv1.Node
 	// PodsToReschedule contains pods on the node that should be rescheduled elsewhere.
 	PodsToReschedule []*apiv1.Pod
+	// IgnoredPods contains pods on the node matching --ignore-pods-for-empty-nodes. They are not
+	// rescheduled in the simulation, but are evicted when the node is drained.
+	IgnoredPods []*apiv1.Pod
+}
+
+// UnremovableReason is the primary reason why a node cannot be removed.
+type UnremovableReason string
+
+const (
+	// NoPlaceToMovePods means some of the pods don't fit anywhere else in the cluster.
+	NoPlaceToMovePods UnremovableReason = "no_place_to_move_pods"
+	// NodeInfoNotFound means the node was not found in the snapshot used for simulation.
+	NodeInfoNotFound UnremovableReason = "node_info_not_found"
+)
+
+// UnremovableNode is a node that cannot be removed, together with the reason. Reasons of nodes
+// blocked by one of their pods are drain.BlockingPodReason values.
+type UnremovableNode struct {
+	Node   *apiv1.Node
+	Reason UnremovableReason
+	// BlockingPods are all pods of the node blocking its drain. It's set only if BlockedByPod is true.
+	BlockingPods []drain.BlockingPod
+}
+
+// BlockedByPod returns true if the node could be removed if not for one of its pods, e.g. with
+// local storage or protected by a PDB.
+func (n *UnremovableNode) BlockedByPod() bool {
+	return n.Reason != NoPlaceToMovePods && n.Reason != NodeInfoNotFound
 }
 
 // FindNodesToRemove finds nodes that can be removed. Returns also an information about good
-// rescheduling location for each of the pods.
+// rescheduling location for each of the pods. Pods are moved to the nodes preferred by
+// scoringStrategy, as the scheduler would do.
 func FindNodesToRemove(candidates []*apiv1.Node, allNodes []*apiv1.Node, pods []*apiv1.Pod,
 	client client.Interface, predicateChecker *PredicateChecker, maxCount int,
 	fastCheck bool, oldHints map[string]string, usageTracker *UsageTracker,
 	timestamp time.Time,
-	podDisruptionBudgets []*policyv1.PodDisruptionBudget,
-) (nodesToRemove []NodeToBeRemoved, unremovableNodes []*apiv1.Node, podReschedulingHints map[string]string, finalError errors.AutoscalerError) {
+	podDisruptionBudgets []*policyv1.PodDisruptionBudget, scoringStrategy ScoringStrategy,
+) (nodesToRemove []NodeToBeRemoved, unremovableNodes []*UnremovableNode, podReschedulingHints map[string]string, finalError errors.AutoscalerError) {
 
 	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(pods, allNodes)
 	result := make([]NodeToBeRemoved, 0)
-	unremovable := make([]*apiv1.Node, 0)
+	unremovable := make([]*UnremovableNode, 0)
 
 	evaluationType := "Detailed evaluation"
 	if fastCheck {
 		evaluationType = "Fast evaluation"
 	}
 	newHints := make(map[string]string, len(oldHints))
+	if pressured := CountNodesUnderPressure(allNodes); pressured > 0 {
+		glog.V(2).Infof("%s: %d nodes under pressure excluded from destinations", evaluationType, pressured)
+	}
 
 candidateloop:
 	for _, node := range candidates {
 		glog.V(2).Infof("%s: %s for removal", evaluationType, node.Name)
 
-		var podsToRemove []*apiv1.Pod
+		var podsToRemove, ignoredPods []*apiv1.Pod
 		var err error
 
 		if nodeInfo, found := nodeNameToNodeInfo[node.Name]; found {
+			nodeInfo, ignoredPods = withoutIgnoredPods(nodeInfo)
+			if node.Name == *drainabilityAuditNode {
+				logDrainabilityAudit(nodeInfo, fastCheck, client, podDisruptionBudgets)
+			}
 			if fastCheck {
 				podsToRemove, err = FastGetPodsToMove(nodeInfo, *skipNodesWithSystemPods, *skipNodesWithLocalStorage,
 					podDisruptionBudgets)
@@ -90,21 +162,29 @@ candidateloop:
 			}
 			if err != nil {
 				glog.V(2).Infof("%s: node %s cannot be removed: %v", evaluationType, node.Name, err)
-				unremovable = append(unremovable, node)
+				blockingPods := AuditPodsToMove(nodeInfo, *skipNodesWithSystemPods, *skipNodesWithLocalStorage, !fastCheck, client,
+					int32(*minReplicaCount), podDisruptionBudgets)
+				glog.V(3).Infof("%s: pods blocking removal of %s: %s", evaluationType, node.Name, drain.SummarizeBlockingPods(blockingPods))
+				unremovable = append(unremovable, &UnremovableNode{
+					Node:         node,
+					Reason:       UnremovableReason(drain.BlockingReason(err)),
+					BlockingPods: blockingPods,
+				})
 				continue candidateloop
 			}
 		} else {
 			glog.V(2).Infof("%s: nodeInfo for %s not found", evaluationType, node.Name)
-			unremovable = append(unremovable, node)
+			unremovable = append(unremovable, &UnremovableNode{Node: node, Reason: NodeInfoNotFound})
 			continue candidateloop
 		}
 		findProblems := findPlaceFor(node.Name, podsToRemove, allNodes, nodeNameToNodeInfo, predicateChecker, oldHints, newHints,
-			usageTracker, timestamp)
+			usageTracker, timestamp, scoringStrategy)
 
 		if findProblems == nil {
 			result = append(result, NodeToBeRemoved{
 				Node:             node,
 				PodsToReschedule: podsToRemove,
+				IgnoredPods:      ignoredPods,
 			})
 			glog.V(2).Infof("%s: node %s may be removed", evaluationType, node.Name)
 			if len(result) >= maxCount {
@@ -112,18 +192,41 @@ candidateloop:
 			}
 		} else {
 			glog.V(2).Infof("%s: node %s is not suitable for removal: %v", evaluationType, node.Name, findProblems)
-			unremovable = append(unremovable, node)
+			unremovable = append(unremovable, &UnremovableNode{Node: node, Reason: NoPlaceToMovePods})
 		}
 	}
 	return result, unremovable, newHints, nil
 }
 
+// logDrainabilityAudit logs, for every pod of the node, the drainability rule blocking it, as
+// requested with --drainability-audit-node.
+func logDrainabilityAudit(nodeInfo *schedulercache.NodeInfo, fastCheck bool, client client.Interface,
+	pdbs []*policyv1.PodDisruptionBudget) {
+	nodeName := nodeInfo.Node().Name
+	blockingPods := AuditPodsToMove(nodeInfo, *skipNodesWithSystemPods, *skipNodesWithLocalStorage, !fastCheck, client,
+		int32(*minReplicaCount), pdbs)
+	blockingRules := make(map[*apiv1.Pod]drain.BlockingPod, len(blockingPods))
+	for _, blockingPod := range blockingPods {
+		blockingRules[blockingPod.Pod] = blockingPod
+	}
+	glog.Infof("Drainability audit of %s: %d of %d pods block removal", nodeName, len(blockingPods), len(nodeInfo.Pods()))
+	for _, pod := range nodeInfo.Pods() {
+		if blockingPod, found := blockingRules[pod]; found {
+			glog.Infof("Drainability audit of %s: pod %s/%s blocked by rule %s: %s", nodeName, pod.Namespace, pod.Name,
+				blockingPod.Reason, blockingPod.Detail)
+		} else {
+			glog.Infof("Drainability audit of %s: pod %s/%s not blocked", nodeName, pod.Namespace, pod.Name)
+		}
+	}
+}
+
 // FindEmptyNodesToRemove finds empty nodes that can be removed.
 func FindEmptyNodesToRemove(candidates []*apiv1.Node, pods []*apiv1.Pod) []*apiv1.Node {
 	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(pods, candidates)
 	result := make([]*apiv1.Node, 0)
 	for _, node := range candidates {
 		if nodeInfo, found := nodeNameToNodeInfo[node.Name]; found {
+			nodeInfo, _ = withoutIgnoredPods(nodeInfo)
 			// Should block on all pods.
 			podsToRemove, err := FastGetPodsToMove(nodeInfo, true, true, nil)
 			if err == nil && len(podsToRemove) == 0 {
@@ -137,6 +240,56 @@ func FindEmptyNodesToRemove(candidates []*apiv1.Node, pods []*apiv1.Pod) []*apiv
 	return result
 }
 
+// IsRebalanceTarget returns false if the node is excluded from destinations of pods moved in
+// simulations, typically because it is about to go into maintenance or is under pressure.
+func IsRebalanceTarget(node *apiv1.Node) bool {
+	return node.Annotations[NoRebalanceTargetAnnotationKey] != "true" && !IsUnderPressure(node)
+}
+
+// pressureTaints are the taints the node lifecycle controller adds for pressure conditions. Taints
+// may be added before the condition is seen by CA and stay a while after it's gone.
+var pressureTaints = map[string]string{
+	string(apiv1.NodeMemoryPressure): algorithm.TaintNodeMemoryPressure,
+	string(apiv1.NodeDiskPressure):   algorithm.TaintNodeDiskPressure,
+	"PIDPressure":                    "node.kubernetes.io/pid-pressure",
+}
+
+// IsUnderPressure returns true if any of the node conditions listed in --pressure-node-conditions
+// is true on the node or the node has the taint of the condition. Pods moved to such nodes are
+// likely to be evicted or fail to start.
+func IsUnderPressure(node *apiv1.Node) bool {
+	for _, conditionType := range strings.Split(*pressureNodeConditions, ",") {
+		conditionType = strings.TrimSpace(conditionType)
+		if conditionType == "" {
+			continue
+		}
+		for _, condition := range node.Status.Conditions {
+			if string(condition.Type) == conditionType && condition.Status == apiv1.ConditionTrue {
+				return true
+			}
+		}
+		if taintKey, found := pressureTaints[conditionType]; found {
+			for _, taint := range node.Spec.Taints {
+				if taint.Key == taintKey {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// CountNodesUnderPressure returns the number of nodes for which IsUnderPressure is true.
+func CountNodesUnderPressure(nodes []*apiv1.Node) int {
+	count := 0
+	for _, node := range nodes {
+		if IsUnderPressure(node) {
+			count++
+		}
+	}
+	return count
+}
+
 // CalculateUtilization calculates utilization of a node, defined as total amount of requested resources divided by capacity.
 func CalculateUtilization(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo) (float64, error) {
 	cpu, err := calculateUtilizationOfResource(node, nodeInfo, apiv1.ResourceCPU)
@@ -150,6 +303,15 @@ func CalculateUtilization(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo) (
 	return math.Max(cpu, mem), nil
 }
 
+// CalculateGpuAwareUtilization is CalculateUtilization, except that GPU nodes are measured by GPU
+// utilization only, as GPUs are their most expensive resource.
+func CalculateGpuAwareUtilization(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo) (float64, error) {
+	if gpu.GetGpuCount(node.Status.Capacity) > 0 {
+		return gpu.CalculateUtilization(node, nodeInfo.Pods())
+	}
+	return CalculateUtilization(node, nodeInfo)
+}
+
 func calculateUtilizationOfResource(node *apiv1.Node, nodeInfo *schedulercache.NodeInfo, resourceName apiv1.ResourceName) (float64, error) {
 	nodeCapacity, found := node.Status.Capacity[resourceName]
 	if !found {
@@ -160,19 +322,32 @@ func calculateUtilizationOfResource(node *apiv1.Node, nodeInfo *schedulercache.N
 	}
 	podsRequest := resource.MustParse("0")
 	for _, pod := range nodeInfo.Pods() {
-		for _, container := range pod.Spec.Containers {
-			if resourceValue, found := container.Resources.Requests[resourceName]; found {
-				podsRequest.Add(resourceValue)
-			}
-		}
+		podsRequest.Add(podResourceRequest(pod, resourceName))
 	}
 	return float64(podsRequest.MilliValue()) / float64(nodeCapacity.MilliValue()), nil
 }
 
+// podResourceRequest returns the request of the pod for the resource. Init containers run one at a time
+// before other containers, so the pod requests the larger of their maximum and the sum of other containers.
+func podResourceRequest(pod *apiv1.Pod, resourceName apiv1.ResourceName) resource.Quantity {
+	request := resource.MustParse("0")
+	for _, container := range pod.Spec.Containers {
+		if resourceValue, found := container.Resources.Requests[resourceName]; found {
+			request.Add(resourceValue)
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if resourceValue, found := container.Resources.Requests[resourceName]; found && resourceValue.Cmp(request) > 0 {
+			request = resourceValue
+		}
+	}
+	return request
+}
+
 // TODO: We don't need to pass list of nodes here as they are already available in nodeInfos.
 func findPlaceFor(removedNode string, pods []*apiv1.Pod, nodes []*apiv1.Node, nodeInfos map[string]*schedulercache.NodeInfo,
 	predicateChecker *PredicateChecker, oldHints map[string]string, newHints map[string]string, usageTracker *UsageTracker,
-	timestamp time.Time) error {
+	timestamp time.Time, scoringStrategy ScoringStrategy) error {
 
 	newNodeInfos := make(map[string]*schedulercache.NodeInfo)
 	for k, v := range nodeInfos {
@@ -193,6 +368,9 @@ func findPlaceFor(removedNode string, pods []*apiv1.Pod, nodes []*apiv1.Node, no
 				glog.Warningf("No node in nodeInfo %s -> %v", nodename, nodeInfo)
 				return false
 			}
+			if !IsRebalanceTarget(nodeInfo.Node()) {
+				return false
+			}
 			err := predicateChecker.CheckPredicates(pod, predicateMeta, nodeInfo, ReturnVerboseError)
 			glog.V(5).Infof("Evaluation %s for %s/%s -> %v", nodename, pod.Namespace, pod.Name, err)
 			if err == nil {
@@ -214,7 +392,7 @@ func findPlaceFor(removedNode string, pods []*apiv1.Pod, nodes []*apiv1.Node, no
 	// layout.
 	shuffledNodes := shuffleNodes(nodes)
 
-	for _, podptr := range pods {
+	tryPod := func(podptr *apiv1.Pod) bool {
 		newpod := *podptr
 		newpod.Spec.NodeName = ""
 		pod := &newpod
@@ -233,24 +411,54 @@ func findPlaceFor(removedNode string, pods []*apiv1.Pod, nodes []*apiv1.Node, no
 			}
 		}
 		if !foundPlace {
+			candidates := make([]string, 0, len(shuffledNodes))
 			for _, node := range shuffledNodes {
-				if node.Name == removedNode {
-					continue
+				if node.Name != removedNode {
+					candidates = append(candidates, node.Name)
 				}
-				if tryNodeForPod(node.Name, pod, predicateMeta) {
+			}
+			// Allocation of nodes changes as pods are placed, so they are ordered for each pod.
+			sortNodeNamesByStrategy(scoringStrategy, candidates, newNodeInfos)
+			for _, name := range candidates {
+				if tryNodeForPod(name, pod, predicateMeta) {
 					foundPlace = true
-					targetNode = node.Name
+					targetNode = name
 					break
 				}
 			}
 			if !foundPlace {
-				return fmt.Errorf("failed to find place for %s", podKey(pod))
+				return false
 			}
 		}
 
 		usageTracker.RegisterUsage(removedNode, targetNode, timestamp)
+		return true
+	}
+
+	// Pods from the removed node are placed as a group. A pod may have affinity to
+	// another pod from the same node, which can only be satisfied once that pod has
+	// been placed, so pods that didn't fit are retried as long as the previous pass
+	// placed something.
+	pending := make([]*apiv1.Pod, len(pods))
+	copy(pending, pods)
+	sort.SliceStable(pending, func(i, j int) bool {
+		return podKey(pending[i]) < podKey(pending[j])
+	})
+	for pass := 0; ; pass++ {
+		unplaced := make([]*apiv1.Pod, 0)
+		for _, pod := range pending {
+			if !tryPod(pod) {
+				unplaced = append(unplaced, pod)
+			}
+		}
+		if len(unplaced) == 0 {
+			return nil
+		}
+		if len(unplaced) == len(pending) || pass+1 >= maxPodGroupPlacementPasses {
+			return fmt.Errorf("failed to find place for %s", podKey(unplaced[0]))
+		}
+		pending = unplaced
 	}
-	return nil
 }
 
 func shuffleNodes(nodes []*apiv1.Node) []*apiv1.Node {
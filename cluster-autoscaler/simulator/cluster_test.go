@@ -23,8 +23,16 @@ import (
 
 	apiv1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/gpu"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
 	"k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/stretchr/testify/assert"
@@ -46,6 +54,80 @@ func TestUtilization(t *testing.T) {
 
 	_, err = CalculateUtilization(node2, nodeInfo)
 	assert.Error(t, err)
+
+	// Init containers run before other containers, so the larger of their requests counts.
+	pod3 := BuildTestPod("p3", 100, 200000)
+	pod3.Spec.InitContainers = []apiv1.Container{{
+		Resources: apiv1.ResourceRequirements{
+			Requests: apiv1.ResourceList{apiv1.ResourceCPU: *resource.NewMilliQuantity(500, resource.DecimalSI)},
+		},
+	}}
+	utilization, err = CalculateUtilization(node, schedulercache.NewNodeInfo(pod3))
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 2.5/10, utilization, 0.01)
+	pod3.Spec.InitContainers[0].Resources.Requests[apiv1.ResourceMemory] = *resource.NewQuantity(1000000, resource.DecimalSI)
+	utilization, err = CalculateUtilization(node, schedulercache.NewNodeInfo(pod3))
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 5.0/10, utilization, 0.01)
+}
+
+func TestIsUnderPressure(t *testing.T) {
+	node := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(node, true, time.Time{})
+	assert.False(t, IsUnderPressure(node))
+	assert.True(t, IsRebalanceTarget(node))
+
+	node.Status.Conditions = append(node.Status.Conditions,
+		apiv1.NodeCondition{Type: apiv1.NodeDiskPressure, Status: apiv1.ConditionFalse},
+		apiv1.NodeCondition{Type: apiv1.NodeNetworkUnavailable, Status: apiv1.ConditionTrue})
+	assert.False(t, IsUnderPressure(node))
+
+	node.Status.Conditions[1].Status = apiv1.ConditionTrue
+	assert.True(t, IsUnderPressure(node))
+	assert.False(t, IsRebalanceTarget(node))
+	assert.Equal(t, 1, CountNodesUnderPressure([]*apiv1.Node{node, BuildTestNode("n2", 1000, 2000000)}))
+
+	defer func(conditions string) { *pressureNodeConditions = conditions }(*pressureNodeConditions)
+	*pressureNodeConditions = "NetworkUnavailable"
+	node.Status.Conditions[1].Status = apiv1.ConditionFalse
+	assert.True(t, IsUnderPressure(node))
+
+	// Pressure taints count as the conditions.
+	*pressureNodeConditions = "MemoryPressure,DiskPressure,PIDPressure"
+	node.Status.Conditions[2].Status = apiv1.ConditionFalse
+	assert.False(t, IsUnderPressure(node))
+	for _, taintKey := range []string{algorithm.TaintNodeMemoryPressure, algorithm.TaintNodeDiskPressure, "node.kubernetes.io/pid-pressure"} {
+		node.Spec.Taints = []apiv1.Taint{{Key: taintKey, Effect: apiv1.TaintEffectNoSchedule}}
+		assert.True(t, IsUnderPressure(node), taintKey)
+	}
+
+	// Empty list disables the check.
+	*pressureNodeConditions = ""
+	assert.False(t, IsUnderPressure(node))
+}
+
+func TestUtilizationGpu(t *testing.T) {
+	gpuPod := BuildTestPod("p1", 100, 200000)
+	gpuPod.Spec.Containers[0].Resources.Requests[gpu.ResourceNvidiaGPU] = *resource.NewQuantity(1, resource.DecimalSI)
+	pod := BuildTestPod("p2", 1900, 200000)
+	nodeInfo := schedulercache.NewNodeInfo(gpuPod, pod)
+
+	node := BuildTestNode("node1", 2000, 2000000)
+	node.Status.Capacity[gpu.ResourceNvidiaGPU] = *resource.NewQuantity(2, resource.DecimalSI)
+	utilization, err := CalculateUtilization(node, nodeInfo)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 1.0, utilization, 0.01)
+	utilization, err = CalculateGpuAwareUtilization(node, nodeInfo)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 1.0/2, utilization, 0.01)
+
+	// 2 GPUs time-shared by 8 containers each: the pod uses one of them.
+	node.Labels[gpu.GPUSharingStrategyLabel] = "time-sharing"
+	node.Labels[gpu.GPUMaxSharedClientsLabel] = "8"
+	node.Status.Capacity[gpu.ResourceNvidiaGPU] = *resource.NewQuantity(16, resource.DecimalSI)
+	utilization, err = CalculateGpuAwareUtilization(node, nodeInfo)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 1.0/2, utilization, 0.01)
 }
 
 func TestFindPlaceAllOk(t *testing.T) {
@@ -73,7 +155,7 @@ func TestFindPlaceAllOk(t *testing.T) {
 		[]*apiv1.Pod{new1, new2},
 		[]*apiv1.Node{node1, node2},
 		nodeInfos, NewTestPredicateChecker(),
-		oldHints, newHints, tracker, time.Now())
+		oldHints, newHints, tracker, time.Now(), FirstFit)
 
 	assert.Len(t, newHints, 2)
 	assert.Contains(t, newHints, new1.Namespace+"/"+new1.Name)
@@ -112,7 +194,7 @@ func TestFindPlaceAllBas(t *testing.T) {
 		[]*apiv1.Pod{new1, new2, new3},
 		[]*apiv1.Node{nodebad, node1, node2},
 		nodeInfos, NewTestPredicateChecker(),
-		oldHints, newHints, tracker, time.Now())
+		oldHints, newHints, tracker, time.Now(), FirstFit)
 
 	assert.Error(t, err)
 	assert.True(t, len(newHints) == 2)
@@ -144,10 +226,174 @@ func TestFindNone(t *testing.T) {
 		make(map[string]string),
 		make(map[string]string),
 		NewUsageTracker(),
-		time.Now())
+		time.Now(), FirstFit)
 	assert.NoError(t, err)
 }
 
+// newGroupAffinityTestPredicateChecker returns a PredicateChecker with a simplified inter-pod
+// affinity predicate (hostname topology only) that, unlike the scheduler one, evaluates
+// affinity against pods placed in the simulation.
+func newGroupAffinityTestPredicateChecker(removedNode string) *PredicateChecker {
+	// Pods placed outside of the removed node, refreshed whenever predicate metadata is computed.
+	var placedPods []*apiv1.Pod
+	matches := func(term apiv1.PodAffinityTerm, pod *apiv1.Pod) bool {
+		selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+		return err == nil && selector.Matches(labels.Set(pod.Labels))
+	}
+	affinityPredicate := func(pod *apiv1.Pod, meta algorithm.PredicateMetadata, nodeInfo *schedulercache.NodeInfo) (bool,
+		[]algorithm.PredicateFailureReason, error) {
+		if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAffinity == nil {
+			return true, nil, nil
+		}
+		for _, term := range pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			satisfied := false
+			for _, existing := range nodeInfo.Pods() {
+				satisfied = satisfied || matches(term, existing)
+			}
+			if satisfied {
+				continue
+			}
+			matchingPodExists := false
+			for _, existing := range placedPods {
+				matchingPodExists = matchingPodExists || matches(term, existing)
+			}
+			// The first pod of a group matching its own term can go anywhere.
+			if matchingPodExists || !matches(term, pod) {
+				return false, []algorithm.PredicateFailureReason{predicates.ErrPodAffinityNotMatch}, nil
+			}
+		}
+		return true, nil, nil
+	}
+	return &PredicateChecker{
+		predicates: []predicateInfo{
+			{name: "default", predicate: predicates.GeneralPredicates},
+			{name: "affinity", predicate: affinityPredicate},
+		},
+		predicateMetadataProducer: func(_ *apiv1.Pod, nodeInfos map[string]*schedulercache.NodeInfo) algorithm.PredicateMetadata {
+			placedPods = make([]*apiv1.Pod, 0)
+			for name, nodeInfo := range nodeInfos {
+				if name != removedNode {
+					placedPods = append(placedPods, nodeInfo.Pods()...)
+				}
+			}
+			return nil
+		},
+		enableAffinityPredicate: true,
+	}
+}
+
+func buildPodWithAffinity(name string, podLabels map[string]string, affinityLabels map[string]string) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 100000)
+	pod.Spec.NodeName = "n1"
+	pod.Labels = podLabels
+	pod.Spec.Affinity = &apiv1.Affinity{
+		PodAffinity: &apiv1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []apiv1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: affinityLabels},
+				TopologyKey:   kubeletapis.LabelHostname,
+			}},
+		},
+	}
+	return pod
+}
+
+func TestFindPlaceForAffinePods(t *testing.T) {
+	// p1 is considered first, but can only go where p2 (the first pod of its group) is placed.
+	p1 := buildPodWithAffinity("p1", map[string]string{"app": "frontend"}, map[string]string{"app": "backend"})
+	p2 := buildPodWithAffinity("p2", map[string]string{"app": "backend"}, map[string]string{"app": "backend"})
+
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(node1, true, time.Time{})
+	node2 := BuildTestNode("n2", 1000, 2000000)
+	SetNodeReadyState(node2, true, time.Time{})
+	node3 := BuildTestNode("n3", 1000, 2000000)
+	SetNodeReadyState(node3, true, time.Time{})
+
+	for i := 0; i < 10; i++ {
+		nodeInfos := map[string]*schedulercache.NodeInfo{
+			"n1": schedulercache.NewNodeInfo(p1, p2),
+			"n2": schedulercache.NewNodeInfo(),
+			"n3": schedulercache.NewNodeInfo(),
+		}
+		nodeInfos["n1"].SetNode(node1)
+		nodeInfos["n2"].SetNode(node2)
+		nodeInfos["n3"].SetNode(node3)
+		newHints := make(map[string]string)
+
+		err := findPlaceFor(
+			"n1",
+			[]*apiv1.Pod{p1, p2},
+			[]*apiv1.Node{node1, node2, node3},
+			nodeInfos, newGroupAffinityTestPredicateChecker("n1"),
+			make(map[string]string), newHints, NewUsageTracker(), time.Now(), FirstFit)
+
+		assert.NoError(t, err)
+		assert.Len(t, newHints, 2)
+		assert.NotEqual(t, "n1", newHints["default/p2"])
+		assert.Equal(t, newHints["default/p2"], newHints["default/p1"])
+	}
+}
+
+func TestFindPlaceForAffinePodsNoProgress(t *testing.T) {
+	p1 := buildPodWithAffinity("p1", map[string]string{"app": "frontend"}, map[string]string{"app": "backend"})
+	p2 := buildPodWithAffinity("p2", map[string]string{"app": "backend"}, map[string]string{"app": "frontend"})
+
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	SetNodeReadyState(node1, true, time.Time{})
+	node2 := BuildTestNode("n2", 1000, 2000000)
+	SetNodeReadyState(node2, true, time.Time{})
+
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"n1": schedulercache.NewNodeInfo(p1, p2),
+		"n2": schedulercache.NewNodeInfo(),
+	}
+	nodeInfos["n1"].SetNode(node1)
+	nodeInfos["n2"].SetNode(node2)
+	newHints := make(map[string]string)
+
+	err := findPlaceFor(
+		"n1",
+		[]*apiv1.Pod{p1, p2},
+		[]*apiv1.Node{node1, node2},
+		nodeInfos, newGroupAffinityTestPredicateChecker("n1"),
+		make(map[string]string), newHints, NewUsageTracker(), time.Now(), FirstFit)
+
+	assert.Error(t, err)
+	assert.Empty(t, newHints)
+}
+
+func TestFindPlaceForScoringStrategy(t *testing.T) {
+	removed := BuildTestNode("removed", 1000, 2000000)
+	SetNodeReadyState(removed, true, time.Time{})
+	busy := BuildTestNode("busy", 1000, 2000000)
+	SetNodeReadyState(busy, true, time.Time{})
+	idle := BuildTestNode("idle", 1000, 2000000)
+	SetNodeReadyState(idle, true, time.Time{})
+	pod := BuildTestPod("p1", 200, 100000)
+
+	for strategy, expected := range map[ScoringStrategy]string{MostAllocated: "busy", LeastAllocated: "idle"} {
+		nodeInfos := map[string]*schedulercache.NodeInfo{
+			"removed": schedulercache.NewNodeInfo(pod),
+			"busy":    schedulercache.NewNodeInfo(BuildTestPod("p2", 600, 1000000)),
+			"idle":    schedulercache.NewNodeInfo(BuildTestPod("p3", 100, 100000)),
+		}
+		nodeInfos["removed"].SetNode(removed)
+		nodeInfos["busy"].SetNode(busy)
+		nodeInfos["idle"].SetNode(idle)
+		newHints := make(map[string]string)
+
+		err := findPlaceFor(
+			"removed",
+			[]*apiv1.Pod{pod},
+			[]*apiv1.Node{removed, busy, idle},
+			nodeInfos, NewTestPredicateChecker(),
+			make(map[string]string), newHints, NewUsageTracker(), time.Now(), strategy)
+
+		assert.NoError(t, err)
+		assert.Equal(t, expected, newHints["default/p1"], "strategy %s", strategy)
+	}
+}
+
 func TestShuffleNodes(t *testing.T) {
 	nodes := []*apiv1.Node{
 		BuildTestNode("n1", 0, 0),
@@ -187,12 +433,44 @@ func TestFindEmptyNodes(t *testing.T) {
 	assert.Equal(t, []*apiv1.Node{node2, node3, node4}, emptyNodes)
 }
 
+func TestFindEmptyNodesWithIgnoredPods(t *testing.T) {
+	defer func(value string) { *ignorePodsForEmptyNodes = value }(*ignorePodsForEmptyNodes)
+	ownerRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	agent := BuildTestPod("agent", 100, 100000)
+	agent.Labels = map[string]string{"app": "node-agent"}
+	agent.OwnerReferences = ownerRefs
+	agent.Spec.NodeName = "n1"
+	pod := BuildTestPod("p2", 100, 100000)
+	pod.OwnerReferences = ownerRefs
+	pod.Spec.NodeName = "n2"
+
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	node2 := BuildTestNode("n2", 1000, 2000000)
+	SetNodeReadyState(node1, true, time.Time{})
+	SetNodeReadyState(node2, true, time.Time{})
+	nodes := []*apiv1.Node{node1, node2}
+	pods := []*apiv1.Pod{agent, pod}
+
+	*ignorePodsForEmptyNodes = ""
+	assert.Equal(t, []*apiv1.Node{}, FindEmptyNodesToRemove(nodes, pods))
+
+	*ignorePodsForEmptyNodes = "label=app=node-agent"
+	assert.Equal(t, []*apiv1.Node{node1}, FindEmptyNodesToRemove(nodes, pods))
+
+	// Ignored pods aren't moved in the simulation, but are reported to be evicted.
+	toRemove, _, _, err := FindNodesToRemove([]*apiv1.Node{node1}, []*apiv1.Node{node1}, pods, nil,
+		NewTestPredicateChecker(), len(nodes), true, map[string]string{}, NewUsageTracker(), time.Now(),
+		[]*policyv1.PodDisruptionBudget{}, FirstFit)
+	assert.NoError(t, err)
+	assert.Equal(t, []NodeToBeRemoved{{Node: node1, PodsToReschedule: []*apiv1.Pod{}, IgnoredPods: []*apiv1.Pod{agent}}}, toRemove)
+}
+
 type findNodesToRemoveTestConfig struct {
 	name        string
 	candidates  []*apiv1.Node
 	allNodes    []*apiv1.Node
 	toRemove    []NodeToBeRemoved
-	unremovable []*apiv1.Node
+	unremovable []*UnremovableNode
 }
 
 func TestFindNodesToRemove(t *testing.T) {
@@ -212,6 +490,22 @@ func TestFindNodesToRemove(t *testing.T) {
 	SetNodeReadyState(nonDrainableNode, true, time.Time{})
 	SetNodeReadyState(fullNode, true, time.Time{})
 
+	// an empty node excluded from rebalancing
+	excludedNode := BuildTestNode("n5", 1000, 2000000)
+	excludedNode.Annotations = map[string]string{NoRebalanceTargetAnnotationKey: "true"}
+	SetNodeReadyState(excludedNode, true, time.Time{})
+
+	// an empty node under memory pressure
+	pressuredNode := BuildTestNode("n6", 1000, 2000000)
+	SetNodeReadyState(pressuredNode, true, time.Time{})
+	pressuredNode.Status.Conditions = append(pressuredNode.Status.Conditions,
+		apiv1.NodeCondition{Type: apiv1.NodeMemoryPressure, Status: apiv1.ConditionTrue})
+
+	// an empty node tainted with disk pressure before the condition is reported
+	diskPressuredNode := BuildTestNode("n7", 1000, 2000000)
+	SetNodeReadyState(diskPressuredNode, true, time.Time{})
+	diskPressuredNode.Spec.Taints = []apiv1.Taint{{Key: algorithm.TaintNodeDiskPressure, Effect: apiv1.TaintEffectNoSchedule}}
+
 	ownerRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
 
 	pod1 := BuildTestPod("p1", 100, 100000)
@@ -245,7 +539,7 @@ func TestFindNodesToRemove(t *testing.T) {
 			candidates:  []*apiv1.Node{emptyNode},
 			allNodes:    []*apiv1.Node{emptyNode},
 			toRemove:    []NodeToBeRemoved{emptyNodeToRemove},
-			unremovable: []*apiv1.Node{},
+			unremovable: []*UnremovableNode{},
 		},
 		// just a drainable node, but nowhere for pods to go to
 		{
@@ -253,15 +547,21 @@ func TestFindNodesToRemove(t *testing.T) {
 			candidates:  []*apiv1.Node{drainableNode},
 			allNodes:    []*apiv1.Node{drainableNode},
 			toRemove:    []NodeToBeRemoved{},
-			unremovable: []*apiv1.Node{drainableNode},
+			unremovable: []*UnremovableNode{{Node: drainableNode, Reason: NoPlaceToMovePods}},
 		},
 		// drainable node, and a mostly empty node that can take its pods
 		{
-			name:        "drainable node, and a mostly empty node that can take its pods",
-			candidates:  []*apiv1.Node{drainableNode, nonDrainableNode},
-			allNodes:    []*apiv1.Node{drainableNode, nonDrainableNode},
-			toRemove:    []NodeToBeRemoved{drainableNodeToRemove},
-			unremovable: []*apiv1.Node{nonDrainableNode},
+			name:       "drainable node, and a mostly empty node that can take its pods",
+			candidates: []*apiv1.Node{drainableNode, nonDrainableNode},
+			allNodes:   []*apiv1.Node{drainableNode, nonDrainableNode},
+			toRemove:   []NodeToBeRemoved{drainableNodeToRemove},
+			unremovable: []*UnremovableNode{{
+				Node:   nonDrainableNode,
+				Reason: UnremovableReason(drain.NotReplicated),
+				BlockingPods: []drain.BlockingPod{
+					{Pod: pod3, Reason: drain.NotReplicated, Detail: "default/p3 is not replicated"},
+				},
+			}},
 		},
 		// drainable node, and a full node that cannot fit anymore pods
 		{
@@ -269,7 +569,31 @@ func TestFindNodesToRemove(t *testing.T) {
 			candidates:  []*apiv1.Node{drainableNode},
 			allNodes:    []*apiv1.Node{drainableNode, fullNode},
 			toRemove:    []NodeToBeRemoved{},
-			unremovable: []*apiv1.Node{drainableNode},
+			unremovable: []*UnremovableNode{{Node: drainableNode, Reason: NoPlaceToMovePods}},
+		},
+		// drainable node, and an empty node excluded from rebalancing
+		{
+			name:        "drainable node, and an empty node excluded from rebalancing",
+			candidates:  []*apiv1.Node{drainableNode},
+			allNodes:    []*apiv1.Node{drainableNode, excludedNode},
+			toRemove:    []NodeToBeRemoved{},
+			unremovable: []*UnremovableNode{{Node: drainableNode, Reason: NoPlaceToMovePods}},
+		},
+		// drainable node, and an empty node under pressure
+		{
+			name:        "drainable node, and an empty node under pressure",
+			candidates:  []*apiv1.Node{drainableNode},
+			allNodes:    []*apiv1.Node{drainableNode, pressuredNode},
+			toRemove:    []NodeToBeRemoved{},
+			unremovable: []*UnremovableNode{{Node: drainableNode, Reason: NoPlaceToMovePods}},
+		},
+		// drainable node, and an empty node tainted with disk pressure
+		{
+			name:        "drainable node, and an empty node tainted with disk pressure",
+			candidates:  []*apiv1.Node{drainableNode},
+			allNodes:    []*apiv1.Node{drainableNode, diskPressuredNode},
+			toRemove:    []NodeToBeRemoved{},
+			unremovable: []*UnremovableNode{{Node: drainableNode, Reason: NoPlaceToMovePods}},
 		},
 		// 4 nodes, 1 empty, 1 drainable
 		{
@@ -277,7 +601,7 @@ func TestFindNodesToRemove(t *testing.T) {
 			candidates:  []*apiv1.Node{emptyNode, drainableNode},
 			allNodes:    []*apiv1.Node{emptyNode, drainableNode, fullNode, nonDrainableNode},
 			toRemove:    []NodeToBeRemoved{emptyNodeToRemove, drainableNodeToRemove},
-			unremovable: []*apiv1.Node{},
+			unremovable: []*UnremovableNode{},
 		},
 	}
 
@@ -285,7 +609,7 @@ func TestFindNodesToRemove(t *testing.T) {
 		toRemove, unremovable, _, err := FindNodesToRemove(
 			test.candidates, test.allNodes, pods, nil,
 			predicateChecker, len(test.allNodes), true, map[string]string{},
-			tracker, time.Now(), []*policyv1.PodDisruptionBudget{})
+			tracker, time.Now(), []*policyv1.PodDisruptionBudget{}, FirstFit)
 		assert.NoError(t, err)
 		fmt.Printf("Test scenario: %s, found len(toRemove)=%v, expected len(test.toRemove)=%v\n", test.name, len(toRemove), len(test.toRemove))
 		assert.Equal(t, toRemove, test.toRemove)
@@ -0,0 +1,82 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1beta1"
+	scheduler_util "k8s.io/autoscaler/cluster-autoscaler/utils/scheduler"
+
+	"github.com/golang/glog"
+)
+
+// CompactionEnablesRemoval checks if evicting movablePods from the source node lets the target node
+// be removed. The evicted pods are moved to nodes other than source and target, and then all pods
+// of target have to fit on the remaining nodes, including the space freed on source.
+func CompactionEnablesRemoval(source *apiv1.Node, movablePods []*apiv1.Pod, target *apiv1.Node, allNodes []*apiv1.Node,
+	pods []*apiv1.Pod, predicateChecker *PredicateChecker, pdbs []*policyv1.PodDisruptionBudget,
+	scoringStrategy ScoringStrategy, timestamp time.Time) bool {
+
+	nodeNameToNodeInfo := scheduler_util.CreateNodeNameToInfoMap(pods, allNodes)
+	targetInfo, found := nodeNameToNodeInfo[target.Name]
+	if !found {
+		return false
+	}
+	targetPods, err := FastGetPodsToMove(targetInfo, *skipNodesWithSystemPods, *skipNodesWithLocalStorage, pdbs)
+	if err != nil {
+		glog.V(4).Infof("Compaction of %s can't help removing %s: %v", source.Name, target.Name, err)
+		return false
+	}
+
+	destinations := make([]*apiv1.Node, 0, len(allNodes))
+	for _, node := range allNodes {
+		if node.Name != target.Name {
+			destinations = append(destinations, node)
+		}
+	}
+	usageTracker := NewUsageTracker()
+	hints := make(map[string]string)
+	if err := findPlaceFor(source.Name, movablePods, destinations, nodeNameToNodeInfo, predicateChecker,
+		map[string]string{}, hints, usageTracker, timestamp, scoringStrategy); err != nil {
+		glog.V(4).Infof("Compaction of %s can't help removing %s: %v", source.Name, target.Name, err)
+		return false
+	}
+
+	moved := make(map[*apiv1.Pod]bool, len(movablePods))
+	for _, pod := range movablePods {
+		moved[pod] = true
+	}
+	compactedPods := make([]*apiv1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if moved[pod] {
+			movedPod := *pod
+			movedPod.Spec.NodeName = hints[fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)]
+			pod = &movedPod
+		}
+		compactedPods = append(compactedPods, pod)
+	}
+	compacted := scheduler_util.CreateNodeNameToInfoMap(compactedPods, allNodes)
+	if err := findPlaceFor(target.Name, targetPods, allNodes, compacted, predicateChecker,
+		map[string]string{}, map[string]string{}, usageTracker, timestamp, scoringStrategy); err != nil {
+		glog.V(4).Infof("Compaction of %s doesn't make %s removable: %v", source.Name, target.Name, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactionEnablesRemoval(t *testing.T) {
+	ownerRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+
+	source := BuildTestNode("source", 1000, 2000000)
+	target := BuildTestNode("target", 1000, 2000000)
+	other := BuildTestNode("other", 1000, 2000000)
+	nodes := []*apiv1.Node{source, target, other}
+	for _, node := range nodes {
+		SetNodeReadyState(node, true, time.Time{})
+	}
+
+	unmovable := BuildTestPod("unmovable", 100, 100000)
+	unmovable.Spec.NodeName = "source"
+	movable := BuildTestPod("movable", 300, 100000)
+	movable.OwnerReferences = ownerRefs
+	movable.Spec.NodeName = "source"
+	filler := BuildTestPod("filler", 500, 100000)
+	filler.Spec.NodeName = "other"
+
+	buildTargetPod := func(cpu int64) *apiv1.Pod {
+		pod := BuildTestPod("target-pod", cpu, 100000)
+		pod.OwnerReferences = ownerRefs
+		pod.Spec.NodeName = "target"
+		return pod
+	}
+	predicateChecker := NewTestPredicateChecker()
+	check := func(targetPod *apiv1.Pod, movablePods []*apiv1.Pod) bool {
+		pods := []*apiv1.Pod{unmovable, movable, filler, targetPod}
+		return CompactionEnablesRemoval(source, movablePods, target, nodes, pods, predicateChecker, nil, FirstFit, time.Now())
+	}
+
+	// The target pod fits on source only after the movable pod is moved to other.
+	assert.True(t, check(buildTargetPod(700), []*apiv1.Pod{movable}))
+	// Without evictions there is no room for the target pod.
+	assert.False(t, check(buildTargetPod(700), []*apiv1.Pod{}))
+	// The target pod doesn't fit even on the compacted source.
+	assert.False(t, check(buildTargetPod(950), []*apiv1.Pod{movable}))
+
+	// The movable pod has nowhere to go.
+	bigMovable := BuildTestPod("movable", 600, 100000)
+	bigMovable.OwnerReferences = ownerRefs
+	bigMovable.Spec.NodeName = "source"
+	pods := []*apiv1.Pod{unmovable, bigMovable, filler, buildTargetPod(300)}
+	assert.False(t, CompactionEnablesRemoval(source, []*apiv1.Pod{bigMovable}, target, nodes, pods, predicateChecker, nil, FirstFit, time.Now()))
+}
@@ -18,6 +18,7 @@ package simulator
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
@@ -27,6 +28,8 @@ import (
 	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
 	client "k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
 )
 
 // FastGetPodsToMove returns a list of pods that should be moved elsewhere if the node
@@ -53,6 +56,12 @@ func FastGetPodsToMove(nodeInfo *schedulercache.NodeInfo, skipNodesWithSystemPod
 	if err := checkPdbs(pods, pdbs); err != nil {
 		return []*apiv1.Pod{}, err
 	}
+	if err := checkInitContainers(pods, time.Now()); err != nil {
+		return []*apiv1.Pod{}, err
+	}
+	if err := checkDoNotDisrupt(pods, time.Now(), *doNotDisruptMaxHorizon); err != nil {
+		return []*apiv1.Pod{}, err
+	}
 
 	return pods, nil
 }
@@ -80,10 +89,116 @@ func DetailedGetPodsForMove(nodeInfo *schedulercache.NodeInfo, skipNodesWithSyst
 	if err := checkPdbs(pods, pdbs); err != nil {
 		return []*apiv1.Pod{}, err
 	}
+	if err := checkInitContainers(pods, time.Now()); err != nil {
+		return []*apiv1.Pod{}, err
+	}
+	if err := checkDoNotDisrupt(pods, time.Now(), *doNotDisruptMaxHorizon); err != nil {
+		return []*apiv1.Pod{}, err
+	}
 
 	return pods, nil
 }
 
+// AuditPodsToMove checks every pod of the node on its own and returns all pods that prevent the
+// node from being drained, with the rules blocking them. FastGetPodsToMove and DetailedGetPodsForMove
+// stop at the first blocking pod, so this is used to explain why a node is unremovable.
+// checkReferences selects the checks of DetailedGetPodsForMove; it requires client to be non-nil.
+func AuditPodsToMove(nodeInfo *schedulercache.NodeInfo, skipNodesWithSystemPods bool, skipNodesWithLocalStorage bool,
+	checkReferences bool, client client.Interface, minReplicaCount int32,
+	pdbs []*policyv1.PodDisruptionBudget) []drain.BlockingPod {
+	blockingPods := make([]drain.BlockingPod, 0)
+	for _, pod := range nodeInfo.Pods() {
+		podInfo := schedulercache.NewNodeInfo(pod)
+		var err error
+		if checkReferences {
+			_, err = DetailedGetPodsForMove(podInfo, skipNodesWithSystemPods, skipNodesWithLocalStorage, client, minReplicaCount, pdbs)
+		} else {
+			_, err = FastGetPodsToMove(podInfo, skipNodesWithSystemPods, skipNodesWithLocalStorage, pdbs)
+		}
+		if err != nil {
+			blockingPods = append(blockingPods, drain.BlockingPod{Pod: pod, Reason: drain.BlockingReason(err), Detail: err.Error()})
+		}
+	}
+	return blockingPods
+}
+
+// GetMovablePods returns pods of the node that can be moved elsewhere even though other pods keep
+// the node from being drained. DaemonSet and mirror pods are never returned.
+func GetMovablePods(nodeInfo *schedulercache.NodeInfo, pdbs []*policyv1.PodDisruptionBudget) []*apiv1.Pod {
+	blocking := make(map[*apiv1.Pod]bool)
+	for _, blockingPod := range AuditPodsToMove(nodeInfo, *skipNodesWithSystemPods, *skipNodesWithLocalStorage, false, nil, 0, pdbs) {
+		blocking[blockingPod.Pod] = true
+	}
+	candidates := make([]*apiv1.Pod, 0, len(nodeInfo.Pods()))
+	for _, pod := range nodeInfo.Pods() {
+		if !blocking[pod] {
+			candidates = append(candidates, pod)
+		}
+	}
+	pods, err := FastGetPodsToMove(schedulercache.NewNodeInfo(candidates...), *skipNodesWithSystemPods, *skipNodesWithLocalStorage, pdbs)
+	if err != nil {
+		// Each of the candidates passed the checks on its own, which is enough for all checks.
+		glog.Warningf("Unexpected error checking movable pods of %s: %v", nodeInfo.Node().Name, err)
+		return []*apiv1.Pod{}
+	}
+	return pods
+}
+
+// checkInitContainers returns an error if evicting one of the pods would waste the work of its running
+// init containers. Such pods are annotated or have been initializing for longer than
+// --defer-eviction-during-init-after.
+func checkInitContainers(pods []*apiv1.Pod, now time.Time) error {
+	for _, pod := range pods {
+		if !drain.IsInitializing(pod) {
+			continue
+		}
+		if drain.HasDeferEvictionDuringInitAnnotation(pod) {
+			return drain.NewBlockingPodError(drain.InitContainersRunning, "pod %s/%s is running init containers", pod.Namespace, pod.Name)
+		}
+		if *deferEvictionDuringInitAfter > 0 && now.Sub(pod.Status.StartTime.Time) >= *deferEvictionDuringInitAfter {
+			return drain.NewBlockingPodError(drain.InitContainersRunning, "pod %s/%s is running init containers for %v",
+				pod.Namespace, pod.Name, now.Sub(pod.Status.StartTime.Time))
+		}
+	}
+	return nil
+}
+
+// checkDoNotDisrupt returns an error if one of the pods asks not to be disrupted until a time in
+// the future. The error names the time all the pods can be disrupted. Malformed annotations are
+// ignored, and times more than maxHorizon after the creation of the pod are capped, so that
+// annotations can't keep the node forever.
+func checkDoNotDisrupt(pods []*apiv1.Pod, now time.Time, maxHorizon time.Duration) error {
+	var blocking *apiv1.Pod
+	var latest time.Time
+	for _, pod := range pods {
+		deadline, found, err := drain.GetDoNotDisruptUntil(pod)
+		if err != nil {
+			glog.Warningf("Ignoring annotation: %v", err)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if maxHorizon > 0 && !pod.CreationTimestamp.IsZero() {
+			limit := pod.CreationTimestamp.Add(maxHorizon)
+			if deadline.After(limit) {
+				glog.Warningf("Pod %s/%s asks not to be disrupted until %s, more than %v after its creation, capping at %s",
+					pod.Namespace, pod.Name, deadline.Format(time.RFC3339), maxHorizon, limit.Format(time.RFC3339))
+				deadline = limit
+			}
+		}
+		if deadline.After(now) && deadline.After(latest) {
+			blocking = pod
+			latest = deadline
+		}
+	}
+	if blocking == nil {
+		return nil
+	}
+	return drain.NewBlockingPodError(drain.DoNotDisruptUntil, "pod %s/%s must not be disrupted until %s",
+		blocking.Namespace, blocking.Name, latest.UTC().Format(time.RFC3339))
+}
+
 func checkPdbs(pods []*apiv1.Pod, pdbs []*policyv1.PodDisruptionBudget) error {
 	// TODO: make it more efficient.
 	for _, pdb := range pdbs {
@@ -94,10 +209,110 @@ func checkPdbs(pods []*apiv1.Pod, pdbs []*policyv1.PodDisruptionBudget) error {
 		for _, pod := range pods {
 			if pod.Namespace == pdb.Namespace && selector.Matches(labels.Set(pod.Labels)) {
 				if pdb.Status.PodDisruptionsAllowed < 1 {
-					return fmt.Errorf("no enough pod disruption budget to move %s/%s", pod.Namespace, pod.Name)
+					return drain.NewBlockingPodError(drain.NotEnoughPdb, "no enough pod disruption budget to move %s/%s", pod.Namespace, pod.Name)
 				}
 			}
 		}
 	}
 	return nil
 }
+
+// ignoredPodSelector matches pods listed in --ignore-pods-for-empty-nodes by namespace or labels.
+type ignoredPodSelector struct {
+	namespace string
+	selector  labels.Selector
+}
+
+func (s ignoredPodSelector) matches(pod *apiv1.Pod) bool {
+	if s.selector != nil {
+		return s.selector.Matches(labels.Set(pod.Labels))
+	}
+	return pod.Namespace == s.namespace
+}
+
+// parseIgnoredPodSelectors parses a comma-separated list of ns=<namespace> and label=<label selector>
+// entries, e.g. "ns=observability,label=app=node-agent".
+func parseIgnoredPodSelectors(value string) ([]ignoredPodSelector, error) {
+	result := make([]ignoredPodSelector, 0)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected ns=<namespace> or label=<label selector>", entry)
+		}
+		switch parts[0] {
+		case "ns":
+			result = append(result, ignoredPodSelector{namespace: parts[1]})
+		case "label":
+			selector, err := labels.Parse(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid label selector in entry %q: %v", entry, err)
+			}
+			result = append(result, ignoredPodSelector{selector: selector})
+		default:
+			return nil, fmt.Errorf("invalid entry %q, expected ns=<namespace> or label=<label selector>", entry)
+		}
+	}
+	return result, nil
+}
+
+// ValidateIgnorePodsForEmptyNodes returns an error if --ignore-pods-for-empty-nodes can't be parsed.
+func ValidateIgnorePodsForEmptyNodes() error {
+	_, err := parseIgnoredPodSelectors(*ignorePodsForEmptyNodes)
+	return err
+}
+
+// SplitIgnoredPods splits pods into those matching --ignore-pods-for-empty-nodes and the remaining ones.
+// DaemonSet and mirror pods are never ignored, as they are handled by drain rules already. Ignored pods
+// are nil if there are none.
+func SplitIgnoredPods(pods []*apiv1.Pod) (remaining []*apiv1.Pod, ignored []*apiv1.Pod) {
+	if *ignorePodsForEmptyNodes == "" {
+		return pods, nil
+	}
+	selectors, err := parseIgnoredPodSelectors(*ignorePodsForEmptyNodes)
+	if err != nil {
+		glog.Errorf("Invalid --ignore-pods-for-empty-nodes: %v", err)
+		return pods, nil
+	}
+	remaining = make([]*apiv1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if isIgnoredPod(pod, selectors) {
+			ignored = append(ignored, pod)
+		} else {
+			remaining = append(remaining, pod)
+		}
+	}
+	return remaining, ignored
+}
+
+func isIgnoredPod(pod *apiv1.Pod, selectors []ignoredPodSelector) bool {
+	if drain.IsMirrorPod(pod) {
+		return false
+	}
+	if controllerRef := drain.ControllerRef(pod); controllerRef != nil && controllerRef.Kind == "DaemonSet" {
+		return false
+	}
+	for _, selector := range selectors {
+		if selector.matches(pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutIgnoredPods returns node info without pods matching --ignore-pods-for-empty-nodes and the
+// pods that were left out.
+func withoutIgnoredPods(nodeInfo *schedulercache.NodeInfo) (*schedulercache.NodeInfo, []*apiv1.Pod) {
+	remaining, ignored := SplitIgnoredPods(nodeInfo.Pods())
+	if len(ignored) == 0 {
+		return nodeInfo, ignored
+	}
+	filtered := schedulercache.NewNodeInfo(remaining...)
+	if node := nodeInfo.Node(); node != nil {
+		filtered.SetNode(node)
+	}
+	return filtered, ignored
+}
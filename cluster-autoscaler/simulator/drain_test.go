@@ -18,11 +18,13 @@ package simulator
 
 import (
 	"testing"
+	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/autoscaler/cluster-autoscaler/utils/drain"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 	"k8s.io/kubernetes/pkg/kubelet/types"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
@@ -204,3 +206,208 @@ func TestFastGetPodsToMove(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(r9))
 }
+
+func TestFastGetPodsToMoveInitContainers(t *testing.T) {
+	buildInitializingPod := func(name string, started time.Time, initialized apiv1.ConditionStatus) *apiv1.Pod {
+		pod := BuildTestPod(name, 100, 0)
+		pod.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+		pod.Spec.InitContainers = []apiv1.Container{{Name: "preload"}}
+		startTime := metav1.NewTime(started)
+		pod.Status.StartTime = &startTime
+		pod.Status.Phase = apiv1.PodPending
+		pod.Status.Conditions = []apiv1.PodCondition{{Type: apiv1.PodInitialized, Status: initialized}}
+		return pod
+	}
+	now := time.Now()
+
+	// Init running for a short time, without the annotation.
+	pod1 := buildInitializingPod("pod1", now.Add(-time.Minute), apiv1.ConditionFalse)
+	r1, err := FastGetPodsToMove(schedulercache.NewNodeInfo(pod1), true, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{pod1}, r1)
+
+	// Init running, with the annotation.
+	pod2 := buildInitializingPod("pod2", now.Add(-time.Minute), apiv1.ConditionFalse)
+	pod2.Annotations = map[string]string{drain.PodDeferEvictionDuringInitKey: "true"}
+	_, err = FastGetPodsToMove(schedulercache.NewNodeInfo(pod1, pod2), true, true, nil)
+	assert.Error(t, err)
+	assert.Equal(t, drain.InitContainersRunning, drain.BlockingReason(err))
+
+	// Init finished, with the annotation.
+	pod3 := buildInitializingPod("pod3", now.Add(-time.Hour), apiv1.ConditionTrue)
+	pod3.Status.Phase = apiv1.PodRunning
+	pod3.Annotations = map[string]string{drain.PodDeferEvictionDuringInitKey: "true"}
+	r3, err := FastGetPodsToMove(schedulercache.NewNodeInfo(pod3), true, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{pod3}, r3)
+
+	// Init running for longer than the threshold, without the annotation.
+	defer func(threshold time.Duration) { *deferEvictionDuringInitAfter = threshold }(*deferEvictionDuringInitAfter)
+	*deferEvictionDuringInitAfter = 10 * time.Minute
+	pod4 := buildInitializingPod("pod4", now.Add(-20*time.Minute), apiv1.ConditionFalse)
+	_, err = FastGetPodsToMove(schedulercache.NewNodeInfo(pod4), true, true, nil)
+	assert.Error(t, err)
+	assert.Equal(t, drain.InitContainersRunning, drain.BlockingReason(err))
+	r1, err = FastGetPodsToMove(schedulercache.NewNodeInfo(pod1), true, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{pod1}, r1)
+}
+
+func buildDoNotDisruptPod(name string, created time.Time, until string) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 0)
+	pod.OwnerReferences = GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.Annotations = map[string]string{drain.PodDoNotDisruptUntilKey: until}
+	return pod
+}
+
+func TestCheckDoNotDisrupt(t *testing.T) {
+	now := time.Date(2017, 11, 20, 12, 0, 0, 0, time.UTC)
+	created := now.Add(-time.Hour)
+
+	// No annotation.
+	assert.NoError(t, checkDoNotDisrupt([]*apiv1.Pod{BuildTestPod("p", 100, 0)}, now, 24*time.Hour))
+
+	// Deadline in the future.
+	err := checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", created, "2017-11-20T14:00:00Z")}, now, 24*time.Hour)
+	assert.Error(t, err)
+	assert.Equal(t, drain.DoNotDisruptUntil, drain.BlockingReason(err))
+	assert.Contains(t, err.Error(), "until 2017-11-20T14:00:00Z")
+
+	// Expired deadline.
+	assert.NoError(t, checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", created, "2017-11-20T11:00:00Z")}, now, 24*time.Hour))
+
+	// Malformed values are ignored.
+	assert.NoError(t, checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", created, "tomorrow")}, now, 24*time.Hour))
+	assert.NoError(t, checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", created, "2017-11-21")}, now, 24*time.Hour))
+
+	// The latest deadline of all pods is reported.
+	err = checkDoNotDisrupt([]*apiv1.Pod{
+		buildDoNotDisruptPod("p1", created, "2017-11-20T14:00:00Z"),
+		buildDoNotDisruptPod("p2", created, "2017-11-20T16:00:00+01:00"),
+		buildDoNotDisruptPod("p3", created, "2017-11-20T13:00:00Z"),
+	}, now, 24*time.Hour)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "pod default/p2 must not be disrupted until 2017-11-20T15:00:00Z")
+}
+
+func TestCheckDoNotDisruptMaxHorizon(t *testing.T) {
+	now := time.Date(2017, 11, 20, 12, 0, 0, 0, time.UTC)
+
+	// Deadlines beyond the horizon are capped at creation time plus the horizon.
+	err := checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", now.Add(-time.Hour), "2018-01-01T00:00:00Z")}, now, 24*time.Hour)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "until 2017-11-21T11:00:00Z")
+
+	// Once the capped deadline passes, the pod can be evicted.
+	assert.NoError(t, checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", now.Add(-25*time.Hour), "2018-01-01T00:00:00Z")}, now, 24*time.Hour))
+
+	// Without the cap the deadline is respected.
+	err = checkDoNotDisrupt([]*apiv1.Pod{buildDoNotDisruptPod("p", now.Add(-25*time.Hour), "2018-01-01T00:00:00Z")}, now, 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "until 2018-01-01T00:00:00Z")
+}
+
+func TestFastGetPodsToMoveDoNotDisrupt(t *testing.T) {
+	now := time.Now()
+	pod := buildDoNotDisruptPod("p", now.Add(-time.Hour), now.Add(time.Hour).Format(time.RFC3339))
+	_, err := FastGetPodsToMove(schedulercache.NewNodeInfo(pod), true, true, nil)
+	assert.Error(t, err)
+	assert.Equal(t, drain.DoNotDisruptUntil, drain.BlockingReason(err))
+
+	expired := buildDoNotDisruptPod("expired", now.Add(-time.Hour), now.Add(-time.Minute).Format(time.RFC3339))
+	pods, err := FastGetPodsToMove(schedulercache.NewNodeInfo(expired), true, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{expired}, pods)
+}
+
+func TestAuditPodsToMove(t *testing.T) {
+	ownerRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+
+	replicated := BuildTestPod("replicated", 100, 0)
+	replicated.OwnerReferences = ownerRefs
+
+	naked := BuildTestPod("naked", 100, 0)
+
+	localStorage := BuildTestPod("local-storage", 100, 0)
+	localStorage.OwnerReferences = ownerRefs
+	localStorage.Spec.Volumes = []apiv1.Volume{{Name: "scratch", VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}}}}
+
+	protected := BuildTestPod("protected", 100, 0)
+	protected.OwnerReferences = ownerRefs
+	protected.Labels = map[string]string{"critical": "true"}
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "critical", Namespace: protected.Namespace},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"critical": "true"}},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{PodDisruptionsAllowed: 0},
+	}
+
+	annotated := BuildTestPod("annotated", 100, 0)
+	annotated.OwnerReferences = ownerRefs
+	annotated.Annotations = map[string]string{drain.PodDeferEvictionDuringInitKey: "true"}
+	annotated.Spec.InitContainers = []apiv1.Container{{Name: "preload"}}
+	startTime := metav1.NewTime(time.Now().Add(-time.Minute))
+	annotated.Status.StartTime = &startTime
+	annotated.Status.Phase = apiv1.PodPending
+
+	nodeInfo := schedulercache.NewNodeInfo(replicated, naked, localStorage, protected, annotated)
+	blockingPods := AuditPodsToMove(nodeInfo, true, true, false, nil, 0, []*policyv1.PodDisruptionBudget{pdb})
+
+	reasons := make(map[string]drain.BlockingPodReason)
+	for _, blockingPod := range blockingPods {
+		assert.NotEmpty(t, blockingPod.Detail)
+		reasons[blockingPod.Pod.Name] = blockingPod.Reason
+	}
+	assert.Equal(t, map[string]drain.BlockingPodReason{
+		"naked":         drain.NotReplicated,
+		"local-storage": drain.LocalStorageRequested,
+		"protected":     drain.NotEnoughPdb,
+		"annotated":     drain.InitContainersRunning,
+	}, reasons)
+
+	// Without the blocking pods the node is drainable and nothing is reported.
+	assert.Empty(t, AuditPodsToMove(schedulercache.NewNodeInfo(replicated), true, true, false, nil, 0, nil))
+}
+
+func TestGetMovablePods(t *testing.T) {
+	ownerRefs := GenerateOwnerReferences("rs", "ReplicaSet", "extensions/v1beta1", "")
+
+	naked := BuildTestPod("naked", 100, 0)
+	replicated := BuildTestPod("replicated", 100, 0)
+	replicated.OwnerReferences = ownerRefs
+	daemonSetPod := BuildTestPod("ds", 100, 0)
+	daemonSetPod.OwnerReferences = GenerateOwnerReferences("ds", "DaemonSet", "extensions/v1beta1", "")
+
+	node := BuildTestNode("n1", 1000, 1000)
+	nodeInfo := schedulercache.NewNodeInfo(naked, replicated, daemonSetPod)
+	nodeInfo.SetNode(node)
+	assert.Equal(t, []*apiv1.Pod{replicated}, GetMovablePods(nodeInfo, nil))
+}
+
+func TestParseIgnoredPodSelectors(t *testing.T) {
+	selectors, err := parseIgnoredPodSelectors("ns=observability, label=app=node-agent,")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(selectors))
+
+	inNamespace := BuildTestPod("p1", 100, 0)
+	inNamespace.Namespace = "observability"
+	labeled := BuildTestPod("p2", 100, 0)
+	labeled.Labels = map[string]string{"app": "node-agent"}
+	other := BuildTestPod("p3", 100, 0)
+	other.Labels = map[string]string{"app": "web"}
+	assert.True(t, isIgnoredPod(inNamespace, selectors))
+	assert.True(t, isIgnoredPod(labeled, selectors))
+	assert.False(t, isIgnoredPod(other, selectors))
+
+	daemonSetPod := BuildTestPod("p4", 100, 0)
+	daemonSetPod.Namespace = "observability"
+	daemonSetPod.OwnerReferences = GenerateOwnerReferences("ds", "DaemonSet", "extensions/v1beta1", "")
+	assert.False(t, isIgnoredPod(daemonSetPod, selectors))
+
+	for _, value := range []string{"observability", "ns=", "node=n1", "label=app in"} {
+		_, err := parseIgnoredPodSelectors(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -146,7 +146,7 @@ func isNodeReadyAndSchedulablePredicate(pod *apiv1.Pod, meta algorithm.Predicate
 func NewTestPredicateChecker() *PredicateChecker {
 	return &PredicateChecker{
 		predicates: []predicateInfo{
-			{name: "default", predicate: predicates.GeneralPredicates},
+			{name: "GeneralPredicates", predicate: predicates.GeneralPredicates},
 			{name: "ready", predicate: isNodeReadyAndSchedulablePredicate},
 		},
 		predicateMetadataProducer: func(_ *apiv1.Pod, _ map[string]*schedulercache.NodeInfo) algorithm.PredicateMetadata {
@@ -183,11 +183,25 @@ func (p *PredicateChecker) GetPredicateMetadata(pod *apiv1.Pod, nodeInfos map[st
 
 // FitsAny checks if the given pod can be place on any of the given nodes.
 func (p *PredicateChecker) FitsAny(pod *apiv1.Pod, nodeInfos map[string]*schedulercache.NodeInfo) (string, error) {
+	return p.fitsAny(pod, nodeInfos, false)
+}
+
+// FitsAnyRebalanceTarget checks if the given pod can be placed on any of the given nodes that
+// is a rebalance target. Nodes that are not rebalance targets should still be passed in nodeInfos,
+// so that their pods are taken into account, they are just never picked as a destination.
+func (p *PredicateChecker) FitsAnyRebalanceTarget(pod *apiv1.Pod, nodeInfos map[string]*schedulercache.NodeInfo) (string, error) {
+	return p.fitsAny(pod, nodeInfos, true)
+}
+
+func (p *PredicateChecker) fitsAny(pod *apiv1.Pod, nodeInfos map[string]*schedulercache.NodeInfo, rebalanceTargetsOnly bool) (string, error) {
 	for name, nodeInfo := range nodeInfos {
 		// Be sure that the node is schedulable.
 		if nodeInfo.Node().Spec.Unschedulable {
 			continue
 		}
+		if rebalanceTargetsOnly && !IsRebalanceTarget(nodeInfo.Node()) {
+			continue
+		}
 		if err := p.CheckPredicates(pod, nil, nodeInfo, ReturnSimpleError); err == nil {
 			return name, nil
 		}
@@ -64,3 +64,37 @@ func TestPredicates(t *testing.T) {
 	assert.NoError(t, predicateChecker.CheckPredicates(p4, nil, ni2, ReturnVerboseError))
 	assert.Error(t, predicateChecker.CheckPredicates(p3, nil, ni2, ReturnVerboseError))
 }
+
+func TestFitsAnyRebalanceTarget(t *testing.T) {
+	p1 := BuildTestPod("p1", 450, 500000)
+	p2 := BuildTestPod("p2", 600, 500000)
+	p3 := BuildTestPod("p3", 500, 500000)
+
+	ni1 := schedulercache.NewNodeInfo(p1)
+	ni2 := schedulercache.NewNodeInfo()
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"n1": ni1,
+		"n2": ni2,
+	}
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	node2 := BuildTestNode("n2", 1000, 2000000)
+	node2.Annotations = map[string]string{NoRebalanceTargetAnnotationKey: "true"}
+	SetNodeReadyState(node1, true, time.Time{})
+	SetNodeReadyState(node2, true, time.Time{})
+
+	ni1.SetNode(node1)
+	ni2.SetNode(node2)
+
+	predicateChecker := NewTestPredicateChecker()
+
+	// p2 fits only on n2, which is not a rebalance target.
+	r1, err := predicateChecker.FitsAny(p2, nodeInfos)
+	assert.NoError(t, err)
+	assert.Equal(t, "n2", r1)
+	_, err = predicateChecker.FitsAnyRebalanceTarget(p2, nodeInfos)
+	assert.Error(t, err)
+
+	r3, err := predicateChecker.FitsAnyRebalanceTarget(p3, nodeInfos)
+	assert.NoError(t, err)
+	assert.Equal(t, "n1", r3)
+}
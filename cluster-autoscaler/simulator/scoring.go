@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	schedulerapi "k8s.io/kubernetes/plugin/pkg/scheduler/api"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// ScoringStrategy describes which of the nodes a pod fits on the scheduler prefers. It is used to
+// choose destinations of pods in simulations the same way the scheduler would.
+type ScoringStrategy string
+
+const (
+	// FirstFit puts a pod on the first node it fits on. It is used when the scheduler
+	// configuration is not known.
+	FirstFit ScoringStrategy = ""
+	// LeastAllocated puts a pod on the node with the smallest fraction of resources requested,
+	// spreading pods between nodes. It is the default of the scheduler.
+	LeastAllocated ScoringStrategy = "LeastAllocated"
+	// MostAllocated puts a pod on the node with the biggest fraction of resources requested,
+	// packing pods on as few nodes as possible.
+	MostAllocated ScoringStrategy = "MostAllocated"
+)
+
+const (
+	leastRequestedPriority = "LeastRequestedPriority"
+	mostRequestedPriority  = "MostRequestedPriority"
+)
+
+// LoadScoringStrategy reads a scheduler policy file, as passed to the scheduler with
+// --policy-config-file, and returns the scoring strategy it configures. MostAllocated is
+// returned if MostRequestedPriority has a bigger weight than LeastRequestedPriority.
+func LoadScoringStrategy(path string) (ScoringStrategy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return FirstFit, fmt.Errorf("failed to read scheduler configuration: %v", err)
+	}
+	return parseScoringStrategy(data)
+}
+
+func parseScoringStrategy(data []byte) (ScoringStrategy, error) {
+	var policy schedulerapi.Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return FirstFit, fmt.Errorf("failed to parse scheduler configuration: %v", err)
+	}
+	leastRequestedWeight := 0
+	mostRequestedWeight := 0
+	for _, priority := range policy.Priorities {
+		switch priority.Name {
+		case leastRequestedPriority:
+			leastRequestedWeight += priority.Weight
+		case mostRequestedPriority:
+			mostRequestedWeight += priority.Weight
+		}
+	}
+	if mostRequestedWeight > leastRequestedWeight {
+		return MostAllocated, nil
+	}
+	return LeastAllocated, nil
+}
+
+// AllocationScore returns the average fraction of allocatable cpu and memory of the node that is
+// requested by its pods, as computed by the scheduler's resource priorities.
+func AllocationScore(nodeInfo *schedulercache.NodeInfo) float64 {
+	allocatable := nodeInfo.AllocatableResource()
+	requested := nodeInfo.NonZeroRequest()
+	score := float64(0)
+	if allocatable.MilliCPU > 0 {
+		score += float64(requested.MilliCPU) / float64(allocatable.MilliCPU)
+	}
+	if allocatable.Memory > 0 {
+		score += float64(requested.Memory) / float64(allocatable.Memory)
+	}
+	return score / 2
+}
+
+// Prefers returns true if the strategy prefers a node with allocation score a over one with score b.
+// FirstFit doesn't prefer any node.
+func (s ScoringStrategy) Prefers(a, b float64) bool {
+	switch s {
+	case LeastAllocated:
+		return a < b
+	case MostAllocated:
+		return a > b
+	}
+	return false
+}
+
+// sortNodeNamesByStrategy stably sorts the names of nodes so that nodes preferred by the strategy
+// come first. Nodes without node infos are moved to the end.
+func sortNodeNamesByStrategy(strategy ScoringStrategy, names []string, nodeInfos map[string]*schedulercache.NodeInfo) {
+	if strategy == FirstFit {
+		return
+	}
+	scores := make(map[string]float64, len(names))
+	for _, name := range names {
+		if nodeInfo, found := nodeInfos[name]; found && nodeInfo.Node() != nil {
+			scores[name] = AllocationScore(nodeInfo)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		scoreI, foundI := scores[names[i]]
+		scoreJ, foundJ := scores[names[j]]
+		if foundI != foundJ {
+			return foundI
+		}
+		return strategy.Prefers(scoreI, scoreJ)
+	})
+}
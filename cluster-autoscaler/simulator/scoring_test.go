@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScoringStrategy(t *testing.T) {
+	strategy, err := parseScoringStrategy([]byte(`{
+		"kind": "Policy",
+		"apiVersion": "v1",
+		"priorities": [
+			{"name": "MostRequestedPriority", "weight": 2},
+			{"name": "SelectorSpreadPriority", "weight": 1}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, MostAllocated, strategy)
+
+	strategy, err = parseScoringStrategy([]byte(`{
+		"kind": "Policy",
+		"apiVersion": "v1",
+		"priorities": [
+			{"name": "MostRequestedPriority", "weight": 1},
+			{"name": "LeastRequestedPriority", "weight": 1}
+		]
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, LeastAllocated, strategy)
+
+	// Default scheduler configuration uses LeastRequestedPriority.
+	strategy, err = parseScoringStrategy([]byte(`{"kind": "Policy", "apiVersion": "v1"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, LeastAllocated, strategy)
+
+	_, err = parseScoringStrategy([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestSortNodeNamesByStrategy(t *testing.T) {
+	nodeInfos := make(map[string]*schedulercache.NodeInfo)
+	for name, cpu := range map[string]int64{"empty": 0, "half": 500, "full": 900} {
+		node := BuildTestNode(name, 1000, 1000000)
+		SetNodeReadyState(node, true, time.Time{})
+		nodeInfos[name] = schedulercache.NewNodeInfo(BuildTestPod("p-"+name, cpu, 0))
+		nodeInfos[name].SetNode(node)
+	}
+
+	names := []string{"half", "unknown", "full", "empty"}
+	sortNodeNamesByStrategy(FirstFit, names, nodeInfos)
+	assert.Equal(t, []string{"half", "unknown", "full", "empty"}, names)
+
+	sortNodeNamesByStrategy(MostAllocated, names, nodeInfos)
+	assert.Equal(t, []string{"full", "half", "empty", "unknown"}, names)
+
+	sortNodeNamesByStrategy(LeastAllocated, names, nodeInfos)
+	assert.Equal(t, []string{"empty", "half", "full", "unknown"}, names)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1helper "k8s.io/kubernetes/pkg/api/v1/helper"
+)
+
+const (
+	generalPredicatesName      = "GeneralPredicates"
+	matchNodeSelectorName      = "MatchNodeSelector"
+	podToleratesNodeTaintsName = "PodToleratesNodeTaints"
+)
+
+// CheckStaticPredicates checks only whether the pod's node selector and required node affinity match
+// the node labels and whether the pod tolerates the node's NoSchedule and NoExecute taints. Each check
+// is done only if CheckPredicates runs the corresponding predicate, so a pod failing CheckStaticPredicates
+// fails CheckPredicates as well. It's much cheaper than CheckPredicates and can be used to rule out
+// nodes before running all predicates.
+func (p *PredicateChecker) CheckStaticPredicates(pod *apiv1.Pod, node *apiv1.Node) error {
+	if p.hasPredicate(generalPredicatesName) || p.hasPredicate(matchNodeSelectorName) {
+		if !podMatchesNodeLabels(pod, node) {
+			return fmt.Errorf("node selector of %s/%s doesn't match %s", pod.Namespace, pod.Name, node.Name)
+		}
+	}
+	if p.hasPredicate(podToleratesNodeTaintsName) {
+		if !v1helper.TolerationsTolerateTaintsWithFilter(pod.Spec.Tolerations, node.Spec.Taints, func(t *apiv1.Taint) bool {
+			return t.Effect == apiv1.TaintEffectNoSchedule || t.Effect == apiv1.TaintEffectNoExecute
+		}) {
+			return fmt.Errorf("%s/%s doesn't tolerate taints of %s", pod.Namespace, pod.Name, node.Name)
+		}
+	}
+	return nil
+}
+
+func (p *PredicateChecker) hasPredicate(name string) bool {
+	for _, predInfo := range p.predicates {
+		if predInfo.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// podMatchesNodeLabels follows the node selector and required node affinity matching of
+// MatchNodeSelector scheduler predicate.
+func podMatchesNodeLabels(pod *apiv1.Pod, node *apiv1.Node) bool {
+	if len(pod.Spec.NodeSelector) > 0 {
+		if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+			return false
+		}
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		selector, err := v1helper.NodeSelectorRequirementsAsSelector(term.MatchExpressions)
+		if err != nil {
+			// Leave invalid terms to the full predicate check.
+			return true
+		}
+		if selector.Matches(labels.Set(node.Labels)) {
+			return true
+		}
+	}
+	return false
+}
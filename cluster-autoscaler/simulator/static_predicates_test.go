@@ -0,0 +1,188 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"testing"
+
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTaintPredicateChecker() *PredicateChecker {
+	return &PredicateChecker{
+		predicates: []predicateInfo{
+			{name: generalPredicatesName, predicate: predicates.GeneralPredicates},
+			{name: podToleratesNodeTaintsName, predicate: predicates.PodToleratesNodeTaints},
+		},
+	}
+}
+
+func buildStaticTestNode(name string, labels map[string]string, taints ...apiv1.Taint) *apiv1.Node {
+	node := BuildTestNode(name, 10000, 10000000)
+	for k, v := range labels {
+		node.Labels[k] = v
+	}
+	node.Spec.Taints = taints
+	return node
+}
+
+func buildStaticTestPod(name string, selector map[string]string, affinity *apiv1.NodeAffinity, tolerations ...apiv1.Toleration) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 1000)
+	pod.Spec.NodeSelector = selector
+	if affinity != nil {
+		pod.Spec.Affinity = &apiv1.Affinity{NodeAffinity: affinity}
+	}
+	pod.Spec.Tolerations = tolerations
+	return pod
+}
+
+func requiredNodeAffinity(terms ...apiv1.NodeSelectorTerm) *apiv1.NodeAffinity {
+	return &apiv1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{NodeSelectorTerms: terms},
+	}
+}
+
+func poolTerm(operator apiv1.NodeSelectorOperator, values ...string) apiv1.NodeSelectorTerm {
+	return apiv1.NodeSelectorTerm{
+		MatchExpressions: []apiv1.NodeSelectorRequirement{{Key: "pool", Operator: operator, Values: values}},
+	}
+}
+
+func TestCheckStaticPredicatesNoFalseNegatives(t *testing.T) {
+	dedicatedA := apiv1.Taint{Key: "dedicated", Value: "a", Effect: apiv1.TaintEffectNoSchedule}
+	nodes := []*apiv1.Node{
+		buildStaticTestNode("plain", nil),
+		buildStaticTestNode("pool-a", map[string]string{"pool": "a"}),
+		buildStaticTestNode("pool-a-dedicated", map[string]string{"pool": "a"}, dedicatedA),
+		buildStaticTestNode("pool-b-prefer", map[string]string{"pool": "b"},
+			apiv1.Taint{Key: "dedicated", Value: "b", Effect: apiv1.TaintEffectPreferNoSchedule}),
+		buildStaticTestNode("pool-b-noexecute", map[string]string{"pool": "b"},
+			apiv1.Taint{Key: "dedicated", Value: "b", Effect: apiv1.TaintEffectNoExecute}),
+	}
+	toleratesA := apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "a", Effect: apiv1.TaintEffectNoSchedule}
+	pods := []*apiv1.Pod{
+		buildStaticTestPod("plain", nil, nil),
+		buildStaticTestPod("selects-a", map[string]string{"pool": "a"}, nil),
+		buildStaticTestPod("selects-c", map[string]string{"pool": "c"}, nil),
+		buildStaticTestPod("tolerates-a", nil, nil, toleratesA),
+		buildStaticTestPod("tolerates-all", nil, nil, apiv1.Toleration{Operator: apiv1.TolerationOpExists}),
+		buildStaticTestPod("selects-a-tolerates-a", map[string]string{"pool": "a"}, nil, toleratesA),
+		buildStaticTestPod("selects-a-tolerates-other-key", map[string]string{"pool": "a"}, nil,
+			apiv1.Toleration{Key: "gpu", Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoSchedule}),
+		buildStaticTestPod("selects-a-tolerates-other-effect", map[string]string{"pool": "a"}, nil,
+			apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "a", Effect: apiv1.TaintEffectNoExecute}),
+		buildStaticTestPod("selects-b-tolerates-b", map[string]string{"pool": "b"}, nil,
+			apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpExists}),
+		buildStaticTestPod("affinity-in", nil, requiredNodeAffinity(poolTerm(apiv1.NodeSelectorOpIn, "a", "b"))),
+		buildStaticTestPod("affinity-not-in", nil, requiredNodeAffinity(poolTerm(apiv1.NodeSelectorOpNotIn, "a"))),
+		buildStaticTestPod("affinity-does-not-exist", nil, requiredNodeAffinity(poolTerm(apiv1.NodeSelectorOpDoesNotExist))),
+		buildStaticTestPod("affinity-second-term", nil, requiredNodeAffinity(
+			poolTerm(apiv1.NodeSelectorOpIn, "c"), poolTerm(apiv1.NodeSelectorOpIn, "b"))),
+		buildStaticTestPod("affinity-no-terms", nil, requiredNodeAffinity()),
+		buildStaticTestPod("affinity-and-selector", map[string]string{"pool": "b"},
+			requiredNodeAffinity(poolTerm(apiv1.NodeSelectorOpIn, "a")), apiv1.Toleration{Operator: apiv1.TolerationOpExists}),
+		buildStaticTestPod("preferred-affinity-only", nil, &apiv1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []apiv1.PreferredSchedulingTerm{
+				{Weight: 1, Preference: poolTerm(apiv1.NodeSelectorOpIn, "c")},
+			},
+		}),
+	}
+
+	checker := newTaintPredicateChecker()
+	rejected := 0
+	for _, node := range nodes {
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(node)
+		for _, pod := range pods {
+			fullErr := checker.CheckPredicates(pod, nil, nodeInfo, ReturnVerboseError)
+			staticErr := checker.CheckStaticPredicates(pod, node)
+			// Nodes have room for every pod, so only labels and taints decide whether it fits.
+			assert.Equal(t, fullErr == nil, staticErr == nil, "pod %s on node %s: full %v, static %v", pod.Name, node.Name, fullErr, staticErr)
+			if staticErr != nil {
+				rejected++
+			}
+		}
+	}
+	assert.True(t, rejected > 0)
+}
+
+func TestCheckStaticPredicatesFollowsConfiguredPredicates(t *testing.T) {
+	node := buildStaticTestNode("n1", map[string]string{"pool": "a"},
+		apiv1.Taint{Key: "dedicated", Value: "a", Effect: apiv1.TaintEffectNoSchedule})
+	selectsB := buildStaticTestPod("selects-b", map[string]string{"pool": "b"}, nil)
+	untolerating := buildStaticTestPod("untolerating", nil, nil)
+
+	// Taints aren't checked by the test predicate checker, so they can't be used to rule out nodes.
+	checker := NewTestPredicateChecker()
+	assert.Error(t, checker.CheckStaticPredicates(selectsB, node))
+	assert.NoError(t, checker.CheckStaticPredicates(untolerating, node))
+
+	checker = newTaintPredicateChecker()
+	assert.Error(t, checker.CheckStaticPredicates(untolerating, node))
+
+	checker = &PredicateChecker{}
+	assert.NoError(t, checker.CheckStaticPredicates(selectsB, node))
+	assert.NoError(t, checker.CheckStaticPredicates(untolerating, node))
+}
+
+// buildStaticPredicatesBenchmark builds templates of 80 node groups, each with a different pool label
+// and half of them tainted, and pods selecting and tolerating one of the pools.
+func buildStaticPredicatesBenchmark() ([]*schedulercache.NodeInfo, []*apiv1.Pod) {
+	nodeInfos := make([]*schedulercache.NodeInfo, 0)
+	pods := make([]*apiv1.Pod, 0)
+	for i := 0; i < 80; i++ {
+		pool := fmt.Sprintf("pool-%d", i)
+		var taints []apiv1.Taint
+		if i%2 == 1 {
+			taints = append(taints, apiv1.Taint{Key: "dedicated", Value: pool, Effect: apiv1.TaintEffectNoSchedule})
+		}
+		nodeInfo := schedulercache.NewNodeInfo()
+		nodeInfo.SetNode(buildStaticTestNode(pool, map[string]string{"pool": pool}, taints...))
+		nodeInfos = append(nodeInfos, nodeInfo)
+		if i%4 == 0 || i%4 == 1 {
+			pods = append(pods, buildStaticTestPod(fmt.Sprintf("p-%d", i), map[string]string{"pool": pool}, nil,
+				apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: pool, Effect: apiv1.TaintEffectNoSchedule}))
+		}
+	}
+	return nodeInfos, pods
+}
+
+func BenchmarkScaleUpPredicates80NodeGroups(b *testing.B) {
+	nodeInfos, pods := buildStaticPredicatesBenchmark()
+	checker := newTaintPredicateChecker()
+	for _, prefilter := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prefilter-%v", prefilter), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, nodeInfo := range nodeInfos {
+					for _, pod := range pods {
+						if prefilter && checker.CheckStaticPredicates(pod, nodeInfo.Node()) != nil {
+							continue
+						}
+						checker.CheckPredicates(pod, nil, nodeInfo, ReturnVerboseError)
+					}
+				}
+			}
+		})
+	}
+}
@@ -20,22 +20,106 @@ import (
 	"fmt"
 	"math/rand"
 
-	"k8s.io/autoscaler/cluster-autoscaler/simulator"
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 
 	apiv1 "k8s.io/api/core/v1"
 	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	v1helper "k8s.io/kubernetes/pkg/api/v1/helper"
+	"k8s.io/kubernetes/pkg/features"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	kubelettypes "k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
 )
 
-// GetDaemonSetPodsForNode returns daemonset nodes for the given pod.
-func GetDaemonSetPodsForNode(nodeInfo *schedulercache.NodeInfo, daemonsets []*extensionsv1.DaemonSet, predicateChecker *simulator.PredicateChecker) []*apiv1.Pod {
+// daemonSetTolerations are added to DaemonSet pods by the DaemonSet controller, so that they run on
+// nodes with conditions tainting the node.
+var daemonSetTolerations = []apiv1.Toleration{
+	{Key: algorithm.TaintNodeNotReady, Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoExecute},
+	{Key: algorithm.TaintNodeUnreachable, Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoExecute},
+	{Key: algorithm.TaintNodeDiskPressure, Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoSchedule},
+	{Key: algorithm.TaintNodeMemoryPressure, Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoSchedule},
+}
+
+// GetDaemonSetPodsForNode returns daemonset pods the DaemonSet controller would run on the given
+// node. The node is checked the way the DaemonSet controller checks it: for node name, node
+// selector, node affinity, taints and resources, but not for whether the node is ready or
+// schedulable, as DaemonSet pods run on cordoned nodes too.
+func GetDaemonSetPodsForNode(nodeInfo *schedulercache.NodeInfo, daemonsets []*extensionsv1.DaemonSet) []*apiv1.Pod {
 	result := make([]*apiv1.Pod, 0)
+	templateNodeInfo := withDefaultLabels(nodeInfo)
 	for _, ds := range daemonsets {
 		pod := newPod(ds, nodeInfo.Node().Name)
-		if err := predicateChecker.CheckPredicates(pod, nil, nodeInfo, simulator.ReturnSimpleError); err == nil {
-			result = append(result, pod)
+		if ds.Spec.Template.Spec.NodeName != "" && ds.Spec.Template.Spec.NodeName != nodeInfo.Node().Name {
+			continue
+		}
+		fits, reasons, err := daemonSetPredicates(pod, templateNodeInfo)
+		if err != nil {
+			glog.Warningf("Failed to check DaemonSet %s/%s on node %s: %v", ds.Namespace, ds.Name, nodeInfo.Node().Name, err)
+			continue
+		}
+		if !fits {
+			for _, reason := range reasons {
+				glog.V(5).Infof("DaemonSet %s/%s doesn't run on node %s: %s", ds.Namespace, ds.Name, nodeInfo.Node().Name, reason.GetReason())
+			}
+			continue
 		}
+		result = append(result, pod)
+	}
+	return result
+}
+
+// daemonSetPredicates checks if a DaemonSet pod would be run on the node by the DaemonSet
+// controller, using the same predicates.
+func daemonSetPredicates(pod *apiv1.Pod, nodeInfo *schedulercache.NodeInfo) (bool, []algorithm.PredicateFailureReason, error) {
+	var failures []algorithm.PredicateFailureReason
+	fits, reasons, err := predicates.PodToleratesNodeTaints(pod, nil, nodeInfo)
+	if err != nil {
+		return false, nil, err
+	}
+	if !fits {
+		failures = append(failures, reasons...)
 	}
+	if utilfeature.DefaultFeatureGate.Enabled(features.ExperimentalCriticalPodAnnotation) && kubelettypes.IsCriticalPod(pod) {
+		fits, reasons, err = predicates.EssentialPredicates(pod, nil, nodeInfo)
+	} else {
+		fits, reasons, err = predicates.GeneralPredicates(pod, nil, nodeInfo)
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	if !fits {
+		failures = append(failures, reasons...)
+	}
+	return len(failures) == 0, failures, nil
+}
+
+// withDefaultLabels returns the node info with OS and architecture labels set on the node if they
+// are missing. Kubelet sets them on every node it registers, so templates built by cloud providers
+// without them would otherwise miss DaemonSets selecting nodes by OS or architecture.
+func withDefaultLabels(nodeInfo *schedulercache.NodeInfo) *schedulercache.NodeInfo {
+	node := nodeInfo.Node()
+	_, hasOS := node.Labels[kubeletapis.LabelOS]
+	_, hasArch := node.Labels[kubeletapis.LabelArch]
+	if hasOS && hasArch {
+		return nodeInfo
+	}
+	node = node.DeepCopy()
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	if !hasOS {
+		node.Labels[kubeletapis.LabelOS] = cloudprovider.DefaultOS
+	}
+	if !hasArch {
+		node.Labels[kubeletapis.LabelArch] = cloudprovider.DefaultArch
+	}
+	result := nodeInfo.Clone()
+	result.SetNode(node)
 	return result
 }
 
@@ -44,5 +128,9 @@ func newPod(ds *extensionsv1.DaemonSet, nodeName string) *apiv1.Pod {
 	newPod.Namespace = ds.Namespace
 	newPod.Name = fmt.Sprintf("%s-pod-%d", ds.Name, rand.Int63())
 	newPod.Spec.NodeName = nodeName
+	newPod.Spec.Tolerations = append([]apiv1.Toleration{}, ds.Spec.Template.Spec.Tolerations...)
+	for i := range daemonSetTolerations {
+		v1helper.AddOrUpdateTolerationInPod(newPod, &daemonSetTolerations[i])
+	}
 	return newPod
 }
@@ -21,12 +21,14 @@ import (
 	"testing"
 	"time"
 
-	"k8s.io/autoscaler/cluster-autoscaler/simulator"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 
 	apiv1 "k8s.io/api/core/v1"
 	extensionsv1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeletapis "k8s.io/kubernetes/pkg/kubelet/apis"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/stretchr/testify/assert"
@@ -38,18 +40,103 @@ func TestGetDaemonSetPodsForNode(t *testing.T) {
 	nodeInfo := schedulercache.NewNodeInfo()
 	nodeInfo.SetNode(node)
 
-	predicateChecker := simulator.NewTestPredicateChecker()
 	ds1 := newDaemonSet("ds1")
 	ds2 := newDaemonSet("ds2")
 	ds2.Spec.Template.Spec.NodeSelector = map[string]string{"foo": "bar"}
 
-	pods := GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{ds1, ds2}, predicateChecker)
+	pods := GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{ds1, ds2})
 
 	assert.Equal(t, 1, len(pods))
 	assert.True(t, strings.HasPrefix(pods[0].Name, "ds1"))
-	assert.Equal(t, 1, len(GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{ds1}, predicateChecker)))
-	assert.Equal(t, 0, len(GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{ds2}, predicateChecker)))
-	assert.Equal(t, 0, len(GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{}, predicateChecker)))
+	assert.Equal(t, 1, len(GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{ds1})))
+	assert.Equal(t, 0, len(GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{ds2})))
+	assert.Equal(t, 0, len(GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{})))
+}
+
+func buildTestNodeInfo(node *apiv1.Node) *schedulercache.NodeInfo {
+	SetNodeReadyState(node, true, time.Now())
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	return nodeInfo
+}
+
+func daemonSetNames(pods []*apiv1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name[:strings.Index(pod.Name, "-pod-")])
+	}
+	return names
+}
+
+func TestGetDaemonSetPodsForNodeGpu(t *testing.T) {
+	const gpuLabel = "cloud.google.com/gke-accelerator"
+	gpuNode := BuildTestNode("gpu", 1000, 1000)
+	gpuNode.Labels[gpuLabel] = "nvidia-tesla-k80"
+	gpuNode.Spec.Taints = []apiv1.Taint{{Key: "nvidia.com/gpu", Value: "present", Effect: apiv1.TaintEffectNoSchedule}}
+	cpuNode := BuildTestNode("cpu", 1000, 1000)
+
+	driver := newDaemonSet("driver")
+	driver.Spec.Template.Spec.Affinity = &apiv1.Affinity{
+		NodeAffinity: &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{{
+					MatchExpressions: []apiv1.NodeSelectorRequirement{{Key: gpuLabel, Operator: apiv1.NodeSelectorOpExists}},
+				}},
+			},
+		},
+	}
+	driver.Spec.Template.Spec.Tolerations = []apiv1.Toleration{{Key: "nvidia.com/gpu", Operator: apiv1.TolerationOpExists}}
+	proxy := newDaemonSet("proxy")
+	daemonSets := []*extensionsv1.DaemonSet{driver, proxy}
+
+	assert.Equal(t, []string{"driver"}, daemonSetNames(GetDaemonSetPodsForNode(buildTestNodeInfo(gpuNode), daemonSets)))
+	assert.Equal(t, []string{"proxy"}, daemonSetNames(GetDaemonSetPodsForNode(buildTestNodeInfo(cpuNode), daemonSets)))
+}
+
+func TestGetDaemonSetPodsForNodeOS(t *testing.T) {
+	windows := newDaemonSet("windows")
+	windows.Spec.Template.Spec.NodeSelector = map[string]string{kubeletapis.LabelOS: "windows"}
+	linux := newDaemonSet("linux")
+	linux.Spec.Template.Spec.NodeSelector = map[string]string{kubeletapis.LabelOS: "linux"}
+	daemonSets := []*extensionsv1.DaemonSet{windows, linux}
+
+	windowsNode := BuildTestNode("windows", 1000, 1000)
+	windowsNode.Labels[kubeletapis.LabelOS] = "windows"
+	assert.Equal(t, []string{"windows"}, daemonSetNames(GetDaemonSetPodsForNode(buildTestNodeInfo(windowsNode), daemonSets)))
+
+	// Kubelet sets the OS label, so templates without it are treated as Linux nodes.
+	nodeInfo := buildTestNodeInfo(BuildTestNode("template", 1000, 1000))
+	assert.Equal(t, []string{"linux"}, daemonSetNames(GetDaemonSetPodsForNode(nodeInfo, daemonSets)))
+	assert.NotContains(t, nodeInfo.Node().Labels, kubeletapis.LabelOS)
+}
+
+func TestGetDaemonSetPodsForNodeTaints(t *testing.T) {
+	node := BuildTestNode("node", 1000, 1000)
+	node.Spec.Taints = []apiv1.Taint{
+		{Key: "dedicated", Value: "batch", Effect: apiv1.TaintEffectNoSchedule},
+		{Key: algorithm.TaintNodeNotReady, Effect: apiv1.TaintEffectNoExecute},
+	}
+	node.Spec.Unschedulable = true
+
+	tolerating := newDaemonSet("tolerating")
+	tolerating.Spec.Template.Spec.Tolerations = []apiv1.Toleration{{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "batch"}}
+	intolerant := newDaemonSet("intolerant")
+	wrongValue := newDaemonSet("wrong-value")
+	wrongValue.Spec.Template.Spec.Tolerations = []apiv1.Toleration{{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "web"}}
+
+	// DaemonSet pods tolerate not ready nodes and run on cordoned nodes.
+	pods := GetDaemonSetPodsForNode(buildTestNodeInfo(node), []*extensionsv1.DaemonSet{tolerating, intolerant, wrongValue})
+	assert.Equal(t, []string{"tolerating"}, daemonSetNames(pods))
+	assert.Len(t, tolerating.Spec.Template.Spec.Tolerations, 1)
+}
+
+func TestGetDaemonSetPodsForNodeResources(t *testing.T) {
+	nodeInfo := buildTestNodeInfo(BuildTestNode("node", 1000, 1000))
+	large := newDaemonSet("large")
+	large.Spec.Template.Spec.Containers[0].Resources.Requests = apiv1.ResourceList{
+		apiv1.ResourceCPU: *resource.NewMilliQuantity(2000, resource.DecimalSI),
+	}
+	assert.Empty(t, GetDaemonSetPodsForNode(nodeInfo, []*extensionsv1.DaemonSet{large}))
 }
 
 func newDaemonSet(name string) *extensionsv1.DaemonSet {
@@ -17,12 +17,15 @@ limitations under the License.
 package deletetaint
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	kube_client "k8s.io/client-go/kubernetes"
 
 	"github.com/golang/glog"
@@ -31,34 +34,40 @@ import (
 const (
 	// ToBeDeletedTaint is a taint used to make the node unschedulable.
 	ToBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+
+	// maxTaintPatchAttempts is the number of times a taint change is attempted when other
+	// controllers change the taints of the node concurrently.
+	maxTaintPatchAttempts = 5
 )
 
+// taintPatchRetryInterval is the time between attempts to change the taints of a node.
+var taintPatchRetryInterval = 200 * time.Millisecond
+
+// jsonPatchOperation is a single operation of a JSON patch (RFC 6902).
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
 // MarkToBeDeleted sets a taint that makes the node unschedulable.
 func MarkToBeDeleted(node *apiv1.Node, client kube_client.Interface) error {
-	// Get the newest version of the node.
-	freshNode, err := client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
-	if err != nil || freshNode == nil {
-		return fmt.Errorf("failed to get node %v: %v", node.Name, err)
-	}
-
-	added, err := addToBeDeletedTaint(freshNode)
-	if added == false {
-		return err
-	}
-	_, err = client.CoreV1().Nodes().Update(freshNode)
+	added, err := patchTaints(node, client, addToBeDeletedTaint)
 	if err != nil {
 		glog.Warningf("Error while adding taints on node %v: %v", node.Name, err)
 		return err
 	}
-	glog.V(1).Infof("Successfully added toBeDeletedTaint on node %v", node.Name)
+	if added {
+		glog.V(1).Infof("Successfully added toBeDeletedTaint on node %v", node.Name)
+	}
 	return nil
 }
 
-func addToBeDeletedTaint(node *apiv1.Node) (bool, error) {
+func addToBeDeletedTaint(node *apiv1.Node) bool {
 	for _, taint := range node.Spec.Taints {
 		if taint.Key == ToBeDeletedTaint {
-			glog.V(2).Infof("ToBeDeletedTaint already present on on node %v", taint, node.Name)
-			return false, nil
+			glog.V(2).Infof("ToBeDeletedTaint already present on node %v", node.Name)
+			return false
 		}
 	}
 	node.Spec.Taints = append(node.Spec.Taints, apiv1.Taint{
@@ -66,7 +75,7 @@ func addToBeDeletedTaint(node *apiv1.Node) (bool, error) {
 		Value:  fmt.Sprint(time.Now().Unix()),
 		Effect: apiv1.TaintEffectNoSchedule,
 	})
-	return true, nil
+	return true
 }
 
 // HasToBeDeletedTaint returns true if ToBeDeleted taint is applied on the node.
@@ -96,28 +105,102 @@ func GetToBeDeletedTime(node *apiv1.Node) (*time.Time, error) {
 
 // CleanToBeDeleted cleans ToBeDeleted taint.
 func CleanToBeDeleted(node *apiv1.Node, client kube_client.Interface) (bool, error) {
-	freshNode, err := client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
-	if err != nil || freshNode == nil {
-		return false, fmt.Errorf("failed to get node %v: %v", node.Name, err)
+	cleaned, err := patchTaints(node, client, removeToBeDeletedTaint)
+	if err != nil {
+		glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
+		return false, err
+	}
+	if cleaned {
+		glog.V(1).Infof("Successfully released toBeDeletedTaint on node %v", node.Name)
+	}
+	return cleaned, nil
+}
+
+// CleanStaleToBeDeleted cleans ToBeDeleted taint if it was added before the given time. The time
+// is checked on the current version of the node, so a taint added again in the meantime is kept.
+func CleanStaleToBeDeleted(node *apiv1.Node, client kube_client.Interface, addedBefore time.Time) (bool, error) {
+	cleaned, err := patchTaints(node, client, func(freshNode *apiv1.Node) bool {
+		deleteTime, err := GetToBeDeletedTime(freshNode)
+		if err != nil || deleteTime == nil || !deleteTime.Before(addedBefore) {
+			return false
+		}
+		return removeToBeDeletedTaint(freshNode)
+	})
+	if err != nil {
+		glog.Warningf("Error while releasing stale taints on node %v: %v", node.Name, err)
+		return false, err
 	}
+	if cleaned {
+		glog.V(1).Infof("Successfully released stale toBeDeletedTaint on node %v", node.Name)
+	}
+	return cleaned, nil
+}
+
+func removeToBeDeletedTaint(node *apiv1.Node) bool {
 	newTaints := make([]apiv1.Taint, 0)
-	for _, taint := range freshNode.Spec.Taints {
+	for _, taint := range node.Spec.Taints {
 		if taint.Key == ToBeDeletedTaint {
 			glog.V(1).Infof("Releasing taint %+v on node %v", taint, node.Name)
 		} else {
 			newTaints = append(newTaints, taint)
 		}
 	}
+	if len(newTaints) == len(node.Spec.Taints) {
+		return false
+	}
+	node.Spec.Taints = newTaints
+	return true
+}
 
-	if len(newTaints) != len(freshNode.Spec.Taints) {
-		freshNode.Spec.Taints = newTaints
-		_, err := client.CoreV1().Nodes().Update(freshNode)
+// patchTaints applies update to the newest version of the node and patches the taints of the node
+// if they were changed. The patch fails if the taints were changed by someone else since the node
+// was read, in which case the update is retried on a fresh copy of the node. Other fields of the
+// node may change freely. Returns true if the taints were patched.
+func patchTaints(node *apiv1.Node, client kube_client.Interface, update func(*apiv1.Node) bool) (bool, error) {
+	var lastErr error
+	for attempt := 0; attempt < maxTaintPatchAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(taintPatchRetryInterval)
+		}
+		// Get the newest version of the node.
+		freshNode, err := client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+		if err != nil || freshNode == nil {
+			return false, fmt.Errorf("failed to get node %v: %v", node.Name, err)
+		}
+		updatedNode := freshNode.DeepCopy()
+		if !update(updatedNode) {
+			return false, nil
+		}
+		patch, err := buildTaintsPatch(freshNode, updatedNode.Spec.Taints)
 		if err != nil {
-			glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
 			return false, err
 		}
-		glog.V(1).Infof("Successfully released toBeDeletedTaint on node %v", node.Name)
-		return true, nil
+		_, err = client.CoreV1().Nodes().Patch(node.Name, types.JSONPatchType, patch)
+		if err == nil {
+			return true, nil
+		}
+		if !kube_errors.IsConflict(err) && !kube_errors.IsInvalid(err) {
+			return false, err
+		}
+		glog.V(2).Infof("Taints of node %v changed concurrently, retrying: %v", node.Name, err)
+		lastErr = err
+	}
+	return false, fmt.Errorf("failed to patch taints of node %v after %d attempts: %v", node.Name, maxTaintPatchAttempts, lastErr)
+}
+
+// buildTaintsPatch returns a JSON patch replacing the taints of the node with newTaints. The test
+// operation makes the API server reject the patch if the taints changed since the node was read.
+// A node without taints has no taints to test, its resource version is tested instead.
+func buildTaintsPatch(node *apiv1.Node, newTaints []apiv1.Taint) ([]byte, error) {
+	operations := make([]jsonPatchOperation, 0, 2)
+	if len(node.Spec.Taints) > 0 {
+		operations = append(operations, jsonPatchOperation{Op: "test", Path: "/spec/taints", Value: node.Spec.Taints})
+	} else if node.ResourceVersion != "" {
+		operations = append(operations, jsonPatchOperation{Op: "test", Path: "/metadata/resourceVersion", Value: node.ResourceVersion})
+	}
+	if newTaints == nil {
+		newTaints = []apiv1.Taint{}
 	}
-	return false, nil
+	operations = append(operations, jsonPatchOperation{Op: "add", Path: "/spec/taints", Value: newTaints})
+	return json.Marshal(operations)
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package deletetaint
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -65,25 +66,149 @@ func TestCleanNodes(t *testing.T) {
 	assert.False(t, HasToBeDeletedTaint(node))
 }
 
+func TestMarkNodesConflictingUpdate(t *testing.T) {
+	taintPatchRetryInterval = 0
+	node := BuildTestNode("node", 1000, 1000)
+	node.ResourceVersion = "1"
+	fakeClient, updatedNodes := buildFakeClientAndUpdateChannel(node)
+	// Another controller adds its taint between our read and our patch, only once.
+	conflicts := 0
+	fakeClient.Fake.PrependReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		node.Spec.Taints = append(node.Spec.Taints, apiv1.Taint{Key: "other", Effect: apiv1.TaintEffectNoSchedule})
+		node.ResourceVersion = "2"
+		return false, nil, nil
+	})
+
+	err := MarkToBeDeleted(node, fakeClient)
+	assert.NoError(t, err)
+	assert.Equal(t, node.Name, getStringFromChan(updatedNodes))
+	assert.Equal(t, 1, conflicts)
+	// The taint of the other controller is kept.
+	assert.True(t, HasToBeDeletedTaint(node))
+	assert.Equal(t, 2, len(node.Spec.Taints))
+	assert.Equal(t, "other", node.Spec.Taints[0].Key)
+}
+
+func TestCleanNodesConflictingUpdate(t *testing.T) {
+	taintPatchRetryInterval = 0
+	node := BuildTestNode("node", 1000, 1000)
+	addToBeDeletedTaint(node)
+	fakeClient, updatedNodes := buildFakeClientAndUpdateChannel(node)
+	// Another controller changes the taints between our read and our patch, only once.
+	conflicts := 0
+	fakeClient.Fake.PrependReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		node.Spec.Taints = append(node.Spec.Taints, apiv1.Taint{Key: "other", Effect: apiv1.TaintEffectNoSchedule})
+		return false, nil, nil
+	})
+
+	cleaned, err := CleanToBeDeleted(node, fakeClient)
+	assert.True(t, cleaned)
+	assert.NoError(t, err)
+	assert.Equal(t, node.Name, getStringFromChan(updatedNodes))
+	assert.Equal(t, 1, conflicts)
+	assert.Equal(t, []apiv1.Taint{{Key: "other", Effect: apiv1.TaintEffectNoSchedule}}, node.Spec.Taints)
+}
+
+func TestMarkNodesPersistentConflict(t *testing.T) {
+	taintPatchRetryInterval = 0
+	node := BuildTestNode("node", 1000, 1000)
+	fakeClient, _ := buildFakeClientAndUpdateChannel(node)
+	fakeClient.Fake.PrependReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewConflict(apiv1.Resource("nodes"), node.Name, fmt.Errorf("taints changed"))
+	})
+
+	err := MarkToBeDeleted(node, fakeClient)
+	assert.Error(t, err)
+	assert.False(t, HasToBeDeletedTaint(node))
+	assert.Equal(t, maxTaintPatchAttempts, countActions(fakeClient, "patch"))
+}
+
+func TestCleanStaleNodes(t *testing.T) {
+	now := time.Now()
+	node := BuildTestNode("node", 1000, 1000)
+	node.Spec.Taints = []apiv1.Taint{{
+		Key:    ToBeDeletedTaint,
+		Value:  fmt.Sprint(now.Add(-time.Hour).Unix()),
+		Effect: apiv1.TaintEffectNoSchedule,
+	}}
+	fakeClient, updatedNodes := buildFakeClientAndUpdateChannel(node)
+
+	// The taint is fresh enough.
+	cleaned, err := CleanStaleToBeDeleted(node, fakeClient, now.Add(-2*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, cleaned)
+	assert.True(t, HasToBeDeletedTaint(node))
+
+	cleaned, err = CleanStaleToBeDeleted(node, fakeClient, now.Add(-30*time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, cleaned)
+	assert.Equal(t, node.Name, getStringFromChan(updatedNodes))
+	assert.False(t, HasToBeDeletedTaint(node))
+}
+
+func TestCleanStaleNodesRetainted(t *testing.T) {
+	now := time.Now()
+	// The informer still has the old taint, but the node was tainted again since then.
+	staleNode := BuildTestNode("node", 1000, 1000)
+	staleNode.Spec.Taints = []apiv1.Taint{{
+		Key:    ToBeDeletedTaint,
+		Value:  fmt.Sprint(now.Add(-time.Hour).Unix()),
+		Effect: apiv1.TaintEffectNoSchedule,
+	}}
+	node := BuildTestNode("node", 1000, 1000)
+	addToBeDeletedTaint(node)
+	fakeClient, _ := buildFakeClientAndUpdateChannel(node)
+
+	cleaned, err := CleanStaleToBeDeleted(staleNode, fakeClient, now.Add(-30*time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, cleaned)
+	assert.True(t, HasToBeDeletedTaint(node))
+	assert.Equal(t, 0, countActions(fakeClient, "patch"))
+}
+
+// buildFakeClientAndUpdateChannel builds a fake client storing the node. Patches are applied to the
+// stored node and names of patched nodes are sent to the returned channel.
 func buildFakeClientAndUpdateChannel(node *apiv1.Node) (*fake.Clientset, chan string) {
 	fakeClient := &fake.Clientset{}
 	updatedNodes := make(chan string, 10)
 	fakeClient.Fake.AddReactor("get", "nodes", func(action core.Action) (bool, runtime.Object, error) {
 		get := action.(core.GetAction)
 		if get.GetName() == node.Name {
-			return true, node, nil
+			return true, node.DeepCopy(), nil
 		}
 		return true, nil, errors.NewNotFound(apiv1.Resource("node"), get.GetName())
 	})
-	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
-		update := action.(core.UpdateAction)
-		obj := update.GetObject().(*apiv1.Node)
-		updatedNodes <- obj.Name
-		return true, obj, nil
+	fakeClient.Fake.AddReactor("patch", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		patch := action.(core.PatchAction)
+		patched, err := ApplyJSONPatchToNode(node, patch.GetPatch())
+		if err != nil {
+			return true, nil, err
+		}
+		*node = *patched
+		updatedNodes <- patch.GetName()
+		return true, node, nil
 	})
 	return fakeClient, updatedNodes
 }
 
+func countActions(fakeClient *fake.Clientset, verb string) int {
+	count := 0
+	for _, action := range fakeClient.Actions() {
+		if action.GetVerb() == verb {
+			count++
+		}
+	}
+	return count
+}
+
 func getStringFromChan(c chan string) string {
 	select {
 	case val := <-c:
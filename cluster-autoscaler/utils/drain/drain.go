@@ -18,10 +18,13 @@ package drain
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1beta1"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	client "k8s.io/client-go/kubernetes"
@@ -37,8 +40,95 @@ const (
 	// PodSafeToEvictKey - annotation that ignores constraints to evict a pod like not being replicated, being on
 	// kube-system namespace or having a local storage.
 	PodSafeToEvictKey = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+	// PodDeferEvictionDuringInitKey - annotation preventing eviction of a pod while its init containers are
+	// running, e.g. because they preload data that would have to be loaded again.
+	PodDeferEvictionDuringInitKey = "cluster-autoscaler.kubernetes.io/defer-eviction-during-init"
+	// PodDoNotDisruptUntilKey - annotation with an RFC3339 time until which a pod must not be evicted, e.g.
+	// because of an interactive session or a job without checkpoints.
+	PodDoNotDisruptUntilKey = "cluster-autoscaler.kubernetes.io/do-not-disrupt-until"
 )
 
+// BlockingPodReason describes why a pod prevents the node from being drained.
+type BlockingPodReason string
+
+const (
+	// ControllerNotFound means the controller of the pod could not be found.
+	ControllerNotFound BlockingPodReason = "controller_not_found"
+	// MinReplicasReached means the controller of the pod has too few replicas.
+	MinReplicasReached BlockingPodReason = "min_replicas_reached"
+	// NotReplicated means the pod has no controller recreating it elsewhere.
+	NotReplicated BlockingPodReason = "not_replicated"
+	// UnmovableKubeSystemPod means the pod runs in kube-system and has no PDB.
+	UnmovableKubeSystemPod BlockingPodReason = "kube_system_pod"
+	// LocalStorageRequested means the pod uses local storage.
+	LocalStorageRequested BlockingPodReason = "local_storage"
+	// NotEnoughPdb means a PDB of the pod doesn't allow any more disruptions.
+	NotEnoughPdb BlockingPodReason = "pdb"
+	// InitContainersRunning means the pod is running init containers whose work would be lost.
+	InitContainersRunning BlockingPodReason = "init_containers_running"
+	// RestartBudgetExhausted means evicting the pod would exceed the daily restart budget of its controller.
+	RestartBudgetExhausted BlockingPodReason = "restart_budget_exhausted"
+	// DoNotDisruptUntil means the pod must not be disrupted until the time in its annotation.
+	DoNotDisruptUntil BlockingPodReason = "do_not_disrupt_until"
+	// UnexpectedError means the drain could not be checked.
+	UnexpectedError BlockingPodReason = "unexpected_error"
+)
+
+// BlockingPodError is returned when a pod prevents the node from being drained.
+type BlockingPodError struct {
+	// Reason is why the pod blocks the drain.
+	Reason BlockingPodReason
+	// Message is a human readable description including the pod name.
+	Message string
+}
+
+// Error implements error.
+func (e *BlockingPodError) Error() string {
+	return e.Message
+}
+
+// NewBlockingPodError builds a BlockingPodError with a formatted message.
+func NewBlockingPodError(reason BlockingPodReason, format string, args ...interface{}) error {
+	return &BlockingPodError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// BlockingReason returns the reason carried by a BlockingPodError or UnexpectedError for other errors.
+func BlockingReason(err error) BlockingPodReason {
+	if blockingErr, ok := err.(*BlockingPodError); ok {
+		return blockingErr.Reason
+	}
+	return UnexpectedError
+}
+
+// BlockingPod is a pod preventing the node from being drained, together with the rule blocking it.
+type BlockingPod struct {
+	Pod    *apiv1.Pod
+	Reason BlockingPodReason
+	// Detail is the message of the rule, e.g. naming the PDB or the missing controller.
+	Detail string
+}
+
+// SummarizeBlockingPods groups blocking pods by reason, e.g.
+// "local_storage (2): default/p1, default/p2; pdb (1): default/p3". Reasons are sorted by name.
+func SummarizeBlockingPods(blockingPods []BlockingPod) string {
+	podsByReason := make(map[BlockingPodReason][]string)
+	for _, blockingPod := range blockingPods {
+		podsByReason[blockingPod.Reason] = append(podsByReason[blockingPod.Reason],
+			fmt.Sprintf("%s/%s", blockingPod.Pod.Namespace, blockingPod.Pod.Name))
+	}
+	reasons := make([]string, 0, len(podsByReason))
+	for reason := range podsByReason {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	groups := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		pods := podsByReason[BlockingPodReason(reason)]
+		groups = append(groups, fmt.Sprintf("%s (%d): %s", reason, len(pods), strings.Join(pods, ", ")))
+	}
+	return strings.Join(groups, "; ")
+}
+
 // GetPodsForDeletionOnNodeDrain returns pods that should be deleted on node drain as well as some extra information
 // about possibly problematic pods (unreplicated and daemonsets).
 func GetPodsForDeletionOnNodeDrain(
@@ -96,13 +186,13 @@ func GetPodsForDeletionOnNodeDrain(
 				// TODO: replace the minReplica check with pod disruption budget.
 				if err == nil && rc != nil {
 					if rc.Spec.Replicas != nil && *rc.Spec.Replicas < minReplica {
-						return []*apiv1.Pod{}, fmt.Errorf("replication controller for %s/%s has too few replicas spec: %d min: %d",
+						return []*apiv1.Pod{}, NewBlockingPodError(MinReplicasReached, "replication controller for %s/%s has too few replicas spec: %d min: %d",
 							pod.Namespace, pod.Name, rc.Spec.Replicas, minReplica)
 					}
 					replicated = true
 
 				} else {
-					return []*apiv1.Pod{}, fmt.Errorf("replication controller for %s/%s is not available, err: %v", pod.Namespace, pod.Name, err)
+					return []*apiv1.Pod{}, NewBlockingPodError(ControllerNotFound, "replication controller for %s/%s is not available, err: %v", pod.Namespace, pod.Name, err)
 				}
 			} else {
 				replicated = true
@@ -120,8 +210,11 @@ func GetPodsForDeletionOnNodeDrain(
 					// FIXME(mml): Add link to the issue concerning a proper way to drain
 					// daemonset pods, probably using taints.
 					daemonsetPod = true
+				} else if kube_errors.IsNotFound(err) && IsTerminatingDaemonSetPod(pod) {
+					// The pod is being deleted together with its DaemonSet, there is nothing to evict.
+					daemonsetPod = true
 				} else {
-					return []*apiv1.Pod{}, fmt.Errorf("daemonset for %s/%s is not present, err: %v", pod.Namespace, pod.Name, err)
+					return []*apiv1.Pod{}, NewBlockingPodError(ControllerNotFound, "daemonset for %s/%s is not present, err: %v", pod.Namespace, pod.Name, err)
 				}
 			} else {
 				daemonsetPod = true
@@ -136,7 +229,7 @@ func GetPodsForDeletionOnNodeDrain(
 				if err == nil && job != nil {
 					replicated = true
 				} else {
-					return []*apiv1.Pod{}, fmt.Errorf("job for %s/%s is not available: err: %v", pod.Namespace, pod.Name, err)
+					return []*apiv1.Pod{}, NewBlockingPodError(ControllerNotFound, "job for %s/%s is not available: err: %v", pod.Namespace, pod.Name, err)
 				}
 			} else {
 				replicated = true
@@ -150,12 +243,12 @@ func GetPodsForDeletionOnNodeDrain(
 				// sophisticated than this
 				if err == nil && rs != nil {
 					if rs.Spec.Replicas != nil && *rs.Spec.Replicas < minReplica {
-						return []*apiv1.Pod{}, fmt.Errorf("replication controller for %s/%s has too few replicas spec: %d min: %d",
+						return []*apiv1.Pod{}, NewBlockingPodError(MinReplicasReached, "replication controller for %s/%s has too few replicas spec: %d min: %d",
 							pod.Namespace, pod.Name, rs.Spec.Replicas, minReplica)
 					}
 					replicated = true
 				} else {
-					return []*apiv1.Pod{}, fmt.Errorf("replication controller for %s/%s is not available, err: %v", pod.Namespace, pod.Name, err)
+					return []*apiv1.Pod{}, NewBlockingPodError(ControllerNotFound, "replication controller for %s/%s is not available, err: %v", pod.Namespace, pod.Name, err)
 				}
 			} else {
 				replicated = true
@@ -170,7 +263,7 @@ func GetPodsForDeletionOnNodeDrain(
 				if err == nil && ss != nil {
 					replicated = true
 				} else {
-					return []*apiv1.Pod{}, fmt.Errorf("statefulset for %s/%s is not available: err: %v", pod.Namespace, pod.Name, err)
+					return []*apiv1.Pod{}, NewBlockingPodError(ControllerNotFound, "statefulset for %s/%s is not available: err: %v", pod.Namespace, pod.Name, err)
 				}
 			} else {
 				replicated = true
@@ -181,19 +274,19 @@ func GetPodsForDeletionOnNodeDrain(
 		}
 		if !deleteAll && !safeToEvict {
 			if !replicated {
-				return []*apiv1.Pod{}, fmt.Errorf("%s/%s is not replicated", pod.Namespace, pod.Name)
+				return []*apiv1.Pod{}, NewBlockingPodError(NotReplicated, "%s/%s is not replicated", pod.Namespace, pod.Name)
 			}
 			if pod.Namespace == "kube-system" && skipNodesWithSystemPods {
 				hasPDB, err := checkKubeSystemPDBs(pod, kubeSystemPDBs)
 				if err != nil {
-					return []*apiv1.Pod{}, fmt.Errorf("error matching pods to pdbs: %v", err)
+					return []*apiv1.Pod{}, NewBlockingPodError(UnexpectedError, "error matching pods to pdbs: %v", err)
 				}
 				if !hasPDB {
-					return []*apiv1.Pod{}, fmt.Errorf("non-daemonset, non-mirrored, non-pdb-assigned kube-system pod present: %s", pod.Name)
+					return []*apiv1.Pod{}, NewBlockingPodError(UnmovableKubeSystemPod, "non-daemonset, non-mirrored, non-pdb-assigned kube-system pod present: %s", pod.Name)
 				}
 			}
 			if HasLocalStorage(pod) && skipNodesWithLocalStorage {
-				return []*apiv1.Pod{}, fmt.Errorf("pod with local storage present: %s", pod.Name)
+				return []*apiv1.Pod{}, NewBlockingPodError(LocalStorageRequested, "pod with local storage present: %s", pod.Name)
 			}
 		}
 		pods = append(pods, pod)
@@ -206,12 +299,52 @@ func ControllerRef(pod *apiv1.Pod) *metav1.OwnerReference {
 	return metav1.GetControllerOf(pod)
 }
 
+// IsTerminatingDaemonSetPod returns true if the pod belongs to a DaemonSet and is being deleted. If the
+// DaemonSet no longer exists, the pod is going away with it and shouldn't be evicted or counted as
+// load of the node.
+func IsTerminatingDaemonSetPod(pod *apiv1.Pod) bool {
+	controllerRef := ControllerRef(pod)
+	return controllerRef != nil && controllerRef.Kind == "DaemonSet" && pod.DeletionTimestamp != nil
+}
+
 // IsMirrorPod checks whether the pod is a mirror pod.
 func IsMirrorPod(pod *apiv1.Pod) bool {
 	_, found := pod.ObjectMeta.Annotations[types.ConfigMirrorAnnotationKey]
 	return found
 }
 
+// IsInitializing returns true if the pod has started, but its init containers haven't finished yet.
+func IsInitializing(pod *apiv1.Pod) bool {
+	if len(pod.Spec.InitContainers) == 0 || pod.Status.StartTime == nil || pod.Status.Phase != apiv1.PodPending {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiv1.PodInitialized {
+			return condition.Status != apiv1.ConditionTrue
+		}
+	}
+	return true
+}
+
+// HasDeferEvictionDuringInitAnnotation checks if the pod asks not to be evicted while initializing.
+func HasDeferEvictionDuringInitAnnotation(pod *apiv1.Pod) bool {
+	return pod.GetAnnotations()[PodDeferEvictionDuringInitKey] == "true"
+}
+
+// GetDoNotDisruptUntil returns the time until which the pod asks not to be disrupted and whether the
+// pod has the annotation. An error is returned if the annotation isn't an RFC3339 time.
+func GetDoNotDisruptUntil(pod *apiv1.Pod) (time.Time, bool, error) {
+	value, found := pod.GetAnnotations()[PodDoNotDisruptUntilKey]
+	if !found {
+		return time.Time{}, false, nil
+	}
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("invalid %s annotation %q of pod %s/%s: %v", PodDoNotDisruptUntilKey, value, pod.Namespace, pod.Name, err)
+	}
+	return deadline, true, nil
+}
+
 // HasLocalStorage returns true if pod has any local storage.
 func HasLocalStorage(pod *apiv1.Pod) bool {
 	for _, volume := range pod.Spec.Volumes {
@@ -26,12 +26,15 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	policyv1 "k8s.io/api/policy/v1beta1"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
 	"k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/kubernetes/pkg/api/testapi"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDrain(t *testing.T) {
@@ -459,3 +462,46 @@ func TestDrain(t *testing.T) {
 		}
 	}
 }
+
+func TestSummarizeBlockingPods(t *testing.T) {
+	p1 := BuildTestPod("p1", 100, 0)
+	p2 := BuildTestPod("p2", 100, 0)
+	p3 := BuildTestPod("p3", 100, 0)
+
+	summary := SummarizeBlockingPods([]BlockingPod{
+		{Pod: p1, Reason: NotEnoughPdb, Detail: "no enough pod disruption budget to move default/p1"},
+		{Pod: p2, Reason: LocalStorageRequested, Detail: "pod with local storage present: p2"},
+		{Pod: p3, Reason: NotEnoughPdb, Detail: "no enough pod disruption budget to move default/p3"},
+	})
+	assert.Equal(t, "local_storage (1): default/p2; pdb (2): default/p1, default/p3", summary)
+	assert.Equal(t, "", SummarizeBlockingPods(nil))
+}
+
+func TestDrainOrphanedDaemonSetPod(t *testing.T) {
+	buildDaemonSetPod := func(name string, terminating bool) *apiv1.Pod {
+		pod := BuildTestPod(name, 100, 0)
+		pod.OwnerReferences = GenerateOwnerReferences("deleted-ds", "DaemonSet", "extensions/v1beta1", "")
+		if terminating {
+			deletionTime := metav1.NewTime(time.Now().Add(-time.Minute))
+			pod.DeletionTimestamp = &deletionTime
+		}
+		return pod
+	}
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("get", "daemonsets", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, kube_errors.NewNotFound(extensions.Resource("daemonsets"), action.(core.GetAction).GetName())
+	})
+
+	// The only pod of the node belongs to a deleted DaemonSet and is terminating.
+	orphaned := buildDaemonSetPod("orphaned", true)
+	assert.True(t, IsTerminatingDaemonSetPod(orphaned))
+	pods, err := GetPodsForDeletionOnNodeDrain([]*apiv1.Pod{orphaned}, nil, false, true, true, true, fakeClient, 0, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, pods)
+
+	// A pod of a missing DaemonSet that isn't being deleted still blocks the drain.
+	running := buildDaemonSetPod("running", false)
+	assert.False(t, IsTerminatingDaemonSetPod(running))
+	_, err = GetPodsForDeletionOnNodeDrain([]*apiv1.Pod{running}, nil, false, true, true, true, fakeClient, 0, time.Now())
+	assert.Equal(t, ControllerNotFound, BlockingReason(err))
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpu
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// detectUnreadyOption is the option of an accelerator flag value enabling detection of nodes whose
+	// accelerators aren't ready.
+	detectUnreadyOption = "detect-unready"
+	// unreadyAcceleratorReason is the reason of the Ready condition of nodes whose accelerators aren't ready.
+	unreadyAcceleratorReason = "AcceleratorNotReady"
+)
+
+// Accelerator is an extended resource of accelerator devices, such as GPUs, exposed by a device plugin.
+// Accelerators are the most expensive resource of nodes that have them, so GPU-specific logic applies
+// to all of them.
+type Accelerator struct {
+	// ResourceName is the name of the resource exposed by the device plugin, e.g. amd.com/gpu.
+	ResourceName apiv1.ResourceName
+	// NodeLabel is a label set on nodes with the accelerator, also before the device plugin exposes
+	// the resource. Empty if there's no such label.
+	NodeLabel string
+	// DetectUnready treats nodes with NodeLabel that don't have the resource allocatable yet as unready.
+	DetectUnready bool
+}
+
+// defaultAccelerators are Nvidia GPUs, under the resource name of the device plugin and the legacy one.
+var defaultAccelerators = []Accelerator{
+	{ResourceName: ResourceNvidiaGPU},
+	{ResourceName: apiv1.ResourceNvidiaGPU},
+}
+
+// accelerators are the accelerators in use, the default ones followed by the registered ones.
+var accelerators = defaultAccelerators
+
+// RegisterAccelerators sets accelerators used in addition to Nvidia GPUs. It's meant to be called on
+// startup, before any of the accelerators are used.
+func RegisterAccelerators(registered []Accelerator) {
+	result := make([]Accelerator, 0, len(defaultAccelerators)+len(registered))
+	result = append(result, defaultAccelerators...)
+	for _, accelerator := range registered {
+		if accelerator.ResourceName == ResourceNvidiaGPU || accelerator.ResourceName == apiv1.ResourceNvidiaGPU {
+			// Allows adding a label and unready detection to Nvidia GPUs.
+			result = removeAccelerator(result, accelerator.ResourceName)
+		}
+		result = append(result, accelerator)
+	}
+	accelerators = result
+}
+
+// Accelerators returns the accelerators in use.
+func Accelerators() []Accelerator {
+	return accelerators
+}
+
+func removeAccelerator(accelerators []Accelerator, resourceName apiv1.ResourceName) []Accelerator {
+	result := make([]Accelerator, 0, len(accelerators))
+	for _, accelerator := range accelerators {
+		if accelerator.ResourceName != resourceName {
+			result = append(result, accelerator)
+		}
+	}
+	return result
+}
+
+// ParseAccelerator parses an accelerator in <resource name>[:<node label>[:detect-unready]] format,
+// e.g. amd.com/gpu:beta.amd.com/gpu.family:detect-unready.
+func ParseAccelerator(value string) (Accelerator, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return Accelerator{}, fmt.Errorf("wrong accelerator %q, expected <resource name>[:<node label>[:%s]]", value, detectUnreadyOption)
+	}
+	accelerator := Accelerator{ResourceName: apiv1.ResourceName(parts[0])}
+	if len(parts) > 1 {
+		accelerator.NodeLabel = parts[1]
+	}
+	if len(parts) > 2 {
+		if parts[2] != detectUnreadyOption {
+			return Accelerator{}, fmt.Errorf("wrong accelerator %q, unknown option %q", value, parts[2])
+		}
+		if accelerator.NodeLabel == "" {
+			return Accelerator{}, fmt.Errorf("wrong accelerator %q, %s requires a node label", value, detectUnreadyOption)
+		}
+		accelerator.DetectUnready = true
+	}
+	return accelerator, nil
+}
+
+// FilterOutNodesWithUnreadyAccelerators removes nodes labeled with an accelerator with unready
+// detection that don't have the accelerator allocatable yet from ready nodes, and replaces them
+// with unready copies in all nodes. Until the device plugin exposes the accelerators, such nodes
+// can't run the pods they were added for, and CA would add more nodes for these pods.
+func FilterOutNodesWithUnreadyAccelerators(allNodes, readyNodes []*apiv1.Node) ([]*apiv1.Node, []*apiv1.Node) {
+	unreadyCopies := make(map[string]*apiv1.Node)
+	newReadyNodes := make([]*apiv1.Node, 0, len(readyNodes))
+	for _, node := range readyNodes {
+		if accelerator, unready := unreadyAccelerator(node); unready {
+			glog.V(3).Infof("Node %s has %s label, but no %s allocatable yet, treating it as unready",
+				node.Name, accelerator.NodeLabel, accelerator.ResourceName)
+			unreadyCopies[node.Name] = unreadyNodeCopy(node, accelerator)
+			continue
+		}
+		newReadyNodes = append(newReadyNodes, node)
+	}
+	if len(unreadyCopies) == 0 {
+		return allNodes, readyNodes
+	}
+	newAllNodes := make([]*apiv1.Node, 0, len(allNodes))
+	for _, node := range allNodes {
+		if unreadyCopy, found := unreadyCopies[node.Name]; found {
+			newAllNodes = append(newAllNodes, unreadyCopy)
+		} else {
+			newAllNodes = append(newAllNodes, node)
+		}
+	}
+	return newAllNodes, newReadyNodes
+}
+
+func unreadyAccelerator(node *apiv1.Node) (Accelerator, bool) {
+	for _, accelerator := range accelerators {
+		if !accelerator.DetectUnready {
+			continue
+		}
+		if _, found := node.Labels[accelerator.NodeLabel]; !found {
+			continue
+		}
+		if allocatable, found := node.Status.Allocatable[accelerator.ResourceName]; !found || allocatable.IsZero() {
+			return accelerator, true
+		}
+	}
+	return Accelerator{}, false
+}
+
+func unreadyNodeCopy(node *apiv1.Node, accelerator Accelerator) *apiv1.Node {
+	result := node.DeepCopy()
+	for i := range result.Status.Conditions {
+		if result.Status.Conditions[i].Type == apiv1.NodeReady {
+			result.Status.Conditions[i].Status = apiv1.ConditionFalse
+			result.Status.Conditions[i].Reason = unreadyAcceleratorReason
+			result.Status.Conditions[i].Message = fmt.Sprintf("%s not allocatable yet", accelerator.ResourceName)
+			result.Status.Conditions[i].LastTransitionTime = metav1.NewTime(result.CreationTimestamp.Time)
+		}
+	}
+	return result
+}
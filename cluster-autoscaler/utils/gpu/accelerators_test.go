@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpu
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccelerator(t *testing.T) {
+	accelerator, err := ParseAccelerator("amd.com/gpu")
+	assert.NoError(t, err)
+	assert.Equal(t, Accelerator{ResourceName: "amd.com/gpu"}, accelerator)
+
+	accelerator, err = ParseAccelerator("aws.amazon.com/neuron:neuron-family")
+	assert.NoError(t, err)
+	assert.Equal(t, Accelerator{ResourceName: "aws.amazon.com/neuron", NodeLabel: "neuron-family"}, accelerator)
+
+	accelerator, err = ParseAccelerator("amd.com/gpu:amd-gpu:detect-unready")
+	assert.NoError(t, err)
+	assert.Equal(t, Accelerator{ResourceName: "amd.com/gpu", NodeLabel: "amd-gpu", DetectUnready: true}, accelerator)
+
+	for _, value := range []string{"", ":label", "amd.com/gpu:label:detect-unready:x", "amd.com/gpu:label:unknown", "amd.com/gpu::detect-unready"} {
+		_, err = ParseAccelerator(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRegisterAccelerators(t *testing.T) {
+	defer RegisterAccelerators(nil)
+
+	RegisterAccelerators([]Accelerator{{ResourceName: "amd.com/gpu"}})
+	assert.Equal(t, []Accelerator{
+		{ResourceName: ResourceNvidiaGPU},
+		{ResourceName: apiv1.ResourceNvidiaGPU},
+		{ResourceName: "amd.com/gpu"},
+	}, Accelerators())
+
+	RegisterAccelerators([]Accelerator{{ResourceName: ResourceNvidiaGPU, NodeLabel: "nvidia-gpu", DetectUnready: true}})
+	assert.Equal(t, []Accelerator{
+		{ResourceName: apiv1.ResourceNvidiaGPU},
+		{ResourceName: ResourceNvidiaGPU, NodeLabel: "nvidia-gpu", DetectUnready: true},
+	}, Accelerators())
+
+	RegisterAccelerators(nil)
+	assert.Equal(t, defaultAccelerators, Accelerators())
+}
+
+func TestGetGpuCountRegisteredAccelerator(t *testing.T) {
+	defer RegisterAccelerators(nil)
+	resources := apiv1.ResourceList{
+		ResourceNvidiaGPU: *resource.NewQuantity(2, resource.DecimalSI),
+		"amd.com/gpu":     *resource.NewQuantity(4, resource.DecimalSI),
+	}
+	assert.Equal(t, int64(2), GetGpuCount(resources))
+
+	RegisterAccelerators([]Accelerator{{ResourceName: "amd.com/gpu"}})
+	assert.Equal(t, int64(6), GetGpuCount(resources))
+}
+
+func TestFilterOutNodesWithUnreadyAccelerators(t *testing.T) {
+	defer RegisterAccelerators(nil)
+	RegisterAccelerators([]Accelerator{
+		{ResourceName: "amd.com/gpu", NodeLabel: "amd-gpu", DetectUnready: true},
+		{ResourceName: "aws.amazon.com/neuron", NodeLabel: "neuron"},
+	})
+	created := time.Now().Add(-time.Minute)
+
+	// Labeled, but no GPU allocatable yet.
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Labels["amd-gpu"] = "mi100"
+	n1.CreationTimestamp.Time = created
+	SetNodeReadyState(n1, true, time.Now())
+	// Labeled, with GPU allocatable.
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Labels["amd-gpu"] = "mi100"
+	n2.Status.Allocatable["amd.com/gpu"] = *resource.NewQuantity(1, resource.DecimalSI)
+	SetNodeReadyState(n2, true, time.Now())
+	// Labeled with an accelerator without unready detection.
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n3.Labels["neuron"] = "inf1"
+	SetNodeReadyState(n3, true, time.Now())
+	// Not labeled.
+	n4 := BuildTestNode("n4", 1000, 1000)
+	SetNodeReadyState(n4, true, time.Now())
+
+	allNodes, readyNodes := FilterOutNodesWithUnreadyAccelerators([]*apiv1.Node{n1, n2, n3, n4}, []*apiv1.Node{n1, n2, n3, n4})
+	assert.Equal(t, []*apiv1.Node{n2, n3, n4}, readyNodes)
+	assert.Len(t, allNodes, 4)
+	assert.Equal(t, "n1", allNodes[0].Name)
+	assert.Equal(t, []*apiv1.Node{n2, n3, n4}, allNodes[1:])
+	condition := allNodes[0].Status.Conditions[0]
+	assert.Equal(t, apiv1.NodeReady, condition.Type)
+	assert.Equal(t, apiv1.ConditionFalse, condition.Status)
+	assert.Equal(t, unreadyAcceleratorReason, condition.Reason)
+	assert.Equal(t, created, condition.LastTransitionTime.Time)
+	// The original node isn't modified.
+	assert.Equal(t, apiv1.ConditionTrue, n1.Status.Conditions[0].Status)
+}
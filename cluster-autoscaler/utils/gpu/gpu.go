@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpu
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ResourceNvidiaGPU is the name of the resource exposed by the Nvidia device plugin.
+	ResourceNvidiaGPU = "nvidia.com/gpu"
+	// GPUSharingStrategyLabel is set on nodes whose GPUs are shared by multiple containers, to time-sharing or mps.
+	GPUSharingStrategyLabel = "cloud.google.com/gke-gpu-sharing-strategy"
+	// GPUMaxSharedClientsLabel is the number of containers sharing a single GPU (or GPU partition).
+	GPUMaxSharedClientsLabel = "cloud.google.com/gke-max-shared-clients-per-gpu"
+	// GPUPartitionSizeLabel is the MIG profile each GPU of the node is partitioned into, for example 1g.5gb.
+	GPUPartitionSizeLabel = "cloud.google.com/gke-gpu-partition-size"
+)
+
+// migPartitionsPerGpu is the number of partitions of a single GPU for each MIG profile.
+var migPartitionsPerGpu = map[string]int64{
+	// A100 40GB.
+	"1g.5gb":  7,
+	"2g.10gb": 3,
+	"3g.20gb": 2,
+	"7g.40gb": 1,
+	// A100 80GB.
+	"1g.10gb": 7,
+	"2g.20gb": 3,
+	"3g.40gb": 2,
+	"7g.80gb": 1,
+}
+
+// PartitionCount returns the number of MIG partitions a single GPU of a node with the given labels is
+// split into, 1 if GPUs are not partitioned.
+func PartitionCount(labels map[string]string) int64 {
+	size, found := labels[GPUPartitionSizeLabel]
+	if !found {
+		return 1
+	}
+	partitions, found := migPartitionsPerGpu[size]
+	if !found {
+		glog.Warningf("Unknown GPU partition size %s, assuming GPUs are not partitioned", size)
+		return 1
+	}
+	return partitions
+}
+
+// SharedClientCount returns the number of containers sharing a single GPU resource of a node with the
+// given labels, 1 if GPUs are not shared.
+func SharedClientCount(labels map[string]string) int64 {
+	if labels[GPUSharingStrategyLabel] == "" {
+		return 1
+	}
+	clients, err := strconv.ParseInt(labels[GPUMaxSharedClientsLabel], 10, 64)
+	if err != nil || clients < 1 {
+		glog.Warningf("Invalid %s label value %q, assuming GPUs are not shared", GPUMaxSharedClientsLabel, labels[GPUMaxSharedClientsLabel])
+		return 1
+	}
+	return clients
+}
+
+// SharingFactor returns how many GPU resources a single physical GPU of a node with the given labels is
+// exposed as.
+func SharingFactor(labels map[string]string) int64 {
+	return PartitionCount(labels) * SharedClientCount(labels)
+}
+
+// GetGpuCount returns the number of GPU resources in the resource list, under resource names of any of
+// the accelerators in use.
+func GetGpuCount(resources apiv1.ResourceList) int64 {
+	count := int64(0)
+	for _, accelerator := range accelerators {
+		gpu := resources[accelerator.ResourceName]
+		count += gpu.Value()
+	}
+	return count
+}
+
+// PhysicalGpuCount returns the number of physical GPUs of the node.
+func PhysicalGpuCount(node *apiv1.Node) int64 {
+	factor := SharingFactor(node.Labels)
+	return (GetGpuCount(node.Status.Capacity) + factor - 1) / factor
+}
+
+// CalculateUtilization returns the part of GPU capacity of the node requested by the pods. A time-shared
+// GPU is used whole by any of the containers sharing it, so every requested share counts as a full GPU,
+// while MIG partitions are separate devices and are counted as they are.
+func CalculateUtilization(node *apiv1.Node, pods []*apiv1.Pod) (float64, error) {
+	capacity := GetGpuCount(node.Status.Capacity)
+	if capacity == 0 {
+		return 0, fmt.Errorf("no GPUs at %s", node.Name)
+	}
+	requested := int64(0)
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			requested += GetGpuCount(container.Resources.Requests)
+		}
+	}
+	return math.Min(1, float64(requested*SharedClientCount(node.Labels))/float64(capacity)), nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpu
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	. "k8s.io/autoscaler/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildGpuNode(gpus int64, labels map[string]string) *apiv1.Node {
+	node := BuildTestNode("n1", 1000, 1000)
+	node.Status.Capacity[ResourceNvidiaGPU] = *resource.NewQuantity(gpus, resource.DecimalSI)
+	for key, value := range labels {
+		node.Labels[key] = value
+	}
+	return node
+}
+
+func buildGpuPod(name string, gpus int64) *apiv1.Pod {
+	pod := BuildTestPod(name, 100, 100)
+	pod.Spec.Containers[0].Resources.Requests[ResourceNvidiaGPU] = *resource.NewQuantity(gpus, resource.DecimalSI)
+	return pod
+}
+
+func TestSharingFactor(t *testing.T) {
+	assert.Equal(t, int64(1), SharingFactor(map[string]string{}))
+	assert.Equal(t, int64(8), SharingFactor(map[string]string{
+		GPUSharingStrategyLabel:  "time-sharing",
+		GPUMaxSharedClientsLabel: "8",
+	}))
+	assert.Equal(t, int64(7), SharingFactor(map[string]string{GPUPartitionSizeLabel: "1g.5gb"}))
+	assert.Equal(t, int64(6), SharingFactor(map[string]string{
+		GPUPartitionSizeLabel:    "3g.20gb",
+		GPUSharingStrategyLabel:  "time-sharing",
+		GPUMaxSharedClientsLabel: "3",
+	}))
+	// Invalid labels are ignored.
+	assert.Equal(t, int64(1), SharingFactor(map[string]string{GPUPartitionSizeLabel: "5g.1gb"}))
+	assert.Equal(t, int64(1), SharingFactor(map[string]string{
+		GPUSharingStrategyLabel:  "time-sharing",
+		GPUMaxSharedClientsLabel: "0",
+	}))
+}
+
+func TestPhysicalGpuCount(t *testing.T) {
+	assert.Equal(t, int64(2), PhysicalGpuCount(buildGpuNode(2, nil)))
+	assert.Equal(t, int64(1), PhysicalGpuCount(buildGpuNode(8, map[string]string{
+		GPUSharingStrategyLabel:  "time-sharing",
+		GPUMaxSharedClientsLabel: "8",
+	})))
+	assert.Equal(t, int64(2), PhysicalGpuCount(buildGpuNode(14, map[string]string{GPUPartitionSizeLabel: "1g.5gb"})))
+	assert.Equal(t, int64(0), PhysicalGpuCount(BuildTestNode("n1", 1000, 1000)))
+}
+
+func TestCalculateUtilization(t *testing.T) {
+	p1 := buildGpuPod("p1", 1)
+	p2 := buildGpuPod("p2", 1)
+	p3 := BuildTestPod("p3", 100, 100)
+
+	utilization, err := CalculateUtilization(buildGpuNode(4, nil), []*apiv1.Pod{p1, p2, p3})
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 0.5, utilization, 0.01)
+
+	// A single pod uses the whole time-shared GPU.
+	timeShared := buildGpuNode(8, map[string]string{
+		GPUSharingStrategyLabel:  "time-sharing",
+		GPUMaxSharedClientsLabel: "8",
+	})
+	utilization, err = CalculateUtilization(timeShared, []*apiv1.Pod{p1})
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 1.0, utilization, 0.01)
+	utilization, err = CalculateUtilization(timeShared, []*apiv1.Pod{p1, p2})
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 1.0, utilization, 0.01)
+
+	// MIG partitions are used separately.
+	partitioned := buildGpuNode(7, map[string]string{GPUPartitionSizeLabel: "1g.5gb"})
+	utilization, err = CalculateUtilization(partitioned, []*apiv1.Pod{p1, p2})
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 2.0/7, utilization, 0.01)
+
+	_, err = CalculateUtilization(BuildTestNode("n1", 1000, 1000), []*apiv1.Pod{p1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// OutOfServiceTaint is put on nodes that are shut down and won't come back.
+	OutOfServiceTaint = "node.kubernetes.io/out-of-service"
+	// ImpendingTerminationTaint is put by GKE on nodes that are about to be terminated,
+	// for example because of preemption.
+	ImpendingTerminationTaint = "cloud.google.com/impending-node-termination"
+	// gracefulShutdownMessage is reported by kubelet in the Ready condition while
+	// it is shutting the node down.
+	gracefulShutdownMessage = "node is shutting down"
+)
+
+// IsNodeShuttingDown returns true if the node is going through graceful shutdown. Pods
+// on such node are being terminated by kubelet and the node will go away soon.
+func IsNodeShuttingDown(node *apiv1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == OutOfServiceTaint || taint.Key == ImpendingTerminationTaint {
+			return true
+		}
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == apiv1.NodeReady && cond.Status != apiv1.ConditionTrue &&
+			strings.Contains(cond.Message, gracefulShutdownMessage) {
+			return true
+		}
+	}
+	return false
+}
@@ -18,7 +18,6 @@ package nodegroupset
 
 import (
 	"fmt"
-	"sort"
 
 	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
 	"k8s.io/autoscaler/cluster-autoscaler/utils/errors"
@@ -53,6 +52,14 @@ func (s ScaleUpInfo) String() string {
 // of all NodeGroups it will be capped to total capacity. In particular if all
 // group already have MaxSize, empty list will be returned.
 func BalanceScaleUpBetweenGroups(groups []cloudprovider.NodeGroup, newNodes int) ([]ScaleUpInfo, errors.AutoscalerError) {
+	return SplitScaleUpBetweenGroups(groups, newNodes, EvenSplittingStrategy{})
+}
+
+// SplitScaleUpBetweenGroups distributes a given number of nodes between given
+// set of NodeGroups with the given strategy. Like BalanceScaleUpBetweenGroups,
+// it respects MaxSize of each group and returns ScaleUpInfos for groups that
+// need to be resized.
+func SplitScaleUpBetweenGroups(groups []cloudprovider.NodeGroup, newNodes int, strategy SplittingStrategy) ([]ScaleUpInfo, errors.AutoscalerError) {
 	if len(groups) == 0 {
 		return []ScaleUpInfo{}, errors.NewAutoscalerError(
 			errors.InternalError, "Can't balance scale up between 0 groups")
@@ -86,61 +93,7 @@ func BalanceScaleUpBetweenGroups(groups []cloudprovider.NodeGroup, newNodes int)
 		newNodes = totalCapacity
 	}
 
-	// The actual balancing algorithm.
-	// Sort the node groups by current size and just loop over nodes adding
-	// to smallest group. If a group hits max size remove it from the list
-	// (by moving it to start of the list and increasing startIndex).
-	//
-	// In each iteration we either allocate one node, or 'remove' a maxed out
-	// node group, so this will terminate in O(#nodes + #node groups) steps.
-	// We already know that newNodes <= total capacity, so we don't have to
-	// worry about accidentally removing all node groups while we still
-	// have nodes to allocate.
-	//
-	// Loop invariants:
-	// 1. i < startIndex -> scaleUpInfos[i].CurrentSize == scaleUpInfos[i].MaxSize
-	// 2. i >= startIndex -> scaleUpInfos[i].CurrentSize < scaleUpInfos[i].MaxSize
-	// 3. startIndex <= currentIndex < len(scaleUpInfos)
-	// 4. currentIndex <= i < j -> scaleUpInfos[i].CurrentSize <= scaleUpInfos[j].CurrentSize
-	// 5. startIndex <= i < j < currentIndex -> scaleUpInfos[i].CurrentSize == scaleUpInfos[j].CurrentSize
-	// 6. startIndex <= i < currentIndex <= j -> scaleUpInfos[i].CurrentSize <= scaleUpInfos[j].CurrentSize + 1
-	sort.Slice(scaleUpInfos, func(i, j int) bool {
-		return scaleUpInfos[i].CurrentSize < scaleUpInfos[j].CurrentSize
-	})
-	startIndex := 0
-	currentIndex := 0
-	for newNodes > 0 {
-		currentInfo := &scaleUpInfos[currentIndex]
-
-		if currentInfo.NewSize < currentInfo.MaxSize {
-			// Add a node to group on currentIndex
-			currentInfo.NewSize++
-			newNodes--
-		} else {
-			// Group on currentIndex is full. Remove it from the array.
-			// Removing is done by swapping the group with the first
-			// group still in array and moving the start of the array.
-			// Every group between startIndex and currentIndex has the
-			// same size, so we can swap without breaking ordering.
-			scaleUpInfos[startIndex], scaleUpInfos[currentIndex] = scaleUpInfos[currentIndex], scaleUpInfos[startIndex]
-			startIndex++
-		}
-
-		// Update currentIndex.
-		// If we removed a group in this loop currentIndex may be equal to startIndex-1,
-		// in which case both branches of below if will make currentIndex == startIndex.
-		if currentIndex < len(scaleUpInfos)-1 && currentInfo.NewSize > scaleUpInfos[currentIndex+1].NewSize {
-			// Next group has exactly one less node, than current one.
-			// We will increase it in next iteration.
-			currentIndex++
-		} else {
-			// We reached end of array, or a group larger than the current one.
-			// All groups from startIndex to currentIndex have the same size.
-			// So we're moving to the beginning of array to loop over all of
-			// them once again.
-			currentIndex = startIndex
-		}
-	}
+	strategy.Split(scaleUpInfos, newNodes)
 
 	// Filter out groups that haven't changed size
 	result := make([]ScaleUpInfo, 0)
@@ -0,0 +1,222 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodegroupset
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+)
+
+const (
+	// EvenSplittingStrategyName is the name of EvenSplittingStrategy.
+	EvenSplittingStrategyName = "even"
+	// ProportionalSplittingStrategyName is the name of ProportionalSplittingStrategy.
+	ProportionalSplittingStrategyName = "proportional"
+	// PrioritySplittingStrategyName is the name of WeightedSplittingStrategy with weights configured
+	// for node groups.
+	PrioritySplittingStrategyName = "priority"
+)
+
+// AvailableSplittingStrategies are names of splitting strategies that can be selected.
+var AvailableSplittingStrategies = []string{EvenSplittingStrategyName, ProportionalSplittingStrategyName, PrioritySplittingStrategyName}
+
+// SplittingStrategy distributes new nodes of a scale-up between similar node groups.
+type SplittingStrategy interface {
+	// Split increases NewSize of the given scale-ups by newNodes in total, without exceeding
+	// MaxSize of any of them. The scale-ups have room for at least newNodes. The order of
+	// the scale-ups may change.
+	Split(scaleUpInfos []ScaleUpInfo, newNodes int)
+}
+
+// NewSplittingStrategy builds the splitting strategy with the given name. Weight returns the
+// weight of a node group for the priority strategy.
+func NewSplittingStrategy(name string, weight func(cloudprovider.NodeGroup) int) (SplittingStrategy, error) {
+	switch name {
+	case EvenSplittingStrategyName:
+		return EvenSplittingStrategy{}, nil
+	case ProportionalSplittingStrategyName:
+		return ProportionalSplittingStrategy{}, nil
+	case PrioritySplittingStrategyName:
+		return WeightedSplittingStrategy{Weight: weight}, nil
+	}
+	return nil, fmt.Errorf("unknown splitting strategy %q, expected one of %v", name, AvailableSplittingStrategies)
+}
+
+// EvenSplittingStrategy adds nodes to the smallest group first, making the group sizes as evenly
+// balanced as possible.
+type EvenSplittingStrategy struct{}
+
+// Split implements SplittingStrategy.
+func (EvenSplittingStrategy) Split(scaleUpInfos []ScaleUpInfo, newNodes int) {
+	if len(scaleUpInfos) == 0 {
+		return
+	}
+	// The actual balancing algorithm.
+	// Sort the node groups by current size and just loop over nodes adding
+	// to smallest group. If a group hits max size remove it from the list
+	// (by moving it to start of the list and increasing startIndex).
+	//
+	// In each iteration we either allocate one node, or 'remove' a maxed out
+	// node group, so this will terminate in O(#nodes + #node groups) steps.
+	// We already know that newNodes <= total capacity, so we don't have to
+	// worry about accidentally removing all node groups while we still
+	// have nodes to allocate.
+	//
+	// Loop invariants:
+	// 1. i < startIndex -> scaleUpInfos[i].CurrentSize == scaleUpInfos[i].MaxSize
+	// 2. i >= startIndex -> scaleUpInfos[i].CurrentSize < scaleUpInfos[i].MaxSize
+	// 3. startIndex <= currentIndex < len(scaleUpInfos)
+	// 4. currentIndex <= i < j -> scaleUpInfos[i].CurrentSize <= scaleUpInfos[j].CurrentSize
+	// 5. startIndex <= i < j < currentIndex -> scaleUpInfos[i].CurrentSize == scaleUpInfos[j].CurrentSize
+	// 6. startIndex <= i < currentIndex <= j -> scaleUpInfos[i].CurrentSize <= scaleUpInfos[j].CurrentSize + 1
+	sort.Slice(scaleUpInfos, func(i, j int) bool {
+		return scaleUpInfos[i].CurrentSize < scaleUpInfos[j].CurrentSize
+	})
+	startIndex := 0
+	currentIndex := 0
+	for newNodes > 0 {
+		currentInfo := &scaleUpInfos[currentIndex]
+
+		if currentInfo.NewSize < currentInfo.MaxSize {
+			// Add a node to group on currentIndex
+			currentInfo.NewSize++
+			newNodes--
+		} else {
+			// Group on currentIndex is full. Remove it from the array.
+			// Removing is done by swapping the group with the first
+			// group still in array and moving the start of the array.
+			// Every group between startIndex and currentIndex has the
+			// same size, so we can swap without breaking ordering.
+			scaleUpInfos[startIndex], scaleUpInfos[currentIndex] = scaleUpInfos[currentIndex], scaleUpInfos[startIndex]
+			startIndex++
+		}
+
+		// Update currentIndex.
+		// If we removed a group in this loop currentIndex may be equal to startIndex-1,
+		// in which case both branches of below if will make currentIndex == startIndex.
+		if currentIndex < len(scaleUpInfos)-1 && currentInfo.NewSize > scaleUpInfos[currentIndex+1].NewSize {
+			// Next group has exactly one less node, than current one.
+			// We will increase it in next iteration.
+			currentIndex++
+		} else {
+			// We reached end of array, or a group larger than the current one.
+			// All groups from startIndex to currentIndex have the same size.
+			// So we're moving to the beginning of array to loop over all of
+			// them once again.
+			currentIndex = startIndex
+		}
+	}
+}
+
+// ProportionalSplittingStrategy splits new nodes proportionally to the current sizes of the
+// groups, keeping the ratio between them. Empty groups only get nodes if all groups are empty or
+// the other groups are full.
+type ProportionalSplittingStrategy struct{}
+
+// Split implements SplittingStrategy.
+func (ProportionalSplittingStrategy) Split(scaleUpInfos []ScaleUpInfo, newNodes int) {
+	weights := make([]float64, len(scaleUpInfos))
+	for i, info := range scaleUpInfos {
+		weights[i] = float64(info.CurrentSize)
+	}
+	splitByWeight(scaleUpInfos, newNodes, weights)
+}
+
+// WeightedSplittingStrategy splits new nodes proportionally to weights of the groups. Groups with
+// weight 0 only get nodes if all groups have weight 0 or the other groups are full.
+type WeightedSplittingStrategy struct {
+	// Weight returns the weight of the node group, which must not be negative.
+	Weight func(cloudprovider.NodeGroup) int
+}
+
+// Split implements SplittingStrategy.
+func (s WeightedSplittingStrategy) Split(scaleUpInfos []ScaleUpInfo, newNodes int) {
+	weights := make([]float64, len(scaleUpInfos))
+	for i, info := range scaleUpInfos {
+		weights[i] = float64(s.Weight(info.Group))
+	}
+	splitByWeight(scaleUpInfos, newNodes, weights)
+}
+
+// splitByWeight splits new nodes between the groups proportionally to their weights. Groups whose
+// share exceeds their free capacity are filled up and the rest is split again between the other
+// groups. Nodes left after rounding the shares down go to the groups with the largest fractional
+// parts of their shares, in the order of the groups in case of a tie.
+func splitByWeight(scaleUpInfos []ScaleUpInfo, newNodes int, weights []float64) {
+	active := make([]int, 0, len(scaleUpInfos))
+	for i, info := range scaleUpInfos {
+		if info.NewSize < info.MaxSize {
+			active = append(active, i)
+		}
+	}
+	for newNodes > 0 && len(active) > 0 {
+		totalWeight := 0.0
+		for _, i := range active {
+			totalWeight += weights[i]
+		}
+		// Without any weight, nodes are split evenly.
+		uniform := totalWeight == 0
+		if uniform {
+			totalWeight = float64(len(active))
+		}
+		weight := func(i int) float64 {
+			if uniform {
+				return 1
+			}
+			return weights[i]
+		}
+
+		// Fill up groups whose share doesn't fit, then split the rest again.
+		remaining := make([]int, 0, len(active))
+		filled := 0
+		for _, i := range active {
+			free := scaleUpInfos[i].MaxSize - scaleUpInfos[i].NewSize
+			if float64(newNodes)*weight(i)/totalWeight >= float64(free) {
+				scaleUpInfos[i].NewSize = scaleUpInfos[i].MaxSize
+				filled += free
+			} else {
+				remaining = append(remaining, i)
+			}
+		}
+		if filled > 0 {
+			newNodes -= filled
+			active = remaining
+			continue
+		}
+
+		// All shares fit, so rounding them up doesn't exceed MaxSize either.
+		fractions := make(map[int]float64, len(active))
+		assigned := 0
+		for _, i := range active {
+			share := float64(newNodes) * weight(i) / totalWeight
+			whole := int(math.Floor(share))
+			scaleUpInfos[i].NewSize += whole
+			fractions[i] = share - float64(whole)
+			assigned += whole
+		}
+		sort.SliceStable(active, func(a, b int) bool {
+			return fractions[active[a]] > fractions[active[b]]
+		})
+		for k := 0; k < newNodes-assigned; k++ {
+			scaleUpInfos[active[k]].NewSize++
+		}
+		return
+	}
+}
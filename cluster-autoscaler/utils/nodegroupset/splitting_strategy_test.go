@@ -0,0 +1,154 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodegroupset
+
+import (
+	"testing"
+
+	"k8s.io/autoscaler/cluster-autoscaler/cloudprovider"
+	testprovider "k8s.io/autoscaler/cluster-autoscaler/cloudprovider/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testGroup struct {
+	id         string
+	targetSize int
+	maxSize    int
+	weight     int
+}
+
+func splitWithStrategy(t *testing.T, strategy SplittingStrategy, groups []testGroup, newNodes int) map[string]int {
+	provider := testprovider.NewTestCloudProvider(nil, nil)
+	for _, group := range groups {
+		provider.AddNodeGroup(group.id, 0, group.maxSize, group.targetSize)
+	}
+	nodeGroups := make([]cloudprovider.NodeGroup, 0, len(groups))
+	for _, group := range groups {
+		for _, nodeGroup := range provider.NodeGroups() {
+			if nodeGroup.Id() == group.id {
+				nodeGroups = append(nodeGroups, nodeGroup)
+			}
+		}
+	}
+	scaleUpInfos, err := SplitScaleUpBetweenGroups(nodeGroups, newNodes, strategy)
+	assert.NoError(t, err)
+	result := make(map[string]int)
+	for _, info := range scaleUpInfos {
+		assert.True(t, info.NewSize <= info.MaxSize, "%v", info)
+		result[info.Group.Id()] = info.NewSize - info.CurrentSize
+	}
+	return result
+}
+
+func testWeights(groups []testGroup) func(cloudprovider.NodeGroup) int {
+	weights := make(map[string]int)
+	for _, group := range groups {
+		weights[group.id] = group.weight
+	}
+	return func(nodeGroup cloudprovider.NodeGroup) int {
+		return weights[nodeGroup.Id()]
+	}
+}
+
+func TestNewSplittingStrategy(t *testing.T) {
+	for _, name := range AvailableSplittingStrategies {
+		strategy, err := NewSplittingStrategy(name, nil)
+		assert.NoError(t, err)
+		assert.NotNil(t, strategy)
+	}
+	_, err := NewSplittingStrategy("cheapest", nil)
+	assert.Error(t, err)
+}
+
+func TestEvenSplittingStrategy(t *testing.T) {
+	groups := []testGroup{
+		{id: "ng1", targetSize: 1, maxSize: 10},
+		{id: "ng2", targetSize: 3, maxSize: 10},
+		{id: "ng3", targetSize: 5, maxSize: 6},
+	}
+	assert.Equal(t, map[string]int{"ng1": 2}, splitWithStrategy(t, EvenSplittingStrategy{}, groups, 2))
+	assert.Equal(t, map[string]int{"ng1": 3, "ng2": 1}, splitWithStrategy(t, EvenSplittingStrategy{}, groups, 4))
+	// ng3 is clamped to its max size.
+	assert.Equal(t, map[string]int{"ng1": 6, "ng2": 4, "ng3": 1}, splitWithStrategy(t, EvenSplittingStrategy{}, groups, 11))
+	// Capped to the total free capacity.
+	assert.Equal(t, map[string]int{"ng1": 9, "ng2": 7, "ng3": 1}, splitWithStrategy(t, EvenSplittingStrategy{}, groups, 100))
+}
+
+func TestProportionalSplittingStrategy(t *testing.T) {
+	groups := []testGroup{
+		{id: "ng1", targetSize: 2, maxSize: 100},
+		{id: "ng2", targetSize: 6, maxSize: 100},
+		{id: "ng3", targetSize: 0, maxSize: 100},
+	}
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 6}, splitWithStrategy(t, ProportionalSplittingStrategy{}, groups, 8))
+	// Remainder: shares are 1.25 and 3.75.
+	assert.Equal(t, map[string]int{"ng1": 1, "ng2": 4}, splitWithStrategy(t, ProportionalSplittingStrategy{}, groups, 5))
+	// Remainder: shares are 0.5 and 1.5, the tie goes to the first group.
+	assert.Equal(t, map[string]int{"ng1": 1, "ng2": 1}, splitWithStrategy(t, ProportionalSplittingStrategy{}, groups, 2))
+
+	// All groups are empty.
+	empty := []testGroup{
+		{id: "ng1", targetSize: 0, maxSize: 10},
+		{id: "ng2", targetSize: 0, maxSize: 10},
+	}
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 1}, splitWithStrategy(t, ProportionalSplittingStrategy{}, empty, 3))
+}
+
+func TestProportionalSplittingStrategyMaxSize(t *testing.T) {
+	groups := []testGroup{
+		{id: "ng1", targetSize: 2, maxSize: 10},
+		{id: "ng2", targetSize: 6, maxSize: 8},
+		{id: "ng3", targetSize: 0, maxSize: 3},
+	}
+	// ng2 is clamped to its max size and the rest goes to ng1.
+	assert.Equal(t, map[string]int{"ng1": 6, "ng2": 2}, splitWithStrategy(t, ProportionalSplittingStrategy{}, groups, 8))
+	// Once ng1 and ng2 are full, the empty group gets the rest.
+	assert.Equal(t, map[string]int{"ng1": 8, "ng2": 2, "ng3": 2}, splitWithStrategy(t, ProportionalSplittingStrategy{}, groups, 12))
+	// Capped to the total free capacity.
+	assert.Equal(t, map[string]int{"ng1": 8, "ng2": 2, "ng3": 3}, splitWithStrategy(t, ProportionalSplittingStrategy{}, groups, 100))
+}
+
+func TestWeightedSplittingStrategy(t *testing.T) {
+	groups := []testGroup{
+		{id: "ng1", targetSize: 5, maxSize: 100, weight: 3},
+		{id: "ng2", targetSize: 0, maxSize: 100, weight: 1},
+		{id: "ng3", targetSize: 1, maxSize: 100, weight: 0},
+	}
+	strategy := WeightedSplittingStrategy{Weight: testWeights(groups)}
+	assert.Equal(t, map[string]int{"ng1": 3, "ng2": 1}, splitWithStrategy(t, strategy, groups, 4))
+	// Remainder: shares are 7.5 and 2.5, the tie goes to the first group.
+	assert.Equal(t, map[string]int{"ng1": 8, "ng2": 2}, splitWithStrategy(t, strategy, groups, 10))
+	// Remainder: shares are 2.25 and 0.75.
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 1}, splitWithStrategy(t, strategy, groups, 3))
+	assert.Equal(t, map[string]int{"ng1": 1}, splitWithStrategy(t, strategy, groups, 1))
+}
+
+func TestWeightedSplittingStrategyMaxSize(t *testing.T) {
+	groups := []testGroup{
+		{id: "ng1", targetSize: 5, maxSize: 7, weight: 3},
+		{id: "ng2", targetSize: 0, maxSize: 10, weight: 1},
+		{id: "ng3", targetSize: 1, maxSize: 4, weight: 0},
+	}
+	strategy := WeightedSplittingStrategy{Weight: testWeights(groups)}
+	// ng1 is clamped to its max size and the rest goes to ng2.
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 6}, splitWithStrategy(t, strategy, groups, 8))
+	// ng3 with weight 0 only gets nodes once the other groups are full.
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 10, "ng3": 1}, splitWithStrategy(t, strategy, groups, 13))
+	// Capped to the total free capacity.
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 10, "ng3": 3}, splitWithStrategy(t, strategy, groups, 100))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package test
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -24,6 +25,7 @@ import (
 	"net/http/httptest"
 
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -32,6 +34,7 @@ import (
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/testapi"
 
+	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -123,6 +126,28 @@ func SetNodeReadyState(node *apiv1.Node, ready bool, lastTransition time.Time) {
 	node.Status.Conditions = append(node.Status.Conditions, condition)
 }
 
+// ApplyJSONPatchToNode returns a copy of the node with the JSON patch applied, as the API server
+// would store it. A failed test operation of the patch results in a conflict error.
+func ApplyJSONPatchToNode(node *apiv1.Node, patch []byte) (*apiv1.Node, error) {
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	original, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := decoded.Apply(original)
+	if err != nil {
+		return nil, errors.NewConflict(apiv1.Resource("nodes"), node.Name, err)
+	}
+	result := &apiv1.Node{}
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // RefJSON builds string reference to
 func RefJSON(o runtime.Object) string {
 	ref, err := refv1.GetReference(api.Scheme, o)
@@ -18,3 +18,7 @@ package main
 
 // ClusterAutoscalerVersion contains version of CA.
 const ClusterAutoscalerVersion = "1.0.0"
+
+// GitCommit is the commit CA was built from. It's set at build time with
+// -ldflags "-X main.GitCommit=<sha>".
+var GitCommit = "unknown"
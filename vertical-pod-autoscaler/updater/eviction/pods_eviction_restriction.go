@@ -18,11 +18,13 @@ package eviction
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 	apiv1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	api "k8s.io/kubernetes/pkg/api"
 	kube_client "k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
@@ -30,7 +32,9 @@ import (
 
 // PodsEvictionRestriction controls pods evictions. It ensures that we will not evict too
 // many pods from one replica set. For replica set will allow to evict one pod or more if
-// evictionToleranceFraction is configured.
+// evictionToleranceFraction is configured. Pods covered by a pod disruption budget with no
+// disruptions allowed are never evicted, evictions from one replica set are spaced by
+// minEvictionInterval and the total number of evictions in flight is capped by maxInFlight.
 type PodsEvictionRestriction interface {
 	// Evict sends eviction instruction to the api client.
 	// Retrurns error if pod cannot be evicted or if client returned error.
@@ -43,18 +47,112 @@ type podsEvictionRestrictionImpl struct {
 	client         kube_client.Interface
 	podsCreators   map[string]podReplicaCreator
 	evictionBudget map[podReplicaCreator]int
+	podsPdbs       map[string][]string
+	pdbBudget      map[string]int32
+	pacing         *evictionPacing
 }
 
 // PodsEvictionRestrictionFactory creates PodsEvictionRestriction
 type PodsEvictionRestrictionFactory interface {
 	// NewPodsEvictionRestriction creates PodsEvictionRestriction for given set of pods.
 	NewPodsEvictionRestriction(pods []*apiv1.Pod) PodsEvictionRestriction
+	// ForgetUnseenCreators forgets past evictions from replica sets none of whose pods were given
+	// to NewPodsEvictionRestriction since the last call. It's called at the end of each updater loop.
+	ForgetUnseenCreators()
 }
 
 type podsEvictionRestrictionFactoryImpl struct {
 	client                    kube_client.Interface
 	minReplicas               int
 	evictionToleranceFraction float64
+	pacing                    *evictionPacing
+}
+
+// inFlightEvictionTimeout is how long an evicted pod is counted as in flight if its replica set
+// doesn't get back to full size, e.g. because the replacement can't be scheduled.
+const inFlightEvictionTimeout = 10 * time.Minute
+
+// evictionPacing keeps track of evictions across updater loops.
+type evictionPacing struct {
+	minEvictionInterval time.Duration
+	maxInFlight         int
+	now                 func() time.Time
+	// lastEviction is the time of the most recent eviction from each replica set, kept for
+	// minEvictionInterval.
+	lastEviction map[podReplicaCreator]time.Time
+	// inFlight are the times of evictions from each replica set whose pods weren't replaced yet,
+	// oldest first, kept for inFlightEvictionTimeout.
+	inFlight map[podReplicaCreator][]time.Time
+	// seen are replica sets with live pods in the current updater loop.
+	seen map[podReplicaCreator]bool
+}
+
+func (p *evictionPacing) canEvict(cr podReplicaCreator) bool {
+	p.expire()
+	if last, found := p.lastEviction[cr]; found && p.now().Sub(last) < p.minEvictionInterval {
+		return false
+	}
+	if p.maxInFlight <= 0 {
+		return true
+	}
+	total := 0
+	for _, evictions := range p.inFlight {
+		total += len(evictions)
+	}
+	return total < p.maxInFlight
+}
+
+func (p *evictionPacing) recordEviction(cr podReplicaCreator) {
+	now := p.now()
+	p.lastEviction[cr] = now
+	p.inFlight[cr] = append(p.inFlight[cr], now)
+}
+
+// updateMissing forgets evictions from the replica set that were already replaced, given the
+// number of replicas missing from it. The oldest evictions are assumed to be replaced first.
+func (p *evictionPacing) updateMissing(cr podReplicaCreator, missing int) {
+	if missing <= 0 {
+		delete(p.inFlight, cr)
+	} else if evictions := p.inFlight[cr]; len(evictions) > missing {
+		p.inFlight[cr] = evictions[len(evictions)-missing:]
+	}
+}
+
+// expire forgets evictions that no longer limit new ones.
+func (p *evictionPacing) expire() {
+	now := p.now()
+	for cr, last := range p.lastEviction {
+		if now.Sub(last) >= p.minEvictionInterval {
+			delete(p.lastEviction, cr)
+		}
+	}
+	for cr, evictions := range p.inFlight {
+		recent := 0
+		for recent < len(evictions) && now.Sub(evictions[recent]) >= inFlightEvictionTimeout {
+			recent++
+		}
+		if recent == len(evictions) {
+			delete(p.inFlight, cr)
+		} else {
+			p.inFlight[cr] = evictions[recent:]
+		}
+	}
+}
+
+// forgetUnseen forgets evictions from replica sets without live pods in the current updater loop,
+// e.g. deleted ones, and starts a new loop.
+func (p *evictionPacing) forgetUnseen() {
+	for cr := range p.lastEviction {
+		if !p.seen[cr] {
+			delete(p.lastEviction, cr)
+		}
+	}
+	for cr := range p.inFlight {
+		if !p.seen[cr] {
+			delete(p.inFlight, cr)
+		}
+	}
+	p.seen = make(map[podReplicaCreator]bool)
 }
 
 type podReplicaCreator struct {
@@ -67,11 +165,20 @@ type podReplicaCreator struct {
 func (e *podsEvictionRestrictionImpl) CanEvict(pod *apiv1.Pod) bool {
 	cr, present := e.podsCreators[getPodID(pod)]
 	if present {
-		return e.evictionBudget[cr] > 0
+		return e.evictionBudget[cr] > 0 && e.pdbsAllowEviction(pod) && e.pacing.canEvict(cr)
 	}
 	return false
 }
 
+func (e *podsEvictionRestrictionImpl) pdbsAllowEviction(pod *apiv1.Pod) bool {
+	for _, pdb := range e.podsPdbs[getPodID(pod)] {
+		if e.pdbBudget[pdb] < 1 {
+			return false
+		}
+	}
+	return true
+}
+
 // Evict sends eviction instruction to api client. Retrurns error if pod cannot be evicted or if client returned error
 // Does not check if pod was actually evicted after eviction grace period.
 func (e *podsEvictionRestrictionImpl) Evict(podToEvict *apiv1.Pod) error {
@@ -82,6 +189,12 @@ func (e *podsEvictionRestrictionImpl) Evict(podToEvict *apiv1.Pod) error {
 	if e.evictionBudget[cr] < 1 {
 		return fmt.Errorf("cannot evict pod %v : eviction budget exceeded", podToEvict.Name)
 	}
+	if !e.pdbsAllowEviction(podToEvict) {
+		return fmt.Errorf("cannot evict pod %v : pod disruption budget exceeded", podToEvict.Name)
+	}
+	if !e.pacing.canEvict(cr) {
+		return fmt.Errorf("cannot evict pod %v : too many recent evictions", podToEvict.Name)
+	}
 
 	eviction := &policyv1.Eviction{
 		ObjectMeta: metav1.ObjectMeta{
@@ -95,19 +208,44 @@ func (e *podsEvictionRestrictionImpl) Evict(podToEvict *apiv1.Pod) error {
 		return err
 	}
 	e.evictionBudget[cr] = e.evictionBudget[cr] - 1
+	for _, pdb := range e.podsPdbs[getPodID(podToEvict)] {
+		e.pdbBudget[pdb] = e.pdbBudget[pdb] - 1
+	}
+	e.pacing.recordEviction(cr)
 	return nil
 }
 
-// NewPodsEvictionRestrictionFactory creates PodsEvictionRestrictionFactory
-func NewPodsEvictionRestrictionFactory(client kube_client.Interface, minReplicas int, evictionToleranceFraction float64) PodsEvictionRestrictionFactory {
-	return &podsEvictionRestrictionFactoryImpl{client: client, minReplicas: minReplicas, evictionToleranceFraction: evictionToleranceFraction}
+// NewPodsEvictionRestrictionFactory creates PodsEvictionRestrictionFactory. Evictions of pods from
+// the same replica set are at least minEvictionInterval apart and at most maxInFlight evicted pods
+// may be waiting for replacement at a time. Non-positive maxInFlight means no limit.
+func NewPodsEvictionRestrictionFactory(client kube_client.Interface, minReplicas int, evictionToleranceFraction float64,
+	minEvictionInterval time.Duration, maxInFlight int) PodsEvictionRestrictionFactory {
+	return &podsEvictionRestrictionFactoryImpl{
+		client:                    client,
+		minReplicas:               minReplicas,
+		evictionToleranceFraction: evictionToleranceFraction,
+		pacing: &evictionPacing{
+			minEvictionInterval: minEvictionInterval,
+			maxInFlight:         maxInFlight,
+			now:                 time.Now,
+			lastEviction:        make(map[podReplicaCreator]time.Time),
+			inFlight:            make(map[podReplicaCreator][]time.Time),
+			seen:                make(map[podReplicaCreator]bool),
+		},
+	}
+}
+
+// ForgetUnseenCreators forgets past evictions from replica sets none of whose pods were given to
+// NewPodsEvictionRestriction since the last call.
+func (f *podsEvictionRestrictionFactoryImpl) ForgetUnseenCreators() {
+	f.pacing.forgetUnseen()
 }
 
 // NewPodsEvictionRestriction creates PodsEvictionRestriction for a given set of pods.
 func (f *podsEvictionRestrictionFactoryImpl) NewPodsEvictionRestriction(pods []*apiv1.Pod) PodsEvictionRestriction {
 	// We can evict pod only if it is a part of replica set
 	// For each replica set we can evict only a fraction of pods.
-	// Evictions may be later limited by pod disruption budget if configured.
+	// Evictions are also limited by pod disruption budgets matching the pods.
 
 	livePods := make(map[podReplicaCreator][]*apiv1.Pod)
 
@@ -127,6 +265,7 @@ func (f *podsEvictionRestrictionFactoryImpl) NewPodsEvictionRestriction(pods []*
 	podsCreators := make(map[string]podReplicaCreator)
 	creatorsEvictionBudget := make(map[podReplicaCreator]int)
 	for creator, replicas := range livePods {
+		f.pacing.seen[creator] = true
 		actual := len(replicas)
 		if actual < f.minReplicas {
 			glog.V(2).Infof("too few replicas for %v %v/%v. Found %v live pods",
@@ -150,6 +289,7 @@ func (f *podsEvictionRestrictionFactoryImpl) NewPodsEvictionRestriction(pods []*
 
 		evictionTolerance := int(float64(configured) * f.evictionToleranceFraction)
 		currentlyEvicted := configured - actual
+		f.pacing.updateMissing(creator, currentlyEvicted)
 		evictionBudget := evictionTolerance - currentlyEvicted
 
 		if evictionBudget > 0 {
@@ -164,7 +304,61 @@ func (f *podsEvictionRestrictionFactoryImpl) NewPodsEvictionRestriction(pods []*
 			podsCreators[getPodID(pod)] = creator
 		}
 	}
-	return &podsEvictionRestrictionImpl{client: f.client, podsCreators: podsCreators, evictionBudget: creatorsEvictionBudget}
+
+	podsPdbs, pdbBudget := f.getPdbs(pods, podsCreators)
+	return &podsEvictionRestrictionImpl{
+		client:         f.client,
+		podsCreators:   podsCreators,
+		evictionBudget: creatorsEvictionBudget,
+		podsPdbs:       podsPdbs,
+		pdbBudget:      pdbBudget,
+		pacing:         f.pacing,
+	}
+}
+
+// getPdbs finds pod disruption budgets matching the pods and the number of disruptions each of
+// them allows. Pods from namespaces whose budgets can't be listed are removed from podsCreators,
+// so they are not evicted.
+func (f *podsEvictionRestrictionFactoryImpl) getPdbs(pods []*apiv1.Pod, podsCreators map[string]podReplicaCreator) (map[string][]string, map[string]int32) {
+	podsPdbs := make(map[string][]string)
+	pdbBudget := make(map[string]int32)
+	pdbsByNamespace := make(map[string][]policyv1.PodDisruptionBudget)
+	failedNamespaces := make(map[string]bool)
+
+	for _, pod := range pods {
+		if _, found := podsCreators[getPodID(pod)]; !found {
+			continue
+		}
+		pdbs, listed := pdbsByNamespace[pod.Namespace]
+		if !listed && !failedNamespaces[pod.Namespace] {
+			pdbList, err := f.client.PolicyV1beta1().PodDisruptionBudgets(pod.Namespace).List(metav1.ListOptions{})
+			if err != nil {
+				glog.Errorf("failed to list pod disruption budgets in namespace %s: %v", pod.Namespace, err)
+				failedNamespaces[pod.Namespace] = true
+			} else {
+				pdbs = pdbList.Items
+				pdbsByNamespace[pod.Namespace] = pdbs
+				for _, pdb := range pdbs {
+					pdbBudget[pdb.Namespace+"/"+pdb.Name] = pdb.Status.PodDisruptionsAllowed
+				}
+			}
+		}
+		if failedNamespaces[pod.Namespace] {
+			delete(podsCreators, getPodID(pod))
+			continue
+		}
+		for _, pdb := range pdbs {
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil {
+				glog.Errorf("failed to parse selector of pod disruption budget %s/%s: %v", pdb.Namespace, pdb.Name, err)
+				continue
+			}
+			if !selector.Empty() && selector.Matches(labels.Set(pod.Labels)) {
+				podsPdbs[getPodID(pod)] = append(podsPdbs[getPodID(pod)], pdb.Namespace+"/"+pdb.Name)
+			}
+		}
+	}
+	return podsPdbs, pdbBudget
 }
 
 func getPodReplicaCreator(pod *apiv1.Pod) (*podReplicaCreator, error) {
@@ -19,12 +19,14 @@ package eviction
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	policyv1 "k8s.io/api/policy/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/autoscaler/vertical-pod-autoscaler/test"
@@ -54,7 +56,7 @@ func TestEvictReplicatedByController(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &rc)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, 0.5).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.True(t, eviction.CanEvict(pod))
@@ -90,7 +92,7 @@ func TestEvictReplicatedByReplicaSet(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &rs)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(nil, &rs, nil, nil, pods), 2, 0.5).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(nil, &rs, nil, nil, pods), 2, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.True(t, eviction.CanEvict(pod))
@@ -126,7 +128,7 @@ func TestEvictReplicatedByStatefulSet(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &ss)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(nil, nil, &ss, nil, pods), 2, 0.5).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(nil, nil, &ss, nil, pods), 2, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.True(t, eviction.CanEvict(pod))
@@ -158,7 +160,7 @@ func TestEvictReplicatedByJob(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &job)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(nil, nil, nil, &job, pods), 2, 0.5).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(nil, nil, nil, &job, pods), 2, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.True(t, eviction.CanEvict(pod))
@@ -194,7 +196,7 @@ func TestEvictTooFewReplicas(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &rc)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 10, 0.5).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 10, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.False(t, eviction.CanEvict(pod))
@@ -227,7 +229,7 @@ func TestEvictionTolerance(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &rc)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, tolerance).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, tolerance, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.True(t, eviction.CanEvict(pod))
@@ -264,7 +266,7 @@ func TestEvictAtLeastOne(t *testing.T) {
 		pods[i] = test.BuildTestPod("test"+string(i), "", "", "", &rc)
 	}
 
-	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, tolerance).NewPodsEvictionRestriction(pods)
+	eviction := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, tolerance, 0, 0).NewPodsEvictionRestriction(pods)
 
 	for _, pod := range pods {
 		assert.True(t, eviction.CanEvict(pod))
@@ -280,6 +282,247 @@ func TestEvictAtLeastOne(t *testing.T) {
 	}
 }
 
+func TestEvictPodDisruptionBudgetExceeded(t *testing.T) {
+	replicas := int32(5)
+	livePods := 5
+
+	rc := apiv1.ReplicationController{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rc",
+			Namespace: "default",
+			SelfLink:  testapi.Default.SelfLink("replicationcontrollers", "rc"),
+		},
+		Spec: apiv1.ReplicationControllerSpec{
+			Replicas: &replicas,
+		},
+	}
+
+	pods := make([]*apiv1.Pod, livePods)
+	for i := range pods {
+		pods[i] = test.BuildTestPod(fmt.Sprintf("test%d", i), "", "", "", &rc)
+		pods[i].Labels = map[string]string{"app": "web"}
+	}
+
+	client := fakeClient(&rc, nil, nil, nil, pods)
+	addPdbs(client, buildTestPdb("web", map[string]string{"app": "web"}, 0), buildTestPdb("other", map[string]string{"app": "other"}, 5))
+	eviction := NewPodsEvictionRestrictionFactory(client, 2, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
+
+	for _, pod := range pods {
+		assert.False(t, eviction.CanEvict(pod))
+		err := eviction.Evict(pod)
+		assert.Error(t, err, "Error expected")
+	}
+	assert.Equal(t, 0, countEvictions(client))
+}
+
+func TestEvictPodDisruptionBudgetHeadroom(t *testing.T) {
+	replicas := int32(5)
+	livePods := 5
+
+	rc := apiv1.ReplicationController{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rc",
+			Namespace: "default",
+			SelfLink:  testapi.Default.SelfLink("replicationcontrollers", "rc"),
+		},
+		Spec: apiv1.ReplicationControllerSpec{
+			Replicas: &replicas,
+		},
+	}
+
+	pods := make([]*apiv1.Pod, livePods)
+	for i := range pods {
+		pods[i] = test.BuildTestPod(fmt.Sprintf("test%d", i), "", "", "", &rc)
+		pods[i].Labels = map[string]string{"app": "web"}
+	}
+
+	client := fakeClient(&rc, nil, nil, nil, pods)
+	addPdbs(client, buildTestPdb("web", map[string]string{"app": "web"}, 1))
+	eviction := NewPodsEvictionRestrictionFactory(client, 2, 0.5, 0, 0).NewPodsEvictionRestriction(pods)
+
+	assert.True(t, eviction.CanEvict(pods[0]))
+	assert.Nil(t, eviction.Evict(pods[0]), "Should evict with no error")
+	for _, pod := range pods[1:] {
+		assert.False(t, eviction.CanEvict(pod))
+		assert.Error(t, eviction.Evict(pod), "Error expected")
+	}
+	assert.Equal(t, 1, countEvictions(client))
+}
+
+func TestEvictionPacing(t *testing.T) {
+	replicas := int32(20)
+	livePods := 20
+
+	rs := extensions.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rs",
+			Namespace: "default",
+			SelfLink:  testapi.Default.SelfLink("replicasets", "rs"),
+		},
+		Spec: extensions.ReplicaSetSpec{
+			Replicas: &replicas,
+		},
+	}
+
+	pods := make([]*apiv1.Pod, livePods)
+	for i := range pods {
+		pods[i] = test.BuildTestPod(fmt.Sprintf("test%d", i), "", "", "", &rs)
+	}
+
+	now := time.Now()
+	factory := NewPodsEvictionRestrictionFactory(fakeClient(nil, &rs, nil, nil, pods), 2, 0.5, time.Minute, 0)
+	factory.(*podsEvictionRestrictionFactoryImpl).pacing.now = func() time.Time { return now }
+
+	// Only one pod can be evicted per minute, even though the tolerance allows evicting 10.
+	for step := 0; step < 3; step++ {
+		live := pods[step:]
+		eviction := factory.NewPodsEvictionRestriction(live)
+		assert.True(t, eviction.CanEvict(live[0]), "step %d", step)
+		assert.Nil(t, eviction.Evict(live[0]), "step %d", step)
+		for _, pod := range live[1:] {
+			assert.False(t, eviction.CanEvict(pod), "step %d", step)
+		}
+
+		now = now.Add(30 * time.Second)
+		eviction = factory.NewPodsEvictionRestriction(live[1:])
+		assert.False(t, eviction.CanEvict(live[1]), "step %d", step)
+
+		now = now.Add(30 * time.Second)
+	}
+}
+
+func TestEvictionMaxInFlight(t *testing.T) {
+	replicas := int32(5)
+	livePods := 5
+
+	rc := apiv1.ReplicationController{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rc",
+			Namespace: "default",
+			SelfLink:  testapi.Default.SelfLink("replicationcontrollers", "rc"),
+		},
+		Spec: apiv1.ReplicationControllerSpec{
+			Replicas: &replicas,
+		},
+	}
+	rs := extensions.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rs",
+			Namespace: "default",
+			SelfLink:  testapi.Default.SelfLink("replicasets", "rs"),
+		},
+		Spec: extensions.ReplicaSetSpec{
+			Replicas: &replicas,
+		},
+	}
+
+	rcPods := make([]*apiv1.Pod, livePods)
+	rsPods := make([]*apiv1.Pod, livePods)
+	for i := 0; i < livePods; i++ {
+		rcPods[i] = test.BuildTestPod(fmt.Sprintf("rc%d", i), "", "", "", &rc)
+		rsPods[i] = test.BuildTestPod(fmt.Sprintf("rs%d", i), "", "", "", &rs)
+	}
+
+	factory := NewPodsEvictionRestrictionFactory(fakeClient(&rc, &rs, nil, nil, nil), 2, 0.5, 0, 1)
+	eviction := factory.NewPodsEvictionRestriction(rcPods)
+	assert.Nil(t, eviction.Evict(rcPods[0]), "Should evict with no error")
+	assert.False(t, eviction.CanEvict(rcPods[1]))
+
+	eviction = factory.NewPodsEvictionRestriction(rsPods)
+	assert.False(t, eviction.CanEvict(rsPods[0]))
+	assert.Error(t, eviction.Evict(rsPods[0]), "Error expected")
+
+	// The evicted pod was replaced.
+	factory.NewPodsEvictionRestriction(rcPods)
+	eviction = factory.NewPodsEvictionRestriction(rsPods)
+	assert.True(t, eviction.CanEvict(rsPods[0]))
+}
+
+func TestEvictionPacingForgetsEvictions(t *testing.T) {
+	replicas := int32(5)
+	livePods := 5
+
+	rc := apiv1.ReplicationController{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rc",
+			Namespace: "default",
+			SelfLink:  testapi.Default.SelfLink("replicationcontrollers", "rc"),
+		},
+		Spec: apiv1.ReplicationControllerSpec{
+			Replicas: &replicas,
+		},
+	}
+
+	pods := make([]*apiv1.Pod, livePods)
+	for i := range pods {
+		pods[i] = test.BuildTestPod(fmt.Sprintf("test%d", i), "", "", "", &rc)
+	}
+
+	now := time.Now()
+	factory := NewPodsEvictionRestrictionFactory(fakeClient(&rc, nil, nil, nil, pods), 2, 0.5, time.Minute, 1)
+	pacing := factory.(*podsEvictionRestrictionFactoryImpl).pacing
+	pacing.now = func() time.Time { return now }
+
+	eviction := factory.NewPodsEvictionRestriction(pods)
+	assert.Nil(t, eviction.Evict(pods[0]), "Should evict with no error")
+	factory.ForgetUnseenCreators()
+
+	// The evicted pod is never replaced, it stops counting as in flight after a while.
+	now = now.Add(inFlightEvictionTimeout - time.Second)
+	eviction = factory.NewPodsEvictionRestriction(pods[1:])
+	assert.False(t, eviction.CanEvict(pods[1]))
+	assert.Empty(t, pacing.lastEviction)
+	factory.ForgetUnseenCreators()
+
+	now = now.Add(time.Second)
+	eviction = factory.NewPodsEvictionRestriction(pods[1:])
+	assert.True(t, eviction.CanEvict(pods[1]))
+	assert.Nil(t, eviction.Evict(pods[1]), "Should evict with no error")
+	factory.ForgetUnseenCreators()
+	assert.Equal(t, 1, len(pacing.inFlight))
+	assert.Equal(t, 1, len(pacing.lastEviction))
+
+	// The replication controller was deleted.
+	factory.ForgetUnseenCreators()
+	assert.Empty(t, pacing.inFlight)
+	assert.Empty(t, pacing.lastEviction)
+}
+
+func buildTestPdb(name string, selector map[string]string, disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{
+			PodDisruptionsAllowed: disruptionsAllowed,
+		},
+	}
+}
+
+func addPdbs(client kube_client.Interface, pdbs ...*policyv1.PodDisruptionBudget) {
+	list := &policyv1.PodDisruptionBudgetList{}
+	for _, pdb := range pdbs {
+		list.Items = append(list.Items, *pdb)
+	}
+	client.(*fake.Clientset).Fake.AddReactor("list", "poddisruptionbudgets", func(action core.Action) (bool, runtime.Object, error) {
+		return true, list, nil
+	})
+}
+
+func countEvictions(client kube_client.Interface) int {
+	count := 0
+	for _, action := range client.(*fake.Clientset).Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "eviction" {
+			count++
+		}
+	}
+	return count
+}
+
 func fakeClient(rc *apiv1.ReplicationController, rs *extensions.ReplicaSet, ss *appsv1beta1.StatefulSet, job *batchv1.Job, pods []*apiv1.Pod) kube_client.Interface {
 	fakeClient := &fake.Clientset{}
 	register := func(resource string, obj runtime.Object, meta metav1.ObjectMeta) {
@@ -37,6 +37,12 @@ var (
 
 	evictionToleranceFraction = flag.Float64("eviction-tolerance", 0.5,
 		`Fraction of replica count that can be evicted for update, if more than one pod can be evicted.`)
+
+	minSecondsBetweenEvictionsPerController = flag.Int("updater-min-seconds-between-evictions-per-controller", 0,
+		`Minimum number of seconds between evictions of pods from the same controller`)
+
+	maxInFlightEvictions = flag.Int("max-in-flight-evictions", 0,
+		`Maximum number of evicted pods waiting for replacement across all controllers. 0 means no limit.`)
 )
 
 func main() {
@@ -46,7 +52,8 @@ func main() {
 	// TODO monitoring
 
 	kubeClient := createKubeClient()
-	updater := NewUpdater(kubeClient, *recommendationsCacheTtl, *minReplicas, *evictionToleranceFraction)
+	updater := NewUpdater(kubeClient, *recommendationsCacheTtl, *minReplicas, *evictionToleranceFraction,
+		time.Duration(*minSecondsBetweenEvictionsPerController)*time.Second, *maxInFlightEvictions)
 	for {
 		select {
 		case <-time.After(*updaterInterval):
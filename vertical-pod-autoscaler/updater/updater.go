@@ -48,12 +48,13 @@ type updater struct {
 }
 
 // NewUpdater creates Updater with given configuration
-func NewUpdater(kubeClient kube_client.Interface, cacheTTl time.Duration, minReplicasForEvicition int, evictionToleranceFraction float64) Updater {
+func NewUpdater(kubeClient kube_client.Interface, cacheTTl time.Duration, minReplicasForEvicition int, evictionToleranceFraction float64,
+	minEvictionInterval time.Duration, maxInFlightEvictions int) Updater {
 	return &updater{
 		vpaLister:        newVpaLister(kubeClient),
 		podLister:        newPodLister(kubeClient),
 		recommender:      recommender.NewCachingRecommender(cacheTTl, apimock.NewRecommenderAPI()),
-		evictionFactrory: eviction.NewPodsEvictionRestrictionFactory(kubeClient, minReplicasForEvicition, evictionToleranceFraction),
+		evictionFactrory: eviction.NewPodsEvictionRestrictionFactory(kubeClient, minReplicasForEvicition, evictionToleranceFraction, minEvictionInterval, maxInFlightEvictions),
 	}
 }
 
@@ -64,6 +65,8 @@ func (u *updater) RunOnce() {
 		glog.Fatalf("failed get VPA list: %v", err)
 	}
 
+	defer u.evictionFactrory.ForgetUnseenCreators()
+
 	if len(vpaList) == 0 {
 		glog.Warningf("no VPA objects to process")
 		return
@@ -101,3 +101,6 @@ type fakeEvictFactory struct {
 func (f fakeEvictFactory) NewPodsEvictionRestriction(pods []*apiv1.Pod) eviction.PodsEvictionRestriction {
 	return f.evict
 }
+
+func (f fakeEvictFactory) ForgetUnseenCreators() {
+}